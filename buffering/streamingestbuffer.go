@@ -2,6 +2,7 @@ package buffering
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nbigot/ministream/types"
@@ -12,42 +13,143 @@ type IStreamWriter interface {
 	Open() error
 	Close() error
 	Write(record *[]types.DeferedStreamRecord) error
+	Sync() error // flush the records written to the storage (fsync)
+	Trim(policy *types.RetentionPolicy, now time.Time) (types.Size64, error)
+	Reencrypt() (types.Size64, error)         // rewrite the records stored with a previous encryption key
+	Scrub() (*types.StreamScrubReport, error) // verify the records stored
 }
 
 type StreamIngestBuffer struct {
 	// variables used for defered save
 	bulkFlushFrequency   time.Duration // RecordMaxBufferedTime
 	bulkMaxSize          int
+	bulkMaxBytes         uint64 // flush the buffer when the buffered records exceed this size (0 means unlimited)
+	bufferedBytes        uint64
 	channelMsg           chan types.DeferedStreamRecord
 	msgBuffer            []types.DeferedStreamRecord
 	bufferedStateUpdates types.Size64
 	mu                   sync.Mutex
 	writer               IStreamWriter
+	notifier             *StreamNotifier
+	// variables used by the producers waiting for their records to be persisted (durability)
+	flushRequests   chan struct{}
+	syncRequested   atomic.Bool
+	lastSavedMsgId  types.MessageId    // last record written by the storage provider
+	lastSyncedMsgId types.MessageId    // last record written and synced (fsync) by the storage provider
+	saveErr         error              // error of the last save (nil if succeeded)
+	discardedMsgIds [2]types.MessageId // first and last records of the last records removed without being saved
+	discardErr      error              // reason why these records were removed
+	muPersisted     sync.Mutex
+	persisted       *StreamNotifier
+	// variables used by the producers waiting for room in the channel (backpressure)
+	cptRoomWaiters atomic.Int32
+	room           *StreamNotifier
 }
 
-func NewStreamIngestBuffer(bulkFlushFrequency time.Duration, bulkMaxSize int, channelBufferSize int, writer IStreamWriter) *StreamIngestBuffer {
+func NewStreamIngestBuffer(bulkFlushFrequency time.Duration, bulkMaxSize int, bulkMaxBytes uint64, channelBufferSize int, writer IStreamWriter) *StreamIngestBuffer {
 	return &StreamIngestBuffer{
 		bulkFlushFrequency:   bulkFlushFrequency,
 		bulkMaxSize:          bulkMaxSize,
+		bulkMaxBytes:         bulkMaxBytes,
 		msgBuffer:            make([]types.DeferedStreamRecord, 0, bulkMaxSize),
 		bufferedStateUpdates: 0,
 		channelMsg:           make(chan types.DeferedStreamRecord, channelBufferSize),
 		writer:               writer,
+		notifier:             NewStreamNotifier(),
+		flushRequests:        make(chan struct{}, 1),
+		persisted:            NewStreamNotifier(),
+		room:                 NewStreamNotifier(),
 	}
 }
 
-func (s *StreamIngestBuffer) PutMessage(msgId types.MessageId, creationDate time.Time, message interface{}) {
-	s.channelMsg <- types.DeferedStreamRecord{Id: msgId, CreationDate: creationDate, Msg: message}
+func (s *StreamIngestBuffer) PutMessage(msgId types.MessageId, creationDate time.Time, message interface{}, headers types.RecordHeaders, sizeInBytes uint64) {
+	s.channelMsg <- types.DeferedStreamRecord{Id: msgId, CreationDate: creationDate, Msg: message, Headers: headers, SizeInBytes: sizeInBytes}
+}
+
+func (s *StreamIngestBuffer) GetQueueDepth() int {
+	// number of records put and waiting to be buffered
+	return len(s.channelMsg)
+}
+
+func (s *StreamIngestBuffer) GetQueueCapacity() int {
+	return cap(s.channelMsg)
+}
+
+func (s *StreamIngestBuffer) WaitForRoom(count int, maxWait time.Duration, done <-chan struct{}) int {
+	// Wait until there is room for count records (or maxWait is elapsed, or done is closed),
+	// returns the number of records that can be put without blocking.
+	room := cap(s.channelMsg) - len(s.channelMsg)
+	if room >= count || maxWait <= 0 {
+		return room
+	}
+
+	// the stream wakes up the waiters each time it takes a record from the channel (see AppendMesssage),
+	// the notification channel is taken before the room is checked therefore no wake up is missed
+	s.cptRoomWaiters.Add(1)
+	defer s.cptRoomWaiters.Add(-1)
+	timeout := time.NewTimer(maxWait)
+	defer timeout.Stop()
+	for {
+		roomChanged := s.room.Wait()
+		if room = cap(s.channelMsg) - len(s.channelMsg); room >= count {
+			return room
+		}
+		select {
+		case <-done:
+			return cap(s.channelMsg) - len(s.channelMsg)
+		case <-timeout.C:
+			return cap(s.channelMsg) - len(s.channelMsg)
+		case <-roomChanged:
+		}
+	}
 }
 
 func (s *StreamIngestBuffer) AppendMesssage(message types.DeferedStreamRecord) {
+	// the message has been taken from the channel
 	s.mu.Lock()
 	s.msgBuffer = append(s.msgBuffer, message)
+	s.bufferedBytes += message.SizeInBytes
 	s.mu.Unlock()
+
+	if s.cptRoomWaiters.Load() > 0 {
+		// wake up the producers waiting for room in the channel
+		s.room.Notify()
+	}
 }
 
 func (s *StreamIngestBuffer) IsFull() bool {
-	return len(s.msgBuffer) >= s.bulkMaxSize
+	return len(s.msgBuffer) >= s.bulkMaxSize || (s.bulkMaxBytes > 0 && s.bufferedBytes >= s.bulkMaxBytes)
+}
+
+func (s *StreamIngestBuffer) RequestFlush(sync bool) {
+	// ask the stream to save the buffer as soon as possible (and to sync the storage if sync is true)
+	if sync {
+		s.syncRequested.Store(true)
+	}
+	select {
+	case s.flushRequests <- struct{}{}:
+	default:
+		// a flush is already requested
+	}
+}
+
+func (s *StreamIngestBuffer) GetFlushRequests() <-chan struct{} {
+	return s.flushRequests
+}
+
+func (s *StreamIngestBuffer) GetPersistedMsgId(synced bool) (types.MessageId, error) {
+	// returns the last record persisted (written, or written and synced) and the error of the last save
+	s.muPersisted.Lock()
+	defer s.muPersisted.Unlock()
+	if synced {
+		return s.lastSyncedMsgId, s.saveErr
+	}
+	return s.lastSavedMsgId, s.saveErr
+}
+
+func (s *StreamIngestBuffer) GetPersistedNotifier() *StreamNotifier {
+	// notified after each save, whether it succeeded or not
+	return s.persisted
 }
 
 func (s *StreamIngestBuffer) Lock() {
@@ -60,6 +162,7 @@ func (s *StreamIngestBuffer) Unlock() {
 
 func (s *StreamIngestBuffer) Clear() {
 	s.msgBuffer = nil
+	s.bufferedBytes = 0
 }
 
 func (s *StreamIngestBuffer) GetBuffer() *[]types.DeferedStreamRecord {
@@ -74,17 +177,101 @@ func (s *StreamIngestBuffer) GetChannelMsg() chan types.DeferedStreamRecord {
 	return s.channelMsg
 }
 
+func (s *StreamIngestBuffer) GetNotifier() *StreamNotifier {
+	return s.notifier
+}
+
 func (s *StreamIngestBuffer) Save() error {
 	s.Lock()
 	defer s.Unlock()
 
+	sync := s.syncRequested.Swap(false)
+	cptMessages := len(s.msgBuffer)
 	if err := s.writer.Write(&s.msgBuffer); err != nil {
+		if sync {
+			s.syncRequested.Store(true)
+		}
+		s.setPersisted(0, false, err)
 		return err
 	}
+
+	var lastMsgId types.MessageId
+	if cptMessages > 0 {
+		lastMsgId = s.msgBuffer[cptMessages-1].Id
+	}
 	s.Clear()
+	if cptMessages > 0 {
+		// new records are readable, wake up the iterators waiting for them (long polling)
+		s.notifier.Notify()
+	}
+
+	if sync {
+		if err := s.writer.Sync(); err != nil {
+			s.syncRequested.Store(true)
+			s.setPersisted(lastMsgId, false, err)
+			return err
+		}
+	}
+	s.setPersisted(lastMsgId, sync, nil)
 	return nil
 }
 
+func (s *StreamIngestBuffer) setPersisted(lastMsgId types.MessageId, synced bool, err error) {
+	// wake up the producers waiting for their records to be persisted
+	s.muPersisted.Lock()
+	if lastMsgId != 0 {
+		s.lastSavedMsgId = lastMsgId
+	}
+	if synced {
+		s.lastSyncedMsgId = s.lastSavedMsgId
+	}
+	s.saveErr = err
+	s.muPersisted.Unlock()
+	s.persisted.Notify()
+}
+
+func (s *StreamIngestBuffer) Discard(reason error) []types.DeferedStreamRecord {
+	// remove the records waiting to be saved, returns the records removed,
+	// the producers waiting for them to be persisted are told the reason
+	s.Lock()
+	defer s.Unlock()
+	records := s.msgBuffer
+	s.Clear()
+	if len(records) > 0 {
+		s.muPersisted.Lock()
+		s.discardedMsgIds = [2]types.MessageId{records[0].Id, records[len(records)-1].Id}
+		s.discardErr = reason
+		s.muPersisted.Unlock()
+		s.persisted.Notify()
+	}
+	return records
+}
+
+func (s *StreamIngestBuffer) GetDiscarded() (types.MessageId, types.MessageId, error) {
+	// returns the first and last records of the last records removed without being saved, and the reason why
+	s.muPersisted.Lock()
+	defer s.muPersisted.Unlock()
+	return s.discardedMsgIds[0], s.discardedMsgIds[1], s.discardErr
+}
+
+func (s *StreamIngestBuffer) Trim(policy *types.RetentionPolicy, now time.Time) (types.Size64, error) {
+	// remove the records that are out of the retention policy, returns the number of records removed
+	s.Lock()
+	defer s.Unlock()
+	return s.writer.Trim(policy, now)
+}
+
+func (s *StreamIngestBuffer) Reencrypt() (types.Size64, error) {
+	// rewrite the records stored with a previous encryption key, returns the number of records rewritten
+	// (the writer is locked a part of the records at a time, the records are still written meanwhile)
+	return s.writer.Reencrypt()
+}
+
+func (s *StreamIngestBuffer) Scrub() (*types.StreamScrubReport, error) {
+	// verify the records stored (the records are still written meanwhile)
+	return s.writer.Scrub()
+}
+
 func (s *StreamIngestBuffer) Close() error {
 	s.Lock()
 	defer s.Unlock()
@@ -0,0 +1,47 @@
+package buffering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+)
+
+func TestWaitForRoom(t *testing.T) {
+	b := NewStreamIngestBuffer(time.Minute, 100, 0, 2, nil)
+	b.PutMessage(1, time.Now(), map[string]interface{}{"n": 1}, nil, 8)
+	b.PutMessage(2, time.Now(), map[string]interface{}{"n": 2}, nil, 8)
+
+	// no room and nothing taken from the channel: the wait ends with the timeout or when done is closed
+	if room := b.WaitForRoom(1, 20*time.Millisecond, nil); room != 0 {
+		t.Fatalf("expected no room, got %d", room)
+	}
+	done := make(chan struct{})
+	close(done)
+	if room := b.WaitForRoom(1, time.Minute, done); room != 0 {
+		t.Fatalf("expected no room, got %d", room)
+	}
+
+	// the producer is woken up as soon as the stream takes a record from the channel
+	rooms := make(chan int)
+	go func() {
+		rooms <- b.WaitForRoom(1, time.Minute, nil)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	startTime := time.Now()
+	b.AppendMesssage(<-b.GetChannelMsg())
+	select {
+	case room := <-rooms:
+		if room != 1 {
+			t.Fatalf("expected room for 1 record, got %d", room)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the producer has not been woken up")
+	}
+	if elapsed := time.Since(startTime); elapsed > time.Second {
+		t.Fatalf("the producer has been woken up late (%s)", elapsed)
+	}
+	if records := b.GetBuffer(); len(*records) != 1 || (*records)[0].Id != types.MessageId(1) {
+		t.Fatalf("unexpected buffered records %+v", *records)
+	}
+}
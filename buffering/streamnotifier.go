@@ -0,0 +1,41 @@
+package buffering
+
+import "sync"
+
+// StreamNotifier wakes up every goroutine waiting for new readable records.
+// Each call to Notify closes the current channel (broadcast) and replaces it by a new one.
+type StreamNotifier struct {
+	mu     sync.Mutex
+	ch     chan struct{}
+	parent *StreamNotifier // also notified (partition of a partitioned stream)
+}
+
+func NewStreamNotifier() *StreamNotifier {
+	return &StreamNotifier{ch: make(chan struct{})}
+}
+
+func (n *StreamNotifier) Wait() <-chan struct{} {
+	// returns a channel closed on the next notification
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ch
+}
+
+func (n *StreamNotifier) Notify() {
+	n.mu.Lock()
+	close(n.ch)
+	n.ch = make(chan struct{})
+	parent := n.parent
+	n.mu.Unlock()
+
+	if parent != nil {
+		parent.Notify()
+	}
+}
+
+func (n *StreamNotifier) SetParent(parent *StreamNotifier) {
+	// every notification is forwarded to the parent notifier
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.parent = parent
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"flag"
@@ -9,13 +10,16 @@ import (
 
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/google/uuid"
 	"github.com/nbigot/ministream/account"
 	"github.com/nbigot/ministream/auth"
 	"github.com/nbigot/ministream/config"
 	"github.com/nbigot/ministream/log"
 	"github.com/nbigot/ministream/rbac"
 	"github.com/nbigot/ministream/service"
+	"github.com/nbigot/ministream/storageprovider/jsonfileprovider"
 	"github.com/nbigot/ministream/storageprovider/registry"
+	"github.com/nbigot/ministream/types"
 	"github.com/nbigot/ministream/web"
 	"github.com/nbigot/ministream/web/webserver"
 	"go.uber.org/zap"
@@ -41,6 +45,181 @@ func argparse() string {
 	return *configFilePath
 }
 
+func RunFsck(args []string) int {
+	// Check (and repair) the streams of a JSONFile storage, the server must not be running.
+	// Exit code: 0 when no inconsistency remains, 1 otherwise.
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	configFilePath := flags.String("config", "config.yaml", "Filepath to config.yaml")
+	repair := flags.Bool("repair", false, "Repair the inconsistencies found (truncate torn records, rebuild index rows, recompute stream meta info)")
+	_ = flags.Parse(args)
+
+	appConfig, err := config.LoadConfig(*configFilePath)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if appConfig.Storage.Type != "JSONFile" {
+		fmt.Printf("fsck: storage type %s is not supported (JSONFile only)\n", appConfig.Storage.Type)
+		return 1
+	}
+	sp, err := jsonfileprovider.NewStorageProvider(log.Logger, appConfig)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	reports, err := sp.(*jsonfileprovider.FileStorage).Fsck(*repair)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	cptIssues, cptUnrepaired := 0, 0
+	for _, report := range reports {
+		if len(report.Issues) == 0 {
+			fmt.Printf("stream %s: ok\n", report.StreamUUID)
+			continue
+		}
+		for _, issue := range report.Issues {
+			status := "not repaired"
+			if issue.Repair != "" {
+				status = "repaired: " + issue.Repair
+			}
+			fmt.Printf("stream %s: %s: %s (%s)\n", report.StreamUUID, issue.File, issue.Problem, status)
+		}
+		cptIssues += len(report.Issues)
+		cptUnrepaired += report.CountUnrepaired()
+	}
+	fmt.Printf("%d streams checked, %d inconsistencies found, %d repaired\n", len(reports), cptIssues, cptIssues-cptUnrepaired)
+	if cptUnrepaired > 0 {
+		return 1
+	}
+	return 0
+}
+
+func newStandaloneService(configFilePath string) (*service.Service, error) {
+	// Load the streams of the storage without starting the web server, the server must not be running.
+	// Stop the service and finalize the registry once done.
+	appConfig, err := config.LoadConfig(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if err = registry.Initialize(); err != nil {
+		return nil, err
+	}
+	svc, err := service.NewStreamService(log.Logger, appConfig)
+	if err == nil {
+		err = svc.Init()
+	}
+	if err == nil {
+		_, err = svc.LoadStreams()
+	}
+	if err != nil {
+		registry.Finalize()
+		return nil, err
+	}
+	return svc, nil
+}
+
+func RunExport(args []string) int {
+	// Export a stream as JSONL (see service.ExportStream) into a file, "-" is the standard output.
+	// Exit code: 0 when the stream is exported, 1 otherwise.
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configFilePath := flags.String("config", "config.yaml", "Filepath to config.yaml")
+	streamUUID := flags.String("stream", "", "UUID of the stream to export")
+	outputFilePath := flags.String("output", "", "Filepath of the export (\"-\" is the standard output)")
+	_ = flags.Parse(args)
+
+	streamId, err := uuid.Parse(*streamUUID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: invalid stream uuid %q: %s\n", *streamUUID, err)
+		return 1
+	}
+	if *outputFilePath == "" {
+		fmt.Fprintln(os.Stderr, "export: the output filepath is required")
+		return 1
+	}
+
+	svc, err := newStandaloneService(*configFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer registry.Finalize()
+	defer svc.Stop()
+	streamPtr := svc.GetStream(streamId)
+	if streamPtr == nil {
+		fmt.Fprintf(os.Stderr, "export: stream %s not found\n", streamId)
+		return 1
+	}
+
+	output := os.Stdout
+	if *outputFilePath != "-" {
+		if output, err = os.Create(*outputFilePath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	writer := bufio.NewWriter(output)
+	cptRecords, err := svc.ExportStream(context.Background(), streamPtr, writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if output != os.Stdout {
+		if errClose := output.Close(); err == nil {
+			err = errClose
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "stream %s: %d records exported\n", streamId, cptRecords)
+	return 0
+}
+
+func RunImport(args []string) int {
+	// Create a stream from an export (see service.ImportStream), "-" is the standard input.
+	// Exit code: 0 when the stream is imported, 1 otherwise (the stream is not created).
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	configFilePath := flags.String("config", "config.yaml", "Filepath to config.yaml")
+	inputFilePath := flags.String("input", "", "Filepath of the export (\"-\" is the standard input)")
+	preserveIds := flags.Bool("preserve-ids", false, "Keep the message ids and the creation dates of the records")
+	keepUUID := flags.Bool("keep-uuid", false, "Create the stream with the UUID of the exported stream")
+	_ = flags.Parse(args)
+
+	if *inputFilePath == "" {
+		fmt.Fprintln(os.Stderr, "import: the input filepath is required")
+		return 1
+	}
+	input := os.Stdin
+	if *inputFilePath != "-" {
+		var err error
+		if input, err = os.Open(*inputFilePath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer func() {
+			_ = input.Close()
+		}()
+	}
+
+	svc, err := newStandaloneService(*configFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer registry.Finalize()
+	defer svc.Stop()
+	options := types.StreamImportOptions{PreserveIds: *preserveIds, KeepUUID: *keepUUID}
+	response, err := svc.ImportStream(context.Background(), input, options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("stream %s: %d records imported from stream %s\n", response.StreamUUID, response.CountRecords, response.SourceStreamUUID)
+	return 0
+}
+
 func WithFiberLogger() webserver.ServerOption {
 	return func(s *webserver.Server) {
 		if s.GetWebConfig().Logs.Enable {
@@ -141,6 +320,16 @@ func RunServer(appConfig *config.Config) (bool, error) {
 // @BasePath /
 func main() {
 	// 127.0.0.1:443
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fsck":
+			os.Exit(RunFsck(os.Args[2:]))
+		case "export":
+			os.Exit(RunExport(os.Args[2:]))
+		case "import":
+			os.Exit(RunImport(os.Args[2:]))
+		}
+	}
 	configFilePath := argparse()
 
 	// start/restart the server forever (reason is reload config) unless an error occurs
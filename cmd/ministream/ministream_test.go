@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nbigot/ministream/service"
+	"github.com/nbigot/ministream/storageprovider/registry"
+	"github.com/nbigot/ministream/types"
+)
+
+func writeTestConfig(t *testing.T) string {
+	// JSONFile storage in a temporary directory, the logs are discarded
+	t.Helper()
+	dir := t.TempDir()
+	configFilePath := filepath.Join(dir, "config.yaml")
+	conf := fmt.Sprintf(`
+streams:
+    bulkFlushFrequency: 1
+    bulkMaxSize: 100
+    channelBufferSize: 100
+    durability:
+        timeout: 5
+storage:
+    type: "JSONFile"
+    logger:
+        level: "error"
+        encoding: "json"
+    jsonfile:
+        dataDirectory: %q
+logger:
+    level: "error"
+    encoding: "json"
+`, filepath.Join(dir, "storage"))
+	if err := os.Mkdir(filepath.Join(dir, "storage"), 0755); err != nil {
+		t.Fatalf("error while creating data directory: %v", err)
+	}
+	if err := os.WriteFile(configFilePath, []byte(conf), 0644); err != nil {
+		t.Fatalf("error while writing configuration: %v", err)
+	}
+	return configFilePath
+}
+
+func withTestService(t *testing.T, configFilePath string, f func(svc *service.Service)) {
+	t.Helper()
+	svc, err := newStandaloneService(configFilePath)
+	if err != nil {
+		t.Fatalf("error while loading service: %v", err)
+	}
+	defer registry.Finalize()
+	defer svc.Stop()
+	f(svc)
+}
+
+func TestExportImport(t *testing.T) {
+	configFilePath := writeTestConfig(t)
+	exportFilePath := filepath.Join(t.TempDir(), "export.jsonl")
+
+	var sourceUUID types.StreamUUID
+	withTestService(t, configFilePath, func(svc *service.Service) {
+		s, err := svc.CreateStream(&types.StreamProperties{"team": "a"})
+		if err != nil {
+			t.Fatalf("error while creating stream: %v", err)
+		}
+		msgIds, _, err := s.PutMessages(nil, []interface{}{map[string]interface{}{"n": 1}, map[string]interface{}{"n": 2}}, nil)
+		if err != nil {
+			t.Fatalf("error while putting records: %v", err)
+		}
+		if err = s.WaitForDurability(context.Background(), types.DurabilityFlushed, msgIds); err != nil {
+			t.Fatalf("error while saving records: %v", err)
+		}
+		sourceUUID = s.GetUUID()
+	})
+
+	if code := RunExport([]string{"-config", configFilePath, "-stream", sourceUUID.String(), "-output", exportFilePath}); code != 0 {
+		t.Fatalf("export failed with exit code %d", code)
+	}
+	if code := RunExport([]string{"-config", configFilePath, "-stream", types.StreamUUID{}.String(), "-output", exportFilePath + ".2"}); code != 1 {
+		t.Fatalf("expected the export of an unknown stream to fail, got exit code %d", code)
+	}
+
+	// the stream already exists: its uuid can't be kept
+	if code := RunImport([]string{"-config", configFilePath, "-input", exportFilePath, "-keep-uuid"}); code != 1 {
+		t.Fatalf("expected the import to fail, got exit code %d", code)
+	}
+	if code := RunImport([]string{"-config", configFilePath, "-input", exportFilePath, "-preserve-ids"}); code != 0 {
+		t.Fatalf("import failed with exit code %d", code)
+	}
+
+	withTestService(t, configFilePath, func(svc *service.Service) {
+		streamUUIDs := svc.GetStreamsUUIDs()
+		if len(streamUUIDs) != 2 {
+			t.Fatalf("expected 2 streams, got %v", streamUUIDs)
+		}
+		for _, streamUUID := range streamUUIDs {
+			s := svc.GetStream(streamUUID)
+			if (*s.GetProperties())["team"] != "a" {
+				t.Fatalf("stream %s: unexpected properties %v", streamUUID, *s.GetProperties())
+			}
+			records, apiErr := svc.GetRecordsByIds(s, nil, []types.MessageId{1, 2, 3})
+			if apiErr != nil {
+				t.Fatalf("error while getting records: %v", apiErr)
+			}
+			for i, record := range records[:2] {
+				if record == nil || fmt.Sprint(record.(map[string]interface{})["m"].(map[string]interface{})["n"]) != fmt.Sprint(i+1) {
+					t.Fatalf("stream %s: unexpected record %d: %v", streamUUID, i+1, record)
+				}
+			}
+			if records[2] != nil {
+				t.Fatalf("stream %s: unexpected record 3: %v", streamUUID, records[2])
+			}
+		}
+	})
+}
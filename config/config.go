@@ -103,6 +103,27 @@ type Config struct {
 		LogVerbosity int        `yaml:"logVerbosity"`
 		JSONFile     struct {
 			DataDirectory string `yaml:"dataDirectory"`
+			Segments      struct {
+				// the records of a stream are written into segments (data and index files), a new segment is started
+				// when the active segment reaches the max size or age, the retention policy deletes whole segments
+				MaxSize string `yaml:"maxSize" example:"256mb"` // size of the data file of a segment, ex: "256mb" (0 or empty means unlimited)
+				MaxAge  int    `yaml:"maxAge" example:"0"`      // seconds since the first record of a segment (0 means unlimited)
+				// the data files of the sealed segments are compressed by blocks (a record is read by decompressing its block only)
+				Compression          string `yaml:"compression" example:"zstd"`           // "none", "gzip" or "zstd" (empty means none)
+				CompressionBlockSize string `yaml:"compressionBlockSize" example:"256kb"` // uncompressed size of a block, ex: "256kb" (empty means 256kb)
+			} `yaml:"segments"`
+			Checksums struct {
+				// the records are verified with the checksums of their index rows when they are read
+				OnMismatch string `yaml:"onMismatch" example:"count"` // "none" (not verified), "skip", "count" (as an error) or "fail" (empty means count)
+			} `yaml:"checksums"`
+			Encryption struct {
+				// the payload of the records (message and headers) is encrypted with AES-GCM, a record holds the id of its key:
+				// the keys are rotated by adding a new key (the previous keys decrypt the records already stored)
+				Enabled     bool   `yaml:"enabled" example:"false"`                    // encrypt the new records
+				KeyFile     string `yaml:"keyFile" example:"/etc/ministream/keys"`     // one key per line "<key id>:<base64 key>"
+				Keys        string `yaml:"keys" example:"$MINISTREAM_ENCRYPTION_KEYS"` // "<key id>:<base64 key>" separated by commas (environment variable name when it starts with "$")
+				ActiveKeyId string `yaml:"activeKeyId" example:"2026-10"`              // key encrypting the new records (empty means the last key listed)
+			} `yaml:"encryption"`
 		} `yaml:"jsonfile"`
 		InMemory struct {
 			MaxRecordsByStream uint64 `yaml:"maxRecordsByStream"`
@@ -110,26 +131,62 @@ type Config struct {
 		} `yaml:"inmemory"`
 		MySQL struct {
 			// https://github.com/Go-SQL-Driver/MySQL/?tab=readme-ov-file#dsn-data-source-name
-			DataSourceName    string `yaml:"dsn" example:"user:password@tcp(localhost:3306)/ministream?tls=skip-verify"`
-			ConnMaxLifetime   uint   `yaml:"connMaxLifetime" example:"0"`
-			MaxIdleConns      uint   `yaml:"maxIdleConns" example:"3"`
-			MaxOpenConns      uint   `yaml:"maxOpenConns" example:"3"`
-			SchemaName        string `yaml:"schemaName" example:"ministream"`
-			CatalogTableName  string `yaml:"catalogTableName" example:"streams"`
-			StreamTablePrefix string `yaml:"streamTablePrefix" example:"stream_"`
+			DataSourceName         string `yaml:"dsn" example:"user:password@tcp(localhost:3306)/ministream?tls=skip-verify"`
+			ConnMaxLifetime        uint   `yaml:"connMaxLifetime" example:"0"`
+			MaxIdleConns           uint   `yaml:"maxIdleConns" example:"3"`
+			MaxOpenConns           uint   `yaml:"maxOpenConns" example:"3"`
+			SchemaName             string `yaml:"schemaName" example:"ministream"`
+			CatalogTableName       string `yaml:"catalogTableName" example:"streams"`
+			StreamTablePrefix      string `yaml:"streamTablePrefix" example:"stream_"`
+			ConsumerGroupTableName string `yaml:"consumerGroupTableName" example:"consumer_groups"`
+			SchemaTableName        string `yaml:"schemaTableName" example:"stream_schemas"`
+			ProducerTableName      string `yaml:"producerTableName" example:"stream_producers"`
 		} `yaml:"mysql"`
 	}
 	DataDirectory string     `yaml:"dataDirectory"`
 	LoggerConfig  zap.Config `yaml:"logger"`
 	Account       Account    `yaml:"account"`
 	Streams       struct {
-		BulkFlushFrequency        int  `yaml:"bulkFlushFrequency"`
-		BulkMaxSize               int  `yaml:"bulkMaxSize"`
-		ChannelBufferSize         int  `yaml:"channelBufferSize"`
-		MaxIteratorsPerStream     int  `yaml:"maxAllowedIteratorsPerStream"`
-		MaxMessagePerGetOperation uint `yaml:"maxMessagePerGetOperation"`
-		LogVerbosity              int  `yaml:"logVerbosity"`
-		MaxAllowedStreams         uint `yaml:"maxAllowedStreams" example:"25"`
+		BulkFlushFrequency        int    `yaml:"bulkFlushFrequency"`
+		BulkFlushInterval         string `yaml:"bulkFlushInterval" example:"250ms"` // duration between two flushes, overrides bulkFlushFrequency (sub-second flushes)
+		BulkMaxSize               int    `yaml:"bulkMaxSize"`
+		BulkMaxBytes              string `yaml:"bulkMaxBytes" example:"4mb"` // flush when the buffered records exceed this size (0 or empty means unlimited)
+		ChannelBufferSize         int    `yaml:"channelBufferSize"`
+		MaxIteratorsPerStream     int    `yaml:"maxAllowedIteratorsPerStream"`
+		MaxMessagePerGetOperation uint   `yaml:"maxMessagePerGetOperation"`
+		LogVerbosity              int    `yaml:"logVerbosity"`
+		MaxAllowedStreams         uint   `yaml:"maxAllowedStreams" example:"25"`
+		IteratorIdleTimeout       int    `yaml:"iteratorIdleTimeout" example:"600"` // seconds without any read before an iterator is deleted (0 means never)
+		IteratorMaxLifetime       int    `yaml:"iteratorMaxLifetime" example:"0"`   // seconds after creation before an iterator is deleted (0 means never)
+		Retention                 struct {
+			// default retention policy of the streams (can be overridden by the stream properties)
+			MaxAge        int    `yaml:"maxAge" example:"0"`         // seconds (0 means unlimited)
+			MaxRecords    uint64 `yaml:"maxRecords" example:"0"`     // count of records (0 means unlimited)
+			MaxSize       string `yaml:"maxSize" example:"0"`        // size in bytes, ex: "500mb" (0 or empty means unlimited)
+			CheckInterval int    `yaml:"checkInterval" example:"60"` // seconds between two checks of the retention policy
+		} `yaml:"retention"`
+		Schemas struct {
+			// default compatibility mode enforced when a new version of the schema of a stream is registered:
+			// NONE, BACKWARD, FORWARD or FULL (can be overridden by the stream properties)
+			DefaultCompatibility string `yaml:"defaultCompatibility" example:"BACKWARD"`
+		} `yaml:"schemas"`
+		Backpressure struct {
+			// default backpressure of the streams when their ingest buffer is full (can be overridden by the stream properties)
+			Mode       string `yaml:"mode" example:"block"`   // block, reject or shed
+			MaxWait    int    `yaml:"maxWait" example:"10"`   // seconds a producer waits for room in the ingest buffer, block mode only (0 means unlimited)
+			RetryAfter int    `yaml:"retryAfter" example:"1"` // seconds given to the producers (Retry-After header) when their records are rejected
+		} `yaml:"backpressure"`
+		Durability struct {
+			// default durability level of the streams: none, flushed or fsynced (can be overridden by the stream properties)
+			Level   string `yaml:"level" example:"none"`
+			Timeout int    `yaml:"timeout" example:"30"` // seconds a producer waits for its records to be persisted (0 means unlimited)
+		} `yaml:"durability"`
+		Query struct {
+			// limits of the queries scanning a range of a stream (a query can request lower limits)
+			MaxRecordsScanned uint64 `yaml:"maxRecordsScanned" example:"1000000"` // records read by a query (0 means 1000000)
+			MaxDuration       int    `yaml:"maxDuration" example:"30"`            // seconds a query can run (0 means 30)
+			MaxGroups         int    `yaml:"maxGroups" example:"10000"`           // groups or distinct values returned by an aggregation (0 means 10000)
+		} `yaml:"query"`
 	}
 	Auth AuthConfig `yaml:"auth"`
 	RBAC struct {
@@ -17,14 +17,53 @@ const ErrorCantPutMessagesIntoStream = 1011
 const ErrorCantDeserializeJsonRecords = 1012
 const ErrorInvalidCreateRecordsIteratorRequest = 1013
 const ErrorCantCreateRecordsIterator = 1014
+const ErrorMessageIdNoLongerAvailable = 1015
+const ErrorInvalidRecordHeaders = 1016
+const ErrorInvalidIngestPipeline = 1017
+const ErrorInvalidDeadLetterStream = 1018
+const ErrorInvalidBackpressure = 1019
 
 const ErrorCantGetMessagesFromStream = 1020
+const ErrorWebSocketUpgradeRequired = 1021
+const ErrorRecordNotFound = 1022
+const ErrorInvalidQuery = 1023
 
 const ErrorCantCloseStreamIterator = 1030
 const ErrorStreamIteratorNotFound = 1031
 const ErrorStreamIteratorIsBusy = 1032
 
 const ErrorCantRebuildStreamIndex = 1040
+const ErrorCantScrubStream = 1041
+const ErrorCantReencryptStream = 1042
+const ErrorInvalidRetention = 1043
+
+const ErrorConsumerGroupNotFound = 1050
+const ErrorInvalidConsumerGroupName = 1051
+const ErrorCantCommitConsumerGroup = 1052
+const ErrorCantResetConsumerGroup = 1053
+const ErrorCantDeleteConsumerGroup = 1054
+
+const ErrorStreamSchemaNotFound = 1060
+const ErrorInvalidStreamSchema = 1061
+const ErrorIncompatibleStreamSchema = 1062
+const ErrorCantRegisterStreamSchema = 1063
+const ErrorCantDeleteStreamSchemas = 1064
+const ErrorRecordsDoNotMatchSchema = 1065
+
+const ErrorInvalidDerivedStream = 1070
+const ErrorCantPutRecordsIntoDerivedStream = 1071
+
+const ErrorInvalidProducer = 1080
+const ErrorProducerSequenceOutOfOrder = 1081
+
+const ErrorStreamOverloaded = 1090
+const ErrorIngestBatchTooLarge = 1091
+const ErrorInvalidDurability = 1092
+const ErrorRecordsNotPersisted = 1093
+
+const ErrorInvalidStreamExport = 1094
+const ErrorCantImportStream = 1095
+const ErrorCantCloneStream = 1096
 
 const ErrorInvalidJobUuid = 1100
 const ErrorJobUuidNotFound = 1101
@@ -66,3 +105,6 @@ const AuthHashMethodSHA1 = 4   // SHA1
 // Http params
 
 const ParamNameStreamIteratorUuid = "streamiteratoruuid"
+const ParamNameMessageId = "messageid"
+const ParamNameConsumerGroup = "consumergroup"
+const ParamNameSchemaVersion = "version"
@@ -0,0 +1,139 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A key ring holds the AES keys used to encrypt the records at rest, every key has an id stored with the data
+// it encrypted: the keys are rotated by adding a new key (the active key encrypts the new data) while
+// the previous keys are kept to decrypt the data already stored.
+//
+// The keys are listed as "<key id>:<base64 key>" entries separated by commas or new lines
+// (a line starting with # is a comment), a key is 16, 24 or 32 bytes long (AES-128, AES-192 or AES-256).
+
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+type KeyRing struct {
+	keys        map[string]cipher.AEAD
+	activeKeyId string
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[string]cipher.AEAD)}
+}
+
+func LoadKeyRing(keyFile string, keys string, activeKeyId string) (*KeyRing, error) {
+	// the keys are loaded from the key file and from the keys value
+	// (an environment variable name when the value starts with "$"), the last key listed is active by default
+	k := NewKeyRing()
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err = k.AddKeys(string(data)); err != nil {
+			return nil, fmt.Errorf("invalid key file %s: %w", keyFile, err)
+		}
+	}
+	if strings.HasPrefix(keys, "$") {
+		keys = os.Getenv(keys[1:])
+	}
+	if err := k.AddKeys(keys); err != nil {
+		return nil, err
+	}
+	if len(k.keys) == 0 {
+		return nil, errors.New("no encryption key")
+	}
+	if activeKeyId != "" {
+		if err := k.SetActiveKeyId(activeKeyId); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+func (k *KeyRing) AddKeys(keys string) error {
+	for _, entry := range strings.FieldsFunc(keys, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		keyId, encodedKey, found := strings.Cut(entry, ":")
+		if !found {
+			return errors.New("invalid key entry (expected <key id>:<base64 key>)")
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+		if err != nil {
+			return fmt.Errorf("invalid key %s: %w", keyId, err)
+		}
+		if err = k.AddKey(strings.TrimSpace(keyId), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *KeyRing) AddKey(keyId string, key []byte) error {
+	// the key added becomes the active key
+	if keyId == "" {
+		return errors.New("empty key id")
+	}
+	if _, found := k.keys[keyId]; found {
+		return fmt.Errorf("duplicate key id %s", keyId)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("invalid key %s: %w", keyId, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	k.keys[keyId] = aead
+	k.activeKeyId = keyId
+	return nil
+}
+
+func (k *KeyRing) SetActiveKeyId(keyId string) error {
+	if _, found := k.keys[keyId]; !found {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, keyId)
+	}
+	k.activeKeyId = keyId
+	return nil
+}
+
+func (k *KeyRing) GetActiveKeyId() string {
+	return k.activeKeyId
+}
+
+func (k *KeyRing) Encrypt(plaintext []byte, additionalData []byte) (string, []byte, error) {
+	// encrypt with the active key, returns the key id and the nonce followed by the ciphertext
+	aead, found := k.keys[k.activeKeyId]
+	if !found {
+		return "", nil, ErrKeyNotFound
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return k.activeKeyId, aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (k *KeyRing) Decrypt(keyId string, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	aead, found := k.keys[keyId]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyId)
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("invalid ciphertext")
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[aead.NonceSize():], additionalData)
+}
@@ -0,0 +1,67 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyRing(t *testing.T) {
+	// keys: 32 bytes of "a", 16 bytes of "b", 32 bytes of "c"
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	content := "# rotated every year\nk1:YWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWE=\nk2:YmJiYmJiYmJiYmJiYmJiYg==\n"
+	if err := os.WriteFile(keyFile, []byte(content), 0600); err != nil {
+		t.Fatalf("could not write key file: %v", err)
+	}
+	t.Setenv("TEST_MINISTREAM_KEYS", "k3:Y2NjY2NjY2NjY2NjY2NjY2NjY2NjY2NjY2NjY2NjY2M=")
+
+	k, err := LoadKeyRing(keyFile, "$TEST_MINISTREAM_KEYS", "")
+	if err != nil {
+		t.Fatalf("could not load key ring: %v", err)
+	}
+	if k.GetActiveKeyId() != "k3" {
+		t.Fatalf("expected the last key to be active, got %s", k.GetActiveKeyId())
+	}
+
+	keyId, ciphertext, err := k.Encrypt([]byte("secret"), []byte("id"))
+	if err != nil || keyId != "k3" || bytes.Contains(ciphertext, []byte("secret")) {
+		t.Fatalf("unexpected ciphertext %s %x (%v)", keyId, ciphertext, err)
+	}
+	if plaintext, err := k.Decrypt(keyId, ciphertext, []byte("id")); err != nil || string(plaintext) != "secret" {
+		t.Fatalf("unexpected plaintext %q (%v)", plaintext, err)
+	}
+	if _, err = k.Decrypt(keyId, ciphertext, []byte("other id")); err == nil {
+		t.Fatalf("expected an error with other additional data")
+	}
+	if _, err = k.Decrypt("k1", ciphertext, []byte("id")); err == nil {
+		t.Fatalf("expected an error with another key")
+	}
+	if _, err = k.Decrypt("k4", ciphertext, []byte("id")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected key not found, got %v", err)
+	}
+
+	// the data encrypted with a previous key is decrypted after the rotation
+	if err = k.SetActiveKeyId("k2"); err != nil {
+		t.Fatalf("could not set active key: %v", err)
+	}
+	if keyId, _, err = k.Encrypt([]byte("secret"), nil); err != nil || keyId != "k2" {
+		t.Fatalf("expected key k2, got %s (%v)", keyId, err)
+	}
+	if plaintext, err := k.Decrypt("k3", ciphertext, []byte("id")); err != nil || string(plaintext) != "secret" {
+		t.Fatalf("unexpected plaintext %q (%v)", plaintext, err)
+	}
+
+	for _, keys := range []string{"k1", "k1:not base64", "k1:YWFh", "k1:YmJiYmJiYmJiYmJiYmJiYg==,k1:YmJiYmJiYmJiYmJiYmJiYg=="} {
+		if _, err = LoadKeyRing("", keys, ""); err == nil {
+			t.Fatalf("expected an error for keys %q", keys)
+		}
+	}
+	if _, err = LoadKeyRing("", "$TEST_MINISTREAM_NO_KEYS", ""); err == nil {
+		t.Fatalf("expected an error without key")
+	}
+	if _, err = LoadKeyRing(keyFile, "", "k4"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected key not found, got %v", err)
+	}
+}
@@ -5,6 +5,7 @@ const ActionCreateRecordsIterator = "CreateRecordsIterator"
 const ActionPutRecords = "PutRecords"
 const ActionPutRecord = "PutRecord"
 const ActionGetRecordsIteratorStats = "GetRecordsIteratorStats"
+const ActionQueryRecords = "QueryRecords"
 const ActionListStreams = "ListStreams"
 const ActionListStreamsProperties = "ListStreamsProperties"
 const ActionGetStreamDescription = "GetStreamDescription"
@@ -13,8 +14,22 @@ const ActionSetStreamProperties = "SetStreamProperties"
 const ActionUpdateStreamProperties = "UpdateStreamProperties"
 const ActionCreateStream = "CreateStream"
 const ActionDeleteStream = "DeleteStream"
+const ActionExportStream = "ExportStream"
+const ActionImportStream = "ImportStream"
+const ActionCloneStream = "CloneStream"
 const ActionCloseRecordsIterator = "CloseRecordsIterator"
 const ActionRebuildIndex = "RebuildIndex"
+const ActionScrubStream = "ScrubStream"
+const ActionReencryptStream = "ReencryptStream"
+const ActionListConsumerGroups = "ListConsumerGroups"
+const ActionGetConsumerGroup = "GetConsumerGroup"
+const ActionCommitConsumerGroup = "CommitConsumerGroup"
+const ActionResetConsumerGroup = "ResetConsumerGroup"
+const ActionDeleteConsumerGroup = "DeleteConsumerGroup"
+const ActionListStreamSchemas = "ListStreamSchemas"
+const ActionGetStreamSchema = "GetStreamSchema"
+const ActionRegisterStreamSchema = "RegisterStreamSchema"
+const ActionDeleteStreamSchemas = "DeleteStreamSchemas"
 const ActionListUsers = "ListUsers"
 const ActionGetAccount = "GetAccount"
 const ActionShutdownServer = "ShutdownServer"
@@ -25,6 +40,8 @@ var ActionList = []string{
 	ActionGetRecords, ActionCreateRecordsIterator, ActionPutRecords, ActionPutRecord, ActionGetRecordsIteratorStats,
 	ActionListStreams, ActionListStreamsProperties, ActionGetStreamDescription, ActionGetStreamProperties,
 	ActionSetStreamProperties, ActionUpdateStreamProperties, ActionCreateStream, ActionDeleteStream,
-	ActionCloseRecordsIterator, ActionRebuildIndex, ActionListUsers,
-	ActionGetAccount, ActionShutdownServer, ActionRestartServer, ActionJWTRevokeAll,
+	ActionCloseRecordsIterator, ActionRebuildIndex, ActionListConsumerGroups, ActionGetConsumerGroup,
+	ActionCommitConsumerGroup, ActionResetConsumerGroup, ActionDeleteConsumerGroup, ActionListStreamSchemas,
+	ActionGetStreamSchema, ActionRegisterStreamSchema, ActionDeleteStreamSchemas, ActionListUsers, ActionGetAccount, ActionShutdownServer, ActionRestartServer, ActionJWTRevokeAll,
+	ActionQueryRecords, ActionExportStream, ActionImportStream, ActionCloneStream, ActionScrubStream, ActionReencryptStream,
 }
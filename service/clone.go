@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nbigot/ministream/constants"
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+func (svc *Service) CloneStream(ctx context.Context, streamPtr *stream.Stream, req *types.StreamCloneRequest) (*stream.CloneStreamResponse, *apierror.APIError) {
+	// Create a new stream holding the records of the stream up to a message id or a timestamp (see types.StreamCloneRequest).
+	// The records are copied by the storage provider (they are not put through the ingest pipeline of the clone),
+	// only the records readable when the clone starts are cloned. The clone of a partitioned stream has the same partitions.
+	// The consumer groups, the schemas and the producers of the stream are not cloned.
+	sourceUUID := streamPtr.GetUUID()
+	if streamPtr.IsPartition() {
+		return errorCloneStream(sourceUUID, constants.ErrorInvalidParameterValue, fiber.StatusBadRequest, fmt.Errorf("%w: cannot clone a partition, clone its partitioned stream instead", types.ErrInvalidStreamClone))
+	}
+	if err := req.CheckEnd(); err != nil {
+		return errorCloneStream(sourceUUID, constants.ErrorInvalidParameterValue, fiber.StatusBadRequest, fmt.Errorf("%w: %w", types.ErrInvalidStreamClone, err))
+	}
+	if streamPtr.IsPartitioned() && req.UntilMessageId != nil {
+		return errorCloneStream(sourceUUID, constants.ErrorInvalidParameterValue, fiber.StatusBadRequest, fmt.Errorf("%w: the partitions have their own message ids, clone a partitioned stream up to a timestamp", types.ErrInvalidStreamClone))
+	}
+
+	properties := types.StreamProperties{}
+	for key, value := range *streamPtr.GetProperties() {
+		properties[key] = value
+	}
+	for key, value := range req.Properties {
+		properties[key] = value
+	}
+	if derived, err := types.GetDerivedStream(properties); err != nil || derived != nil {
+		// the records of a derived stream are put by the server
+		return errorCloneStream(sourceUUID, constants.ErrorInvalidParameterValue, fiber.StatusBadRequest, fmt.Errorf("%w: a derived stream cannot be cloned", types.ErrInvalidStreamClone))
+	}
+
+	// the last record to clone is found before the clone is created (0 if there is no record to clone)
+	sources := []*stream.Stream{streamPtr}
+	if streamPtr.IsPartitioned() {
+		sources = streamPtr.GetPartitions()
+	}
+	lastMsgIds := make([]types.MessageId, len(sources))
+	for i, source := range sources {
+		var partition *int
+		if streamPtr.IsPartitioned() {
+			partition = &i
+		}
+		var apiErr *apierror.APIError
+		if lastMsgIds[i], apiErr = svc.getLastMsgIdToClone(ctx, streamPtr, partition, source.GetInfo().ReadableMessages, req); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+
+	var cptRecords int64
+	created := make(types.StreamUUIDList, 0, len(sources)+1)
+	populate := func(info *types.StreamInfo) error {
+		created = append(created, info.UUID)
+		index := 0
+		switch {
+		case info.IsPartitioned():
+			// the records are held by the partitions
+			return nil
+		case info.IsPartition():
+			index = info.Partitioning.Partition
+		}
+		if lastMsgIds[index] == 0 {
+			return nil
+		}
+		if err := svc.sp.CopyRecords(sources[index].GetUUID(), info, lastMsgIds[index]); err != nil {
+			return err
+		}
+		cptRecords += int64(info.ReadableMessages.CptMessages)
+		return nil
+	}
+
+	s, err := svc.createStream(svc.sp.GenerateNewStreamUuid(), &properties, streamPtr.GetPartitionsCount(), populate)
+	if err != nil {
+		svc.logger.Error(
+			"Cannot clone stream",
+			zap.String("topic", "stream"),
+			zap.String("method", "CloneStream"),
+			zap.String("stream.uuid", sourceUUID.String()),
+			zap.Error(err),
+		)
+		if len(created) == 0 {
+			// the clone was rejected before being created (ex: invalid properties, limit reached)
+			return errorCloneStream(sourceUUID, constants.ErrorCantCloneStream, fiber.StatusBadRequest, err)
+		}
+		svc.discardStreams(created)
+		return errorCloneStream(sourceUUID, constants.ErrorCantCloneStream, fiber.StatusInternalServerError, err)
+	}
+
+	response := stream.CloneStreamResponse{
+		Status:           "success",
+		Message:          "Stream cloned",
+		StreamUUID:       s.GetUUID(),
+		SourceStreamUUID: sourceUUID,
+		CountRecords:     cptRecords,
+	}
+	return &response, nil
+}
+
+func (svc *Service) getLastMsgIdToClone(ctx context.Context, streamPtr *stream.Stream, partition *int, readable types.StreamMessagesInfo, req *types.StreamCloneRequest) (types.MessageId, *apierror.APIError) {
+	// returns the id of the last readable record to clone (0 if there is no record to clone)
+	streamUUID := streamPtr.GetUUID()
+	switch {
+	case readable.CptMessages == 0:
+		return 0, nil
+	case req.UntilMessageId != nil:
+		return min(*req.UntilMessageId, readable.LastMsgId), nil
+	case req.UntilTimestamp == nil:
+		return readable.LastMsgId, nil
+	case !readable.FirstMsgTimestamp.Before(*req.UntilTimestamp):
+		return 0, nil
+	}
+
+	// the last record put before the timestamp is the first record read backward
+	itReq := types.StreamIteratorRequest{
+		IteratorType: "AT_TIMESTAMP",
+		Timestamp:    req.UntilTimestamp.Add(-time.Nanosecond),
+		Name:         "clone",
+		Partition:    partition,
+		Direction:    types.IteratorDirectionBackward,
+	}
+	itUUID, apiErr := svc.createInternalRecordsIterator(streamPtr, &itReq)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+	defer func() {
+		_ = streamPtr.CloseIterator(itUUID)
+	}()
+
+	response, err := streamPtr.GetRecords(ctx, itUUID, 1)
+	if err != nil {
+		_, apiErr = errorCloneStream(streamUUID, constants.ErrorCantGetMessagesFromStream, fiber.StatusInternalServerError, err)
+		return 0, apiErr
+	}
+	if len(response.Records) == 0 {
+		return 0, nil
+	}
+	record, err := toExportedRecord(response.Records[0], partition)
+	if err != nil {
+		_, apiErr = errorCloneStream(streamUUID, constants.ErrorCantGetMessagesFromStream, fiber.StatusInternalServerError, err)
+		return 0, apiErr
+	}
+	return min(record.Id, readable.LastMsgId), nil
+}
+
+func (svc *Service) discardStreams(streamUUIDs types.StreamUUIDList) {
+	// delete the streams (and the partitions) left by a stream creation that failed
+	for _, streamUUID := range streamUUIDs {
+		if s := svc.GetStream(streamUUID); s != nil {
+			_ = s.Close()
+			svc.setStreamMap(streamUUID, nil)
+		}
+		if err := svc.sp.DeleteStream(streamUUID); err != nil {
+			svc.logger.Error(
+				"Cannot delete stream",
+				zap.String("topic", "stream"),
+				zap.String("method", "discardStreams"),
+				zap.String("stream.uuid", streamUUID.String()),
+				zap.Error(err),
+			)
+		}
+	}
+	if err := svc.saveStreamCatalog(); err != nil {
+		svc.logger.Error(
+			"Cannot save stream catalog",
+			zap.String("topic", "stream"),
+			zap.String("method", "discardStreams"),
+			zap.Error(err),
+		)
+	}
+}
+
+func errorCloneStream(streamUUID types.StreamUUID, errorCode int, httpCode int, err error) (*stream.CloneStreamResponse, *apierror.APIError) {
+	return nil, &apierror.APIError{
+		Message:    "cannot clone stream",
+		Details:    err.Error(),
+		Code:       errorCode,
+		HttpCode:   httpCode,
+		StreamUUID: streamUUID,
+		Err:        err,
+	}
+}
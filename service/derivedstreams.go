@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Maximum number of records of the source stream processed at once by a derived stream
+const DerivedStreamBatchSize = 1000
+
+// Maximum time a derived stream waits for new records of its source stream (long polling)
+const DerivedStreamMaxWaitTime = 10 * time.Second
+
+// Maximum time a derived stream waits for the records it puts to be written before committing its position
+const DerivedStreamDurabilityTimeout = 30 * time.Second
+
+// Time to wait before retrying when the source stream can't be read (or the records can't be put)
+const DerivedStreamRetryDelay = 5 * time.Second
+
+type derivedStreamFollower struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (svc *Service) checkDerivedStream(properties types.StreamProperties, cptPartitions int) error {
+	// check the definition of a derived stream before it is created
+	derived, err := types.GetDerivedStream(properties)
+	if err != nil || derived == nil {
+		return err
+	}
+	if cptPartitions > 0 {
+		return fmt.Errorf("%w: a derived stream cannot be partitioned", types.ErrInvalidDerivedStream)
+	}
+	source := svc.GetStream(derived.Source)
+	if source == nil {
+		return fmt.Errorf("%w: source stream %s not found", types.ErrInvalidDerivedStream, derived.Source.String())
+	}
+	if source.IsPartitioned() {
+		// the position of the derived stream is tracked by a consumer group of the source stream
+		return fmt.Errorf("%w: %w", types.ErrInvalidDerivedStream, errConsumerGroupOnPartitionedStream)
+	}
+	return nil
+}
+
+func (svc *Service) startDerivedStreams() {
+	// start following the source streams once all the streams are started
+	svc.mapMutex.RLock()
+	streams := make([]*stream.Stream, 0)
+	for _, s := range svc.Hashmap {
+		if s.IsDerived() {
+			streams = append(streams, s)
+		}
+	}
+	svc.mapMutex.RUnlock()
+
+	for _, s := range streams {
+		svc.startDerivedStream(s)
+	}
+}
+
+func (svc *Service) startDerivedStream(s *stream.Stream) {
+	derived, err := types.GetDerivedStream(s.GetInfo().Properties)
+	if err != nil || derived == nil {
+		svc.logger.Error(
+			"Cannot start derived stream",
+			zap.String("topic", "stream"),
+			zap.String("method", "startDerivedStream"),
+			zap.String("stream.uuid", s.GetUUID().String()),
+			zap.Error(err),
+		)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	follower := &derivedStreamFollower{cancel: cancel, done: make(chan struct{})}
+	svc.muDerivedStreams.Lock()
+	svc.derivedStreams[s.GetUUID()] = follower
+	svc.muDerivedStreams.Unlock()
+
+	go func() {
+		defer close(follower.done)
+		svc.followSourceStream(ctx, s, derived)
+	}()
+}
+
+func (svc *Service) stopDerivedStream(streamUUID types.StreamUUID) {
+	svc.muDerivedStreams.Lock()
+	follower, found := svc.derivedStreams[streamUUID]
+	delete(svc.derivedStreams, streamUUID)
+	svc.muDerivedStreams.Unlock()
+
+	if found {
+		follower.cancel()
+		<-follower.done
+	}
+}
+
+func (svc *Service) stopDerivedStreams() {
+	svc.muDerivedStreams.Lock()
+	streamUUIDs := make([]types.StreamUUID, 0, len(svc.derivedStreams))
+	for streamUUID := range svc.derivedStreams {
+		streamUUIDs = append(streamUUIDs, streamUUID)
+	}
+	svc.muDerivedStreams.Unlock()
+
+	for _, streamUUID := range streamUUIDs {
+		svc.stopDerivedStream(streamUUID)
+	}
+}
+
+func (svc *Service) deleteDerivedStream(s *stream.Stream) {
+	// stop following the source stream and forget the position of the derived stream
+	svc.stopDerivedStream(s.GetUUID())
+	derived, err := types.GetDerivedStream(s.GetInfo().Properties)
+	if err != nil || derived == nil {
+		return
+	}
+	if source := svc.GetStream(derived.Source); source != nil {
+		err = svc.DeleteConsumerGroup(source, types.GetDerivedStreamConsumerGroup(s.GetUUID()))
+		if err != nil && !errors.Is(err, stream.ErrConsumerGroupNotFound) {
+			svc.logger.Error(
+				"Cannot delete derived stream consumer group",
+				zap.String("topic", "stream"),
+				zap.String("method", "deleteDerivedStream"),
+				zap.String("stream.uuid", s.GetUUID().String()),
+				zap.String("source.uuid", derived.Source.String()),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+func (svc *Service) followSourceStream(ctx context.Context, s *stream.Stream, derived *types.DerivedStream) {
+	// Read the source stream from the position committed by the consumer group of the derived stream,
+	// put the transformed records into the derived stream, then commit the position.
+	groupName := types.GetDerivedStreamConsumerGroup(s.GetUUID())
+	logError := func(msg string, err error) {
+		svc.logger.Error(
+			msg,
+			zap.String("topic", "stream"),
+			zap.String("method", "followSourceStream"),
+			zap.String("stream.uuid", s.GetUUID().String()),
+			zap.String("source.uuid", derived.Source.String()),
+			zap.Error(err),
+		)
+	}
+
+	var source *stream.Stream
+	itUUID := uuid.Nil
+	closeIterator := func() {
+		if itUUID != uuid.Nil {
+			_ = source.CloseIterator(itUUID)
+			itUUID = uuid.Nil
+		}
+	}
+	defer closeIterator()
+
+	retry := func() bool {
+		// returns false if the derived stream is stopping
+		closeIterator()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(DerivedStreamRetryDelay):
+			return true
+		}
+	}
+
+	svc.logger.Info(
+		"Start derived stream",
+		zap.String("topic", "stream"),
+		zap.String("method", "followSourceStream"),
+		zap.String("stream.uuid", s.GetUUID().String()),
+		zap.String("source.uuid", derived.Source.String()),
+	)
+
+	for ctx.Err() == nil {
+		if itUUID == uuid.Nil {
+			if source = svc.GetStream(derived.Source); source == nil {
+				logError("Cannot read source stream", errors.New("source stream not found"))
+				if !retry() {
+					return
+				}
+				continue
+			}
+
+			req := types.StreamIteratorRequest{
+				IteratorType:       "FIRST_MESSAGE",
+				MaxWaitTimeSeconds: int(DerivedStreamMaxWaitTime.Seconds()),
+				Name:               groupName,
+				ConsumerGroup:      groupName,
+			}
+			var apiErr *apierror.APIError
+			if itUUID, apiErr = svc.createInternalRecordsIterator(source, &req); apiErr != nil {
+				itUUID = uuid.Nil
+				logError("Cannot read source stream", apiErr)
+				if !retry() {
+					return
+				}
+				continue
+			}
+		}
+
+		response, err := source.GetRecords(ctx, itUUID, DerivedStreamBatchSize)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logError("Cannot read source stream", err)
+			if !retry() {
+				return
+			}
+			continue
+		}
+		if response.Count == 0 {
+			continue
+		}
+
+		// the position is committed once the records are written, not only queued into the ingest buffer,
+		// the wait is not cancelled when the derived stream stops: the records written by the stop would be put again
+		waitCtx, cancelWait := context.WithTimeout(context.WithoutCancel(ctx), DerivedStreamDurabilityTimeout)
+		msgIds, err := svc.putDerivedRecords(waitCtx, s, derived, response.Records)
+		if err == nil {
+			err = s.WaitForDurability(waitCtx, types.DurabilityFlushed, msgIds)
+		}
+		cancelWait()
+		if err != nil {
+			// the records will be read again from the committed position
+			logError("Cannot put records into derived stream", err)
+			if !retry() {
+				return
+			}
+			continue
+		}
+
+		if _, err = source.CommitConsumerGroup(groupName, response.LastRecordIdRead, svc.sp.SaveConsumerGroup); err != nil {
+			logError("Cannot commit derived stream position", err)
+		}
+	}
+}
+
+func (svc *Service) putDerivedRecords(ctx context.Context, s *stream.Stream, derived *types.DerivedStream, sourceRecords []interface{}) ([]types.MessageId, error) {
+	// returns the message ids of the records put into the derived stream,
+	// the records rejected by the filter are written into the dead-letter stream before
+	// transform the records read from the source stream ({"i": ..., "d": ..., "m": {...}, "h": {...}})
+	records := make([]interface{}, 0, len(sourceRecords))
+	headers := make([]types.RecordHeaders, 0, len(sourceRecords))
+	var deadLetters []*types.DeadLetter
+	for _, sourceRecord := range sourceRecords {
+		fields, ok := sourceRecord.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		message := fields["m"]
+		recordHeaders := getSourceRecordHeaders(fields["h"])
+
+		record, kept, err := derived.Filter.Run(message, recordHeaders, derived.Source)
+		if err != nil {
+			deadLetters = append(deadLetters, &types.DeadLetter{Source: types.DeadLetterSourceDerived, Reason: err.Error(), Message: message, Headers: recordHeaders})
+			continue
+		}
+		if kept {
+			records = append(records, record)
+			headers = append(headers, recordHeaders)
+		}
+	}
+
+	deadLetterStream, deadLetterIds, err := s.PutDeadLetters(deadLetters)
+	if err != nil {
+		return nil, err
+	}
+	if deadLetterStream != nil {
+		if err = deadLetterStream.WaitForDurability(ctx, types.DurabilityFlushed, deadLetterIds); err != nil {
+			return nil, err
+		}
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	msgIds, _, err := s.PutMessages(nil, records, headers)
+	return msgIds, err
+}
+
+func getSourceRecordHeaders(value interface{}) types.RecordHeaders {
+	fields, ok := value.(map[string]interface{})
+	if !ok || len(fields) == 0 {
+		return nil
+	}
+	headers := make(types.RecordHeaders, len(fields))
+	for name, value := range fields {
+		if strValue, ok := value.(string); ok {
+			headers[name] = strValue
+		}
+	}
+	return headers
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+)
+
+// Number of records read (or put) at once by an export (or an import)
+const ExportBatchSize = 1000
+
+var ErrStreamAlreadyExists = errors.New("stream already exists")
+
+func (svc *Service) ExportStream(ctx context.Context, streamPtr *stream.Stream, w io.Writer) (int64, error) {
+	// Write the records of the stream as JSONL (see types.StreamExportHeader), returns the number of records exported.
+	// The records are read with iterators therefore the export works with any storage provider,
+	// the records put while the stream is exported are not exported.
+	info := streamPtr.GetInfo()
+	header := types.StreamExportHeader{
+		Version:      types.StreamExportVersion,
+		UUID:         info.UUID,
+		CreationDate: info.CreationDate,
+		ExportDate:   time.Now(),
+		Properties:   info.Properties,
+		Partitions:   streamPtr.GetPartitionsCount(),
+	}
+	if err := writeJSONLine(w, header); err != nil {
+		return 0, err
+	}
+
+	if !streamPtr.IsPartitioned() {
+		return svc.exportRecords(ctx, streamPtr, nil, w)
+	}
+
+	var cptRecords int64
+	for i := range streamPtr.GetPartitionsCount() {
+		partition := i
+		cpt, err := svc.exportRecords(ctx, streamPtr, &partition, w)
+		cptRecords += cpt
+		if err != nil {
+			return cptRecords, err
+		}
+	}
+	return cptRecords, nil
+}
+
+func (svc *Service) exportRecords(ctx context.Context, streamPtr *stream.Stream, partition *int, w io.Writer) (int64, error) {
+	req := types.StreamIteratorRequest{
+		IteratorType: "FIRST_MESSAGE",
+		Name:         "export",
+		Partition:    partition,
+		Snapshot:     true,
+	}
+	itUUID, apiErr := svc.createInternalRecordsIterator(streamPtr, &req)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+	defer func() {
+		_ = streamPtr.CloseIterator(itUUID)
+	}()
+
+	var cptRecords int64
+	for {
+		response, err := streamPtr.GetRecords(ctx, itUUID, ExportBatchSize)
+		if err != nil {
+			return cptRecords, err
+		}
+		if response.CountErrors > 0 {
+			return cptRecords, fmt.Errorf("cannot read %d records", response.CountErrors)
+		}
+		for _, record := range response.Records {
+			exportedRecord, err := toExportedRecord(record, partition)
+			if err != nil {
+				return cptRecords, err
+			}
+			if err = writeJSONLine(w, exportedRecord); err != nil {
+				return cptRecords, err
+			}
+			cptRecords++
+		}
+		if response.Ended || !response.Remain {
+			return cptRecords, nil
+		}
+	}
+}
+
+func toExportedRecord(record interface{}, partition *int) (*types.ExportedRecord, error) {
+	// convert a record read from a storage provider ({"i": ..., "d": ..., "m": {...}, "h": {...}})
+	fields, ok := record.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid record format")
+	}
+
+	exportedRecord := types.ExportedRecord{Message: fields["m"], Headers: getSourceRecordHeaders(fields["h"]), Partition: partition}
+	switch id := fields["i"].(type) {
+	case types.MessageId:
+		exportedRecord.Id = id
+	case float64:
+		exportedRecord.Id = types.MessageId(id)
+	case int:
+		exportedRecord.Id = types.MessageId(id)
+	default:
+		return nil, errors.New("invalid record id")
+	}
+	strDate, _ := fields["d"].(string)
+	creationDate, err := time.Parse(time.RFC3339Nano, strDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date of record %d: %w", exportedRecord.Id, err)
+	}
+	exportedRecord.CreationDate = creationDate
+	return &exportedRecord, nil
+}
+
+func writeJSONLine(w io.Writer, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (svc *Service) ImportStream(ctx context.Context, r io.Reader, options types.StreamImportOptions) (*stream.ImportStreamResponse, error) {
+	// Create a stream from a stream export (see ExportStream).
+	// The records are not transformed by the ingest pipeline of the stream (they were transformed before being exported).
+	// The stream is deleted if the import fails.
+	reader := bufio.NewReader(r)
+	line, err := readJSONLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot read header: %w", types.ErrInvalidStreamExport, err)
+	}
+	var header types.StreamExportHeader
+	if err = json.Unmarshal(line, &header); err != nil {
+		return nil, fmt.Errorf("%w: invalid header: %w", types.ErrInvalidStreamExport, err)
+	}
+	if header.Version != types.StreamExportVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", types.ErrInvalidStreamExport, header.Version)
+	}
+	if header.Properties == nil {
+		header.Properties = types.StreamProperties{}
+	}
+	if derived, err := types.GetDerivedStream(header.Properties); err != nil || derived != nil {
+		// the records of a derived stream are put by the server
+		return nil, fmt.Errorf("%w: a derived stream cannot be imported, import its source stream instead", types.ErrInvalidStreamExport)
+	}
+
+	streamUUID := svc.sp.GenerateNewStreamUuid()
+	if options.KeepUUID {
+		if svc.GetStream(header.UUID) != nil {
+			return nil, fmt.Errorf("%w: %s", ErrStreamAlreadyExists, header.UUID.String())
+		}
+		streamUUID = header.UUID
+	}
+	s, err := svc.createStream(streamUUID, &header.Properties, header.Partitions, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cptRecords, err := svc.importRecords(ctx, s, reader, options.PreserveIds)
+	if err != nil {
+		svc.logger.Error(
+			"Cannot import stream",
+			zap.String("topic", "stream"),
+			zap.String("method", "ImportStream"),
+			zap.String("stream.uuid", s.GetUUID().String()),
+			zap.Error(err),
+		)
+		if errDelete := svc.DeleteStream(s.GetUUID()); errDelete != nil {
+			err = errors.Join(err, errDelete)
+		}
+		return nil, err
+	}
+
+	response := stream.ImportStreamResponse{
+		Status:           "success",
+		Message:          "Stream imported",
+		StreamUUID:       s.GetUUID(),
+		SourceStreamUUID: header.UUID,
+		CountRecords:     cptRecords,
+	}
+	return &response, nil
+}
+
+func (svc *Service) importRecords(ctx context.Context, s *stream.Stream, reader *bufio.Reader, preserveIds bool) (int64, error) {
+	var cptRecords int64
+	batch := make([]*types.ExportedRecord, 0, ExportBatchSize)
+	var batchTarget *stream.Stream
+	lastMsgIds := make(map[*stream.Stream]types.MessageId)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		msgIds, err := batchTarget.ImportRecords(batch, preserveIds)
+		if err != nil {
+			return err
+		}
+		lastMsgIds[batchTarget] = msgIds[len(msgIds)-1]
+		cptRecords += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	for lineNumber := 2; ; lineNumber++ {
+		line, err := readJSONLine(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cptRecords, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+
+		var record types.ExportedRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			return cptRecords, fmt.Errorf("%w: line %d: %w", types.ErrInvalidStreamExport, lineNumber, err)
+		}
+		target := s
+		if s.IsPartitioned() != (record.Partition != nil) {
+			return cptRecords, fmt.Errorf("%w: line %d: the partition must be set if and only if the stream is partitioned", types.ErrInvalidStreamExport, lineNumber)
+		}
+		if record.Partition != nil {
+			if target, err = s.GetPartition(*record.Partition); err != nil {
+				return cptRecords, fmt.Errorf("%w: line %d: %w", types.ErrInvalidStreamExport, lineNumber, err)
+			}
+		}
+
+		if target != batchTarget || len(batch) >= ExportBatchSize {
+			if err = flush(); err != nil {
+				return cptRecords, err
+			}
+			batchTarget = target
+		}
+		batch = append(batch, &record)
+	}
+	if err := flush(); err != nil {
+		return cptRecords, err
+	}
+
+	// the stream is readable once the import is done
+	for target, lastMsgId := range lastMsgIds {
+		if err := target.WaitForDurability(ctx, types.DurabilityFlushed, []types.MessageId{lastMsgId}); err != nil {
+			return cptRecords, err
+		}
+	}
+	return cptRecords, nil
+}
+
+func readJSONLine(reader *bufio.Reader) ([]byte, error) {
+	// returns io.EOF once all the lines have been read
+	line, err := reader.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	return bytes.TrimSpace(line), err
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+var errConsumerGroupOnPartitionedStream = errors.New("consumer groups must be used on the partitions of the stream")
+
+func (svc *Service) createPartitions(info *types.StreamInfo, cptPartitions int, populate streamPopulator) ([]*stream.Stream, error) {
+	// create the partitions of a partitioned stream, each partition is a stream on its own
+	parentUUID := info.UUID
+	info.Partitioning = &types.StreamPartitioning{Partitions: make(types.StreamUUIDList, cptPartitions)}
+	partitions := make([]*stream.Stream, cptPartitions)
+	for i := 0; i < cptPartitions; i++ {
+		partitionInfo := types.NewStreamInfo(svc.sp.GenerateNewStreamUuid())
+		partitionInfo.Partitioning = &types.StreamPartitioning{ParentUUID: &parentUUID, Partition: i}
+		if err := svc.sp.OnCreateStream(partitionInfo); err != nil {
+			return nil, err
+		}
+		if populate != nil {
+			if err := populate(partitionInfo); err != nil {
+				return nil, err
+			}
+		}
+
+		s, err := svc.startStream(partitionInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		info.Partitioning.Partitions[i] = partitionInfo.UUID
+		partitions[i] = s
+	}
+
+	return partitions, nil
+}
+
+func (svc *Service) attachPartitions() error {
+	// attach the partitions to their partitioned stream once all the streams are started
+	svc.mapMutex.RLock()
+	defer svc.mapMutex.RUnlock()
+
+	for _, s := range svc.Hashmap {
+		if !s.IsPartitioned() {
+			continue
+		}
+
+		partitionsUUIDs := s.GetInfo().Partitioning.Partitions
+		partitions := make([]*stream.Stream, len(partitionsUUIDs))
+		for i, partitionUUID := range partitionsUUIDs {
+			partition, found := svc.Hashmap[partitionUUID]
+			if !found {
+				err := fmt.Errorf("partition %d not found: %s", i, partitionUUID.String())
+				svc.logger.Error(
+					"Cannot attach stream partitions",
+					zap.String("topic", "stream"),
+					zap.String("method", "attachPartitions"),
+					zap.String("stream.uuid", s.GetUUID().String()),
+					zap.Error(err),
+				)
+				return err
+			}
+			partitions[i] = partition
+		}
+		s.SetPartitions(partitions)
+	}
+
+	return nil
+}
+
+func (svc *Service) newPartitionsIteratorHandler(streamPtr *stream.Stream, req *types.StreamIteratorRequest, iteratorUUID types.StreamIteratorUUID) (types.IStreamIteratorHandler, *types.StreamIteratorRequest, error) {
+	// returns the iterator handler of a partitioned stream: one partition or all the partitions
+	if req.ConsumerGroup != "" {
+		return nil, req, errConsumerGroupOnPartitionedStream
+	}
+
+	if req.Partition != nil {
+		partition, err := streamPtr.GetPartition(*req.Partition)
+		if err != nil {
+			return nil, req, err
+		}
+		if req, err = checkIteratorPosition(partition, req); err != nil {
+			return nil, req, err
+		}
+		handler, err := svc.sp.NewStreamIteratorHandler(partition.GetUUID(), iteratorUUID)
+		return handler, req, err
+	}
+
+	// each partition has its own message ids
+	switch req.IteratorType {
+	case "AT_MESSAGE_ID", "AFTER_MESSAGE_ID":
+		return nil, req, fmt.Errorf("iterator type %s requires a partition", req.IteratorType)
+	}
+	if req.UntilMessageId != nil || req.Snapshot {
+		return nil, req, errors.New("untilMessageId and snapshot require a partition")
+	}
+
+	handler, err := streamPtr.GetPartitionsHandlers(func(partitionUUID types.StreamUUID) (types.IStreamIteratorHandler, error) {
+		return svc.sp.NewStreamIteratorHandler(partitionUUID, iteratorUUID)
+	})
+	return handler, req, err
+}
@@ -0,0 +1,17 @@
+package service
+
+import (
+	"context"
+
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+)
+
+func (svc *Service) PutIdempotent(ctx context.Context, streamPtr *stream.Stream, producerId string, sequence uint64, put func() (*types.ProducerBatch, error)) (*types.ProducerBatch, bool, error) {
+	// the records are put as is when they are not sent by an idempotent producer
+	if producerId == "" {
+		batch, err := put()
+		return batch, false, err
+	}
+	return streamPtr.PutIdempotent(ctx, producerId, sequence, svc.sp.SaveProducer, put)
+}
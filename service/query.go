@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbigot/ministream/constants"
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// Default limits of the queries (when not set by the configuration)
+const DefaultQueryMaxRecordsScanned = 1000000
+const DefaultQueryMaxDuration = 30 * time.Second
+const DefaultQueryMaxGroups = 10000
+
+// Number of records read at once by a query
+const QueryReadBatchSize = 1000
+
+func (svc *Service) QueryStream(ctx context.Context, streamPtr *stream.Stream, req *types.StreamQueryRequest) (*stream.QueryStreamResponse, *apierror.APIError) {
+	// Scan a range of the stream and aggregate the records matching the jq program of the query.
+	// The scan stops at the end of the range, or when the stream has been read up to its last record,
+	// or when a limit (records scanned, duration) is reached (the results are then incomplete).
+	startTime := time.Now()
+	streamUUID := streamPtr.GetUUID()
+
+	maxRecordsScanned, maxDuration, maxGroups := svc.getQueryLimits()
+	if req.MaxRecordsScanned > maxRecordsScanned {
+		return errorQueryStream(streamUUID, constants.ErrorInvalidQuery, fiber.StatusBadRequest, fmt.Errorf("%w: maxRecordsScanned cannot exceed limit %d", types.ErrInvalidQuery, maxRecordsScanned))
+	}
+	if req.MaxRecordsScanned > 0 {
+		maxRecordsScanned = req.MaxRecordsScanned
+	}
+	if req.MaxDurationSeconds < 0 || time.Duration(req.MaxDurationSeconds)*time.Second > maxDuration {
+		return errorQueryStream(streamUUID, constants.ErrorInvalidQuery, fiber.StatusBadRequest, fmt.Errorf("%w: maxDurationSeconds must be between 0 and %d", types.ErrInvalidQuery, int(maxDuration.Seconds())))
+	}
+	if req.MaxDurationSeconds > 0 {
+		maxDuration = time.Duration(req.MaxDurationSeconds) * time.Second
+	}
+
+	query, err := types.NewStreamQuery(req, maxGroups)
+	if err != nil {
+		return errorQueryStream(streamUUID, constants.ErrorInvalidQuery, fiber.StatusBadRequest, err)
+	}
+
+	// the range is read by a temporary iterator
+	itReq := req.Range
+	if itReq.IteratorType == "" {
+		itReq.IteratorType = "FIRST_MESSAGE"
+	}
+	switch itReq.IteratorType {
+	case "FIRST_MESSAGE", "LAST_MESSAGE", "AT_MESSAGE_ID", "AFTER_MESSAGE_ID", "AT_TIMESTAMP":
+	default:
+		return errorQueryStream(streamUUID, constants.ErrorInvalidQuery, fiber.StatusBadRequest, fmt.Errorf("%w: invalid iterator type %s", types.ErrInvalidQuery, itReq.IteratorType))
+	}
+	if itReq.ConsumerGroup != "" {
+		return errorQueryStream(streamUUID, constants.ErrorInvalidQuery, fiber.StatusBadRequest, fmt.Errorf("%w: a query cannot use a consumer group", types.ErrInvalidQuery))
+	}
+	if itReq.JqFilter != "" {
+		return errorQueryStream(streamUUID, constants.ErrorInvalidQuery, fiber.StatusBadRequest, fmt.Errorf("%w: the jq program of a query is set by the jq attribute of the query", types.ErrInvalidQuery))
+	}
+	itReq.Name = "query"
+	itReq.MaxWaitTimeSeconds = 0
+	if !itReq.HasEnd() && !itReq.IsBackward() && (!streamPtr.IsPartitioned() || itReq.Partition != nil) {
+		// the records put while the query is running are not scanned
+		itReq.Snapshot = true
+	}
+
+	itUUID, apiErr := svc.createInternalRecordsIterator(streamPtr, &itReq)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	defer func() {
+		_ = streamPtr.CloseIterator(itUUID)
+	}()
+
+	response := stream.QueryStreamResponse{
+		Status:     "success",
+		StreamUUID: streamUUID,
+	}
+	deadline := startTime.Add(maxDuration)
+	for uint64(response.RecordsScanned) < maxRecordsScanned && time.Now().Before(deadline) && ctx.Err() == nil {
+		batchSize := min(uint64(QueryReadBatchSize), maxRecordsScanned-uint64(response.RecordsScanned))
+		records, err := streamPtr.GetRecords(ctx, itUUID, uint(batchSize))
+		if err != nil {
+			var recordsApiErr *apierror.APIError
+			if errors.As(err, &recordsApiErr) {
+				return nil, recordsApiErr
+			}
+			return errorQueryStream(streamUUID, constants.ErrorCantGetMessagesFromStream, fiber.StatusInternalServerError, err)
+		}
+
+		response.RecordsScanned += records.Count + records.CountErrors
+		response.CountErrors += records.CountErrors
+		response.LastRecordIdRead = records.LastRecordIdRead
+		for _, record := range records.Records {
+			matched, err := query.Aggregate(record)
+			if matched {
+				response.RecordsMatched++
+			}
+			if err != nil {
+				response.CountErrors++
+				if svc.conf.Streams.LogVerbosity > 0 {
+					svc.logger.Debug(
+						"Query error",
+						zap.String("topic", "stream"),
+						zap.String("method", "QueryStream"),
+						zap.String("stream.uuid", streamUUID.String()),
+						zap.Error(err),
+					)
+				}
+			}
+		}
+
+		if records.Ended || !records.Remain {
+			// the end of the range (or the last record of the stream) has been read
+			response.Complete = true
+			break
+		}
+	}
+
+	response.Results = query.GetResults()
+	response.Duration = time.Since(startTime).Milliseconds()
+	return &response, nil
+}
+
+func (svc *Service) getQueryLimits() (uint64, time.Duration, int) {
+	maxRecordsScanned := uint64(DefaultQueryMaxRecordsScanned)
+	if svc.conf.Streams.Query.MaxRecordsScanned > 0 {
+		maxRecordsScanned = svc.conf.Streams.Query.MaxRecordsScanned
+	}
+	maxDuration := DefaultQueryMaxDuration
+	if svc.conf.Streams.Query.MaxDuration > 0 {
+		maxDuration = time.Duration(svc.conf.Streams.Query.MaxDuration) * time.Second
+	}
+	maxGroups := DefaultQueryMaxGroups
+	if svc.conf.Streams.Query.MaxGroups > 0 {
+		maxGroups = svc.conf.Streams.Query.MaxGroups
+	}
+	return maxRecordsScanned, maxDuration, maxGroups
+}
+
+func errorQueryStream(streamUUID types.StreamUUID, errorCode int, httpCode int, err error) (*stream.QueryStreamResponse, *apierror.APIError) {
+	return nil, &apierror.APIError{
+		Message:    "cannot query stream",
+		Details:    err.Error(),
+		Code:       errorCode,
+		HttpCode:   httpCode,
+		StreamUUID: streamUUID,
+		Err:        err,
+	}
+}
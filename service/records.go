@@ -0,0 +1,46 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/nbigot/ministream/constants"
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (svc *Service) GetRecordsByIds(streamPtr *stream.Stream, partition *int, messageIds []types.MessageId) ([]interface{}, *apierror.APIError) {
+	// Returns the readable records having the given message ids, without creating an iterator.
+	// The record is nil if it is not found (never put, not yet saved or removed by the retention policy).
+	streamUUID := streamPtr.GetUUID()
+	if streamPtr.IsPartitioned() {
+		if partition == nil {
+			return errorGetRecordsByIds(streamUUID, constants.ErrorInvalidParameterValue, fiber.StatusBadRequest, errors.New("message ids are specific to each partition, the partition is required"))
+		}
+		var err error
+		if streamPtr, err = streamPtr.GetPartition(*partition); err != nil {
+			return errorGetRecordsByIds(streamUUID, constants.ErrorInvalidParameterValue, fiber.StatusBadRequest, err)
+		}
+	} else if partition != nil {
+		return errorGetRecordsByIds(streamUUID, constants.ErrorInvalidParameterValue, fiber.StatusBadRequest, errors.New("stream is not partitioned"))
+	}
+
+	records, err := svc.sp.GetRecordsByIds(streamPtr.GetUUID(), messageIds)
+	if err != nil {
+		return errorGetRecordsByIds(streamUUID, constants.ErrorCantGetMessagesFromStream, fiber.StatusInternalServerError, err)
+	}
+	return records, nil
+}
+
+func errorGetRecordsByIds(streamUUID types.StreamUUID, errorCode int, httpCode int, err error) ([]interface{}, *apierror.APIError) {
+	return nil, &apierror.APIError{
+		Message:    "cannot get records",
+		Details:    err.Error(),
+		Code:       errorCode,
+		HttpCode:   httpCode,
+		StreamUUID: streamUUID,
+		Err:        err,
+	}
+}
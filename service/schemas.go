@@ -0,0 +1,25 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+)
+
+var errSchemaOnPartition = errors.New("schemas must be registered on the partitioned stream")
+
+func (svc *Service) RegisterStreamSchema(streamPtr *stream.Stream, schema map[string]interface{}) (*types.StreamSchema, bool, error) {
+	// the records are validated before being dispatched to the partitions, therefore the partitions have no schema
+	if streamPtr.IsPartition() {
+		return nil, false, errSchemaOnPartition
+	}
+	return streamPtr.RegisterSchema(schema, svc.sp.SaveStreamSchema)
+}
+
+func (svc *Service) DeleteStreamSchemas(streamPtr *stream.Stream) error {
+	streamUUID := streamPtr.GetUUID()
+	return streamPtr.DeleteSchemas(func() error {
+		return svc.sp.DeleteStreamSchemas(streamUUID)
+	})
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/nbigot/ministream/types"
 	"github.com/nbigot/ministream/web/apierror"
 
+	"github.com/dustin/go-humanize"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/itchyny/gojq"
@@ -24,11 +26,13 @@ import (
 type StreamMap = map[types.StreamUUID]*stream.Stream
 
 type Service struct {
-	Hashmap  StreamMap
-	mapMutex sync.RWMutex
-	logger   *zap.Logger
-	sp       storageprovider.IStorageProvider
-	conf     *config.Config
+	Hashmap          StreamMap
+	mapMutex         sync.RWMutex
+	logger           *zap.Logger
+	sp               storageprovider.IStorageProvider
+	conf             *config.Config
+	derivedStreams   map[types.StreamUUID]*derivedStreamFollower
+	muDerivedStreams sync.Mutex
 }
 
 func (svc *Service) Init() error {
@@ -52,13 +56,66 @@ func (svc *Service) startStream(info *types.StreamInfo) (*stream.Stream, error)
 		return nil, err
 	}
 
+	bulkFlushFrequency, bulkMaxBytes, err := svc.getBulkFlushTriggers()
+	if err != nil {
+		return nil, err
+	}
 	ingestBuffer := buffering.NewStreamIngestBuffer(
-		time.Duration(svc.conf.Streams.BulkFlushFrequency)*time.Second,
+		bulkFlushFrequency,
 		svc.conf.Streams.BulkMaxSize,
+		bulkMaxBytes,
 		svc.conf.Streams.ChannelBufferSize,
 		writer,
 	)
-	s := stream.NewStream(info, ingestBuffer, log.Logger, svc.conf.Streams.LogVerbosity)
+	retentionPolicy, err := svc.getDefaultRetentionPolicy()
+	if err != nil {
+		return nil, err
+	}
+	schemaCompatibility, err := svc.getDefaultSchemaCompatibility()
+	if err != nil {
+		return nil, err
+	}
+	backpressure, err := svc.getDefaultBackpressure()
+	if err != nil {
+		return nil, err
+	}
+	durability, err := svc.getDefaultDurability()
+	if err != nil {
+		return nil, err
+	}
+
+	s := stream.NewStream(
+		info, ingestBuffer, log.Logger, svc.conf.Streams.LogVerbosity,
+		stream.WithIteratorTimeouts(
+			time.Duration(svc.conf.Streams.IteratorIdleTimeout)*time.Second,
+			time.Duration(svc.conf.Streams.IteratorMaxLifetime)*time.Second,
+		),
+		stream.WithRetention(retentionPolicy, time.Duration(svc.conf.Streams.Retention.CheckInterval)*time.Second),
+		stream.WithSchemaCompatibility(schemaCompatibility),
+		stream.WithBackpressure(backpressure),
+		stream.WithDurability(durability, time.Duration(svc.conf.Streams.Durability.Timeout)*time.Second),
+		stream.WithDeadLetterResolver(svc.GetStream),
+	)
+
+	var groups types.ConsumerGroupList
+	if groups, err = svc.sp.LoadConsumerGroups(info.UUID); err != nil {
+		return nil, err
+	}
+	s.SetConsumerGroups(groups)
+
+	var schemas types.StreamSchemaList
+	if schemas, err = svc.sp.LoadStreamSchemas(info.UUID); err != nil {
+		return nil, err
+	}
+	if err = s.SetSchemas(schemas); err != nil {
+		return nil, err
+	}
+
+	var producers types.ProducerList
+	if producers, err = svc.sp.LoadProducers(info.UUID); err != nil {
+		return nil, err
+	}
+	s.SetProducers(producers)
 
 	svc.setStreamMap(s.GetUUID(), s)
 	svc.logger.Info(
@@ -90,11 +147,32 @@ func (svc *Service) LoadStreams() (types.StreamInfoList, error) {
 	}
 	wg.Wait()
 
-	return streamInfoList, errStartStream
+	if errStartStream != nil {
+		return streamInfoList, errStartStream
+	}
+
+	if err = svc.attachPartitions(); err != nil {
+		return streamInfoList, err
+	}
+
+	svc.startDerivedStreams()
+	return streamInfoList, nil
 }
 
 func (svc *Service) CreateStream(properties *types.StreamProperties) (*stream.Stream, error) {
-	if svc.conf.Streams.MaxAllowedStreams > 0 && uint(svc.GetStreamsCount()) >= svc.conf.Streams.MaxAllowedStreams {
+	return svc.CreatePartitionedStream(properties, 0)
+}
+
+func (svc *Service) CreatePartitionedStream(properties *types.StreamProperties, cptPartitions int) (*stream.Stream, error) {
+	return svc.createStream(svc.sp.GenerateNewStreamUuid(), properties, cptPartitions, nil)
+}
+
+// Function filling a stream (and each of its partitions) with records once it is created and before it is started
+type streamPopulator func(info *types.StreamInfo) error
+
+func (svc *Service) createStream(uuid types.StreamUUID, properties *types.StreamProperties, cptPartitions int, populate streamPopulator) (*stream.Stream, error) {
+	// the partitions are counted as streams
+	if svc.conf.Streams.MaxAllowedStreams > 0 && uint(svc.GetStreamsCount()+cptPartitions) >= svc.conf.Streams.MaxAllowedStreams {
 		err := errors.New("cannot create stream, limit reached")
 		svc.logger.Error(
 			"Cannot create stream",
@@ -105,32 +183,75 @@ func (svc *Service) CreateStream(properties *types.StreamProperties) (*stream.St
 		return nil, err
 	}
 
-	uuid := svc.sp.GenerateNewStreamUuid()
+	if cptPartitions < 0 {
+		return nil, fmt.Errorf("invalid partitions count %d", cptPartitions)
+	}
+
+	if _, err := types.NewIngestPipeline(*properties); err != nil {
+		return nil, err
+	}
+	if _, _, err := types.GetDeadLetterStream(*properties); err != nil {
+		return nil, err
+	}
+	if _, err := (types.Backpressure{}).WithProperties(*properties); err != nil {
+		return nil, err
+	}
+	if _, err := types.GetDurability(types.DurabilityNone, *properties); err != nil {
+		return nil, err
+	}
+	if _, err := (types.RetentionPolicy{}).WithProperties(*properties); err != nil {
+		return nil, err
+	}
+	if err := svc.checkDerivedStream(*properties, cptPartitions); err != nil {
+		return nil, err
+	}
 
 	svc.logger.Info(
 		"Create stream",
 		zap.String("topic", "stream"),
 		zap.String("method", "CreateStream"),
 		zap.String("stream.uuid", uuid.String()),
+		zap.Int("partitions", cptPartitions),
 	)
 
 	var err error
 	info := types.NewStreamInfo(uuid)
 	info.Properties = *properties
 
+	var partitions []*stream.Stream
+	if cptPartitions > 0 {
+		// the partitions are created first, therefore a partitioned stream always has all its partitions
+		if partitions, err = svc.createPartitions(info, cptPartitions, populate); err != nil {
+			return nil, err
+		}
+	}
+
 	if err = svc.sp.OnCreateStream(info); err != nil {
 		return nil, err
 	}
+	if populate != nil {
+		if err = populate(info); err != nil {
+			return nil, err
+		}
+	}
 
 	var s *stream.Stream
 	if s, err = svc.startStream(info); err != nil {
 		return s, err
 	}
 
+	if cptPartitions > 0 {
+		s.SetPartitions(partitions)
+	}
+
 	if err = svc.saveStreamCatalog(); err != nil {
 		return s, err
 	}
 
+	if s.IsDerived() {
+		svc.startDerivedStream(s)
+	}
+
 	return s, nil
 }
 
@@ -155,15 +276,34 @@ func (svc *Service) DeleteStream(streamUUID types.StreamUUID) error {
 		return err
 	}
 
-	if err = s.Close(); err != nil {
+	if s.IsPartition() {
+		err = errors.New("cannot delete a partition, delete its partitioned stream instead")
+		svc.logger.Error(
+			"Cannot delete stream",
+			zap.String("topic", "stream"),
+			zap.String("method", "DeleteStream"),
+			zap.String("StreamUUID", streamUUID.String()),
+			zap.Error(err),
+		)
 		return err
 	}
-	if err = svc.sp.DeleteStream(streamUUID); err != nil {
-		return err
+
+	if s.IsDerived() {
+		svc.deleteDerivedStream(s)
 	}
 
-	// delete uuid from hashmap
-	svc.setStreamMap(streamUUID, nil)
+	// delete the partitioned stream first, therefore a failure can't leave a partitioned stream without its partitions
+	for _, streamToDelete := range append([]*stream.Stream{s}, s.GetPartitions()...) {
+		if err = streamToDelete.Close(); err != nil {
+			return err
+		}
+		if err = svc.sp.DeleteStream(streamToDelete.GetUUID()); err != nil {
+			return err
+		}
+
+		// delete uuid from hashmap
+		svc.setStreamMap(streamToDelete.GetUUID(), nil)
+	}
 
 	if err = svc.saveStreamCatalog(); err != nil {
 		return err
@@ -194,8 +334,11 @@ func (svc *Service) GetStreamsUUIDs() types.StreamUUIDList {
 	svc.mapMutex.RLock()
 
 	uuids := make([]types.StreamUUID, 0, len(svc.Hashmap))
-	for k := range svc.Hashmap {
-		uuids = append(uuids, k)
+	for k, s := range svc.Hashmap {
+		// the partitions are reachable through their partitioned stream
+		if !s.IsPartition() {
+			uuids = append(uuids, k)
+		}
 	}
 
 	svc.mapMutex.RUnlock()
@@ -203,13 +346,12 @@ func (svc *Service) GetStreamsUUIDs() types.StreamUUIDList {
 }
 
 func (svc *Service) GetStreamsUUIDsFiltered(jqFilter ...*gojq.Query) types.StreamUUIDList {
-	svc.mapMutex.Lock()
-	defer svc.mapMutex.Unlock()
+	svc.mapMutex.RLock()
+	defer svc.mapMutex.RUnlock()
 
 	uuids := make([]types.StreamUUID, 0, len(svc.Hashmap))
-	for uuid := range svc.Hashmap {
-		s := svc.GetStream(uuid)
-		if s != nil {
+	for uuid, s := range svc.Hashmap {
+		if s != nil && !s.IsPartition() {
 			match_filters := true
 			for _, jq := range jqFilter {
 				if jq != nil {
@@ -236,7 +378,7 @@ func (svc *Service) GetStreamsFiltered(jqFilter ...*gojq.Query) *[]*stream.Strea
 	// TODO: rename cptMessages into cptRecords
 	rows := make([]*stream.Stream, 0, len(svc.Hashmap))
 	for _, s := range svc.Hashmap {
-		if s != nil {
+		if s != nil && !s.IsPartition() {
 			match_filters := true
 			for _, jq := range jqFilter {
 				if jq != nil {
@@ -256,24 +398,104 @@ func (svc *Service) GetStreamsFiltered(jqFilter ...*gojq.Query) *[]*stream.Strea
 }
 
 func (svc *Service) CreateRecordsIterator(streamPtr *stream.Stream, req *types.StreamIteratorRequest) (types.StreamIteratorUUID, *apierror.APIError) {
+	return svc.createRecordsIterator(streamPtr, req, false)
+}
+
+func (svc *Service) createInternalRecordsIterator(streamPtr *stream.Stream, req *types.StreamIteratorRequest) (types.StreamIteratorUUID, *apierror.APIError) {
+	// iterator used by the server to scan a stream (query, export, clone, derived stream),
+	// it doesn't take the room of the iterators of the clients
+	return svc.createRecordsIterator(streamPtr, req, true)
+}
+
+func (svc *Service) createRecordsIterator(streamPtr *stream.Stream, req *types.StreamIteratorRequest, internal bool) (types.StreamIteratorUUID, *apierror.APIError) {
 	var err error
 	var iter *stream.StreamIterator
 	var handler types.IStreamIteratorHandler
 	streamUUID := streamPtr.GetUUID()
 
-	// check limit the number of iterators for the stream
-	if svc.conf.Streams.MaxIteratorsPerStream > 0 && streamPtr.GetIteratorsCount() > svc.conf.Streams.MaxIteratorsPerStream {
-		return errorCreateRecordsIterator(streamUUID, constants.ErrorCantCreateRecordsIterator, errors.New("too many iterators opened for this stream"))
+	// check limit the number of iterators for the stream (expired iterators are not counted)
+	if svc.conf.Streams.MaxIteratorsPerStream > 0 && !internal {
+		streamPtr.ReapExpiredIterators()
+		if streamPtr.GetClientIteratorsCount() >= svc.conf.Streams.MaxIteratorsPerStream {
+			return errorCreateRecordsIterator(streamUUID, constants.ErrorCantCreateRecordsIterator, errors.New("too many iterators opened for this stream"))
+		}
+	}
+
+	if err = req.CheckEnd(); err != nil {
+		return errorCreateRecordsIterator(streamUUID, constants.ErrorInvalidCreateRecordsIteratorRequest, err)
+	}
+	if err = req.CheckDirection(); err != nil {
+		return errorCreateRecordsIterator(streamUUID, constants.ErrorInvalidCreateRecordsIteratorRequest, err)
+	}
+
+	if req.ConsumerGroup != "" {
+		if !types.IsValidConsumerGroupName(req.ConsumerGroup) {
+			return errorCreateRecordsIterator(streamUUID, constants.ErrorInvalidConsumerGroupName, fmt.Errorf("invalid consumer group name: %s", req.ConsumerGroup))
+		}
+		if group, found := streamPtr.GetConsumerGroup(req.ConsumerGroup); found {
+			// resume right after the message committed by the consumer group,
+			// the position given in the request is used only when the group has never committed
+			groupReq := *req
+			if group.CommittedMsgId == 0 {
+				groupReq.IteratorType = "FIRST_MESSAGE"
+			} else if firstMsgId := streamPtr.GetFirstReadableMsgId(); group.CommittedMsgId+1 < firstMsgId {
+				// the records not yet consumed by the group have been removed by the retention policy
+				svc.logger.Warn(
+					"Consumer group committed message is no longer available",
+					zap.String("topic", "stream"),
+					zap.String("method", "CreateRecordsIterator"),
+					zap.String("stream.uuid", streamUUID.String()),
+					zap.String("consumerGroup", group.Name),
+					zap.Uint64("committedMsgId", group.CommittedMsgId),
+					zap.Uint64("firstMsgId", firstMsgId),
+				)
+				groupReq.IteratorType = "FIRST_MESSAGE"
+			} else {
+				groupReq.IteratorType = "AFTER_MESSAGE_ID"
+				groupReq.MessageId = group.CommittedMsgId
+			}
+			req = &groupReq
+		}
 	}
 
 	iteratorUUID := uuid.New()
-	if handler, err = svc.sp.NewStreamIteratorHandler(streamUUID, iteratorUUID); err != nil {
-		return errorCreateRecordsIterator(streamUUID, constants.ErrorCantCreateRecordsIterator, err)
+	rangeSource := streamPtr // stream (or partition) read by the iterator
+	if streamPtr.IsPartitioned() {
+		if handler, req, err = svc.newPartitionsIteratorHandler(streamPtr, req, iteratorUUID); err != nil {
+			return errorCreateRecordsIterator(streamUUID, constants.ErrorInvalidCreateRecordsIteratorRequest, err)
+		}
+		if req.Partition != nil {
+			rangeSource, _ = streamPtr.GetPartition(*req.Partition)
+		}
+	} else {
+		if req.Partition != nil {
+			return errorCreateRecordsIterator(streamUUID, constants.ErrorInvalidCreateRecordsIteratorRequest, errors.New("stream is not partitioned"))
+		}
+		if req, err = checkIteratorPosition(streamPtr, req); err != nil {
+			return errorCreateRecordsIterator(streamUUID, constants.ErrorMessageIdNoLongerAvailable, err)
+		}
+		if handler, err = svc.sp.NewStreamIteratorHandler(streamUUID, iteratorUUID); err != nil {
+			return errorCreateRecordsIterator(streamUUID, constants.ErrorCantCreateRecordsIterator, err)
+		}
+	}
+
+	if req.Snapshot {
+		// the iterator stops at the last message put before its creation
+		snapshotReq := *req
+		lastMsgId := rangeSource.GetLastIngestedMsgId()
+		snapshotReq.UntilMessageId = &lastMsgId
+		req = &snapshotReq
 	}
 
 	if iter, err = stream.NewStreamIterator(streamUUID, iteratorUUID, req, handler, svc.GetLogger()); err != nil {
 		return errorCreateRecordsIterator(streamUUID, constants.ErrorInvalidCreateRecordsIteratorRequest, err)
 	}
+	if req.HasEnd() {
+		iter.SetRangeSource(rangeSource)
+	}
+	if internal {
+		iter.SetInternal()
+	}
 
 	if err = streamPtr.AddIterator(iter); err != nil {
 		return errorCreateRecordsIterator(streamUUID, constants.ErrorCantCreateRecordsIterator, err)
@@ -286,6 +508,129 @@ func (svc *Service) CreateRecordsIterator(streamPtr *stream.Stream, req *types.S
 	return iteratorUUID, nil
 }
 
+func checkIteratorPosition(streamPtr *stream.Stream, req *types.StreamIteratorRequest) (*types.StreamIteratorRequest, error) {
+	// the records before the first readable message may have been removed by the retention policy
+	firstMsgId := streamPtr.GetFirstReadableMsgId()
+	switch {
+	case req.IsBackward():
+		// reading backward, AFTER_MESSAGE_ID starts at the message before the given message id
+		if (req.IteratorType == "AT_MESSAGE_ID" || req.IteratorType == "AFTER_MESSAGE_ID") && req.MessageId < firstMsgId {
+			return req, fmt.Errorf("message id %d is no longer available, first message id is %d", req.MessageId, firstMsgId)
+		}
+	case req.IteratorType == "AT_MESSAGE_ID" && req.MessageId < firstMsgId:
+		return req, fmt.Errorf("message id %d is no longer available, first message id is %d", req.MessageId, firstMsgId)
+	case req.IteratorType == "AFTER_MESSAGE_ID" && req.MessageId+1 == firstMsgId:
+		// nothing is missing, the iterator starts at the head of the stream
+		headReq := *req
+		headReq.IteratorType = "FIRST_MESSAGE"
+		return &headReq, nil
+	case req.IteratorType == "AFTER_MESSAGE_ID" && req.MessageId+1 < firstMsgId:
+		return req, fmt.Errorf("message id %d is no longer available, first message id is %d", req.MessageId, firstMsgId)
+	}
+	return req, nil
+}
+
+func (svc *Service) getDefaultSchemaCompatibility() (string, error) {
+	if svc.conf.Streams.Schemas.DefaultCompatibility == "" {
+		return types.SchemaCompatibilityBackward, nil
+	}
+	return types.ParseSchemaCompatibility(svc.conf.Streams.Schemas.DefaultCompatibility)
+}
+
+func (svc *Service) getBulkFlushTriggers() (time.Duration, uint64, error) {
+	// returns the maximum time the records are buffered before being saved and the maximum size of the buffered records
+	bulkFlushFrequency := time.Duration(svc.conf.Streams.BulkFlushFrequency) * time.Second
+	if svc.conf.Streams.BulkFlushInterval != "" {
+		interval, err := time.ParseDuration(svc.conf.Streams.BulkFlushInterval)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid bulk flush interval: %w", err)
+		}
+		bulkFlushFrequency = interval
+	}
+
+	var bulkMaxBytes uint64
+	if maxBytes := svc.conf.Streams.BulkMaxBytes; maxBytes != "" && maxBytes != "0" {
+		var err error
+		if bulkMaxBytes, err = humanize.ParseBytes(maxBytes); err != nil {
+			return 0, 0, fmt.Errorf("invalid bulk max bytes: %w", err)
+		}
+	}
+	return bulkFlushFrequency, bulkMaxBytes, nil
+}
+
+func (svc *Service) getDefaultDurability() (string, error) {
+	if svc.conf.Streams.Durability.Level == "" {
+		return types.DurabilityNone, nil
+	}
+	return types.ParseDurability(svc.conf.Streams.Durability.Level)
+}
+
+func (svc *Service) getDefaultBackpressure() (types.Backpressure, error) {
+	backpressure := types.Backpressure{
+		Mode:    types.BackpressureModeBlock,
+		MaxWait: time.Duration(svc.conf.Streams.Backpressure.MaxWait) * time.Second,
+	}
+	if svc.conf.Streams.Backpressure.Mode != "" {
+		mode, err := types.ParseBackpressureMode(svc.conf.Streams.Backpressure.Mode)
+		if err != nil {
+			return backpressure, err
+		}
+		backpressure.Mode = mode
+	}
+	return backpressure, nil
+}
+
+func (svc *Service) getDefaultRetentionPolicy() (types.RetentionPolicy, error) {
+	policy := types.RetentionPolicy{
+		MaxAge:     time.Duration(svc.conf.Streams.Retention.MaxAge) * time.Second,
+		MaxRecords: svc.conf.Streams.Retention.MaxRecords,
+	}
+	maxSize := svc.conf.Streams.Retention.MaxSize
+	if maxSize != "" && maxSize != "0" {
+		maxBytes, err := humanize.ParseBytes(maxSize)
+		if err != nil {
+			return policy, fmt.Errorf("invalid retention max size: %w", err)
+		}
+		policy.MaxBytes = maxBytes
+	}
+	return policy, nil
+}
+
+func (svc *Service) CommitConsumerGroup(streamPtr *stream.Stream, name string, messageId types.MessageId) (*types.ConsumerGroup, error) {
+	if streamPtr.IsPartitioned() {
+		return nil, errConsumerGroupOnPartitionedStream
+	}
+	return streamPtr.CommitConsumerGroup(name, messageId, svc.sp.SaveConsumerGroup)
+}
+
+func (svc *Service) CommitIteratorConsumerGroup(streamPtr *stream.Stream, iteratorUUID types.StreamIteratorUUID) (*types.ConsumerGroup, error) {
+	it, err := streamPtr.GetIterator(iteratorUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	name := it.GetConsumerGroup()
+	if name == "" {
+		return nil, errors.New("iterator is not attached to a consumer group")
+	}
+
+	return streamPtr.CommitConsumerGroup(name, it.LastRecordIdRead, svc.sp.SaveConsumerGroup)
+}
+
+func (svc *Service) ResetConsumerGroup(streamPtr *stream.Stream, name string, messageId types.MessageId) (*types.ConsumerGroup, error) {
+	if streamPtr.IsPartitioned() {
+		return nil, errConsumerGroupOnPartitionedStream
+	}
+	return streamPtr.ResetConsumerGroup(name, messageId, svc.sp.SaveConsumerGroup)
+}
+
+func (svc *Service) DeleteConsumerGroup(streamPtr *stream.Stream, name string) error {
+	streamUUID := streamPtr.GetUUID()
+	return streamPtr.DeleteConsumerGroup(name, func(name string) error {
+		return svc.sp.DeleteConsumerGroup(streamUUID, name)
+	})
+}
+
 func (svc *Service) GetLogger() *zap.Logger {
 	return svc.logger
 }
@@ -294,11 +639,22 @@ func (svc *Service) BuildIndex(streamUUID types.StreamUUID) (interface{}, error)
 	return svc.sp.BuildIndex(streamUUID)
 }
 
+func (svc *Service) ReencryptStream(streamPtr *stream.Stream) (types.Size64, error) {
+	return streamPtr.Reencrypt()
+}
+
+func (svc *Service) ScrubStream(streamPtr *stream.Stream) (*types.StreamScrubReport, error) {
+	return streamPtr.Scrub()
+}
+
 func (svc *Service) Finalize() {
 	svc.Stop()
 }
 
 func (svc *Service) Stop() {
+	// the derived streams stop first, therefore they can't read or write streams being closed
+	svc.stopDerivedStreams()
+
 	svc.mapMutex.RLock()
 	defer svc.mapMutex.RUnlock()
 
@@ -369,7 +725,7 @@ func NewStreamService(logger *zap.Logger, conf *config.Config) (*Service, error)
 	if err != nil {
 		return nil, err
 	}
-	return &Service{logger: logger, conf: conf, sp: sp, Hashmap: make(StreamMap)}, nil
+	return &Service{logger: logger, conf: conf, sp: sp, Hashmap: make(StreamMap), derivedStreams: make(map[types.StreamUUID]*derivedStreamFollower)}, nil
 }
 
 func errorCreateRecordsIterator(streamUUID uuid.UUID, errorCode int, err error) (types.StreamIteratorUUID, *apierror.APIError) {
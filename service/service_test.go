@@ -1,15 +1,25 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/nbigot/ministream/config"
+	"github.com/nbigot/ministream/log"
+	"github.com/nbigot/ministream/storageprovider/jsonfileprovider"
 	"github.com/nbigot/ministream/storageprovider/registry"
 	"github.com/nbigot/ministream/stream"
 	"github.com/nbigot/ministream/types"
 
+	"github.com/goccy/go-json"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 )
 
@@ -41,6 +51,55 @@ storage:
 	return &conf
 }
 
+func newTestService(t *testing.T, tweak func(conf *config.Config)) *Service {
+	// the service is stopped at the end of the test
+	t.Helper()
+	log.Logger = zap.NewNop()
+	conf := initConfig()
+	if tweak != nil {
+		tweak(conf)
+	}
+	svc, err := NewStreamService(zap.NewNop(), conf)
+	if err != nil {
+		t.Fatalf("error while creating service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("error while initializing service: %v", err)
+	}
+	t.Cleanup(svc.Stop)
+	return svc
+}
+
+func withRecordsSavedOnDemand(conf *config.Config) {
+	// the records are saved when a test waits for their durability, not by the periodic flush
+	conf.Streams.BulkFlushFrequency = 60
+	conf.Streams.BulkMaxSize = 1000
+	conf.Streams.ChannelBufferSize = 100
+	conf.Streams.Durability.Timeout = 5
+}
+
+func newRecords(from int, to int) []interface{} {
+	// the record n is {"n": n}
+	records := make([]interface{}, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		records = append(records, map[string]interface{}{"n": n})
+	}
+	return records
+}
+
+func putRecords(t *testing.T, s *stream.Stream, records []interface{}, headers []types.RecordHeaders) []types.MessageId {
+	// put the records and wait until they are saved
+	t.Helper()
+	msgIds, _, err := s.PutMessages(nil, records, headers)
+	if err != nil {
+		t.Fatalf("error while putting records: %v", err)
+	}
+	if err = s.WaitForDurability(context.Background(), types.DurabilityFlushed, msgIds); err != nil {
+		t.Fatalf("error while saving records: %v", err)
+	}
+	return msgIds
+}
+
 func BenchmarkSetStreamMap(b *testing.B) {
 	svc, err := NewStreamService(nil, initConfig())
 	if err != nil {
@@ -62,6 +121,246 @@ func BenchmarkSetStreamMap(b *testing.B) {
 	}
 }
 
+func TestIteratorExpiry(t *testing.T) {
+	newStream := func(idleTimeout int, maxLifetime int) (*Service, *stream.Stream) {
+		svc := newTestService(t, func(conf *config.Config) {
+			conf.Streams.IteratorIdleTimeout = idleTimeout
+			conf.Streams.IteratorMaxLifetime = maxLifetime
+		})
+		s, err := svc.CreateStream(&types.StreamProperties{})
+		if err != nil {
+			t.Fatalf("error while creating stream: %v", err)
+		}
+		return svc, s
+	}
+	createIterator := func(svc *Service, s *stream.Stream) types.StreamIteratorUUID {
+		itUUID, apiErr := svc.CreateRecordsIterator(s, &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"})
+		if apiErr != nil {
+			t.Fatalf("error while creating iterator: %v", apiErr)
+		}
+		return itUUID
+	}
+	read := func(s *stream.Stream, itUUID types.StreamIteratorUUID) error {
+		_, err := s.GetRecords(context.Background(), itUUID, 10)
+		return err
+	}
+
+	// an iterator not read during the idle timeout is deleted, reading it keeps it alive
+	svc, s := newStream(1, 0)
+	itIdle := createIterator(svc, s)
+	itRead := createIterator(svc, s)
+	for i := 0; i < 3; i++ {
+		time.Sleep(400 * time.Millisecond)
+		if err := read(s, itRead); err != nil {
+			t.Fatalf("error while reading iterator: %v", err)
+		}
+	}
+	// the reaper of the stream may have already deleted the iterator
+	s.ReapExpiredIterators()
+	if err := read(s, itIdle); err == nil {
+		t.Fatalf("expected the idle iterator to be deleted")
+	}
+	if err := read(s, itRead); err != nil {
+		t.Fatalf("expected the iterator read to be kept: %v", err)
+	}
+
+	// an iterator is deleted after its max lifetime even if it is read
+	svc, s = newStream(0, 1)
+	itUUID := createIterator(svc, s)
+	for i := 0; i < 3; i++ {
+		time.Sleep(400 * time.Millisecond)
+		_ = read(s, itUUID)
+	}
+	s.ReapExpiredIterators()
+	if err := read(s, itUUID); err == nil {
+		t.Fatalf("expected the iterator to be deleted after its max lifetime")
+	}
+}
+
+func TestMaxIteratorsPerStream(t *testing.T) {
+	svc := newTestService(t, func(conf *config.Config) {
+		conf.Streams.MaxIteratorsPerStream = 2
+		conf.Streams.IteratorIdleTimeout = 1
+	})
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+
+	req := types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}
+	itUUID, apiErr := svc.CreateRecordsIterator(s, &req)
+	if apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr)
+	}
+	if _, apiErr = svc.CreateRecordsIterator(s, &req); apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr)
+	}
+	if _, apiErr = svc.CreateRecordsIterator(s, &req); apiErr == nil {
+		t.Fatalf("expected too many iterators")
+	}
+
+	// a closed iterator frees its slot
+	if err = s.CloseIterator(itUUID); err != nil {
+		t.Fatalf("error while closing iterator: %v", err)
+	}
+	if _, apiErr = svc.CreateRecordsIterator(s, &req); apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr)
+	}
+
+	// the expired iterators are not counted
+	time.Sleep(1100 * time.Millisecond)
+	if _, apiErr = svc.CreateRecordsIterator(s, &req); apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr)
+	}
+}
+
+func TestMaxIteratorsPerStreamInternalIterators(t *testing.T) {
+	svc := newTestService(t, func(conf *config.Config) {
+		conf.Streams.MaxIteratorsPerStream = 1
+	})
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	putRecords(t, s, []interface{}{map[string]interface{}{"n": 1}, map[string]interface{}{"n": 2}}, nil)
+
+	req := types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}
+	itUUID, apiErr := svc.CreateRecordsIterator(s, &req)
+	if apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr)
+	}
+
+	// the scan of a query doesn't take the room of the iterators of the clients
+	query := types.StreamQueryRequest{Range: req, Aggregations: []*types.QueryAggregation{{Name: "count", Op: types.QueryOpCount}}}
+	response, apiErr := svc.QueryStream(context.Background(), s, &query)
+	if apiErr != nil {
+		t.Fatalf("error while querying stream: %v", apiErr)
+	}
+	if response.RecordsScanned != 2 {
+		t.Fatalf("expected 2 records scanned, got %d", response.RecordsScanned)
+	}
+	if _, apiErr = svc.createInternalRecordsIterator(s, &req); apiErr != nil {
+		t.Fatalf("error while creating internal iterator: %v", apiErr)
+	}
+	if _, apiErr = svc.CreateRecordsIterator(s, &req); apiErr == nil {
+		t.Fatalf("expected too many iterators")
+	}
+
+	// an internal iterator doesn't block the iterators of the clients
+	if err = s.CloseIterator(itUUID); err != nil {
+		t.Fatalf("error while closing iterator: %v", err)
+	}
+	if _, apiErr = svc.CreateRecordsIterator(s, &req); apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr)
+	}
+}
+
+func TestCloseIteratorWhileReading(t *testing.T) {
+	svc := newTestService(t, nil)
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+
+	req := types.StreamIteratorRequest{IteratorType: "AFTER_LAST_MESSAGE", MaxWaitTimeSeconds: 30}
+	itUUID, apiErr := svc.CreateRecordsIterator(s, &req)
+	if apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.GetRecords(context.Background(), itUUID, 10)
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// the iterator waiting for records is closed by its reader, the wait ends at once
+	if err = s.CloseIterator(itUUID); err != nil {
+		t.Fatalf("error while closing iterator: %v", err)
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("error while reading iterator: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the wait of the closed iterator has not ended")
+	}
+	if _, err = s.GetRecords(context.Background(), itUUID, 10); err == nil {
+		t.Fatalf("expected the iterator to be deleted")
+	}
+}
+
+func TestLongPolling(t *testing.T) {
+	svc := newTestService(t, withRecordsSavedOnDemand)
+
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	createIterator := func(maxWaitTimeSeconds int) types.StreamIteratorUUID {
+		req := types.StreamIteratorRequest{IteratorType: "AFTER_LAST_MESSAGE", MaxWaitTimeSeconds: maxWaitTimeSeconds}
+		itUUID, apiErr := svc.CreateRecordsIterator(s, &req)
+		if apiErr != nil {
+			t.Fatalf("error while creating iterator: %v", apiErr)
+		}
+		return itUUID
+	}
+	type result struct {
+		response *stream.GetStreamRecordsResponse
+		err      error
+		duration time.Duration
+	}
+	getRecords := func(ctx context.Context, itUUID types.StreamIteratorUUID) <-chan result {
+		done := make(chan result, 1)
+		go func() {
+			startTime := time.Now()
+			response, err := s.GetRecords(ctx, itUUID, 100)
+			done <- result{response: response, err: err, duration: time.Since(startTime)}
+		}()
+		return done
+	}
+	waitForResult := func(done <-chan result, expectedCount int64) result {
+		select {
+		case r := <-done:
+			if r.err != nil || r.response.Count != expectedCount {
+				t.Fatalf("expected %d records, got %+v (%v)", expectedCount, r.response, r.err)
+			}
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the wait has not ended")
+		}
+		return result{}
+	}
+
+	// the records saved wake up the iterator waiting for them
+	done := getRecords(context.Background(), createIterator(30))
+	time.Sleep(100 * time.Millisecond)
+	putRecords(t, s, newRecords(1, 2), nil)
+	waitForResult(done, 2)
+
+	// an empty batch is returned when the wait time elapses
+	r := waitForResult(getRecords(context.Background(), createIterator(1)), 0)
+	if r.duration < time.Second {
+		t.Fatalf("expected to wait 1s, waited %s", r.duration)
+	}
+
+	// the request cancelled ends the wait
+	ctx, cancel := context.WithCancel(context.Background())
+	done = getRecords(ctx, createIterator(30))
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	waitForResult(done, 0)
+
+	// the stream stopping ends the wait
+	done = getRecords(context.Background(), createIterator(30))
+	time.Sleep(100 * time.Millisecond)
+	if err = s.Close(); err != nil {
+		t.Fatalf("error while closing stream: %v", err)
+	}
+	waitForResult(done, 0)
+}
+
 func TestGetStream(t *testing.T) {
 	svc, err := NewStreamService(nil, initConfig())
 	if err != nil {
@@ -79,3 +378,1370 @@ func TestGetStream(t *testing.T) {
 		t.Fatalf("wrong value")
 	}
 }
+
+func TestConsumerGroup(t *testing.T) {
+	dataDirectory := t.TempDir()
+	withJSONFile := func(conf *config.Config) {
+		withRecordsSavedOnDemand(conf)
+		conf.Storage.Type = "JSONFile"
+		conf.Storage.JSONFile.DataDirectory = dataDirectory
+	}
+	svc := newTestService(t, withJSONFile)
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	streamUUID := s.GetUUID()
+	putRecords(t, s, newRecords(1, 10), nil)
+
+	restart := func() {
+		// the consumer groups are loaded back from the storage
+		svc.Stop()
+		svc = newTestService(t, withJSONFile)
+		if _, err = svc.LoadStreams(); err != nil {
+			t.Fatalf("error while loading streams: %v", err)
+		}
+		s = svc.GetStream(streamUUID)
+	}
+	readGroup := func(iteratorType string, maxRecords uint) (types.StreamIteratorUUID, []interface{}) {
+		// read the records of the consumer group "g" (the iterator type is used only if the group has never committed)
+		t.Helper()
+		itUUID, apiErr := svc.CreateRecordsIterator(s, &types.StreamIteratorRequest{IteratorType: iteratorType, ConsumerGroup: "g"})
+		if apiErr != nil {
+			t.Fatalf("error while creating iterator: %v", apiErr.Details)
+		}
+		response, err := s.GetRecords(nil, itUUID, maxRecords)
+		if err != nil {
+			t.Fatalf("error while getting records: %v", err)
+		}
+		ns := make([]interface{}, 0, len(response.Records))
+		for _, record := range response.Records {
+			ns = append(ns, record.(map[string]interface{})["m"].(map[string]interface{})["n"])
+		}
+		return itUUID, ns
+	}
+	expectRecords := func(ns []interface{}, from int, to int) {
+		t.Helper()
+		if len(ns) != to-from+1 {
+			t.Fatalf("expected the records %d to %d, got %v", from, to, ns)
+		}
+		for i, n := range ns {
+			if n != float64(from+i) {
+				t.Fatalf("expected the records %d to %d, got %v", from, to, ns)
+			}
+		}
+	}
+
+	// the iterator commits the last record it has read
+	itUUID, ns := readGroup("FIRST_MESSAGE", 4)
+	expectRecords(ns, 1, 4)
+	if group, err := svc.CommitIteratorConsumerGroup(s, itUUID); err != nil || group.CommittedMsgId != 4 {
+		t.Fatalf("expected the message 4 committed, got %+v (%v)", group, err)
+	}
+
+	// a commit only moves the position forward, up to the last readable record
+	for _, msgId := range []types.MessageId{0, 2, 11} {
+		if _, err = svc.CommitConsumerGroup(s, "g", msgId); err == nil {
+			t.Fatalf("expected the commit of message %d to be rejected", msgId)
+		}
+	}
+	if group, err := svc.CommitConsumerGroup(s, "g", 6); err != nil || group.CommittedMsgId != 6 {
+		t.Fatalf("expected the message 6 committed, got %+v (%v)", group, err)
+	}
+
+	// the group resumes right after the committed record, after a restart too
+	restart()
+	_, ns = readGroup("AFTER_LAST_MESSAGE", 100)
+	expectRecords(ns, 7, 10)
+
+	// a reset moves the position anywhere, 0 restarts from the first record
+	if _, err = svc.ResetConsumerGroup(s, "unknown", 0); !errors.Is(err, stream.ErrConsumerGroupNotFound) {
+		t.Fatalf("expected consumer group not found, got %v", err)
+	}
+	if _, err = svc.ResetConsumerGroup(s, "g", 11); err == nil {
+		t.Fatalf("expected the reset beyond the last record to be rejected")
+	}
+	if group, err := svc.ResetConsumerGroup(s, "g", 0); err != nil || group.CommittedMsgId != 0 {
+		t.Fatalf("expected the group reset, got %+v (%v)", group, err)
+	}
+	restart()
+	_, ns = readGroup("AFTER_LAST_MESSAGE", 100)
+	expectRecords(ns, 1, 10)
+
+	// a deleted group is removed from the storage, a new group with the same name starts from the request
+	if err = svc.DeleteConsumerGroup(s, "g"); err != nil {
+		t.Fatalf("error while deleting consumer group: %v", err)
+	}
+	if err = svc.DeleteConsumerGroup(s, "g"); !errors.Is(err, stream.ErrConsumerGroupNotFound) {
+		t.Fatalf("expected consumer group not found, got %v", err)
+	}
+	restart()
+	if group, found := s.GetConsumerGroup("g"); found {
+		t.Fatalf("expected the consumer group deleted, got %+v", group)
+	}
+	_, ns = readGroup("AFTER_LAST_MESSAGE", 100)
+	expectRecords(ns, 1, 0)
+}
+
+func TestPartitionedStream(t *testing.T) {
+	svc := newTestService(t, nil)
+
+	s, err := svc.CreatePartitionedStream(&types.StreamProperties{}, 3)
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	if s.GetPartitionsCount() != 3 || len(s.GetInfo().Partitioning.Partitions) != 3 {
+		t.Fatalf("expected 3 partitions")
+	}
+	if uuids := svc.GetStreamsUUIDs(); len(uuids) != 1 || uuids[0] != s.GetUUID() {
+		t.Fatalf("partitions must not be listed, got %v", uuids)
+	}
+	if _, _, err = s.PutMessage(nil, map[string]interface{}{"n": 0}, nil); err == nil {
+		t.Fatalf("records must be put into a partition")
+	}
+
+	// the records having the same key go to the same partition
+	partitionA, rankA := s.GetPartitionForKey("a")
+	partitionB, rankB := s.GetPartitionForKey("a")
+	if partitionA != partitionB || rankA != rankB || partitionA.GetParent() != s {
+		t.Fatalf("expected the same partition for the same key")
+	}
+	for i := 0; i < 6; i++ {
+		// the message may have an attribute named like the partition of the record envelope
+		partition, _ := s.GetPartitionForKey("")
+		putRecords(t, partition, []interface{}{map[string]interface{}{"n": i, "p": "message"}}, nil)
+	}
+
+	// read all the partitions
+	itUUID, apiErr := svc.CreateRecordsIterator(s, &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"})
+	if apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr.Details)
+	}
+	response, err := s.GetRecords(nil, itUUID, 100)
+	if err != nil || response.Count != 6 {
+		t.Fatalf("expected 6 records, got %d (%v)", response.Count, err)
+	}
+	for _, record := range response.Records {
+		envelope := record.(map[string]interface{})
+		if _, found := envelope[stream.RecordPartitionKey].(int); !found {
+			t.Fatalf("expected the partition of the record %v", record)
+		}
+		if envelope["m"].(map[string]interface{})["p"] != "message" {
+			t.Fatalf("the message of the record must not be modified %v", record)
+		}
+	}
+
+	// read a single partition
+	partition := 1
+	itUUID, apiErr = svc.CreateRecordsIterator(s, &types.StreamIteratorRequest{IteratorType: "AFTER_MESSAGE_ID", MessageId: 1, Partition: &partition})
+	if apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr.Details)
+	}
+	if response, err = s.GetRecords(nil, itUUID, 100); err != nil || response.Count != 1 || response.LastRecordIdRead != 2 {
+		t.Fatalf("expected record 2 of partition 1, got %d records (%v)", response.Count, err)
+	}
+	if _, apiErr = svc.CreateRecordsIterator(s, &types.StreamIteratorRequest{IteratorType: "AT_MESSAGE_ID", MessageId: 1}); apiErr == nil {
+		t.Fatalf("a message id requires a partition")
+	}
+
+	partitionUUID := s.GetPartitions()[0].GetUUID()
+	if err = svc.DeleteStream(partitionUUID); err == nil {
+		t.Fatalf("a partition must not be deleted")
+	}
+	if err = svc.DeleteStream(s.GetUUID()); err != nil {
+		t.Fatalf("error while deleting stream: %v", err)
+	}
+	if svc.GetStream(partitionUUID) != nil || svc.GetStreamsCount() != 0 {
+		t.Fatalf("the partitions must be deleted with the stream")
+	}
+}
+
+func TestStreamSchemas(t *testing.T) {
+	svc := newTestService(t, nil)
+
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+
+	records := []interface{}{map[string]interface{}{"id": 1.0}, map[string]interface{}{"id": "2", "name": 3.0}}
+	if version, validationErrors, err := s.ValidateRecords(context.Background(), 0, records, nil); err != nil || version != 0 || len(validationErrors) != 0 {
+		t.Fatalf("records must not be validated without schema")
+	}
+
+	properties := func() map[string]interface{} {
+		return map[string]interface{}{"id": map[string]interface{}{"type": "integer"}, "name": map[string]interface{}{"type": "string"}}
+	}
+	schemaV1 := map[string]interface{}{"type": "object", "required": []interface{}{"id"}, "properties": properties()}
+	schema, created, err := svc.RegisterStreamSchema(s, schemaV1)
+	if err != nil || !created || schema.Version != 1 {
+		t.Fatalf("error while registering schema: %v", err)
+	}
+	if schema, created, err = svc.RegisterStreamSchema(s, schemaV1); err != nil || created || schema.Version != 1 {
+		t.Fatalf("registering the latest schema again must not create a new version")
+	}
+
+	version, validationErrors, err := s.ValidateRecords(context.Background(), 0, records, nil)
+	if err != nil || version != 1 {
+		t.Fatalf("error while validating records: %v", err)
+	}
+	if len(validationErrors) != 2 || validationErrors[0].FailedField != "records[1]/id" || validationErrors[1].FailedField != "records[1]/name" {
+		t.Fatalf("unexpected validation errors %+v", validationErrors)
+	}
+
+	// backward compatibility (default): a new required property is rejected, a new optional property is accepted
+	schemaV2 := map[string]interface{}{"type": "object", "required": []interface{}{"id", "email"}, "properties": properties()}
+	schemaV2["properties"].(map[string]interface{})["email"] = map[string]interface{}{"type": "string"}
+	var incompatibleErr *stream.IncompatibleSchemaError
+	if _, _, err = svc.RegisterStreamSchema(s, schemaV2); !errors.As(err, &incompatibleErr) {
+		t.Fatalf("expected incompatible schema, got %v", err)
+	}
+	if len(incompatibleErr.Reasons) != 1 || incompatibleErr.Reasons[0] != "/email: property is required but may be missing" {
+		t.Fatalf("unexpected incompatibility reasons %v", incompatibleErr.Reasons)
+	}
+	schemaV2["required"] = []interface{}{"id"}
+	if schema, _, err = svc.RegisterStreamSchema(s, schemaV2); err != nil || schema.Version != 2 {
+		t.Fatalf("error while registering schema: %v", err)
+	}
+
+	// full compatibility: the previous version must also accept the records of the new version
+	s.GetInfo().UpdateProperties(&types.StreamProperties{types.SchemaPropertyCompatibility: "full"})
+	schemaV3 := map[string]interface{}{"type": "object", "required": []interface{}{"id"}, "properties": properties()}
+	schemaV3["properties"].(map[string]interface{})["email"] = map[string]interface{}{"type": "string", "maxLength": 64.0}
+	if _, _, err = svc.RegisterStreamSchema(s, schemaV3); !errors.As(err, &incompatibleErr) {
+		t.Fatalf("expected incompatible schema, got %v", err)
+	}
+	s.GetInfo().UpdateProperties(&types.StreamProperties{types.SchemaPropertyCompatibility: "forward"})
+	if schema, _, err = svc.RegisterStreamSchema(s, schemaV3); err != nil || schema.Version != 3 {
+		t.Fatalf("error while registering schema: %v", err)
+	}
+
+	// validate against a previous version
+	if version, validationErrors, err = s.ValidateRecords(context.Background(), 1, records[:1], nil); err != nil || version != 1 || len(validationErrors) != 0 {
+		t.Fatalf("unexpected validation result %d %+v %v", version, validationErrors, err)
+	}
+	if _, _, err = s.ValidateRecords(context.Background(), 4, records, nil); !errors.Is(err, stream.ErrStreamSchemaNotFound) {
+		t.Fatalf("expected schema not found, got %v", err)
+	}
+
+	if err = svc.DeleteStreamSchemas(s); err != nil {
+		t.Fatalf("error while deleting schemas: %v", err)
+	}
+	if schemas, _ := svc.sp.LoadStreamSchemas(s.GetUUID()); len(schemas) != 0 || len(s.GetSchemas()) != 0 {
+		t.Fatalf("schemas must be deleted")
+	}
+}
+
+func TestIngestPipeline(t *testing.T) {
+	svc := newTestService(t, nil)
+
+	if _, err := svc.CreateStream(&types.StreamProperties{"ingest.pipeline.1": "{"}); err == nil {
+		t.Fatalf("expected invalid ingest pipeline")
+	}
+
+	properties := types.StreamProperties{
+		"ingest.pipeline.2":  "select(.level != \"debug\")",
+		"ingest.pipeline.1":  ".level |= ascii_downcase",
+		"ingest.pipeline.10": ".source = $headers.source | .count = (.count + 1)",
+	}
+	s, err := svc.CreateStream(&properties)
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+
+	records := []interface{}{
+		map[string]interface{}{"level": "INFO", "count": 1},
+		map[string]interface{}{"level": "Debug", "count": 2},
+		map[string]interface{}{"level": "ERROR", "count": "3"},
+		map[string]interface{}{"level": "WARN", "count": 4},
+	}
+	headers := []types.RecordHeaders{{"source": "a"}, nil, nil, {"source": "b"}}
+	msgIds, report, err := s.PutMessages(nil, records, headers)
+	if err != nil {
+		t.Fatalf("error while putting messages: %v", err)
+	}
+	if msgIds[0] != 1 || msgIds[1] != 0 || msgIds[2] != 0 || msgIds[3] != 2 {
+		t.Fatalf("unexpected message ids %v", msgIds)
+	}
+	if report == nil || report.CountDropped != 1 || report.CountErrors != 1 {
+		t.Fatalf("unexpected ingest pipeline report %+v", report)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].Index != 2 || report.Errors[0].Step != "ingest.pipeline.10" {
+		t.Fatalf("unexpected ingest pipeline errors %+v", report.Errors)
+	}
+	if err = s.WaitForDurability(context.Background(), types.DurabilityFlushed, msgIds); err != nil {
+		t.Fatalf("error while saving records: %v", err)
+	}
+
+	itUUID, apiErr := svc.CreateRecordsIterator(s, &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"})
+	if apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr.Details)
+	}
+	response, err := s.GetRecords(nil, itUUID, 100)
+	if err != nil || response.Count != 2 {
+		t.Fatalf("expected 2 records, got %d (%v)", response.Count, err)
+	}
+	last := response.Records[1].(map[string]interface{})["m"].(map[string]interface{})
+	if last["level"] != "warn" || last["source"] != "b" || last["count"] != 5 {
+		t.Fatalf("unexpected transformed record %v", last)
+	}
+
+	// an invalid pipeline is refused and the previous one is kept
+	if err = s.UpdateProperties(&types.StreamProperties{"ingest.pipeline.x": "."}); err == nil {
+		t.Fatalf("expected invalid ingest pipeline")
+	}
+	if err = s.UpdateProperties(&types.StreamProperties{"ingest.pipeline.3": "empty"}); err != nil {
+		t.Fatalf("error while updating properties: %v", err)
+	}
+	if msgIds, report, err = s.PutMessages(nil, records[:1], nil); err != nil || msgIds[0] != 0 || report.CountDropped != 1 {
+		t.Fatalf("expected the record to be dropped, got %v %+v %v", msgIds, report, err)
+	}
+}
+
+func TestDeadLetterStream(t *testing.T) {
+	svc := newTestService(t, nil)
+
+	if _, err := svc.CreateStream(&types.StreamProperties{types.DeadLetterPropertyStream: "dlq"}); !errors.Is(err, types.ErrInvalidDeadLetterStream) {
+		t.Fatalf("expected invalid dead-letter stream, got %v", err)
+	}
+
+	dlq, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	s, err := svc.CreateStream(&types.StreamProperties{
+		types.DeadLetterPropertyStream: dlq.GetUUID().String(),
+		"ingest.pipeline.1":            ".n += 1",
+	})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	if err = s.UpdateProperties(&types.StreamProperties{types.DeadLetterPropertyStream: s.GetUUID().String()}); !errors.Is(err, types.ErrInvalidDeadLetterStream) {
+		t.Fatalf("a stream cannot be its own dead-letter stream, got %v", err)
+	}
+
+	// a record rejected by the ingest pipeline
+	records := []interface{}{map[string]interface{}{"n": 1}, map[string]interface{}{"n": "a"}}
+	headers := []types.RecordHeaders{nil, {"trace-id": "1234"}}
+	if _, report, err := s.PutMessages(nil, records, headers); err != nil || report.CountErrors != 1 {
+		t.Fatalf("expected a record error, got %+v %v", report, err)
+	}
+
+	// a record rejected by the schema
+	schema := map[string]interface{}{"type": "object", "properties": map[string]interface{}{"n": map[string]interface{}{"type": "integer"}}}
+	if _, _, err = svc.RegisterStreamSchema(s, schema); err != nil {
+		t.Fatalf("error while registering schema: %v", err)
+	}
+	if _, validationErrors, err := s.ValidateRecords(context.Background(), 0, records, nil); err != nil || len(validationErrors) != 1 {
+		t.Fatalf("expected a validation error, got %+v %v", validationErrors, err)
+	}
+	if err = dlq.WaitForDurability(context.Background(), types.DurabilityFlushed, []types.MessageId{2}); err != nil {
+		t.Fatalf("error while saving dead letters: %v", err)
+	}
+
+	itUUID, apiErr := svc.CreateRecordsIterator(dlq, &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"})
+	if apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr.Details)
+	}
+	response, err := dlq.GetRecords(nil, itUUID, 100)
+	if err != nil || response.Count != 2 {
+		t.Fatalf("expected 2 dead letters, got %d (%v)", response.Count, err)
+	}
+	for i, source := range []string{types.DeadLetterSourceIngestPipeline, types.DeadLetterSourceSchema} {
+		record := response.Records[i].(map[string]interface{})
+		deadLetter := record["m"].(map[string]interface{})
+		if deadLetter["source"] != source || deadLetter["stream"] != s.GetUUID().String() || deadLetter["reason"] == "" {
+			t.Fatalf("unexpected dead letter %v", deadLetter)
+		}
+		if deadLetter["message"].(map[string]interface{})["n"] != "a" {
+			t.Fatalf("expected the original record, got %v", deadLetter["message"])
+		}
+	}
+	if h, ok := response.Records[0].(map[string]interface{})["h"].(map[string]interface{}); !ok || h["trace-id"] != "1234" {
+		t.Fatalf("expected the headers of the original record, got %v", response.Records[0])
+	}
+}
+
+func TestDerivedStream(t *testing.T) {
+	svc := newTestService(t, nil)
+
+	source, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	if _, err = svc.CreateStream(&types.StreamProperties{types.DerivedStreamPropertySource: uuid.New().String()}); !errors.Is(err, types.ErrInvalidDerivedStream) {
+		t.Fatalf("expected source stream not found, got %v", err)
+	}
+
+	putLevels := func(levels ...string) {
+		records := make([]interface{}, len(levels))
+		for i, level := range levels {
+			records[i] = map[string]interface{}{"level": level, "msg": i}
+		}
+		putRecords(t, source, records, nil)
+	}
+	putLevels("info", "error")
+
+	derived, err := svc.CreateStream(&types.StreamProperties{
+		types.DerivedStreamPropertySource: source.GetUUID().String(),
+		types.DerivedStreamPropertyJq:     "select(.level == \"error\") | {msg}",
+	})
+	if err != nil {
+		t.Fatalf("error while creating derived stream: %v", err)
+	}
+	if err = derived.UpdateProperties(&types.StreamProperties{types.DerivedStreamPropertyJq: "."}); !errors.Is(err, types.ErrInvalidDerivedStream) {
+		t.Fatalf("the definition of a derived stream cannot be changed, got %v", err)
+	}
+	putLevels("error", "debug")
+
+	// wait until the derived stream has processed the records of the source stream
+	groupName := types.GetDerivedStreamConsumerGroup(derived.GetUUID())
+	waitForDerivedRecords := func(committedMsgId types.MessageId, lastMsgId types.MessageId) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			if group, found := source.GetConsumerGroup(groupName); found && group.CommittedMsgId >= committedMsgId {
+				break
+			}
+			select {
+			case <-timeout:
+				t.Fatalf("timeout while waiting for the derived stream to process message %d", committedMsgId)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		if err := derived.WaitForDurability(context.Background(), types.DurabilityFlushed, []types.MessageId{lastMsgId}); err != nil {
+			t.Fatalf("error while saving derived records: %v", err)
+		}
+	}
+	waitForDerivedRecords(4, 2)
+
+	// the derived stream resumes from its committed position
+	svc.stopDerivedStream(derived.GetUUID())
+	if group, found := source.GetConsumerGroup(groupName); !found || group.CommittedMsgId != 4 {
+		t.Fatalf("expected the position of the derived stream, got %+v", group)
+	}
+	putLevels("error")
+	svc.startDerivedStream(derived)
+	waitForDerivedRecords(5, 3)
+
+	itUUID, apiErr := svc.CreateRecordsIterator(derived, &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"})
+	if apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr.Details)
+	}
+	response, err := derived.GetRecords(nil, itUUID, 100)
+	if err != nil || response.Count != 3 {
+		t.Fatalf("expected 3 records, got %d (%v)", response.Count, err)
+	}
+	for i, msg := range []int{1, 0, 0} {
+		if record := response.Records[i].(map[string]interface{})["m"]; !reflect.DeepEqual(record, map[string]interface{}{"msg": msg}) {
+			t.Fatalf("unexpected derived record %v", record)
+		}
+	}
+
+	if err = svc.DeleteStream(derived.GetUUID()); err != nil {
+		t.Fatalf("error while deleting stream: %v", err)
+	}
+	if _, found := source.GetConsumerGroup(groupName); found {
+		t.Fatalf("the consumer group of the derived stream must be deleted")
+	}
+}
+
+func TestDerivedStreamRestart(t *testing.T) {
+	dataDirectory := t.TempDir()
+	withJSONFile := func(conf *config.Config) {
+		withRecordsSavedOnDemand(conf)
+		conf.Storage.Type = "JSONFile"
+		conf.Storage.JSONFile.DataDirectory = dataDirectory
+	}
+	svc := newTestService(t, withJSONFile)
+	source, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	derived, err := svc.CreateStream(&types.StreamProperties{types.DerivedStreamPropertySource: source.GetUUID().String()})
+	if err != nil {
+		t.Fatalf("error while creating derived stream: %v", err)
+	}
+	sourceUUID, derivedUUID := source.GetUUID(), derived.GetUUID()
+
+	// the service is stopped while the derived stream processes the records of the source stream
+	const cptRestarts, cptRecords = 5, 2*DerivedStreamBatchSize + 500
+	for i := range cptRestarts {
+		putRecords(t, source, newRecords(cptRecords*i+1, cptRecords*(i+1)), nil)
+		svc.Stop()
+		svc = newTestService(t, withJSONFile)
+		if _, err = svc.LoadStreams(); err != nil {
+			t.Fatalf("error while loading streams: %v", err)
+		}
+		source, derived = svc.GetStream(sourceUUID), svc.GetStream(derivedUUID)
+	}
+
+	// wait until the derived stream has processed all the records of the source stream
+	groupName := types.GetDerivedStreamConsumerGroup(derivedUUID)
+	timeout := time.After(5 * time.Second)
+	for {
+		if group, found := source.GetConsumerGroup(groupName); found && group.CommittedMsgId == cptRecords*cptRestarts {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("timeout while waiting for the derived stream")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// every record of the source stream is put once into the derived stream
+	itUUID, apiErr := svc.CreateRecordsIterator(derived, &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"})
+	if apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr.Details)
+	}
+	response, err := derived.GetRecords(nil, itUUID, cptRecords*cptRestarts)
+	if err != nil || response.Count != cptRecords*cptRestarts {
+		t.Fatalf("expected %d records, got %d (%v)", cptRecords*cptRestarts, response.Count, err)
+	}
+	for i, record := range response.Records {
+		if n := record.(map[string]interface{})["m"].(map[string]interface{})["n"]; n != float64(i+1) {
+			t.Fatalf("expected the record %d, got %v", i+1, n)
+		}
+	}
+}
+
+func TestIdempotentProducer(t *testing.T) {
+	svc := newTestService(t, nil)
+
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+
+	cptPuts := 0
+	put := func() (*types.ProducerBatch, error) {
+		cptPuts++
+		records := []interface{}{map[string]interface{}{"n": 1}, map[string]interface{}{"n": 2}}
+		msgIds, _, err := s.PutMessages(nil, records, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &types.ProducerBatch{MessageIds: msgIds}, nil
+	}
+
+	batch, duplicate, err := svc.PutIdempotent(context.Background(), s, "producer-1", 1, put)
+	if err != nil || duplicate || !reflect.DeepEqual(batch.MessageIds, []types.MessageId{1, 2}) {
+		t.Fatalf("unexpected batch %+v %v %v", batch, duplicate, err)
+	}
+
+	// the retried batch returns the original message ids, nothing is put
+	batch, duplicate, err = svc.PutIdempotent(context.Background(), s, "producer-1", 1, put)
+	if err != nil || !duplicate || !reflect.DeepEqual(batch.MessageIds, []types.MessageId{1, 2}) || cptPuts != 1 {
+		t.Fatalf("expected duplicate batch, got %+v %v %v", batch, duplicate, err)
+	}
+
+	// a gap in the sequence is rejected
+	var seqErr *stream.ProducerSequenceError
+	if _, _, err = svc.PutIdempotent(context.Background(), s, "producer-1", 3, put); !errors.As(err, &seqErr) || seqErr.ExpectedSequence != 2 {
+		t.Fatalf("expected sequence error, got %v", err)
+	}
+	if _, _, err = svc.PutIdempotent(context.Background(), s, "producer-1", 2, put); err != nil {
+		t.Fatalf("error while putting batch: %v", err)
+	}
+
+	// the sequences are persisted by the storage provider (reloaded when the server restarts)
+	producers, err := svc.sp.LoadProducers(s.GetUUID())
+	if err != nil || len(producers) != 1 {
+		t.Fatalf("expected 1 producer, got %d (%v)", len(producers), err)
+	}
+	s.SetProducers(producers)
+	batch, duplicate, err = svc.PutIdempotent(context.Background(), s, "producer-1", 1, put)
+	if err != nil || !duplicate || !reflect.DeepEqual(batch.MessageIds, []types.MessageId{1, 2}) {
+		t.Fatalf("expected duplicate batch after reload, got %+v %v %v", batch, duplicate, err)
+	}
+	if _, _, err = svc.PutIdempotent(context.Background(), s, "producer-1", 4, put); !errors.As(err, &seqErr) || seqErr.ExpectedSequence != 3 {
+		t.Fatalf("expected sequence error after reload, got %v", err)
+	}
+
+	// a batch is not acknowledged until the producer is saved, the retried batch saves the producer
+	// and returns the original message ids (the records are not put twice)
+	errSave := errors.New("disk full")
+	failingSave := func(producer *types.Producer) error {
+		return errSave
+	}
+	if _, _, err = s.PutIdempotent(context.Background(), "producer-1", 3, failingSave, put); !errors.Is(err, errSave) || cptPuts != 3 {
+		t.Fatalf("expected save error, got %v", err)
+	}
+	if _, _, err = s.PutIdempotent(context.Background(), "producer-1", 3, failingSave, put); !errors.Is(err, errSave) || cptPuts != 3 {
+		t.Fatalf("expected save error on retry, got %v", err)
+	}
+	batch, duplicate, err = svc.PutIdempotent(context.Background(), s, "producer-1", 3, put)
+	if err != nil || !duplicate || !reflect.DeepEqual(batch.MessageIds, []types.MessageId{5, 6}) || cptPuts != 3 {
+		t.Fatalf("expected duplicate batch once saved, got %+v %v %v", batch, duplicate, err)
+	}
+	if producers, err = svc.sp.LoadProducers(s.GetUUID()); err != nil || len(producers) != 1 || producers[0].LastSequence != 3 {
+		t.Fatalf("expected the producer to be saved, got %+v (%v)", producers, err)
+	}
+}
+
+func TestIdempotentProducerSavedOncePersisted(t *testing.T) {
+	svc := newTestService(t, withRecordsSavedOnDemand)
+
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+
+	// the producer is saved once the records of the batch are persisted: a crash before the records
+	// are written must not answer the retried batch as a duplicate
+	var msgIds []types.MessageId
+	put := func() (*types.ProducerBatch, error) {
+		msgIds, _, err = s.PutMessages(nil, newRecords(1, 2), nil)
+		if err != nil {
+			return nil, err
+		}
+		return &types.ProducerBatch{MessageIds: msgIds}, nil
+	}
+	cptSaves := 0
+	save := func(producer *types.Producer) error {
+		cptSaves++
+		records, apiErr := svc.GetRecordsByIds(s, nil, msgIds)
+		if apiErr != nil {
+			return apiErr
+		}
+		for i, record := range records {
+			if record == nil {
+				t.Errorf("record %d is not persisted when the producer is saved", msgIds[i])
+			}
+		}
+		return svc.sp.SaveProducer(producer)
+	}
+
+	if _, _, err = s.PutIdempotent(context.Background(), "producer-1", 1, save, put); err != nil || cptSaves != 1 {
+		t.Fatalf("error while putting batch: %v", err)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	svc := newTestService(t, func(conf *config.Config) {
+		conf.Streams.ChannelBufferSize = 2
+		conf.Streams.Backpressure.MaxWait = 1
+	})
+
+	if _, err := svc.CreateStream(&types.StreamProperties{types.BackpressurePropertyMode: "drop"}); !errors.Is(err, types.ErrInvalidBackpressure) {
+		t.Fatalf("expected invalid backpressure, got %v", err)
+	}
+
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	stats := s.GetIngestStats()
+	if stats.QueueCapacity != 2 || stats.Backpressure.Mode != types.BackpressureModeBlock || stats.Backpressure.MaxWait != time.Second {
+		t.Fatalf("unexpected ingest stats %+v", stats)
+	}
+
+	records := []interface{}{
+		map[string]interface{}{"n": 1}, map[string]interface{}{"n": 2}, map[string]interface{}{"n": 3},
+	}
+
+	// a bounded wait can't be satisfied by a batch larger than the ingest buffer
+	if _, _, err = s.PutMessages(nil, records, nil); !errors.Is(err, stream.ErrIngestBatchTooLarge) {
+		t.Fatalf("expected batch too large, got %v", err)
+	}
+	putRecords(t, s, records[:2], nil)
+
+	if err = s.UpdateProperties(&types.StreamProperties{types.BackpressurePropertyMode: "shed"}); err != nil {
+		t.Fatalf("error while updating properties: %v", err)
+	}
+
+	// the records that don't fit into the ingest buffer are shed
+	msgIds, report, err := s.PutMessages(nil, records, nil)
+	if err != nil || report == nil || report.CountShed != 1 || msgIds[2] != 0 {
+		t.Fatalf("expected a shed record, got %v %+v %v", msgIds, report, err)
+	}
+	if stats = s.GetIngestStats(); stats.CountShed != 1 || stats.CountRejected != 3 {
+		t.Fatalf("unexpected ingest stats %+v", stats)
+	}
+}
+
+func TestDurability(t *testing.T) {
+	svc := newTestService(t, withRecordsSavedOnDemand)
+
+	if _, err := svc.CreateStream(&types.StreamProperties{types.DurabilityPropertyLevel: "disk"}); !errors.Is(err, types.ErrInvalidDurability) {
+		t.Fatalf("expected invalid durability, got %v", err)
+	}
+
+	s, err := svc.CreateStream(&types.StreamProperties{types.DurabilityPropertyLevel: "fsynced"})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	level, err := s.GetDurability()
+	if err != nil || level != types.DurabilityFsynced {
+		t.Fatalf("unexpected durability %s %v", level, err)
+	}
+
+	// the records are saved at once instead of waiting for the next flush (60 seconds)
+	startTime := time.Now()
+	for _, level := range []string{types.DurabilityFlushed, types.DurabilityFsynced} {
+		msgIds, _, err := s.PutMessages(nil, []interface{}{map[string]interface{}{"level": level}}, nil)
+		if err != nil {
+			t.Fatalf("error while putting records: %v", err)
+		}
+		if err = s.WaitForDurability(context.Background(), level, msgIds); err != nil {
+			t.Fatalf("error while waiting for durability %s: %v", level, err)
+		}
+	}
+	if time.Since(startTime) > 5*time.Second {
+		t.Fatalf("records not saved at once (%s)", time.Since(startTime))
+	}
+	if cpt := s.GetInfo().ReadableMessages.CptMessages; cpt != 2 {
+		t.Fatalf("expected 2 readable records, got %d", cpt)
+	}
+}
+
+func TestBulkFlushTriggers(t *testing.T) {
+	waitForReadableRecords := func(s *stream.Stream, cpt types.Size64, timeout time.Duration) {
+		t.Helper()
+		deadline := time.Now().Add(timeout)
+		for s.GetInfo().ReadableMessages.CptMessages < cpt {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected at least %d readable records within %s, got %d", cpt, timeout, s.GetInfo().ReadableMessages.CptMessages)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// the records are saved by the sub-second flush instead of waiting for the next flush (60 seconds)
+	svc := newTestService(t, func(conf *config.Config) {
+		withRecordsSavedOnDemand(conf)
+		conf.Streams.BulkFlushInterval = "100ms"
+	})
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	if _, _, err = s.PutMessages(nil, newRecords(1, 3), nil); err != nil {
+		t.Fatalf("error while putting records: %v", err)
+	}
+	waitForReadableRecords(s, 3, 900*time.Millisecond)
+
+	// the records are saved once the buffered records exceed the maximum size (about 8 records)
+	svc = newTestService(t, func(conf *config.Config) {
+		withRecordsSavedOnDemand(conf)
+		conf.Streams.BulkMaxBytes = "64b"
+	})
+	if s, err = svc.CreateStream(&types.StreamProperties{}); err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	if _, _, err = s.PutMessages(nil, newRecords(1, 2), nil); err != nil {
+		t.Fatalf("error while putting records: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if cpt := s.GetInfo().ReadableMessages.CptMessages; cpt != 0 {
+		t.Fatalf("expected the records to be buffered, got %d readable records", cpt)
+	}
+	if _, _, err = s.PutMessages(nil, newRecords(3, 10), nil); err != nil {
+		t.Fatalf("error while putting records: %v", err)
+	}
+	waitForReadableRecords(s, 7, 900*time.Millisecond)
+
+	svc = newTestService(t, func(conf *config.Config) {
+		conf.Streams.BulkFlushInterval = "often"
+	})
+	if _, err = svc.CreateStream(&types.StreamProperties{}); err == nil {
+		t.Fatalf("expected invalid bulk flush interval")
+	}
+}
+
+func TestRetention(t *testing.T) {
+	svc := newTestService(t, withRecordsSavedOnDemand)
+
+	if _, err := svc.CreateStream(&types.StreamProperties{types.RetentionPropertyMaxAge: "forever"}); !errors.Is(err, types.ErrInvalidRetention) {
+		t.Fatalf("expected invalid retention, got %v", err)
+	}
+
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	for _, properties := range []types.StreamProperties{
+		{types.RetentionPropertyMaxRecords: float64(-1)},
+		{types.RetentionPropertyMaxSize: "lots"},
+		{types.RetentionPropertyMaxAge: "-1h"},
+	} {
+		if err = s.UpdateProperties(&properties); !errors.Is(err, types.ErrInvalidRetention) {
+			t.Fatalf("expected invalid retention for %v, got %v", properties, err)
+		}
+	}
+
+	// the records out of the retention policy are removed
+	msgIds := putRecords(t, s, newRecords(1, 10), nil)
+	if err = s.WaitForDurability(context.Background(), types.DurabilityFlushed, msgIds); err != nil {
+		t.Fatalf("error while waiting for durability: %v", err)
+	}
+	if err = s.UpdateProperties(&types.StreamProperties{types.RetentionPropertyMaxRecords: float64(4)}); err != nil {
+		t.Fatalf("error while updating properties: %v", err)
+	}
+	if cptRemoved, err := s.ApplyRetention(); err != nil || cptRemoved != 6 {
+		t.Fatalf("expected 6 records removed, got %d (%v)", cptRemoved, err)
+	}
+	if msgId := s.GetFirstReadableMsgId(); msgId != 7 {
+		t.Fatalf("expected first readable record 7, got %d", msgId)
+	}
+}
+
+func TestBoundedIterator(t *testing.T) {
+	svc := newTestService(t, withRecordsSavedOnDemand)
+
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	createIterator := func(payload string) types.StreamIteratorUUID {
+		if err := stream.ValidateStreamIteratorRequest(context.Background(), []byte(payload)); err != nil {
+			t.Fatalf("invalid iterator request %s: %v", payload, err)
+		}
+		req := types.StreamIteratorRequest{}
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			t.Fatalf("can't unmarshal iterator request %s: %v", payload, err)
+		}
+		itUUID, apiErr := svc.CreateRecordsIterator(s, &req)
+		if apiErr != nil {
+			t.Fatalf("error while creating iterator %s: %v", payload, apiErr)
+		}
+		return itUUID
+	}
+	getRecords := func(itUUID types.StreamIteratorUUID, maxRecords uint, expectedCount int64, expectedEnded bool) {
+		response, err := s.GetRecords(context.Background(), itUUID, maxRecords)
+		if err != nil {
+			t.Fatalf("error while getting records: %v", err)
+		}
+		if response.Count != expectedCount || response.Ended != expectedEnded {
+			t.Fatalf("expected %d records (ended %t), got %d records (ended %t)", expectedCount, expectedEnded, response.Count, response.Ended)
+		}
+	}
+
+	putRecords(t, s, newRecords(1, 5), nil)
+
+	// the snapshot ignores the records put after the creation of the iterator
+	itSnapshot := createIterator(`{"iteratorType": "FIRST_MESSAGE", "snapshot": true}`)
+	putRecords(t, s, newRecords(6, 8), nil)
+	getRecords(itSnapshot, 100, 5, true)
+	getRecords(itSnapshot, 100, 0, true)
+
+	// the last message id is included
+	itUntil := createIterator(`{"iteratorType": "AT_MESSAGE_ID", "messageId": 2, "untilMessageId": 3}`)
+	getRecords(itUntil, 1, 1, false)
+	getRecords(itUntil, 1, 1, true)
+
+	// records put from the date are excluded
+	itPast := createIterator(`{"iteratorType": "AT_TIMESTAMP", "timestamp": "-1h", "untilTimestamp": "2000-01-01T00:00:00.123456789Z"}`)
+	getRecords(itPast, 100, 0, true)
+	itFuture := createIterator(`{"iteratorType": "FIRST_MESSAGE", "untilTimestamp": "2100-01-01T00:00:00+02:00", "maxWaitTimeSeconds": 1}`)
+	getRecords(itFuture, 100, 8, false)
+
+	if err = stream.ValidateStreamIteratorRequest(context.Background(), []byte(`{"iteratorType": "AT_TIMESTAMP", "timestamp": "15m"}`)); err == nil {
+		t.Fatalf("expected invalid timestamp")
+	}
+	req := types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE", Snapshot: true, UntilTimestamp: &time.Time{}}
+	if _, apiErr := svc.CreateRecordsIterator(s, &req); apiErr == nil {
+		t.Fatalf("expected mutually exclusive ends of range")
+	}
+}
+
+func TestBackwardIterator(t *testing.T) {
+	svc := newTestService(t, withRecordsSavedOnDemand)
+
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	putRecords(t, s, newRecords(1, 10), nil)
+
+	getRecords := func(req types.StreamIteratorRequest, maxRecords uint, expected ...[]int) {
+		itUUID, apiErr := svc.CreateRecordsIterator(s, &req)
+		if apiErr != nil {
+			t.Fatalf("error while creating iterator: %v", apiErr)
+		}
+		for page, expectedIds := range expected {
+			response, err := s.GetRecords(context.Background(), itUUID, maxRecords)
+			if err != nil {
+				t.Fatalf("error while getting records: %v", err)
+			}
+			ids := make([]int, 0, len(response.Records))
+			for _, record := range response.Records {
+				ids = append(ids, record.(map[string]interface{})["m"].(map[string]interface{})["n"].(int))
+			}
+			if !reflect.DeepEqual(ids, expectedIds) {
+				t.Fatalf("page %d: expected records %v, got %v", page, expectedIds, ids)
+			}
+			if ended := page == len(expected)-1; response.Ended != ended {
+				t.Fatalf("page %d: expected ended %t, got %t", page, ended, response.Ended)
+			}
+		}
+	}
+
+	// the last records first, page by page (the record n has the message id n)
+	getRecords(types.StreamIteratorRequest{IteratorType: "LAST_MESSAGE", Direction: types.IteratorDirectionBackward}, 4,
+		[]int{10, 9, 8, 7}, []int{6, 5, 4, 3}, []int{2, 1})
+	getRecords(types.StreamIteratorRequest{IteratorType: "AFTER_MESSAGE_ID", MessageId: 3, Direction: types.IteratorDirectionBackward}, 4,
+		[]int{2, 1})
+	getRecords(types.StreamIteratorRequest{IteratorType: "AT_MESSAGE_ID", MessageId: 5, Direction: types.IteratorDirectionBackward, JqFilter: "select(.m.n % 2 == 1)"}, 2,
+		[]int{5, 3}, []int{1})
+	untilMessageId := types.MessageId(8)
+	getRecords(types.StreamIteratorRequest{IteratorType: "LAST_MESSAGE", Direction: types.IteratorDirectionBackward, UntilMessageId: &untilMessageId}, 4,
+		[]int{10, 9, 8})
+
+	req := types.StreamIteratorRequest{IteratorType: "AFTER_LAST_MESSAGE", Direction: types.IteratorDirectionBackward}
+	if _, apiErr := svc.CreateRecordsIterator(s, &req); apiErr == nil {
+		t.Fatalf("expected AFTER_LAST_MESSAGE can't be read backward")
+	}
+}
+
+func TestRecordLookup(t *testing.T) {
+	svc := newTestService(t, withRecordsSavedOnDemand)
+
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	putRecords(t, s, newRecords(1, 10), nil)
+
+	// the records are returned in the order of the message ids requested (nil if not found)
+	found, apiErr := svc.GetRecordsByIds(s, nil, []types.MessageId{7, 42, 1, 10, 0})
+	if apiErr != nil {
+		t.Fatalf("error while getting records: %v", apiErr)
+	}
+	expected := []interface{}{7, nil, 1, 10, nil}
+	if len(found) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(found))
+	}
+	for i, record := range found {
+		var n interface{}
+		if record != nil {
+			n = record.(map[string]interface{})["m"].(map[string]interface{})["n"]
+		}
+		if n != expected[i] {
+			t.Fatalf("record %d: expected %v, got %v", i, expected[i], n)
+		}
+	}
+
+	partition := 0
+	if _, apiErr = svc.GetRecordsByIds(s, &partition, []types.MessageId{1}); apiErr == nil {
+		t.Fatalf("the stream is not partitioned")
+	}
+
+	// message ids are specific to each partition
+	p, err := svc.CreatePartitionedStream(&types.StreamProperties{}, 2)
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	if _, apiErr = svc.GetRecordsByIds(p, nil, []types.MessageId{1}); apiErr == nil {
+		t.Fatalf("the partition must be required")
+	}
+	partition = 2
+	if _, apiErr = svc.GetRecordsByIds(p, &partition, []types.MessageId{1}); apiErr == nil {
+		t.Fatalf("the partition must exist")
+	}
+	partition = 1
+	if found, apiErr = svc.GetRecordsByIds(p, &partition, []types.MessageId{1}); apiErr != nil || len(found) != 1 || found[0] != nil {
+		t.Fatalf("expected no record, got %v (%v)", found, apiErr)
+	}
+}
+
+func TestStreamQuery(t *testing.T) {
+	svc := newTestService(t, withRecordsSavedOnDemand)
+
+	s, err := svc.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	records := make([]interface{}, 10)
+	for i := range records {
+		n := i + 1
+		record := map[string]interface{}{"n": n, "type": "a", "amount": n * 10}
+		if n%2 == 1 {
+			record["type"] = "b"
+		}
+		records[i] = record
+	}
+	putRecords(t, s, records, nil)
+
+	runQuery := func(req types.StreamQueryRequest) *stream.QueryStreamResponse {
+		response, apiErr := svc.QueryStream(context.Background(), s, &req)
+		if apiErr != nil {
+			t.Fatalf("error while querying stream: %v", apiErr)
+		}
+		return response
+	}
+
+	response := runQuery(types.StreamQueryRequest{
+		Jq: "select(.m.n > 2)",
+		Aggregations: []*types.QueryAggregation{
+			{Name: "count", Op: types.QueryOpCount},
+			{Name: "sum", Op: types.QueryOpSum, Value: ".m.amount"},
+			{Name: "min", Op: types.QueryOpMin, Value: ".m.n"},
+			{Name: "max", Op: types.QueryOpMax, Value: ".m.type"},
+			{Name: "types", Op: types.QueryOpDistinct, Value: ".m.type"},
+			{Name: "byType", Op: types.QueryOpGroupBy, Key: ".m.type", Value: ".m.amount"},
+		},
+	})
+	if !response.Complete || response.RecordsScanned != 10 || response.RecordsMatched != 8 || response.CountErrors != 0 {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	sumA, sumB := float64(280), float64(240)
+	expected := map[string]interface{}{
+		"count": int64(8),
+		"sum":   float64(520),
+		"min":   3,
+		"max":   "b",
+		"types": types.QueryDistinctResult{Values: []interface{}{"b", "a"}},
+		"byType": types.QueryGroupByResult{Groups: []*types.QueryGroup{
+			{Key: "b", Count: 4, Sum: &sumB},
+			{Key: "a", Count: 4, Sum: &sumA},
+		}},
+	}
+	if !reflect.DeepEqual(response.Results, expected) {
+		t.Fatalf("expected results %v, got %v", expected, response.Results)
+	}
+
+	// bounded range
+	count := []*types.QueryAggregation{{Name: "count", Op: types.QueryOpCount}}
+	untilMsgId := types.MessageId(5)
+	response = runQuery(types.StreamQueryRequest{Range: types.StreamIteratorRequest{IteratorType: "AFTER_MESSAGE_ID", MessageId: 1, UntilMessageId: &untilMsgId}, Aggregations: count})
+	if !response.Complete || response.Results["count"] != int64(4) {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	// the scan is stopped by the limit
+	response = runQuery(types.StreamQueryRequest{Aggregations: count, MaxRecordsScanned: 4})
+	if response.Complete || response.RecordsScanned != 4 || response.Results["count"] != int64(4) || response.LastRecordIdRead != 4 {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	for _, req := range []types.StreamQueryRequest{
+		{},
+		{Aggregations: []*types.QueryAggregation{{Name: "x", Op: "avg", Value: ".m.n"}}},
+		{Aggregations: []*types.QueryAggregation{{Name: "x", Op: types.QueryOpSum}}},
+		{Aggregations: count, Jq: "select("},
+		{Aggregations: count, Range: types.StreamIteratorRequest{IteratorType: "AFTER_LAST_MESSAGE"}},
+		{Aggregations: count, MaxRecordsScanned: DefaultQueryMaxRecordsScanned + 1},
+	} {
+		if _, apiErr := svc.QueryStream(context.Background(), s, &req); apiErr == nil {
+			t.Fatalf("expected an invalid query: %+v", req)
+		}
+	}
+	if s.GetIteratorsCount() != 0 {
+		t.Fatalf("the iterators of the queries must be closed")
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	svc := newTestService(t, withRecordsSavedOnDemand)
+
+	putRecordsWithHeaders := func(s *stream.Stream, from int, to int) {
+		headers := make([]types.RecordHeaders, 0, to-from+1)
+		for n := from; n <= to; n++ {
+			headers = append(headers, types.RecordHeaders{"h": "v"})
+		}
+		putRecords(t, s, newRecords(from, to), headers)
+	}
+	export := func(s *stream.Stream, expectedRecords int64) []byte {
+		var buffer bytes.Buffer
+		cptRecords, err := svc.ExportStream(context.Background(), s, &buffer)
+		if err != nil || cptRecords != expectedRecords {
+			t.Fatalf("expected %d records exported, got %d (%v)", expectedRecords, cptRecords, err)
+		}
+		return buffer.Bytes()
+	}
+	importStream := func(data []byte, options types.StreamImportOptions) *stream.Stream {
+		response, err := svc.ImportStream(context.Background(), bytes.NewReader(data), options)
+		if err != nil {
+			t.Fatalf("error while importing stream: %v", err)
+		}
+		return svc.GetStream(response.StreamUUID)
+	}
+	expectRecords := func(s *stream.Stream, partition *int, firstMsgId types.MessageId, expected ...int) {
+		msgIds := make([]types.MessageId, len(expected)+1)
+		for i := range msgIds {
+			msgIds[i] = firstMsgId + types.MessageId(i)
+		}
+		records, apiErr := svc.GetRecordsByIds(s, partition, msgIds)
+		if apiErr != nil {
+			t.Fatalf("error while getting records: %v", apiErr)
+		}
+		for i, n := range expected {
+			record, _ := records[i].(map[string]interface{})
+			if record == nil || record["m"].(map[string]interface{})["n"] != float64(n) || record["h"].(map[string]interface{})["h"] != "v" {
+				t.Fatalf("message %d: expected record %d, got %v", msgIds[i], n, records[i])
+			}
+		}
+		if records[len(expected)] != nil {
+			t.Fatalf("message %d: expected no record, got %v", msgIds[len(expected)], records[len(expected)])
+		}
+	}
+
+	// the imported records are decoded from JSON (the numbers are float64)
+	s, err := svc.CreateStream(&types.StreamProperties{"project": "demo"})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	putRecordsWithHeaders(s, 1, 10)
+	data := export(s, 10)
+	if lines := bytes.Split(bytes.TrimSpace(data), []byte("\n")); len(lines) != 11 {
+		t.Fatalf("expected 11 lines, got %d", len(lines))
+	}
+
+	imported := importStream(data, types.StreamImportOptions{PreserveIds: true})
+	if imported.GetUUID() == s.GetUUID() || imported.GetInfo().Properties["project"] != "demo" {
+		t.Fatalf("unexpected imported stream %+v", imported.GetInfo())
+	}
+	expectRecords(imported, nil, 1, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	if !imported.GetInfo().IngestedMessages.FirstMsgTimestamp.Equal(s.GetInfo().IngestedMessages.FirstMsgTimestamp) {
+		t.Fatalf("the creation dates of the records must be preserved")
+	}
+
+	// the first records are missing (ex: removed by the retention policy),
+	// the message ids start from 1 unless they are preserved
+	if err = svc.DeleteStream(imported.GetUUID()); err != nil {
+		t.Fatalf("error while deleting stream: %v", err)
+	}
+	putRecordsWithHeaders(s, 11, 12)
+	data = export(s, 12)
+	lines := bytes.Split(data, []byte("\n"))
+	data = bytes.Join(append(lines[:1], lines[4:]...), []byte("\n"))
+	imported = importStream(data, types.StreamImportOptions{})
+	expectRecords(imported, nil, 1, 4, 5, 6, 7, 8, 9, 10, 11, 12)
+	imported = importStream(data, types.StreamImportOptions{PreserveIds: true})
+	expectRecords(imported, nil, 4, 4, 5, 6, 7, 8, 9, 10, 11, 12)
+
+	// partitioned stream
+	p, err := svc.CreatePartitionedStream(&types.StreamProperties{}, 2)
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	partition0, _ := p.GetPartition(0)
+	partition1, _ := p.GetPartition(1)
+	putRecordsWithHeaders(partition0, 1, 3)
+	putRecordsWithHeaders(partition1, 4, 5)
+	data = export(p, 5)
+	if _, err = svc.ImportStream(context.Background(), bytes.NewReader(data), types.StreamImportOptions{KeepUUID: true}); !errors.Is(err, ErrStreamAlreadyExists) {
+		t.Fatalf("expected stream already exists, got %v", err)
+	}
+	if err = svc.DeleteStream(p.GetUUID()); err != nil {
+		t.Fatalf("error while deleting stream: %v", err)
+	}
+	imported = importStream(data, types.StreamImportOptions{KeepUUID: true, PreserveIds: true})
+	if imported.GetUUID() != p.GetUUID() || imported.GetPartitionsCount() != 2 {
+		t.Fatalf("unexpected imported stream %+v", imported.GetInfo())
+	}
+	partition := 0
+	expectRecords(imported, &partition, 1, 1, 2, 3)
+	partition = 1
+	expectRecords(imported, &partition, 1, 4, 5)
+
+	// the stream is deleted when the import fails
+	cptStreams := svc.GetStreamsCount()
+	for _, data := range [][]byte{
+		[]byte(`{"version": 2}`),
+		append(export(s, 12), []byte("{\"i\": 1,")...),
+		append(export(s, 12), []byte(`{"i": 1, "d": "2024-01-01T00:00:00Z", "m": {}}`)...),
+	} {
+		if _, err = svc.ImportStream(context.Background(), bytes.NewReader(data), types.StreamImportOptions{PreserveIds: true}); !errors.Is(err, types.ErrInvalidStreamExport) {
+			t.Fatalf("expected an invalid stream export, got %v", err)
+		}
+	}
+	if svc.GetStreamsCount() != cptStreams {
+		t.Fatalf("the streams must be deleted when the import fails")
+	}
+}
+
+func TestStreamClone(t *testing.T) {
+	svc := newTestService(t, withRecordsSavedOnDemand)
+
+	cloneStream := func(s *stream.Stream, req types.StreamCloneRequest, expectedRecords int64) *stream.Stream {
+		response, apiErr := svc.CloneStream(context.Background(), s, &req)
+		if apiErr != nil {
+			t.Fatalf("error while cloning stream: %v", apiErr.Details)
+		}
+		if response.CountRecords != expectedRecords || response.SourceStreamUUID != s.GetUUID() {
+			t.Fatalf("expected %d records cloned, got %+v", expectedRecords, response)
+		}
+		return svc.GetStream(response.StreamUUID)
+	}
+	expectRecords := func(s *stream.Stream, partition *int, lastMsgId types.MessageId) {
+		msgIds := make([]types.MessageId, lastMsgId+1)
+		for i := range msgIds {
+			msgIds[i] = types.MessageId(i + 1)
+		}
+		records, apiErr := svc.GetRecordsByIds(s, partition, msgIds)
+		if apiErr != nil {
+			t.Fatalf("error while getting records: %v", apiErr)
+		}
+		for i, record := range records {
+			if found := record != nil; found != (msgIds[i] <= lastMsgId) {
+				t.Fatalf("message %d: unexpected record %v", msgIds[i], record)
+			}
+		}
+	}
+
+	s, err := svc.CreateStream(&types.StreamProperties{"project": "demo", "owner": "team"})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	putRecords(t, s, newRecords(1, 5), nil)
+	time.Sleep(10 * time.Millisecond)
+	untilTimestamp := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	putRecords(t, s, newRecords(6, 10), nil)
+
+	// the whole stream, the records put into the clone follow the cloned records
+	clone := cloneStream(s, types.StreamCloneRequest{}, 10)
+	expectRecords(clone, nil, 10)
+	if info := clone.GetInfo(); info.Properties["project"] != "demo" || info.ReadableMessages.CptMessages != 10 || info.ReadableMessages.LastMsgId != 10 {
+		t.Fatalf("unexpected clone %+v", info)
+	}
+	putRecords(t, clone, newRecords(11, 11), nil)
+	expectRecords(clone, nil, 11)
+	expectRecords(s, nil, 10)
+
+	// up to a message id, with properties overridden
+	lastMsgId := types.MessageId(4)
+	clone = cloneStream(s, types.StreamCloneRequest{UntilMessageId: &lastMsgId, Properties: map[string]string{"project": "copy"}}, 4)
+	expectRecords(clone, nil, 4)
+	if properties := clone.GetInfo().Properties; properties["project"] != "copy" || properties["owner"] != "team" {
+		t.Fatalf("unexpected properties %v", properties)
+	}
+	if (*s.GetProperties())["project"] != "demo" {
+		t.Fatalf("the properties of the source stream must not change")
+	}
+
+	// up to a timestamp
+	clone = cloneStream(s, types.StreamCloneRequest{UntilTimestamp: &untilTimestamp}, 5)
+	expectRecords(clone, nil, 5)
+	beforeFirstRecord := untilTimestamp.Add(-time.Hour)
+	clone = cloneStream(s, types.StreamCloneRequest{UntilTimestamp: &beforeFirstRecord}, 0)
+	expectRecords(clone, nil, 0)
+
+	if _, apiErr := svc.CloneStream(context.Background(), s, &types.StreamCloneRequest{UntilMessageId: &lastMsgId, UntilTimestamp: &untilTimestamp}); apiErr == nil {
+		t.Fatalf("untilMessageId and untilTimestamp must be mutually exclusive")
+	}
+	derivedProperties := map[string]string{types.DerivedStreamPropertySource: s.GetUUID().String()}
+	if _, apiErr := svc.CloneStream(context.Background(), s, &types.StreamCloneRequest{Properties: derivedProperties}); apiErr == nil {
+		t.Fatalf("a clone must not be a derived stream")
+	}
+
+	// a partitioned stream is cloned partition by partition
+	partitioned, err := svc.CreatePartitionedStream(&types.StreamProperties{}, 2)
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	putRecords(t, partitioned.GetPartitions()[0], newRecords(1, 3), nil)
+	putRecords(t, partitioned.GetPartitions()[1], newRecords(1, 2), nil)
+	clone = cloneStream(partitioned, types.StreamCloneRequest{}, 5)
+	if clone.GetPartitionsCount() != 2 {
+		t.Fatalf("expected 2 partitions, got %d", clone.GetPartitionsCount())
+	}
+	for partition, lastMsgId := range []types.MessageId{3, 2} {
+		expectRecords(clone, &partition, lastMsgId)
+	}
+	if _, apiErr := svc.CloneStream(context.Background(), partitioned, &types.StreamCloneRequest{UntilMessageId: &lastMsgId}); apiErr == nil {
+		t.Fatalf("a partitioned stream must not be cloned up to a message id")
+	}
+	if _, apiErr := svc.CloneStream(context.Background(), partitioned.GetPartitions()[0], &types.StreamCloneRequest{}); apiErr == nil {
+		t.Fatalf("a partition must not be cloned")
+	}
+}
+
+func TestReencryptPartitionedStream(t *testing.T) {
+	dataDirectory := t.TempDir()
+	withJSONFile := func(encryptRecords bool) func(conf *config.Config) {
+		return func(conf *config.Config) {
+			withRecordsSavedOnDemand(conf)
+			conf.Storage.Type = "JSONFile"
+			conf.Storage.JSONFile.DataDirectory = dataDirectory
+			conf.Storage.JSONFile.Encryption.Enabled = encryptRecords
+			conf.Storage.JSONFile.Encryption.Keys = "k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+		}
+	}
+
+	// the records are stored before the encryption is enabled
+	svc := newTestService(t, withJSONFile(false))
+	s, err := svc.CreatePartitionedStream(&types.StreamProperties{}, 2)
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	streamUUID := s.GetUUID()
+	for i := 0; i < 5; i++ {
+		partition, _ := s.GetPartitionForKey("")
+		putRecords(t, partition, newRecords(i, i), nil)
+	}
+	svc.Stop()
+
+	// the records of every partition are rewritten, once
+	svc = newTestService(t, withJSONFile(true))
+	if _, err = svc.LoadStreams(); err != nil {
+		t.Fatalf("error while loading streams: %v", err)
+	}
+	if s = svc.GetStream(streamUUID); s == nil || s.GetPartitionsCount() != 2 {
+		t.Fatalf("expected the partitioned stream, got %v", s)
+	}
+	if cpt, err := svc.ReencryptStream(s); err != nil || cpt != 5 {
+		t.Fatalf("expected 5 records re-encrypted, got %d (%v)", cpt, err)
+	}
+	if cpt, err := svc.ReencryptStream(s); err != nil || cpt != 0 {
+		t.Fatalf("expected no record left to re-encrypt, got %d (%v)", cpt, err)
+	}
+
+	// the scrub of a partitioned stream merges the reports of its partitions
+	report, err := svc.ScrubStream(s)
+	if err != nil || report.CptRecords != 5 || report.CptChecksums != 5 || report.CptCorrupted != 0 || len(report.Partitions) != 2 {
+		t.Fatalf("unexpected scrub report %+v (%v)", report, err)
+	}
+	if report.Partitions[0].CptRecords+report.Partitions[1].CptRecords != 5 {
+		t.Fatalf("unexpected scrub reports of the partitions %+v %+v", report.Partitions[0], report.Partitions[1])
+	}
+}
+
+func TestScrubPartitionedStream(t *testing.T) {
+	dataDirectory := t.TempDir()
+	svc := newTestService(t, func(conf *config.Config) {
+		withRecordsSavedOnDemand(conf)
+		conf.Storage.Type = "JSONFile"
+		conf.Storage.JSONFile.DataDirectory = dataDirectory
+	})
+	s, err := svc.CreatePartitionedStream(&types.StreamProperties{}, 2)
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	partitions := s.GetPartitions()
+	putRecords(t, partitions[0], newRecords(1, 3), nil)
+	putRecords(t, partitions[1], newRecords(4, 6), nil)
+
+	// the record 5 (message id 2 of the second partition) is modified on the disk
+	dataPath := svc.sp.(*jsonfileprovider.FileStorage).GetStreamDataFilePath(partitions[1].GetUUID())
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		t.Fatalf("could not read data file: %v", err)
+	}
+	if bytes.Count(data, []byte(`"n":5}`)) != 1 {
+		t.Fatalf("unexpected data file %s", data)
+	}
+	if err = os.WriteFile(dataPath, bytes.Replace(data, []byte(`"n":5}`), []byte(`"n":7}`), 1), 0644); err != nil {
+		t.Fatalf("could not write data file: %v", err)
+	}
+
+	// the corrupted record is reported by the partition holding it
+	report, err := svc.ScrubStream(s)
+	if err != nil || report.CptRecords != 6 || report.CptChecksums != 6 || report.CptCorrupted != 1 || len(report.Partitions) != 2 {
+		t.Fatalf("unexpected scrub report %+v (%v)", report, err)
+	}
+	if report.Partitions[0].CptRecords != 3 || report.Partitions[0].CptCorrupted != 0 {
+		t.Fatalf("unexpected scrub report of the first partition %+v", report.Partitions[0])
+	}
+	if report.Partitions[1].CptRecords != 3 || !reflect.DeepEqual(report.Partitions[1].CorruptedIds, []types.MessageId{2}) {
+		t.Fatalf("unexpected scrub report of the second partition %+v", report.Partitions[1])
+	}
+
+	// a partition is scrubbed alone
+	if report, err = svc.ScrubStream(partitions[1]); err != nil || report.CptRecords != 3 || report.CptCorrupted != 1 || len(report.Partitions) != 0 {
+		t.Fatalf("unexpected scrub report of a partition %+v (%v)", report, err)
+	}
+}
@@ -22,6 +22,9 @@ type InMemoryStorage struct {
 	catalog            catalog.IStorageCatalog
 	mu                 sync.Mutex
 	inMemoryStreams    map[types.StreamUUID]*InMemoryStream
+	consumerGroups     map[types.StreamUUID]map[string]*types.ConsumerGroup
+	schemas            map[types.StreamUUID]types.StreamSchemaList
+	producers          map[types.StreamUUID]map[string]*types.Producer
 	maxRecordsByStream uint64
 	maxSizeInBytes     uint64
 }
@@ -139,6 +142,9 @@ func (s *InMemoryStorage) ClearStreams() error {
 	defer s.mu.Unlock()
 
 	s.inMemoryStreams = make(map[types.StreamUUID]*InMemoryStream, 0)
+	s.consumerGroups = make(map[types.StreamUUID]map[string]*types.ConsumerGroup, 0)
+	s.schemas = make(map[types.StreamUUID]types.StreamSchemaList, 0)
+	s.producers = make(map[types.StreamUUID]map[string]*types.Producer, 0)
 	return nil
 }
 
@@ -151,14 +157,154 @@ func (s *InMemoryStorage) NewStreamIteratorHandler(streamUUID types.StreamUUID,
 	return NewStreamIteratorHandlerInMemory(streamUUID, iteratorUUID, inMemoryStream, s.logger), nil
 }
 
+func (s *InMemoryStorage) GetRecordsByIds(streamUUID types.StreamUUID, messageIds []types.MessageId) ([]interface{}, error) {
+	inMemoryStream, found := s.inMemoryStreams[streamUUID]
+	if !found {
+		return nil, fmt.Errorf("stream not found: %v", streamUUID)
+	}
+
+	records := make([]interface{}, len(messageIds))
+	for i, record := range inMemoryStream.GetRecordsByIds(messageIds) {
+		if record != nil {
+			records[i] = record.ToMessage()
+		}
+	}
+	return records, nil
+}
+
+func (s *InMemoryStorage) CopyRecords(sourceUUID types.StreamUUID, target *types.StreamInfo, lastMsgId types.MessageId) error {
+	// copy the records of the source stream up to lastMsgId (included) into the target stream (created but not started yet)
+	sourceStream, found := s.inMemoryStreams[sourceUUID]
+	if !found {
+		return fmt.Errorf("stream not found: %v", sourceUUID)
+	}
+	targetStream, found := s.inMemoryStreams[target.UUID]
+	if !found {
+		return fmt.Errorf("stream not found: %v", target.UUID)
+	}
+
+	records := sourceStream.GetRecordsUntil(lastMsgId)
+	if len(records) == 0 {
+		return nil
+	}
+
+	messages := types.StreamMessagesInfo{
+		FirstMsgId:        records[0].Id,
+		FirstMsgTimestamp: records[0].CreationDate,
+	}
+	for _, record := range records {
+		deferedRecord := types.DeferedStreamRecord{Id: record.Id, CreationDate: record.CreationDate, Msg: record.Msg, Headers: record.Headers}
+		if err := targetStream.AddRecord(&deferedRecord, record.size); err != nil {
+			return err
+		}
+		messages.CptMessages += 1
+		messages.SizeInBytes += record.size
+		messages.LastMsgId = record.Id
+		messages.LastMsgTimestamp = record.CreationDate
+	}
+	target.IngestedMessages = messages
+	target.ReadableMessages = messages
+	return nil
+}
+
 func (s *InMemoryStorage) DeleteStream(streamUUID types.StreamUUID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	delete(s.inMemoryStreams, streamUUID)
+	delete(s.consumerGroups, streamUUID)
+	delete(s.schemas, streamUUID)
+	delete(s.producers, streamUUID)
 	return s.catalog.OnDeleteStream(streamUUID)
 }
 
+func (s *InMemoryStorage) LoadConsumerGroups(streamUUID types.StreamUUID) (types.ConsumerGroupList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups := make(types.ConsumerGroupList, 0, len(s.consumerGroups[streamUUID]))
+	for _, group := range s.consumerGroups[streamUUID] {
+		g := *group
+		groups = append(groups, &g)
+	}
+	return groups, nil
+}
+
+func (s *InMemoryStorage) SaveConsumerGroup(group *types.ConsumerGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// consumer groups are not persistent (only in memory): they are lost when program shuts down
+	if _, found := s.consumerGroups[group.StreamUUID]; !found {
+		s.consumerGroups[group.StreamUUID] = make(map[string]*types.ConsumerGroup)
+	}
+	g := *group
+	s.consumerGroups[group.StreamUUID][group.Name] = &g
+	return nil
+}
+
+func (s *InMemoryStorage) DeleteConsumerGroup(streamUUID types.StreamUUID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if groups, found := s.consumerGroups[streamUUID]; found {
+		delete(groups, name)
+	}
+	return nil
+}
+
+func (s *InMemoryStorage) LoadStreamSchemas(streamUUID types.StreamUUID) (types.StreamSchemaList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schemas := make(types.StreamSchemaList, len(s.schemas[streamUUID]))
+	copy(schemas, s.schemas[streamUUID])
+	return schemas, nil
+}
+
+func (s *InMemoryStorage) SaveStreamSchema(schema *types.StreamSchema) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// schemas are not persistent (only in memory): they are lost when program shuts down
+	sch := *schema
+	s.schemas[schema.StreamUUID] = append(s.schemas[schema.StreamUUID], &sch)
+	return nil
+}
+
+func (s *InMemoryStorage) DeleteStreamSchemas(streamUUID types.StreamUUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.schemas, streamUUID)
+	return nil
+}
+
+func (s *InMemoryStorage) LoadProducers(streamUUID types.StreamUUID) (types.ProducerList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	producers := make(types.ProducerList, 0, len(s.producers[streamUUID]))
+	for _, producer := range s.producers[streamUUID] {
+		p := *producer
+		producers = append(producers, &p)
+	}
+	return producers, nil
+}
+
+func (s *InMemoryStorage) SaveProducer(producer *types.Producer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// producers are not persistent (only in memory): they are lost when program shuts down
+	if _, found := s.producers[producer.StreamUUID]; !found {
+		s.producers[producer.StreamUUID] = make(map[string]*types.Producer)
+	}
+	p := *producer
+	s.producers[producer.StreamUUID][producer.Id] = &p
+	return nil
+}
+
 func (s *InMemoryStorage) BuildIndex(streamUUID types.StreamUUID) (interface{}, error) {
 	// there is no index for in memory storage, therefore return fake dummy index
 	return "", nil
@@ -185,6 +331,9 @@ func NewStorageProvider(logger *zap.Logger, conf *config.Config) (storageprovide
 		logVerbosity:       conf.Storage.LogVerbosity,
 		catalog:            NewStreamCatalogInMemory(logger),
 		inMemoryStreams:    make(map[types.StreamUUID]*InMemoryStream, 0),
+		consumerGroups:     make(map[types.StreamUUID]map[string]*types.ConsumerGroup, 0),
+		schemas:            make(map[types.StreamUUID]types.StreamSchemaList, 0),
+		producers:          make(map[types.StreamUUID]map[string]*types.Producer, 0),
 		maxRecordsByStream: conf.Storage.InMemory.MaxRecordsByStream,
 		maxSizeInBytes:     maxSizeInBytes,
 	}, nil
@@ -0,0 +1,92 @@
+package inmemoryprovider
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/config"
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestRecordHeadersInMemory(t *testing.T) {
+	conf := config.Config{}
+	conf.Storage.InMemory.MaxSize = "1gb"
+	sp, err := NewStorageProvider(zap.NewNop(), &conf)
+	if err != nil {
+		t.Fatalf("could not create storage provider: %v", err)
+	}
+	if err = sp.Init(); err != nil {
+		t.Fatalf("could not init storage provider: %v", err)
+	}
+	source, target := types.NewStreamInfo(uuid.New()), types.NewStreamInfo(uuid.New())
+	for _, info := range []*types.StreamInfo{source, target} {
+		if err = sp.OnCreateStream(info); err != nil {
+			t.Fatalf("could not create stream: %v", err)
+		}
+	}
+	w, err := sp.NewStreamWriter(source)
+	if err != nil {
+		t.Fatalf("could not create stream writer: %v", err)
+	}
+
+	// the even records have headers
+	records := make([]types.DeferedStreamRecord, 0, 4)
+	for i := 1; i <= 4; i++ {
+		record := types.DeferedStreamRecord{Id: types.MessageId(i), CreationDate: time.Now(), Msg: map[string]interface{}{"n": i}}
+		if i%2 == 0 {
+			record.Headers = types.RecordHeaders{"content-type": "application/json", "trace.id": strconv.Itoa(i)}
+		}
+		records = append(records, record)
+	}
+	if err = w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+
+	expectHeaders := func(record interface{}, msgId types.MessageId) {
+		headers, found := record.(map[string]interface{})["h"]
+		if msgId%2 == 1 {
+			if found {
+				t.Fatalf("expected no headers for message %d, got %v", msgId, headers)
+			}
+			return
+		}
+		expected := map[string]interface{}{"content-type": "application/json", "trace.id": strconv.Itoa(int(msgId))}
+		if !reflect.DeepEqual(headers, expected) {
+			t.Fatalf("expected headers %v for message %d, got %v", expected, msgId, headers)
+		}
+	}
+
+	// the headers are read back by the iterators and by the lookup of the records, and are copied with the records
+	it, err := sp.NewStreamIteratorHandler(source.UUID, uuid.New())
+	if err != nil {
+		t.Fatalf("could not create iterator: %v", err)
+	}
+	if err = it.Seek(&types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	for expectedId := types.MessageId(1); expectedId <= 4; expectedId++ {
+		msgId, record, found, _, err := it.GetNextRecord()
+		if err != nil || !found || msgId != expectedId {
+			t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+		}
+		expectHeaders(record, msgId)
+	}
+	if err = sp.CopyRecords(source.UUID, target, 4); err != nil {
+		t.Fatalf("could not copy records: %v", err)
+	}
+	messageIds := []types.MessageId{1, 2, 3, 4}
+	for _, streamUUID := range []types.StreamUUID{source.UUID, target.UUID} {
+		found, err := sp.GetRecordsByIds(streamUUID, messageIds)
+		if err != nil {
+			t.Fatalf("could not read records: %v", err)
+		}
+		for i, record := range found {
+			expectHeaders(record, messageIds[i])
+		}
+	}
+}
@@ -3,6 +3,7 @@ package inmemoryprovider
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -13,18 +14,41 @@ type InMemoryStream struct {
 	streamUUID         types.StreamUUID
 	info               *types.StreamInfo
 	records            []*InMemoryRecord
+	firstRecordIndex   uint64 // index of the first record (count of records removed by the retention policy)
+	sizeInBytes        uint64 // size of all the records ever added
 	mu                 sync.Mutex
 	maxRecordsByStream uint64
 	maxSizeInBytes     uint64
 }
 
 type InMemoryRecord struct {
-	Id           types.MessageId `json:"i"`
-	CreationDate time.Time       `json:"d"`
-	Msg          interface{}     `json:"m"`
+	Id           types.MessageId     `json:"i"`
+	CreationDate time.Time           `json:"d"`
+	Msg          interface{}         `json:"m"`
+	Headers      types.RecordHeaders `json:"h,omitempty"`
+	offset       uint64              // size of all the records added before this one
+	size         uint64
 }
 
-func (s *InMemoryStream) AddRecord(record *types.DeferedStreamRecord) error {
+func (r *InMemoryRecord) ToMessage() map[string]interface{} {
+	// same format as the records read from the other storage providers
+	message := map[string]interface{}{
+		"i": r.Id,
+		"d": r.CreationDate.Format(time.RFC3339Nano),
+		"m": r.Msg,
+	}
+	if len(r.Headers) > 0 {
+		// jq filters only handle json types
+		headers := make(map[string]interface{}, len(r.Headers))
+		for name, value := range r.Headers {
+			headers[name] = value
+		}
+		message["h"] = headers
+	}
+	return message
+}
+
+func (s *InMemoryStream) AddRecord(record *types.DeferedStreamRecord, sizeInBytes uint64) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -35,31 +59,121 @@ func (s *InMemoryStream) AddRecord(record *types.DeferedStreamRecord) error {
 	// TODO: check maxSizeInBytes
 
 	// append the record to data memory
-	inMemoryRecord := InMemoryRecord{Id: record.Id, CreationDate: record.CreationDate, Msg: record.Msg}
+	inMemoryRecord := InMemoryRecord{Id: record.Id, CreationDate: record.CreationDate, Msg: record.Msg, Headers: record.Headers, offset: s.sizeInBytes, size: sizeInBytes}
 	s.records = append(s.records, &inMemoryRecord)
+	s.sizeInBytes += sizeInBytes
 
 	return nil
 }
 
-func (s *InMemoryStream) GetRecordAtIndex(index uint64) (*InMemoryRecord, bool, bool) {
+func (s *InMemoryStream) GetRecordAtIndex(index uint64) (*InMemoryRecord, uint64, bool, bool) {
+	// Returns the record at the given index and its index.
+	// If the record was removed by the retention policy, then the first record available is returned.
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if index < s.firstRecordIndex {
+		index = s.firstRecordIndex
+	}
+
 	switch cptRecords := uint64(len(s.records)); {
-	case index+1 == cptRecords:
-		// result is: (record, record found, cannot continue because this is the last record)
-		return s.records[index], true, false
-	case index < cptRecords:
-		// result is: (record, record found, can continue because there are one or many records after this one)
-		return s.records[index], true, true
+	case index-s.firstRecordIndex+1 == cptRecords:
+		// result is: (record, record index, record found, cannot continue because this is the last record)
+		return s.records[index-s.firstRecordIndex], index, true, false
+	case index-s.firstRecordIndex < cptRecords:
+		// result is: (record, record index, record found, can continue because there are one or many records after this one)
+		return s.records[index-s.firstRecordIndex], index, true, true
 	default:
-		// result is: (no record, no record found, cannot continue)
-		return nil, false, false
+		// result is: (no record, record index, no record found, cannot continue)
+		return nil, index, false, false
+	}
+}
+
+func (s *InMemoryStream) GetRecordBeforeIndex(index uint64) (*InMemoryRecord, uint64, bool, bool) {
+	// Returns the record before the given index and its index (read backward).
+	// There is no record before the first record available.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cptRecords := uint64(len(s.records))
+	if index > s.firstRecordIndex+cptRecords {
+		index = s.firstRecordIndex + cptRecords
 	}
+	if index <= s.firstRecordIndex {
+		// result is: (no record, record index, no record found, cannot continue)
+		return nil, s.firstRecordIndex, false, false
+	}
+
+	index--
+	// result is: (record, record index, record found, can continue if there are one or many records before this one)
+	return s.records[index-s.firstRecordIndex], index, true, index > s.firstRecordIndex
+}
+
+func (s *InMemoryStream) GetRecordsByIds(messageIds []types.MessageId) []*InMemoryRecord {
+	// returns the records having the given message ids (nil if the record is not found)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]*InMemoryRecord, len(messageIds))
+	if len(s.records) == 0 {
+		return records
+	}
+	for i, messageId := range messageIds {
+		if recordIndex, err := s.searchRecordIndexByRecordId(messageId, uint64(len(s.records))-1); err == nil {
+			records[i] = s.records[recordIndex]
+		}
+	}
+	return records
+}
+
+func (s *InMemoryStream) GetRecordsUntil(lastMsgId types.MessageId) []*InMemoryRecord {
+	// returns the records having an id lower or equal to the given message id
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cptRecords := sort.Search(len(s.records), func(rank int) bool { return s.records[rank].Id > lastMsgId })
+	return append([]*InMemoryRecord{}, s.records[:cptRecords]...)
 }
 
-func (s *InMemoryStream) GetRecordsCount() uint64 {
-	return uint64(len(s.records))
+func (s *InMemoryStream) GetIndexRange() (uint64, uint64) {
+	// returns the index of the first record and the count of records
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.firstRecordIndex, uint64(len(s.records))
+}
+
+func (s *InMemoryStream) Trim(policy *types.RetentionPolicy, now time.Time) (uint64, uint64, *InMemoryRecord, error) {
+	// Remove the records that are out of the retention policy.
+	// Returns the count of records removed, their size and the new first record (nil if the stream is empty)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cptRecords := int64(len(s.records))
+	if cptRecords == 0 {
+		return 0, 0, nil, nil
+	}
+
+	cptToRemove, err := policy.CountRecordsToRemove(cptRecords, now, func(rank int64) (int64, uint64, error) {
+		record := s.records[rank]
+		return record.CreationDate.UnixNano(), s.sizeInBytes - record.offset, nil
+	})
+	if err != nil || cptToRemove == 0 {
+		return 0, 0, nil, err
+	}
+
+	var firstRecord *InMemoryRecord
+	sizeInBytesRemoved := s.sizeInBytes - s.records[0].offset
+	if cptToRemove < cptRecords {
+		firstRecord = s.records[cptToRemove]
+		sizeInBytesRemoved = firstRecord.offset - s.records[0].offset
+	}
+
+	// copy the remaining records so that the memory of the removed records can be released
+	s.records = append(make([]*InMemoryRecord, 0, cptRecords-cptToRemove), s.records[cptToRemove:]...)
+	s.firstRecordIndex += uint64(cptToRemove)
+
+	return uint64(cptToRemove), sizeInBytesRemoved, firstRecord, nil
 }
 
 func (s *InMemoryStream) GetIndexAtMessageId(messageId types.MessageId) (uint64, error) {
@@ -71,7 +185,8 @@ func (s *InMemoryStream) GetIndexAtMessageId(messageId types.MessageId) (uint64,
 		return 0, errors.New("no matching record not found")
 	}
 
-	return s.searchRecordIndexByRecordId(messageId, cptRecords-1)
+	recordIndex, err := s.searchRecordIndexByRecordId(messageId, cptRecords-1)
+	return s.firstRecordIndex + recordIndex, err
 }
 
 func (s *InMemoryStream) GetIndexAfterMessageId(messageId types.MessageId) (uint64, error) {
@@ -86,7 +201,7 @@ func (s *InMemoryStream) GetIndexAfterMessageId(messageId types.MessageId) (uint
 	if recordIndex, err := s.searchRecordIndexByRecordId(messageId, cptRecords-1); err != nil {
 		return recordIndex, err
 	} else {
-		return recordIndex + 1, err
+		return s.firstRecordIndex + recordIndex + 1, err
 	}
 }
 
@@ -100,7 +215,24 @@ func (s *InMemoryStream) GetIndexAtTimestamp(timestamp *time.Time) (uint64, erro
 	}
 
 	timestampUnixNano := timestamp.UnixNano()
-	return s.searchRecordIndexAtOrAfterTimestamp(timestampUnixNano, cptRecords-1)
+	recordIndex, err := s.searchRecordIndexAtOrAfterTimestamp(timestampUnixNano, cptRecords-1)
+	return s.firstRecordIndex + recordIndex, err
+}
+
+func (s *InMemoryStream) GetIndexAfterTimestamp(timestamp *time.Time) (uint64, error) {
+	// returns the index of the first record created after the given timestamp
+	// (the records before this index were created at or before the timestamp)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timestampUnixNano := timestamp.UnixNano()
+	recordIndex := sort.Search(len(s.records), func(rank int) bool {
+		return s.records[rank].CreationDate.UnixNano() > timestampUnixNano
+	})
+	if recordIndex == 0 {
+		return 0, errors.New("no matching record not found")
+	}
+	return s.firstRecordIndex + uint64(recordIndex), nil
 }
 
 func (s *InMemoryStream) searchRecordIndexByRecordId(messageId types.MessageId, lastIndexRank uint64) (uint64, error) {
@@ -131,10 +263,10 @@ func (s *InMemoryStream) searchRecordIndexByRecordId(messageId types.MessageId,
 		}
 	}
 
-	record = s.records[nextMedianIndexRank]
+	record = s.records[lowIndexRank]
 	if record.Id == messageId {
 		// record id was found
-		return nextMedianIndexRank, nil
+		return lowIndexRank, nil
 	}
 
 	// can't find record id
@@ -171,11 +303,11 @@ func (s *InMemoryStream) searchRecordIndexAtOrAfterTimestamp(timestampUnixNano i
 		}
 	}
 
-	record = s.records[nextMedianIndexRank]
+	record = s.records[lowIndexRank]
 	recordTimestampUnixNano = record.CreationDate.UnixNano()
 	if recordTimestampUnixNano >= timestampUnixNano {
 		// found a message that was created after the given timestamp
-		return nextMedianIndexRank, nil
+		return lowIndexRank, nil
 	}
 
 	// can't find message created after timestamp
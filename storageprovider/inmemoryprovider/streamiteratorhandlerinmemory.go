@@ -15,7 +15,8 @@ type StreamIteratorHandlerInMemory struct {
 	itUUID              types.StreamIteratorUUID
 	initialized         bool
 	inMemoryStream      *InMemoryStream
-	nextReadRecordIndex types.MessageId
+	nextReadRecordIndex types.MessageId // reading backward, the next record read is the one before this index
+	backward            bool
 	reader              *bufio.Reader
 	logger              *zap.Logger
 }
@@ -36,15 +37,27 @@ func (h *StreamIteratorHandlerInMemory) Seek(request *types.StreamIteratorReques
 		return nil
 	}
 
-	cptRecords := h.inMemoryStream.GetRecordsCount()
+	firstRecordIndex, cptRecords := h.inMemoryStream.GetIndexRange()
+
+	if request.IsBackward() {
+		if err = h.seekBackward(request, firstRecordIndex, cptRecords); err == nil {
+			h.initialized = true
+			h.backward = true
+		}
+		return err
+	}
 
 	switch request.IteratorType {
 	case "FIRST_MESSAGE":
-		h.nextReadRecordIndex = 0
+		h.nextReadRecordIndex = firstRecordIndex
 	case "LAST_MESSAGE":
-		h.nextReadRecordIndex = cptRecords
+		if cptRecords > 0 {
+			h.nextReadRecordIndex = firstRecordIndex + cptRecords - 1
+		} else {
+			h.nextReadRecordIndex = firstRecordIndex
+		}
 	case "AFTER_LAST_MESSAGE":
-		h.nextReadRecordIndex = cptRecords + 1
+		h.nextReadRecordIndex = firstRecordIndex + cptRecords
 	case "AT_MESSAGE_ID":
 		h.nextReadRecordIndex, err = h.inMemoryStream.GetIndexAtMessageId(request.MessageId)
 	case "AFTER_MESSAGE_ID":
@@ -63,6 +76,29 @@ func (h *StreamIteratorHandlerInMemory) Seek(request *types.StreamIteratorReques
 	return err
 }
 
+func (h *StreamIteratorHandlerInMemory) seekBackward(request *types.StreamIteratorRequest, firstRecordIndex uint64, cptRecords uint64) error {
+	// the next record read is the one before the index
+	var err error
+	switch request.IteratorType {
+	case "FIRST_MESSAGE":
+		h.nextReadRecordIndex = firstRecordIndex + min(cptRecords, 1)
+	case "LAST_MESSAGE", "AFTER_LAST_MESSAGE":
+		h.nextReadRecordIndex = firstRecordIndex + cptRecords
+	case "AT_MESSAGE_ID":
+		if h.nextReadRecordIndex, err = h.inMemoryStream.GetIndexAtMessageId(request.MessageId); err == nil {
+			h.nextReadRecordIndex++
+		}
+	case "AFTER_MESSAGE_ID":
+		h.nextReadRecordIndex, err = h.inMemoryStream.GetIndexAtMessageId(request.MessageId)
+	case "AT_TIMESTAMP":
+		h.nextReadRecordIndex, err = h.inMemoryStream.GetIndexAfterTimestamp(&request.Timestamp)
+	default:
+		h.nextReadRecordIndex = 0
+		err = errors.New("invalid iterator type")
+	}
+	return err
+}
+
 func (h *StreamIteratorHandlerInMemory) SaveSeek() error {
 	// in memory stream iterator is not persisted,
 	// therefore: nothing to do
@@ -70,12 +106,27 @@ func (h *StreamIteratorHandlerInMemory) SaveSeek() error {
 }
 
 func (h *StreamIteratorHandlerInMemory) GetNextRecord() (types.MessageId, interface{}, bool, bool, error) {
-	recordIndex := h.nextReadRecordIndex
-	record, foundRecord, mayContinue := h.inMemoryStream.GetRecordAtIndex(recordIndex)
-	if foundRecord {
-		h.nextReadRecordIndex++
+	var (
+		record      *InMemoryRecord
+		recordIndex uint64
+		foundRecord bool
+		mayContinue bool
+	)
+	if h.backward {
+		record, recordIndex, foundRecord, mayContinue = h.inMemoryStream.GetRecordBeforeIndex(h.nextReadRecordIndex)
+		if !foundRecord {
+			return 0, nil, false, false, nil
+		}
+		h.nextReadRecordIndex = recordIndex
+	} else {
+		// the index moves to the first record available if the next record was removed by the retention policy
+		record, recordIndex, foundRecord, mayContinue = h.inMemoryStream.GetRecordAtIndex(h.nextReadRecordIndex)
+		if !foundRecord {
+			return 0, nil, false, false, nil
+		}
+		h.nextReadRecordIndex = recordIndex + 1
 	}
-	return recordIndex, record, foundRecord, mayContinue, nil
+	return record.Id, record.ToMessage(), foundRecord, mayContinue, nil
 }
 
 func NewStreamIteratorHandlerInMemory(streamUUID types.StreamUUID, iteratorUUID types.StreamIteratorUUID, inMemoryStream *InMemoryStream, logger *zap.Logger) *StreamIteratorHandlerInMemory {
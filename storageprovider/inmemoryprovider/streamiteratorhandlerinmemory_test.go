@@ -0,0 +1,73 @@
+package inmemoryprovider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestForwardIteratorInMemory(t *testing.T) {
+	info := types.NewStreamInfo(uuid.New())
+	inMemoryStream, err := NewInMemoryStream(info, 0, 0)
+	if err != nil {
+		t.Fatalf("could not create stream: %v", err)
+	}
+	// the message ids are not the ranks of the records
+	start := time.Now()
+	firstMsgId, lastMsgId := types.MessageId(101), types.MessageId(111)
+	addRecord := func(msgId types.MessageId) {
+		record := types.DeferedStreamRecord{Id: msgId, CreationDate: start.Add(time.Duration(msgId) * time.Second), Msg: map[string]interface{}{"n": msgId}}
+		if err := inMemoryStream.AddRecord(&record, 10); err != nil {
+			t.Fatalf("could not add record: %v", err)
+		}
+	}
+	for msgId := firstMsgId; msgId <= lastMsgId; msgId++ {
+		addRecord(msgId)
+	}
+
+	newIterator := func(req *types.StreamIteratorRequest) *StreamIteratorHandlerInMemory {
+		it := NewStreamIteratorHandlerInMemory(info.UUID, uuid.New(), inMemoryStream, zap.NewNop())
+		if err := it.Open(); err != nil {
+			t.Fatalf("could not open iterator: %v", err)
+		}
+		if err := it.Seek(req); err != nil {
+			t.Fatalf("could not seek iterator %+v: %v", req, err)
+		}
+		return it
+	}
+	expectRecords := func(it *StreamIteratorHandlerInMemory, firstMsgId types.MessageId, lastMsgId types.MessageId) {
+		for expectedId := firstMsgId; expectedId <= lastMsgId; expectedId++ {
+			msgId, record, found, _, err := it.GetNextRecord()
+			if err != nil || !found || msgId != expectedId {
+				t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+			}
+			if n := record.(map[string]interface{})["m"].(map[string]interface{})["n"]; n != expectedId {
+				t.Fatalf("unexpected record %v for message %d", record, expectedId)
+			}
+		}
+		if msgId, _, found, _, err := it.GetNextRecord(); err != nil || found {
+			t.Fatalf("expected no more record, got %d (%v)", msgId, err)
+		}
+	}
+
+	// every message is found by its id or its date, not only the ones the binary search visits first
+	for msgId := firstMsgId; msgId <= lastMsgId; msgId++ {
+		expectRecords(newIterator(&types.StreamIteratorRequest{IteratorType: "AT_MESSAGE_ID", MessageId: msgId}), msgId, lastMsgId)
+		expectRecords(newIterator(&types.StreamIteratorRequest{IteratorType: "AFTER_MESSAGE_ID", MessageId: msgId}), msgId+1, lastMsgId)
+		expectRecords(newIterator(&types.StreamIteratorRequest{IteratorType: "AT_TIMESTAMP", Timestamp: start.Add(time.Duration(msgId)*time.Second - time.Millisecond)}), msgId, lastMsgId)
+	}
+	expectRecords(newIterator(&types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}), firstMsgId, lastMsgId)
+
+	// the last message is read once, after the last message only the next messages are read
+	lastIt := newIterator(&types.StreamIteratorRequest{IteratorType: "LAST_MESSAGE"})
+	afterLastIt := newIterator(&types.StreamIteratorRequest{IteratorType: "AFTER_LAST_MESSAGE"})
+	expectRecords(lastIt, lastMsgId, lastMsgId)
+	expectRecords(afterLastIt, lastMsgId+1, lastMsgId)
+	addRecord(lastMsgId + 1)
+	expectRecords(lastIt, lastMsgId+1, lastMsgId+1)
+	expectRecords(afterLastIt, lastMsgId+1, lastMsgId+1)
+}
@@ -3,6 +3,7 @@ package inmemoryprovider
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/nbigot/ministream/types"
 
@@ -58,8 +59,8 @@ func (w *StreamWriterInMemory) Write(records *[]types.DeferedStreamRecord) error
 	}
 
 	if w.info.ReadableMessages.CptMessages == 0 {
-		// first message ever of the stream
-		w.info.ReadableMessages.FirstMsgId = w.info.IngestedMessages.FirstMsgId
+		// first message ever of the stream (or the stream was emptied by the retention policy)
+		w.info.ReadableMessages.FirstMsgId = (*records)[0].Id
 		w.info.ReadableMessages.LastMsgId = 0
 		w.info.ReadableMessages.FirstMsgTimestamp = (*records)[0].CreationDate
 	}
@@ -77,20 +78,65 @@ func (w *StreamWriterInMemory) Write(records *[]types.DeferedStreamRecord) error
 		}
 
 		// append the record to data memory
-		if err := w.inMemoryStream.AddRecord(&record); err != nil {
+		sizeInBytes := uint64(len(fmt.Sprintf("%v", record.Msg)))
+		if err := w.inMemoryStream.AddRecord(&record, sizeInBytes); err != nil {
 			return err
 		}
 
 		// update info
 		w.info.ReadableMessages.CptMessages += 1
 		w.info.ReadableMessages.LastMsgTimestamp = record.CreationDate
-		w.info.ReadableMessages.SizeInBytes += uint64(len(fmt.Sprintf("%v", record.Msg)))
+		w.info.ReadableMessages.SizeInBytes += sizeInBytes
 		w.info.ReadableMessages.LastMsgId = record.Id
 	}
 
 	return nil
 }
 
+func (w *StreamWriterInMemory) Sync() error {
+	// the records are kept in memory only, there is nothing to flush
+	return nil
+}
+
+func (w *StreamWriterInMemory) Reencrypt() (types.Size64, error) {
+	// the records are kept in memory only, there is nothing encrypted at rest
+	return 0, nil
+}
+
+func (w *StreamWriterInMemory) Scrub() (*types.StreamScrubReport, error) {
+	// the records are not stored, therefore there is nothing to verify
+	return types.NewStreamScrubReport(), nil
+}
+
+func (w *StreamWriterInMemory) Trim(policy *types.RetentionPolicy, now time.Time) (types.Size64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cptRemoved, sizeInBytesRemoved, firstRecord, err := w.inMemoryStream.Trim(policy, now)
+	if err != nil || cptRemoved == 0 {
+		return 0, err
+	}
+
+	var firstMsgId types.MessageId
+	var firstMsgTimestamp time.Time
+	if firstRecord != nil {
+		firstMsgId = firstRecord.Id
+		firstMsgTimestamp = firstRecord.CreationDate
+	}
+	w.info.ReadableMessages.TrimHead(cptRemoved, sizeInBytesRemoved, firstMsgId, firstMsgTimestamp)
+
+	w.logger.Info(
+		"Stream trimmed",
+		zap.String("topic", "stream"),
+		zap.String("method", "Trim"),
+		zap.String("stream.uuid", w.info.UUID.String()),
+		zap.Uint64("records.removed", cptRemoved),
+		zap.Uint64("bytes.removed", sizeInBytesRemoved),
+	)
+
+	return cptRemoved, nil
+}
+
 func NewStreamWriterInMemory(info *types.StreamInfo, inMemoryStream *InMemoryStream, logger *zap.Logger, logVerbosity int) *StreamWriterInMemory {
 	return &StreamWriterInMemory{
 		logger:         logger,
@@ -0,0 +1,79 @@
+package inmemoryprovider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestTrimStreamInMemory(t *testing.T) {
+	info := types.NewStreamInfo(uuid.New())
+	inMemoryStream, err := NewInMemoryStream(info, 0, 0)
+	if err != nil {
+		t.Fatalf("could not create stream: %v", err)
+	}
+	w := NewStreamWriterInMemory(info, inMemoryStream, zap.NewNop(), 0)
+
+	// the record n is created at start + n seconds, its size is the size of "map[n:<n>]"
+	start := time.Now()
+	records := make([]types.DeferedStreamRecord, 0, 10)
+	for i := 1; i <= 10; i++ {
+		records = append(records, types.DeferedStreamRecord{Id: types.MessageId(i), CreationDate: start.Add(time.Duration(i) * time.Second), Msg: map[string]interface{}{"n": i}})
+	}
+	if err = w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+
+	// an iterator reading the stream while it is trimmed
+	it := NewStreamIteratorHandlerInMemory(info.UUID, uuid.New(), inMemoryStream, zap.NewNop())
+	req := types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}
+	if err = it.Seek(&req); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	if msgId, _, _, _, err := it.GetNextRecord(); err != nil || msgId != 1 {
+		t.Fatalf("expected message 1, got %d (%v)", msgId, err)
+	}
+
+	now := start.Add(10 * time.Second)
+	trim := func(policy types.RetentionPolicy, expectedCptRemoved types.Size64, expectedFirstMsgId types.MessageId) {
+		t.Helper()
+		cptRemoved, err := w.Trim(&policy, now)
+		if err != nil || cptRemoved != expectedCptRemoved {
+			t.Fatalf("expected %d records removed by %+v, got %d (%v)", expectedCptRemoved, policy, cptRemoved, err)
+		}
+		readable := info.ReadableMessages
+		if readable.FirstMsgId != expectedFirstMsgId || readable.LastMsgId != 10 || readable.CptMessages != types.Size64(11-expectedFirstMsgId) {
+			t.Fatalf("unexpected readable messages %+v after %+v", readable, policy)
+		}
+		if readable.FirstMsgTimestamp != start.Add(time.Duration(expectedFirstMsgId)*time.Second) {
+			t.Fatalf("unexpected first message timestamp %s after %+v", readable.FirstMsgTimestamp, policy)
+		}
+	}
+	trim(types.RetentionPolicy{MaxRecords: 8}, 2, 3)
+	trim(types.RetentionPolicy{MaxRecords: 8}, 0, 3)
+	trim(types.RetentionPolicy{MaxAge: 5 * time.Second}, 2, 5)
+	// the records 9 and 10 take 8 + 9 bytes
+	trim(types.RetentionPolicy{MaxBytes: 20}, 4, 9)
+	if info.ReadableMessages.SizeInBytes != 17 {
+		t.Fatalf("expected 17 bytes left, got %d", info.ReadableMessages.SizeInBytes)
+	}
+
+	// the next record read by the iterator was removed, it moves to the head of the stream
+	for _, expectedId := range []types.MessageId{9, 10} {
+		if msgId, _, found, _, err := it.GetNextRecord(); err != nil || !found || msgId != expectedId {
+			t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+		}
+	}
+
+	// the policy removes all the records
+	if cptRemoved, err := w.Trim(&types.RetentionPolicy{MaxAge: time.Millisecond}, now.Add(time.Second)); err != nil || cptRemoved != 2 {
+		t.Fatalf("expected 2 records removed, got %d (%v)", cptRemoved, err)
+	}
+	if info.ReadableMessages.CptMessages != 0 || info.ReadableMessages.SizeInBytes != 0 {
+		t.Fatalf("expected an empty stream, got %+v", info.ReadableMessages)
+	}
+}
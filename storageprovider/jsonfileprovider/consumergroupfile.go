@@ -0,0 +1,144 @@
+package jsonfileprovider
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+)
+
+type consumerGroupsSerializeStruct struct {
+	ConsumerGroups types.ConsumerGroupList `json:"consumerGroups"`
+}
+
+func (s *FileStorage) LoadConsumerGroups(streamUUID types.StreamUUID) (types.ConsumerGroupList, error) {
+	s.muConsumerGroups.Lock()
+	defer s.muConsumerGroups.Unlock()
+
+	return s.loadConsumerGroupsFile(streamUUID)
+}
+
+func (s *FileStorage) SaveConsumerGroup(group *types.ConsumerGroup) error {
+	s.muConsumerGroups.Lock()
+	defer s.muConsumerGroups.Unlock()
+
+	groups, err := s.loadConsumerGroupsFile(group.StreamUUID)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for idx, g := range groups {
+		if g.Name == group.Name {
+			groups[idx] = group
+			found = true
+			break
+		}
+	}
+	if !found {
+		groups = append(groups, group)
+	}
+
+	return s.saveConsumerGroupsFile(group.StreamUUID, groups)
+}
+
+func (s *FileStorage) DeleteConsumerGroup(streamUUID types.StreamUUID, name string) error {
+	s.muConsumerGroups.Lock()
+	defer s.muConsumerGroups.Unlock()
+
+	groups, err := s.loadConsumerGroupsFile(streamUUID)
+	if err != nil {
+		return err
+	}
+
+	remainingGroups := make(types.ConsumerGroupList, 0, len(groups))
+	for _, g := range groups {
+		if g.Name != name {
+			remainingGroups = append(remainingGroups, g)
+		}
+	}
+
+	return s.saveConsumerGroupsFile(streamUUID, remainingGroups)
+}
+
+func (s *FileStorage) loadConsumerGroupsFile(streamUUID types.StreamUUID) (types.ConsumerGroupList, error) {
+	filename := s.GetConsumerGroupsFilePath(streamUUID)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// no consumer group has ever been saved for this stream
+			return types.ConsumerGroupList{}, nil
+		}
+		s.logger.Error(
+			"Can't read consumer groups file",
+			zap.String("topic", "stream"),
+			zap.String("method", "loadConsumerGroupsFile"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.String("filename", filename),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	obj := consumerGroupsSerializeStruct{}
+	if err = json.Unmarshal(data, &obj); err != nil {
+		s.logger.Error(
+			"Can't decode json consumer groups",
+			zap.String("topic", "stream"),
+			zap.String("method", "loadConsumerGroupsFile"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.String("filename", filename),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	if obj.ConsumerGroups == nil {
+		obj.ConsumerGroups = types.ConsumerGroupList{}
+	}
+
+	return obj.ConsumerGroups, nil
+}
+
+func (s *FileStorage) saveConsumerGroupsFile(streamUUID types.StreamUUID, groups types.ConsumerGroupList) error {
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	filename := s.GetConsumerGroupsFilePath(streamUUID)
+	data, err := json.Marshal(consumerGroupsSerializeStruct{ConsumerGroups: groups})
+	if err != nil {
+		return err
+	}
+
+	// write into a temporary file then rename it,
+	// therefore the committed offsets are never lost if the server crashes while saving
+	tmpFilename := filename + ".tmp"
+	if err = os.WriteFile(tmpFilename, data, 0644); err != nil {
+		s.logger.Error(
+			"Can't save consumer groups",
+			zap.String("topic", "stream"),
+			zap.String("method", "saveConsumerGroupsFile"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.String("filename", tmpFilename),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	if err = os.Rename(tmpFilename, filename); err != nil {
+		s.logger.Error(
+			"Can't save consumer groups",
+			zap.String("topic", "stream"),
+			zap.String("method", "saveConsumerGroupsFile"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.String("filename", filename),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
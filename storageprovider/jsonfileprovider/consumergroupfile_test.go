@@ -0,0 +1,71 @@
+package jsonfileprovider
+
+import (
+	"os"
+	"testing"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestConsumerGroupsFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	s := &FileStorage{logger: zap.NewExample(), dataDirectory: tmpDir}
+	streamUUID := uuid.New()
+	if err := s.CreateStreamDirectory(streamUUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+
+	groups, err := s.LoadConsumerGroups(streamUUID)
+	if err != nil {
+		t.Fatalf("could not load consumer groups: %v", err)
+	}
+	if len(groups) != 0 {
+		t.Fatalf("expected no consumer group, got %d", len(groups))
+	}
+
+	groupA := types.NewConsumerGroup(streamUUID, "a")
+	groupA.CommittedMsgId = 10
+	groupB := types.NewConsumerGroup(streamUUID, "b")
+	groupB.CommittedMsgId = 20
+	for _, g := range []*types.ConsumerGroup{groupB, groupA} {
+		if err = s.SaveConsumerGroup(g); err != nil {
+			t.Fatalf("could not save consumer group: %v", err)
+		}
+	}
+
+	// update an existing consumer group
+	groupA.CommittedMsgId = 15
+	if err = s.SaveConsumerGroup(groupA); err != nil {
+		t.Fatalf("could not save consumer group: %v", err)
+	}
+
+	if groups, err = s.LoadConsumerGroups(streamUUID); err != nil {
+		t.Fatalf("could not load consumer groups: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 consumer groups, got %d", len(groups))
+	}
+	if groups[0].Name != "a" || groups[0].CommittedMsgId != 15 {
+		t.Fatalf("unexpected consumer group %+v", groups[0])
+	}
+	if groups[1].Name != "b" || groups[1].CommittedMsgId != 20 {
+		t.Fatalf("unexpected consumer group %+v", groups[1])
+	}
+
+	if err = s.DeleteConsumerGroup(streamUUID, "a"); err != nil {
+		t.Fatalf("could not delete consumer group: %v", err)
+	}
+	if groups, err = s.LoadConsumerGroups(streamUUID); err != nil {
+		t.Fatalf("could not load consumer groups: %v", err)
+	}
+	if len(groups) != 1 || groups[0].Name != "b" {
+		t.Fatalf("unexpected consumer groups after delete %+v", groups)
+	}
+
+	if _, err = os.Stat(s.GetConsumerGroupsFilePath(streamUUID) + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file should not remain")
+	}
+}
@@ -1,15 +1,20 @@
 package jsonfileprovider
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/nbigot/ministream/buffering"
 	"github.com/nbigot/ministream/config"
+	"github.com/nbigot/ministream/encryption"
 	"github.com/nbigot/ministream/storageprovider"
 	"github.com/nbigot/ministream/storageprovider/catalog"
 	"github.com/nbigot/ministream/types"
 
+	"github.com/dustin/go-humanize"
 	"github.com/google/uuid"
 
 	"go.uber.org/zap"
@@ -21,18 +26,27 @@ type FileStorage struct {
 	logVerbosity  int
 	catalog       catalog.IStorageCatalog
 	dataDirectory string // root directory to store all data and streams
+	// a new segment of a stream is started when the active segment reaches the max size or age (0 means unlimited)
+	segmentMaxSize int64
+	segmentMaxAge  time.Duration
+	// the sealed segments are compressed by blocks (none, gzip or zstd)
+	segmentCompression   string
+	compressionBlockSize int64
+	// behavior of the iterators reading a record that doesn't match its checksum (none, skip, count or fail)
+	checksumMismatch string
+	// the payload of the new records is encrypted when encryptRecords is true,
+	// the records already encrypted are decrypted with the key ring (nil if no key is configured)
+	keyRing        *encryption.KeyRing
+	encryptRecords bool
+	// protect the consumer groups and schemas files (each producer has its own file)
+	muConsumerGroups sync.Mutex
+	muSchemas        sync.Mutex
 }
 
 type streamListSerializeStruct struct {
 	StreamsUUID types.StreamUUIDList `json:"streams"`
 }
 
-type streamRowIndex struct {
-	msgId      types.MessageId
-	bytesCount int32
-	dateTime   int64
-}
-
 func (s *FileStorage) Init() error {
 	var err error
 
@@ -95,6 +109,13 @@ func (s *FileStorage) LoadStreams() (types.StreamInfoList, error) {
 		return l, err
 	}
 
+	// the server may have stopped while writing the streams, the tail of their active segment is repaired
+	for _, info := range l {
+		if _, err = s.CheckStream(info, false, true); err != nil {
+			return l, err
+		}
+	}
+
 	return l, nil
 }
 
@@ -149,6 +170,22 @@ func (s *FileStorage) GetStreamIndexFilePath(streamUUID types.StreamUUID) string
 	return filepath.Join(s.GetStreamDirectoryPath(streamUUID), "index.bin")
 }
 
+func (s *FileStorage) GetConsumerGroupsFilePath(streamUUID types.StreamUUID) string {
+	return filepath.Join(s.GetStreamDirectoryPath(streamUUID), "consumergroups.json")
+}
+
+func (s *FileStorage) GetSchemasFilePath(streamUUID types.StreamUUID) string {
+	return filepath.Join(s.GetStreamDirectoryPath(streamUUID), "schemas.json")
+}
+
+func (s *FileStorage) GetProducersDirectoryPath(streamUUID types.StreamUUID) string {
+	return filepath.Join(s.GetStreamDirectoryPath(streamUUID), "producers")
+}
+
+func (s *FileStorage) GetProducerFilePath(streamUUID types.StreamUUID, producerId string) string {
+	return filepath.Join(s.GetProducersDirectoryPath(streamUUID), producerId+".json")
+}
+
 func (s *FileStorage) CreateDataDirectory() error {
 	return os.MkdirAll(s.GetDataDirectory(), os.ModePerm)
 }
@@ -163,7 +200,7 @@ func (s *FileStorage) CreateStreamDirectory(streamUUID types.StreamUUID) error {
 
 func (s *FileStorage) NewStreamIteratorHandler(streamUUID types.StreamUUID, iteratorUUID types.StreamIteratorUUID) (types.IStreamIteratorHandler, error) {
 	idx := NewStreamIndex(streamUUID, s.GetStreamIndexFilePath(streamUUID), s.logger)
-	return NewStreamIteratorHandlerFile(streamUUID, iteratorUUID, s.GetStreamDataFilePath(streamUUID), idx, s.logger), nil
+	return NewStreamIteratorHandlerFile(streamUUID, iteratorUUID, s.GetStreamDataFilePath(streamUUID), idx, s.logger, WithChecksumMismatch(s.checksumMismatch), WithKeyRing(s.keyRing)), nil
 }
 
 func (s *FileStorage) DeleteStream(streamUUID types.StreamUUID) error {
@@ -177,7 +214,7 @@ func (s *FileStorage) NewStreamWriter(info *types.StreamInfo) (buffering.IStream
 	fileDataPath := s.GetStreamDataFilePath(info.UUID)
 	fileIndexPath := s.GetStreamIndexFilePath(info.UUID)
 	fileMetaInfoPath := s.GetMetaDataFilePath(info.UUID)
-	w := NewStreamWriterFile(info, fileDataPath, fileIndexPath, fileMetaInfoPath, s.logger, s.logVerbosity)
+	w := NewStreamWriterFile(info, fileDataPath, fileIndexPath, fileMetaInfoPath, s.logger, s.logVerbosity, WithSegmentRolling(s.segmentMaxSize, s.segmentMaxAge), WithSegmentCompression(s.segmentCompression, s.compressionBlockSize), WithEncryption(s.keyRing, s.encryptRecords))
 	return w, nil
 }
 
@@ -187,10 +224,52 @@ func (s *FileStorage) BuildIndex(streamUUID types.StreamUUID) (interface{}, erro
 }
 
 func NewStorageProvider(logger *zap.Logger, conf *config.Config) (storageprovider.IStorageProvider, error) {
+	var segmentMaxSize uint64
+	if maxSize := conf.Storage.JSONFile.Segments.MaxSize; maxSize != "" && maxSize != "0" {
+		var err error
+		if segmentMaxSize, err = humanize.ParseBytes(maxSize); err != nil {
+			return nil, fmt.Errorf("cannot parse value for configuration storage.jsonfile.segments.maxSize: %s", err.Error())
+		}
+	}
+	segmentCompression, err := ParseSegmentCompression(conf.Storage.JSONFile.Segments.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse value for configuration storage.jsonfile.segments.compression: %s", err.Error())
+	}
+	var compressionBlockSize uint64 = DefaultSegmentCompressionBlockSize
+	if blockSize := conf.Storage.JSONFile.Segments.CompressionBlockSize; blockSize != "" {
+		if compressionBlockSize, err = humanize.ParseBytes(blockSize); err != nil {
+			return nil, fmt.Errorf("cannot parse value for configuration storage.jsonfile.segments.compressionBlockSize: %s", err.Error())
+		}
+		if compressionBlockSize == 0 {
+			return nil, fmt.Errorf("invalid value for configuration storage.jsonfile.segments.compressionBlockSize: must be greater than 0")
+		}
+	}
+	checksumMismatch, err := ParseChecksumMismatch(conf.Storage.JSONFile.Checksums.OnMismatch)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse value for configuration storage.jsonfile.checksums.onMismatch: %s", err.Error())
+	}
+	var keyRing *encryption.KeyRing
+	encryptionConf := &conf.Storage.JSONFile.Encryption
+	if encryptionConf.KeyFile != "" || encryptionConf.Keys != "" {
+		if keyRing, err = encryption.LoadKeyRing(encryptionConf.KeyFile, encryptionConf.Keys, encryptionConf.ActiveKeyId); err != nil {
+			return nil, fmt.Errorf("cannot load the keys of configuration storage.jsonfile.encryption: %s", err.Error())
+		}
+	}
+	if encryptionConf.Enabled && keyRing == nil {
+		return nil, fmt.Errorf("invalid configuration storage.jsonfile.encryption: a key file or keys are required")
+	}
+
 	return &FileStorage{
-		logger:        logger,
-		logVerbosity:  conf.Storage.LogVerbosity,
-		dataDirectory: conf.Storage.JSONFile.DataDirectory,
-		catalog:       NewStreamCatalogFile(logger, conf.Storage.JSONFile.DataDirectory, GetStreamCatalogFilepath(conf.Storage.JSONFile.DataDirectory)),
+		logger:               logger,
+		logVerbosity:         conf.Storage.LogVerbosity,
+		dataDirectory:        conf.Storage.JSONFile.DataDirectory,
+		segmentMaxSize:       int64(segmentMaxSize),
+		segmentMaxAge:        time.Duration(conf.Storage.JSONFile.Segments.MaxAge) * time.Second,
+		segmentCompression:   segmentCompression,
+		compressionBlockSize: int64(compressionBlockSize),
+		checksumMismatch:     checksumMismatch,
+		keyRing:              keyRing,
+		encryptRecords:       encryptionConf.Enabled,
+		catalog:              NewStreamCatalogFile(logger, conf.Storage.JSONFile.DataDirectory, GetStreamCatalogFilepath(conf.Storage.JSONFile.DataDirectory)),
 	}, nil
 }
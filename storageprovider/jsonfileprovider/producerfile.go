@@ -0,0 +1,131 @@
+package jsonfileprovider
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+)
+
+func (s *FileStorage) LoadProducers(streamUUID types.StreamUUID) (types.ProducerList, error) {
+	directory := s.GetProducersDirectoryPath(streamUUID)
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// no idempotent producer has ever put records into this stream
+			return types.ProducerList{}, nil
+		}
+		s.logger.Error(
+			"Can't read producers directory",
+			zap.String("topic", "stream"),
+			zap.String("method", "LoadProducers"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.String("directory", directory),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	producers := make(types.ProducerList, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			// ignore the temporary file of a producer whose save was interrupted
+			continue
+		}
+		producer, err := s.loadProducerFile(streamUUID, filepath.Join(directory, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		producers = append(producers, producer)
+	}
+	sort.Slice(producers, func(i, j int) bool { return producers[i].Id < producers[j].Id })
+	return producers, nil
+}
+
+func (s *FileStorage) SaveProducer(producer *types.Producer) error {
+	// each producer has its own file: the batches of a producer are saved one at a time by the stream,
+	// the producers of a stream (and of the other streams) are saved concurrently
+	directory := s.GetProducersDirectoryPath(producer.StreamUUID)
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(producer)
+	if err != nil {
+		return err
+	}
+
+	// write and sync a temporary file then rename it,
+	// therefore the sequence of the producer is never lost if the server crashes while saving
+	filename := s.GetProducerFilePath(producer.StreamUUID, producer.Id)
+	tmpFilename := filename + ".tmp"
+	if err = writeFileSync(tmpFilename, data); err != nil {
+		s.logger.Error(
+			"Can't save producer",
+			zap.String("topic", "stream"),
+			zap.String("method", "SaveProducer"),
+			zap.String("stream.uuid", producer.StreamUUID.String()),
+			zap.String("producer", producer.Id),
+			zap.String("filename", tmpFilename),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	if err = os.Rename(tmpFilename, filename); err != nil {
+		s.logger.Error(
+			"Can't save producer",
+			zap.String("topic", "stream"),
+			zap.String("method", "SaveProducer"),
+			zap.String("stream.uuid", producer.StreamUUID.String()),
+			zap.String("producer", producer.Id),
+			zap.String("filename", filename),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileStorage) loadProducerFile(streamUUID types.StreamUUID, filename string) (*types.Producer, error) {
+	data, err := os.ReadFile(filename)
+	if err == nil {
+		producer := types.Producer{}
+		if err = json.Unmarshal(data, &producer); err == nil {
+			return &producer, nil
+		}
+	}
+
+	s.logger.Error(
+		"Can't read producer file",
+		zap.String("topic", "stream"),
+		zap.String("method", "loadProducerFile"),
+		zap.String("stream.uuid", streamUUID.String()),
+		zap.String("filename", filename),
+		zap.Error(err),
+	)
+	return nil, err
+}
+
+func writeFileSync(filename string, data []byte) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
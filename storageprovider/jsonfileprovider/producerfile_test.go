@@ -0,0 +1,64 @@
+package jsonfileprovider
+
+import (
+	"os"
+	"testing"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestProducersFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	s := &FileStorage{logger: zap.NewExample(), dataDirectory: tmpDir}
+	streamUUID := uuid.New()
+	if err := s.CreateStreamDirectory(streamUUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+
+	producers, err := s.LoadProducers(streamUUID)
+	if err != nil || len(producers) != 0 {
+		t.Fatalf("expected no producer, got %d (%v)", len(producers), err)
+	}
+
+	producerA := types.NewProducer(streamUUID, "a")
+	producerA.AddBatch(&types.ProducerBatch{Sequence: 2, MessageIds: []types.MessageId{1, 2}})
+	producerB := types.NewProducer(streamUUID, "b")
+	producerB.AddBatch(&types.ProducerBatch{Sequence: 1, MessageIds: []types.MessageId{3}})
+	producerC := types.NewProducer(streamUUID, "c")
+	producerC.AddBatch(&types.ProducerBatch{Sequence: 7, MessageIds: []types.MessageId{4}})
+	for _, p := range []*types.Producer{producerC, producerB, producerA} {
+		if err = s.SaveProducer(p); err != nil {
+			t.Fatalf("could not save producer: %v", err)
+		}
+	}
+
+	// a temporary file left by an interrupted save is ignored
+	if err = os.WriteFile(s.GetProducerFilePath(streamUUID, "d")+".tmp", []byte("{"), 0644); err != nil {
+		t.Fatalf("could not write temporary file: %v", err)
+	}
+
+	if producers, err = s.LoadProducers(streamUUID); err != nil {
+		t.Fatalf("could not load producers: %v", err)
+	}
+	if len(producers) != 3 {
+		t.Fatalf("expected 3 producers, got %d", len(producers))
+	}
+	for i, expected := range []struct {
+		id           string
+		lastSequence uint64
+	}{{"a", 2}, {"b", 1}, {"c", 7}} {
+		if producers[i].Id != expected.id || producers[i].LastSequence != expected.lastSequence {
+			t.Fatalf("unexpected producer %+v", producers[i])
+		}
+	}
+	if batch, found := producers[0].GetBatch(2); !found || len(batch.MessageIds) != 2 {
+		t.Fatalf("unexpected batches %+v", producers[0].Batches)
+	}
+
+	if _, err = os.Stat(s.GetProducerFilePath(streamUUID, "a") + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file should not remain")
+	}
+}
@@ -0,0 +1,85 @@
+package jsonfileprovider
+
+import (
+	"errors"
+	"io"
+
+	"github.com/nbigot/ministream/encryption"
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+)
+
+// usually the data file was replaced (stream trimmed by the retention policy) while the records were read
+var errRecordNotAtIndexPosition = errors.New("record not found at the position given by the index")
+
+func (s *FileStorage) GetRecordsByIds(streamUUID types.StreamUUID, messageIds []types.MessageId) ([]interface{}, error) {
+	// the records are located with the index (nil if the record is not found)
+	idx := NewStreamIndex(streamUUID, s.GetStreamIndexFilePath(streamUUID), s.logger)
+	records, err := readRecordsByIds(s.GetStreamDataFilePath(streamUUID), idx, messageIds, s.keyRing)
+	if errors.Is(err, errRecordNotAtIndexPosition) {
+		// the index and the data file are consistent again
+		records, err = readRecordsByIds(s.GetStreamDataFilePath(streamUUID), idx, messageIds, s.keyRing)
+	}
+	if err != nil {
+		s.logger.Error(
+			"Can't read records",
+			zap.String("topic", "stream"),
+			zap.String("method", "GetRecordsByIds"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.Error(err),
+		)
+	}
+	return records, err
+}
+
+func readRecordsByIds(dataFilePath string, idx *StreamIndexFile, messageIds []types.MessageId, keyRing *encryption.KeyRing) ([]interface{}, error) {
+	records := make([]interface{}, len(messageIds))
+	data, err := openSegmentDataReader(dataFilePath, idx.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = data.Close()
+	}()
+
+	for i, messageId := range messageIds {
+		_, row, err := idx.GetRankAtOrAfterMessageId(messageId)
+		if err != nil {
+			return nil, err
+		}
+		if row == nil || row.Id != messageId {
+			continue
+		}
+
+		record, err := readRecordAtIndexPosition(data, row)
+		if err != nil {
+			return nil, err
+		}
+		if err = decryptStreamRecord(record, keyRing); err != nil {
+			return nil, err
+		}
+		records[i] = record
+	}
+	return records, nil
+}
+
+func readRecordAtIndexPosition(file io.ReaderAt, row *streamIndexRowMsg) (map[string]interface{}, error) {
+	// read the record at the position given by the index row, the record must have the id and the checksum of the row
+	line := make([]byte, row.LengthInBytes)
+	if _, err := file.ReadAt(line, row.Offset); err != nil {
+		return nil, errRecordNotAtIndexPosition
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, errRecordNotAtIndexPosition
+	}
+	if recordId, ok := record["i"].(float64); !ok || types.MessageId(recordId) != row.Id {
+		return nil, errRecordNotAtIndexPosition
+	}
+	if err := row.verifyChecksum(line); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,56 @@
+package jsonfileprovider
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestReadRecordsByIds(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := zap.NewNop()
+	info := types.NewStreamInfo(uuid.New())
+	dataPath := filepath.Join(tmpDir, "data.jsonl")
+	indexPath := filepath.Join(tmpDir, "index.bin")
+	w := NewStreamWriterFile(info, dataPath, indexPath, filepath.Join(tmpDir, "meta.json"), logger, 0)
+	if err := w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+	if err := w.Open(); err != nil {
+		t.Fatalf("could not open stream writer: %v", err)
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+
+	// message ids with gaps (records dropped)
+	records := make([]types.DeferedStreamRecord, 0, 50)
+	for i := 1; i <= 50; i++ {
+		records = append(records, types.DeferedStreamRecord{Id: types.MessageId(2 * i), CreationDate: time.Now(), Msg: map[string]interface{}{"n": 2 * i}})
+	}
+	if err := w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+
+	idx := NewStreamIndex(info.UUID, indexPath, logger)
+	messageIds := []types.MessageId{100, 3, 2, 51, 50, 101}
+	expected := []interface{}{float64(100), nil, float64(2), nil, float64(50), nil}
+	found, err := readRecordsByIds(dataPath, idx, messageIds, nil)
+	if err != nil {
+		t.Fatalf("could not read records: %v", err)
+	}
+	for i, record := range found {
+		var n interface{}
+		if record != nil {
+			n = record.(map[string]interface{})["m"].(map[string]interface{})["n"]
+		}
+		if n != expected[i] {
+			t.Fatalf("message %d: expected %v, got %v", messageIds[i], expected[i], n)
+		}
+	}
+}
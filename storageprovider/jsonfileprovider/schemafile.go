@@ -0,0 +1,133 @@
+package jsonfileprovider
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+)
+
+type schemasSerializeStruct struct {
+	Schemas types.StreamSchemaList `json:"schemas"`
+}
+
+func (s *FileStorage) LoadStreamSchemas(streamUUID types.StreamUUID) (types.StreamSchemaList, error) {
+	s.muSchemas.Lock()
+	defer s.muSchemas.Unlock()
+
+	return s.loadSchemasFile(streamUUID)
+}
+
+func (s *FileStorage) SaveStreamSchema(schema *types.StreamSchema) error {
+	s.muSchemas.Lock()
+	defer s.muSchemas.Unlock()
+
+	schemas, err := s.loadSchemasFile(schema.StreamUUID)
+	if err != nil {
+		return err
+	}
+
+	return s.saveSchemasFile(schema.StreamUUID, append(schemas, schema))
+}
+
+func (s *FileStorage) DeleteStreamSchemas(streamUUID types.StreamUUID) error {
+	s.muSchemas.Lock()
+	defer s.muSchemas.Unlock()
+
+	filename := s.GetSchemasFilePath(streamUUID)
+	if err := os.Remove(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.logger.Error(
+			"Can't delete schemas file",
+			zap.String("topic", "stream"),
+			zap.String("method", "DeleteStreamSchemas"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.String("filename", filename),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileStorage) loadSchemasFile(streamUUID types.StreamUUID) (types.StreamSchemaList, error) {
+	filename := s.GetSchemasFilePath(streamUUID)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// no schema has ever been registered for this stream
+			return types.StreamSchemaList{}, nil
+		}
+		s.logger.Error(
+			"Can't read schemas file",
+			zap.String("topic", "stream"),
+			zap.String("method", "loadSchemasFile"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.String("filename", filename),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	obj := schemasSerializeStruct{}
+	if err = json.Unmarshal(data, &obj); err != nil {
+		s.logger.Error(
+			"Can't decode json schemas",
+			zap.String("topic", "stream"),
+			zap.String("method", "loadSchemasFile"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.String("filename", filename),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	if obj.Schemas == nil {
+		obj.Schemas = types.StreamSchemaList{}
+	}
+
+	return obj.Schemas, nil
+}
+
+func (s *FileStorage) saveSchemasFile(streamUUID types.StreamUUID, schemas types.StreamSchemaList) error {
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Version < schemas[j].Version })
+
+	filename := s.GetSchemasFilePath(streamUUID)
+	data, err := json.Marshal(schemasSerializeStruct{Schemas: schemas})
+	if err != nil {
+		return err
+	}
+
+	// write into a temporary file then rename it,
+	// therefore the registered versions are never lost if the server crashes while saving
+	tmpFilename := filename + ".tmp"
+	if err = os.WriteFile(tmpFilename, data, 0644); err != nil {
+		s.logger.Error(
+			"Can't save schemas",
+			zap.String("topic", "stream"),
+			zap.String("method", "saveSchemasFile"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.String("filename", tmpFilename),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	if err = os.Rename(tmpFilename, filename); err != nil {
+		s.logger.Error(
+			"Can't save schemas",
+			zap.String("topic", "stream"),
+			zap.String("method", "saveSchemasFile"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.String("filename", filename),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
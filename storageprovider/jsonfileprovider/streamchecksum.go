@@ -0,0 +1,56 @@
+package jsonfileprovider
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// The index row of a record holds the CRC32C of the record line (the end of line included),
+// the records are verified when they are read. The rows written before the checksums have a zero checksum,
+// these records are not verified (a computed checksum of zero is stored as is, the record is not verified either).
+
+// Behavior of an iterator reading a record that doesn't match its checksum
+const (
+	ChecksumMismatchNone  = "none"  // the records are not verified
+	ChecksumMismatchSkip  = "skip"  // the record is skipped (logged)
+	ChecksumMismatchCount = "count" // the record is counted as an error, the iterator continues
+	ChecksumMismatchFail  = "fail"  // the iterator stops with an error
+)
+
+var errRecordChecksumMismatch = errors.New("record checksum mismatch")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func ParseChecksumMismatch(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "", ChecksumMismatchCount:
+		return ChecksumMismatchCount, nil
+	case ChecksumMismatchNone:
+		return ChecksumMismatchNone, nil
+	case ChecksumMismatchSkip:
+		return ChecksumMismatchSkip, nil
+	case ChecksumMismatchFail:
+		return ChecksumMismatchFail, nil
+	}
+	return "", fmt.Errorf("invalid checksum mismatch behavior %q (none, skip, count or fail)", value)
+}
+
+func getRecordChecksum(line []byte) uint32 {
+	return crc32.Checksum(line, crc32cTable)
+}
+
+func (row *streamIndexRowMsg) hasChecksum() bool {
+	return row.Checksum != 0
+}
+
+func (row *streamIndexRowMsg) verifyChecksum(line []byte) error {
+	if !row.hasChecksum() {
+		return nil
+	}
+	if int64(len(line)) != int64(row.LengthInBytes) || getRecordChecksum(line) != row.Checksum {
+		return fmt.Errorf("%w (message id %d)", errRecordChecksumMismatch, row.Id)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package jsonfileprovider
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestRecordChecksums(t *testing.T) {
+	logger := zap.NewNop()
+	s := &FileStorage{logger: logger, dataDirectory: t.TempDir()}
+	info := types.NewStreamInfo(uuid.New())
+	if err := s.CreateStreamDirectory(info.UUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+	dataPath := s.GetStreamDataFilePath(info.UUID)
+	indexPath := s.GetStreamIndexFilePath(info.UUID)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	openWriter := func() *StreamWriterFile {
+		w := NewStreamWriterFile(info, dataPath, indexPath, s.GetMetaDataFilePath(info.UUID), logger, 0)
+		if err := w.Init(); err != nil {
+			t.Fatalf("could not init stream writer: %v", err)
+		}
+		if err := w.Open(); err != nil {
+			t.Fatalf("could not open stream writer: %v", err)
+		}
+		return w
+	}
+	scrubStream := func() (*types.StreamScrubReport, error) {
+		w := openWriter()
+		defer func() {
+			_ = w.Close()
+		}()
+		return w.Scrub()
+	}
+	w := openWriter()
+	records := make([]types.DeferedStreamRecord, 0)
+	for i := types.MessageId(1); i <= 10; i++ {
+		records = append(records, types.DeferedStreamRecord{Id: i, CreationDate: start.Add(time.Duration(i) * time.Second), Msg: map[string]interface{}{"n": i}})
+	}
+	if err := w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+	_ = w.Close()
+	info.IngestedMessages = info.ReadableMessages
+
+	report, err := scrubStream()
+	if err != nil || report.CptRecords != 10 || report.CptChecksums != 10 || report.CptCorrupted != 0 {
+		t.Fatalf("unexpected scrub report %+v (%v)", report, err)
+	}
+
+	// the record 5 is modified on the disk (still a valid record)
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		t.Fatalf("could not read data file: %v", err)
+	}
+	if bytes.Count(data, []byte(`"n":5}`)) != 1 {
+		t.Fatalf("unexpected data file %s", data)
+	}
+	if err = os.WriteFile(dataPath, bytes.Replace(data, []byte(`"n":5}`), []byte(`"n":6}`), 1), 0644); err != nil {
+		t.Fatalf("could not write data file: %v", err)
+	}
+
+	expectRecords := func(checksumMismatch string, req *types.StreamIteratorRequest, expectedIds []types.MessageId, corruptedId types.MessageId, canContinueAfterCorrupted bool) {
+		it := NewStreamIteratorHandlerFile(info.UUID, uuid.New(), dataPath, NewStreamIndex(info.UUID, indexPath, logger), logger, WithChecksumMismatch(checksumMismatch))
+		defer func() {
+			_ = it.Close()
+		}()
+		if err := it.Open(); err != nil {
+			t.Fatalf("%s: could not open iterator: %v", checksumMismatch, err)
+		}
+		if err := it.Seek(req); err != nil {
+			t.Fatalf("%s: could not seek iterator: %v", checksumMismatch, err)
+		}
+		for _, expectedId := range expectedIds {
+			msgId, record, found, canContinue, err := it.GetNextRecord()
+			if !found || msgId != expectedId {
+				t.Fatalf("%s: expected message %d, got %d (found %t, %v)", checksumMismatch, expectedId, msgId, found, err)
+			}
+			if msgId == corruptedId {
+				if !errors.Is(err, errRecordChecksumMismatch) || record != nil || canContinue != canContinueAfterCorrupted {
+					t.Fatalf("%s: expected a checksum mismatch for message %d, got %v (can continue %t)", checksumMismatch, msgId, err, canContinue)
+				}
+				if !canContinue {
+					return
+				}
+				continue
+			}
+			if err != nil || !canContinue {
+				t.Fatalf("%s: unexpected error for message %d: %v", checksumMismatch, msgId, err)
+			}
+		}
+		if msgId, _, found, _, err := it.GetNextRecord(); err != nil || found {
+			t.Fatalf("%s: expected no more record, got %d (%v)", checksumMismatch, msgId, err)
+		}
+	}
+	forward := &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}
+	backward := &types.StreamIteratorRequest{IteratorType: "LAST_MESSAGE", Direction: types.IteratorDirectionBackward}
+	expectRecords(ChecksumMismatchCount, forward, []types.MessageId{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 5, true)
+	expectRecords(ChecksumMismatchCount, backward, []types.MessageId{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, 5, true)
+	expectRecords(ChecksumMismatchSkip, forward, []types.MessageId{1, 2, 3, 4, 6, 7, 8, 9, 10}, 0, true)
+	expectRecords(ChecksumMismatchSkip, backward, []types.MessageId{10, 9, 8, 7, 6, 4, 3, 2, 1}, 0, true)
+	expectRecords(ChecksumMismatchFail, forward, []types.MessageId{1, 2, 3, 4, 5}, 5, false)
+	expectRecords(ChecksumMismatchFail, backward, []types.MessageId{10, 9, 8, 7, 6, 5}, 5, false)
+	expectRecords(ChecksumMismatchNone, forward, []types.MessageId{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 0, true)
+
+	if _, err = readRecordsByIds(dataPath, NewStreamIndex(info.UUID, indexPath, logger), []types.MessageId{4, 5}, nil); !errors.Is(err, errRecordChecksumMismatch) {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	report, err = scrubStream()
+	if err != nil || report.CptRecords != 10 || report.CptCorrupted != 1 || len(report.CorruptedIds) != 1 || report.CorruptedIds[0] != 5 {
+		t.Fatalf("unexpected scrub report %+v (%v)", report, err)
+	}
+
+	// the index is not rebuilt from a corrupted record
+	checkReport, err := s.CheckStream(info, true, true)
+	if err != nil || len(checkReport.Issues) != 1 || checkReport.CountUnrepaired() != 1 {
+		t.Fatalf("expected an unrepaired issue, got %+v (%v)", checkReport, err)
+	}
+
+	// a row written before the checksums is not verified
+	index, err := os.OpenFile(indexPath, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("could not open index file: %v", err)
+	}
+	row := streamIndexRowMsg{}
+	if err = readIndexRowAt(index, 4, &row); err != nil {
+		t.Fatalf("could not read index row: %v", err)
+	}
+	row.Checksum = 0
+	var buffer bytes.Buffer
+	_ = binary.Write(&buffer, binary.LittleEndian, row)
+	if _, err = index.WriteAt(buffer.Bytes(), 4*sizeOfStreamIndexRowMsg); err != nil {
+		t.Fatalf("could not write index row: %v", err)
+	}
+	_ = index.Close()
+	report, err = scrubStream()
+	if err != nil || report.CptRecords != 10 || report.CptChecksums != 9 || report.CptCorrupted != 0 {
+		t.Fatalf("unexpected scrub report %+v (%v)", report, err)
+	}
+	expectRecords(ChecksumMismatchFail, forward, []types.MessageId{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 0, true)
+	if checkReport, err = s.CheckStream(info, true, false); err != nil || len(checkReport.Issues) != 0 {
+		t.Fatalf("unexpected issues %+v (%v)", checkReport, err)
+	}
+}
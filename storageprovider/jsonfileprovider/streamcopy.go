@@ -0,0 +1,162 @@
+package jsonfileprovider
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+func (s *FileStorage) CopyRecords(sourceUUID types.StreamUUID, target *types.StreamInfo, lastMsgId types.MessageId) error {
+	// Copy the records of the source stream up to lastMsgId (included) into the target stream (created but not started yet).
+	// The segments of the source are copied up to the last record, the records are not parsed.
+	err := s.copyRecords(sourceUUID, target, lastMsgId)
+	if errors.Is(err, errRecordNotAtIndexPosition) {
+		// the source stream was trimmed by the retention policy while its files were opened
+		err = s.copyRecords(sourceUUID, target, lastMsgId)
+	}
+	if err != nil {
+		s.logger.Error(
+			"Can't copy records",
+			zap.String("topic", "stream"),
+			zap.String("method", "CopyRecords"),
+			zap.String("stream.uuid", target.UUID.String()),
+			zap.String("source.uuid", sourceUUID.String()),
+			zap.Error(err),
+		)
+	}
+	return err
+}
+
+func (s *FileStorage) copyRecords(sourceUUID types.StreamUUID, target *types.StreamInfo, lastMsgId types.MessageId) error {
+	sourceSegments, err := loadSegmentManifest(s.GetStreamDirectoryPath(sourceUUID), filepath.Base(s.GetStreamDataFilePath(sourceUUID)), filepath.Base(s.GetStreamIndexFilePath(sourceUUID)))
+	if err != nil {
+		return err
+	}
+	view, err := openSegmentIndexView(sourceSegments)
+	if err != nil {
+		return err
+	}
+	defer view.close()
+	srcData := newSegmentDataReader(sourceSegments)
+	defer func() {
+		_ = srcData.Close()
+	}()
+
+	// the rows before the rank found hold the records to copy
+	row := streamIndexRowMsg{}
+	var errSearch error
+	cptToCopy := int64(sort.Search(int(view.cptRows), func(rank int) bool {
+		if errSearch != nil {
+			return true
+		}
+		if errSearch = view.readRow(int64(rank), &row); errSearch != nil {
+			return true
+		}
+		return row.Id > lastMsgId
+	}))
+	if errSearch != nil {
+		return errSearch
+	}
+	if cptToCopy == 0 {
+		return nil
+	}
+
+	var firstRow, lastRow streamIndexRowMsg
+	if err = view.readRow(0, &firstRow); err != nil {
+		return err
+	}
+	if err = view.readRow(cptToCopy-1, &lastRow); err != nil {
+		return err
+	}
+	// the index and the data files must be the same generation (the retention policy replaces the head segment)
+	if _, err = readRecordAtIndexPosition(srcData, &lastRow); err != nil {
+		return err
+	}
+
+	// the segments are copied up to the last record, the segment holding it is the active segment of the target
+	targetSegments := &streamSegmentManifest{directory: s.GetStreamDirectoryPath(target.UUID)}
+	for position := 0; position < len(view.segments) && view.segments[position].firstRank < cptToCopy; position++ {
+		indexSegment := &view.segments[position]
+		cptRows := min(indexSegment.cptRows, cptToCopy-indexSegment.firstRank)
+		if err = view.readRow(indexSegment.firstRank+cptRows-1, &row); err != nil {
+			return err
+		}
+		dataSize := row.Offset + int64(row.LengthInBytes) - indexSegment.baseOffset
+
+		sourceSegment := sourceSegments.Segments[position]
+		segment := &streamSegment{
+			DataFile:    getUncompressedFilename(sourceSegment.DataFile, sourceSegment.Compression),
+			IndexFile:   sourceSegment.IndexFile,
+			Sealed:      true,
+			CptMessages: cptRows,
+			SizeInBytes: dataSize,
+		}
+		targetSegments.Segments = append(targetSegments.Segments, segment)
+
+		if sourceSegment.Compression != "" && cptRows == indexSegment.cptRows && indexSegment.firstRank+cptRows < cptToCopy {
+			// a compressed segment copied entirely stays compressed (the active segment of the target is not)
+			segment.DataFile = sourceSegment.DataFile
+			segment.Compression = sourceSegment.Compression
+			if err = copyFile(sourceSegments.getFilePath(sourceSegment.DataFile), targetSegments.getFilePath(segment.DataFile)); err != nil {
+				return err
+			}
+		} else {
+			fileData, err := srcData.getFile(position)
+			if err != nil {
+				return err
+			}
+			if err = copyFileSection(fileData, targetSegments.getFilePath(segment.DataFile), 0, dataSize); err != nil {
+				return err
+			}
+		}
+		fileIndex, err := view.getFile(position)
+		if err != nil {
+			return err
+		}
+		if err = copyFileSection(fileIndex, targetSegments.getFilePath(segment.IndexFile), 0, cptRows*sizeOfStreamIndexRowMsg); err != nil {
+			return err
+		}
+	}
+	// the last segment copied is not sealed, the records written into the target are appended to it
+	activeSegment := targetSegments.getActiveSegment()
+	*activeSegment = streamSegment{DataFile: activeSegment.DataFile, IndexFile: activeSegment.IndexFile}
+	if err = targetSegments.save(); err != nil {
+		return err
+	}
+
+	messages := types.StreamMessagesInfo{
+		CptMessages:       types.Size64(cptToCopy),
+		SizeInBytes:       types.Size64(lastRow.Offset + int64(lastRow.LengthInBytes)),
+		FirstMsgId:        firstRow.Id,
+		LastMsgId:         lastRow.Id,
+		FirstMsgTimestamp: time.Unix(0, firstRow.TimestampUnixNano),
+		LastMsgTimestamp:  time.Unix(0, lastRow.TimestampUnixNano),
+	}
+	target.IngestedMessages = messages
+	target.ReadableMessages = messages
+
+	w := NewStreamWriterFile(target, s.GetStreamDataFilePath(target.UUID), s.GetStreamIndexFilePath(target.UUID), s.GetMetaDataFilePath(target.UUID), s.logger, s.logVerbosity)
+	return w.SaveFileMetaInfo()
+}
+
+func copyFile(srcPath string, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	return copyFileSection(src, dstPath, 0, info.Size())
+}
@@ -0,0 +1,96 @@
+package jsonfileprovider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestCopyRecords(t *testing.T) {
+	logger := zap.NewNop()
+	s := &FileStorage{logger: logger, dataDirectory: t.TempDir()}
+
+	newWriter := func(info *types.StreamInfo) *StreamWriterFile {
+		if err := s.CreateStreamDirectory(info.UUID); err != nil {
+			t.Fatalf("could not create stream directory: %v", err)
+		}
+		w := NewStreamWriterFile(info, s.GetStreamDataFilePath(info.UUID), s.GetStreamIndexFilePath(info.UUID), s.GetMetaDataFilePath(info.UUID), logger, 0)
+		if err := w.Init(); err != nil {
+			t.Fatalf("could not init stream writer: %v", err)
+		}
+		if err := w.Open(); err != nil {
+			t.Fatalf("could not open stream writer: %v", err)
+		}
+		return w
+	}
+	expectRecords := func(info *types.StreamInfo, messageIds []types.MessageId, expected []interface{}) {
+		idx := NewStreamIndex(info.UUID, s.GetStreamIndexFilePath(info.UUID), logger)
+		found, err := readRecordsByIds(s.GetStreamDataFilePath(info.UUID), idx, messageIds, nil)
+		if err != nil {
+			t.Fatalf("could not read records: %v", err)
+		}
+		for i, record := range found {
+			var n interface{}
+			if record != nil {
+				n = record.(map[string]interface{})["m"].(map[string]interface{})["n"]
+			}
+			if n != expected[i] {
+				t.Fatalf("message %d: expected %v, got %v", messageIds[i], expected[i], n)
+			}
+		}
+	}
+
+	// message ids with gaps (records dropped)
+	source := types.NewStreamInfo(uuid.New())
+	w := newWriter(source)
+	firstDate := time.Now()
+	records := make([]types.DeferedStreamRecord, 0, 20)
+	for i := 1; i <= 20; i++ {
+		records = append(records, types.DeferedStreamRecord{Id: types.MessageId(2 * i), CreationDate: firstDate.Add(time.Duration(i) * time.Second), Msg: map[string]interface{}{"n": 2 * i}})
+	}
+	if err := w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+	_ = w.Close()
+
+	target := types.NewStreamInfo(uuid.New())
+	if err := s.CreateStreamDirectory(target.UUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+	if err := s.CopyRecords(source.UUID, target, 13); err != nil {
+		t.Fatalf("could not copy records: %v", err)
+	}
+	readable := target.ReadableMessages
+	if readable.CptMessages != 6 || readable.FirstMsgId != 2 || readable.LastMsgId != 12 || !readable.LastMsgTimestamp.Equal(records[5].CreationDate) {
+		t.Fatalf("unexpected messages info %+v", readable)
+	}
+	if target.IngestedMessages != readable {
+		t.Fatalf("expected the ingested messages to be the readable messages, got %+v", target.IngestedMessages)
+	}
+	expectRecords(target, []types.MessageId{2, 12, 14}, []interface{}{float64(2), float64(12), nil})
+
+	// the records written into the copy are appended after the records copied
+	w = newWriter(target)
+	defer func() {
+		_ = w.Close()
+	}()
+	records = []types.DeferedStreamRecord{{Id: 13, CreationDate: time.Now(), Msg: map[string]interface{}{"n": 13}}}
+	if err := w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+	expectRecords(target, []types.MessageId{12, 13, 14}, []interface{}{float64(12), float64(13), nil})
+	expectRecords(source, []types.MessageId{12, 13, 14}, []interface{}{float64(12), nil, float64(14)})
+
+	// nothing to copy
+	empty := types.NewStreamInfo(uuid.New())
+	if err := s.CreateStreamDirectory(empty.UUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+	if err := s.CopyRecords(source.UUID, empty, 1); err != nil || empty.ReadableMessages.CptMessages != 0 {
+		t.Fatalf("expected no record copied, got %+v (%v)", empty.ReadableMessages, err)
+	}
+}
@@ -0,0 +1,424 @@
+package jsonfileprovider
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbigot/ministream/encryption"
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+)
+
+// The payload of a record (message and headers) is encrypted with AES-GCM when the encryption is enabled,
+// the message id and the creation date stay readable (the index is built and the stream is checked without the keys).
+// Every record holds the id of the key which encrypted it, the keys are rotated without rewriting the records,
+// the re-encryption rewrites the records stored with a previous key (or not encrypted) segment by segment.
+//
+// Encrypted record: {"i":<msg id>,"d":<creation date>,"k":<key id>,"e":<base64 nonce and ciphertext of {"m":...,"h":...}>}
+
+var errNoEncryptionKey = errors.New("the record is encrypted but no encryption key is configured")
+
+type storedStreamRecord struct {
+	// a record as stored in the data file, either encrypted or not
+	Id           types.MessageId `json:"i"`
+	CreationDate time.Time       `json:"d"`
+	Msg          json.RawMessage `json:"m,omitempty"`
+	Headers      json.RawMessage `json:"h,omitempty"`
+	KeyId        string          `json:"k,omitempty"`
+	Payload      []byte          `json:"e,omitempty"`
+}
+
+type streamRecordPayload struct {
+	Msg     interface{}         `json:"m"`
+	Headers types.RecordHeaders `json:"h,omitempty"`
+}
+
+type storedStreamRecordPayload struct {
+	Msg     json.RawMessage `json:"m"`
+	Headers json.RawMessage `json:"h,omitempty"`
+}
+
+func WithEncryption(keyRing *encryption.KeyRing, encryptRecords bool) StreamWriterFileOption {
+	// the new records are encrypted with the active key of the key ring when encryptRecords is true,
+	// the records already encrypted are decrypted with the key ring when they are re-encrypted
+	return func(w *StreamWriterFile) {
+		w.keyRing = keyRing
+		w.encryptRecords = encryptRecords
+	}
+}
+
+func WithKeyRing(keyRing *encryption.KeyRing) StreamIteratorHandlerFileOption {
+	// the encrypted records are decrypted with the key ring
+	return func(h *StreamIteratorHandlerFile) {
+		h.keyRing = keyRing
+	}
+}
+
+func getRecordAdditionalData(msgId types.MessageId) []byte {
+	// the ciphertext is bound to the message id of the record
+	return binary.LittleEndian.AppendUint64(nil, msgId)
+}
+
+func marshalStreamRecord(record *types.DeferedStreamRecord, keyRing *encryption.KeyRing) ([]byte, error) {
+	// serialize the record, its payload is encrypted with the active key when a key ring is given
+	if keyRing == nil {
+		return json.Marshal(record)
+	}
+	payload, err := json.Marshal(streamRecordPayload{Msg: record.Msg, Headers: record.Headers})
+	if err != nil {
+		return nil, err
+	}
+	return encryptStreamRecordPayload(&storedStreamRecord{Id: record.Id, CreationDate: record.CreationDate}, payload, keyRing)
+}
+
+func encryptStreamRecordPayload(record *storedStreamRecord, payload []byte, keyRing *encryption.KeyRing) ([]byte, error) {
+	keyId, ciphertext, err := keyRing.Encrypt(payload, getRecordAdditionalData(record.Id))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(storedStreamRecord{Id: record.Id, CreationDate: record.CreationDate, KeyId: keyId, Payload: ciphertext})
+}
+
+func decryptStreamRecord(record map[string]interface{}, keyRing *encryption.KeyRing) error {
+	// replace the encrypted payload of the record by its message and headers (the record is unchanged if not encrypted)
+	encoded, ok := record["e"].(string)
+	if !ok {
+		return nil
+	}
+	if keyRing == nil {
+		return errNoEncryptionKey
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	keyId, _ := record["k"].(string)
+	msgId, _ := record["i"].(float64)
+	plaintext, err := keyRing.Decrypt(keyId, ciphertext, getRecordAdditionalData(types.MessageId(msgId)))
+	if err != nil {
+		return fmt.Errorf("can't decrypt record %d: %w", types.MessageId(msgId), err)
+	}
+	var payload map[string]interface{}
+	if err = json.Unmarshal(plaintext, &payload); err != nil {
+		return err
+	}
+	record["m"] = payload["m"]
+	if headers, found := payload["h"]; found {
+		record["h"] = headers
+	}
+	delete(record, "k")
+	delete(record, "e")
+	return nil
+}
+
+func (w *StreamWriterFile) reencryptLine(line []byte) ([]byte, bool, error) {
+	// returns the line of the record in the format of the new records (encrypted with the active key or not encrypted),
+	// false if the record is already stored in this format
+	record := storedStreamRecord{}
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, false, err
+	}
+	encrypted := record.Payload != nil
+	if encrypted == w.encryptRecords && (!encrypted || record.KeyId == w.keyRing.GetActiveKeyId()) {
+		return line, false, nil
+	}
+
+	var payload []byte
+	if encrypted {
+		if w.keyRing == nil {
+			return nil, false, errNoEncryptionKey
+		}
+		var err error
+		if payload, err = w.keyRing.Decrypt(record.KeyId, record.Payload, getRecordAdditionalData(record.Id)); err != nil {
+			return nil, false, fmt.Errorf("can't decrypt record %d: %w", record.Id, err)
+		}
+	} else {
+		var err error
+		if payload, err = json.Marshal(storedStreamRecordPayload{Msg: record.Msg, Headers: record.Headers}); err != nil {
+			return nil, false, err
+		}
+	}
+
+	var (
+		bytes []byte
+		err   error
+	)
+	if w.encryptRecords {
+		bytes, err = encryptStreamRecordPayload(&record, payload, w.keyRing)
+	} else {
+		decrypted := storedStreamRecordPayload{}
+		if err = json.Unmarshal(payload, &decrypted); err != nil {
+			return nil, false, err
+		}
+		bytes, err = json.Marshal(storedStreamRecord{Id: record.Id, CreationDate: record.CreationDate, Msg: decrypted.Msg, Headers: decrypted.Headers})
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return append(bytes, EOLChar), true, nil
+}
+
+func (w *StreamWriterFile) Reencrypt() (types.Size64, error) {
+	// Rewrite the records which are not stored in the format of the new records (encrypted with the active key,
+	// or not encrypted when the encryption is disabled), returns the number of records rewritten.
+	// The writer is locked a segment at a time, a segment rewritten replaces the segment in a new generation
+	// of the manifest (the offsets of the records change), the iterators move to the same message id.
+	var (
+		cptRewritten int64
+		nextMsgId    types.MessageId
+	)
+	for {
+		cpt, lastMsgId, done, err := w.reencryptSegment(nextMsgId)
+		cptRewritten += cpt
+		if err != nil {
+			w.logger.Error(
+				"can't re-encrypt segment",
+				zap.String("topic", "stream"),
+				zap.String("method", "Reencrypt"),
+				zap.String("stream.uuid", w.info.UUID.String()),
+				zap.Uint64("msgId", nextMsgId),
+				zap.Error(err),
+			)
+			return types.Size64(cptRewritten), err
+		}
+		if done {
+			return types.Size64(cptRewritten), nil
+		}
+		nextMsgId = lastMsgId + 1
+	}
+}
+
+func (w *StreamWriterFile) reencryptSegment(fromMsgId types.MessageId) (int64, types.MessageId, bool, error) {
+	// rewrite the segment holding the first record having an id greater or equal to the given message id,
+	// returns the number of records rewritten, the last message id of the segment and true if it is the last segment
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.state != STREAM_WRITER_FILE_STATE_OPENED {
+		return 0, 0, true, fmt.Errorf("cannot re-encrypt stream writer file because it's not opened")
+	}
+	if w.encryptRecords && w.keyRing == nil {
+		return 0, 0, true, errors.New("no encryption key")
+	}
+
+	view, err := openSegmentIndexView(w.segments)
+	if err != nil {
+		return 0, 0, true, err
+	}
+	defer view.close()
+
+	row := streamIndexRowMsg{}
+	rank := sort.Search(int(view.cptRows), func(rank int) bool {
+		if err != nil {
+			return true
+		}
+		err = view.readRow(int64(rank), &row)
+		return err != nil || row.Id >= fromMsgId
+	})
+	if err != nil {
+		return 0, 0, true, err
+	}
+	if int64(rank) == view.cptRows {
+		return 0, 0, true, nil
+	}
+	position := view.locate(int64(rank))
+	segment := w.segments.Segments[position]
+	indexSegment := &view.segments[position]
+	isLast := position == len(w.segments.Segments)-1
+
+	var firstRow, lastRow streamIndexRowMsg
+	if err = view.readRow(indexSegment.firstRank, &firstRow); err != nil {
+		return 0, 0, true, err
+	}
+	if err = view.readRow(indexSegment.firstRank+indexSegment.cptRows-1, &lastRow); err != nil {
+		return 0, 0, true, err
+	}
+	fileIndex, err := view.getFile(position)
+	if err != nil {
+		return 0, lastRow.Id, isLast, err
+	}
+	data, err := w.segments.openDataFile(segment)
+	if err != nil {
+		return 0, lastRow.Id, isLast, err
+	}
+	defer func() {
+		_ = data.Close()
+	}()
+
+	rewritten := &streamSegment{
+		DataFile:  getRewrittenSegmentFilename(filepath.Base(w.fileDataPath), firstRow.Id, w.segments.Generation+1),
+		IndexFile: getRewrittenSegmentFilename(filepath.Base(w.fileIndexPath), firstRow.Id, w.segments.Generation+1),
+		Sealed:    segment.Sealed,
+	}
+	dataPath := w.segments.getFilePath(rewritten.DataFile)
+	indexPath := w.segments.getFilePath(rewritten.IndexFile)
+	removeRewritten := func() {
+		_ = os.Remove(dataPath)
+		_ = os.Remove(indexPath)
+	}
+	cptRewritten, dataSize, err := w.rewriteSegmentRecords(data, fileIndex, indexSegment.cptRows, dataPath, indexPath)
+	if err != nil || cptRewritten == 0 {
+		removeRewritten()
+		return 0, lastRow.Id, isLast, err
+	}
+	if rewritten.Sealed {
+		rewritten.CptMessages = indexSegment.cptRows
+		rewritten.SizeInBytes = dataSize
+	}
+
+	var fileData, fileIndexActive *os.File
+	if !rewritten.Sealed {
+		// the records are appended to the new files of the active segment
+		if fileData, err = os.OpenFile(dataPath, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+			removeRewritten()
+			return 0, lastRow.Id, isLast, err
+		}
+		if fileIndexActive, err = os.OpenFile(indexPath, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+			_ = fileData.Close()
+			removeRewritten()
+			return 0, lastRow.Id, isLast, err
+		}
+	}
+
+	// the new manifest replaces the segment at once (the files of the previous segment are deleted afterward)
+	manifest := *w.segments
+	manifest.Generation++
+	manifest.Segments = append([]*streamSegment{}, w.segments.Segments...)
+	manifest.Segments[position] = rewritten
+	if err = manifest.save(); err != nil {
+		if fileData != nil {
+			_ = fileData.Close()
+			_ = fileIndexActive.Close()
+		}
+		removeRewritten()
+		return 0, lastRow.Id, isLast, err
+	}
+	w.segments = &manifest
+	previousSize := lastRow.Offset + int64(lastRow.LengthInBytes) - indexSegment.baseOffset
+	if fileData != nil {
+		_ = w.fileData.Close()
+		_ = w.fileIndex.Close()
+		w.fileData = fileData
+		w.fileIndex = fileIndexActive
+		w.dataOffset = dataSize
+	}
+	for _, filename := range []string{segment.DataFile, segment.IndexFile} {
+		if err := os.Remove(w.segments.getFilePath(filename)); err != nil {
+			w.logger.Error(
+				"can't delete segment file",
+				zap.String("topic", "stream"),
+				zap.String("method", "Reencrypt"),
+				zap.String("stream.uuid", w.info.UUID.String()),
+				zap.String("filename", filename),
+				zap.Error(err),
+			)
+		}
+	}
+	w.info.ReadableMessages.SizeInBytes = types.Size64(int64(w.info.ReadableMessages.SizeInBytes) + dataSize - previousSize)
+
+	w.logger.Info(
+		"Stream segment re-encrypted",
+		zap.String("topic", "stream"),
+		zap.String("method", "Reencrypt"),
+		zap.String("stream.uuid", w.info.UUID.String()),
+		zap.String("segment", rewritten.DataFile),
+		zap.Int64("records.rewritten", cptRewritten),
+		zap.Bool("encrypted", w.encryptRecords),
+	)
+	// a sealed segment rewritten is compressed again
+	w.startSegmentCompression()
+
+	return cptRewritten, lastRow.Id, isLast, w.SaveFileMetaInfo()
+}
+
+func (w *StreamWriterFile) rewriteSegmentRecords(data segmentDataFile, fileIndex *os.File, cptRows int64, dataPath string, indexPath string) (int64, int64, error) {
+	// write the records of the segment into new data and index files (synced to the disk),
+	// returns the number of records rewritten and the size of the new data file
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	dstData, err := os.OpenFile(dataPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = dstData.Close()
+	}()
+	dstIndex, err := os.OpenFile(indexPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = dstIndex.Close()
+	}()
+
+	reader := bufio.NewReaderSize(data, 1024*1024)
+	rows := bufio.NewReader(io.NewSectionReader(fileIndex, 0, cptRows*sizeOfStreamIndexRowMsg))
+	dataWriter := bufio.NewWriter(dstData)
+	indexWriter := bufio.NewWriter(dstIndex)
+	var cptRewritten, offset int64
+	row := streamIndexRowMsg{}
+	for rank := int64(0); rank < cptRows; rank++ {
+		if err = binary.Read(rows, binary.LittleEndian, &row); err != nil {
+			return 0, 0, err
+		}
+		line, err := reader.ReadBytes(EOLChar)
+		if err != nil {
+			return 0, 0, err
+		}
+		// a corrupted record is not rewritten (its new checksum would hide the corruption)
+		if err = row.verifyChecksum(line); err != nil {
+			return 0, 0, err
+		}
+		newLine, changed, err := w.reencryptLine(line)
+		if err != nil {
+			return 0, 0, err
+		}
+		if changed {
+			cptRewritten++
+		}
+		if _, err = dataWriter.Write(newLine); err != nil {
+			return 0, 0, err
+		}
+		row.LengthInBytes = uint32(len(newLine))
+		row.Checksum = getRecordChecksum(newLine)
+		row.Offset = offset
+		if err = binary.Write(indexWriter, binary.LittleEndian, row); err != nil {
+			return 0, 0, err
+		}
+		offset += int64(len(newLine))
+	}
+	if cptRewritten == 0 {
+		return 0, offset, nil
+	}
+
+	for _, writer := range []*bufio.Writer{dataWriter, indexWriter} {
+		if err = writer.Flush(); err != nil {
+			return 0, 0, err
+		}
+	}
+	for _, file := range []*os.File{dstData, dstIndex} {
+		if err = file.Sync(); err != nil {
+			return 0, 0, err
+		}
+	}
+	return cptRewritten, offset, nil
+}
+
+func getRewrittenSegmentFilename(filename string, firstMsgId types.MessageId, generation int64) string {
+	// the files of a segment rewritten are named after its first message id and the generation of the manifest
+	// (ex: data.00000000000000001234.g5.jsonl)
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s.%020d.g%d%s", strings.TrimSuffix(filename, ext), firstMsgId, generation, ext)
+}
@@ -0,0 +1,173 @@
+package jsonfileprovider
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/encryption"
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestEncryptedRecords(t *testing.T) {
+	logger := zap.NewNop()
+	s := &FileStorage{logger: logger, dataDirectory: t.TempDir()}
+	info := types.NewStreamInfo(uuid.New())
+	if err := s.CreateStreamDirectory(info.UUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+	dataPath := s.GetStreamDataFilePath(info.UUID)
+	indexPath := s.GetStreamIndexFilePath(info.UUID)
+	directory := s.GetStreamDirectoryPath(info.UUID)
+
+	keyRing := encryption.NewKeyRing()
+	if err := keyRing.AddKey("k1", bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatalf("could not add key: %v", err)
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	newRecord := func(msgId types.MessageId) types.DeferedStreamRecord {
+		return types.DeferedStreamRecord{Id: msgId, CreationDate: start.Add(time.Duration(msgId) * time.Second), Msg: map[string]interface{}{"secret": "s3cr3t", "n": msgId}, Headers: types.RecordHeaders{"tenant": "acme"}}
+	}
+	var w *StreamWriterFile
+	openWriter := func(encryptRecords bool) {
+		if w != nil {
+			_ = w.Close()
+		}
+		// a segment holds 4 records at most
+		w = NewStreamWriterFile(info, dataPath, indexPath, s.GetMetaDataFilePath(info.UUID), logger, 0, WithSegmentRolling(700, 0), WithEncryption(keyRing, encryptRecords))
+		if err := w.Init(); err != nil {
+			t.Fatalf("could not init stream writer: %v", err)
+		}
+		if err := w.Open(); err != nil {
+			t.Fatalf("could not open stream writer: %v", err)
+		}
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+	write := func(firstMsgId types.MessageId, lastMsgId types.MessageId) {
+		records := make([]types.DeferedStreamRecord, 0)
+		for i := firstMsgId; i <= lastMsgId; i++ {
+			records = append(records, newRecord(i))
+		}
+		if err := w.Write(&records); err != nil {
+			t.Fatalf("could not write records: %v", err)
+		}
+		info.IngestedMessages = info.ReadableMessages
+	}
+	countStored := func(pattern string) int {
+		matches, _ := filepath.Glob(filepath.Join(directory, "data*"))
+		cpt := 0
+		for _, match := range matches {
+			data, err := os.ReadFile(match)
+			if err != nil {
+				t.Fatalf("could not read data file: %v", err)
+			}
+			cpt += strings.Count(string(data), pattern)
+		}
+		return cpt
+	}
+	expectRecords := func(keyRing *encryption.KeyRing, req *types.StreamIteratorRequest, expectedIds []types.MessageId) {
+		it := NewStreamIteratorHandlerFile(info.UUID, uuid.New(), dataPath, NewStreamIndex(info.UUID, indexPath, logger), logger, WithKeyRing(keyRing))
+		defer func() {
+			_ = it.Close()
+		}()
+		if err := it.Open(); err != nil {
+			t.Fatalf("could not open iterator: %v", err)
+		}
+		if err := it.Seek(req); err != nil {
+			t.Fatalf("could not seek iterator: %v", err)
+		}
+		for _, expectedId := range expectedIds {
+			msgId, record, found, _, err := it.GetNextRecord()
+			if err != nil || !found || msgId != expectedId {
+				t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+			}
+			fields := record.(map[string]interface{})
+			msg := fields["m"].(map[string]interface{})
+			headers := fields["h"].(map[string]interface{})
+			if msg["secret"] != "s3cr3t" || msg["n"] != float64(expectedId) || headers["tenant"] != "acme" || fields["e"] != nil || fields["k"] != nil {
+				t.Fatalf("unexpected record %v for message %d", record, expectedId)
+			}
+		}
+		if msgId, _, found, _, err := it.GetNextRecord(); err != nil || found {
+			t.Fatalf("expected no more record, got %d (%v)", msgId, err)
+		}
+	}
+	allIds := []types.MessageId{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	forward := &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}
+	backward := &types.StreamIteratorRequest{IteratorType: "LAST_MESSAGE", Direction: types.IteratorDirectionBackward}
+
+	// the records written before the encryption was enabled are read along with the encrypted records
+	openWriter(false)
+	write(1, 3)
+	openWriter(true)
+	write(4, 10)
+	if cpt := countStored("s3cr3t"); cpt != 3 {
+		t.Fatalf("expected 3 records not encrypted, got %d", cpt)
+	}
+	if len(w.segments.Segments) < 3 {
+		t.Fatalf("expected several segments, got %d", len(w.segments.Segments))
+	}
+	expectRecords(keyRing, forward, allIds)
+	expectRecords(keyRing, backward, []types.MessageId{10, 9, 8, 7, 6, 5, 4, 3, 2, 1})
+	found, err := readRecordsByIds(dataPath, NewStreamIndex(info.UUID, indexPath, logger), []types.MessageId{2, 7}, keyRing)
+	if err != nil || found[1].(map[string]interface{})["m"].(map[string]interface{})["secret"] != "s3cr3t" {
+		t.Fatalf("unexpected records %v (%v)", found, err)
+	}
+	if _, err = readRecordsByIds(dataPath, NewStreamIndex(info.UUID, indexPath, logger), []types.MessageId{7}, nil); !errors.Is(err, errNoEncryptionKey) {
+		t.Fatalf("expected no encryption key, got %v", err)
+	}
+
+	// the records are encrypted with the new key (the records not encrypted too)
+	if err = keyRing.AddKey("k2", bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatalf("could not add key: %v", err)
+	}
+	cptRewritten, err := w.Reencrypt()
+	if err != nil || cptRewritten != 10 {
+		t.Fatalf("expected 10 records rewritten, got %d (%v)", cptRewritten, err)
+	}
+	if countStored("s3cr3t") != 0 || countStored(`"k":"k1"`) != 0 || countStored(`"k":"k2"`) != 10 {
+		t.Fatalf("expected all the records encrypted with the new key")
+	}
+	rotated := encryption.NewKeyRing()
+	if err = rotated.AddKey("k2", bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatalf("could not add key: %v", err)
+	}
+	expectRecords(rotated, forward, allIds)
+	if cptRewritten, err = w.Reencrypt(); err != nil || cptRewritten != 0 {
+		t.Fatalf("expected no record rewritten, got %d (%v)", cptRewritten, err)
+	}
+
+	// the records are appended to the active segment rewritten, the stream is consistent
+	write(11, 12)
+	report, err := s.CheckStream(info, true, false)
+	if err != nil || len(report.Issues) != 0 {
+		t.Fatalf("unexpected issues %+v (%v)", report, err)
+	}
+	scrubReport, err := w.Scrub()
+	if err != nil || scrubReport.CptRecords != 12 || scrubReport.CptCorrupted != 0 {
+		t.Fatalf("unexpected scrub report %+v (%v)", scrubReport, err)
+	}
+
+	// the records are decrypted when the encryption is disabled
+	openWriter(false)
+	if cptRewritten, err = w.Reencrypt(); err != nil || cptRewritten != 12 {
+		t.Fatalf("expected 12 records rewritten, got %d (%v)", cptRewritten, err)
+	}
+	if countStored("s3cr3t") != 12 {
+		t.Fatalf("expected all the records decrypted")
+	}
+	expectRecords(nil, forward, append(allIds, 11, 12))
+	if report, err = s.CheckStream(info, true, false); err != nil || len(report.Issues) != 0 {
+		t.Fatalf("unexpected issues %+v (%v)", report, err)
+	}
+}
@@ -5,8 +5,9 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
-	"io/fs"
 	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -24,7 +25,7 @@ type StreamIndexFile struct {
 	logger       *zap.Logger
 	logVerbosity int
 	filename     string
-	file         *os.File
+	view         *segmentIndexView // rows of all the segments of the stream
 	mu           sync.Mutex
 }
 
@@ -39,23 +40,44 @@ type StreamIndexStats struct {
 
 type streamIndexRowMsg struct {
 	Id                types.MessageId
-	LengthInBytes     int64
+	LengthInBytes     uint32
+	Checksum          uint32 // CRC32C of the record (0 if the row was written before the checksums)
 	Offset            int64
 	TimestampUnixNano int64
 }
 
-const sizeOfStreamIndexRowMsg int64 = 4 * 8 // 4 fields x 8 bytes per field
+const sizeOfStreamIndexRowMsg int64 = 4 * 8 // 4 fields x 8 bytes per field (the length and the checksum share 8 bytes)
 
 func (idx *StreamIndexFile) Close() error {
-	if idx.file != nil {
-		return idx.file.Close()
+	idx.closeView()
+	return nil
+}
+
+func (idx *StreamIndexFile) openView() error {
+	// the segments are loaded at every lookup (the segments change while the stream is written)
+	var err error
+	idx.view, err = idx.newView()
+	return err
+}
+
+func (idx *StreamIndexFile) newView() (*segmentIndexView, error) {
+	// view of the index rows of all the segments, the data files are not read
+	m, err := loadSegmentManifest(filepath.Dir(idx.filename), "", filepath.Base(idx.filename))
+	if err != nil {
+		return nil, err
 	}
+	return openSegmentIndexView(m)
+}
 
-	return nil
+func (idx *StreamIndexFile) closeView() {
+	if idx.view != nil {
+		idx.view.close()
+		idx.view = nil
+	}
 }
 
 func (idx *StreamIndexFile) BuildIndex(dataFilePath string) (*StreamIndexStats, error) {
-	// Build or rebuild index
+	// Build or rebuild the index files of all the segments
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
@@ -68,35 +90,61 @@ func (idx *StreamIndexFile) BuildIndex(dataFilePath string) (*StreamIndexStats,
 	)
 	stats := StreamIndexStats{CptMessages: 0, FileSize: 0}
 
-	var err error
-	if idx.file != nil {
-		_ = idx.file.Close()
+	idx.closeView()
+	m, err := loadSegmentManifest(filepath.Dir(idx.filename), filepath.Base(dataFilePath), filepath.Base(idx.filename))
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range m.Segments {
+		if err = idx.buildSegmentIndex(m, segment, &stats); err != nil {
+			return nil, err
+		}
 	}
 
-	var streamDataFile *os.File
-	streamDataFile, err = os.OpenFile(dataFilePath, os.O_RDONLY, 0644)
+	idx.logger.Info(
+		"Build index ended",
+		zap.String("topic", "index"),
+		zap.String("method", "BuildIndex"),
+		zap.String("stream.uuid", idx.streamUUID.String()),
+		zap.String("index.filename", idx.filename),
+		zap.Int("index.segments", len(m.Segments)),
+		zap.Int64("index.byteSize", stats.FileSize),
+		zap.Int64("index.rowsCount", stats.CptMessages),
+		zap.Uint64("index.firstMsgId", stats.FirstMsgId),
+		zap.Uint64("index.lastMsgId", stats.LastMsgId),
+		zap.Time("index.firstMsgTimestamp", stats.FirstMsgTimestamp),
+		zap.Time("index.lastMsgTimestamp", stats.LastMsgTimestamp),
+	)
+
+	return &stats, nil
+}
+
+func (idx *StreamIndexFile) buildSegmentIndex(m *streamSegmentManifest, segment *streamSegment, stats *StreamIndexStats) error {
+	// rebuild the index file of a segment from its data file (the offsets are relative to the data file)
+	streamDataFile, err := m.openDataFile(segment)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	indexFilePath := m.getFilePath(segment.IndexFile)
 	defer func() {
 		_ = streamDataFile.Close()
 	}()
 
-	idx.file, err = os.OpenFile(idx.filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	indexFile, err := os.OpenFile(indexFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		idx.logger.Error(
 			"can't open index file",
 			zap.String("topic", "index"),
 			zap.String("method", "BuildIndex"),
 			zap.String("stream.uuid", idx.streamUUID.String()),
-			zap.Any("filename", idx.filename),
+			zap.Any("filename", indexFilePath),
 			zap.Error(err),
 		)
-		return nil, err
+		return err
 	}
 	defer func() {
-		_ = idx.file.Sync()
-		_ = idx.file.Close()
+		_ = indexFile.Sync()
+		_ = indexFile.Close()
 	}()
 
 	var msgOffset MsgOffset = 0
@@ -118,11 +166,11 @@ func (idx *StreamIndexFile) BuildIndex(dataFilePath string) (*StreamIndexStats,
 					zap.String("method", "BuildIndex"),
 					zap.String("detail", "can't read stream file"),
 					zap.String("stream.uuid", idx.streamUUID.String()),
-					zap.String("index.filename", idx.filename),
+					zap.String("index.filename", indexFilePath),
 					zap.Int64("offset", msgOffset),
 					zap.Error(err),
 				)
-				return nil, err
+				return err
 			}
 		}
 
@@ -134,72 +182,59 @@ func (idx *StreamIndexFile) BuildIndex(dataFilePath string) (*StreamIndexStats,
 				zap.String("method", "BuildIndex"),
 				zap.String("detail", "can't decode json message"),
 				zap.String("stream.uuid", idx.streamUUID.String()),
-				zap.String("index.filename", idx.filename),
+				zap.String("index.filename", indexFilePath),
 				zap.Int64("offset", msgOffset),
 				zap.Error(err),
 			)
-			return nil, err
+			return err
 		}
 
 		row.Id = message.Id
-		row.LengthInBytes = int64(len(line))
+		row.LengthInBytes = uint32(len(line))
+		row.Checksum = getRecordChecksum([]byte(line))
 		row.Offset = msgOffset
 		row.TimestampUnixNano = message.CreationDate.UnixNano()
 
-		err = binary.Write(idx.file, binary.LittleEndian, row)
+		err = binary.Write(indexFile, binary.LittleEndian, row)
 		if err != nil {
 			idx.logger.Error(
 				"Error while writing index into file",
 				zap.String("topic", "index"),
 				zap.String("method", "BuildIndex"),
 				zap.String("stream.uuid", idx.streamUUID.String()),
-				zap.String("index.filename", idx.filename),
+				zap.String("index.filename", indexFilePath),
 				zap.Int64("offset", msgOffset),
 				zap.Error(err),
 			)
-			return nil, err
+			return err
 		}
 
 		if idx.logVerbosity > 0 {
 			idx.logger.Debug(
 				"idx msg",
-				zap.Int64("msglen", row.LengthInBytes),
+				zap.Int64("msglen", int64(row.LengthInBytes)),
 				zap.Int64("offset", msgOffset),
 				zap.Uint64("msgindex", message.Id),
 				zap.Time("timestamp", message.CreationDate),
 			)
 		}
 
-		if msgOffset == 0 {
+		if stats.CptMessages == 0 {
 			stats.FirstMsgId = message.Id
 			stats.FirstMsgTimestamp = message.CreationDate
 		}
 
-		msgOffset += row.LengthInBytes
+		msgOffset += int64(row.LengthInBytes)
 		stats.CptMessages += 1
 	}
 
 	if message != nil {
 		stats.LastMsgId = message.Id
 		stats.LastMsgTimestamp = message.CreationDate
-		stats.FileSize = msgOffset
+		stats.FileSize += msgOffset
 	}
 
-	idx.logger.Info(
-		"Build index ended",
-		zap.String("topic", "index"),
-		zap.String("method", "BuildIndex"),
-		zap.String("stream.uuid", idx.streamUUID.String()),
-		zap.String("index.filename", idx.filename),
-		zap.Int64("index.byteSize", stats.FileSize),
-		zap.Int64("index.rowsCount", stats.CptMessages),
-		zap.Uint64("index.firstMsgId", stats.FirstMsgId),
-		zap.Uint64("index.lastMsgId", stats.LastMsgId),
-		zap.Time("index.firstMsgTimestamp", stats.FirstMsgTimestamp),
-		zap.Time("index.lastMsgTimestamp", stats.LastMsgTimestamp),
-	)
-
-	return &stats, nil
+	return nil
 }
 
 func (idx *StreamIndexFile) GetOffsetFirstMessage() (types.MessageId, MsgOffset, error) {
@@ -207,21 +242,29 @@ func (idx *StreamIndexFile) GetOffsetFirstMessage() (types.MessageId, MsgOffset,
 }
 
 func (idx *StreamIndexFile) GetOffsetLastMessage() (types.MessageId, MsgOffset, error) {
-	row, err := idx.getOffsetMessage(-sizeOfStreamIndexRowMsg, io.SeekEnd)
+	row, err := idx.getLastRow()
 	if err != nil {
 		return 0, 0, err
 	}
+	if row == nil {
+		// index is empty, therefore start at the beginning of the data file
+		return 0, 0, nil
+	}
 
 	return row.Id, row.Offset, nil
 }
 
 func (idx *StreamIndexFile) GetOffsetAfterLastMessage() (types.MessageId, MsgOffset, error) {
-	row, err := idx.getOffsetMessage(-sizeOfStreamIndexRowMsg, io.SeekEnd)
+	row, err := idx.getLastRow()
 	if err != nil {
 		return 0, 0, err
 	}
+	if row == nil {
+		// index is empty, therefore start at the beginning of the data file
+		return 0, 0, nil
+	}
 
-	return row.Id + 1, row.Offset + row.LengthInBytes, nil
+	return row.Id + 1, row.Offset + int64(row.LengthInBytes), nil
 }
 
 func (idx *StreamIndexFile) GetOffsetAtMessageId(messageId types.MessageId) (types.MessageId, MsgOffset, error) {
@@ -229,6 +272,9 @@ func (idx *StreamIndexFile) GetOffsetAtMessageId(messageId types.MessageId) (typ
 	if err != nil {
 		return 0, 0, err
 	}
+	if row == nil {
+		return 0, 0, errors.New("message id not found")
+	}
 
 	return row.Id, row.Offset, nil
 }
@@ -238,8 +284,32 @@ func (idx *StreamIndexFile) GetOffsetAfterMessageId(messageId types.MessageId) (
 	if err != nil {
 		return 0, 0, err
 	}
+	if row == nil {
+		return 0, 0, errors.New("message id not found")
+	}
+
+	return row.Id + 1, row.Offset + int64(row.LengthInBytes), nil
+}
+
+func (idx *StreamIndexFile) GetOffsetAtOrAfterMessageId(messageId types.MessageId) (types.MessageId, MsgOffset, error) {
+	// find the first message having an id greater or equal to the given message id,
+	// if there is no such message then return the position after the last message
+	rank, row, err := idx.GetRankAtOrAfterMessageId(messageId)
+	if err != nil {
+		return 0, 0, err
+	}
+	if row != nil {
+		return row.Id, row.Offset, nil
+	}
+	if rank == 0 {
+		return messageId, 0, nil
+	}
 
-	return row.Id + 1, row.Offset + row.LengthInBytes, nil
+	rows, err := idx.GetRows(rank-1, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return rows[0].Id + 1, rows[0].Offset + int64(rows[0].LengthInBytes), nil
 }
 
 func (idx *StreamIndexFile) GetOffsetAtTimestamp(timestamp *time.Time) (types.MessageId, MsgOffset, error) {
@@ -247,52 +317,136 @@ func (idx *StreamIndexFile) GetOffsetAtTimestamp(timestamp *time.Time) (types.Me
 	if err != nil {
 		return 0, 0, err
 	}
+	if row == nil {
+		return 0, 0, errors.New("message id not found")
+	}
 
 	return row.Id, row.Offset, nil
 }
 
-func (idx *StreamIndexFile) getOffsetMessage(seekOffset int64, seekWhence int) (*streamIndexRowMsg, error) {
+func (idx *StreamIndexFile) GetRowsCount() (int64, error) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
-	var err error
-	if idx.file, err = os.Open(idx.filename); err != nil {
+	if err := idx.openView(); err != nil {
+		return 0, err
+	}
+	defer idx.closeView()
+
+	return idx.getIndexRowsCount()
+}
+
+func (idx *StreamIndexFile) GetRows(rank int64, count int64) ([]streamIndexRowMsg, error) {
+	// read count rows of the index starting at the given rank
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if err := idx.openView(); err != nil {
+		return nil, err
+	}
+	defer idx.closeView()
+
+	rows, err := idx.view.readRows(rank, count)
+	if err != nil {
 		idx.logger.Error(
-			"Error while GetOffsetLastMessage open index file",
+			"Error while GetRows read bytes",
 			zap.String("topic", "index"),
-			zap.String("method", "getOffsetMessage"),
+			zap.String("method", "GetRows"),
 			zap.String("stream.uuid", idx.streamUUID.String()),
 			zap.String("index.filename", idx.filename),
+			zap.Int64("rank", rank),
+			zap.Int64("count", count),
 			zap.Error(err),
 		)
 		return nil, err
 	}
-	defer func() {
-		_ = idx.file.Close()
-	}()
+	return rows, nil
+}
+
+func (idx *StreamIndexFile) GetRankAtOrAfterMessageId(messageId types.MessageId) (int64, *streamIndexRowMsg, error) {
+	// find the rank of the first message having an id greater or equal to the given message id
+	// (the row is nil and the rank is the count of rows if there is no such message)
+	return idx.searchRank(func(row *streamIndexRowMsg) bool { return row.Id >= messageId })
+}
+
+func (idx *StreamIndexFile) GetRankAfterTimestamp(timestamp *time.Time) (int64, *streamIndexRowMsg, error) {
+	// find the rank of the first message created after the given timestamp
+	// (the row is nil and the rank is the count of rows if there is no such message)
+	timestampUnixNano := timestamp.UnixNano()
+	return idx.searchRank(func(row *streamIndexRowMsg) bool { return row.TimestampUnixNano > timestampUnixNano })
+}
+
+func (idx *StreamIndexFile) searchRank(match func(row *streamIndexRowMsg) bool) (int64, *streamIndexRowMsg, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if err := idx.openView(); err != nil {
+		return 0, nil, err
+	}
+	defer idx.closeView()
+
+	return idx.searchViewRank(match)
+}
+
+func (idx *StreamIndexFile) searchViewRank(match func(row *streamIndexRowMsg) bool) (int64, *streamIndexRowMsg, error) {
+	// use a dichotomy algorithm to find the rank of the first row matching (the rows after it match too)
+	var (
+		err            error
+		indexRowsCount int64
+	)
+	if indexRowsCount, err = idx.getIndexRowsCount(); err != nil {
+		return 0, nil, err
+	}
+
+	var row streamIndexRowMsg
+	rank := sort.Search(int(indexRowsCount), func(rank int) bool {
+		if err != nil {
+			return true
+		}
+		err = idx.getRowAtIndexPos(int64(rank), &row)
+		return err != nil || match(&row)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if int64(rank) == indexRowsCount {
+		return indexRowsCount, nil, nil
+	}
+
+	if err = idx.getRowAtIndexPos(int64(rank), &row); err != nil {
+		return 0, nil, err
+	}
+	return int64(rank), &row, nil
+}
+
+func (idx *StreamIndexFile) getLastRow() (*streamIndexRowMsg, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
 
-	if _, err = idx.file.Seek(seekOffset, seekWhence); err != nil {
+	if err := idx.openView(); err != nil {
 		idx.logger.Error(
-			"Error while GetOffsetLastMessage seek",
+			"Error while getLastRow open index file",
 			zap.String("topic", "index"),
-			zap.String("method", "getOffsetMessage"),
+			zap.String("method", "getLastRow"),
 			zap.String("stream.uuid", idx.streamUUID.String()),
 			zap.String("index.filename", idx.filename),
 			zap.Error(err),
 		)
 		return nil, err
 	}
+	defer idx.closeView()
+
+	indexRowsCount, err := idx.getIndexRowsCount()
+	if err != nil {
+		return nil, err
+	}
+	if indexRowsCount == 0 {
+		// index is empty, there is no message yet
+		return nil, nil
+	}
 
 	row := streamIndexRowMsg{}
-	if err = binary.Read(idx.file, binary.LittleEndian, &row); err != nil {
-		idx.logger.Error(
-			"Error while GetOffsetLastMessage read bytes",
-			zap.String("topic", "index"),
-			zap.String("method", "getOffsetMessage"),
-			zap.String("stream.uuid", idx.streamUUID.String()),
-			zap.String("index.filename", idx.filename),
-			zap.Error(err),
-		)
+	if err = idx.getRowAtIndexPos(indexRowsCount-1, &row); err != nil {
 		return nil, err
 	}
 
@@ -304,7 +458,7 @@ func (idx *StreamIndexFile) getOffsetAt(messageId *types.MessageId, timestamp *t
 	defer idx.mu.Unlock()
 
 	var err error
-	if idx.file, err = os.Open(idx.filename); err != nil {
+	if err = idx.openView(); err != nil {
 		idx.logger.Error(
 			"Error while getOffsetAt open index file",
 			zap.String("topic", "index"),
@@ -315,9 +469,7 @@ func (idx *StreamIndexFile) getOffsetAt(messageId *types.MessageId, timestamp *t
 		)
 		return nil, err
 	}
-	defer func() {
-		_ = idx.file.Close()
-	}()
+	defer idx.closeView()
 
 	var indexRowsCount int64
 	if indexRowsCount, err = idx.getIndexRowsCount(); err != nil {
@@ -418,26 +570,14 @@ func (idx *StreamIndexFile) searchTimestamp(timestampUnixNano int64, lastIndexRa
 }
 
 func (idx *StreamIndexFile) getRowAtIndexPos(indexPos int64, row *streamIndexRowMsg) error {
-	var err error
-	if _, err = idx.file.Seek(indexPos*sizeOfStreamIndexRowMsg, io.SeekStart); err != nil {
-		idx.logger.Error(
-			"Error while getRowAtIndexPos seek",
-			zap.String("topic", "index"),
-			zap.String("method", "getRowAtIndexPos"),
-			zap.String("stream.uuid", idx.streamUUID.String()),
-			zap.String("index.filename", idx.filename),
-			zap.Error(err),
-		)
-		return err
-	}
-
-	if err = binary.Read(idx.file, binary.LittleEndian, row); err != nil {
+	if err := idx.view.readRow(indexPos, row); err != nil {
 		idx.logger.Error(
 			"Error while getRowAtIndexPos read bytes",
 			zap.String("topic", "index"),
 			zap.String("method", "getRowAtIndexPos"),
 			zap.String("stream.uuid", idx.streamUUID.String()),
 			zap.String("index.filename", idx.filename),
+			zap.Int64("rank", indexPos),
 			zap.Error(err),
 		)
 		return err
@@ -447,14 +587,8 @@ func (idx *StreamIndexFile) getRowAtIndexPos(indexPos int64, row *streamIndexRow
 }
 
 func (idx *StreamIndexFile) getIndexRowsCount() (int64, error) {
-	// Compute index rows count
-	var err error
-	var info fs.FileInfo
-	if info, err = os.Stat(idx.filename); err != nil {
-		return 0, err
-	}
-
-	return info.Size() / sizeOfStreamIndexRowMsg, nil
+	// Compute index rows count (all the segments)
+	return idx.view.cptRows, nil
 }
 
 func (idx *StreamIndexFile) Log() {
@@ -473,6 +607,6 @@ func NewStreamIndex(streamUUID uuid.UUID, filename string, logger *zap.Logger) *
 		logger:       logger,
 		logVerbosity: 0,
 		filename:     filename,
-		file:         nil,
+		view:         nil,
 	}
 }
@@ -4,8 +4,8 @@ import (
 	"bufio"
 	"errors"
 	"io"
-	"os"
 
+	"github.com/nbigot/ministream/encryption"
 	"github.com/nbigot/ministream/types"
 
 	"github.com/goccy/go-json"
@@ -14,12 +14,21 @@ import (
 
 const EOLChar = '\n'
 
+// Count of records read at once by an iterator reading backward
+const BackwardReadBatchSize = 100
+
+// Count of index rows read at once to verify the checksums of the records read forward
+const ForwardChecksumBatchSize = 100
+
+// A corrupted record skipped by the iterator (checksum mismatch), the next record is read instead
+var errRecordSkipped = errors.New("corrupted record skipped")
+
 type StreamIteratorHandlerFile struct {
 	// implements IStreamIteratorHandler interface
 	streamUUID       types.StreamUUID
 	itUUID           types.StreamIteratorUUID
 	initialized      bool
-	file             *os.File
+	data             *segmentDataReader // data files of the segments
 	filename         string
 	FileOffset       int64
 	bytesRead        int64
@@ -27,6 +36,29 @@ type StreamIteratorHandlerFile struct {
 	index            *StreamIndexFile
 	reader           *bufio.Reader
 	logger           *zap.Logger
+	// reading backward, the records are located with the index rows
+	backward           bool
+	backwardRank       int64               // rank of the index row following the rows still to read
+	backwardMsgId      types.MessageId     // the records still to read have a lower message id
+	backwardRows       []streamIndexRowMsg // rows of the records read at once (the last row is the next record)
+	backwardData       []byte              // records read at once
+	backwardDataOffset int64               // offset of the records read at once in the data file
+	// the records are verified with the checksums of their index rows
+	checksumMismatch string
+	forwardRows      []streamIndexRowMsg // rows of the next records read forward
+	forwardView      *segmentIndexView   // index rows of the segments, kept while the segments are the same generation
+	forwardRank      int64               // rank of the index row following the forward rows
+	// the encrypted records are decrypted with the key ring
+	keyRing *encryption.KeyRing
+}
+
+type StreamIteratorHandlerFileOption func(*StreamIteratorHandlerFile)
+
+func WithChecksumMismatch(checksumMismatch string) StreamIteratorHandlerFileOption {
+	// behavior of the iterator reading a record that doesn't match its checksum (none, skip, count or fail)
+	return func(h *StreamIteratorHandlerFile) {
+		h.checksumMismatch = checksumMismatch
+	}
 }
 
 func (h *StreamIteratorHandlerFile) Open() error {
@@ -35,12 +67,13 @@ func (h *StreamIteratorHandlerFile) Open() error {
 		return errors.New("empty stream filename")
 	}
 
-	if h.file != nil {
-		_ = h.file.Close()
+	if h.data != nil {
+		_ = h.data.Close()
 	}
+	h.closeForwardView()
 
 	var err error
-	h.file, err = os.Open(h.filename)
+	h.data, err = openSegmentDataReader(h.filename, h.index.filename)
 	if err != nil {
 		return err
 	}
@@ -49,10 +82,11 @@ func (h *StreamIteratorHandlerFile) Open() error {
 }
 
 func (h *StreamIteratorHandlerFile) Close() error {
-	if h.file != nil {
-		_ = h.file.Close()
-		h.file = nil
+	if h.data != nil {
+		_ = h.data.Close()
+		h.data = nil
 	}
+	h.closeForwardView()
 
 	if h.index != nil {
 		_ = h.index.Close()
@@ -68,7 +102,29 @@ func (h *StreamIteratorHandlerFile) Seek(request *types.StreamIteratorRequest) e
 		nextRecordIdToRead types.MessageId
 	)
 	if h.initialized {
-		_, err = h.file.Seek(h.FileOffset, io.SeekStart)
+		var replaced bool
+		if replaced, err = h.isDataFileReplaced(); err != nil {
+			return err
+		}
+		if replaced {
+			// the stream was trimmed by the retention policy
+			if h.backward {
+				return h.reopenBackward()
+			}
+			return h.reopen()
+		}
+		if h.backward {
+			return nil
+		}
+		_, err = h.data.Seek(h.FileOffset, io.SeekStart)
+		return err
+	}
+
+	if request.IsBackward() {
+		if err = h.seekBackward(request); err == nil {
+			h.initialized = true
+			h.backward = true
+		}
 		return err
 	}
 
@@ -92,25 +148,358 @@ func (h *StreamIteratorHandlerFile) Seek(request *types.StreamIteratorRequest) e
 	}
 
 	if err == nil {
-		if _, err = h.file.Seek(h.FileOffset, io.SeekStart); err != nil {
+		if _, err = h.data.Seek(h.FileOffset, io.SeekStart); err != nil {
 			return err
 		}
 		h.initialized = true
-		h.reader = bufio.NewReaderSize(h.file, 1024*1024)
-		h.reader.Reset(h.file)
+		h.reader = bufio.NewReaderSize(h.data, 1024*1024)
+		h.reader.Reset(h.data)
 		h.nextRecordIdRead = nextRecordIdToRead
 	}
 
 	return err
 }
 
+func (h *StreamIteratorHandlerFile) seekBackward(request *types.StreamIteratorRequest) error {
+	// find the rank of the index row following the next record to read
+	cptRows, err := h.index.GetRowsCount()
+	if err != nil {
+		return err
+	}
+
+	var (
+		rank int64
+		row  *streamIndexRowMsg
+	)
+	switch request.IteratorType {
+	case "FIRST_MESSAGE":
+		rank = min(cptRows, 1)
+	case "LAST_MESSAGE", "AFTER_LAST_MESSAGE":
+		rank = cptRows
+	case "AT_MESSAGE_ID", "AFTER_MESSAGE_ID":
+		if rank, row, err = h.index.GetRankAtOrAfterMessageId(request.MessageId); err != nil {
+			return err
+		}
+		if row == nil || row.Id != request.MessageId {
+			return errors.New("message id not found")
+		}
+		if request.IteratorType == "AT_MESSAGE_ID" {
+			rank++
+		}
+	case "AT_TIMESTAMP":
+		if rank, _, err = h.index.GetRankAfterTimestamp(&request.Timestamp); err != nil {
+			return err
+		}
+		if rank == 0 {
+			return errors.New("message id not found")
+		}
+	default:
+		return errors.New("invalid iterator type")
+	}
+
+	// keep the message id of the row as well, the ranks change when the stream is trimmed
+	switch {
+	case rank < cptRows:
+		rows, err := h.index.GetRows(rank, 1)
+		if err != nil {
+			return err
+		}
+		h.backwardMsgId = rows[0].Id
+	case cptRows > 0:
+		rows, err := h.index.GetRows(cptRows-1, 1)
+		if err != nil {
+			return err
+		}
+		h.backwardMsgId = rows[0].Id + 1
+	}
+	h.backwardRank = rank
+	return nil
+}
+
+func (h *StreamIteratorHandlerFile) reopenBackward() error {
+	// open the new data files and move before the last record read
+	// (there is nothing more to read if the previous records were removed)
+	if err := h.Open(); err != nil {
+		return err
+	}
+
+	rank, _, err := h.index.GetRankAtOrAfterMessageId(h.backwardMsgId)
+	if err != nil {
+		return err
+	}
+	h.backwardRank = rank
+	h.backwardRows = nil
+	h.backwardData = nil
+	return nil
+}
+
+func (h *StreamIteratorHandlerFile) readBackwardRecords() error {
+	// read at once the records preceding the records already read
+	if h.backwardRank == 0 {
+		return nil
+	}
+
+	startRank := max(h.backwardRank-BackwardReadBatchSize, 0)
+	rows, err := h.index.GetRows(startRank, h.backwardRank-startRank)
+	if err != nil {
+		return err
+	}
+	firstRow, lastRow := rows[0], rows[len(rows)-1]
+	data := make([]byte, lastRow.Offset+int64(lastRow.LengthInBytes)-firstRow.Offset)
+	if _, err = h.data.ReadAt(data, firstRow.Offset); err != nil {
+		return err
+	}
+
+	h.backwardRank = startRank
+	h.backwardRows = rows
+	h.backwardData = data
+	h.backwardDataOffset = firstRow.Offset
+	return nil
+}
+
+func (h *StreamIteratorHandlerFile) getPreviousRecord() (types.MessageId, interface{}, bool, bool, error) {
+	if len(h.backwardRows) == 0 {
+		if err := h.readBackwardRecords(); err != nil {
+			h.logger.Error(
+				"cannot read records backward",
+				zap.String("topic", "streamiterator"),
+				zap.String("method", "getPreviousRecord"),
+				zap.String("stream.uuid", h.streamUUID.String()),
+				zap.String("it.uuid", h.itUUID.String()),
+				zap.Error(err),
+			)
+			// result is: (no record, no record found, cannot continue, error)
+			return 0, nil, false, false, err
+		}
+		if len(h.backwardRows) == 0 {
+			// the first record of the stream has been read
+			// result is: (no record, no record found, cannot continue, no error)
+			return 0, nil, false, false, nil
+		}
+	}
+
+	row := h.backwardRows[len(h.backwardRows)-1]
+	h.backwardRows = h.backwardRows[:len(h.backwardRows)-1]
+	h.backwardMsgId = row.Id
+	line := h.backwardData[row.Offset-h.backwardDataOffset : row.Offset-h.backwardDataOffset+int64(row.LengthInBytes)]
+	h.bytesRead += int64(len(line))
+
+	if h.checksumMismatch != ChecksumMismatchNone {
+		if err := row.verifyChecksum(line); err != nil {
+			return h.onChecksumMismatch(row.Id, err, "getPreviousRecord")
+		}
+	}
+
+	var message interface{}
+	if errUnmarshal := json.Unmarshal(line, &message); errUnmarshal != nil {
+		h.logger.Error(
+			"json format error",
+			zap.String("topic", "streamiterator"),
+			zap.String("method", "getPreviousRecord"),
+			zap.String("stream.uuid", h.streamUUID.String()),
+			zap.String("it.uuid", h.itUUID.String()),
+			zap.ByteString("line", line),
+			zap.Error(errUnmarshal),
+		)
+		// result is: (no record, record found, may continue, error)
+		return row.Id, nil, true, true, errUnmarshal
+	}
+
+	if err := h.decryptRecord(row.Id, message, "getPreviousRecord"); err != nil {
+		// result is: (no record, record found, may continue, error)
+		return row.Id, nil, true, true, err
+	}
+
+	// result is: (valid record, record found, may continue, no error)
+	return row.Id, message, true, true, nil
+}
+
+func (h *StreamIteratorHandlerFile) decryptRecord(msgId types.MessageId, message interface{}, method string) error {
+	record, ok := message.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if err := decryptStreamRecord(record, h.keyRing); err != nil {
+		h.logger.Error(
+			"can't decrypt record",
+			zap.String("topic", "streamiterator"),
+			zap.String("method", method),
+			zap.String("stream.uuid", h.streamUUID.String()),
+			zap.String("it.uuid", h.itUUID.String()),
+			zap.Uint64("msgId", msgId),
+			zap.Error(err),
+		)
+		return err
+	}
+	return nil
+}
+
+func (h *StreamIteratorHandlerFile) isDataFileReplaced() (bool, error) {
+	// the segments started since the previous read are read as well,
+	// the segments are replaced when the head of the stream is trimmed
+	return h.data.refresh()
+}
+
+func (h *StreamIteratorHandlerFile) reopen() error {
+	// Open the new data files and move to the next record to read,
+	// or to the first record available if the next record was removed.
+	if err := h.Open(); err != nil {
+		return err
+	}
+
+	nextRecordIdToRead, fileOffset, err := h.index.GetOffsetAtOrAfterMessageId(h.nextRecordIdRead)
+	if err != nil {
+		return err
+	}
+
+	if nextRecordIdToRead > h.nextRecordIdRead {
+		h.logger.Info(
+			"Iterator moved to the head of the stream",
+			zap.String("topic", "streamiterator"),
+			zap.String("method", "reopen"),
+			zap.String("stream.uuid", h.streamUUID.String()),
+			zap.String("it.uuid", h.itUUID.String()),
+			zap.Uint64("nextRecordId", h.nextRecordIdRead),
+			zap.Uint64("firstRecordId", nextRecordIdToRead),
+		)
+	}
+
+	if _, err = h.data.Seek(fileOffset, io.SeekStart); err != nil {
+		return err
+	}
+	h.FileOffset = fileOffset
+	h.nextRecordIdRead = nextRecordIdToRead
+	h.reader.Reset(h.data)
+	h.forwardRows = nil
+	return nil
+}
+
+func (h *StreamIteratorHandlerFile) getForwardRow(msgId types.MessageId) (*streamIndexRowMsg, error) {
+	// the index rows of the records read forward are read at once,
+	// the row is nil if the record is not indexed yet (the index row is written after the record)
+	for len(h.forwardRows) > 0 && h.forwardRows[0].Id < msgId {
+		h.forwardRows = h.forwardRows[1:]
+	}
+	if len(h.forwardRows) == 0 || h.forwardRows[0].Id != msgId {
+		rows, err := h.readForwardRows(msgId)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 || rows[0].Id != msgId {
+			h.forwardRows = nil
+			return nil, nil
+		}
+		h.forwardRows = rows
+	}
+	row := h.forwardRows[0]
+	h.forwardRows = h.forwardRows[1:]
+	return &row, nil
+}
+
+func (h *StreamIteratorHandlerFile) readForwardRows(msgId types.MessageId) ([]streamIndexRowMsg, error) {
+	// read the index rows starting at the given message id, the rows follow the previous rows read unless the iterator moved,
+	// the view of the index is refreshed once its rows are read (it is opened again if the segments were replaced)
+	if h.forwardView != nil && h.forwardRank >= h.forwardView.cptRows {
+		replaced, err := h.forwardView.refresh()
+		if err != nil {
+			return nil, err
+		}
+		if replaced {
+			h.closeForwardView()
+		}
+	}
+	search := h.forwardView == nil
+	if search {
+		view, err := h.index.newView()
+		if err != nil {
+			return nil, err
+		}
+		h.forwardView = view
+	}
+
+	for {
+		if search {
+			rank, err := h.forwardView.searchRank(func(row *streamIndexRowMsg) bool { return row.Id >= msgId })
+			if err != nil {
+				return nil, err
+			}
+			h.forwardRank = rank
+		}
+		rows, err := h.forwardView.readRows(h.forwardRank, min(ForwardChecksumBatchSize, h.forwardView.cptRows-h.forwardRank))
+		if err != nil {
+			return nil, err
+		}
+		if !search && (len(rows) == 0 || rows[0].Id != msgId) {
+			// the iterator moved, or the record is not indexed yet
+			search = true
+			continue
+		}
+		h.forwardRank += int64(len(rows))
+		return rows, nil
+	}
+}
+
+func (h *StreamIteratorHandlerFile) closeForwardView() {
+	if h.forwardView != nil {
+		h.forwardView.close()
+		h.forwardView = nil
+	}
+	h.forwardRows = nil
+	h.forwardRank = 0
+}
+
+func (h *StreamIteratorHandlerFile) onChecksumMismatch(msgId types.MessageId, err error, method string) (types.MessageId, interface{}, bool, bool, error) {
+	h.logger.Error(
+		"corrupted record",
+		zap.String("topic", "streamiterator"),
+		zap.String("method", method),
+		zap.String("stream.uuid", h.streamUUID.String()),
+		zap.String("it.uuid", h.itUUID.String()),
+		zap.Uint64("msgId", msgId),
+		zap.String("onMismatch", h.checksumMismatch),
+		zap.Error(err),
+	)
+	switch h.checksumMismatch {
+	case ChecksumMismatchSkip:
+		// result is: (no record, record found, may continue, record skipped)
+		return msgId, nil, true, true, errRecordSkipped
+	case ChecksumMismatchFail:
+		// result is: (no record, record found, cannot continue, error)
+		return msgId, nil, true, false, err
+	default:
+		// result is: (no record, record found, may continue, error)
+		return msgId, nil, true, true, err
+	}
+}
+
 func (h *StreamIteratorHandlerFile) SaveSeek() error {
 	var err error
-	h.FileOffset, err = h.file.Seek(0, io.SeekCurrent)
+	h.FileOffset, err = h.data.Seek(0, io.SeekCurrent)
 	return err
 }
 
 func (h *StreamIteratorHandlerFile) GetNextRecord() (types.MessageId, interface{}, bool, bool, error) {
+	// the corrupted records skipped are followed by the next record
+	for {
+		var (
+			msgId       types.MessageId
+			message     interface{}
+			found       bool
+			canContinue bool
+			err         error
+		)
+		if h.backward {
+			msgId, message, found, canContinue, err = h.getPreviousRecord()
+		} else {
+			msgId, message, found, canContinue, err = h.getNextRecord()
+		}
+		if !errors.Is(err, errRecordSkipped) {
+			return msgId, message, found, canContinue, err
+		}
+	}
+}
+
+func (h *StreamIteratorHandlerFile) getNextRecord() (types.MessageId, interface{}, bool, bool, error) {
 	line, errRead := h.reader.ReadString(EOLChar)
 	if errRead != nil {
 		// err is often io.EOF (end of file reached)
@@ -128,7 +517,7 @@ func (h *StreamIteratorHandlerFile) GetNextRecord() (types.MessageId, interface{
 			h.logger.Error(
 				"cannot read record line",
 				zap.String("topic", "streamiterator"),
-				zap.String("method", "GetNextRecord"),
+				zap.String("method", "getNextRecord"),
 				zap.String("stream.uuid", h.streamUUID.String()),
 				zap.String("it.uuid", h.itUUID.String()),
 				zap.String("line", line),
@@ -145,10 +534,18 @@ func (h *StreamIteratorHandlerFile) GetNextRecord() (types.MessageId, interface{
 
 	var message interface{}
 	if errUnmarshal := json.Unmarshal([]byte(line), &message); errUnmarshal != nil {
+		if h.checksumMismatch != ChecksumMismatchNone {
+			// the record is located with the expected message id
+			if row, err := h.getForwardRow(lastRecordIdRead); err == nil && row != nil {
+				if err = row.verifyChecksum([]byte(line)); err != nil {
+					return h.onChecksumMismatch(lastRecordIdRead, err, "getNextRecord")
+				}
+			}
+		}
 		h.logger.Error(
 			"json format error",
 			zap.String("topic", "streamiterator"),
-			zap.String("method", "GetNextRecord"),
+			zap.String("method", "getNextRecord"),
 			zap.String("stream.uuid", h.streamUUID.String()),
 			zap.String("it.uuid", h.itUUID.String()),
 			zap.String("line", line),
@@ -158,16 +555,42 @@ func (h *StreamIteratorHandlerFile) GetNextRecord() (types.MessageId, interface{
 		return lastRecordIdRead, nil, true, true, errUnmarshal
 	}
 
+	// the record holds its own message id (the index may not start at the first message id)
+	if record, ok := message.(map[string]interface{}); ok {
+		if recordId, ok := record["i"].(float64); ok {
+			lastRecordIdRead = types.MessageId(recordId)
+			h.nextRecordIdRead = lastRecordIdRead + 1
+		}
+	}
+
+	if h.checksumMismatch != ChecksumMismatchNone {
+		row, err := h.getForwardRow(lastRecordIdRead)
+		if err != nil {
+			// result is: (no record, record found, may continue, error)
+			return lastRecordIdRead, nil, true, true, err
+		}
+		if row != nil {
+			if err = row.verifyChecksum([]byte(line)); err != nil {
+				return h.onChecksumMismatch(lastRecordIdRead, err, "getNextRecord")
+			}
+		}
+	}
+
+	if err := h.decryptRecord(lastRecordIdRead, message, "getNextRecord"); err != nil {
+		// result is: (no record, record found, may continue, error)
+		return lastRecordIdRead, nil, true, true, err
+	}
+
 	// result is: (valid record, record found, may continue, no error)
 	return lastRecordIdRead, message, true, true, nil
 }
 
-func NewStreamIteratorHandlerFile(streamUUID types.StreamUUID, iteratorUUID types.StreamIteratorUUID, filename string, idx *StreamIndexFile, logger *zap.Logger) *StreamIteratorHandlerFile {
-	return &StreamIteratorHandlerFile{
+func NewStreamIteratorHandlerFile(streamUUID types.StreamUUID, iteratorUUID types.StreamIteratorUUID, filename string, idx *StreamIndexFile, logger *zap.Logger, opts ...StreamIteratorHandlerFileOption) *StreamIteratorHandlerFile {
+	h := &StreamIteratorHandlerFile{
 		streamUUID:       streamUUID,
 		itUUID:           iteratorUUID,
 		initialized:      false,
-		file:             nil,
+		data:             nil,
 		filename:         filename,
 		FileOffset:       0,
 		bytesRead:        0,
@@ -175,5 +598,10 @@ func NewStreamIteratorHandlerFile(streamUUID types.StreamUUID, iteratorUUID type
 		index:            idx,
 		reader:           nil,
 		logger:           logger,
+		checksumMismatch: ChecksumMismatchCount,
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
@@ -0,0 +1,285 @@
+package jsonfileprovider
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestBackwardIteratorFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := zap.NewNop()
+	info := types.NewStreamInfo(uuid.New())
+	dataPath := filepath.Join(tmpDir, "data.jsonl")
+	indexPath := filepath.Join(tmpDir, "index.bin")
+	w := NewStreamWriterFile(info, dataPath, indexPath, filepath.Join(tmpDir, "meta.json"), logger, 0)
+	if err := w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+	if err := w.Open(); err != nil {
+		t.Fatalf("could not open stream writer: %v", err)
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+
+	// more records than read at once
+	cptRecords := 2*BackwardReadBatchSize + 50
+	start := time.Now()
+	records := make([]types.DeferedStreamRecord, 0, cptRecords)
+	for i := 1; i <= cptRecords; i++ {
+		records = append(records, types.DeferedStreamRecord{Id: types.MessageId(i), CreationDate: start.Add(time.Duration(i) * time.Second), Msg: map[string]interface{}{"n": i}})
+	}
+	if err := w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+
+	newIterator := func(req *types.StreamIteratorRequest) *StreamIteratorHandlerFile {
+		it := NewStreamIteratorHandlerFile(info.UUID, uuid.New(), dataPath, NewStreamIndex(info.UUID, indexPath, logger), logger)
+		if err := it.Open(); err != nil {
+			t.Fatalf("could not open iterator: %v", err)
+		}
+		if err := it.Seek(req); err != nil {
+			t.Fatalf("could not seek iterator: %v", err)
+		}
+		return it
+	}
+	expectRecords := func(it *StreamIteratorHandlerFile, firstMsgId types.MessageId, lastMsgId types.MessageId) {
+		for expectedId := firstMsgId; expectedId >= lastMsgId; expectedId-- {
+			msgId, record, found, _, err := it.GetNextRecord()
+			if err != nil || !found || msgId != expectedId {
+				t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+			}
+			if n := record.(map[string]interface{})["m"].(map[string]interface{})["n"]; n != float64(expectedId) {
+				t.Fatalf("unexpected record %v for message %d", record, expectedId)
+			}
+		}
+		if msgId, _, found, _, err := it.GetNextRecord(); err != nil || found {
+			t.Fatalf("expected no more record, got %d (%v)", msgId, err)
+		}
+	}
+
+	it := newIterator(&types.StreamIteratorRequest{IteratorType: "LAST_MESSAGE", Direction: types.IteratorDirectionBackward})
+	expectRecords(it, types.MessageId(cptRecords), 1)
+	_ = it.Close()
+
+	it = newIterator(&types.StreamIteratorRequest{IteratorType: "AFTER_MESSAGE_ID", MessageId: 3, Direction: types.IteratorDirectionBackward})
+	expectRecords(it, 2, 1)
+	_ = it.Close()
+
+	it = newIterator(&types.StreamIteratorRequest{IteratorType: "AT_TIMESTAMP", Timestamp: start.Add(10*time.Second + time.Millisecond), Direction: types.IteratorDirectionBackward})
+	expectRecords(it, 10, 1)
+	_ = it.Close()
+
+	// the stream is trimmed while it is read backward
+	req := types.StreamIteratorRequest{IteratorType: "LAST_MESSAGE", Direction: types.IteratorDirectionBackward}
+	it = newIterator(&req)
+	defer func() {
+		_ = it.Close()
+	}()
+	expectedId := types.MessageId(cptRecords)
+	if msgId, _, _, _, err := it.GetNextRecord(); err != nil || msgId != expectedId {
+		t.Fatalf("expected message %d, got %d (%v)", expectedId, msgId, err)
+	}
+	if _, err := w.Trim(&types.RetentionPolicy{MaxRecords: 20}, time.Now()); err != nil {
+		t.Fatalf("could not trim stream: %v", err)
+	}
+	if err := it.Seek(&req); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	expectRecords(it, expectedId-1, expectedId-19)
+}
+
+func TestForwardIteratorIndexRows(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := zap.NewNop()
+	info := types.NewStreamInfo(uuid.New())
+	dataPath := filepath.Join(tmpDir, "data.jsonl")
+	indexPath := filepath.Join(tmpDir, "index.bin")
+	// a segment holds about 40 records
+	w := NewStreamWriterFile(info, dataPath, indexPath, filepath.Join(tmpDir, "meta.json"), logger, 0, WithSegmentRolling(4096, 0))
+	if err := w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+	if err := w.Open(); err != nil {
+		t.Fatalf("could not open stream writer: %v", err)
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+
+	start := time.Now()
+	write := func(firstMsgId types.MessageId, lastMsgId types.MessageId) {
+		records := make([]types.DeferedStreamRecord, 0)
+		for i := firstMsgId; i <= lastMsgId; i++ {
+			records = append(records, types.DeferedStreamRecord{Id: i, CreationDate: start.Add(time.Duration(i) * time.Second), Msg: map[string]interface{}{"n": i}})
+		}
+		if err := w.Write(&records); err != nil {
+			t.Fatalf("could not write records: %v", err)
+		}
+	}
+	// more records than read at once
+	cptRecords := types.MessageId(2*ForwardChecksumBatchSize + 50)
+	write(1, cptRecords)
+
+	it := NewStreamIteratorHandlerFile(info.UUID, uuid.New(), dataPath, NewStreamIndex(info.UUID, indexPath, logger), logger)
+	defer func() {
+		_ = it.Close()
+	}()
+	if err := it.Open(); err != nil {
+		t.Fatalf("could not open iterator: %v", err)
+	}
+	if err := it.Seek(&types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	expectRecords := func(firstMsgId types.MessageId, lastMsgId types.MessageId) {
+		for expectedId := firstMsgId; expectedId <= lastMsgId; expectedId++ {
+			msgId, _, found, _, err := it.GetNextRecord()
+			if err != nil || !found || msgId != expectedId {
+				t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+			}
+		}
+		if msgId, _, found, _, err := it.GetNextRecord(); err != nil || found {
+			t.Fatalf("expected no more record, got %d (%v)", msgId, err)
+		}
+	}
+
+	// the view of the index is kept while the records are read, the segments started meanwhile are added to it
+	expectRecords(1, cptRecords)
+	view := it.forwardView
+	if view == nil || view.cptRows != int64(cptRecords) {
+		t.Fatalf("expected the view of the index rows, got %+v", view)
+	}
+	write(cptRecords+1, cptRecords+100)
+	if err := it.Seek(nil); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	expectRecords(cptRecords+1, cptRecords+100)
+	if it.forwardView != view || view.cptRows != int64(cptRecords+100) || len(view.segments) < 2 {
+		t.Fatalf("expected the view of the index rows to be refreshed, got %+v", it.forwardView)
+	}
+}
+
+func TestForwardIteratorEmptyStream(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := zap.NewNop()
+	info := types.NewStreamInfo(uuid.New())
+	dataPath := filepath.Join(tmpDir, "data.jsonl")
+	indexPath := filepath.Join(tmpDir, "index.bin")
+	w := NewStreamWriterFile(info, dataPath, indexPath, filepath.Join(tmpDir, "meta.json"), logger, 0)
+	if err := w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+	if err := w.Open(); err != nil {
+		t.Fatalf("could not open stream writer: %v", err)
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+
+	newIterator := func(iteratorType string) *StreamIteratorHandlerFile {
+		it := NewStreamIteratorHandlerFile(info.UUID, uuid.New(), dataPath, NewStreamIndex(info.UUID, indexPath, logger), logger)
+		if err := it.Open(); err != nil {
+			t.Fatalf("could not open iterator: %v", err)
+		}
+		if err := it.Seek(&types.StreamIteratorRequest{IteratorType: iteratorType}); err != nil {
+			t.Fatalf("could not seek iterator %s: %v", iteratorType, err)
+		}
+		return it
+	}
+	expectRecords := func(it *StreamIteratorHandlerFile, firstMsgId types.MessageId, lastMsgId types.MessageId) {
+		for expectedId := firstMsgId; expectedId <= lastMsgId; expectedId++ {
+			msgId, _, found, _, err := it.GetNextRecord()
+			if err != nil || !found || msgId != expectedId {
+				t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+			}
+		}
+		if msgId, _, found, _, err := it.GetNextRecord(); err != nil || found {
+			t.Fatalf("expected no more record, got %d (%v)", msgId, err)
+		}
+	}
+
+	// the iterators of an empty stream wait for the first message
+	lastIt := newIterator("LAST_MESSAGE")
+	defer func() {
+		_ = lastIt.Close()
+	}()
+	afterLastIt := newIterator("AFTER_LAST_MESSAGE")
+	defer func() {
+		_ = afterLastIt.Close()
+	}()
+	expectRecords(lastIt, 1, 0)
+	expectRecords(afterLastIt, 1, 0)
+
+	start := time.Now()
+	records := make([]types.DeferedStreamRecord, 0)
+	for i := types.MessageId(1); i <= 10; i++ {
+		records = append(records, types.DeferedStreamRecord{Id: i, CreationDate: start.Add(time.Duration(i) * time.Second), Msg: map[string]interface{}{"n": i}})
+	}
+	if err := w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+	for _, it := range []*StreamIteratorHandlerFile{lastIt, afterLastIt} {
+		if err := it.Seek(nil); err != nil {
+			t.Fatalf("could not seek iterator: %v", err)
+		}
+		expectRecords(it, 1, 10)
+	}
+}
+
+func TestForwardIteratorMessageIds(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := zap.NewNop()
+	info := types.NewStreamInfo(uuid.New())
+	dataPath := filepath.Join(tmpDir, "data.jsonl")
+	indexPath := filepath.Join(tmpDir, "index.bin")
+	w := NewStreamWriterFile(info, dataPath, indexPath, filepath.Join(tmpDir, "meta.json"), logger, 0)
+	if err := w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+	if err := w.Open(); err != nil {
+		t.Fatalf("could not open stream writer: %v", err)
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+
+	// the first record of the stream is not the message id 1
+	start := time.Now()
+	records := make([]types.DeferedStreamRecord, 0)
+	for i := types.MessageId(7); i <= 10; i++ {
+		records = append(records, types.DeferedStreamRecord{Id: i, CreationDate: start.Add(time.Duration(i) * time.Second), Msg: map[string]interface{}{"n": i}})
+	}
+	if err := w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+
+	// the message ids are the ones of the records
+	it := NewStreamIteratorHandlerFile(info.UUID, uuid.New(), dataPath, NewStreamIndex(info.UUID, indexPath, logger), logger)
+	defer func() {
+		_ = it.Close()
+	}()
+	if err := it.Open(); err != nil {
+		t.Fatalf("could not open iterator: %v", err)
+	}
+	if err := it.Seek(&types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	for expectedId := types.MessageId(7); expectedId <= 10; expectedId++ {
+		msgId, record, found, _, err := it.GetNextRecord()
+		if err != nil || !found || msgId != expectedId {
+			t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+		}
+		if n := record.(map[string]interface{})["m"].(map[string]interface{})["n"]; n != float64(expectedId) {
+			t.Fatalf("unexpected record %v for message %d", record, expectedId)
+		}
+	}
+	if msgId, _, found, _, err := it.GetNextRecord(); err != nil || found {
+		t.Fatalf("expected no more record, got %d (%v)", msgId, err)
+	}
+}
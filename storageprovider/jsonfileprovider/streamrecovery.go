@@ -0,0 +1,488 @@
+package jsonfileprovider
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+)
+
+// A stream may be inconsistent when the process died while writing it: the data file of the active segment can end
+// with a torn record, its index file can miss the rows of the last records written (or end with a partial row),
+// the meta info of the stream is saved after the records and the files of a segment being rolled, compressed
+// or trimmed may be left in the stream directory. The check scans the data files and compares them with the index
+// files, the repair truncates the torn records, rewrites the index rows and recomputes the meta info of the stream.
+
+type StreamCheckIssue struct {
+	File    string `json:"file"`
+	Problem string `json:"problem"`
+	Repair  string `json:"repair,omitempty"` // change made to repair the file (empty if not repaired)
+}
+
+type StreamCheckReport struct {
+	StreamUUID types.StreamUUID   `json:"streamUUID"`
+	Issues     []StreamCheckIssue `json:"issues"`
+}
+
+func (r *StreamCheckReport) CountUnrepaired() int {
+	cpt := 0
+	for _, issue := range r.Issues {
+		if issue.Repair == "" {
+			cpt++
+		}
+	}
+	return cpt
+}
+
+type streamChecker struct {
+	storage  *FileStorage
+	info     *types.StreamInfo
+	segments *streamSegmentManifest
+	full     bool // scan all the segments (otherwise the tail of the active segment only)
+	repair   bool
+	report   *StreamCheckReport
+}
+
+func (s *FileStorage) CheckStream(info *types.StreamInfo, full bool, repair bool) (*StreamCheckReport, error) {
+	// Check the files of a stream which is not opened (no writer nor iterator), and repair them if asked.
+	// Without full check only the tail of the active segment is scanned (the sealed segments were synced to the disk).
+	c := &streamChecker{
+		storage: s,
+		info:    info,
+		full:    full,
+		repair:  repair,
+		report:  &StreamCheckReport{StreamUUID: info.UUID, Issues: make([]StreamCheckIssue, 0)},
+	}
+	err := c.check()
+	for _, issue := range c.report.Issues {
+		s.logger.Warn(
+			"Stream inconsistency",
+			zap.String("topic", "stream"),
+			zap.String("method", "CheckStream"),
+			zap.String("stream.uuid", info.UUID.String()),
+			zap.String("file", issue.File),
+			zap.String("problem", issue.Problem),
+			zap.String("repair", issue.Repair),
+		)
+	}
+	if err != nil {
+		s.logger.Error(
+			"Can't check stream",
+			zap.String("topic", "stream"),
+			zap.String("method", "CheckStream"),
+			zap.String("stream.uuid", info.UUID.String()),
+			zap.Error(err),
+		)
+	}
+	return c.report, err
+}
+
+func (s *FileStorage) Fsck(repair bool) ([]*StreamCheckReport, error) {
+	// Check all the segments of all the streams of the catalog (the server must not be running)
+	streamsUUID, err := s.catalog.LoadStreamCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*StreamCheckReport, 0, len(streamsUUID))
+	for _, streamUUID := range streamsUUID {
+		info, err := s.GetStreamInfo(streamUUID)
+		if err != nil {
+			return reports, err
+		}
+		report, err := s.CheckStream(info, true, repair)
+		if err != nil {
+			report.addIssue(s.GetStreamDirectoryPath(streamUUID), fmt.Sprintf("can't check stream: %s", err.Error()), "")
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func (r *StreamCheckReport) addIssue(file string, problem string, repair string) {
+	r.Issues = append(r.Issues, StreamCheckIssue{File: file, Problem: problem, Repair: repair})
+}
+
+func (c *streamChecker) addIssue(file string, problem string, repair string) {
+	if !c.repair {
+		repair = ""
+	}
+	c.report.addIssue(file, problem, repair)
+}
+
+func (c *streamChecker) check() error {
+	var err error
+	dataFilePath := c.storage.GetStreamDataFilePath(c.info.UUID)
+	indexFilePath := c.storage.GetStreamIndexFilePath(c.info.UUID)
+	if c.segments, err = loadSegmentManifest(filepath.Dir(dataFilePath), filepath.Base(dataFilePath), filepath.Base(indexFilePath)); err != nil {
+		return err
+	}
+	if found, errManifest := c.segments.exists(); errManifest != nil {
+		return errManifest
+	} else if !found {
+		// a stream written before the segments may have index rows of 20 bytes (written by the first versions
+		// of the writer, while the readers expected the rows of 32 bytes), all its records are compared with the index
+		c.full = true
+	}
+	for position, segment := range c.segments.Segments {
+		if segment.Sealed == (position == len(c.segments.Segments)-1) {
+			return fmt.Errorf("invalid segment manifest %s: the last segment only must not be sealed", c.segments.getPath())
+		}
+	}
+
+	if err = c.checkOrphanFiles(); err != nil {
+		return err
+	}
+	for position := range c.segments.Segments {
+		if err = c.checkSegment(c.segments.Segments[position]); err != nil {
+			return err
+		}
+	}
+	return c.checkMetaInfo()
+}
+
+func (c *streamChecker) checkOrphanFiles() error {
+	// the files of a segment not listed by the manifest were left by a roll, a compression or a trim interrupted
+	listed := make(map[string]bool)
+	for _, segment := range c.segments.Segments {
+		listed[segment.DataFile] = true
+		listed[segment.IndexFile] = true
+	}
+	prefixes := []string{
+		strings.TrimSuffix(c.segments.dataFilename, filepath.Ext(c.segments.dataFilename)) + ".",
+		strings.TrimSuffix(c.segments.indexFilename, filepath.Ext(c.segments.indexFilename)) + ".",
+	}
+
+	entries, err := os.ReadDir(c.segments.directory)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		filename := entry.Name()
+		if entry.IsDir() || listed[filename] {
+			continue
+		}
+		orphan := filename == segmentManifestFilename+".tmp"
+		for _, prefix := range prefixes {
+			orphan = orphan || strings.HasPrefix(filename, prefix)
+		}
+		if !orphan {
+			continue
+		}
+		if c.repair {
+			if err = os.Remove(c.segments.getFilePath(filename)); err != nil {
+				return err
+			}
+		}
+		c.addIssue(filename, "file not listed by the segment manifest", "deleted")
+	}
+	return nil
+}
+
+func (c *streamChecker) checkSegment(segment *streamSegment) error {
+	dataPath := c.segments.getFilePath(segment.DataFile)
+	indexPath := c.segments.getFilePath(segment.IndexFile)
+
+	data, err := c.segments.openDataFile(segment)
+	if errors.Is(err, os.ErrNotExist) && !segment.Sealed && c.repair {
+		// the active segment may not hold any record yet
+		c.addIssue(segment.DataFile, "data file not found", "created empty")
+		if err = os.WriteFile(dataPath, nil, 0644); err == nil {
+			data, err = c.segments.openDataFile(segment)
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) && segment.Compression == "" {
+			return err
+		}
+		c.addIssue(segment.DataFile, fmt.Sprintf("can't read data file: %s", err.Error()), "")
+		return nil
+	}
+	defer func() {
+		_ = data.Close()
+	}()
+	dataSize, err := data.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	index, err := os.Open(indexPath)
+	var indexSize int64
+	switch {
+	case err == nil:
+		defer func() {
+			_ = index.Close()
+		}()
+		info, errStat := index.Stat()
+		if errStat != nil {
+			return errStat
+		}
+		indexSize = info.Size()
+	case errors.Is(err, os.ErrNotExist):
+		// rebuilt from the data file
+		index = nil
+	default:
+		return err
+	}
+	cptRows := indexSize / sizeOfStreamIndexRowMsg
+	mismatch := index == nil || indexSize%sizeOfStreamIndexRowMsg != 0
+
+	if !c.full && !mismatch && segment.Sealed && cptRows == segment.CptMessages && dataSize == segment.SizeInBytes {
+		return nil
+	}
+
+	// the records are written before their index row, the records of the active segment
+	// up to indexedEnd were entirely written (their index row was written)
+	var indexedRank, indexedEnd int64
+	if !segment.Sealed && !mismatch {
+		row := streamIndexRowMsg{}
+		for indexedRank = cptRows; indexedRank > 0; indexedRank-- {
+			if err = readIndexRowAt(index, indexedRank-1, &row); err != nil {
+				return err
+			}
+			if row.Offset+int64(row.LengthInBytes) <= dataSize {
+				indexedEnd = row.Offset + int64(row.LengthInBytes)
+				break
+			}
+		}
+	}
+
+	// the rows before fromRank are not checked
+	var fromRank, fromOffset int64
+	if !c.full && !mismatch {
+		fromRank, fromOffset = indexedRank, indexedEnd
+	}
+
+	// compare the records of the data file with the rows of the index file
+	var indexRows *bufio.Reader
+	if index != nil {
+		indexRows = bufio.NewReader(io.NewSectionReader(index, fromRank*sizeOfStreamIndexRowMsg, (cptRows-fromRank)*sizeOfStreamIndexRowMsg))
+	}
+	var cptScanned int64
+	corrupted := make([]types.MessageId, 0)
+	end, err := scanDataRecords(data, fromOffset, func(row *streamIndexRowMsg) error {
+		cptScanned++
+		if mismatch || fromRank+cptScanned > cptRows {
+			mismatch = true
+			return nil
+		}
+		indexRow := streamIndexRowMsg{}
+		if err := binary.Read(indexRows, binary.LittleEndian, &indexRow); err != nil {
+			return err
+		}
+		if !indexRow.hasChecksum() {
+			// the row was written before the checksums
+			indexRow.Checksum = row.Checksum
+		}
+		if indexRow != *row && indexRow.Checksum != row.Checksum {
+			indexRow.Checksum = row.Checksum
+			if indexRow == *row {
+				// the record was modified since it was written
+				corrupted = append(corrupted, row.Id)
+				return nil
+			}
+		}
+		mismatch = indexRow != *row
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cptRecords := fromRank + cptScanned
+	mismatch = mismatch || cptRecords != cptRows
+
+	for _, msgId := range corrupted {
+		c.addIssue(segment.DataFile, fmt.Sprintf("record %d doesn't match its checksum", msgId), "")
+	}
+	if end < dataSize {
+		if segment.Sealed || end < indexedEnd {
+			// the sealed segments were synced to the disk and the indexed records were entirely written, the file is corrupted
+			c.addIssue(segment.DataFile, fmt.Sprintf("invalid record at offset %d", end), "")
+			return nil
+		}
+		if c.repair {
+			if err = truncateFile(dataPath, end); err != nil {
+				return err
+			}
+		}
+		c.addIssue(segment.DataFile, fmt.Sprintf("torn record at offset %d (%d bytes)", end, dataSize-end), fmt.Sprintf("truncated to %d bytes", end))
+	}
+	if segment.Sealed && (cptRecords != segment.CptMessages || end != segment.SizeInBytes) {
+		c.addIssue(segment.DataFile, fmt.Sprintf("segment holds %d records (%d bytes), the manifest lists %d records (%d bytes)", cptRecords, end, segment.CptMessages, segment.SizeInBytes), "")
+		return nil
+	}
+
+	if mismatch && len(corrupted) > 0 {
+		// the index is not rebuilt from corrupted records
+		c.addIssue(segment.IndexFile, "index rows do not match the records of the data file", "")
+		return nil
+	}
+	if mismatch {
+		problem := fmt.Sprintf("index holds %d rows, data file holds %d records", cptRows, cptRecords)
+		switch {
+		case index == nil:
+			problem = "index file not found"
+		case indexSize%sizeOfStreamIndexRowMsg != 0:
+			problem = fmt.Sprintf("index ends with a partial row (%d bytes)", indexSize%sizeOfStreamIndexRowMsg)
+		case cptRows == cptRecords:
+			problem = "index rows do not match the records of the data file"
+		}
+		if c.repair {
+			if err = rewriteIndexRows(indexPath, fromRank, data, fromOffset); err != nil {
+				return err
+			}
+		}
+		c.addIssue(segment.IndexFile, problem, fmt.Sprintf("rebuilt %d rows", cptRecords-fromRank))
+	}
+	return nil
+}
+
+func (c *streamChecker) checkMetaInfo() error {
+	// the messages info of the stream are recomputed from the index rows
+	view, err := openSegmentIndexView(c.segments)
+	if err != nil {
+		return err
+	}
+	defer view.close()
+
+	readable := c.info.ReadableMessages
+	if view.cptRows == 0 {
+		if readable.CptMessages > 0 {
+			readable.TrimHead(readable.CptMessages, readable.SizeInBytes, 0, time.Time{})
+		}
+	} else {
+		var firstRow, lastRow streamIndexRowMsg
+		if err = view.readRow(0, &firstRow); err != nil {
+			return err
+		}
+		if err = view.readRow(view.cptRows-1, &lastRow); err != nil {
+			return err
+		}
+		readable.CptMessages = types.Size64(view.cptRows)
+		readable.SizeInBytes = types.Size64(lastRow.Offset + int64(lastRow.LengthInBytes))
+		readable.FirstMsgId = firstRow.Id
+		readable.LastMsgId = lastRow.Id
+		readable.FirstMsgTimestamp = time.Unix(0, firstRow.TimestampUnixNano)
+		readable.LastMsgTimestamp = time.Unix(0, lastRow.TimestampUnixNano)
+	}
+
+	// the message ids of the records written must not be given to new records
+	ingested := c.info.IngestedMessages
+	if readable.CptMessages > 0 && ingested.LastMsgId < readable.LastMsgId {
+		if ingested.CptMessages == 0 {
+			ingested.FirstMsgId = readable.FirstMsgId
+			ingested.FirstMsgTimestamp = readable.FirstMsgTimestamp
+		}
+		ingested.CptMessages += types.Size64(readable.LastMsgId - ingested.LastMsgId)
+		ingested.LastMsgId = readable.LastMsgId
+		ingested.LastMsgTimestamp = readable.LastMsgTimestamp
+	}
+
+	if isSameMessagesInfo(&readable, &c.info.ReadableMessages) && isSameMessagesInfo(&ingested, &c.info.IngestedMessages) {
+		return nil
+	}
+	problem := fmt.Sprintf(
+		"meta info lists %d readable records (ids %d to %d, last id ingested %d), the index holds %d records (ids %d to %d)",
+		c.info.ReadableMessages.CptMessages, c.info.ReadableMessages.FirstMsgId, c.info.ReadableMessages.LastMsgId,
+		c.info.IngestedMessages.LastMsgId, readable.CptMessages, readable.FirstMsgId, readable.LastMsgId,
+	)
+	if c.repair {
+		c.info.ReadableMessages = readable
+		c.info.IngestedMessages = ingested
+		w := NewStreamWriterFile(c.info, c.storage.GetStreamDataFilePath(c.info.UUID), c.storage.GetStreamIndexFilePath(c.info.UUID), c.storage.GetMetaDataFilePath(c.info.UUID), c.storage.logger, c.storage.logVerbosity)
+		if err = w.SaveFileMetaInfo(); err != nil {
+			return err
+		}
+	}
+	c.addIssue(filepath.Base(c.storage.GetMetaDataFilePath(c.info.UUID)), problem, "recomputed from the index")
+	return nil
+}
+
+func isSameMessagesInfo(a *types.StreamMessagesInfo, b *types.StreamMessagesInfo) bool {
+	return a.CptMessages == b.CptMessages && a.SizeInBytes == b.SizeInBytes &&
+		a.FirstMsgId == b.FirstMsgId && a.LastMsgId == b.LastMsgId &&
+		a.FirstMsgTimestamp.Equal(b.FirstMsgTimestamp) && a.LastMsgTimestamp.Equal(b.LastMsgTimestamp)
+}
+
+func scanDataRecords(data io.ReadSeeker, offset int64, onRecord func(row *streamIndexRowMsg) error) (int64, error) {
+	// read the records from offset, returns the offset following the last valid record
+	// (a record without end of line or which is not a valid json record is torn)
+	if _, err := data.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	reader := bufio.NewReaderSize(data, 1024*1024)
+	row := streamIndexRowMsg{}
+	for {
+		line, err := reader.ReadBytes(EOLChar)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+
+		message := types.DeferedStreamRecord{}
+		if err = json.Unmarshal(line, &message); err != nil || message.Id == 0 {
+			return offset, nil
+		}
+		row.Id = message.Id
+		row.LengthInBytes = uint32(len(line))
+		row.Checksum = getRecordChecksum(line)
+		row.Offset = offset
+		row.TimestampUnixNano = message.CreationDate.UnixNano()
+		if err = onRecord(&row); err != nil {
+			return offset, err
+		}
+		offset += int64(len(line))
+	}
+}
+
+func rewriteIndexRows(indexPath string, fromRank int64, data io.ReadSeeker, fromOffset int64) error {
+	// replace the index rows from fromRank with the rows of the records of the data file from fromOffset
+	file, err := os.OpenFile(indexPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err = file.Truncate(fromRank * sizeOfStreamIndexRowMsg); err == nil {
+		_, err = file.Seek(fromRank*sizeOfStreamIndexRowMsg, io.SeekStart)
+	}
+	if err == nil {
+		writer := bufio.NewWriter(file)
+		if _, err = scanDataRecords(data, fromOffset, func(row *streamIndexRowMsg) error {
+			return binary.Write(writer, binary.LittleEndian, row)
+		}); err == nil {
+			err = writer.Flush()
+		}
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func truncateFile(path string, size int64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err = file.Truncate(size); err == nil {
+		err = file.Sync()
+	}
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
@@ -0,0 +1,231 @@
+package jsonfileprovider
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestCheckStream(t *testing.T) {
+	logger := zap.NewNop()
+	s := &FileStorage{logger: logger, dataDirectory: t.TempDir()}
+	info := types.NewStreamInfo(uuid.New())
+	if err := s.CreateStreamDirectory(info.UUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+	dataPath := s.GetStreamDataFilePath(info.UUID)
+	indexPath := s.GetStreamIndexFilePath(info.UUID)
+	directory := s.GetStreamDirectoryPath(info.UUID)
+
+	// a segment holds 3 records
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	newRecord := func(msgId types.MessageId) []byte {
+		bytes, err := json.Marshal(types.DeferedStreamRecord{Id: msgId, CreationDate: start.Add(time.Duration(msgId) * time.Second), Msg: map[string]interface{}{"n": msgId}})
+		if err != nil {
+			t.Fatalf("could not marshal record: %v", err)
+		}
+		return append(bytes, EOLChar)
+	}
+	w := NewStreamWriterFile(info, dataPath, indexPath, s.GetMetaDataFilePath(info.UUID), logger, 0, WithSegmentRolling(3*int64(len(newRecord(10))), 0))
+	if err := w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+	if err := w.Open(); err != nil {
+		t.Fatalf("could not open stream writer: %v", err)
+	}
+	records := make([]types.DeferedStreamRecord, 0)
+	for i := types.MessageId(1); i <= 10; i++ {
+		records = append(records, types.DeferedStreamRecord{Id: i, CreationDate: start.Add(time.Duration(i) * time.Second), Msg: map[string]interface{}{"n": i}})
+	}
+	if err := w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+	_ = w.Close()
+	// the ingested messages are counted by the stream
+	info.IngestedMessages = info.ReadableMessages
+	activeDataPath := filepath.Join(directory, "data.00000000000000000010.jsonl")
+	activeIndexPath := filepath.Join(directory, "index.00000000000000000010.bin")
+
+	appendFile := func(path string, bytes []byte) {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("could not open file: %v", err)
+		}
+		if _, err = file.Write(bytes); err != nil {
+			t.Fatalf("could not write file: %v", err)
+		}
+		_ = file.Close()
+	}
+	check := func(full bool, repair bool, expectedIssues int) *StreamCheckReport {
+		report, err := s.CheckStream(info, full, repair)
+		if err != nil {
+			t.Fatalf("could not check stream: %v", err)
+		}
+		if len(report.Issues) != expectedIssues {
+			t.Fatalf("expected %d issues, got %+v", expectedIssues, report.Issues)
+		}
+		return report
+	}
+	expectRecords := func(lastMsgId types.MessageId) {
+		it := NewStreamIteratorHandlerFile(info.UUID, uuid.New(), dataPath, NewStreamIndex(info.UUID, indexPath, logger), logger)
+		defer func() {
+			_ = it.Close()
+		}()
+		if err := it.Open(); err != nil {
+			t.Fatalf("could not open iterator: %v", err)
+		}
+		if err := it.Seek(&types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}); err != nil {
+			t.Fatalf("could not seek iterator: %v", err)
+		}
+		for expectedId := types.MessageId(1); expectedId <= lastMsgId; expectedId++ {
+			if msgId, _, found, _, err := it.GetNextRecord(); err != nil || !found || msgId != expectedId {
+				t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+			}
+		}
+		if msgId, _, found, _, err := it.GetNextRecord(); err != nil || found {
+			t.Fatalf("expected no more record, got %d (%v)", msgId, err)
+		}
+	}
+	check(true, false, 0)
+
+	// crash after a record was written but not its index row, while the next record was written
+	// and while a segment was rolled
+	appendFile(activeDataPath, newRecord(11))
+	appendFile(activeDataPath, newRecord(12)[:20])
+	if err := os.WriteFile(filepath.Join(directory, "data.00000000000000000013.jsonl"), nil, 0644); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	// the meta info is compared with the index rows (not repaired yet)
+	report := check(false, false, 3)
+	if report.CountUnrepaired() != 3 || info.ReadableMessages.CptMessages != 10 {
+		t.Fatalf("expected nothing repaired, got %+v", report.Issues)
+	}
+	report = check(false, true, 4)
+	if report.CountUnrepaired() != 0 {
+		t.Fatalf("expected all issues repaired, got %+v", report.Issues)
+	}
+	if info.ReadableMessages.CptMessages != 11 || info.ReadableMessages.LastMsgId != 11 || info.IngestedMessages.LastMsgId != 11 || !info.ReadableMessages.LastMsgTimestamp.Equal(start.Add(11*time.Second)) {
+		t.Fatalf("unexpected messages info %+v %+v", info.ReadableMessages, info.IngestedMessages)
+	}
+	if _, err := os.Stat(filepath.Join(directory, "data.00000000000000000013.jsonl")); !os.IsNotExist(err) {
+		t.Fatalf("expected the orphan file to be deleted (%v)", err)
+	}
+	check(true, false, 0)
+	expectRecords(11)
+
+	// the data file lost the end of the last record (the index row was written)
+	stat, err := os.Stat(activeDataPath)
+	if err != nil {
+		t.Fatalf("could not stat data file: %v", err)
+	}
+	if err = os.Truncate(activeDataPath, stat.Size()-5); err != nil {
+		t.Fatalf("could not truncate data file: %v", err)
+	}
+	check(false, true, 3)
+	if info.ReadableMessages.CptMessages != 10 || info.ReadableMessages.LastMsgId != 10 || info.IngestedMessages.LastMsgId != 11 {
+		t.Fatalf("unexpected messages info %+v %+v", info.ReadableMessages, info.IngestedMessages)
+	}
+	expectRecords(10)
+
+	// a partial index row
+	appendFile(activeIndexPath, []byte{1, 2, 3})
+	check(false, true, 1)
+	check(true, false, 0)
+
+	// the index of a sealed segment is corrupted, it is found by the full check only
+	if err = os.WriteFile(filepath.Join(directory, "index.00000000000000000004.bin"), make([]byte, 3*sizeOfStreamIndexRowMsg), 0644); err != nil {
+		t.Fatalf("could not write index file: %v", err)
+	}
+	check(false, true, 0)
+	check(true, true, 1)
+	check(true, false, 0)
+	expectRecords(10)
+
+	// a sealed segment with an invalid record can't be repaired
+	if err = os.WriteFile(filepath.Join(directory, "data.00000000000000000004.jsonl"), append(newRecord(4), []byte("{}\n{}\n")...), 0644); err != nil {
+		t.Fatalf("could not write data file: %v", err)
+	}
+	if report = check(true, true, 1); report.CountUnrepaired() != 1 {
+		t.Fatalf("expected an unrepaired issue, got %+v", report.Issues)
+	}
+}
+
+func TestCheckStreamWithPreviousIndexRows(t *testing.T) {
+	logger := zap.NewNop()
+	s := &FileStorage{logger: logger, dataDirectory: t.TempDir()}
+	info := types.NewStreamInfo(uuid.New())
+	if err := s.CreateStreamDirectory(info.UUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+	dataPath := s.GetStreamDataFilePath(info.UUID)
+	indexPath := s.GetStreamIndexFilePath(info.UUID)
+
+	// a stream written before the segments: no manifest and index rows of 20 bytes
+	// (8 rows of 20 bytes are 5 rows of 32 bytes, the size of the index file doesn't tell the layout,
+	// and with dates near the epoch the offsets read from the rows fall within the data file)
+	start := time.Unix(0, 0)
+	var data, index bytes.Buffer
+	for i := types.MessageId(1); i <= 8; i++ {
+		record, err := json.Marshal(types.DeferedStreamRecord{Id: i, CreationDate: start.Add(time.Duration(i) * time.Second), Msg: map[string]interface{}{"n": i}})
+		if err != nil {
+			t.Fatalf("could not marshal record: %v", err)
+		}
+		data.Write(append(record, EOLChar))
+		_ = binary.Write(&index, binary.LittleEndian, struct {
+			Id         types.MessageId
+			BytesCount int32
+			DateTime   int64
+		}{i, int32(len(record) + 1), start.Add(time.Duration(i) * time.Second).Unix()})
+	}
+	if err := os.WriteFile(dataPath, data.Bytes(), 0644); err != nil {
+		t.Fatalf("could not write data file: %v", err)
+	}
+	if err := os.WriteFile(indexPath, index.Bytes(), 0644); err != nil {
+		t.Fatalf("could not write index file: %v", err)
+	}
+	info.IngestedMessages.CptMessages = 8
+	info.IngestedMessages.FirstMsgId = 1
+	info.IngestedMessages.LastMsgId = 8
+	info.ReadableMessages = info.IngestedMessages
+
+	// the index is rebuilt by the check at startup (which doesn't scan the whole segment otherwise)
+	report, err := s.CheckStream(info, false, true)
+	if err != nil || len(report.Issues) == 0 || report.CountUnrepaired() != 0 {
+		t.Fatalf("expected the index to be rebuilt, got %+v (%v)", report, err)
+	}
+	if stat, err := os.Stat(indexPath); err != nil || stat.Size() != 8*sizeOfStreamIndexRowMsg {
+		t.Fatalf("unexpected index file %+v (%v)", stat, err)
+	}
+
+	w := NewStreamWriterFile(info, dataPath, indexPath, s.GetMetaDataFilePath(info.UUID), logger, 0)
+	if err = w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+	it := NewStreamIteratorHandlerFile(info.UUID, uuid.New(), dataPath, NewStreamIndex(info.UUID, indexPath, logger), logger)
+	defer func() {
+		_ = it.Close()
+	}()
+	if err = it.Open(); err != nil {
+		t.Fatalf("could not open iterator: %v", err)
+	}
+	if err = it.Seek(&types.StreamIteratorRequest{IteratorType: "AT_MESSAGE_ID", MessageId: 6}); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	if msgId, _, found, _, err := it.GetNextRecord(); err != nil || !found || msgId != 6 {
+		t.Fatalf("expected message 6, got %d (found %t, %v)", msgId, found, err)
+	}
+
+	// the manifest is saved by the writer, the stream is checked as usual from now on
+	if report, err = s.CheckStream(info, true, false); err != nil || len(report.Issues) != 0 {
+		t.Fatalf("unexpected issues %+v (%v)", report, err)
+	}
+}
@@ -0,0 +1,286 @@
+package jsonfileprovider
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+func (w *StreamWriterFile) Trim(policy *types.RetentionPolicy, now time.Time) (types.Size64, error) {
+	// Remove the records that are out of the retention policy.
+	// The segments holding only records to remove are deleted, the records to keep of the first segment left
+	// are copied into new data and index files that replace the segment (the active segment is never deleted).
+	// The iterators detect the new generation of the segments and move to the same message id (or to the new head).
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.state != STREAM_WRITER_FILE_STATE_OPENED {
+		return 0, fmt.Errorf("cannot trim stream writer file because it's not opened")
+	}
+
+	view, err := openSegmentIndexView(w.segments)
+	if err != nil {
+		return 0, err
+	}
+	defer view.close()
+
+	cptRows := view.cptRows
+	if cptRows == 0 {
+		return 0, nil
+	}
+
+	row := streamIndexRowMsg{}
+	var lastRow streamIndexRowMsg
+	if err = view.readRow(cptRows-1, &lastRow); err != nil {
+		return 0, err
+	}
+	dataSize := lastRow.Offset + int64(lastRow.LengthInBytes)
+
+	cptToRemove, err := policy.CountRecordsToRemove(cptRows, now, func(rank int64) (int64, uint64, error) {
+		if err := view.readRow(rank, &row); err != nil {
+			return 0, 0, err
+		}
+		return row.TimestampUnixNano, uint64(dataSize - row.Offset), nil
+	})
+	if err != nil || cptToRemove == 0 {
+		return 0, err
+	}
+
+	// offset of the first record to keep, the segment holding it is the new head segment
+	firstRow := streamIndexRowMsg{}
+	baseOffset := dataSize
+	head := len(view.segments) - 1
+	nextMsgId := lastRow.Id + 1
+	if cptToRemove < cptRows {
+		if err = view.readRow(cptToRemove, &firstRow); err != nil {
+			return 0, err
+		}
+		baseOffset = firstRow.Offset
+		head = view.locate(cptToRemove)
+		nextMsgId = firstRow.Id
+	}
+
+	segments := append([]*streamSegment{}, w.segments.Segments[head:]...)
+	obsolete := append([]*streamSegment{}, w.segments.Segments[:head]...)
+	var (
+		rewritten          *streamSegment
+		fileData           *os.File
+		fileIndex          *os.File
+		activeDataOffset   int64
+		activeSegmentCount int64
+	)
+	if fromRank := cptToRemove - view.segments[head].firstRank; fromRank > 0 {
+		if rewritten, err = w.rewriteSegmentTail(view, head, fromRank, baseOffset, nextMsgId); err != nil {
+			return 0, err
+		}
+		obsolete = append(obsolete, segments[0])
+		segments[0] = rewritten
+		activeDataOffset = dataSize - baseOffset
+		activeSegmentCount = cptRows - cptToRemove
+	}
+	removeRewritten := func() {
+		if rewritten != nil {
+			_ = os.Remove(w.segments.getFilePath(rewritten.DataFile))
+			_ = os.Remove(w.segments.getFilePath(rewritten.IndexFile))
+		}
+	}
+
+	activeRewritten := rewritten != nil && !rewritten.Sealed
+	if activeRewritten {
+		// the records are appended to the new files of the active segment
+		if fileData, err = os.OpenFile(w.segments.getFilePath(rewritten.DataFile), os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+			removeRewritten()
+			w.logTrimError("can't open data file", err)
+			return 0, err
+		}
+		if fileIndex, err = os.OpenFile(w.segments.getFilePath(rewritten.IndexFile), os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+			_ = fileData.Close()
+			removeRewritten()
+			w.logTrimError("can't open index file", err)
+			return 0, err
+		}
+	}
+
+	// the new manifest replaces the segments at once (the files it no longer lists are deleted afterward)
+	manifest := *w.segments
+	manifest.Generation++
+	manifest.Segments = segments
+	if err = manifest.save(); err != nil {
+		if activeRewritten {
+			_ = fileData.Close()
+			_ = fileIndex.Close()
+		}
+		removeRewritten()
+		w.logTrimError("can't save segment manifest", err)
+		return 0, err
+	}
+	w.segments = &manifest
+	if activeRewritten {
+		_ = w.fileData.Close()
+		_ = w.fileIndex.Close()
+		w.fileData = fileData
+		w.fileIndex = fileIndex
+		w.dataOffset = activeDataOffset
+		w.segmentCptRows = activeSegmentCount
+		w.segmentFirstDate = time.Time{}
+		if activeSegmentCount > 0 {
+			w.segmentFirstDate = time.Unix(0, firstRow.TimestampUnixNano)
+		}
+	}
+	for _, segment := range obsolete {
+		for _, filename := range []string{segment.DataFile, segment.IndexFile} {
+			if err := os.Remove(w.segments.getFilePath(filename)); err != nil {
+				w.logTrimError("can't delete segment file", err)
+			}
+		}
+	}
+
+	w.info.ReadableMessages.TrimHead(
+		types.Size64(cptToRemove),
+		types.Size64(baseOffset),
+		firstRow.Id,
+		time.Unix(0, firstRow.TimestampUnixNano),
+	)
+
+	w.logger.Info(
+		"Stream trimmed",
+		zap.String("topic", "stream"),
+		zap.String("method", "Trim"),
+		zap.String("stream.uuid", w.info.UUID.String()),
+		zap.Int64("records.removed", cptToRemove),
+		zap.Int64("bytes.removed", baseOffset),
+		zap.Int("segments.removed", len(obsolete)),
+	)
+	// a sealed head segment rewritten is not compressed
+	w.startSegmentCompression()
+
+	return types.Size64(cptToRemove), w.SaveFileMetaInfo()
+}
+
+func (w *StreamWriterFile) rewriteSegmentTail(view *segmentIndexView, position int, fromRank int64, fromOffset int64, firstMsgId types.MessageId) (*streamSegment, error) {
+	// copy the records of the segment from the given rank into new files named after the first message id kept
+	// (all the records are removed when the rank is the count of records of the segment)
+	segment := w.segments.Segments[position]
+	indexSegment := &view.segments[position]
+	rewritten := &streamSegment{
+		// a sealed segment rewritten is compressed again afterward
+		DataFile:  getSegmentFilename(filepath.Base(w.fileDataPath), firstMsgId),
+		IndexFile: getSegmentFilename(filepath.Base(w.fileIndexPath), firstMsgId),
+		Sealed:    segment.Sealed,
+	}
+
+	// the offsets of the data file of the segment
+	var lastRow streamIndexRowMsg
+	if err := view.readRow(indexSegment.firstRank+indexSegment.cptRows-1, &lastRow); err != nil {
+		return nil, err
+	}
+	dataSize := lastRow.Offset + int64(lastRow.LengthInBytes) - indexSegment.baseOffset
+	baseOffset := min(fromOffset-indexSegment.baseOffset, dataSize)
+	if segment.Sealed {
+		rewritten.CptMessages = indexSegment.cptRows - fromRank
+		rewritten.SizeInBytes = dataSize - baseOffset
+	}
+
+	dataPath := w.segments.getFilePath(rewritten.DataFile)
+	indexPath := w.segments.getFilePath(rewritten.IndexFile)
+	if err := copyDataFileTail(w.segments, segment, dataPath, baseOffset, dataSize); err != nil {
+		_ = os.Remove(dataPath)
+		return nil, err
+	}
+	fileIndex, err := view.getFile(position)
+	if err == nil {
+		err = copyIndexFileTail(fileIndex, indexPath, fromRank, indexSegment.cptRows, baseOffset)
+	}
+	if err != nil {
+		_ = os.Remove(dataPath)
+		_ = os.Remove(indexPath)
+		return nil, err
+	}
+	return rewritten, nil
+}
+
+func copyDataFileTail(m *streamSegmentManifest, segment *streamSegment, dstPath string, fromOffset int64, toOffset int64) error {
+	// the records copied are not compressed
+	src, err := m.openDataFile(segment)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	return copyFileSection(src, dstPath, fromOffset, toOffset-fromOffset)
+}
+
+func copyFileSection(src io.ReaderAt, dstPath string, offset int64, length int64) error {
+	// copy length bytes of src starting at offset into a new file (synced to the disk)
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(dst, io.NewSectionReader(src, offset, length)); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = dst.Sync(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+func copyIndexFileTail(src *os.File, dstPath string, fromRank int64, toRank int64, baseOffset int64) error {
+	// copy the index rows, the offsets are shifted because the data file now starts at baseOffset
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(src, fromRank*sizeOfStreamIndexRowMsg, (toRank-fromRank)*sizeOfStreamIndexRowMsg))
+	writer := bufio.NewWriter(dst)
+	row := streamIndexRowMsg{}
+	for rank := fromRank; rank < toRank; rank++ {
+		if err = binary.Read(reader, binary.LittleEndian, &row); err != nil {
+			_ = dst.Close()
+			return err
+		}
+		row.Offset -= baseOffset
+		if err = binary.Write(writer, binary.LittleEndian, row); err != nil {
+			_ = dst.Close()
+			return err
+		}
+	}
+
+	if err = writer.Flush(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = dst.Sync(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+func readIndexRowAt(file *os.File, rank int64, row *streamIndexRowMsg) error {
+	return binary.Read(io.NewSectionReader(file, rank*sizeOfStreamIndexRowMsg, sizeOfStreamIndexRowMsg), binary.LittleEndian, row)
+}
+
+func (w *StreamWriterFile) logTrimError(msg string, err error) {
+	w.logger.Error(
+		msg,
+		zap.String("topic", "stream"),
+		zap.String("method", "Trim"),
+		zap.String("stream.uuid", w.info.UUID.String()),
+		zap.Error(err),
+	)
+}
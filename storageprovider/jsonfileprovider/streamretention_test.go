@@ -0,0 +1,99 @@
+package jsonfileprovider
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestTrimStreamFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := zap.NewExample()
+	info := types.NewStreamInfo(uuid.New())
+	dataPath := filepath.Join(tmpDir, "data.jsonl")
+	indexPath := filepath.Join(tmpDir, "index.bin")
+	w := NewStreamWriterFile(info, dataPath, indexPath, filepath.Join(tmpDir, "meta.json"), logger, 0)
+	if err := w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+	if err := w.Open(); err != nil {
+		t.Fatalf("could not open stream writer: %v", err)
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+
+	now := time.Now()
+	records := make([]types.DeferedStreamRecord, 0, 10)
+	for i := 1; i <= 10; i++ {
+		records = append(records, types.DeferedStreamRecord{Id: types.MessageId(i), CreationDate: now, Msg: map[string]interface{}{"n": i}})
+	}
+	if err := w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+
+	// an iterator reading the stream while it is trimmed
+	it := NewStreamIteratorHandlerFile(info.UUID, uuid.New(), dataPath, NewStreamIndex(info.UUID, indexPath, logger), logger)
+	if err := it.Open(); err != nil {
+		t.Fatalf("could not open iterator: %v", err)
+	}
+	defer func() {
+		_ = it.Close()
+	}()
+	req := types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}
+	if err := it.Seek(&req); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	if msgId, _, _, _, err := it.GetNextRecord(); err != nil || msgId != 1 {
+		t.Fatalf("expected message 1, got %d (%v)", msgId, err)
+	}
+	if err := it.SaveSeek(); err != nil {
+		t.Fatalf("could not save iterator position: %v", err)
+	}
+
+	cptRemoved, err := w.Trim(&types.RetentionPolicy{MaxRecords: 4}, now)
+	if err != nil {
+		t.Fatalf("could not trim stream: %v", err)
+	}
+	if cptRemoved != 6 {
+		t.Fatalf("expected 6 records removed, got %d", cptRemoved)
+	}
+	if info.ReadableMessages.CptMessages != 4 || info.ReadableMessages.FirstMsgId != 7 || info.ReadableMessages.LastMsgId != 10 {
+		t.Fatalf("unexpected readable messages %+v", info.ReadableMessages)
+	}
+
+	// the policy is already satisfied
+	if cptRemoved, err = w.Trim(&types.RetentionPolicy{MaxRecords: 4}, now); err != nil || cptRemoved != 0 {
+		t.Fatalf("expected no record removed, got %d (%v)", cptRemoved, err)
+	}
+
+	// the next record read by the iterator was removed, it moves to the head of the stream
+	if err = it.Seek(&req); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	if msgId, _, _, _, err := it.GetNextRecord(); err != nil || msgId != 7 {
+		t.Fatalf("expected message 7, got %d (%v)", msgId, err)
+	}
+
+	// new records are appended after the remaining ones
+	records = []types.DeferedStreamRecord{{Id: 11, CreationDate: now, Msg: map[string]interface{}{"n": 11}}}
+	if err = w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+	index := NewStreamIndex(info.UUID, indexPath, logger)
+	defer func() {
+		_ = index.Close()
+	}()
+	msgId, offset, err := index.GetOffsetAtMessageId(11)
+	if err != nil || msgId != 11 {
+		t.Fatalf("expected message 11 in index, got %d (%v)", msgId, err)
+	}
+	if types.Size64(offset) >= info.ReadableMessages.SizeInBytes {
+		t.Fatalf("unexpected offset %d for message 11 (stream size %d)", offset, info.ReadableMessages.SizeInBytes)
+	}
+}
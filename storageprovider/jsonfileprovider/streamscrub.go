@@ -0,0 +1,102 @@
+package jsonfileprovider
+
+import (
+	"fmt"
+
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+// Count of index rows read at once by the scrub of a stream
+const ScrubReadBatchSize = 1000
+
+func (w *StreamWriterFile) Scrub() (*types.StreamScrubReport, error) {
+	// Verify every record of the stream with its index row (checksum, or json format and message id).
+	// The writer is locked a segment at a time, therefore a segment can't be sealed, compressed, trimmed or
+	// rewritten while it is verified (the segments are looked up by message id, a new generation is followed).
+	report := types.NewStreamScrubReport()
+	var nextMsgId types.MessageId
+	for {
+		lastMsgId, done, err := w.scrubSegment(nextMsgId, report)
+		if err != nil {
+			w.logger.Error(
+				"Can't scrub stream",
+				zap.String("topic", "stream"),
+				zap.String("method", "Scrub"),
+				zap.String("stream.uuid", w.info.UUID.String()),
+				zap.Uint64("msgId", nextMsgId),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+		if done {
+			break
+		}
+		nextMsgId = lastMsgId + 1
+	}
+
+	if report.CptCorrupted > 0 {
+		w.logger.Warn(
+			"Corrupted records found",
+			zap.String("topic", "stream"),
+			zap.String("method", "Scrub"),
+			zap.String("stream.uuid", w.info.UUID.String()),
+			zap.Int64("cptRecords", report.CptRecords),
+			zap.Int64("cptCorrupted", report.CptCorrupted),
+		)
+	}
+	return report, nil
+}
+
+func (w *StreamWriterFile) scrubSegment(fromMsgId types.MessageId, report *types.StreamScrubReport) (types.MessageId, bool, error) {
+	// verify the records of the segment holding the first record having an id greater or equal to the given message id,
+	// returns the last message id of the segment and true if it is the last segment
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.state != STREAM_WRITER_FILE_STATE_OPENED {
+		return 0, true, fmt.Errorf("cannot scrub stream writer file because it's not opened")
+	}
+
+	view, err := openSegmentIndexView(w.segments)
+	if err != nil {
+		return 0, true, err
+	}
+	defer view.close()
+	data := newSegmentDataReader(w.segments)
+	defer func() {
+		_ = data.Close()
+	}()
+
+	rank, err := view.searchRank(func(row *streamIndexRowMsg) bool { return row.Id >= fromMsgId })
+	if err != nil {
+		return 0, true, err
+	}
+	if rank == view.cptRows {
+		return 0, true, nil
+	}
+	position := view.locate(rank)
+	indexSegment := &view.segments[position]
+	endRank := indexSegment.firstRank + indexSegment.cptRows
+
+	var lastMsgId types.MessageId
+	for ; rank < endRank; rank += ScrubReadBatchSize {
+		rows, err := view.readRows(rank, min(ScrubReadBatchSize, endRank-rank))
+		if err != nil {
+			return 0, true, err
+		}
+		for i := range rows {
+			row := &rows[i]
+			report.CptRecords++
+			if row.hasChecksum() {
+				report.CptChecksums++
+			}
+			if _, err = readRecordAtIndexPosition(data, row); err != nil {
+				report.AddCorrupted(row.Id)
+			}
+			lastMsgId = row.Id
+		}
+	}
+	return lastMsgId, position == len(view.segments)-1, nil
+}
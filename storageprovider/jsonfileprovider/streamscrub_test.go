@@ -0,0 +1,95 @@
+package jsonfileprovider
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestScrubWhileWriting(t *testing.T) {
+	logger := zap.NewNop()
+	s := &FileStorage{logger: logger, dataDirectory: t.TempDir()}
+	info := types.NewStreamInfo(uuid.New())
+	if err := s.CreateStreamDirectory(info.UUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+
+	// a segment holds 10 records, the sealed segments are compressed
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	newRecord := func(msgId types.MessageId) types.DeferedStreamRecord {
+		return types.DeferedStreamRecord{Id: msgId, CreationDate: start.Add(time.Duration(msgId) * time.Second), Msg: map[string]interface{}{"n": msgId}}
+	}
+	bytes, err := json.Marshal(newRecord(100))
+	if err != nil {
+		t.Fatalf("could not marshal record: %v", err)
+	}
+	recordSize := int64(len(bytes) + 1)
+	w := NewStreamWriterFile(info, s.GetStreamDataFilePath(info.UUID), s.GetStreamIndexFilePath(info.UUID), s.GetMetaDataFilePath(info.UUID), logger, 0, WithSegmentRolling(10*recordSize, 0), WithSegmentCompression(SegmentCompressionZstd, 4*recordSize))
+	if err := w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+	if err := w.Open(); err != nil {
+		t.Fatalf("could not open stream writer: %v", err)
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+
+	// the records are written by batches of 3 while the stream is scrubbed:
+	// a scrub verifies the records written before it ends, none of them is reported as corrupted
+	const cptBatches = 100
+	var cptWritten atomic.Int64
+	writeErrors := make(chan error, 1)
+	go func() {
+		defer close(writeErrors)
+		for batch := range cptBatches {
+			records := make([]types.DeferedStreamRecord, 0, 3)
+			for i := 1; i <= 3; i++ {
+				records = append(records, newRecord(types.MessageId(3*batch+i)))
+			}
+			if err := w.Write(&records); err != nil {
+				writeErrors <- err
+				return
+			}
+			cptWritten.Add(3)
+		}
+	}()
+
+	var previousCptRecords int64
+	for done := false; !done; {
+		select {
+		case err, running := <-writeErrors:
+			if err != nil {
+				t.Fatalf("could not write records: %v", err)
+			}
+			done = !running
+		default:
+		}
+		cptWrittenBefore := cptWritten.Load()
+		report, err := w.Scrub()
+		if err != nil {
+			t.Fatalf("could not scrub stream: %v", err)
+		}
+		if report.CptCorrupted != 0 || report.CptRecords != report.CptChecksums {
+			t.Fatalf("unexpected scrub report %+v", report)
+		}
+		// the batch being written may be verified before it is counted
+		cptWrittenAfter := cptWritten.Load() + 3
+		if report.CptRecords < cptWrittenBefore || report.CptRecords > cptWrittenAfter || report.CptRecords < previousCptRecords {
+			t.Fatalf("scrub verified %d records, expected between %d and %d", report.CptRecords, max(cptWrittenBefore, previousCptRecords), cptWrittenAfter)
+		}
+		previousCptRecords = report.CptRecords
+	}
+	if previousCptRecords != 3*cptBatches {
+		t.Fatalf("expected the last scrub to verify %d records, got %d", 3*cptBatches, previousCptRecords)
+	}
+	if len(w.segments.Segments) < 2 || !w.segments.Segments[0].Sealed {
+		t.Fatalf("expected sealed segments, got %+v", w.segments.Segments)
+	}
+}
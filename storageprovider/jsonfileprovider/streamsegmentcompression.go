@@ -0,0 +1,464 @@
+package jsonfileprovider
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+// The data file of a sealed segment can be compressed, the records are compressed by blocks (a block holds whole records)
+// and the table of the blocks is written at the end of the file. A record is read by decompressing its block only,
+// the offsets of the records are the offsets in the uncompressed data file (the index files are unchanged).
+//
+// Compressed data file: <block>...<block> [zstd skippable frame header] <block table> <trailer>
+// The block table holds the offset of each block in the uncompressed and in the compressed data, followed by
+// the end of the data. A zstd data file is a valid zstd file (the block table is a skippable frame).
+
+const (
+	SegmentCompressionNone = "none"
+	SegmentCompressionGzip = "gzip"
+	SegmentCompressionZstd = "zstd"
+)
+
+// Count of uncompressed bytes compressed together when no block size is configured
+const DefaultSegmentCompressionBlockSize = 256 * 1024
+
+var errSegmentCompressionCanceled = errors.New("segment compression canceled")
+
+var segmentBlocksMagic = [8]byte{'M', 'S', 'B', 'L', 'O', 'C', 'K', 'S'}
+
+const zstdSkippableFrameMagic uint32 = 0x184D2A5E
+
+type segmentBlock struct {
+	Offset           int64 // offset of the block in the uncompressed data
+	CompressedOffset int64 // offset of the block in the compressed file
+}
+
+type segmentBlocksTrailer struct {
+	CptBlocks int64 // count of rows of the block table (the last row is the end of the data)
+	Magic     [8]byte
+}
+
+const sizeOfSegmentBlock int64 = 2 * 8
+const sizeOfSegmentBlocksTrailer int64 = 2 * 8
+
+var getZstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil)
+})
+
+var getZstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil)
+})
+
+func ParseSegmentCompression(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "", SegmentCompressionNone:
+		return SegmentCompressionNone, nil
+	case SegmentCompressionGzip:
+		return SegmentCompressionGzip, nil
+	case SegmentCompressionZstd:
+		return SegmentCompressionZstd, nil
+	}
+	return "", fmt.Errorf("invalid segment compression %q (none, gzip or zstd)", value)
+}
+
+func getCompressedFilename(filename string, compression string) string {
+	switch compression {
+	case SegmentCompressionGzip:
+		return filename + ".gz"
+	case SegmentCompressionZstd:
+		return filename + ".zst"
+	}
+	return filename
+}
+
+func getUncompressedFilename(filename string, compression string) string {
+	return strings.TrimSuffix(filename, getCompressedFilename("", compression))
+}
+
+func compressBlock(compression string, data []byte) ([]byte, error) {
+	switch compression {
+	case SegmentCompressionGzip:
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case SegmentCompressionZstd:
+		encoder, err := getZstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(data, nil), nil
+	}
+	return nil, fmt.Errorf("invalid segment compression %q", compression)
+}
+
+func decompressBlock(compression string, block []byte, size int64) ([]byte, error) {
+	data := make([]byte, 0, size)
+	switch compression {
+	case SegmentCompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(block))
+		if err != nil {
+			return nil, err
+		}
+		data = data[:size]
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data, reader.Close()
+	case SegmentCompressionZstd:
+		decoder, err := getZstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		if data, err = decoder.DecodeAll(block, data); err != nil {
+			return nil, err
+		}
+		if int64(len(data)) != size {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("invalid segment compression %q", compression)
+}
+
+func compressSegmentDataFile(srcPath string, dstPath string, compression string, blockSize int64, canceled *atomic.Bool) error {
+	// write the compressed copy of the data file of a sealed segment (synced to the disk)
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = writeCompressedBlocks(bufio.NewReaderSize(src, 1024*1024), dst, compression, blockSize, canceled)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if errClose := dst.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(dstPath)
+	}
+	return err
+}
+
+func writeCompressedBlocks(src *bufio.Reader, dst io.Writer, compression string, blockSize int64, canceled *atomic.Bool) error {
+	writer := bufio.NewWriter(dst)
+	blocks := make([]segmentBlock, 0)
+	var offset, compressedOffset int64
+	block := make([]byte, 0, blockSize)
+	flush := func() error {
+		if len(block) == 0 {
+			return nil
+		}
+		if canceled != nil && canceled.Load() {
+			return errSegmentCompressionCanceled
+		}
+		compressed, err := compressBlock(compression, block)
+		if err != nil {
+			return err
+		}
+		if _, err = writer.Write(compressed); err != nil {
+			return err
+		}
+		blocks = append(blocks, segmentBlock{Offset: offset, CompressedOffset: compressedOffset})
+		offset += int64(len(block))
+		compressedOffset += int64(len(compressed))
+		block = block[:0]
+		return nil
+	}
+
+	// a block holds whole records (a record larger than the block size is a block)
+	recordStart := true
+	for {
+		line, err := src.ReadSlice(EOLChar)
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			return err
+		}
+		if recordStart && len(block) > 0 && int64(len(block)+len(line)) > blockSize {
+			if errFlush := flush(); errFlush != nil {
+				return errFlush
+			}
+		}
+		block = append(block, line...)
+		if err == io.EOF {
+			break
+		}
+		recordStart = err == nil
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	blocks = append(blocks, segmentBlock{Offset: offset, CompressedOffset: compressedOffset})
+
+	trailer := segmentBlocksTrailer{CptBlocks: int64(len(blocks)), Magic: segmentBlocksMagic}
+	if compression == SegmentCompressionZstd {
+		frameSize := uint32(int64(len(blocks))*sizeOfSegmentBlock + sizeOfSegmentBlocksTrailer)
+		if err := binary.Write(writer, binary.LittleEndian, [2]uint32{zstdSkippableFrameMagic, frameSize}); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(writer, binary.LittleEndian, blocks); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, binary.LittleEndian, trailer); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+type segmentDataFile interface {
+	// the data file of a segment, read with the offsets of the uncompressed data
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+func openSegmentDataFile(path string, compression string) (segmentDataFile, error) {
+	if compression == "" || compression == SegmentCompressionNone {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+	return openCompressedSegmentFile(path, compression)
+}
+
+type compressedSegmentFile struct {
+	file        *os.File
+	compression string
+	blocks      []segmentBlock
+	offset      int64 // offset of the next byte read sequentially
+	cachedBlock int   // the last block decompressed (-1 if none)
+	cachedData  []byte
+}
+
+func openCompressedSegmentFile(path string, compression string) (*compressedSegmentFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &compressedSegmentFile{file: file, compression: compression, cachedBlock: -1}
+	if err = f.readBlockTable(); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("invalid compressed segment %s: %w", path, err)
+	}
+	return f, nil
+}
+
+func (f *compressedSegmentFile) readBlockTable() error {
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < sizeOfSegmentBlocksTrailer {
+		return io.ErrUnexpectedEOF
+	}
+	trailer := segmentBlocksTrailer{}
+	if err = binary.Read(io.NewSectionReader(f.file, info.Size()-sizeOfSegmentBlocksTrailer, sizeOfSegmentBlocksTrailer), binary.LittleEndian, &trailer); err != nil {
+		return err
+	}
+	tableSize := trailer.CptBlocks * sizeOfSegmentBlock
+	if trailer.Magic != segmentBlocksMagic || trailer.CptBlocks < 1 || tableSize > info.Size()-sizeOfSegmentBlocksTrailer {
+		return errors.New("block table not found")
+	}
+	f.blocks = make([]segmentBlock, trailer.CptBlocks)
+	return binary.Read(io.NewSectionReader(f.file, info.Size()-sizeOfSegmentBlocksTrailer-tableSize, tableSize), binary.LittleEndian, f.blocks)
+}
+
+func (f *compressedSegmentFile) size() int64 {
+	return f.blocks[len(f.blocks)-1].Offset
+}
+
+func (f *compressedSegmentFile) readBlock(position int) ([]byte, error) {
+	if position == f.cachedBlock {
+		return f.cachedData, nil
+	}
+	block, next := f.blocks[position], f.blocks[position+1]
+	compressed := make([]byte, next.CompressedOffset-block.CompressedOffset)
+	if _, err := f.file.ReadAt(compressed, block.CompressedOffset); err != nil {
+		return nil, err
+	}
+	data, err := decompressBlock(f.compression, compressed, next.Offset-block.Offset)
+	if err != nil {
+		return nil, err
+	}
+	f.cachedBlock = position
+	f.cachedData = data
+	return data, nil
+}
+
+func (f *compressedSegmentFile) ReadAt(p []byte, offset int64) (int, error) {
+	read := 0
+	for read < len(p) {
+		current := offset + int64(read)
+		if current >= f.size() {
+			return read, io.EOF
+		}
+		// the last row of the block table is the end of the data
+		position := sort.Search(len(f.blocks)-1, func(i int) bool { return f.blocks[i+1].Offset > current })
+		data, err := f.readBlock(position)
+		if err != nil {
+			return read, err
+		}
+		read += copy(p[read:], data[current-f.blocks[position].Offset:])
+	}
+	return read, nil
+}
+
+func (f *compressedSegmentFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *compressedSegmentFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *compressedSegmentFile) Close() error {
+	f.cachedData = nil
+	return f.file.Close()
+}
+
+func (w *StreamWriterFile) startSegmentCompression() {
+	// start compressing the sealed segments in the background (the writer must be locked)
+	if w.segmentCompression == "" || w.segmentCompression == SegmentCompressionNone || w.compressing {
+		return
+	}
+	w.compressing = true
+	w.compressionDone.Add(1)
+	go w.compressSegments()
+}
+
+func (w *StreamWriterFile) compressSegments() {
+	defer w.compressionDone.Done()
+	for {
+		w.mu.Lock()
+		var segment *streamSegment
+		for _, s := range w.segments.Segments {
+			if s.Sealed && s.Compression == "" {
+				segment = s
+				break
+			}
+		}
+		if segment == nil || w.state != STREAM_WRITER_FILE_STATE_OPENED {
+			w.compressing = false
+			w.mu.Unlock()
+			return
+		}
+		filename := segment.DataFile
+		srcPath := w.segments.getFilePath(filename)
+		w.mu.Unlock()
+
+		if err := w.compressSegment(filename, srcPath); err != nil {
+			if !errors.Is(err, errSegmentCompressionCanceled) {
+				w.logger.Error(
+					"can't compress segment",
+					zap.String("topic", "stream"),
+					zap.String("method", "compressSegments"),
+					zap.String("stream.uuid", w.info.UUID.String()),
+					zap.String("segment", filename),
+					zap.Error(err),
+				)
+			}
+			// the segment is compressed again when the next segment is sealed
+			w.mu.Lock()
+			w.compressing = false
+			w.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (w *StreamWriterFile) compressSegment(filename string, srcPath string) error {
+	// the compressed file replaces the data file of the segment when the manifest is saved,
+	// the readers which loaded the previous manifest keep reading the data file already opened
+	compressedFilename := getCompressedFilename(filename, w.segmentCompression)
+	dstPath := getCompressedFilename(srcPath, w.segmentCompression)
+	if err := compressSegmentDataFile(srcPath, dstPath, w.segmentCompression, w.compressionBlockSize, &w.compressionCanceled); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// the segment may have been deleted or rewritten by the retention policy meanwhile
+	var segment *streamSegment
+	for _, s := range w.segments.Segments {
+		if s.DataFile == filename && s.Sealed {
+			segment = s
+			break
+		}
+	}
+	if segment == nil {
+		_ = os.Remove(dstPath)
+		return nil
+	}
+	segment.DataFile = compressedFilename
+	segment.Compression = w.segmentCompression
+	if err := w.segments.save(); err != nil {
+		segment.DataFile = filename
+		segment.Compression = ""
+		_ = os.Remove(dstPath)
+		return err
+	}
+	if err := os.Remove(srcPath); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(dstPath); err == nil {
+		w.logger.Info(
+			"Stream segment compressed",
+			zap.String("topic", "stream"),
+			zap.String("method", "compressSegment"),
+			zap.String("stream.uuid", w.info.UUID.String()),
+			zap.String("segment", compressedFilename),
+			zap.Int64("segment.bytes", segment.SizeInBytes),
+			zap.Int64("segment.compressedBytes", info.Size()),
+		)
+	}
+	return nil
+}
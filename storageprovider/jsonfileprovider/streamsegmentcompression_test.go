@@ -0,0 +1,218 @@
+package jsonfileprovider
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestCompressedSegmentDataFile(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "data.jsonl")
+	var data strings.Builder
+	for i := 0; i < 100; i++ {
+		data.WriteString(fmt.Sprintf("{\"i\":%d,\"m\":\"%s\"}\n", i, strings.Repeat("x", i)))
+	}
+	if err := os.WriteFile(srcPath, []byte(data.String()), 0644); err != nil {
+		t.Fatalf("could not write data file: %v", err)
+	}
+
+	for _, compression := range []string{SegmentCompressionGzip, SegmentCompressionZstd} {
+		dstPath := getCompressedFilename(srcPath, compression)
+		if err := compressSegmentDataFile(srcPath, dstPath, compression, 256, nil); err != nil {
+			t.Fatalf("%s: could not compress data file: %v", compression, err)
+		}
+		file, err := openSegmentDataFile(dstPath, compression)
+		if err != nil {
+			t.Fatalf("%s: could not open compressed data file: %v", compression, err)
+		}
+		if blocks := file.(*compressedSegmentFile).blocks; len(blocks) < 10 {
+			t.Fatalf("%s: expected several blocks, got %d", compression, len(blocks)-1)
+		}
+
+		// a range spanning several blocks
+		expected := data.String()[1000:3000]
+		buffer := make([]byte, len(expected))
+		if _, err = file.ReadAt(buffer, 1000); err != nil || string(buffer) != expected {
+			t.Fatalf("%s: unexpected range (%v)", compression, err)
+		}
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("%s: could not seek: %v", compression, err)
+		}
+		all, err := io.ReadAll(file)
+		if err != nil || !bytes.Equal(all, []byte(data.String())) {
+			t.Fatalf("%s: unexpected data (%v)", compression, err)
+		}
+		_ = file.Close()
+	}
+}
+
+func TestCompressedSegments(t *testing.T) {
+	logger := zap.NewNop()
+	s := &FileStorage{logger: logger, dataDirectory: t.TempDir()}
+	info := types.NewStreamInfo(uuid.New())
+	if err := s.CreateStreamDirectory(info.UUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+	dataPath := s.GetStreamDataFilePath(info.UUID)
+	indexPath := s.GetStreamIndexFilePath(info.UUID)
+	directory := s.GetStreamDirectoryPath(info.UUID)
+
+	// a segment holds 3 records, a block holds 2 records
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	newRecord := func(msgId types.MessageId) types.DeferedStreamRecord {
+		return types.DeferedStreamRecord{Id: msgId, CreationDate: start.Add(time.Duration(msgId) * time.Second), Msg: map[string]interface{}{"n": msgId}}
+	}
+	bytes, err := json.Marshal(newRecord(10))
+	if err != nil {
+		t.Fatalf("could not marshal record: %v", err)
+	}
+	recordSize := int64(len(bytes) + 1)
+	w := NewStreamWriterFile(info, dataPath, indexPath, s.GetMetaDataFilePath(info.UUID), logger, 0, WithSegmentRolling(3*recordSize, 0), WithSegmentCompression(SegmentCompressionZstd, 2*recordSize))
+	if err := w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+	if err := w.Open(); err != nil {
+		t.Fatalf("could not open stream writer: %v", err)
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+	write := func(firstMsgId types.MessageId, lastMsgId types.MessageId) {
+		records := make([]types.DeferedStreamRecord, 0)
+		for i := firstMsgId; i <= lastMsgId; i++ {
+			records = append(records, newRecord(i))
+		}
+		if err := w.Write(&records); err != nil {
+			t.Fatalf("could not write records: %v", err)
+		}
+		w.compressionDone.Wait()
+	}
+	expectCompressed := func(m *streamSegmentManifest) {
+		for i, segment := range m.Segments {
+			compressed := segment.Compression == SegmentCompressionZstd && strings.HasSuffix(segment.DataFile, ".zst")
+			if compressed != segment.Sealed {
+				t.Fatalf("unexpected segment %d %+v", i, segment)
+			}
+			if _, err := os.Stat(m.getFilePath(segment.DataFile)); err != nil {
+				t.Fatalf("segment %d: %v", i, err)
+			}
+			if _, err := os.Stat(m.getFilePath(getUncompressedFilename(segment.DataFile, segment.Compression))); compressed && !os.IsNotExist(err) {
+				t.Fatalf("expected the uncompressed data file of segment %d to be deleted (%v)", i, err)
+			}
+		}
+	}
+	expectRecords := func(dataPath string, indexPath string, req *types.StreamIteratorRequest, firstMsgId types.MessageId, lastMsgId types.MessageId) {
+		it := NewStreamIteratorHandlerFile(info.UUID, uuid.New(), dataPath, NewStreamIndex(info.UUID, indexPath, logger), logger)
+		defer func() {
+			_ = it.Close()
+		}()
+		if err := it.Open(); err != nil {
+			t.Fatalf("could not open iterator: %v", err)
+		}
+		if err := it.Seek(req); err != nil {
+			t.Fatalf("could not seek iterator: %v", err)
+		}
+		step := types.MessageId(1)
+		if firstMsgId > lastMsgId {
+			step = ^types.MessageId(0)
+		}
+		for expectedId := firstMsgId; ; expectedId += step {
+			msgId, record, found, _, err := it.GetNextRecord()
+			if err != nil || !found || msgId != expectedId {
+				t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+			}
+			if n := record.(map[string]interface{})["m"].(map[string]interface{})["n"]; n != float64(expectedId) {
+				t.Fatalf("unexpected record %v for message %d", record, expectedId)
+			}
+			if expectedId == lastMsgId {
+				break
+			}
+		}
+		if msgId, _, found, _, err := it.GetNextRecord(); err != nil || found {
+			t.Fatalf("expected no more record, got %d (%v)", msgId, err)
+		}
+	}
+
+	// the sealed segments are compressed in the background
+	write(1, 20)
+	if len(w.segments.Segments) != 7 {
+		t.Fatalf("expected 7 segments, got %d", len(w.segments.Segments))
+	}
+	expectCompressed(w.segments)
+	saved, err := w.segments.reload()
+	if err != nil {
+		t.Fatalf("could not load segment manifest: %v", err)
+	}
+	expectCompressed(saved)
+
+	// the records are read from the compressed segments
+	expectRecords(dataPath, indexPath, &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}, 1, 20)
+	expectRecords(dataPath, indexPath, &types.StreamIteratorRequest{IteratorType: "LAST_MESSAGE", Direction: types.IteratorDirectionBackward}, 20, 1)
+	expectRecords(dataPath, indexPath, &types.StreamIteratorRequest{IteratorType: "AFTER_MESSAGE_ID", MessageId: 7}, 8, 20)
+	found, err := readRecordsByIds(dataPath, NewStreamIndex(info.UUID, indexPath, logger), []types.MessageId{2, 11, 20, 21}, nil)
+	if err != nil || found[0] == nil || found[1] == nil || found[2] == nil || found[3] != nil {
+		t.Fatalf("unexpected records %v (%v)", found, err)
+	}
+	stats, err := NewStreamIndex(info.UUID, indexPath, logger).BuildIndex(dataPath)
+	if err != nil || stats.CptMessages != 20 || types.Size64(stats.FileSize) != info.ReadableMessages.SizeInBytes {
+		t.Fatalf("unexpected index stats %+v (%v)", stats, err)
+	}
+
+	// the head segment rewritten by the retention policy is compressed again
+	cptRemoved, err := w.Trim(&types.RetentionPolicy{MaxRecords: 14}, time.Now())
+	if err != nil || cptRemoved != 6 {
+		t.Fatalf("expected 6 records removed, got %d (%v)", cptRemoved, err)
+	}
+	w.compressionDone.Wait()
+	if head := w.segments.Segments[0]; head.DataFile != "data.00000000000000000007.jsonl.zst" {
+		t.Fatalf("unexpected head segment %+v", head)
+	}
+	expectCompressed(w.segments)
+	expectRecords(dataPath, indexPath, &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}, 7, 20)
+	cptRemoved, err = w.Trim(&types.RetentionPolicy{MaxRecords: 12}, time.Now())
+	if err != nil || cptRemoved != 2 {
+		t.Fatalf("expected 2 records removed, got %d (%v)", cptRemoved, err)
+	}
+	w.compressionDone.Wait()
+	if head := w.segments.Segments[0]; head.DataFile != "data.00000000000000000009.jsonl.zst" || head.CptMessages != 1 {
+		t.Fatalf("unexpected head segment %+v", head)
+	}
+	expectCompressed(w.segments)
+	expectRecords(dataPath, indexPath, &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}, 9, 20)
+	if _, err = os.Stat(filepath.Join(directory, "data.00000000000000000007.jsonl.zst")); !os.IsNotExist(err) {
+		t.Fatalf("expected the previous head segment to be deleted (%v)", err)
+	}
+
+	// the compressed segments copied entirely stay compressed
+	target := types.NewStreamInfo(uuid.New())
+	if err = s.CreateStreamDirectory(target.UUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+	if err = s.CopyRecords(info.UUID, target, 17); err != nil {
+		t.Fatalf("could not copy records: %v", err)
+	}
+	if target.ReadableMessages.CptMessages != 9 || target.ReadableMessages.FirstMsgId != 9 || target.ReadableMessages.LastMsgId != 17 {
+		t.Fatalf("unexpected messages info %+v", target.ReadableMessages)
+	}
+	targetSegments, err := loadSegmentManifest(s.GetStreamDirectoryPath(target.UUID), filepath.Base(dataPath), filepath.Base(indexPath))
+	if err != nil {
+		t.Fatalf("could not load segment manifest: %v", err)
+	}
+	if len(targetSegments.Segments) != 4 || targetSegments.getActiveSegment().DataFile != "data.00000000000000000016.jsonl" {
+		t.Fatalf("unexpected segments %d", len(targetSegments.Segments))
+	}
+	expectCompressed(targetSegments)
+	expectRecords(s.GetStreamDataFilePath(target.UUID), s.GetStreamIndexFilePath(target.UUID), &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}, 9, 17)
+}
@@ -0,0 +1,191 @@
+package jsonfileprovider
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type segmentDataReader struct {
+	// reads the data files of the segments as a single file (logical offsets),
+	// the segments started after the manifest was loaded are read as well
+	manifest    *streamSegmentManifest
+	baseOffsets []int64
+	files       []segmentDataFile // opened on first read
+	current     int               // position of the segment read sequentially
+	offset      int64             // logical offset of the next byte read sequentially
+}
+
+func openSegmentDataReader(dataFilePath string, indexFilePath string) (*segmentDataReader, error) {
+	m, err := loadSegmentManifest(filepath.Dir(dataFilePath), filepath.Base(dataFilePath), filepath.Base(indexFilePath))
+	if err != nil {
+		return nil, err
+	}
+	return newSegmentDataReader(m), nil
+}
+
+func newSegmentDataReader(m *streamSegmentManifest) *segmentDataReader {
+	r := &segmentDataReader{}
+	r.setManifest(m)
+	return r
+}
+
+func (r *segmentDataReader) setManifest(m *streamSegmentManifest) {
+	// the segments of a generation are only appended (the previous active segment is sealed),
+	// the data file of a sealed segment is replaced by its compressed copy (same data)
+	r.manifest = m
+	r.baseOffsets = m.getBaseOffsets()
+	for len(r.files) < len(m.Segments) {
+		r.files = append(r.files, nil)
+	}
+}
+
+func (r *segmentDataReader) refresh() (bool, error) {
+	// load the segments started since the manifest was loaded,
+	// returns true if the stream was trimmed by the retention policy (the offsets changed)
+	m, err := r.manifest.reload()
+	if err != nil {
+		return false, err
+	}
+	if m.Generation != r.manifest.Generation {
+		return true, nil
+	}
+	r.setManifest(m)
+	return false, nil
+}
+
+func (r *segmentDataReader) Close() error {
+	for i, file := range r.files {
+		if file != nil {
+			_ = file.Close()
+			r.files[i] = nil
+		}
+	}
+	return nil
+}
+
+func (r *segmentDataReader) locate(offset int64) int {
+	// position of the segment holding the byte at the given logical offset
+	position := sort.Search(len(r.baseOffsets), func(i int) bool { return r.baseOffsets[i] > offset }) - 1
+	return max(position, 0)
+}
+
+func (r *segmentDataReader) getFile(position int) (segmentDataFile, error) {
+	if r.files[position] == nil {
+		file, err := r.manifest.openDataFile(r.manifest.Segments[position])
+		if errors.Is(err, os.ErrNotExist) {
+			// the segment may have been compressed since the manifest was loaded
+			if trimmed, errRefresh := r.refresh(); errRefresh == nil && !trimmed {
+				file, err = r.manifest.openDataFile(r.manifest.Segments[position])
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.files[position] = file
+	}
+	return r.files[position], nil
+}
+
+func (r *segmentDataReader) isLastSegment(position int) bool {
+	return position == len(r.manifest.Segments)-1
+}
+
+func (r *segmentDataReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	position := r.locate(offset)
+	file, err := r.getFile(position)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = file.Seek(offset-r.baseOffsets[position], io.SeekStart); err != nil {
+		return 0, err
+	}
+	r.current = position
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *segmentDataReader) Read(p []byte) (int, error) {
+	for {
+		file, err := r.getFile(r.current)
+		if err != nil {
+			return 0, err
+		}
+		n, err := file.Read(p)
+		r.offset += int64(n)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+
+		if r.isLastSegment(r.current) {
+			// the segment may have been sealed and a new segment started
+			trimmed, err := r.refresh()
+			if err != nil {
+				return 0, err
+			}
+			if trimmed || r.isLastSegment(r.current) {
+				return 0, io.EOF
+			}
+			// read the records appended before the segment was sealed
+			continue
+		}
+
+		// end of a sealed segment, continue with the next segment
+		if r.offset != r.baseOffsets[r.current+1] {
+			return 0, io.ErrUnexpectedEOF
+		}
+		r.current++
+		if file, err = r.getFile(r.current); err != nil {
+			return 0, err
+		}
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (r *segmentDataReader) ReadAt(p []byte, offset int64) (int, error) {
+	read := 0
+	refreshed := false
+	for read < len(p) {
+		position := r.locate(offset + int64(read))
+		file, err := r.getFile(position)
+		if err != nil {
+			return read, err
+		}
+		chunk := p[read:]
+		if !r.isLastSegment(position) {
+			chunk = chunk[:min(int64(len(chunk)), r.baseOffsets[position+1]-offset-int64(read))]
+		}
+		n, err := file.ReadAt(chunk, offset+int64(read)-r.baseOffsets[position])
+		read += n
+		switch {
+		case n == len(chunk):
+		case err == io.EOF && r.isLastSegment(position) && !refreshed:
+			// the bytes may be in a segment started since the manifest was loaded
+			refreshed = true
+			trimmed, errRefresh := r.refresh()
+			if errRefresh != nil {
+				return read, errRefresh
+			}
+			if trimmed {
+				return read, io.EOF
+			}
+		case err != nil:
+			return read, err
+		default:
+			return read, io.ErrUnexpectedEOF
+		}
+	}
+	return read, nil
+}
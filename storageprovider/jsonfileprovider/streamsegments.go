@@ -0,0 +1,290 @@
+package jsonfileprovider
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+)
+
+// The records of a stream are written into segments, a segment is a data file and its index file.
+// The new records are appended to the last segment (the active segment), it is sealed and a new segment is started
+// when it reaches the maximum size or age of a segment. The segments of a stream are listed by its manifest.
+//
+// The offsets of the records (index rows, iterators) are logical offsets: a segment starts at the sum of the sizes
+// of the segments preceding it. The offsets change when the retention policy removes or rewrites the head segments,
+// the generation of the manifest is incremented then (the iterators move to the same message id).
+
+const segmentManifestFilename = "segments.json"
+
+type streamSegment struct {
+	// the file names are relative to the stream directory
+	DataFile    string `json:"data"`
+	IndexFile   string `json:"index"`
+	Sealed      bool   `json:"sealed"`                // no record is appended to a sealed segment
+	CptMessages int64  `json:"cptMessages,omitempty"` // count of records of a sealed segment
+	SizeInBytes int64  `json:"sizeInBytes,omitempty"` // size of the data of a sealed segment (uncompressed)
+	Compression string `json:"compression,omitempty"` // compression of the data file of a sealed segment (gzip or zstd)
+}
+
+type streamSegmentManifest struct {
+	Generation int64            `json:"generation"`
+	Segments   []*streamSegment `json:"segments"`
+	// a stream without manifest (written before the segments) has a single segment
+	directory     string
+	dataFilename  string
+	indexFilename string
+}
+
+func loadSegmentManifest(directory string, dataFilename string, indexFilename string) (*streamSegmentManifest, error) {
+	m := &streamSegmentManifest{directory: directory, dataFilename: dataFilename, indexFilename: indexFilename}
+	bytes, err := os.ReadFile(m.getPath())
+	if errors.Is(err, os.ErrNotExist) {
+		m.Segments = []*streamSegment{{DataFile: dataFilename, IndexFile: indexFilename}}
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(bytes, m); err != nil {
+		return nil, fmt.Errorf("invalid segment manifest %s: %w", m.getPath(), err)
+	}
+	if len(m.Segments) == 0 {
+		return nil, fmt.Errorf("invalid segment manifest %s: no segment", m.getPath())
+	}
+	return m, nil
+}
+
+func (m *streamSegmentManifest) reload() (*streamSegmentManifest, error) {
+	return loadSegmentManifest(m.directory, m.dataFilename, m.indexFilename)
+}
+
+func (m *streamSegmentManifest) exists() (bool, error) {
+	_, err := os.Stat(m.getPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *streamSegmentManifest) save() error {
+	// the manifest is replaced at once, after a crash the manifest is either the previous one or the new one
+	bytes, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := m.getPath() + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(bytes); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, m.getPath())
+}
+
+func (m *streamSegmentManifest) getPath() string {
+	return filepath.Join(m.directory, segmentManifestFilename)
+}
+
+func (m *streamSegmentManifest) getFilePath(filename string) string {
+	return filepath.Join(m.directory, filename)
+}
+
+func (m *streamSegmentManifest) getActiveSegment() *streamSegment {
+	return m.Segments[len(m.Segments)-1]
+}
+
+func (m *streamSegmentManifest) openDataFile(segment *streamSegment) (segmentDataFile, error) {
+	return openSegmentDataFile(m.getFilePath(segment.DataFile), segment.Compression)
+}
+
+func (m *streamSegmentManifest) getBaseOffsets() []int64 {
+	// logical offset of the first byte of each segment
+	baseOffsets := make([]int64, len(m.Segments))
+	for i := 1; i < len(m.Segments); i++ {
+		baseOffsets[i] = baseOffsets[i-1] + m.Segments[i-1].SizeInBytes
+	}
+	return baseOffsets
+}
+
+func getSegmentFilename(filename string, firstMsgId types.MessageId) string {
+	// the files of a segment are named after its first message id (ex: data.00000000000000001234.jsonl)
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s.%020d%s", strings.TrimSuffix(filename, ext), firstMsgId, ext)
+}
+
+type segmentIndexView struct {
+	// the index rows of all the segments, the rows are read with their logical offset
+	manifest *streamSegmentManifest
+	segments []segmentIndexFile
+	cptRows  int64
+}
+
+type segmentIndexFile struct {
+	filename   string
+	file       *os.File // opened on first read
+	firstRank  int64    // rank of the first row of the segment
+	cptRows    int64
+	baseOffset int64
+}
+
+func openSegmentIndexView(m *streamSegmentManifest) (*segmentIndexView, error) {
+	v := &segmentIndexView{manifest: m, segments: make([]segmentIndexFile, len(m.Segments))}
+	baseOffsets := m.getBaseOffsets()
+	for i, segment := range m.Segments {
+		filename := m.getFilePath(segment.IndexFile)
+		cptRows := segment.CptMessages
+		if !segment.Sealed {
+			info, err := os.Stat(filename)
+			switch {
+			case err == nil:
+				cptRows = info.Size() / sizeOfStreamIndexRowMsg
+			case errors.Is(err, os.ErrNotExist) && len(m.Segments) == 1:
+				// no record saved yet
+				cptRows = 0
+			default:
+				return nil, err
+			}
+		}
+		v.segments[i] = segmentIndexFile{filename: filename, firstRank: v.cptRows, cptRows: cptRows, baseOffset: baseOffsets[i]}
+		v.cptRows += cptRows
+	}
+	return v, nil
+}
+
+func (v *segmentIndexView) refresh() (bool, error) {
+	// load the rows written since the view was opened (the files already opened are kept),
+	// returns true if the segments were replaced (new generation of the manifest, the ranks changed)
+	m, err := v.manifest.reload()
+	if err != nil {
+		return false, err
+	}
+	if m.Generation != v.manifest.Generation {
+		return true, nil
+	}
+	refreshed, err := openSegmentIndexView(m)
+	if err != nil {
+		return false, err
+	}
+	// the segments of a generation are only appended
+	for i := range v.segments {
+		if i < len(refreshed.segments) && refreshed.segments[i].filename == v.segments[i].filename {
+			refreshed.segments[i].file = v.segments[i].file
+		} else if v.segments[i].file != nil {
+			_ = v.segments[i].file.Close()
+		}
+		v.segments[i].file = nil
+	}
+	*v = *refreshed
+	return false, nil
+}
+
+func (v *segmentIndexView) searchRank(match func(row *streamIndexRowMsg) bool) (int64, error) {
+	// use a dichotomy algorithm to find the rank of the first row matching (the rows after it match too),
+	// the rank is the count of rows if there is no such row
+	var (
+		err error
+		row streamIndexRowMsg
+	)
+	rank := sort.Search(int(v.cptRows), func(rank int) bool {
+		if err != nil {
+			return true
+		}
+		err = v.readRow(int64(rank), &row)
+		return err != nil || match(&row)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(rank), nil
+}
+
+func (v *segmentIndexView) close() {
+	for i := range v.segments {
+		if v.segments[i].file != nil {
+			_ = v.segments[i].file.Close()
+			v.segments[i].file = nil
+		}
+	}
+}
+
+func (v *segmentIndexView) locate(rank int64) int {
+	// position of the segment holding the row at the given rank
+	return sort.Search(len(v.segments), func(i int) bool {
+		return v.segments[i].firstRank+v.segments[i].cptRows > rank
+	})
+}
+
+func (v *segmentIndexView) getFile(position int) (*os.File, error) {
+	segment := &v.segments[position]
+	if segment.file == nil {
+		file, err := os.Open(segment.filename)
+		if err != nil {
+			return nil, err
+		}
+		segment.file = file
+	}
+	return segment.file, nil
+}
+
+func (v *segmentIndexView) readRow(rank int64, row *streamIndexRowMsg) error {
+	if rank < 0 || rank >= v.cptRows {
+		return fmt.Errorf("index rank %d out of range (%d rows)", rank, v.cptRows)
+	}
+	position := v.locate(rank)
+	file, err := v.getFile(position)
+	if err != nil {
+		return err
+	}
+	segment := &v.segments[position]
+	if err = readIndexRowAt(file, rank-segment.firstRank, row); err != nil {
+		return err
+	}
+	row.Offset += segment.baseOffset
+	return nil
+}
+
+func (v *segmentIndexView) readRows(rank int64, count int64) ([]streamIndexRowMsg, error) {
+	if rank < 0 || count < 0 || rank+count > v.cptRows {
+		return nil, fmt.Errorf("index rows %d to %d out of range (%d rows)", rank, rank+count, v.cptRows)
+	}
+	rows := make([]streamIndexRowMsg, count)
+	for read := int64(0); read < count; {
+		position := v.locate(rank + read)
+		file, err := v.getFile(position)
+		if err != nil {
+			return nil, err
+		}
+		segment := &v.segments[position]
+		localRank := rank + read - segment.firstRank
+		n := min(count-read, segment.cptRows-localRank)
+		section := io.NewSectionReader(file, localRank*sizeOfStreamIndexRowMsg, n*sizeOfStreamIndexRowMsg)
+		if err = binary.Read(section, binary.LittleEndian, rows[read:read+n]); err != nil {
+			return nil, err
+		}
+		for i := read; i < read+n; i++ {
+			rows[i].Offset += segment.baseOffset
+		}
+		read += n
+	}
+	return rows, nil
+}
@@ -0,0 +1,173 @@
+package jsonfileprovider
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestSegmentedStreamFile(t *testing.T) {
+	logger := zap.NewNop()
+	s := &FileStorage{logger: logger, dataDirectory: t.TempDir()}
+	info := types.NewStreamInfo(uuid.New())
+	if err := s.CreateStreamDirectory(info.UUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+	dataPath := s.GetStreamDataFilePath(info.UUID)
+	indexPath := s.GetStreamIndexFilePath(info.UUID)
+
+	// a segment holds 3 records
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	newRecord := func(msgId types.MessageId) types.DeferedStreamRecord {
+		return types.DeferedStreamRecord{Id: msgId, CreationDate: start.Add(time.Duration(msgId) * time.Second), Msg: map[string]interface{}{"n": msgId}}
+	}
+	bytes, err := json.Marshal(newRecord(10))
+	if err != nil {
+		t.Fatalf("could not marshal record: %v", err)
+	}
+	w := NewStreamWriterFile(info, dataPath, indexPath, s.GetMetaDataFilePath(info.UUID), logger, 0, WithSegmentRolling(3*int64(len(bytes)+1), 0))
+	if err := w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+	if err := w.Open(); err != nil {
+		t.Fatalf("could not open stream writer: %v", err)
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+	write := func(firstMsgId types.MessageId, lastMsgId types.MessageId) {
+		records := make([]types.DeferedStreamRecord, 0)
+		for i := firstMsgId; i <= lastMsgId; i++ {
+			records = append(records, newRecord(i))
+		}
+		if err := w.Write(&records); err != nil {
+			t.Fatalf("could not write records: %v", err)
+		}
+	}
+	write(1, 20)
+	if len(w.segments.Segments) != 7 {
+		t.Fatalf("expected 7 segments, got %d", len(w.segments.Segments))
+	}
+	if segment := w.segments.Segments[1]; !segment.Sealed || segment.CptMessages != 3 || segment.DataFile != "data.00000000000000000004.jsonl" {
+		t.Fatalf("unexpected segment %+v", segment)
+	}
+
+	newIterator := func(req *types.StreamIteratorRequest) *StreamIteratorHandlerFile {
+		it := NewStreamIteratorHandlerFile(info.UUID, uuid.New(), dataPath, NewStreamIndex(info.UUID, indexPath, logger), logger)
+		if err := it.Open(); err != nil {
+			t.Fatalf("could not open iterator: %v", err)
+		}
+		if err := it.Seek(req); err != nil {
+			t.Fatalf("could not seek iterator: %v", err)
+		}
+		return it
+	}
+	expectRecords := func(it *StreamIteratorHandlerFile, messageIds ...types.MessageId) {
+		for _, expectedId := range messageIds {
+			msgId, record, found, _, err := it.GetNextRecord()
+			if err != nil || !found || msgId != expectedId {
+				t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+			}
+			if n := record.(map[string]interface{})["m"].(map[string]interface{})["n"]; n != float64(expectedId) {
+				t.Fatalf("unexpected record %v for message %d", record, expectedId)
+			}
+		}
+		if msgId, _, found, _, err := it.GetNextRecord(); err != nil || found {
+			t.Fatalf("expected no more record, got %d (%v)", msgId, err)
+		}
+		if err := it.SaveSeek(); err != nil {
+			t.Fatalf("could not save iterator position: %v", err)
+		}
+	}
+	messageIds := func(firstMsgId types.MessageId, lastMsgId types.MessageId) []types.MessageId {
+		// in the reading order (backward if the first message id is the greatest)
+		ids := make([]types.MessageId, 0)
+		for i := min(firstMsgId, lastMsgId); i <= max(firstMsgId, lastMsgId); i++ {
+			ids = append(ids, i)
+		}
+		if firstMsgId > lastMsgId {
+			slices.Reverse(ids)
+		}
+		return ids
+	}
+
+	// the records are read across the segments
+	it := newIterator(&types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"})
+	defer func() {
+		_ = it.Close()
+	}()
+	expectRecords(it, messageIds(1, 20)...)
+	backward := newIterator(&types.StreamIteratorRequest{IteratorType: "LAST_MESSAGE", Direction: types.IteratorDirectionBackward})
+	expectRecords(backward, messageIds(20, 1)...)
+	_ = backward.Close()
+	middle := newIterator(&types.StreamIteratorRequest{IteratorType: "AFTER_MESSAGE_ID", MessageId: 6})
+	expectRecords(middle, messageIds(7, 20)...)
+	_ = middle.Close()
+	found, err := readRecordsByIds(dataPath, NewStreamIndex(info.UUID, indexPath, logger), []types.MessageId{1, 3, 4, 20, 21}, nil)
+	if err != nil || found[0] == nil || found[1] == nil || found[2] == nil || found[3] == nil || found[4] != nil {
+		t.Fatalf("unexpected records %v (%v)", found, err)
+	}
+
+	// the iterator reads the records written into the new segments
+	write(21, 25)
+	if err = it.Seek(&types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	expectRecords(it, messageIds(21, 25)...)
+
+	// the retention policy deletes the segments of the old records and rewrites the head segment
+	removedSegment := filepath.Join(s.GetStreamDirectoryPath(info.UUID), w.segments.Segments[1].DataFile)
+	cptRemoved, err := w.Trim(&types.RetentionPolicy{MaxRecords: 11}, time.Now())
+	if err != nil || cptRemoved != 14 {
+		t.Fatalf("expected 14 records removed, got %d (%v)", cptRemoved, err)
+	}
+	if info.ReadableMessages.CptMessages != 11 || info.ReadableMessages.FirstMsgId != 15 {
+		t.Fatalf("unexpected readable messages %+v", info.ReadableMessages)
+	}
+	if _, err = os.Stat(removedSegment); !os.IsNotExist(err) {
+		t.Fatalf("expected segment %s to be deleted (%v)", removedSegment, err)
+	}
+	if head := w.segments.Segments[0]; head.DataFile != "data.00000000000000000015.jsonl" || head.CptMessages != 1 {
+		t.Fatalf("unexpected head segment %+v", head)
+	}
+	head := newIterator(&types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"})
+	expectRecords(head, messageIds(15, 25)...)
+	_ = head.Close()
+
+	// the clone holds the segments up to the last record cloned
+	target := types.NewStreamInfo(uuid.New())
+	if err = s.CreateStreamDirectory(target.UUID); err != nil {
+		t.Fatalf("could not create stream directory: %v", err)
+	}
+	if err = s.CopyRecords(info.UUID, target, 20); err != nil {
+		t.Fatalf("could not copy records: %v", err)
+	}
+	if target.ReadableMessages.CptMessages != 6 || target.ReadableMessages.FirstMsgId != 15 || target.ReadableMessages.LastMsgId != 20 {
+		t.Fatalf("unexpected messages info %+v", target.ReadableMessages)
+	}
+	clone := NewStreamIteratorHandlerFile(target.UUID, uuid.New(), s.GetStreamDataFilePath(target.UUID), NewStreamIndex(target.UUID, s.GetStreamIndexFilePath(target.UUID), logger), logger)
+	if err = clone.Open(); err != nil {
+		t.Fatalf("could not open iterator: %v", err)
+	}
+	defer func() {
+		_ = clone.Close()
+	}()
+	if err = clone.Seek(&types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	expectRecords(clone, messageIds(15, 20)...)
+
+	// the index files of all the segments are rebuilt
+	stats, err := NewStreamIndex(info.UUID, indexPath, logger).BuildIndex(dataPath)
+	if err != nil || stats.CptMessages != 11 || stats.FirstMsgId != 15 || stats.LastMsgId != 25 || types.Size64(stats.FileSize) != info.ReadableMessages.SizeInBytes {
+		t.Fatalf("unexpected index stats %+v (%v)", stats, err)
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/nbigot/ministream/encryption"
 	"github.com/nbigot/ministream/types"
 
 	"github.com/goccy/go-json"
@@ -28,8 +31,43 @@ type StreamWriterFile struct {
 	fileMetaInfoPath string
 	fileData         *os.File
 	fileIndex        *os.File
+	dataOffset       int64 // offset of the next record to append into the data file
 	mu               sync.Mutex
 	state            int
+	// the records are appended to the active segment, a new segment is started when it reaches the max size or age
+	segments         *streamSegmentManifest
+	segmentCptRows   int64     // count of records of the active segment
+	segmentFirstDate time.Time // creation date of the first record of the active segment
+	segmentMaxSize   int64     // 0 means unlimited
+	segmentMaxAge    time.Duration
+	// the sealed segments are compressed in the background (one goroutine at a time)
+	segmentCompression   string // none, gzip or zstd
+	compressionBlockSize int64
+	compressing          bool
+	compressionDone      sync.WaitGroup
+	compressionCanceled  atomic.Bool
+	// the payload of the new records is encrypted with the active key when encryptRecords is true
+	keyRing        *encryption.KeyRing
+	encryptRecords bool
+}
+
+type StreamWriterFileOption func(*StreamWriterFile)
+
+func WithSegmentRolling(maxSize int64, maxAge time.Duration) StreamWriterFileOption {
+	// a new segment is started when the data file of the active segment would exceed maxSize bytes,
+	// or when its first record is older than maxAge (0 means unlimited)
+	return func(w *StreamWriterFile) {
+		w.segmentMaxSize = maxSize
+		w.segmentMaxAge = maxAge
+	}
+}
+
+func WithSegmentCompression(compression string, blockSize int64) StreamWriterFileOption {
+	// the data files of the sealed segments are compressed by blocks of blockSize uncompressed bytes
+	return func(w *StreamWriterFile) {
+		w.segmentCompression = compression
+		w.compressionBlockSize = blockSize
+	}
 }
 
 func (w *StreamWriterFile) Init() error {
@@ -44,8 +82,14 @@ func (w *StreamWriterFile) Init() error {
 		return err
 	}
 
-	// ensure file fileDataPath exists (or create it)
-	if fileData, err1 := os.OpenFile(w.fileDataPath, os.O_RDONLY|os.O_CREATE, 0644); err1 != nil {
+	// load the segments of the stream (a stream written before the segments has a single segment)
+	if w.segments, err = loadSegmentManifest(dir, filepath.Base(w.fileDataPath), filepath.Base(w.fileIndexPath)); err != nil {
+		return err
+	}
+	activeSegment := w.segments.getActiveSegment()
+
+	// ensure the data file of the active segment exists (or create it)
+	if fileData, err1 := os.OpenFile(w.segments.getFilePath(activeSegment.DataFile), os.O_RDONLY|os.O_CREATE, 0644); err1 != nil {
 		return err1
 	} else {
 		defer func() {
@@ -53,8 +97,8 @@ func (w *StreamWriterFile) Init() error {
 		}()
 	}
 
-	// ensure file fileIndexPath exists
-	if fileIndex, err2 := os.OpenFile(w.fileIndexPath, os.O_RDONLY|os.O_CREATE, 0644); err2 != nil {
+	// ensure the index file of the active segment exists
+	if fileIndex, err2 := os.OpenFile(w.segments.getFilePath(activeSegment.IndexFile), os.O_RDONLY|os.O_CREATE, 0644); err2 != nil {
 		return err2
 	} else {
 		defer func() {
@@ -62,6 +106,15 @@ func (w *StreamWriterFile) Init() error {
 		}()
 	}
 
+	// If the segment manifest does not exist then create it for the first time
+	if found, errManifest := w.segments.exists(); errManifest != nil {
+		return errManifest
+	} else if !found {
+		if err = w.segments.save(); err != nil {
+			return err
+		}
+	}
+
 	// If stream meta file does not exists then create it for the first time
 	if _, errStat := os.Stat(w.fileMetaInfoPath); errors.Is(errStat, os.ErrNotExist) {
 		err = w.SaveFileMetaInfo()
@@ -83,45 +136,72 @@ func (w *StreamWriterFile) Open() error {
 		return fmt.Errorf("cannot open stream writer file because it's already opened")
 	}
 
-	// Open stream data file (os.File must stay opened for further writing)
-	w.fileData, err = os.OpenFile(w.fileDataPath, os.O_APPEND|os.O_WRONLY, 0644)
+	// Open the data file of the active segment (os.File must stay opened for further writing)
+	activeSegment := w.segments.getActiveSegment()
+	fileDataPath := w.segments.getFilePath(activeSegment.DataFile)
+	w.fileData, err = os.OpenFile(fileDataPath, os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
 		w.logger.Error(
 			"can't open data file",
 			zap.String("topic", "stream"),
 			zap.String("method", "save"),
 			zap.String("stream.uuid", w.info.UUID.String()),
-			zap.Any("filename", w.fileDataPath),
+			zap.Any("filename", fileDataPath),
 			zap.Error(err),
 		)
 		return err
 	}
 
-	// Open stream index file
-	w.fileIndex, err = os.OpenFile(w.fileIndexPath, os.O_APPEND|os.O_WRONLY, 0644)
+	var fileDataInfo os.FileInfo
+	if fileDataInfo, err = w.fileData.Stat(); err != nil {
+		return err
+	}
+	w.dataOffset = fileDataInfo.Size()
+
+	// Open the index file of the active segment
+	fileIndexPath := w.segments.getFilePath(activeSegment.IndexFile)
+	w.fileIndex, err = os.OpenFile(fileIndexPath, os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
+		_ = w.fileData.Close()
 		w.logger.Error(
 			"can't open index file",
 			zap.String("topic", "stream"),
 			zap.String("method", "save"),
 			zap.String("stream.uuid", w.info.UUID.String()),
-			zap.Any("filename", w.fileIndexPath),
+			zap.Any("filename", fileIndexPath),
 			zap.Error(err),
 		)
 		return err
 	}
 
+	if err = w.loadActiveSegmentInfo(fileIndexPath); err != nil {
+		_ = w.fileData.Close()
+		_ = w.fileIndex.Close()
+		return err
+	}
+
 	w.state = STREAM_WRITER_FILE_STATE_OPENED
+	// the segments sealed before the writer was closed may not be compressed yet
+	w.compressionCanceled.Store(false)
+	w.startSegmentCompression()
 	return nil
 }
 
 func (w *StreamWriterFile) Close() error {
 	w.mu.Lock()
-	defer w.mu.Unlock()
+	err := w.close()
+	w.mu.Unlock()
 
+	// wait for the compression of a segment to stop (the compressed file is not kept)
+	w.compressionDone.Wait()
+	return err
+}
+
+func (w *StreamWriterFile) close() error {
 	if w.state != STREAM_WRITER_FILE_STATE_OPENED {
 		return fmt.Errorf("cannot close stream writer file because it's not opened")
 	}
+	w.compressionCanceled.Store(true)
 
 	if err := w.fileData.Close(); err != nil {
 		w.logger.Error(
@@ -179,15 +259,37 @@ func (w *StreamWriterFile) Write(records *[]types.DeferedStreamRecord) error {
 		)
 	}
 
+	// serialize the records first, a record that can't be serialized is never written (nor the records of its batch)
+	keyRing := w.keyRing
+	if !w.encryptRecords {
+		keyRing = nil
+	}
+	lines := make([][]byte, len(*records))
+	for i, record := range *records {
+		bytes, err := marshalStreamRecord(&record, keyRing)
+		if err != nil {
+			w.logger.Error(
+				"json",
+				zap.String("topic", "stream"),
+				zap.String("method", "Write"),
+				zap.String("stream.uuid", w.info.UUID.String()),
+				zap.Any("msg", record),
+				zap.Error(err),
+			)
+			return fmt.Errorf("%w: %w", types.ErrRecordNotWritable, err)
+		}
+		lines[i] = bytes
+	}
+
 	if w.info.ReadableMessages.CptMessages == 0 {
-		// first message ever of the stream
-		w.info.ReadableMessages.FirstMsgId = w.info.IngestedMessages.FirstMsgId
+		// first message ever of the stream (or the stream was emptied by the retention policy)
+		w.info.ReadableMessages.FirstMsgId = (*records)[0].Id
 		w.info.ReadableMessages.LastMsgId = 0
 		w.info.ReadableMessages.FirstMsgTimestamp = (*records)[0].CreationDate
 	}
 
 	// process all records of the ingest buffer
-	for _, record := range *records {
+	for i, record := range *records {
 		if w.logVerbosity > 1 {
 			w.logger.Debug(
 				"write record into file",
@@ -198,25 +300,18 @@ func (w *StreamWriterFile) Write(records *[]types.DeferedStreamRecord) error {
 			)
 		}
 
-		// serialize the record into a string
-		bytes, err := json.Marshal(record)
-		if err != nil {
-			w.logger.Error(
-				"json",
-				zap.String("topic", "stream"),
-				zap.String("method", "Write"),
-				zap.String("stream.uuid", w.info.UUID.String()),
-				zap.Any("msg", record),
-				zap.Error(err),
-			)
-			// drop the record (should never happen)
-			return err
+		var err error
+		bytes := lines[i]
+		if w.mustRollSegment(int64(len(bytes) + 1)) {
+			if err = w.rollSegment(record.Id); err != nil {
+				return err
+			}
 		}
 
 		// append the record to data file
-		strjson := string(bytes)
+		line := append(bytes, EOLChar)
 		var countBytesWritten int
-		if countBytesWritten, err = w.fileData.WriteString(strjson + "\n"); err != nil {
+		if countBytesWritten, err = w.fileData.Write(line); err != nil {
 			return err
 		}
 
@@ -227,16 +322,172 @@ func (w *StreamWriterFile) Write(records *[]types.DeferedStreamRecord) error {
 		w.info.ReadableMessages.LastMsgId = record.Id
 
 		// update the index file
-		// row format is: (<msg id>, <msg length in bytes>, <date>)
-		var data = streamRowIndex{record.Id, int32(countBytesWritten), record.CreationDate.Unix()}
+		// row format is: (<msg id>, <msg length in bytes>, <msg checksum>, <msg offset in data file>, <date>)
+		var data = streamIndexRowMsg{
+			Id:                record.Id,
+			LengthInBytes:     uint32(countBytesWritten),
+			Checksum:          getRecordChecksum(line),
+			Offset:            w.dataOffset,
+			TimestampUnixNano: record.CreationDate.UnixNano(),
+		}
 		if err := binary.Write(w.fileIndex, binary.LittleEndian, data); err != nil {
 			return err
 		}
+		w.dataOffset += int64(countBytesWritten)
+		if w.segmentCptRows == 0 {
+			w.segmentFirstDate = record.CreationDate
+		}
+		w.segmentCptRows++
 	}
 
 	return w.SaveFileMetaInfo()
 }
 
+func (w *StreamWriterFile) Sync() error {
+	// flush the data and index files to the disk (fsync), the records written survive a crash of the server
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.state != STREAM_WRITER_FILE_STATE_OPENED {
+		return fmt.Errorf("cannot sync stream writer file because it's not opened")
+	}
+
+	for _, file := range []*os.File{w.fileData, w.fileIndex} {
+		if err := file.Sync(); err != nil {
+			w.logger.Error(
+				"can't sync file",
+				zap.String("topic", "stream"),
+				zap.String("method", "Sync"),
+				zap.String("stream.uuid", w.info.UUID.String()),
+				zap.Any("filename", file.Name()),
+				zap.Error(err),
+			)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *StreamWriterFile) loadActiveSegmentInfo(fileIndexPath string) error {
+	// the records already written into the active segment are found with its index file
+	file, err := os.Open(fileIndexPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	w.segmentCptRows = fileInfo.Size() / sizeOfStreamIndexRowMsg
+	w.segmentFirstDate = time.Time{}
+	if w.segmentCptRows > 0 {
+		row := streamIndexRowMsg{}
+		if err = readIndexRowAt(file, 0, &row); err != nil {
+			return err
+		}
+		w.segmentFirstDate = time.Unix(0, row.TimestampUnixNano)
+	}
+	return nil
+}
+
+func (w *StreamWriterFile) mustRollSegment(recordSize int64) bool {
+	// a segment holds at least one record
+	if w.segmentCptRows == 0 {
+		return false
+	}
+	if w.segmentMaxSize > 0 && w.dataOffset+recordSize > w.segmentMaxSize {
+		return true
+	}
+	return w.segmentMaxAge > 0 && time.Since(w.segmentFirstDate) >= w.segmentMaxAge
+}
+
+func (w *StreamWriterFile) rollSegment(firstMsgId types.MessageId) error {
+	// seal the active segment and start a new segment with the next record
+	segment := &streamSegment{
+		DataFile:  getSegmentFilename(filepath.Base(w.fileDataPath), firstMsgId),
+		IndexFile: getSegmentFilename(filepath.Base(w.fileIndexPath), firstMsgId),
+	}
+	fileDataPath := w.segments.getFilePath(segment.DataFile)
+	fileIndexPath := w.segments.getFilePath(segment.IndexFile)
+	removeSegment := func() {
+		_ = os.Remove(fileDataPath)
+		_ = os.Remove(fileIndexPath)
+	}
+	fileData, err := os.OpenFile(fileDataPath, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		w.logRollError("can't create data file", err)
+		return err
+	}
+	fileIndex, err := os.OpenFile(fileIndexPath, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		_ = fileData.Close()
+		removeSegment()
+		w.logRollError("can't create index file", err)
+		return err
+	}
+
+	// the sealed segment is flushed to the disk before the manifest lists the new segment
+	activeSegment := w.segments.getActiveSegment()
+	for _, file := range []*os.File{w.fileData, w.fileIndex} {
+		if err = file.Sync(); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		activeSegment.Sealed = true
+		activeSegment.CptMessages = w.segmentCptRows
+		activeSegment.SizeInBytes = w.dataOffset
+		w.segments.Segments = append(w.segments.Segments, segment)
+		if err = w.segments.save(); err != nil {
+			// the active segment is unchanged
+			w.segments.Segments = w.segments.Segments[:len(w.segments.Segments)-1]
+			*activeSegment = streamSegment{DataFile: activeSegment.DataFile, IndexFile: activeSegment.IndexFile}
+		}
+	}
+	if err != nil {
+		_ = fileData.Close()
+		_ = fileIndex.Close()
+		removeSegment()
+		w.logRollError("can't seal segment", err)
+		return err
+	}
+
+	_ = w.fileData.Close()
+	_ = w.fileIndex.Close()
+	w.fileData = fileData
+	w.fileIndex = fileIndex
+	w.dataOffset = 0
+	w.segmentCptRows = 0
+
+	w.logger.Info(
+		"Stream segment rolled",
+		zap.String("topic", "stream"),
+		zap.String("method", "rollSegment"),
+		zap.String("stream.uuid", w.info.UUID.String()),
+		zap.String("segment.sealed", activeSegment.DataFile),
+		zap.Int64("segment.records", activeSegment.CptMessages),
+		zap.Int64("segment.bytes", activeSegment.SizeInBytes),
+		zap.String("segment.active", segment.DataFile),
+	)
+	w.startSegmentCompression()
+	return nil
+}
+
+func (w *StreamWriterFile) logRollError(msg string, err error) {
+	w.logger.Error(
+		msg,
+		zap.String("topic", "stream"),
+		zap.String("method", "rollSegment"),
+		zap.String("stream.uuid", w.info.UUID.String()),
+		zap.Error(err),
+	)
+}
+
 func (w *StreamWriterFile) SaveFileMetaInfo() error {
 	streamUUID := w.info.UUID
 	if w.logVerbosity > 0 {
@@ -248,7 +499,7 @@ func (w *StreamWriterFile) SaveFileMetaInfo() error {
 		)
 	}
 
-	file, err := os.OpenFile(w.fileMetaInfoPath, os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(w.fileMetaInfoPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		w.logger.Error(
 			"can't open meta info file",
@@ -287,8 +538,8 @@ func (w *StreamWriterFile) SaveFileMetaInfo() error {
 	return nil
 }
 
-func NewStreamWriterFile(info *types.StreamInfo, fileDataPath string, fileIndexPath string, fileMetaInfoPath string, logger *zap.Logger, logVerbosity int) *StreamWriterFile {
-	return &StreamWriterFile{
+func NewStreamWriterFile(info *types.StreamInfo, fileDataPath string, fileIndexPath string, fileMetaInfoPath string, logger *zap.Logger, logVerbosity int, opts ...StreamWriterFileOption) *StreamWriterFile {
+	w := &StreamWriterFile{
 		logger:           logger,
 		logVerbosity:     logVerbosity,
 		info:             info,
@@ -299,4 +550,8 @@ func NewStreamWriterFile(info *types.StreamInfo, fileDataPath string, fileIndexP
 		fileData:         nil,
 		fileIndex:        nil,
 	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
 }
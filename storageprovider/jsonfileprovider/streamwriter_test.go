@@ -0,0 +1,193 @@
+package jsonfileprovider
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestWriterIndexRows(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := zap.NewNop()
+	info := types.NewStreamInfo(uuid.New())
+	dataPath := filepath.Join(tmpDir, "data.jsonl")
+	indexPath := filepath.Join(tmpDir, "index.bin")
+	w := NewStreamWriterFile(info, dataPath, indexPath, filepath.Join(tmpDir, "meta.json"), logger, 0)
+	if err := w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 123456789, time.UTC)
+	write := func(firstMsgId types.MessageId, lastMsgId types.MessageId) {
+		if err := w.Open(); err != nil {
+			t.Fatalf("could not open stream writer: %v", err)
+		}
+		records := make([]types.DeferedStreamRecord, 0)
+		for i := firstMsgId; i <= lastMsgId; i++ {
+			records = append(records, types.DeferedStreamRecord{Id: i, CreationDate: start.Add(time.Duration(i) * time.Millisecond), Msg: map[string]interface{}{"n": i}})
+		}
+		if err := w.Write(&records); err != nil {
+			t.Fatalf("could not write records: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("could not close stream writer: %v", err)
+		}
+	}
+	// the offset of the records appended after the writer is opened again starts at the end of the data file
+	write(1, 10)
+	write(11, 15)
+
+	indexFile, err := os.Open(indexPath)
+	if err != nil {
+		t.Fatalf("could not open index file: %v", err)
+	}
+	defer func() {
+		_ = indexFile.Close()
+	}()
+	indexFileInfo, err := indexFile.Stat()
+	if err != nil {
+		t.Fatalf("could not stat index file: %v", err)
+	}
+	if indexFileInfo.Size() != 15*sizeOfStreamIndexRowMsg {
+		t.Fatalf("expected 15 index rows of %d bytes, got a file of %d bytes", sizeOfStreamIndexRowMsg, indexFileInfo.Size())
+	}
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		t.Fatalf("could not read data file: %v", err)
+	}
+
+	// each row locates its record in the data file and holds the date of the record in nanoseconds
+	expectedOffset := int64(0)
+	for msgId := types.MessageId(1); msgId <= 15; msgId++ {
+		row := streamIndexRowMsg{}
+		if err := binary.Read(indexFile, binary.LittleEndian, &row); err != nil {
+			t.Fatalf("could not read index row of message %d: %v", msgId, err)
+		}
+		if row.Id != msgId || row.Offset != expectedOffset {
+			t.Fatalf("expected row of message %d at offset %d, got %+v", msgId, expectedOffset, row)
+		}
+		if row.TimestampUnixNano != start.Add(time.Duration(msgId)*time.Millisecond).UnixNano() {
+			t.Fatalf("unexpected date of message %d: %d", msgId, row.TimestampUnixNano)
+		}
+		record := map[string]interface{}{}
+		if err := json.Unmarshal(data[row.Offset:row.Offset+int64(row.LengthInBytes)], &record); err != nil {
+			t.Fatalf("could not read record of message %d: %v", msgId, err)
+		}
+		if n := record["m"].(map[string]interface{})["n"]; n != float64(msgId) {
+			t.Fatalf("unexpected record %v for message %d", record, msgId)
+		}
+		expectedOffset += int64(row.LengthInBytes)
+	}
+}
+
+func TestSaveFileMetaInfo(t *testing.T) {
+	tmpDir := t.TempDir()
+	info := types.NewStreamInfo(uuid.New())
+	metaPath := filepath.Join(tmpDir, "meta.json")
+	w := NewStreamWriterFile(info, filepath.Join(tmpDir, "data.jsonl"), filepath.Join(tmpDir, "index.bin"), metaPath, zap.NewNop(), 0)
+
+	info.Properties["description"] = "a description longer than the next meta info document"
+	if err := w.SaveFileMetaInfo(); err != nil {
+		t.Fatalf("could not save meta info: %v", err)
+	}
+	// a shorter document replaces the whole file
+	delete(info.Properties, "description")
+	if err := w.SaveFileMetaInfo(); err != nil {
+		t.Fatalf("could not save meta info: %v", err)
+	}
+
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		t.Fatalf("could not read meta info file: %v", err)
+	}
+	saved := types.StreamInfo{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("could not decode meta info file %q: %v", data, err)
+	}
+	if saved.UUID != info.UUID || len(saved.Properties) != 0 {
+		t.Fatalf("unexpected meta info %+v", saved)
+	}
+}
+
+func TestWriterRecordHeaders(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := zap.NewNop()
+	info := types.NewStreamInfo(uuid.New())
+	dataPath := filepath.Join(tmpDir, "data.jsonl")
+	indexPath := filepath.Join(tmpDir, "index.bin")
+	w := NewStreamWriterFile(info, dataPath, indexPath, filepath.Join(tmpDir, "meta.json"), logger, 0)
+	if err := w.Init(); err != nil {
+		t.Fatalf("could not init stream writer: %v", err)
+	}
+	if err := w.Open(); err != nil {
+		t.Fatalf("could not open stream writer: %v", err)
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+
+	// the even records have headers
+	records := make([]types.DeferedStreamRecord, 0, 4)
+	for i := 1; i <= 4; i++ {
+		record := types.DeferedStreamRecord{Id: types.MessageId(i), CreationDate: time.Now(), Msg: map[string]interface{}{"n": i}}
+		if i%2 == 0 {
+			record.Headers = types.RecordHeaders{"content-type": "application/json", "trace.id": strconv.Itoa(i)}
+		}
+		records = append(records, record)
+	}
+	if err := w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+
+	expectHeaders := func(record interface{}, msgId types.MessageId) {
+		headers, found := record.(map[string]interface{})["h"]
+		if msgId%2 == 1 {
+			if found {
+				t.Fatalf("expected no headers for message %d, got %v", msgId, headers)
+			}
+			return
+		}
+		expected := map[string]interface{}{"content-type": "application/json", "trace.id": strconv.Itoa(int(msgId))}
+		if !reflect.DeepEqual(headers, expected) {
+			t.Fatalf("expected headers %v for message %d, got %v", expected, msgId, headers)
+		}
+	}
+
+	// the headers are read back by the iterators and by the lookup of the records
+	idx := NewStreamIndex(info.UUID, indexPath, logger)
+	it := NewStreamIteratorHandlerFile(info.UUID, uuid.New(), dataPath, idx, logger)
+	if err := it.Open(); err != nil {
+		t.Fatalf("could not open iterator: %v", err)
+	}
+	defer func() {
+		_ = it.Close()
+	}()
+	if err := it.Seek(&types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	for expectedId := types.MessageId(1); expectedId <= 4; expectedId++ {
+		msgId, record, found, _, err := it.GetNextRecord()
+		if err != nil || !found || msgId != expectedId {
+			t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+		}
+		expectHeaders(record, msgId)
+	}
+	messageIds := []types.MessageId{1, 2, 3, 4}
+	found, err := readRecordsByIds(dataPath, idx, messageIds, nil)
+	if err != nil {
+		t.Fatalf("could not read records: %v", err)
+	}
+	for i, record := range found {
+		expectHeaders(record, messageIds[i])
+	}
+}
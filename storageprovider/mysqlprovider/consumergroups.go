@@ -0,0 +1,129 @@
+package mysqlprovider
+
+import (
+	"database/sql"
+
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+func (s *MySQLStorage) getConsumerGroupFullTableName() string {
+	return s.mysqlConfig.SchemaName + "." + s.mysqlConfig.ConsumerGroupTableName
+}
+
+func (s *MySQLStorage) EnsureConsumerGroupTableExists() error {
+	// create the SQL table holding the committed offsets of the consumer groups (if not exists)
+	query := `CREATE TABLE IF NOT EXISTS ` + s.getConsumerGroupFullTableName() + ` (
+		stream_id CHAR(36) NOT NULL,
+		name VARCHAR(256) NOT NULL,
+		committed_msg_id BIGINT DEFAULT 0,
+		creation_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_commit_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (stream_id, name)
+	)`
+	if _, err := s.pool.Exec(query); err != nil {
+		s.logger.Error(
+			"Can't create table",
+			zap.String("topic", "stream"),
+			zap.String("method", "EnsureConsumerGroupTableExists"),
+			zap.String("table", s.mysqlConfig.ConsumerGroupTableName),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+func (s *MySQLStorage) LoadConsumerGroups(streamUUID types.StreamUUID) (types.ConsumerGroupList, error) {
+	query := "SELECT name, committed_msg_id, creation_date, last_commit_date FROM " + s.getConsumerGroupFullTableName() + " WHERE stream_id = ? ORDER BY name"
+	rows, err := s.pool.Query(query, streamUUID.String())
+	if err != nil {
+		s.logger.Error(
+			"Can't load consumer groups",
+			zap.String("topic", "stream"),
+			zap.String("method", "LoadConsumerGroups"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	groups := make(types.ConsumerGroupList, 0)
+	for rows.Next() {
+		group := types.ConsumerGroup{StreamUUID: streamUUID}
+		var committedMsgId sql.NullInt64
+		if err := rows.Scan(&group.Name, &committedMsgId, &group.CreationDate, &group.LastCommitDate); err != nil {
+			s.logger.Error(
+				"Can't read consumer group",
+				zap.String("topic", "stream"),
+				zap.String("method", "LoadConsumerGroups"),
+				zap.String("stream.uuid", streamUUID.String()),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+		if committedMsgId.Valid {
+			group.CommittedMsgId = types.MessageId(committedMsgId.Int64)
+		}
+		groups = append(groups, &group)
+	}
+
+	return groups, rows.Err()
+}
+
+func (s *MySQLStorage) SaveConsumerGroup(group *types.ConsumerGroup) error {
+	query := "INSERT INTO " + s.getConsumerGroupFullTableName() + " (stream_id, name, committed_msg_id, creation_date, last_commit_date) VALUES (?, ?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE committed_msg_id = VALUES(committed_msg_id), last_commit_date = VALUES(last_commit_date)"
+	_, err := s.pool.Exec(query, group.StreamUUID.String(), group.Name, group.CommittedMsgId, group.CreationDate, group.LastCommitDate)
+	if err != nil {
+		s.logger.Error(
+			"Can't save consumer group",
+			zap.String("topic", "stream"),
+			zap.String("method", "SaveConsumerGroup"),
+			zap.String("stream.uuid", group.StreamUUID.String()),
+			zap.String("consumerGroup", group.Name),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+func (s *MySQLStorage) DeleteConsumerGroup(streamUUID types.StreamUUID, name string) error {
+	query := "DELETE FROM " + s.getConsumerGroupFullTableName() + " WHERE stream_id = ? AND name = ?"
+	if _, err := s.pool.Exec(query, streamUUID.String(), name); err != nil {
+		s.logger.Error(
+			"Can't delete consumer group",
+			zap.String("topic", "stream"),
+			zap.String("method", "DeleteConsumerGroup"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.String("consumerGroup", name),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+func (s *MySQLStorage) deleteStreamConsumerGroups(streamUUID types.StreamUUID) error {
+	query := "DELETE FROM " + s.getConsumerGroupFullTableName() + " WHERE stream_id = ?"
+	if _, err := s.pool.Exec(query, streamUUID.String()); err != nil {
+		s.logger.Error(
+			"Can't delete consumer groups",
+			zap.String("topic", "stream"),
+			zap.String("method", "deleteStreamConsumerGroups"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
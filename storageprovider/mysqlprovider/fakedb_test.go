@@ -0,0 +1,116 @@
+package mysqlprovider
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeDB answers the queries of the provider without a MySQL server:
+// the statements are recorded, the rows of a query are returned by the query function
+// and the statements executed are given to the exec function (if any) which returns the number of rows affected
+type fakeDB struct {
+	mu         sync.Mutex
+	statements []string
+	query      func(query string, args []driver.NamedValue) (*fakeRows, error)
+	exec       func(query string, args []driver.NamedValue) int64
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func newFakeDB(t *testing.T, query func(query string, args []driver.NamedValue) (*fakeRows, error)) (*fakeDB, *sql.DB) {
+	db := &fakeDB{query: query}
+	pool := sql.OpenDB(db)
+	t.Cleanup(func() {
+		_ = pool.Close()
+	})
+	return db, pool
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: db}, nil
+}
+
+func (db *fakeDB) Driver() driver.Driver {
+	return db
+}
+
+func (db *fakeDB) Open(name string) (driver.Conn, error) {
+	return &fakeConn{db: db}, nil
+}
+
+func (db *fakeDB) record(query string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.statements = append(db.statements, query)
+}
+
+func (db *fakeDB) getStatements() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string{}, db.statements...)
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	return nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.record(query)
+	if c.db.exec != nil {
+		return driver.RowsAffected(c.db.exec(query, args)), nil
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.record(query)
+	if c.db.query == nil {
+		return &fakeRows{}, nil
+	}
+	return c.db.query(query, args)
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -9,23 +9,26 @@ import (
 )
 
 type MySQLConfig struct {
-	Dsn               string // data source name
-	SchemaName        string // mysql schema name
-	CatalogTableName  string // mysql table name to store the catalog of streams
-	StreamTablePrefix string // prefix for the stream tables
-	ConnMaxLifetime   uint
-	MaxIdleConns      uint
-	MaxOpenConns      uint
+	Dsn                    string // data source name
+	SchemaName             string // mysql schema name
+	CatalogTableName       string // mysql table name to store the catalog of streams
+	StreamTablePrefix      string // prefix for the stream tables
+	ConsumerGroupTableName string // mysql table name to store the consumer groups committed offsets
+	SchemaTableName        string // mysql table name to store the versions of the schemas of the streams
+	ProducerTableName      string // mysql table name to store the sequences of the idempotent producers
+	ConnMaxLifetime        uint
+	MaxIdleConns           uint
+	MaxOpenConns           uint
 }
 
-func CheckMySQLConfiguration(conf *config.Config) (string, string, string, string, error) {
+func CheckMySQLConfiguration(conf *config.Config) (string, string, string, string, string, string, string, error) {
 	// check if MySQL configuration is valid
 	if conf.Storage.MySQL.DataSourceName == "" {
-		return "", "", "", "", errors.New("empty data source name")
+		return "", "", "", "", "", "", "", errors.New("empty data source name")
 	}
 
 	if conf.Storage.MySQL.MaxIdleConns == 0 {
-		return "", "", "", "", errors.New("invalid MaxIdleConns value")
+		return "", "", "", "", "", "", "", errors.New("invalid MaxIdleConns value")
 	}
 
 	// if then DSN string value starts with "$" then it is an environment variable name
@@ -45,7 +48,7 @@ func CheckMySQLConfiguration(conf *config.Config) (string, string, string, strin
 	}
 
 	if !re.Match([]byte(schemaName)) {
-		return "", "", "", "", errors.New("invalid schema name")
+		return "", "", "", "", "", "", "", errors.New("invalid schema name")
 	}
 
 	// check if catalog table name is valid
@@ -55,7 +58,7 @@ func CheckMySQLConfiguration(conf *config.Config) (string, string, string, strin
 	}
 
 	if !re.Match([]byte(catalogTableName)) {
-		return "", "", "", "", errors.New("invalid catalog table name")
+		return "", "", "", "", "", "", "", errors.New("invalid catalog table name")
 	}
 
 	// check if stream table prefix is valid
@@ -65,26 +68,59 @@ func CheckMySQLConfiguration(conf *config.Config) (string, string, string, strin
 	}
 
 	if !re.Match([]byte(streamTablePrefix)) {
-		return "", "", "", "", errors.New("invalid stream table prefix")
+		return "", "", "", "", "", "", "", errors.New("invalid stream table prefix")
 	}
 
-	return dataSourceName, schemaName, catalogTableName, streamTablePrefix, nil
+	// check if consumer groups table name is valid
+	consumerGroupTableName := conf.Storage.MySQL.ConsumerGroupTableName
+	if consumerGroupTableName == "" {
+		consumerGroupTableName = "consumer_groups"
+	}
+
+	if !re.Match([]byte(consumerGroupTableName)) {
+		return "", "", "", "", "", "", "", errors.New("invalid consumer group table name")
+	}
+
+	// check if schemas table name is valid
+	schemaTableName := conf.Storage.MySQL.SchemaTableName
+	if schemaTableName == "" {
+		schemaTableName = "stream_schemas"
+	}
+
+	if !re.Match([]byte(schemaTableName)) {
+		return "", "", "", "", "", "", "", errors.New("invalid schema table name")
+	}
+
+	// check if producers table name is valid
+	producerTableName := conf.Storage.MySQL.ProducerTableName
+	if producerTableName == "" {
+		producerTableName = "stream_producers"
+	}
+
+	if !re.Match([]byte(producerTableName)) {
+		return "", "", "", "", "", "", "", errors.New("invalid producer table name")
+	}
+
+	return dataSourceName, schemaName, catalogTableName, streamTablePrefix, consumerGroupTableName, schemaTableName, producerTableName, nil
 }
 
 func NewMySQLConfig(conf *config.Config) (*MySQLConfig, error) {
-	dataSourceName, schemaName, catalogTableName, streamTablePrefix, err := CheckMySQLConfiguration(conf)
+	dataSourceName, schemaName, catalogTableName, streamTablePrefix, consumerGroupTableName, schemaTableName, producerTableName, err := CheckMySQLConfiguration(conf)
 	if err != nil {
 		return nil, err
 	}
 
 	mySQLConfig := MySQLConfig{
-		Dsn:               dataSourceName,
-		SchemaName:        schemaName,
-		CatalogTableName:  catalogTableName,
-		StreamTablePrefix: streamTablePrefix,
-		ConnMaxLifetime:   conf.Storage.MySQL.ConnMaxLifetime,
-		MaxIdleConns:      conf.Storage.MySQL.MaxIdleConns,
-		MaxOpenConns:      conf.Storage.MySQL.MaxOpenConns,
+		Dsn:                    dataSourceName,
+		SchemaName:             schemaName,
+		CatalogTableName:       catalogTableName,
+		StreamTablePrefix:      streamTablePrefix,
+		ConsumerGroupTableName: consumerGroupTableName,
+		SchemaTableName:        schemaTableName,
+		ProducerTableName:      producerTableName,
+		ConnMaxLifetime:        conf.Storage.MySQL.ConnMaxLifetime,
+		MaxIdleConns:           conf.Storage.MySQL.MaxIdleConns,
+		MaxOpenConns:           conf.Storage.MySQL.MaxOpenConns,
 	}
 
 	return &mySQLConfig, nil
@@ -0,0 +1,113 @@
+package mysqlprovider
+
+import (
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+)
+
+func (s *MySQLStorage) getProducerFullTableName() string {
+	return s.mysqlConfig.SchemaName + "." + s.mysqlConfig.ProducerTableName
+}
+
+func (s *MySQLStorage) EnsureProducerTableExists() error {
+	// create the SQL table holding the sequences of the idempotent producers of the streams (if not exists)
+	query := `CREATE TABLE IF NOT EXISTS ` + s.getProducerFullTableName() + ` (
+		stream_id CHAR(36) NOT NULL,
+		producer_id VARCHAR(64) NOT NULL,
+		state JSON NOT NULL,
+		last_update TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		PRIMARY KEY (stream_id, producer_id)
+	)`
+	if _, err := s.pool.Exec(query); err != nil {
+		s.logger.Error(
+			"Can't create table",
+			zap.String("topic", "stream"),
+			zap.String("method", "EnsureProducerTableExists"),
+			zap.String("table", s.mysqlConfig.ProducerTableName),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+func (s *MySQLStorage) LoadProducers(streamUUID types.StreamUUID) (types.ProducerList, error) {
+	query := "SELECT state FROM " + s.getProducerFullTableName() + " WHERE stream_id = ?"
+	rows, err := s.pool.Query(query, streamUUID.String())
+	if err != nil {
+		s.logger.Error(
+			"Can't load producers",
+			zap.String("topic", "stream"),
+			zap.String("method", "LoadProducers"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	producers := make(types.ProducerList, 0)
+	for rows.Next() {
+		producer := types.Producer{}
+		var state []byte
+		if err = rows.Scan(&state); err == nil {
+			err = json.Unmarshal(state, &producer)
+		}
+		if err != nil {
+			s.logger.Error(
+				"Can't read producer",
+				zap.String("topic", "stream"),
+				zap.String("method", "LoadProducers"),
+				zap.String("stream.uuid", streamUUID.String()),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+		producers = append(producers, &producer)
+	}
+
+	return producers, rows.Err()
+}
+
+func (s *MySQLStorage) SaveProducer(producer *types.Producer) error {
+	state, err := json.Marshal(producer)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO " + s.getProducerFullTableName() + " (stream_id, producer_id, state) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE state = VALUES(state)"
+	if _, err = s.pool.Exec(query, producer.StreamUUID.String(), producer.Id, state); err != nil {
+		s.logger.Error(
+			"Can't save producer",
+			zap.String("topic", "stream"),
+			zap.String("method", "SaveProducer"),
+			zap.String("stream.uuid", producer.StreamUUID.String()),
+			zap.String("producer", producer.Id),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+func (s *MySQLStorage) deleteStreamProducers(streamUUID types.StreamUUID) error {
+	query := "DELETE FROM " + s.getProducerFullTableName() + " WHERE stream_id = ?"
+	if _, err := s.pool.Exec(query, streamUUID.String()); err != nil {
+		s.logger.Error(
+			"Can't delete producers",
+			zap.String("topic", "stream"),
+			zap.String("method", "deleteStreamProducers"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
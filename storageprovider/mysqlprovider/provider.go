@@ -63,6 +63,18 @@ func (s *MySQLStorage) Init() error {
 		return err
 	}
 
+	if err = s.EnsureConsumerGroupTableExists(); err != nil {
+		return err
+	}
+
+	if err = s.EnsureSchemaTableExists(); err != nil {
+		return err
+	}
+
+	if err = s.EnsureProducerTableExists(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -175,6 +187,21 @@ func (s *MySQLStorage) DeleteStream(streamUUID types.StreamUUID) error {
 	// delete index in memory
 	delete(s.indexes, streamUUID)
 
+	// delete consumer groups committed offsets
+	if err := s.deleteStreamConsumerGroups(streamUUID); err != nil {
+		return err
+	}
+
+	// delete the versions of the schema
+	if err := s.DeleteStreamSchemas(streamUUID); err != nil {
+		return err
+	}
+
+	// delete the sequences of the idempotent producers
+	if err := s.deleteStreamProducers(streamUUID); err != nil {
+		return err
+	}
+
 	// delete index in catalog
 	return s.catalog.OnDeleteStream(streamUUID)
 }
@@ -1,9 +1,13 @@
 package mysqlprovider
 
 import (
+	"database/sql/driver"
 	"testing"
 
+	"github.com/nbigot/ministream/types"
+
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
 func TestSafeOverrideDSN(t *testing.T) {
@@ -71,3 +75,83 @@ func TestMySQLStorage_getStreamTableName(t *testing.T) {
 		}
 	})
 }
+
+func TestMySQLStorage_streamTableNames(t *testing.T) {
+	// the catalog, the index and the records of a stream use the same table
+	streamUUID := uuid.MustParse("9cf1eba6-0f6d-425e-ada9-e5b6cf293ffc")
+	expectedFullTableName := "ministream.prefix_9cf1eba6_0f6d_425e_ada9_e5b6cf293ffc"
+	db, pool := newFakeDB(t, func(query string, args []driver.NamedValue) (*fakeRows, error) {
+		return &fakeRows{columns: []string{"exists"}, values: [][]driver.Value{{int64(1)}}}, nil
+	})
+	logger := zap.NewNop()
+	mysqlStorage := &MySQLStorage{
+		mysqlConfig: &MySQLConfig{
+			SchemaName:        "ministream",
+			CatalogTableName:  "catalog",
+			StreamTablePrefix: "prefix_",
+		},
+	}
+	info := types.NewStreamInfo(streamUUID)
+	info.ReadableMessages = types.StreamMessagesInfo{CptMessages: 1, FirstMsgId: 1, LastMsgId: 1}
+
+	idx := NewStreamIndex(streamUUID, info, "ministream", mysqlStorage.getStreamTableName(streamUUID), pool, logger)
+	if fullTableName := idx.GetFullTableName(); fullTableName != expectedFullTableName {
+		t.Errorf("Expected index table name to be %q, but got %q", expectedFullTableName, fullTableName)
+	}
+	if _, err := idx.GetMessageId(1); err != nil {
+		t.Fatalf("Expected message id to be found, but got: %v", err)
+	}
+
+	catalog := NewStreamCatalogMySQL(logger, pool, "ministream", "catalog", "prefix_")
+	if err := catalog.OnDeleteStream(streamUUID); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	statements := db.getStatements()
+	expectedStatements := []string{
+		"SELECT EXISTS(SELECT 1 FROM " + expectedFullTableName + " WHERE `id` = ?)",
+		"DELETE FROM ministream.catalog WHERE id = ?",
+		"DROP TABLE " + expectedFullTableName,
+	}
+	if len(statements) != len(expectedStatements) {
+		t.Fatalf("Expected statements %q, but got %q", expectedStatements, statements)
+	}
+	for i := range expectedStatements {
+		if statements[i] != expectedStatements[i] {
+			t.Errorf("Expected statement %q, but got %q", expectedStatements[i], statements[i])
+		}
+	}
+}
+
+func TestStreamIndexMySQL_GetMessageIdAfterMessageId(t *testing.T) {
+	streamUUID := uuid.New()
+	_, pool := newFakeDB(t, func(query string, args []driver.NamedValue) (*fakeRows, error) {
+		// message ids 1, 2 and 4 are in the stream table
+		columns := []string{"id"}
+		switch args[0].Value {
+		case int64(1):
+			return &fakeRows{columns: columns, values: [][]driver.Value{{int64(2)}}}, nil
+		case int64(2):
+			return &fakeRows{columns: columns, values: [][]driver.Value{{int64(4)}}}, nil
+		default:
+			return &fakeRows{columns: columns}, nil
+		}
+	})
+	info := types.NewStreamInfo(streamUUID)
+	info.ReadableMessages = types.StreamMessagesInfo{CptMessages: 3, FirstMsgId: 1, LastMsgId: 4}
+	idx := NewStreamIndex(streamUUID, info, "ministream", "stream", pool, zap.NewNop())
+
+	// after the last message, the next message id is the one of the next message put
+	for messageId, expectedMessageId := range map[types.MessageId]types.MessageId{1: 2, 2: 4, 4: 5} {
+		nextMessageId, err := idx.GetMessageIdAfterMessageId(messageId)
+		if err != nil {
+			t.Fatalf("Expected no error after message id %d, but got: %v", messageId, err)
+		}
+		if nextMessageId != expectedMessageId {
+			t.Errorf("Expected message id %d after message id %d, but got %d", expectedMessageId, messageId, nextMessageId)
+		}
+	}
+	if _, err := idx.GetMessageIdAfterMessageId(5); err == nil {
+		t.Error("Expected an error after a message id not put yet, but got nil")
+	}
+}
@@ -0,0 +1,154 @@
+package mysqlprovider
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+)
+
+func (s *MySQLStorage) GetRecordsByIds(streamUUID types.StreamUUID, messageIds []types.MessageId) ([]interface{}, error) {
+	// primary key lookup of the records (nil if the record is not found)
+	records := make([]interface{}, len(messageIds))
+	if len(messageIds) == 0 {
+		return records, nil
+	}
+
+	args := make([]interface{}, len(messageIds))
+	for i, messageId := range messageIds {
+		args[i] = messageId
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(messageIds)), ", ")
+	query := "SELECT `id`, `message` FROM " + s.mysqlConfig.SchemaName + "." + s.getStreamTableName(streamUUID) + " WHERE `id` IN (" + placeholders + ")"
+	rows, err := s.pool.Query(query, args...)
+	if err != nil {
+		s.logger.Error(
+			"Can't read records",
+			zap.String("topic", "stream"),
+			zap.String("method", "GetRecordsByIds"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	// the message column holds the whole record (same format as the records read from the other storage providers)
+	found := make(map[types.MessageId]map[string]interface{}, len(messageIds))
+	for rows.Next() {
+		var (
+			messageId types.MessageId
+			strMsg    string
+			record    map[string]interface{}
+		)
+		if err = rows.Scan(&messageId, &strMsg); err == nil {
+			err = json.Unmarshal([]byte(strMsg), &record)
+		}
+		if err != nil {
+			s.logger.Error(
+				"Can't read record",
+				zap.String("topic", "stream"),
+				zap.String("method", "GetRecordsByIds"),
+				zap.String("stream.uuid", streamUUID.String()),
+				zap.Uint64("message.id", messageId),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+		found[messageId] = record
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, messageId := range messageIds {
+		if record, ok := found[messageId]; ok {
+			records[i] = record
+		}
+	}
+	return records, nil
+}
+
+func (s *MySQLStorage) CopyRecords(sourceUUID types.StreamUUID, target *types.StreamInfo, lastMsgId types.MessageId) error {
+	// copy the records of the source stream up to lastMsgId (included) into the target stream (created but not started yet)
+	targetTableName := s.mysqlConfig.SchemaName + "." + s.getStreamTableName(target.UUID)
+	transaction, err := s.pool.Begin()
+	if err != nil {
+		s.logCopyRecordsError("can't start transaction", sourceUUID, target.UUID, err)
+		return err
+	}
+
+	query := "INSERT INTO " + targetTableName + " (`id`, `timestamp`, `message`) SELECT `id`, `timestamp`, `message` FROM " + s.mysqlConfig.SchemaName + "." + s.getStreamTableName(sourceUUID) + " WHERE `id` <= ?"
+	if _, err = transaction.Exec(query, lastMsgId); err != nil {
+		_ = transaction.Rollback()
+		s.logCopyRecordsError("can't copy records", sourceUUID, target.UUID, err)
+		return err
+	}
+
+	messages := types.StreamMessagesInfo{}
+	var sizeInBytes sql.NullInt64
+	query = "SELECT COUNT(*), SUM(LENGTH(`message`)) FROM " + targetTableName
+	if err = transaction.QueryRow(query).Scan(&messages.CptMessages, &sizeInBytes); err != nil {
+		_ = transaction.Rollback()
+		s.logCopyRecordsError("can't count records", sourceUUID, target.UUID, err)
+		return err
+	}
+	if messages.CptMessages == 0 {
+		return transaction.Commit()
+	}
+	messages.SizeInBytes = types.Size64(sizeInBytes.Int64)
+
+	query = "SELECT `id`, `timestamp` FROM " + targetTableName + " ORDER BY `id` ASC LIMIT 1"
+	if err = transaction.QueryRow(query).Scan(&messages.FirstMsgId, &messages.FirstMsgTimestamp); err != nil {
+		_ = transaction.Rollback()
+		s.logCopyRecordsError("can't read first record", sourceUUID, target.UUID, err)
+		return err
+	}
+	query = "SELECT `id`, `timestamp` FROM " + targetTableName + " ORDER BY `id` DESC LIMIT 1"
+	if err = transaction.QueryRow(query).Scan(&messages.LastMsgId, &messages.LastMsgTimestamp); err != nil {
+		_ = transaction.Rollback()
+		s.logCopyRecordsError("can't read last record", sourceUUID, target.UUID, err)
+		return err
+	}
+
+	w := NewStreamWriterMySQL(target, s.mysqlConfig.SchemaName, s.mysqlConfig.CatalogTableName, s.getStreamTableName(target.UUID), s.pool, s.logger, s.logVerbosity)
+	if err = w.SaveMetaInfo(
+		transaction,
+		messages.CptMessages,
+		messages.SizeInBytes,
+		messages.FirstMsgId,
+		messages.LastMsgId,
+		messages.FirstMsgTimestamp,
+		messages.LastMsgTimestamp,
+	); err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+
+	if err = transaction.Commit(); err != nil {
+		_ = transaction.Rollback()
+		s.logCopyRecordsError("can't commit transaction", sourceUUID, target.UUID, err)
+		return err
+	}
+
+	// update stream info after the transaction commit
+	target.IngestedMessages = messages
+	target.ReadableMessages = messages
+	return nil
+}
+
+func (s *MySQLStorage) logCopyRecordsError(msg string, sourceUUID types.StreamUUID, targetUUID types.StreamUUID, err error) {
+	s.logger.Error(
+		msg,
+		zap.String("topic", "stream"),
+		zap.String("method", "CopyRecords"),
+		zap.String("stream.uuid", targetUUID.String()),
+		zap.String("source.uuid", sourceUUID.String()),
+		zap.Error(err),
+	)
+}
@@ -0,0 +1,113 @@
+package mysqlprovider
+
+import (
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+)
+
+func (s *MySQLStorage) getSchemaFullTableName() string {
+	return s.mysqlConfig.SchemaName + "." + s.mysqlConfig.SchemaTableName
+}
+
+func (s *MySQLStorage) EnsureSchemaTableExists() error {
+	// create the SQL table holding the versions of the schemas of the streams (if not exists)
+	query := `CREATE TABLE IF NOT EXISTS ` + s.getSchemaFullTableName() + ` (
+		stream_id CHAR(36) NOT NULL,
+		version INT NOT NULL,
+		definition JSON NOT NULL,
+		creation_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (stream_id, version)
+	)`
+	if _, err := s.pool.Exec(query); err != nil {
+		s.logger.Error(
+			"Can't create table",
+			zap.String("topic", "stream"),
+			zap.String("method", "EnsureSchemaTableExists"),
+			zap.String("table", s.mysqlConfig.SchemaTableName),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+func (s *MySQLStorage) LoadStreamSchemas(streamUUID types.StreamUUID) (types.StreamSchemaList, error) {
+	query := "SELECT version, definition, creation_date FROM " + s.getSchemaFullTableName() + " WHERE stream_id = ? ORDER BY version"
+	rows, err := s.pool.Query(query, streamUUID.String())
+	if err != nil {
+		s.logger.Error(
+			"Can't load schemas",
+			zap.String("topic", "stream"),
+			zap.String("method", "LoadStreamSchemas"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	schemas := make(types.StreamSchemaList, 0)
+	for rows.Next() {
+		schema := types.StreamSchema{StreamUUID: streamUUID}
+		var definition []byte
+		if err = rows.Scan(&schema.Version, &definition, &schema.CreationDate); err == nil {
+			err = json.Unmarshal(definition, &schema.Schema)
+		}
+		if err != nil {
+			s.logger.Error(
+				"Can't read schema",
+				zap.String("topic", "stream"),
+				zap.String("method", "LoadStreamSchemas"),
+				zap.String("stream.uuid", streamUUID.String()),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+		schemas = append(schemas, &schema)
+	}
+
+	return schemas, rows.Err()
+}
+
+func (s *MySQLStorage) SaveStreamSchema(schema *types.StreamSchema) error {
+	definition, err := json.Marshal(schema.Schema)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO " + s.getSchemaFullTableName() + " (stream_id, version, definition, creation_date) VALUES (?, ?, ?, ?)"
+	if _, err = s.pool.Exec(query, schema.StreamUUID.String(), schema.Version, definition, schema.CreationDate); err != nil {
+		s.logger.Error(
+			"Can't save schema",
+			zap.String("topic", "stream"),
+			zap.String("method", "SaveStreamSchema"),
+			zap.String("stream.uuid", schema.StreamUUID.String()),
+			zap.Int("schema.version", schema.Version),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+func (s *MySQLStorage) DeleteStreamSchemas(streamUUID types.StreamUUID) error {
+	query := "DELETE FROM " + s.getSchemaFullTableName() + " WHERE stream_id = ?"
+	if _, err := s.pool.Exec(query, streamUUID.String()); err != nil {
+		s.logger.Error(
+			"Can't delete schemas",
+			zap.String("topic", "stream"),
+			zap.String("method", "DeleteStreamSchemas"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
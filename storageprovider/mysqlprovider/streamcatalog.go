@@ -59,8 +59,8 @@ func (s *StreamCatalogMySQL) EnsureCatalogExists() error {
 	}
 
 	if exists {
-		// if catalog already exists therefore do nothing
-		return nil
+		// if catalog already exists therefore only add the columns missing in the catalogs created by older versions
+		return s.EnsureCatalogColumnExists("partitioning", "JSON DEFAULT NULL")
 	}
 
 	// create new empty catalog of streams
@@ -153,7 +153,8 @@ func (s *StreamCatalogMySQL) CreateEmptyCatalog() error {
 		cache_first_msg_timestamp TIMESTAMP NULL,
 		cache_last_msg_timestamp TIMESTAMP NULL,
 		comment VARCHAR(255) DEFAULT NULL,
-		properties JSON DEFAULT NULL
+		properties JSON DEFAULT NULL,
+		partitioning JSON DEFAULT NULL
 	)`
 	_, err := s.pool.Exec(query)
 	if err != nil {
@@ -172,6 +173,41 @@ func (s *StreamCatalogMySQL) CreateEmptyCatalog() error {
 	return nil
 }
 
+func (s *StreamCatalogMySQL) EnsureCatalogColumnExists(columnName string, columnDefinition string) error {
+	var cpt int
+	query := "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = ? AND table_name = ? AND column_name = ?"
+	if err := s.pool.QueryRow(query, s.schemaName, s.catalogTableName, columnName).Scan(&cpt); err != nil {
+		s.logger.Fatal(
+			"Can't check if column exists",
+			zap.String("topic", "stream"),
+			zap.String("method", "EnsureCatalogColumnExists"),
+			zap.String("table", s.catalogTableName),
+			zap.String("column", columnName),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	if cpt > 0 {
+		return nil
+	}
+
+	query = "ALTER TABLE " + s.schemaName + "." + s.catalogTableName + " ADD COLUMN " + columnName + " " + columnDefinition
+	if _, err := s.pool.Exec(query); err != nil {
+		s.logger.Fatal(
+			"Can't add column",
+			zap.String("topic", "stream"),
+			zap.String("method", "EnsureCatalogColumnExists"),
+			zap.String("table", s.catalogTableName),
+			zap.String("column", columnName),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
 func (s *StreamCatalogMySQL) SaveStreamCatalog() error {
 	// nothing to do (catalog is persistent in the SQL table)
 	return nil
@@ -212,7 +248,7 @@ func (s *StreamCatalogMySQL) LoadStreamCatalog() (types.StreamUUIDList, error) {
 	)
 
 	// load the catalog of streams from the SQL table
-	query := "SELECT id, creation_date, cache_cpt_rows, cache_size_in_bytes, cache_first_msg_id, cache_last_msg_id, cache_first_msg_timestamp, cache_last_msg_timestamp, last_update, properties FROM " + s.schemaName + "." + s.catalogTableName
+	query := "SELECT id, creation_date, cache_cpt_rows, cache_size_in_bytes, cache_first_msg_id, cache_last_msg_id, cache_first_msg_timestamp, cache_last_msg_timestamp, last_update, properties, partitioning FROM " + s.schemaName + "." + s.catalogTableName
 	rows, err := s.pool.Query(query)
 	if err != nil {
 		s.logger.Fatal(
@@ -235,6 +271,7 @@ func (s *StreamCatalogMySQL) LoadStreamCatalog() (types.StreamUUIDList, error) {
 	// read the rows of the SQL table into the catalog of streams
 	var streamsUUIDs = make(types.StreamUUIDList, 0)
 	var strProperties string
+	var strPartitioning sql.NullString
 	var firstMsgId sql.NullInt64
 	var lastMsgId sql.NullInt64
 	var firstMsgTimestamp sql.NullTime
@@ -253,6 +290,7 @@ func (s *StreamCatalogMySQL) LoadStreamCatalog() (types.StreamUUIDList, error) {
 			&lastMsgTimestamp,
 			&info.LastUpdate,
 			&strProperties,
+			&strPartitioning,
 		); err != nil {
 			s.logger.Fatal(
 				"Can't read stream",
@@ -311,6 +349,22 @@ func (s *StreamCatalogMySQL) LoadStreamCatalog() (types.StreamUUIDList, error) {
 			return nil, err
 		}
 
+		if strPartitioning.Valid {
+			info.Partitioning = &types.StreamPartitioning{}
+			if err := json.Unmarshal([]byte(strPartitioning.String), info.Partitioning); err != nil {
+				s.logger.Fatal(
+					"Can't unmarshal partitioning from JSON",
+					zap.String("topic", "stream"),
+					zap.String("method", "LoadStreamCatalog"),
+					zap.String("schema", s.schemaName),
+					zap.String("table", s.catalogTableName),
+					zap.String("stream.uuid", info.UUID.String()),
+					zap.Error(err),
+				)
+				return nil, err
+			}
+		}
+
 		s.streams[info.UUID] = &info
 		streamsUUIDs = append(streamsUUIDs, info.UUID)
 	}
@@ -336,7 +390,7 @@ func (s *StreamCatalogMySQL) OnCreateStream(streamInfo *types.StreamInfo) error
 	}
 
 	// insert new stream into the catalog (in catalog SQL table)
-	query := "INSERT INTO " + s.schemaName + "." + s.catalogTableName + " (id, creation_date, last_update, properties) VALUES (?, ?, ?, ?)"
+	query := "INSERT INTO " + s.schemaName + "." + s.catalogTableName + " (id, creation_date, last_update, properties, partitioning) VALUES (?, ?, ?, ?, ?)"
 	propertiesJSON, err := json.Marshal(streamInfo.Properties)
 	if err != nil {
 		s.logger.Error(
@@ -350,12 +404,22 @@ func (s *StreamCatalogMySQL) OnCreateStream(streamInfo *types.StreamInfo) error
 		)
 		return err
 	}
+	partitioningJSON := sql.NullString{}
+	if streamInfo.Partitioning != nil {
+		bytes, err := json.Marshal(streamInfo.Partitioning)
+		if err != nil {
+			_ = transaction.Rollback()
+			return err
+		}
+		partitioningJSON = sql.NullString{String: string(bytes), Valid: true}
+	}
 	_, err = transaction.Exec(
 		query,
 		streamInfo.UUID,
 		streamInfo.CreationDate.Format(time.RFC3339),
 		streamInfo.LastUpdate.Format(time.RFC3339),
 		propertiesJSON,
+		partitioningJSON,
 	)
 	if err != nil {
 		s.logger.Error(
@@ -436,7 +500,7 @@ func (s *StreamCatalogMySQL) OnDeleteStream(streamUUID types.StreamUUID) error {
 	}
 
 	// Remove the stream SQL table
-	streamTableName := s.streamTablePrefix + streamUUID.String()
+	streamTableName := s.GetSQLStreamTable(streamUUID)
 	query = "DROP TABLE " + s.schemaName + "." + streamTableName
 	_, err = s.pool.Exec(query)
 	if err != nil {
@@ -138,7 +138,7 @@ func (idx *StreamIndexMySQL) GetStats() (*StreamIndexStats, error) {
 }
 
 func (idx *StreamIndexMySQL) GetFullTableName() string {
-	return idx.schemaName + ".stream_" + idx.streamUUID.String()
+	return idx.schemaName + "." + idx.tableName
 }
 
 func (idx *StreamIndexMySQL) GetFirstMessageId() (types.MessageId, error) {
@@ -205,8 +205,8 @@ func (idx *StreamIndexMySQL) GetMessageIdAfterMessageId(messageId types.MessageI
 	err := row.Scan(&nextMessageId)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			// no message id found after the given message id
-			return 0, errors.New("no message found")
+			// the given message id is the last one, therefore wait for the next message
+			return messageId + 1, nil
 		}
 
 		idx.logger.Error(
@@ -249,6 +249,32 @@ func (idx *StreamIndexMySQL) GetMessageIdAtTimestamp(timestamp *time.Time) (type
 	return nextMessageId, nil
 }
 
+func (idx *StreamIndexMySQL) GetMessageIdAtOrBeforeTimestamp(timestamp *time.Time) (types.MessageId, error) {
+	// find the last message id created at or before the given timestamp (read backward)
+	fullTableName := idx.GetFullTableName()
+	query := "SELECT `id` FROM " + fullTableName + " WHERE `timestamp` <= ? ORDER BY `id` DESC LIMIT 1"
+	row := idx.pool.QueryRow(query, timestamp)
+	var messageId types.MessageId
+	err := row.Scan(&messageId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// no message found at the exact timestamp or before the given timestamp
+			return 0, errors.New("no message found")
+		}
+
+		idx.logger.Error(
+			"Error while looking for timestamp",
+			zap.String("topic", "index"),
+			zap.String("method", "GetMessageIdAtOrBeforeTimestamp"),
+			zap.String("stream.uuid", idx.streamUUID.String()),
+			zap.Error(err),
+		)
+		return 0, err
+	}
+
+	return messageId, nil
+}
+
 func (idx *StreamIndexMySQL) Log() {
 	idx.logger.Info(
 		"StreamIndex",
@@ -22,6 +22,7 @@ type StreamIteratorHandlerMySQL struct {
 	bufferSize       int                      // number of records to read at once from the SQL database
 	bufferIndex      int                      // index of the next record to read from the buffer
 	bufferNextId     types.MessageId          // id of the next record to read from mysql into the buffer
+	backward         bool                     // true if the records are read from the newest to the oldest
 	schemaName       string                   // name of the SQL schema holding the stream data
 	streamTableName  string                   // name of the SQL table holding the stream data
 	pool             *sql.DB                  // connection pool to the SQL database
@@ -30,9 +31,9 @@ type StreamIteratorHandlerMySQL struct {
 }
 
 type rowMySQL struct {
-	Id           types.MessageId           `json:"i"`
-	CreationDate string                    `json:"d"`
-	Msg          types.DeferedStreamRecord `json:"m"`
+	Id           types.MessageId        `json:"i"`
+	CreationDate string                 `json:"d"`
+	Msg          map[string]interface{} `json:"m"` // the whole record (same format as the records read from the other storage providers)
 }
 
 func (h *StreamIteratorHandlerMySQL) Open() error {
@@ -53,22 +54,43 @@ func (h *StreamIteratorHandlerMySQL) Seek(request *types.StreamIteratorRequest)
 		return nil
 	}
 
-	switch request.IteratorType {
-	case "FIRST_MESSAGE":
-		nextRecordIdToRead, err = h.index.GetFirstMessageId()
-	case "LAST_MESSAGE":
-		nextRecordIdToRead, err = h.index.GetLastMessageId()
-	case "AFTER_LAST_MESSAGE":
-		nextRecordIdToRead, err = h.index.GetMessageIdAfterLastMessage()
-	case "AT_MESSAGE_ID":
-		nextRecordIdToRead, err = h.index.GetMessageId(request.MessageId)
-	case "AFTER_MESSAGE_ID":
-		nextRecordIdToRead, err = h.index.GetMessageIdAfterMessageId(request.MessageId)
-	case "AT_TIMESTAMP":
-		nextRecordIdToRead, err = h.index.GetMessageIdAtTimestamp(&request.Timestamp)
-	default:
-		nextRecordIdToRead = 0
-		err = errors.New("invalid iterator type")
+	if request.IsBackward() {
+		switch request.IteratorType {
+		case "FIRST_MESSAGE":
+			nextRecordIdToRead, err = h.index.GetFirstMessageId()
+		case "LAST_MESSAGE", "AFTER_LAST_MESSAGE":
+			nextRecordIdToRead, err = h.index.GetLastMessageId()
+		case "AT_MESSAGE_ID":
+			nextRecordIdToRead, err = h.index.GetMessageId(request.MessageId)
+		case "AFTER_MESSAGE_ID":
+			// the message before the given message id
+			if nextRecordIdToRead, err = h.index.GetMessageId(request.MessageId); err == nil {
+				nextRecordIdToRead--
+			}
+		case "AT_TIMESTAMP":
+			nextRecordIdToRead, err = h.index.GetMessageIdAtOrBeforeTimestamp(&request.Timestamp)
+		default:
+			err = errors.New("invalid iterator type")
+		}
+		h.backward = true
+	} else {
+		switch request.IteratorType {
+		case "FIRST_MESSAGE":
+			nextRecordIdToRead, err = h.index.GetFirstMessageId()
+		case "LAST_MESSAGE":
+			nextRecordIdToRead, err = h.index.GetLastMessageId()
+		case "AFTER_LAST_MESSAGE":
+			nextRecordIdToRead, err = h.index.GetMessageIdAfterLastMessage()
+		case "AT_MESSAGE_ID":
+			nextRecordIdToRead, err = h.index.GetMessageId(request.MessageId)
+		case "AFTER_MESSAGE_ID":
+			nextRecordIdToRead, err = h.index.GetMessageIdAfterMessageId(request.MessageId)
+		case "AT_TIMESTAMP":
+			nextRecordIdToRead, err = h.index.GetMessageIdAtTimestamp(&request.Timestamp)
+		default:
+			nextRecordIdToRead = 0
+			err = errors.New("invalid iterator type")
+		}
 	}
 
 	if err == nil {
@@ -108,7 +130,11 @@ func (h *StreamIteratorHandlerMySQL) GetNextRecord() (types.MessageId, interface
 	// return the next record from the buffer
 	record := h.buffer[h.bufferIndex]
 	h.bufferIndex++
-	h.nextRecordIdRead = record.Id + 1
+	if h.backward {
+		h.nextRecordIdRead = record.Id - 1
+	} else {
+		h.nextRecordIdRead = record.Id + 1
+	}
 
 	// result is: (valid record, record found, may continue, no error)
 	return record.Id, record.Msg, true, true, nil
@@ -121,6 +147,9 @@ func (h *StreamIteratorHandlerMySQL) fillBuffer() error {
 	defer h.mu.Unlock()
 
 	query := "SELECT `id`, `timestamp`, `message` FROM " + h.schemaName + "." + h.streamTableName + " WHERE `id` >= ? ORDER BY `id` ASC LIMIT ?"
+	if h.backward {
+		query = "SELECT `id`, `timestamp`, `message` FROM " + h.schemaName + "." + h.streamTableName + " WHERE `id` <= ? ORDER BY `id` DESC LIMIT ?"
+	}
 	rows, err := h.pool.Query(query, h.bufferNextId, h.bufferSize)
 	if err != nil {
 		h.logger.Error(
@@ -176,7 +205,11 @@ func (h *StreamIteratorHandlerMySQL) fillBuffer() error {
 		}
 
 		h.buffer = append(h.buffer, row)
-		h.bufferNextId = row.Id + 1
+		if h.backward {
+			h.bufferNextId = row.Id - 1
+		} else {
+			h.bufferNextId = row.Id + 1
+		}
 	}
 
 	return nil
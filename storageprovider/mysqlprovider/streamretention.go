@@ -0,0 +1,153 @@
+package mysqlprovider
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+func (w *StreamWriterMySQL) Trim(policy *types.RetentionPolicy, now time.Time) (types.Size64, error) {
+	// Remove the records that are out of the retention policy.
+	// The iterators read the records by id, therefore they continue from the new first record.
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.info.ReadableMessages.CptMessages == 0 {
+		return 0, nil
+	}
+
+	fullTableName := w.schemaName + "." + w.streamTableName
+	lastMsgId := w.info.ReadableMessages.LastMsgId
+	firstMsgIdToKeep := w.info.ReadableMessages.FirstMsgId
+
+	// message ids are contiguous
+	if policy.MaxRecords > 0 && w.info.ReadableMessages.CptMessages > policy.MaxRecords {
+		firstMsgIdToKeep = max(firstMsgIdToKeep, lastMsgId-policy.MaxRecords+1)
+	}
+
+	if policy.MaxAge > 0 {
+		query := "SELECT MIN(`id`) FROM " + fullTableName + " WHERE `timestamp` >= ?"
+		msgId, err := w.queryFirstMsgIdToKeep(query, now.Add(-policy.MaxAge))
+		if err != nil {
+			return 0, err
+		}
+		firstMsgIdToKeep = max(firstMsgIdToKeep, msgId)
+	}
+
+	if policy.MaxBytes > 0 {
+		query := "SELECT MIN(`id`) FROM (SELECT `id`, SUM(LENGTH(`message`)) OVER (ORDER BY `id` DESC) AS `tail_size` FROM " + fullTableName + ") AS t WHERE `tail_size` <= ?"
+		msgId, err := w.queryFirstMsgIdToKeep(query, policy.MaxBytes)
+		if err != nil {
+			return 0, err
+		}
+		firstMsgIdToKeep = max(firstMsgIdToKeep, msgId)
+	}
+
+	if firstMsgIdToKeep <= w.info.ReadableMessages.FirstMsgId {
+		return 0, nil
+	}
+
+	transaction, err := w.pool.Begin()
+	if err != nil {
+		w.logTrimError("can't start transaction", err)
+		return 0, err
+	}
+
+	var sizeInBytesRemoved sql.NullInt64
+	query := "SELECT SUM(LENGTH(`message`)) FROM " + fullTableName + " WHERE `id` < ?"
+	if err = transaction.QueryRow(query, firstMsgIdToKeep).Scan(&sizeInBytesRemoved); err != nil {
+		_ = transaction.Rollback()
+		w.logTrimError("can't compute size of records", err)
+		return 0, err
+	}
+
+	query = "DELETE FROM " + fullTableName + " WHERE `id` < ?"
+	result, err := transaction.Exec(query, firstMsgIdToKeep)
+	if err != nil {
+		_ = transaction.Rollback()
+		w.logTrimError("can't delete records", err)
+		return 0, err
+	}
+	cptRemoved, err := result.RowsAffected()
+	if err != nil {
+		_ = transaction.Rollback()
+		w.logTrimError("can't delete records", err)
+		return 0, err
+	}
+
+	var firstMsgId types.MessageId
+	var firstMsgTimestamp time.Time
+	query = "SELECT `id`, `timestamp` FROM " + fullTableName + " ORDER BY `id` ASC LIMIT 1"
+	if err = transaction.QueryRow(query).Scan(&firstMsgId, &firstMsgTimestamp); err != nil && err != sql.ErrNoRows {
+		_ = transaction.Rollback()
+		w.logTrimError("can't read first record", err)
+		return 0, err
+	}
+
+	readableMessages := w.info.ReadableMessages
+	readableMessages.TrimHead(types.Size64(cptRemoved), types.Size64(sizeInBytesRemoved.Int64), firstMsgId, firstMsgTimestamp)
+
+	if err = w.SaveMetaInfo(
+		transaction,
+		readableMessages.CptMessages,
+		readableMessages.SizeInBytes,
+		readableMessages.FirstMsgId,
+		readableMessages.LastMsgId,
+		readableMessages.FirstMsgTimestamp,
+		readableMessages.LastMsgTimestamp,
+	); err != nil {
+		_ = transaction.Rollback()
+		return 0, err
+	}
+
+	if err = transaction.Commit(); err != nil {
+		_ = transaction.Rollback()
+		w.logTrimError("can't commit transaction", err)
+		return 0, err
+	}
+
+	// update stream info after the transaction commit
+	w.info.ReadableMessages = readableMessages
+
+	w.logger.Info(
+		"Stream trimmed",
+		zap.String("topic", "stream"),
+		zap.String("method", "Trim"),
+		zap.String("stream.uuid", w.info.UUID.String()),
+		zap.Int64("records.removed", cptRemoved),
+		zap.Int64("bytes.removed", sizeInBytesRemoved.Int64),
+	)
+
+	return types.Size64(cptRemoved), nil
+}
+
+func (w *StreamWriterMySQL) queryFirstMsgIdToKeep(query string, args ...interface{}) (types.MessageId, error) {
+	// returns the message id given by the query or the id after the last message if the query returns NULL
+	var msgId sql.NullInt64
+	if err := w.pool.QueryRow(query, args...).Scan(&msgId); err != nil {
+		w.logTrimError("can't find records to remove", err)
+		return 0, err
+	}
+
+	if !msgId.Valid {
+		// all the records must be removed
+		return w.info.ReadableMessages.LastMsgId + 1, nil
+	}
+
+	return types.MessageId(msgId.Int64), nil
+}
+
+func (w *StreamWriterMySQL) logTrimError(msg string, err error) {
+	w.logger.Error(
+		msg,
+		zap.String("topic", "stream"),
+		zap.String("method", "Trim"),
+		zap.String("schema", w.schemaName),
+		zap.String("table", w.streamTableName),
+		zap.String("stream.uuid", w.info.UUID.String()),
+		zap.Error(err),
+	)
+}
@@ -0,0 +1,129 @@
+package mysqlprovider
+
+import (
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestTrimStreamMySQL(t *testing.T) {
+	// the rows of the stream table are kept in memory, the queries of the retention are answered from them
+	type row struct {
+		id        int64
+		timestamp time.Time
+		message   string
+	}
+	var (
+		mu   sync.Mutex
+		rows []row
+	)
+	db, pool := newFakeDB(t, func(query string, args []driver.NamedValue) (*fakeRows, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasPrefix(query, "SELECT MIN(`id`)") && strings.Contains(query, "`timestamp` >= ?"):
+			// first record within the maximum age
+			for _, r := range rows {
+				if !r.timestamp.Before(args[0].Value.(time.Time)) {
+					return &fakeRows{columns: []string{"id"}, values: [][]driver.Value{{r.id}}}, nil
+				}
+			}
+			return &fakeRows{columns: []string{"id"}, values: [][]driver.Value{{nil}}}, nil
+		case strings.HasPrefix(query, "SELECT MIN(`id`)") && strings.Contains(query, "`tail_size` <= ?"):
+			// first record of the tail within the maximum size
+			var firstId driver.Value
+			tailSize := int64(0)
+			for i := len(rows) - 1; i >= 0; i-- {
+				if tailSize += int64(len(rows[i].message)); tailSize > args[0].Value.(int64) {
+					break
+				}
+				firstId = rows[i].id
+			}
+			return &fakeRows{columns: []string{"id"}, values: [][]driver.Value{{firstId}}}, nil
+		case strings.HasPrefix(query, "SELECT SUM(LENGTH(`message`))"):
+			size := int64(0)
+			for _, r := range rows {
+				if r.id < args[0].Value.(int64) {
+					size += int64(len(r.message))
+				}
+			}
+			return &fakeRows{columns: []string{"size"}, values: [][]driver.Value{{size}}}, nil
+		case strings.HasPrefix(query, "SELECT `id`, `timestamp` FROM"):
+			result := &fakeRows{columns: []string{"id", "timestamp"}}
+			if len(rows) > 0 {
+				result.values = [][]driver.Value{{rows[0].id, rows[0].timestamp}}
+			}
+			return result, nil
+		}
+		return &fakeRows{}, nil
+	})
+	db.exec = func(query string, args []driver.NamedValue) int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasPrefix(query, "INSERT INTO"):
+			rows = append(rows, row{id: args[0].Value.(int64), timestamp: args[1].Value.(time.Time), message: args[2].Value.(string)})
+			return 1
+		case strings.HasPrefix(query, "DELETE FROM"):
+			cptRows := len(rows)
+			for len(rows) > 0 && rows[0].id < args[0].Value.(int64) {
+				rows = rows[1:]
+			}
+			return int64(cptRows - len(rows))
+		}
+		return 0
+	}
+
+	logger := zap.NewNop()
+	info := types.NewStreamInfo(uuid.New())
+	w := NewStreamWriterMySQL(info, "ministream", "catalog", "prefix_stream", pool, logger, 0)
+
+	// the record n is created at start + n seconds
+	start := time.Now()
+	records := make([]types.DeferedStreamRecord, 0, 10)
+	for i := 1; i <= 10; i++ {
+		records = append(records, types.DeferedStreamRecord{Id: types.MessageId(i), CreationDate: start.Add(time.Duration(i) * time.Second), Msg: map[string]interface{}{"n": i}})
+	}
+	if err := w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+
+	now := start.Add(10 * time.Second)
+	trim := func(policy types.RetentionPolicy, expectedCptRemoved types.Size64, expectedFirstMsgId types.MessageId) {
+		t.Helper()
+		cptRemoved, err := w.Trim(&policy, now)
+		if err != nil || cptRemoved != expectedCptRemoved {
+			t.Fatalf("expected %d records removed by %+v, got %d (%v)", expectedCptRemoved, policy, cptRemoved, err)
+		}
+		readable := info.ReadableMessages
+		if readable.FirstMsgId != expectedFirstMsgId || readable.LastMsgId != 10 || readable.CptMessages != types.Size64(11-expectedFirstMsgId) {
+			t.Fatalf("unexpected readable messages %+v after %+v", readable, policy)
+		}
+		if !readable.FirstMsgTimestamp.Equal(start.Add(time.Duration(expectedFirstMsgId) * time.Second)) {
+			t.Fatalf("unexpected first message timestamp %s after %+v", readable.FirstMsgTimestamp, policy)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		size := types.Size64(0)
+		for _, r := range rows {
+			size += types.Size64(len(r.message))
+		}
+		if readable.SizeInBytes != size {
+			t.Fatalf("expected %d bytes left, got %d", size, readable.SizeInBytes)
+		}
+	}
+	trim(types.RetentionPolicy{MaxRecords: 8}, 2, 3)
+	trim(types.RetentionPolicy{MaxRecords: 8}, 0, 3)
+	trim(types.RetentionPolicy{MaxAge: 5 * time.Second}, 2, 5)
+	mu.Lock()
+	tailSize := uint64(len(rows[len(rows)-1].message) + len(rows[len(rows)-2].message))
+	mu.Unlock()
+	trim(types.RetentionPolicy{MaxBytes: tailSize}, 4, 9)
+}
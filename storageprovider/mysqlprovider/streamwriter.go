@@ -2,6 +2,8 @@ package mysqlprovider
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -102,8 +104,9 @@ func (w *StreamWriterMySQL) Write(records *[]types.DeferedStreamRecord) error {
 				zap.Any("msg", record),
 				zap.Error(errMarshall),
 			)
-			// drop the record (normally it should never happen, but never say never)
-			return errMarshall
+			// the transaction is rolled back, the records are never written (normally it should never happen, but never say never)
+			_ = transaction.Rollback()
+			return fmt.Errorf("%w: %w", types.ErrRecordNotWritable, errMarshall)
 		}
 
 		// insert the record into the sql table
@@ -134,11 +137,13 @@ func (w *StreamWriterMySQL) Write(records *[]types.DeferedStreamRecord) error {
 
 	cptReadableRecords += insertedRecords
 
+	var firstMsgId = w.info.ReadableMessages.FirstMsgId
 	if w.info.ReadableMessages.CptMessages == 0 {
+		firstMsgId = (*records)[0].Id
 		firstMsgTimestamp = (*records)[0].CreationDate
 	}
 
-	if err = w.SaveMetaInfo(transaction, cptReadableRecords, w.info.ReadableMessages.SizeInBytes+accumulatedSizeInBytes, w.info.IngestedMessages.FirstMsgId, lastMsgId, firstMsgTimestamp, lastMsgTimestamp); err != nil {
+	if err = w.SaveMetaInfo(transaction, cptReadableRecords, w.info.ReadableMessages.SizeInBytes+accumulatedSizeInBytes, firstMsgId, lastMsgId, firstMsgTimestamp, lastMsgTimestamp); err != nil {
 		return err
 	}
 
@@ -160,8 +165,8 @@ func (w *StreamWriterMySQL) Write(records *[]types.DeferedStreamRecord) error {
 	// update stream info
 	// (update is done after the transaction commit to ensure that the data is really written)
 	if w.info.ReadableMessages.CptMessages == 0 {
-		// first message ever of the stream
-		w.info.ReadableMessages.FirstMsgId = w.info.IngestedMessages.FirstMsgId
+		// first message ever of the stream (or the stream was emptied by the retention policy)
+		w.info.ReadableMessages.FirstMsgId = firstMsgId
 		w.info.ReadableMessages.LastMsgId = 0
 		w.info.ReadableMessages.FirstMsgTimestamp = (*records)[0].CreationDate
 	}
@@ -184,6 +189,21 @@ func (w *StreamWriterMySQL) Write(records *[]types.DeferedStreamRecord) error {
 	return nil
 }
 
+func (w *StreamWriterMySQL) Sync() error {
+	// the records are written within a committed transaction, the database is in charge of their durability
+	return nil
+}
+
+func (w *StreamWriterMySQL) Reencrypt() (types.Size64, error) {
+	// the encryption at rest is in charge of the database
+	return 0, errors.New("re-encryption is not supported by the MySQL storage")
+}
+
+func (w *StreamWriterMySQL) Scrub() (*types.StreamScrubReport, error) {
+	// the records are verified by the database (page checksums), therefore there is nothing to verify
+	return types.NewStreamScrubReport(), nil
+}
+
 func (w *StreamWriterMySQL) SaveMetaInfo(transaction *sql.Tx, cptMessages types.Size64, sizeInBytes types.Size64, firstMsgId types.MessageId, lastMsgId types.MessageId, firstMsgTimestamp time.Time, lastMsgTimestamp time.Time) error {
 	streamUUID := w.info.UUID.String()
 	if w.logVerbosity > 0 {
@@ -0,0 +1,116 @@
+package mysqlprovider
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestStreamWriterMySQL_RecordHeaders(t *testing.T) {
+	// the rows inserted by the writer are returned by the queries of the iterator and of the lookup of the records
+	var (
+		mu   sync.Mutex
+		rows [][]driver.Value // id, timestamp, message
+	)
+	db, pool := newFakeDB(t, func(query string, args []driver.NamedValue) (*fakeRows, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(query, "SELECT `id`, `timestamp`, `message` FROM") {
+			// the iterator reads the rows from the given id
+			result := &fakeRows{columns: []string{"id", "timestamp", "message"}}
+			for _, row := range rows {
+				if row[0].(int64) >= args[0].Value.(int64) {
+					result.values = append(result.values, row)
+				}
+			}
+			return result, nil
+		}
+		result := &fakeRows{columns: []string{"id", "message"}}
+		for _, row := range rows {
+			result.values = append(result.values, []driver.Value{row[0], row[2]})
+		}
+		return result, nil
+	})
+	db.exec = func(query string, args []driver.NamedValue) int64 {
+		if !strings.HasPrefix(query, "INSERT INTO") {
+			return 0
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		rows = append(rows, []driver.Value{args[0].Value, args[1].Value, args[2].Value})
+		return 1
+	}
+	logger := zap.NewNop()
+	mysqlStorage := &MySQLStorage{
+		logger: logger,
+		pool:   pool,
+		mysqlConfig: &MySQLConfig{
+			SchemaName:        "ministream",
+			CatalogTableName:  "catalog",
+			StreamTablePrefix: "prefix_",
+		},
+	}
+	info := types.NewStreamInfo(uuid.New())
+	streamTableName := mysqlStorage.getStreamTableName(info.UUID)
+	w := NewStreamWriterMySQL(info, "ministream", "catalog", streamTableName, pool, logger, 0)
+
+	// the even records have headers
+	records := make([]types.DeferedStreamRecord, 0, 4)
+	for i := 1; i <= 4; i++ {
+		record := types.DeferedStreamRecord{Id: types.MessageId(i), CreationDate: time.Now(), Msg: map[string]interface{}{"n": i}}
+		if i%2 == 0 {
+			record.Headers = types.RecordHeaders{"content-type": "application/json", "trace.id": strconv.Itoa(i)}
+		}
+		records = append(records, record)
+	}
+	if err := w.Write(&records); err != nil {
+		t.Fatalf("could not write records: %v", err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 rows inserted, got %d", len(rows))
+	}
+
+	expectHeaders := func(record interface{}, msgId types.MessageId) {
+		headers, found := record.(map[string]interface{})["h"]
+		if msgId%2 == 1 {
+			if found {
+				t.Fatalf("expected no headers for message %d, got %v", msgId, headers)
+			}
+			return
+		}
+		expected := map[string]interface{}{"content-type": "application/json", "trace.id": strconv.Itoa(int(msgId))}
+		if !reflect.DeepEqual(headers, expected) {
+			t.Fatalf("expected headers %v for message %d, got %v", expected, msgId, headers)
+		}
+	}
+
+	idx := NewStreamIndex(info.UUID, info, "ministream", streamTableName, pool, logger)
+	it := NewStreamIteratorHandlerMySQL(info.UUID, uuid.New(), idx, "ministream", streamTableName, pool, 10, logger)
+	if err := it.Seek(&types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE"}); err != nil {
+		t.Fatalf("could not seek iterator: %v", err)
+	}
+	for expectedId := types.MessageId(1); expectedId <= 4; expectedId++ {
+		msgId, record, found, _, err := it.GetNextRecord()
+		if err != nil || !found || msgId != expectedId {
+			t.Fatalf("expected message %d, got %d (found %t, %v)", expectedId, msgId, found, err)
+		}
+		expectHeaders(record, msgId)
+	}
+	messageIds := []types.MessageId{1, 2, 3, 4}
+	found, err := mysqlStorage.GetRecordsByIds(info.UUID, messageIds)
+	if err != nil {
+		t.Fatalf("could not read records: %v", err)
+	}
+	for i, record := range found {
+		expectHeaders(record, messageIds[i])
+	}
+}
@@ -16,6 +16,16 @@ type IStorageProvider interface {
 	GetStreamInfo(streamUUID types.StreamUUID) (*types.StreamInfo, error)
 	BuildIndex(streamUUID types.StreamUUID) (interface{}, error)
 	NewStreamIteratorHandler(streamUUID types.StreamUUID, iteratorUUID types.StreamIteratorUUID) (types.IStreamIteratorHandler, error)
+	GetRecordsByIds(streamUUID types.StreamUUID, messageIds []types.MessageId) ([]interface{}, error)
+	CopyRecords(sourceUUID types.StreamUUID, target *types.StreamInfo, lastMsgId types.MessageId) error
 	NewStreamWriter(*types.StreamInfo) (buffering.IStreamWriter, error)
 	DeleteStream(streamUUID types.StreamUUID) error
+	LoadConsumerGroups(streamUUID types.StreamUUID) (types.ConsumerGroupList, error)
+	SaveConsumerGroup(group *types.ConsumerGroup) error
+	DeleteConsumerGroup(streamUUID types.StreamUUID, name string) error
+	LoadStreamSchemas(streamUUID types.StreamUUID) (types.StreamSchemaList, error)
+	SaveStreamSchema(schema *types.StreamSchema) error
+	DeleteStreamSchemas(streamUUID types.StreamUUID) error
+	LoadProducers(streamUUID types.StreamUUID) (types.ProducerList, error)
+	SaveProducer(producer *types.Producer) error
 }
@@ -0,0 +1,85 @@
+package stream
+
+import (
+	"github.com/nbigot/ministream/types"
+)
+
+// Attribute of the record envelope holding the partition of the records read from all the partitions of a stream,
+// next to the id ("i"), the creation date ("d") and the headers ("h"): the message ("m") is never modified
+const RecordPartitionKey = "p"
+
+type PartitionsIteratorHandler struct {
+	// implements IStreamIteratorHandler interface
+	// read the records of all the partitions in turn (round robin), the records of each partition stay ordered
+	handlers      []types.IStreamIteratorHandler
+	nextPartition int
+}
+
+func (h *PartitionsIteratorHandler) Open() error {
+	for _, handler := range h.handlers {
+		if err := handler.Open(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *PartitionsIteratorHandler) Close() error {
+	var err error
+	for _, handler := range h.handlers {
+		if errClose := handler.Close(); errClose != nil {
+			err = errClose
+		}
+	}
+	return err
+}
+
+func (h *PartitionsIteratorHandler) Seek(request *types.StreamIteratorRequest) error {
+	for _, handler := range h.handlers {
+		if err := handler.Seek(request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *PartitionsIteratorHandler) SaveSeek() error {
+	for _, handler := range h.handlers {
+		if err := handler.SaveSeek(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *PartitionsIteratorHandler) GetNextRecord() (types.MessageId, interface{}, bool, bool, error) {
+	// a busy partition can't starve the others
+	cptPartitions := len(h.handlers)
+	for i := 0; i < cptPartitions; i++ {
+		partition := (h.nextPartition + i) % cptPartitions
+		recordId, record, foundRecord, canContinue, err := h.handlers[partition].GetNextRecord()
+		if !foundRecord {
+			if err != nil {
+				// the partition can't be read
+				h.nextPartition = (partition + 1) % cptPartitions
+				return 0, nil, false, canContinue, err
+			}
+			continue
+		}
+
+		h.nextPartition = (partition + 1) % cptPartitions
+		if envelope, ok := record.(map[string]interface{}); ok {
+			if _, isEnvelope := envelope["i"]; isEnvelope {
+				envelope[RecordPartitionKey] = partition
+			}
+		}
+		return recordId, record, true, canContinue, err
+	}
+
+	// result is: (no record, no record found, cannot continue, no error)
+	return 0, nil, false, false, nil
+}
+
+func NewPartitionsIteratorHandler(handlers []types.IStreamIteratorHandler) *PartitionsIteratorHandler {
+	return &PartitionsIteratorHandler{handlers: handlers, nextPartition: 0}
+}
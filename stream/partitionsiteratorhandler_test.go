@@ -0,0 +1,52 @@
+package stream
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbigot/ministream/types"
+)
+
+type testIteratorHandler struct {
+	records []map[string]interface{}
+	err     error // returned once all the records are read
+}
+
+func (h *testIteratorHandler) Open() error                                     { return nil }
+func (h *testIteratorHandler) Close() error                                    { return nil }
+func (h *testIteratorHandler) Seek(request *types.StreamIteratorRequest) error { return nil }
+func (h *testIteratorHandler) SaveSeek() error                                 { return nil }
+
+func (h *testIteratorHandler) GetNextRecord() (types.MessageId, interface{}, bool, bool, error) {
+	if len(h.records) == 0 {
+		return 0, nil, false, true, h.err
+	}
+	record := h.records[0]
+	h.records = h.records[1:]
+	return record["i"].(types.MessageId), record, true, true, nil
+}
+
+func TestPartitionsIteratorHandler(t *testing.T) {
+	errRead := errors.New("can't read partition")
+	handler := NewPartitionsIteratorHandler([]types.IStreamIteratorHandler{
+		&testIteratorHandler{records: []map[string]interface{}{
+			{"i": types.MessageId(1), "m": map[string]interface{}{"p": "message"}},
+		}},
+		&testIteratorHandler{err: errRead},
+	})
+
+	// the partition is set next to the id, the message is not modified
+	_, record, found, _, err := handler.GetNextRecord()
+	if !found || err != nil {
+		t.Fatalf("expected a record, got %v", err)
+	}
+	envelope := record.(map[string]interface{})
+	if envelope[RecordPartitionKey] != 0 || envelope["m"].(map[string]interface{})["p"] != "message" {
+		t.Fatalf("unexpected record %v", record)
+	}
+
+	// the error of a partition that can't be read is returned
+	if _, _, found, _, err = handler.GetNextRecord(); found || !errors.Is(err, errRead) {
+		t.Fatalf("expected the error of the partition, got %v", err)
+	}
+}
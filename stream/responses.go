@@ -1,6 +1,8 @@
 package stream
 
 import (
+	"time"
+
 	"github.com/nbigot/ministream/types"
 )
 
@@ -11,12 +13,33 @@ type GetStreamRecordsResponse struct {
 	CountErrors        int64                    `json:"countErrors"`
 	CountSkipped       int64                    `json:"countSkipped"`
 	Remain             bool                     `json:"remain"`
+	Ended              bool                     `json:"ended"` // the end of the range of the iterator has been reached, no more record will ever be read
 	LastRecordIdRead   types.MessageId          `json:"lastRecordIdRead"`
 	StreamUUID         types.StreamUUID         `json:"streamUUID"`
 	StreamIteratorUUID types.StreamIteratorUUID `json:"streamIteratorUUID"`
 	Records            []interface{}            `json:"records"`
 }
 
+type GetRecordsByIdsResponse struct {
+	Status     string            `json:"status"`
+	StreamUUID types.StreamUUID  `json:"streamUUID"`
+	Count      int64             `json:"count"`
+	Records    []interface{}     `json:"records"`  // records found (in the order of the message ids requested)
+	NotFound   []types.MessageId `json:"notFound"` // message ids of the records not found
+}
+
+type QueryStreamResponse struct {
+	Status           string                 `json:"status"`
+	Duration         int64                  `json:"duration"`
+	StreamUUID       types.StreamUUID       `json:"streamUUID"`
+	RecordsScanned   int64                  `json:"recordsScanned"`
+	RecordsMatched   int64                  `json:"recordsMatched"`
+	CountErrors      int64                  `json:"countErrors"`
+	Complete         bool                   `json:"complete"` // false if a limit has stopped the scan before the end of the range
+	LastRecordIdRead types.MessageId        `json:"lastRecordIdRead"`
+	Results          map[string]interface{} `json:"results"` // results of the aggregations by name
+}
+
 type CreateRecordsIteratorResponse struct {
 	Status             string                   `json:"status"`
 	Message            string                   `json:"message"`
@@ -38,14 +61,78 @@ type GetRecordsIteratorStatsResponse struct {
 	StreamIteratorUUID types.StreamIteratorUUID `json:"streamIteratorUUID"`
 	LastRecordIdRead   types.MessageId          `json:"lastRecordIdRead"`
 	Name               string                   `json:"name"`
+	ConsumerGroup      string                   `json:"consumerGroup"`
+	CreationDate       time.Time                `json:"creationDate"`
+	LastAccess         time.Time                `json:"lastAccess"`
+	ExpiresAt          *time.Time               `json:"expiresAt,omitempty"`
 }
 
 type PutStreamRecordsResponse struct {
-	Status     string            `json:"status"`
-	StreamUUID types.StreamUUID  `json:"streamUUID"`
-	Duration   int64             `json:"duration"`
-	Count      int64             `json:"count"`
-	MessageIds []types.MessageId `json:"messageIds"`
+	Status        string                       `json:"status"`
+	StreamUUID    types.StreamUUID             `json:"streamUUID"`
+	Duration      int64                        `json:"duration"`
+	Count         int64                        `json:"count"`
+	MessageIds    []types.MessageId            `json:"messageIds"`
+	Partition     *int                         `json:"partition,omitempty"`     // partition where the records were put (partitioned stream only)
+	SchemaVersion int                          `json:"schemaVersion,omitempty"` // version of the schema the records were validated against
+	CountDropped  int64                        `json:"countDropped,omitempty"`  // records dropped by the ingest pipeline
+	CountErrors   int64                        `json:"countErrors,omitempty"`   // records rejected by the ingest pipeline
+	CountShed     int64                        `json:"countShed,omitempty"`     // records shed because the ingest buffer of the stream was full
+	Errors        []*IngestPipelineRecordError `json:"errors,omitempty"`
+	Duplicate     bool                         `json:"duplicate,omitempty"`  // the batch of the idempotent producer has already been put (nothing is put)
+	Durability    string                       `json:"durability,omitempty"` // durability level of the records acknowledged
+}
+
+func (r *PutStreamRecordsResponse) SetIngestPipelineReport(report *IngestPipelineReport) {
+	// the records dropped or rejected by the ingest pipeline (or shed) are not saved into the stream
+	if report == nil {
+		return
+	}
+	r.CountDropped = report.CountDropped
+	r.CountErrors = report.CountErrors
+	r.CountShed = report.CountShed
+	r.Errors = report.Errors
+}
+
+func (r *PutStreamRecordsResponse) SetDuplicateProducerBatch(batch *types.ProducerBatch) {
+	// a retried batch of an idempotent producer returns the message ids of the original batch
+	r.Duplicate = true
+	r.MessageIds = batch.MessageIds
+	r.Partition = batch.Partition
+	r.Count = batch.CountPut()
+}
+
+type GetStreamIngestStatsResponse struct {
+	Status     string           `json:"status"`
+	StreamUUID types.StreamUUID `json:"streamUUID"`
+	Ingest     *IngestStats     `json:"ingest"`
+}
+
+type ListConsumerGroupsResponse struct {
+	Status         string                  `json:"status"`
+	StreamUUID     types.StreamUUID        `json:"streamUUID"`
+	ConsumerGroups types.ConsumerGroupList `json:"consumerGroups"`
+}
+
+type ConsumerGroupResponse struct {
+	Status        string               `json:"status"`
+	Message       string               `json:"message"`
+	StreamUUID    types.StreamUUID     `json:"streamUUID"`
+	ConsumerGroup *types.ConsumerGroup `json:"consumerGroup"`
+}
+
+type ListStreamSchemasResponse struct {
+	Status        string                 `json:"status"`
+	StreamUUID    types.StreamUUID       `json:"streamUUID"`
+	Compatibility string                 `json:"compatibility"`
+	Schemas       types.StreamSchemaList `json:"schemas"`
+}
+
+type StreamSchemaResponse struct {
+	Status     string              `json:"status"`
+	Message    string              `json:"message"`
+	StreamUUID types.StreamUUID    `json:"streamUUID"`
+	Schema     *types.StreamSchema `json:"schema"`
 }
 
 type LoginAccountResponse struct {
@@ -67,3 +154,35 @@ type RebuildStreamIndexResponse struct {
 	Duration   int64            `json:"duration"`
 	IndexStats interface{}      `json:"indexStats"`
 }
+
+type ReencryptStreamResponse struct {
+	Status              string           `json:"status"`
+	Message             string           `json:"message"`
+	StreamUUID          types.StreamUUID `json:"streamUUID"`
+	Duration            int64            `json:"duration"`
+	CptRecordsRewritten types.Size64     `json:"cptRecordsRewritten"`
+}
+
+type ScrubStreamResponse struct {
+	Status      string                   `json:"status"`
+	Message     string                   `json:"message"`
+	StreamUUID  types.StreamUUID         `json:"streamUUID"`
+	Duration    int64                    `json:"duration"`
+	ScrubReport *types.StreamScrubReport `json:"scrubReport"`
+}
+
+type ImportStreamResponse struct {
+	Status           string           `json:"status"`
+	Message          string           `json:"message"`
+	StreamUUID       types.StreamUUID `json:"streamUUID"`
+	SourceStreamUUID types.StreamUUID `json:"sourceStreamUUID"` // UUID of the exported stream
+	CountRecords     int64            `json:"countRecords"`
+}
+
+type CloneStreamResponse struct {
+	Status           string           `json:"status"`
+	Message          string           `json:"message"`
+	StreamUUID       types.StreamUUID `json:"streamUUID"`
+	SourceStreamUUID types.StreamUUID `json:"sourceStreamUUID"` // UUID of the cloned stream
+	CountRecords     int64            `json:"countRecords"`
+}
@@ -1,9 +1,12 @@
 package stream
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nbigot/ministream/buffering"
@@ -11,6 +14,7 @@ import (
 
 	"github.com/dustin/go-humanize"
 	"github.com/itchyny/gojq"
+	"github.com/qri-io/jsonschema"
 	"github.com/valyala/fasthttp"
 	"go.uber.org/zap"
 )
@@ -21,15 +25,44 @@ const STREAM_STATE_RUNNING = 2
 const STREAM_STATE_STOPPING = 3
 
 type Stream struct {
-	info         *types.StreamInfo
-	logger       *zap.Logger
-	logVerbosity int
-	iterators    StreamIteratorMap
-	ingestBuffer *buffering.StreamIngestBuffer
-	muIncMsgId   sync.Mutex
-	done         chan struct{}
-	wg           sync.WaitGroup
-	state        int
+	info                *types.StreamInfo
+	logger              *zap.Logger
+	logVerbosity        int
+	iterators           StreamIteratorMap
+	muIterators         sync.RWMutex
+	iteratorIdleTTL     time.Duration
+	iteratorMaxTTL      time.Duration
+	retentionPolicy     types.RetentionPolicy
+	retentionPeriod     time.Duration
+	ingestBuffer        *buffering.StreamIngestBuffer
+	partitions          []*Stream     // partitions of a partitioned stream
+	parent              *Stream       // partitioned stream of a partition
+	nextPartition       atomic.Uint32 // round robin of the records put without partition key
+	ingestPipeline      atomic.Pointer[types.IngestPipeline]
+	deadLetterResolver  DeadLetterStreamResolver
+	unsentDeadLetters   []*types.DeadLetter // records the storage provider can't write, waiting for room in the dead-letter stream
+	muUnsentDeadLetters sync.Mutex
+	deadLettersReady    chan struct{}
+	consumerGroups      map[string]*types.ConsumerGroup
+	muConsumerGroups    sync.Mutex
+	schemas             types.StreamSchemaList // registered versions of the schema of the records
+	compiledSchemas     map[int]*jsonschema.Schema
+	schemaCompatibility string
+	muSchemas           sync.RWMutex
+	producers           map[string]*types.Producer // idempotent producers
+	producerLocks       map[string]*producerLock   // the batches of a producer are put one at a time
+	unsavedProducers    map[string]bool            // producers whose last batch is put but not saved yet
+	muProducers         sync.Mutex
+	backpressure        types.Backpressure
+	durability          string
+	durabilityTimeout   time.Duration
+	cptRejectedRecords  atomic.Uint64 // records rejected because the ingest buffer was full
+	cptShedRecords      atomic.Uint64 // records shed because the ingest buffer was full
+	muIncMsgId          sync.Mutex
+	done                chan struct{}
+	writerStopped       chan struct{} // closed once Run is finished (the ingest buffer is saved)
+	wg                  sync.WaitGroup
+	state               atomic.Int32 // read by the readers and writers while the stream starts or stops
 }
 
 func (s *Stream) setState(state int) {
@@ -55,21 +88,24 @@ func (s *Stream) setState(state int) {
 		)
 	}
 
-	s.state = state
+	s.state.Store(int32(state))
 }
 
 func (s *Stream) Start() error {
-	if s.state != STREAM_STATE_NONE {
+	if s.state.Load() != STREAM_STATE_NONE {
 		return errors.New("stream state is already started")
 	}
 	s.setState(STREAM_STATE_STARTING)
 	s.startDeferedSaveTimer()
+	s.startDeadLetterSender()
+	s.startIteratorsReaper()
+	s.startRetentionTimer()
 	s.setState(STREAM_STATE_RUNNING)
 	return nil
 }
 
 func (s *Stream) Close() error {
-	if s.state != STREAM_STATE_RUNNING {
+	if s.state.Load() != STREAM_STATE_RUNNING {
 		return errors.New("stream state is not running")
 	}
 	s.setState(STREAM_STATE_STOPPING)
@@ -83,12 +119,18 @@ func (s *Stream) Close() error {
 }
 
 func (s *Stream) AddIterator(it *StreamIterator) error {
-	if s.state != STREAM_STATE_RUNNING {
+	if s.state.Load() != STREAM_STATE_RUNNING {
 		return errors.New("stream state is not running")
 	}
 
 	itUUID := it.GetUUID()
+	it.SetTimeouts(s.iteratorIdleTTL, s.iteratorMaxTTL)
+	if s.ingestBuffer != nil {
+		it.SetNotifier(s.ingestBuffer.GetNotifier(), s.done)
+	}
+	s.muIterators.Lock()
 	s.iterators[itUUID] = it
+	s.muIterators.Unlock()
 	s.logger.Info(
 		"Add stream iterator",
 		zap.String("topic", "stream"),
@@ -107,8 +149,12 @@ func (s *Stream) CloseIterators() error {
 		zap.String("stream.uuid", s.info.UUID.String()),
 	)
 
+	s.muIterators.Lock()
+	defer s.muIterators.Unlock()
+
+	// an iterator reading records is closed by its reader once done
 	for _, it := range s.iterators {
-		if err := it.Close(); err != nil {
+		if err := it.CloseWhenIdle(); err != nil {
 			return err
 		}
 	}
@@ -121,6 +167,8 @@ func (s *Stream) CloseIterators() error {
 func (s *Stream) CloseIterator(iterUUID types.StreamIteratorUUID) error {
 	var it *StreamIterator
 	var found bool
+	s.muIterators.Lock()
+	defer s.muIterators.Unlock()
 	if it, found = s.iterators[iterUUID]; !found {
 		// maybe the iterator has timed out or has been already be deleted
 		return errors.New("iterator not found")
@@ -134,18 +182,14 @@ func (s *Stream) CloseIterator(iterUUID types.StreamIteratorUUID) error {
 		zap.String("it.uuid", iterUUID.String()),
 	)
 
-	// close the iterator
-	if err := it.Close(); err != nil {
-		return err
-	}
-
-	// clean
+	// the iterator can't be read anymore, it is closed by its reader if it is reading records
 	delete(s.iterators, iterUUID)
-	it = nil
-	return nil
+	return it.CloseWhenIdle()
 }
 
 func (s *Stream) GetIterator(iterUUID types.StreamIteratorUUID) (*StreamIterator, error) {
+	s.muIterators.RLock()
+	defer s.muIterators.RUnlock()
 	if it, found := s.iterators[iterUUID]; !found {
 		return nil, fmt.Errorf("iterator not found: %s", iterUUID.String())
 	} else {
@@ -153,51 +197,109 @@ func (s *Stream) GetIterator(iterUUID types.StreamIteratorUUID) (*StreamIterator
 	}
 }
 
-func (s *Stream) GetRecords(c *fasthttp.RequestCtx, iterUUID types.StreamIteratorUUID, maxRecords uint) (*GetStreamRecordsResponse, error) {
-	if s.state != STREAM_STATE_RUNNING {
+func (s *Stream) GetRecords(ctx context.Context, iterUUID types.StreamIteratorUUID, maxRecords uint) (*GetStreamRecordsResponse, error) {
+	it, err := s.getReadableIterator(iterUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return it.GetRecords(ctx, maxRecords)
+}
+
+func (s *Stream) GetRecordsWithoutWait(ctx context.Context, iterUUID types.StreamIteratorUUID, maxRecords uint) (*GetStreamRecordsResponse, error) {
+	it, err := s.getReadableIterator(iterUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return it.GetRecordsWithoutWait(ctx, maxRecords)
+}
+
+func (s *Stream) getReadableIterator(iterUUID types.StreamIteratorUUID) (*StreamIterator, error) {
+	if s.state.Load() != STREAM_STATE_RUNNING {
 		return nil, errors.New("stream state is not running")
 	}
 
-	if it, found := s.iterators[iterUUID]; !found {
+	s.muIterators.RLock()
+	it, found := s.iterators[iterUUID]
+	s.muIterators.RUnlock()
+	if !found {
 		// maybe the iterator has timed out and be deleted
 		return nil, errors.New("iterator not found")
-	} else {
-		return it.GetRecords(c, maxRecords)
 	}
+
+	return it, nil
 }
 
-func (s *Stream) PutMessage(c *fasthttp.RequestCtx, message map[string]interface{}) (types.MessageId, error) {
-	if s.state != STREAM_STATE_RUNNING {
-		return 0, errors.New("stream state is not running")
+func (s *Stream) PutMessage(c *fasthttp.RequestCtx, message map[string]interface{}, headers types.RecordHeaders) (types.MessageId, *IngestPipelineReport, error) {
+	// the message id is 0 if the message has been dropped or rejected by the ingest pipeline
+	if s.state.Load() != STREAM_STATE_RUNNING {
+		return 0, nil, errors.New("stream state is not running")
 	}
-	s.muIncMsgId.Lock()
-	now := time.Now()
-	if s.info.IngestedMessages.CptMessages == 0 {
-		// first message ever of the stream
-		s.info.IngestedMessages.FirstMsgId = 1
-		s.info.IngestedMessages.LastMsgId = 0
-		s.info.IngestedMessages.FirstMsgTimestamp = now
+	if s.IsPartitioned() {
+		return 0, nil, errors.New("stream is partitioned, records must be put into a partition")
 	}
-	s.info.IngestedMessages.LastMsgTimestamp = now
-	s.info.IngestedMessages.LastMsgId += 1
-	s.info.IngestedMessages.CptMessages += 1
-	s.info.IngestedMessages.SizeInBytes += uint64(len(fmt.Sprintf("%v", message)))
-	msgId := s.info.IngestedMessages.LastMsgId
-	s.ingestBuffer.PutMessage(msgId, now, message)
-	s.muIncMsgId.Unlock()
-	return msgId, nil
+	records, keep, report := s.applyIngestPipeline([]interface{}{message}, []types.RecordHeaders{headers})
+	msgIds, cptShed, err := s.putRecords(records, keep, []types.RecordHeaders{headers})
+	if err != nil {
+		return 0, report, err
+	}
+	return msgIds[0], report.addShed(cptShed), nil
 }
 
-func (s *Stream) PutMessages(c *fasthttp.RequestCtx, records []interface{}) ([]types.MessageId, error) {
-	if s.state != STREAM_STATE_RUNNING {
-		return nil, errors.New("stream state is not running")
+func (s *Stream) PutMessages(c *fasthttp.RequestCtx, records []interface{}, headers []types.RecordHeaders) ([]types.MessageId, *IngestPipelineReport, error) {
+	// headers is either nil or holds the headers of each record (same order),
+	// the message id of a record is 0 if it has been dropped or rejected by the ingest pipeline
+	if s.state.Load() != STREAM_STATE_RUNNING {
+		return nil, nil, errors.New("stream state is not running")
+	}
+	if s.IsPartitioned() {
+		return nil, nil, errors.New("stream is partitioned, records must be put into a partition")
+	}
+	if len(records) == 0 {
+		return nil, nil, errors.New("no records to ingest")
+	}
+	if headers != nil && len(headers) != len(records) {
+		return nil, nil, errors.New("headers count does not match records count")
+	}
+	records, keep, report := s.applyIngestPipeline(records, headers)
+	msgIds, cptShed, err := s.putRecords(records, keep, headers)
+	return msgIds, report.addShed(cptShed), err
+}
+
+func (s *Stream) putRecords(records []interface{}, keep []bool, headers []types.RecordHeaders) ([]types.MessageId, int64, error) {
+	// Give a message id to the records and send them to the ingest buffer, returns the number of records shed.
+	// keep is either nil (all the records are saved) or tells whether each record must be saved (message id 0 otherwise).
+	return s.putRecordsWithBackpressure(records, keep, headers, nil)
+}
+
+func (s *Stream) putRecordsWithBackpressure(records []interface{}, keep []bool, headers []types.RecordHeaders, backpressure *types.Backpressure) ([]types.MessageId, int64, error) {
+	// same as putRecords, the backpressure (if not nil) is applied instead of the backpressure of the stream
+	if s.state.Load() != STREAM_STATE_RUNNING {
+		return nil, 0, errors.New("stream state is not running")
 	}
 	cptRecords := len(records)
-	if cptRecords == 0 {
-		return nil, errors.New("no records to ingest")
+	if keep != nil {
+		cptRecords = 0
+		for _, kept := range keep {
+			if kept {
+				cptRecords++
+			}
+		}
 	}
-	msgIds := make([]types.MessageId, cptRecords)
+
+	msgIds := make([]types.MessageId, len(records))
+	startTime := time.Now()
 	s.muIncMsgId.Lock()
+	defer s.muIncMsgId.Unlock()
+
+	// the ingest buffer may be full when the storage can't keep up with the producers (backpressure)
+	room, err := s.waitForIngestBufferRoom(cptRecords, startTime, backpressure)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var cptShed int64 = 0
 	now := time.Now()
 	if s.info.IngestedMessages.CptMessages == 0 {
 		// first message ever of the stream
@@ -206,22 +308,36 @@ func (s *Stream) PutMessages(c *fasthttp.RequestCtx, records []interface{}) ([]t
 		s.info.IngestedMessages.FirstMsgTimestamp = now
 	}
 	for i, message := range records {
+		if keep != nil && !keep[i] {
+			continue
+		}
+		if room == 0 {
+			// shed: the record is dropped (message id 0)
+			cptShed++
+			continue
+		}
+		room--
 		s.info.IngestedMessages.LastMsgTimestamp = now
 		s.info.IngestedMessages.LastMsgId += 1
 		s.info.IngestedMessages.CptMessages += 1
-		s.info.IngestedMessages.SizeInBytes += uint64(len(fmt.Sprintf("%v", message)))
+		sizeInBytes := uint64(len(fmt.Sprintf("%v", message)))
+		s.info.IngestedMessages.SizeInBytes += sizeInBytes
 		msgId := s.info.IngestedMessages.LastMsgId
 		msgIds[i] = msgId
-		s.ingestBuffer.PutMessage(msgId, now, message)
+		var recordHeaders types.RecordHeaders
+		if headers != nil {
+			recordHeaders = headers[i]
+		}
+		s.ingestBuffer.PutMessage(msgId, now, message, recordHeaders, sizeInBytes)
 	}
-	s.muIncMsgId.Unlock()
-	return msgIds, nil
+	return msgIds, cptShed, nil
 }
 
 func (s *Stream) startDeferedSaveTimer() {
 	s.wg.Add(1)
 	go func() {
 		s.Run()
+		close(s.writerStopped)
 		s.wg.Done()
 	}()
 }
@@ -269,15 +385,7 @@ func (s *Stream) Run() {
 				timer.Stop()
 				timer = nil
 			}
-			if err = s.ingestBuffer.Save(); err != nil {
-				s.logger.Error(
-					"Can't save stream ingest buffer",
-					zap.String("topic", "stream"),
-					zap.String("method", "Run"),
-					zap.String("stream.uuid", s.info.UUID.String()),
-					zap.Error(err),
-				)
-			}
+			s.saveIngestBuffer("Run")
 			if err = s.ingestBuffer.Close(); err != nil {
 				s.logger.Error(
 					"Can't close stream ingest buffer",
@@ -313,17 +421,19 @@ func (s *Stream) Run() {
 
 		case <-flushC:
 			timer.Stop()
-			if err = s.ingestBuffer.Save(); err != nil {
-				s.logger.Error(
-					"Can't save stream ingest buffer",
-					zap.String("topic", "stream"),
-					zap.String("method", "Run"),
-					zap.String("stream.uuid", s.info.UUID.String()),
-					zap.Error(err),
-				)
-			}
+			s.saveIngestBuffer("Run")
 			flushC = nil
 			timer = nil
+
+		case <-s.ingestBuffer.GetFlushRequests():
+			// a producer waits for its records to be persisted (durability): they are already put into the channel
+			s.bufferizePendingMessages()
+			if timer != nil {
+				timer.Stop()
+				flushC = nil
+				timer = nil
+			}
+			s.saveIngestBuffer("Run")
 		}
 	}
 }
@@ -339,18 +449,37 @@ func (s *Stream) bufferizeMessage(msg types.DeferedStreamRecord, immediateSave b
 	}
 	s.ingestBuffer.AppendMesssage(msg)
 	if immediateSave || s.ingestBuffer.IsFull() {
-		if err := s.ingestBuffer.Save(); err != nil {
-			s.logger.Error(
-				"Can't save stream ingest buffer",
-				zap.String("topic", "stream"),
-				zap.String("method", "bufferizeMessage"),
-				zap.String("stream.uuid", s.info.UUID.String()),
-				zap.Error(err),
-			)
+		s.saveIngestBuffer("bufferizeMessage")
+	}
+}
+
+func (s *Stream) bufferizePendingMessages() {
+	// move the records waiting into the channel to the ingest buffer (without saving them)
+	channelMsg := s.ingestBuffer.GetChannelMsg()
+	for {
+		select {
+		case msg := <-channelMsg:
+			s.ingestBuffer.AppendMesssage(msg)
+		default:
+			return
 		}
 	}
 }
 
+func (s *Stream) saveIngestBuffer(method string) {
+	if err := s.ingestBuffer.Save(); err != nil {
+		s.logger.Error(
+			"Can't save stream ingest buffer",
+			zap.String("topic", "stream"),
+			zap.String("method", method),
+			zap.String("stream.uuid", s.info.UUID.String()),
+			zap.Error(err),
+		)
+		// the records are kept in the buffer (and saved later) unless the stream has a dead-letter stream
+		s.sendUnsavedRecordsToDeadLetterStream(err)
+	}
+}
+
 func (s *Stream) Log() {
 	s.logger.Info("Stream",
 		zap.String("topic", "stream"),
@@ -378,18 +507,62 @@ func (s *Stream) Log() {
 	)
 }
 
-func (s *Stream) UpdateProperties(properties *types.StreamProperties) {
+func (s *Stream) UpdateProperties(properties *types.StreamProperties) error {
 	if s.logVerbosity > 0 {
 		s.logger.Debug("UpdateProperties")
 	}
+
+	newProperties := maps.Clone(s.info.Properties)
+	if properties != nil {
+		maps.Copy(newProperties, *properties)
+	}
+	if err := s.applyProperties(newProperties); err != nil {
+		return err
+	}
+
 	s.info.UpdateProperties(properties)
+	return nil
 }
 
-func (s *Stream) SetProperties(properties *types.StreamProperties) {
+func (s *Stream) SetProperties(properties *types.StreamProperties) error {
 	if s.logVerbosity > 0 {
 		s.logger.Debug("SetProperties")
 	}
+
+	var newProperties types.StreamProperties
+	if properties != nil {
+		newProperties = *properties
+	}
+	if err := s.applyProperties(newProperties); err != nil {
+		return err
+	}
+
 	s.info.SetProperties(properties)
+	return nil
+}
+
+func (s *Stream) applyProperties(properties types.StreamProperties) error {
+	// check the properties configuring the stream before they are set
+	deadLetterUUID, found, err := types.GetDeadLetterStream(properties)
+	if err != nil {
+		return err
+	}
+	if found && deadLetterUUID == s.info.UUID {
+		return fmt.Errorf("%w: a stream cannot be its own dead-letter stream", types.ErrInvalidDeadLetterStream)
+	}
+	if !types.IsSameDerivedStream(s.info.Properties, properties) {
+		return fmt.Errorf("%w: the source and the jq program of a derived stream cannot be changed", types.ErrInvalidDerivedStream)
+	}
+	if _, err = s.backpressure.WithProperties(properties); err != nil {
+		return err
+	}
+	if _, err = types.GetDurability(s.durability, properties); err != nil {
+		return err
+	}
+	if _, err = s.retentionPolicy.WithProperties(properties); err != nil {
+		return err
+	}
+	return s.loadIngestPipeline(properties)
 }
 
 func (s *Stream) GetProperties() *types.StreamProperties {
@@ -415,23 +588,103 @@ func (s *Stream) GetInfo() *types.StreamInfo {
 	return s.info
 }
 
+func (s *Stream) GetFirstReadableMsgId() types.MessageId {
+	return s.getReadableMessages().FirstMsgId
+}
+
+func (s *Stream) GetLastIngestedMsgId() types.MessageId {
+	// the message ids are given to the records put while muIncMsgId is locked
+	s.muIncMsgId.Lock()
+	defer s.muIncMsgId.Unlock()
+	return s.info.IngestedMessages.LastMsgId
+}
+
+func (s *Stream) getReadableMessages() types.StreamMessagesInfo {
+	// the writer and the retention policy update the readable messages while the ingest buffer is locked
+	if s.ingestBuffer != nil {
+		s.ingestBuffer.Lock()
+		defer s.ingestBuffer.Unlock()
+	}
+	return s.info.ReadableMessages
+}
+
 func (s *Stream) GetUUID() types.StreamUUID {
 	return s.info.UUID
 }
 
+func (s *Stream) IsDerived() bool {
+	// the records of a derived stream are put by the server only (they come from its source stream)
+	_, found := s.info.Properties[types.DerivedStreamPropertySource]
+	return found
+}
+
 func (s *Stream) GetIteratorsCount() int {
+	s.muIterators.RLock()
+	defer s.muIterators.RUnlock()
 	return len(s.iterators)
 }
 
-func NewStream(info *types.StreamInfo, ingestBuffer *buffering.StreamIngestBuffer, logger *zap.Logger, logVerbosity int) *Stream {
-	return &Stream{
-		info:         info,
-		iterators:    make(StreamIteratorMap),
-		logger:       logger,
-		logVerbosity: logVerbosity,
-		ingestBuffer: ingestBuffer,
-		done:         make(chan struct{}),
-		wg:           sync.WaitGroup{},
-		state:        STREAM_STATE_NONE,
+func (s *Stream) GetClientIteratorsCount() int {
+	// the iterators opened by the clients (the internal iterators of the server are not counted)
+	s.muIterators.RLock()
+	defer s.muIterators.RUnlock()
+	cpt := 0
+	for _, it := range s.iterators {
+		if !it.IsInternal() {
+			cpt++
+		}
 	}
+	return cpt
+}
+
+type StreamOption func(*Stream)
+
+func WithIteratorTimeouts(idleTimeout time.Duration, maxLifetime time.Duration) StreamOption {
+	// iterators are deleted after idleTimeout without any read
+	// or after maxLifetime since their creation (0 means never)
+	return func(s *Stream) {
+		s.iteratorIdleTTL = idleTimeout
+		s.iteratorMaxTTL = maxLifetime
+	}
+}
+
+func NewStream(info *types.StreamInfo, ingestBuffer *buffering.StreamIngestBuffer, logger *zap.Logger, logVerbosity int, options ...StreamOption) *Stream {
+	s := &Stream{
+		info:                info,
+		iterators:           make(StreamIteratorMap),
+		logger:              logger,
+		logVerbosity:        logVerbosity,
+		ingestBuffer:        ingestBuffer,
+		consumerGroups:      make(map[string]*types.ConsumerGroup),
+		compiledSchemas:     make(map[int]*jsonschema.Schema),
+		producers:           make(map[string]*types.Producer),
+		producerLocks:       make(map[string]*producerLock),
+		unsavedProducers:    make(map[string]bool),
+		schemaCompatibility: types.SchemaCompatibilityBackward,
+		backpressure:        types.Backpressure{Mode: types.BackpressureModeBlock},
+		durability:          types.DurabilityNone,
+		done:                make(chan struct{}),
+		writerStopped:       make(chan struct{}),
+		deadLettersReady:    make(chan struct{}, 1),
+		wg:                  sync.WaitGroup{},
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if info == nil {
+		return s
+	}
+	if err := s.loadIngestPipeline(info.Properties); err != nil {
+		s.logger.Error(
+			"Invalid ingest pipeline, records are saved without transformation",
+			zap.String("topic", "stream"),
+			zap.String("method", "NewStream"),
+			zap.String("stream.uuid", info.UUID.String()),
+			zap.Error(err),
+		)
+	}
+
+	return s
 }
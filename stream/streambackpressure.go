@@ -0,0 +1,105 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+)
+
+var ErrIngestBufferFull = errors.New("stream ingest buffer is full")
+var ErrIngestBufferTimeout = errors.New("timeout while waiting for room in the stream ingest buffer")
+var ErrIngestBatchTooLarge = errors.New("batch of records is larger than the stream ingest buffer")
+
+// Statistics of the ingest buffer of a stream
+type IngestStats struct {
+	QueueDepth     int                `json:"queueDepth"`    // records put and waiting to be buffered
+	QueueCapacity  int                `json:"queueCapacity"` // maximum number of records waiting to be buffered
+	Backpressure   types.Backpressure `json:"backpressure"`
+	CountRejected  uint64             `json:"countRejected"` // records rejected because the ingest buffer was full (since the server started)
+	CountShed      uint64             `json:"countShed"`     // records shed because the ingest buffer was full (since the server started)
+	PartitionStats []*IngestStats     `json:"partitions,omitempty"`
+}
+
+func WithBackpressure(backpressure types.Backpressure) StreamOption {
+	// default backpressure of the stream (can be overridden by the stream properties)
+	return func(s *Stream) {
+		s.backpressure = backpressure
+	}
+}
+
+func (s *Stream) GetBackpressure() (types.Backpressure, error) {
+	if s.parent != nil {
+		// the partitions follow the backpressure of the partitioned stream
+		return s.backpressure.WithProperties(s.parent.info.Properties)
+	}
+	return s.backpressure.WithProperties(s.info.Properties)
+}
+
+func (s *Stream) GetIngestStats() *IngestStats {
+	backpressure, _ := s.GetBackpressure()
+	stats := IngestStats{
+		Backpressure:  backpressure,
+		CountRejected: s.cptRejectedRecords.Load(),
+		CountShed:     s.cptShedRecords.Load(),
+	}
+	if s.ingestBuffer != nil {
+		stats.QueueDepth = s.ingestBuffer.GetQueueDepth()
+		stats.QueueCapacity = s.ingestBuffer.GetQueueCapacity()
+	}
+	for _, partition := range s.partitions {
+		partitionStats := partition.GetIngestStats()
+		stats.QueueDepth += partitionStats.QueueDepth
+		stats.QueueCapacity += partitionStats.QueueCapacity
+		stats.CountRejected += partitionStats.CountRejected
+		stats.CountShed += partitionStats.CountShed
+		stats.PartitionStats = append(stats.PartitionStats, partitionStats)
+	}
+	return &stats
+}
+
+func (s *Stream) waitForIngestBufferRoom(count int, startTime time.Time, override *types.Backpressure) (int, error) {
+	// Returns the number of records that can be put into the ingest buffer without blocking (count unless shed).
+	// It must be called while muIncMsgId is locked: the room left can only grow until the records are put.
+	// The time spent waiting for the lock (startTime) is part of the maximum wait of the producer.
+	// The backpressure of the stream is applied unless overridden.
+	var backpressure types.Backpressure
+	var err error
+	if override != nil {
+		backpressure = *override
+	} else if backpressure, err = s.GetBackpressure(); err != nil {
+		return 0, err
+	}
+
+	capacity := s.ingestBuffer.GetQueueCapacity()
+	if capacity == 0 || (backpressure.Mode == types.BackpressureModeBlock && backpressure.MaxWait <= 0) {
+		// unbuffered channel or unlimited wait: the producer is blocked until the records are buffered
+		return count, nil
+	}
+	if count > capacity && backpressure.Mode != types.BackpressureModeShed {
+		s.cptRejectedRecords.Add(uint64(count))
+		return 0, fmt.Errorf("%w: %d records (ingest buffer capacity is %d)", ErrIngestBatchTooLarge, count, capacity)
+	}
+
+	var maxWait time.Duration = 0
+	if backpressure.Mode == types.BackpressureModeBlock {
+		maxWait = backpressure.MaxWait - time.Since(startTime)
+	}
+	room := s.ingestBuffer.WaitForRoom(count, maxWait, s.done)
+	if room >= count {
+		return count, nil
+	}
+
+	switch backpressure.Mode {
+	case types.BackpressureModeShed:
+		s.cptShedRecords.Add(uint64(count - room))
+		return room, nil
+	case types.BackpressureModeBlock:
+		s.cptRejectedRecords.Add(uint64(count))
+		return 0, fmt.Errorf("%w (waited %s)", ErrIngestBufferTimeout, time.Since(startTime).Round(time.Millisecond))
+	default:
+		s.cptRejectedRecords.Add(uint64(count))
+		return 0, ErrIngestBufferFull
+	}
+}
@@ -0,0 +1,160 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+// Function used to persist a consumer group (usually provided by the storage provider)
+type ConsumerGroupSaver func(group *types.ConsumerGroup) error
+
+var ErrConsumerGroupNotFound = errors.New("consumer group not found")
+
+func (s *Stream) SetConsumerGroups(groups types.ConsumerGroupList) {
+	s.muConsumerGroups.Lock()
+	defer s.muConsumerGroups.Unlock()
+
+	s.consumerGroups = make(map[string]*types.ConsumerGroup, len(groups))
+	for _, group := range groups {
+		s.consumerGroups[group.Name] = group
+	}
+}
+
+func (s *Stream) GetConsumerGroup(name string) (*types.ConsumerGroup, bool) {
+	s.muConsumerGroups.Lock()
+	defer s.muConsumerGroups.Unlock()
+
+	if group, found := s.consumerGroups[name]; found {
+		g := *group
+		return &g, true
+	}
+
+	return nil, false
+}
+
+func (s *Stream) GetConsumerGroups() types.ConsumerGroupList {
+	s.muConsumerGroups.Lock()
+	groups := make(types.ConsumerGroupList, 0, len(s.consumerGroups))
+	for _, group := range s.consumerGroups {
+		g := *group
+		groups = append(groups, &g)
+	}
+	s.muConsumerGroups.Unlock()
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+func (s *Stream) CommitConsumerGroup(name string, messageId types.MessageId, save ConsumerGroupSaver) (*types.ConsumerGroup, error) {
+	// Commit the last message id processed by a consumer group.
+	// The consumer group is created if it does not exist yet.
+	// A commit can only move the position forward, use ResetConsumerGroup to move it backward.
+	if !types.IsValidConsumerGroupName(name) {
+		return nil, fmt.Errorf("invalid consumer group name: %s", name)
+	}
+
+	if messageId == 0 {
+		return nil, errors.New("invalid message id: nothing to commit")
+	}
+
+	if lastMsgId := s.getReadableMessages().LastMsgId; messageId > lastMsgId {
+		return nil, fmt.Errorf("invalid message id: %d is greater than last readable message id %d", messageId, lastMsgId)
+	}
+
+	s.muConsumerGroups.Lock()
+	defer s.muConsumerGroups.Unlock()
+
+	var group types.ConsumerGroup
+	if current, found := s.consumerGroups[name]; found {
+		if messageId < current.CommittedMsgId {
+			return nil, fmt.Errorf("invalid message id: %d is lower than committed message id %d (use reset instead)", messageId, current.CommittedMsgId)
+		}
+		group = *current
+	} else {
+		group = *types.NewConsumerGroup(s.info.UUID, name)
+	}
+
+	group.CommittedMsgId = messageId
+	group.LastCommitDate = time.Now()
+	return s.saveConsumerGroup(&group, save)
+}
+
+func (s *Stream) ResetConsumerGroup(name string, messageId types.MessageId, save ConsumerGroupSaver) (*types.ConsumerGroup, error) {
+	// Move the position of a consumer group anywhere in the stream.
+	// A message id of 0 means the consumer group will restart from the first message.
+	if lastMsgId := s.getReadableMessages().LastMsgId; messageId > lastMsgId {
+		return nil, fmt.Errorf("invalid message id: %d is greater than last readable message id %d", messageId, lastMsgId)
+	}
+
+	s.muConsumerGroups.Lock()
+	defer s.muConsumerGroups.Unlock()
+
+	current, found := s.consumerGroups[name]
+	if !found {
+		return nil, ErrConsumerGroupNotFound
+	}
+
+	group := *current
+	group.CommittedMsgId = messageId
+	group.LastCommitDate = time.Now()
+	return s.saveConsumerGroup(&group, save)
+}
+
+func (s *Stream) DeleteConsumerGroup(name string, remove func(name string) error) error {
+	s.muConsumerGroups.Lock()
+	defer s.muConsumerGroups.Unlock()
+
+	if _, found := s.consumerGroups[name]; !found {
+		return ErrConsumerGroupNotFound
+	}
+
+	if err := remove(name); err != nil {
+		return err
+	}
+
+	delete(s.consumerGroups, name)
+	s.logger.Info(
+		"Consumer group deleted",
+		zap.String("topic", "stream"),
+		zap.String("method", "DeleteConsumerGroup"),
+		zap.String("stream.uuid", s.info.UUID.String()),
+		zap.String("consumerGroup", name),
+	)
+	return nil
+}
+
+func (s *Stream) saveConsumerGroup(group *types.ConsumerGroup, save ConsumerGroupSaver) (*types.ConsumerGroup, error) {
+	// persist first, then update the in memory state (must be called with muConsumerGroups locked)
+	if err := save(group); err != nil {
+		s.logger.Error(
+			"Can't save consumer group",
+			zap.String("topic", "stream"),
+			zap.String("method", "saveConsumerGroup"),
+			zap.String("stream.uuid", s.info.UUID.String()),
+			zap.String("consumerGroup", group.Name),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	s.consumerGroups[group.Name] = group
+	if s.logVerbosity > 0 {
+		s.logger.Debug(
+			"Consumer group saved",
+			zap.String("topic", "stream"),
+			zap.String("method", "saveConsumerGroup"),
+			zap.String("stream.uuid", s.info.UUID.String()),
+			zap.String("consumerGroup", group.Name),
+			zap.Uint64("committedMsgId", group.CommittedMsgId),
+		)
+	}
+
+	g := *group
+	return &g, nil
+}
@@ -0,0 +1,254 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+// Time the records that the storage provider can't write wait for room in the dead-letter stream before being retried
+const DeadLetterRetryDelay = time.Second
+
+// Function used to find the dead-letter stream of a stream (usually provided by the service)
+type DeadLetterStreamResolver func(streamUUID types.StreamUUID) *Stream
+
+func WithDeadLetterResolver(resolver DeadLetterStreamResolver) StreamOption {
+	return func(s *Stream) {
+		s.deadLetterResolver = resolver
+	}
+}
+
+func (s *Stream) GetDeadLetterStream() (*Stream, error) {
+	// returns the dead-letter stream of the stream (nil if the stream has none),
+	// the partitions use the dead-letter stream of the partitioned stream
+	source := s
+	if s.parent != nil {
+		source = s.parent
+	}
+
+	deadLetterUUID, found, err := types.GetDeadLetterStream(source.info.Properties)
+	if err != nil || !found {
+		return nil, err
+	}
+	if deadLetterUUID == source.info.UUID {
+		return nil, fmt.Errorf("%w: a stream cannot be its own dead-letter stream", types.ErrInvalidDeadLetterStream)
+	}
+
+	var deadLetterStream *Stream
+	if s.deadLetterResolver != nil {
+		deadLetterStream = s.deadLetterResolver(deadLetterUUID)
+	}
+	if deadLetterStream == nil {
+		return nil, fmt.Errorf("%w: stream %s not found", types.ErrInvalidDeadLetterStream, deadLetterUUID.String())
+	}
+	return deadLetterStream, nil
+}
+
+func (s *Stream) SendToDeadLetterStream(deadLetters []*types.DeadLetter) {
+	// Put the records that could not be saved into the dead-letter stream (if any), an error is logged.
+	_, _, _ = s.PutDeadLetters(deadLetters)
+}
+
+func (s *Stream) PutDeadLetters(deadLetters []*types.DeadLetter) (*Stream, []types.MessageId, error) {
+	// Put the records that could not be saved into the dead-letter stream (if any),
+	// returns the stream that received them (nil if none) and their message ids.
+	target, msgIds, err := s.putDeadLetters(deadLetters, nil)
+	if err != nil {
+		s.logDeadLettersError("PutDeadLetters", deadLetters, err)
+		return nil, nil, err
+	}
+	return target, msgIds, nil
+}
+
+func (s *Stream) putDeadLetters(deadLetters []*types.DeadLetter, backpressure *types.Backpressure) (*Stream, []types.MessageId, error) {
+	// A partitioned dead-letter stream receives the records of a stream into the same partition.
+	// The backpressure (if not nil) is applied instead of the backpressure of the dead-letter stream.
+	if len(deadLetters) == 0 {
+		return nil, nil, nil
+	}
+
+	deadLetterStream, err := s.GetDeadLetterStream()
+	if deadLetterStream == nil || err != nil {
+		return nil, nil, err
+	}
+
+	streamUUID := s.info.UUID
+	if s.parent != nil {
+		streamUUID = s.parent.info.UUID
+	}
+
+	now := time.Now()
+	records := make([]interface{}, len(deadLetters))
+	headers := make([]types.RecordHeaders, len(deadLetters))
+	for i, deadLetter := range deadLetters {
+		records[i] = deadLetter.ToRecord(streamUUID, now)
+		headers[i] = deadLetter.Headers
+	}
+
+	target := deadLetterStream
+	if target.IsPartitioned() {
+		target, _ = target.GetPartitionForKey(streamUUID.String())
+	}
+	// the ingest pipeline of the dead-letter stream is not run, the records must be saved as they are
+	msgIds, _, err := target.putRecordsWithBackpressure(records, nil, headers, backpressure)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.logVerbosity > 0 {
+		s.logger.Debug(
+			"Records sent to the dead-letter stream",
+			zap.String("topic", "stream"),
+			zap.String("method", "putDeadLetters"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.String("deadletter.uuid", deadLetterStream.GetUUID().String()),
+			zap.String("deadletter.source", deadLetters[0].Source),
+			zap.Int("records", len(deadLetters)),
+		)
+	}
+	return target, msgIds, nil
+}
+
+func (s *Stream) logDeadLettersError(method string, deadLetters []*types.DeadLetter, err error) {
+	streamUUID := s.info.UUID
+	if s.parent != nil {
+		streamUUID = s.parent.info.UUID
+	}
+	s.logger.Error(
+		"Can't send records to the dead-letter stream",
+		zap.String("topic", "stream"),
+		zap.String("method", method),
+		zap.String("stream.uuid", streamUUID.String()),
+		zap.String("deadletter.source", deadLetters[0].Source),
+		zap.Int("records", len(deadLetters)),
+		zap.Error(err),
+	)
+}
+
+func (s *Stream) sendUnsavedRecordsToDeadLetterStream(err error) {
+	// The records that the storage provider will never write are moved from the ingest buffer to the dead-letter stream,
+	// the other errors are transient: the records are kept in the ingest buffer and saved later.
+	// The records are sent by the dead-letter sender, the writer of the stream never waits for the dead-letter stream.
+	if !errors.Is(err, types.ErrRecordNotWritable) {
+		return
+	}
+	if deadLetterStream, errDeadLetter := s.GetDeadLetterStream(); deadLetterStream == nil || errDeadLetter != nil {
+		return
+	}
+
+	records := s.ingestBuffer.Discard(err)
+	deadLetters := make([]*types.DeadLetter, len(records))
+	for i, record := range records {
+		deadLetters[i] = &types.DeadLetter{
+			Source:    types.DeadLetterSourceWrite,
+			Reason:    err.Error(),
+			MessageId: record.Id,
+			Message:   record.Msg,
+			Headers:   record.Headers,
+		}
+	}
+
+	s.muUnsentDeadLetters.Lock()
+	s.unsentDeadLetters = append(s.unsentDeadLetters, deadLetters...)
+	s.muUnsentDeadLetters.Unlock()
+	select {
+	case s.deadLettersReady <- struct{}{}:
+	default:
+		// the dead-letter sender is already notified
+	}
+}
+
+func (s *Stream) startDeadLetterSender() {
+	// send the records moved from the ingest buffer until the writer of the stream is stopped
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			select {
+			case <-s.deadLettersReady:
+				s.sendUnsentDeadLetters(s.writerStopped)
+			case <-s.writerStopped:
+				// the last save of the writer may have moved records
+				s.sendUnsentDeadLetters(nil)
+				return
+			}
+		}
+	}()
+}
+
+func (s *Stream) sendUnsentDeadLetters(stop <-chan struct{}) {
+	// The records are sent once there is room for them in the ingest buffer of the dead-letter stream,
+	// they are retried until stop is closed (a single attempt if stop is nil, the records not sent are lost).
+	for {
+		s.muUnsentDeadLetters.Lock()
+		deadLetters := s.unsentDeadLetters
+		s.muUnsentDeadLetters.Unlock()
+		if len(deadLetters) == 0 {
+			return
+		}
+
+		cptSent, err := s.sendDeadLettersWithoutBlocking(deadLetters, stop)
+		if err == nil {
+			s.muUnsentDeadLetters.Lock()
+			s.unsentDeadLetters = s.unsentDeadLetters[cptSent:]
+			s.muUnsentDeadLetters.Unlock()
+			continue
+		}
+
+		if stop == nil {
+			s.logDeadLettersError("sendUnsentDeadLetters", deadLetters, err)
+			s.muUnsentDeadLetters.Lock()
+			s.unsentDeadLetters = nil
+			s.muUnsentDeadLetters.Unlock()
+			return
+		}
+		if !errors.Is(err, ErrIngestBufferFull) {
+			s.logDeadLettersError("sendUnsentDeadLetters", deadLetters, err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(DeadLetterRetryDelay):
+		}
+	}
+}
+
+func (s *Stream) sendDeadLettersWithoutBlocking(deadLetters []*types.DeadLetter, stop <-chan struct{}) (int, error) {
+	// Put the first records that fit into the ingest buffer of the dead-letter stream, returns the number of records put.
+	// It waits for room up to DeadLetterRetryDelay (not at all if stop is nil).
+	deadLetterStream, err := s.GetDeadLetterStream()
+	if err != nil {
+		return 0, err
+	}
+	if deadLetterStream == nil {
+		return 0, fmt.Errorf("%w: the stream has no dead-letter stream anymore", types.ErrInvalidDeadLetterStream)
+	}
+	if deadLetterStream.IsPartitioned() {
+		// same partition as putDeadLetters
+		streamUUID := s.info.UUID
+		if s.parent != nil {
+			streamUUID = s.parent.info.UUID
+		}
+		deadLetterStream, _ = deadLetterStream.GetPartitionForKey(streamUUID.String())
+	}
+
+	count := len(deadLetters)
+	if capacity := deadLetterStream.ingestBuffer.GetQueueCapacity(); capacity > 0 {
+		count = min(count, capacity)
+		maxWait := DeadLetterRetryDelay
+		if stop == nil {
+			maxWait = 0
+		}
+		if room := deadLetterStream.ingestBuffer.WaitForRoom(count, maxWait, stop); room < count {
+			return 0, ErrIngestBufferFull
+		}
+	}
+	if _, _, err = s.putDeadLetters(deadLetters[:count], &types.Backpressure{Mode: types.BackpressureModeReject}); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
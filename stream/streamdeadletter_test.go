@@ -0,0 +1,241 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/buffering"
+	"github.com/nbigot/ministream/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type testStreamWriter struct {
+	mu          sync.Mutex
+	records     []types.DeferedStreamRecord
+	release     chan struct{} // the writes wait until it is closed (nil: no wait)
+	releaseOnce sync.Once
+	cptFailures int // the next writes that fail with a transient error
+}
+
+func newBlockedTestStreamWriter() *testStreamWriter {
+	// the writes wait until unblock is called (at the latest when the stream is closed)
+	return &testStreamWriter{release: make(chan struct{})}
+}
+
+func (w *testStreamWriter) unblock() {
+	w.releaseOnce.Do(func() {
+		close(w.release)
+	})
+}
+
+func (w *testStreamWriter) Init() error  { return nil }
+func (w *testStreamWriter) Open() error  { return nil }
+func (w *testStreamWriter) Close() error { return nil }
+func (w *testStreamWriter) Sync() error  { return nil }
+
+func (w *testStreamWriter) Trim(policy *types.RetentionPolicy, now time.Time) (types.Size64, error) {
+	return 0, nil
+}
+
+func (w *testStreamWriter) Reencrypt() (types.Size64, error) { return 0, nil }
+
+func (w *testStreamWriter) Scrub() (*types.StreamScrubReport, error) {
+	return types.NewStreamScrubReport(), nil
+}
+
+func (w *testStreamWriter) Write(records *[]types.DeferedStreamRecord) error {
+	// the records {"fail": true} can never be written
+	if w.release != nil {
+		<-w.release
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cptFailures > 0 && len(*records) > 0 {
+		w.cptFailures--
+		return errors.New("storage is not available")
+	}
+	for _, record := range *records {
+		if msg, ok := record.Msg.(map[string]interface{}); ok && msg["fail"] == true {
+			return fmt.Errorf("%w: record %d", types.ErrRecordNotWritable, record.Id)
+		}
+	}
+	w.records = append(w.records, *records...)
+	return nil
+}
+
+func (w *testStreamWriter) countDeadLetters() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cpt := 0
+	for _, record := range w.records {
+		if record.Msg.(map[string]interface{})["source"] == types.DeadLetterSourceWrite {
+			cpt++
+		}
+	}
+	return cpt
+}
+
+type testStreams struct {
+	mu      sync.Mutex
+	streams map[types.StreamUUID]*Stream
+}
+
+func (ts *testStreams) newStream(t *testing.T, writer *testStreamWriter, channelBufferSize int) *Stream {
+	// the records are saved one at a time, the producers wait for room in the ingest buffer without limit
+	t.Helper()
+	ingestBuffer := buffering.NewStreamIngestBuffer(0, 100, 0, channelBufferSize, writer)
+	s := NewStream(types.NewStreamInfo(uuid.New()), ingestBuffer, zap.NewNop(), 0, WithDeadLetterResolver(func(streamUUID types.StreamUUID) *Stream {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		return ts.streams[streamUUID]
+	}))
+	ts.mu.Lock()
+	ts.streams[s.GetUUID()] = s
+	ts.mu.Unlock()
+	if err := s.Start(); err != nil {
+		t.Fatalf("could not start stream: %v", err)
+	}
+	t.Cleanup(func() {
+		closed := make(chan error, 1)
+		go func() {
+			closed <- s.Close()
+		}()
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Errorf("timeout while closing stream %s", s.GetUUID())
+		}
+	})
+	if writer.release != nil {
+		t.Cleanup(writer.unblock)
+	}
+	return s
+}
+
+func fillIngestBuffer(t *testing.T, s *Stream, records []interface{}) {
+	// the writer of the stream is blocked, the records wait in the ingest buffer
+	t.Helper()
+	if _, _, err := s.PutMessages(nil, records, nil); err != nil {
+		t.Fatalf("could not put records: %v", err)
+	}
+	waitFor(t, "the ingest buffer to be full", func() bool {
+		return s.ingestBuffer.GetQueueDepth() == s.ingestBuffer.GetQueueCapacity()
+	})
+}
+
+func putAndWaitForDurability(t *testing.T, s *Stream) {
+	// the writer of the stream still writes records
+	t.Helper()
+	msgId, _, err := s.PutMessage(nil, map[string]interface{}{"n": 1}, nil)
+	if err != nil {
+		t.Fatalf("could not put record: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = s.WaitForDurability(ctx, types.DurabilityFlushed, []types.MessageId{msgId}); err != nil {
+		t.Fatalf("record %d not written: %v", msgId, err)
+	}
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for !condition() {
+		select {
+		case <-timeout:
+			t.Fatalf("timeout while waiting for %s", what)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestDeadLetterStreamFull(t *testing.T) {
+	ts := &testStreams{streams: make(map[types.StreamUUID]*Stream)}
+	s := ts.newStream(t, &testStreamWriter{}, 2)
+	dlqWriter := newBlockedTestStreamWriter()
+	dlq := ts.newStream(t, dlqWriter, 2)
+	if err := s.UpdateProperties(&types.StreamProperties{types.DeadLetterPropertyStream: dlq.GetUUID().String()}); err != nil {
+		t.Fatalf("could not set dead-letter stream: %v", err)
+	}
+
+	// the dead-letter stream has no room, the records that can't be written wait for it
+	fillIngestBuffer(t, dlq, []interface{}{map[string]interface{}{"n": 1}, map[string]interface{}{"n": 2}, map[string]interface{}{"n": 3}})
+	msgId, _, err := s.PutMessage(nil, map[string]interface{}{"fail": true}, nil)
+	if err != nil {
+		t.Fatalf("could not put record: %v", err)
+	}
+	if err = s.WaitForDurability(context.Background(), types.DurabilityFlushed, []types.MessageId{msgId}); !errors.Is(err, ErrRecordsNotPersisted) {
+		t.Fatalf("expected the record not persisted, got %v", err)
+	}
+	putAndWaitForDurability(t, s)
+	if cpt := dlqWriter.countDeadLetters(); cpt != 0 {
+		t.Fatalf("expected no dead letter written yet, got %d", cpt)
+	}
+
+	// the record is sent once there is room in the dead-letter stream
+	dlqWriter.unblock()
+	waitFor(t, "the dead letter", func() bool { return dlqWriter.countDeadLetters() == 1 })
+	dlqWriter.mu.Lock()
+	defer dlqWriter.mu.Unlock()
+	deadLetter := dlqWriter.records[len(dlqWriter.records)-1].Msg.(map[string]interface{})
+	if deadLetter["stream"] != s.GetUUID().String() || deadLetter["messageId"] != 1 || deadLetter["message"].(map[string]interface{})["fail"] != true {
+		t.Fatalf("unexpected dead letter %v", deadLetter)
+	}
+}
+
+func TestDeadLetterStreamsOfEachOther(t *testing.T) {
+	ts := &testStreams{streams: make(map[types.StreamUUID]*Stream)}
+	writers := []*testStreamWriter{newBlockedTestStreamWriter(), newBlockedTestStreamWriter()}
+	streams := []*Stream{ts.newStream(t, writers[0], 2), ts.newStream(t, writers[1], 2)}
+	for i, s := range streams {
+		if err := s.UpdateProperties(&types.StreamProperties{types.DeadLetterPropertyStream: streams[1-i].GetUUID().String()}); err != nil {
+			t.Fatalf("could not set dead-letter stream: %v", err)
+		}
+	}
+
+	// the ingest buffers are full of records that can't be written, each stream sends them to the other one
+	failing := []interface{}{map[string]interface{}{"fail": true}, map[string]interface{}{"fail": true}, map[string]interface{}{"fail": true}}
+	for _, s := range streams {
+		fillIngestBuffer(t, s, failing)
+	}
+	for _, writer := range writers {
+		writer.unblock()
+	}
+	for i, s := range streams {
+		putAndWaitForDurability(t, s)
+		waitFor(t, "the dead letters", func() bool { return writers[i].countDeadLetters() == len(failing) })
+	}
+}
+
+func TestDeadLetterStreamTransientError(t *testing.T) {
+	ts := &testStreams{streams: make(map[types.StreamUUID]*Stream)}
+	writer := &testStreamWriter{cptFailures: 1}
+	s := ts.newStream(t, writer, 2)
+	dlqWriter := &testStreamWriter{}
+	dlq := ts.newStream(t, dlqWriter, 2)
+	if err := s.UpdateProperties(&types.StreamProperties{types.DeadLetterPropertyStream: dlq.GetUUID().String()}); err != nil {
+		t.Fatalf("could not set dead-letter stream: %v", err)
+	}
+
+	// the record that could not be written is kept in the ingest buffer and written by the next save
+	if _, _, err := s.PutMessage(nil, map[string]interface{}{"n": 0}, nil); err != nil {
+		t.Fatalf("could not put record: %v", err)
+	}
+	waitFor(t, "the write to fail", func() bool {
+		writer.mu.Lock()
+		defer writer.mu.Unlock()
+		return writer.cptFailures == 0
+	})
+	putAndWaitForDurability(t, s)
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if len(writer.records) != 2 || dlqWriter.countDeadLetters() != 0 {
+		t.Fatalf("expected the records written into the stream, got %d records and %d dead letters", len(writer.records), dlqWriter.countDeadLetters())
+	}
+}
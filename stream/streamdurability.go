@@ -0,0 +1,84 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+)
+
+var ErrRecordsNotPersisted = errors.New("records not persisted")
+var ErrDurabilityTimeout = errors.New("timeout while waiting for the records to be persisted")
+
+func WithDurability(level string, timeout time.Duration) StreamOption {
+	// default durability level of the stream (can be overridden by the stream properties),
+	// a producer waits at most timeout for its records to be persisted (0 means unlimited)
+	return func(s *Stream) {
+		s.durability = level
+		s.durabilityTimeout = timeout
+	}
+}
+
+func (s *Stream) GetDurability() (string, error) {
+	if s.parent != nil {
+		// the partitions follow the durability level of the partitioned stream
+		return types.GetDurability(s.durability, s.parent.info.Properties)
+	}
+	return types.GetDurability(s.durability, s.info.Properties)
+}
+
+func (s *Stream) WaitForDurability(ctx context.Context, level string, msgIds []types.MessageId) error {
+	// Wait until the records are persisted according to the durability level.
+	// The stream is asked to save its ingest buffer at once instead of waiting for the next flush.
+	var firstMsgId, lastMsgId types.MessageId
+	for _, msgId := range msgIds {
+		if msgId != 0 && (firstMsgId == 0 || msgId < firstMsgId) {
+			firstMsgId = msgId
+		}
+		lastMsgId = max(lastMsgId, msgId)
+	}
+	if level == types.DurabilityNone || lastMsgId == 0 || s.ingestBuffer == nil {
+		return nil
+	}
+
+	var timeout <-chan time.Time
+	if s.durabilityTimeout > 0 {
+		timer := time.NewTimer(s.durabilityTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	synced := level == types.DurabilityFsynced
+	notifier := s.ingestBuffer.GetPersistedNotifier()
+	saved := false
+	for {
+		// the notification channel is taken before checking, therefore no save can be missed
+		persisted := notifier.Wait()
+		persistedMsgId, err := s.ingestBuffer.GetPersistedMsgId(synced)
+		if discardedFirstMsgId, discardedLastMsgId, discardErr := s.ingestBuffer.GetDiscarded(); discardErr != nil && discardedFirstMsgId <= lastMsgId && discardedLastMsgId >= firstMsgId {
+			// some of the records will never be saved into the stream (they are sent to the dead-letter stream)
+			return fmt.Errorf("%w: %w", ErrRecordsNotPersisted, discardErr)
+		}
+		if persistedMsgId >= lastMsgId {
+			return nil
+		}
+		if saved && err != nil {
+			// the records will be saved later
+			return fmt.Errorf("%w: %w", ErrRecordsNotPersisted, err)
+		}
+
+		s.ingestBuffer.RequestFlush(synced)
+		select {
+		case <-persisted:
+			saved = true
+		case <-timeout:
+			return fmt.Errorf("%w (%s)", ErrDurabilityTimeout, s.durabilityTimeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			return fmt.Errorf("%w: stream is stopping", ErrRecordsNotPersisted)
+		}
+	}
+}
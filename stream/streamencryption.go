@@ -0,0 +1,45 @@
+package stream
+
+import (
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+func (s *Stream) Reencrypt() (types.Size64, error) {
+	// rewrite the records stored with a previous encryption key (or not encrypted), returns the number of records rewritten
+	// (the records of a partitioned stream are stored by its partitions)
+	var cptRewritten types.Size64
+	if s.ingestBuffer != nil {
+		cpt, err := s.ingestBuffer.Reencrypt()
+		cptRewritten += cpt
+		if err != nil {
+			s.logger.Error(
+				"Can't re-encrypt stream",
+				zap.String("topic", "stream"),
+				zap.String("method", "Reencrypt"),
+				zap.String("stream.uuid", s.info.UUID.String()),
+				zap.Uint64("records.rewritten", cpt),
+				zap.Error(err),
+			)
+			return cptRewritten, err
+		}
+
+		s.logger.Info(
+			"Stream re-encrypted",
+			zap.String("topic", "stream"),
+			zap.String("method", "Reencrypt"),
+			zap.String("stream.uuid", s.info.UUID.String()),
+			zap.Uint64("records.rewritten", cpt),
+		)
+	}
+
+	for _, partition := range s.partitions {
+		cpt, err := partition.Reencrypt()
+		cptRewritten += cpt
+		if err != nil {
+			return cptRewritten, err
+		}
+	}
+	return cptRewritten, nil
+}
@@ -0,0 +1,64 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+)
+
+func (s *Stream) ImportRecords(records []*types.ExportedRecord, preserveIds bool) ([]types.MessageId, error) {
+	// Put records of a stream export, the ingest pipeline is not run (the records were transformed before being exported).
+	// If preserveIds is set, the records keep their message id and creation date:
+	// the message ids must be increasing (gaps are allowed) and greater than the last message id of the stream.
+	// The records are never rejected nor shed when the ingest buffer is full, the import waits for room.
+	if s.state.Load() != STREAM_STATE_RUNNING {
+		return nil, errors.New("stream state is not running")
+	}
+	if s.IsPartitioned() {
+		return nil, errors.New("stream is partitioned, records must be imported into a partition")
+	}
+
+	s.muIncMsgId.Lock()
+	defer s.muIncMsgId.Unlock()
+
+	if preserveIds {
+		lastMsgId := s.info.IngestedMessages.LastMsgId
+		lastMsgTimestamp := s.info.IngestedMessages.LastMsgTimestamp
+		for _, record := range records {
+			if record.Id <= lastMsgId {
+				return nil, fmt.Errorf("%w: message id %d is not greater than the previous message id %d", types.ErrInvalidStreamExport, record.Id, lastMsgId)
+			}
+			if record.CreationDate.Before(lastMsgTimestamp) {
+				return nil, fmt.Errorf("%w: message %d is older than the previous message", types.ErrInvalidStreamExport, record.Id)
+			}
+			lastMsgId = record.Id
+			lastMsgTimestamp = record.CreationDate
+		}
+	}
+
+	msgIds := make([]types.MessageId, len(records))
+	now := time.Now()
+	for i, record := range records {
+		msgId := s.info.IngestedMessages.LastMsgId + 1
+		creationDate := now
+		if preserveIds {
+			msgId = record.Id
+			creationDate = record.CreationDate
+		}
+		if s.info.IngestedMessages.CptMessages == 0 {
+			// first message of the stream
+			s.info.IngestedMessages.FirstMsgId = msgId
+			s.info.IngestedMessages.FirstMsgTimestamp = creationDate
+		}
+		s.info.IngestedMessages.LastMsgTimestamp = creationDate
+		s.info.IngestedMessages.LastMsgId = msgId
+		s.info.IngestedMessages.CptMessages += 1
+		sizeInBytes := uint64(len(fmt.Sprintf("%v", record.Message)))
+		s.info.IngestedMessages.SizeInBytes += sizeInBytes
+		msgIds[i] = msgId
+		s.ingestBuffer.PutMessage(msgId, creationDate, record.Message, record.Headers, sizeInBytes)
+	}
+	return msgIds, nil
+}
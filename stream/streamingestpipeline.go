@@ -0,0 +1,105 @@
+package stream
+
+import (
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+// Maximum number of record errors reported when the ingest pipeline rejects records
+const MaxIngestPipelineErrors = 100
+
+type IngestPipelineRecordError struct {
+	Index int    `json:"index"` // rank of the record in the request
+	Step  string `json:"step"`  // name of the step that failed
+	Error string `json:"error"`
+}
+
+// Records dropped or rejected by the ingest pipeline of a stream (or shed because the ingest buffer was full)
+type IngestPipelineReport struct {
+	CountDropped int64
+	CountErrors  int64
+	CountShed    int64
+	Errors       []*IngestPipelineRecordError
+}
+
+func (r *IngestPipelineReport) addShed(cptShed int64) *IngestPipelineReport {
+	if cptShed == 0 {
+		return r
+	}
+	if r == nil {
+		r = &IngestPipelineReport{}
+	}
+	r.CountShed += cptShed
+	return r
+}
+
+func (s *Stream) loadIngestPipeline(properties types.StreamProperties) error {
+	pipeline, err := types.NewIngestPipeline(properties)
+	if err != nil {
+		return err
+	}
+	s.ingestPipeline.Store(&pipeline)
+	return nil
+}
+
+func (s *Stream) getIngestPipeline() types.IngestPipeline {
+	if s.parent != nil {
+		// the partitions run the ingest pipeline of the partitioned stream
+		return s.parent.getIngestPipeline()
+	}
+	if pipeline := s.ingestPipeline.Load(); pipeline != nil {
+		return *pipeline
+	}
+	return nil
+}
+
+func (s *Stream) applyIngestPipeline(records []interface{}, headers []types.RecordHeaders) ([]interface{}, []bool, *IngestPipelineReport) {
+	// Run the ingest pipeline on the records (headers is either nil or holds the headers of each record).
+	// Returns the transformed records and whether each record must be saved (nil if the stream has no pipeline).
+	pipeline := s.getIngestPipeline()
+	if pipeline == nil {
+		return records, nil, nil
+	}
+
+	report := IngestPipelineReport{}
+	var deadLetters []*types.DeadLetter
+	output := make([]interface{}, len(records))
+	keep := make([]bool, len(records))
+	for i, record := range records {
+		var recordHeaders types.RecordHeaders
+		if headers != nil {
+			recordHeaders = headers[i]
+		}
+
+		result, kept, err := pipeline.Run(record, recordHeaders, s.info.UUID)
+		switch {
+		case err != nil:
+			report.CountErrors++
+			if len(report.Errors) < MaxIngestPipelineErrors {
+				pipelineErr := err.(*types.IngestPipelineError)
+				report.Errors = append(report.Errors, &IngestPipelineRecordError{Index: i, Step: pipelineErr.Step, Error: pipelineErr.Err.Error()})
+			}
+			deadLetters = append(deadLetters, &types.DeadLetter{Source: types.DeadLetterSourceIngestPipeline, Reason: err.Error(), Message: record, Headers: recordHeaders})
+		case !kept:
+			report.CountDropped++
+		default:
+			output[i] = result
+			keep[i] = true
+		}
+	}
+
+	if report.CountErrors > 0 && s.logVerbosity > 0 {
+		s.logger.Debug(
+			"Records rejected by the ingest pipeline",
+			zap.String("topic", "stream"),
+			zap.String("method", "applyIngestPipeline"),
+			zap.String("stream.uuid", s.info.UUID.String()),
+			zap.Int64("records.errors", report.CountErrors),
+			zap.Int64("records.dropped", report.CountDropped),
+		)
+	}
+
+	s.SendToDeadLetterStream(deadLetters)
+	return output, keep, &report
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/nbigot/ministream/buffering"
 	"github.com/nbigot/ministream/constants"
 	"github.com/nbigot/ministream/types"
 	"github.com/nbigot/ministream/web/apierror"
@@ -14,7 +15,6 @@ import (
 	"github.com/goccy/go-json"
 	"github.com/itchyny/gojq"
 	"github.com/qri-io/jsonschema"
-	"github.com/valyala/fasthttp"
 	"go.uber.org/zap"
 )
 
@@ -39,8 +39,19 @@ type StreamIterator struct {
 	Stats              StreamIteratorStats
 	handler            types.IStreamIteratorHandler
 	getRecordsBusyFlag atomic.Bool
+	closed             atomic.Bool
+	closeRequested     atomic.Bool   // the iterator is closed as soon as it is not reading records
+	closing            chan struct{} // closed when the iterator is asked to close, ends a long polling wait
 	logger             *zap.Logger
-	// TODO: add timeout (self delete at timeout)
+	creationDate       time.Time
+	lastAccess         atomic.Int64              // unix nano timestamp of the last use of the iterator
+	idleTimeout        time.Duration             // delete the iterator if not used for this duration (0 means never)
+	maxLifetime        time.Duration             // delete the iterator after this duration since creation (0 means never)
+	notifier           *buffering.StreamNotifier // wakes up long polling when new records are readable
+	streamDone         <-chan struct{}           // closed when the stream is stopping
+	rangeSource        *Stream                   // stream (or partition) read by an iterator stopping at the end of a range
+	ended              bool                      // the end of the range has been reached
+	internal           bool                      // opened by the server to scan the stream (query, export...), not by a client
 }
 
 var rs = jsonschema.Schema{}
@@ -49,6 +60,15 @@ func (it *StreamIterator) GetUUID() types.StreamIteratorUUID {
 	return it.itUUID
 }
 
+func (it *StreamIterator) SetInternal() {
+	// the iterator is opened by the server, it is not counted in the limit of iterators of the stream
+	it.internal = true
+}
+
+func (it *StreamIterator) IsInternal() bool {
+	return it.internal
+}
+
 func (it *StreamIterator) GetName() string {
 	if it.request != nil {
 		return it.request.Name
@@ -57,11 +77,123 @@ func (it *StreamIterator) GetName() string {
 	}
 }
 
+func (it *StreamIterator) GetConsumerGroup() string {
+	if it.request != nil {
+		return it.request.ConsumerGroup
+	} else {
+		return ""
+	}
+}
+
+func (it *StreamIterator) SetTimeouts(idleTimeout time.Duration, maxLifetime time.Duration) {
+	it.idleTimeout = idleTimeout
+	it.maxLifetime = maxLifetime
+}
+
+func (it *StreamIterator) SetNotifier(notifier *buffering.StreamNotifier, streamDone <-chan struct{}) {
+	it.notifier = notifier
+	it.streamDone = streamDone
+}
+
+func (it *StreamIterator) waitForRecords(ctx context.Context, notified <-chan struct{}, deadline time.Time) bool {
+	// Wait until new records are readable.
+	// Returns false if the wait ended without new records (timeout, stream stopping, iterator closed, request cancelled)
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-notified:
+		return true
+	case <-timer.C:
+		return false
+	case <-it.streamDone:
+		return false
+	case <-it.closing:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (it *StreamIterator) GetMaxWaitTime() time.Duration {
+	if it.request == nil {
+		return 0
+	}
+	return time.Duration(it.request.MaxWaitTimeSeconds) * time.Second
+}
+
+func (it *StreamIterator) GetCreationDate() time.Time {
+	return it.creationDate
+}
+
+func (it *StreamIterator) GetLastAccess() time.Time {
+	return time.Unix(0, it.lastAccess.Load())
+}
+
+func (it *StreamIterator) GetExpiresAt() time.Time {
+	// return the time when the iterator will expire (zero time means the iterator never expires)
+	var expiresAt time.Time
+	if it.idleTimeout > 0 {
+		expiresAt = it.GetLastAccess().Add(it.idleTimeout)
+	}
+	if it.maxLifetime > 0 {
+		maxLifetimeExpiresAt := it.creationDate.Add(it.maxLifetime)
+		if expiresAt.IsZero() || maxLifetimeExpiresAt.Before(expiresAt) {
+			expiresAt = maxLifetimeExpiresAt
+		}
+	}
+	return expiresAt
+}
+
+func (it *StreamIterator) IsExpired(now time.Time) bool {
+	expiresAt := it.GetExpiresAt()
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+func (it *StreamIterator) TryAcquire() bool {
+	// try to mark the iterator as busy, returns false if the iterator is already busy
+	return it.getRecordsBusyFlag.CompareAndSwap(false, true)
+}
+
+func (it *StreamIterator) Release() {
+	it.getRecordsBusyFlag.Store(false)
+	if it.closeRequested.Load() && it.TryAcquire() {
+		// the iterator has been asked to close while it was reading records,
+		// it stays busy once closed therefore it can't be read anymore
+		it.close()
+	}
+}
+
+func (it *StreamIterator) CloseWhenIdle() error {
+	// Close the iterator at once if it is not reading records, otherwise the reader closes it once done
+	// (a long polling wait ends at once). The iterator stays busy once closed.
+	if it.closeRequested.Swap(true) {
+		// already closed or being closed
+		return nil
+	}
+	close(it.closing)
+
+	if !it.TryAcquire() {
+		return nil
+	}
+	return it.close()
+}
+
+func (it *StreamIterator) touch() {
+	it.lastAccess.Store(time.Now().UnixNano())
+}
+
 func (it *StreamIterator) Open() error {
 	return it.handler.Open()
 }
 
 func (it *StreamIterator) Close() error {
+	// the caller must have acquired the iterator (see TryAcquire), otherwise use CloseWhenIdle
+	return it.close()
+}
+
+func (it *StreamIterator) close() error {
+	it.closed.Store(true)
 	it.request = nil
 	it.jqFilter = nil
 	return it.handler.Close()
@@ -75,9 +207,21 @@ func (it *StreamIterator) SaveSeek() error {
 	return it.handler.SaveSeek()
 }
 
-func (it *StreamIterator) GetRecords(c *fasthttp.RequestCtx, maxRecords uint) (*GetStreamRecordsResponse, error) {
+func (it *StreamIterator) GetRecords(ctx context.Context, maxRecords uint) (*GetStreamRecordsResponse, error) {
+	return it.getRecords(ctx, maxRecords, true)
+}
+
+func (it *StreamIterator) GetRecordsWithoutWait(ctx context.Context, maxRecords uint) (*GetStreamRecordsResponse, error) {
+	// returns the records readable right now, even if the iterator is long polling
+	return it.getRecords(ctx, maxRecords, false)
+}
+
+func (it *StreamIterator) getRecords(ctx context.Context, maxRecords uint, wait bool) (*GetStreamRecordsResponse, error) {
 	var err error
 	startTime := time.Now()
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
 	response := GetStreamRecordsResponse{
 		Status:             "",
@@ -97,7 +241,8 @@ func (it *StreamIterator) GetRecords(c *fasthttp.RequestCtx, maxRecords uint) (*
 	}()
 
 	// check mutex
-	if it.getRecordsBusyFlag.Load() {
+	acquired := it.TryAcquire()
+	if !acquired && !it.closeRequested.Load() {
 		// if the flag is already set, then the iterator is busy,
 		// therefore, return a busy status to tell the client to retry later
 
@@ -114,8 +259,35 @@ func (it *StreamIterator) GetRecords(c *fasthttp.RequestCtx, maxRecords uint) (*
 		return &response, &apiErr
 	}
 
-	defer it.getRecordsBusyFlag.Store(false)
-	it.getRecordsBusyFlag.Store(true)
+	if acquired {
+		defer it.Release()
+	}
+
+	if !acquired || it.closeRequested.Load() || it.closed.Load() {
+		// the iterator has expired or has been closed in the meantime
+		apiErr := apierror.APIError{
+			StreamUUID: it.streamUUID,
+			Message:    "cannot get records",
+			Details:    "iterator not found",
+			Code:       constants.ErrorStreamIteratorNotFound,
+			HttpCode:   fiber.StatusBadRequest,
+			Err:        nil,
+		}
+
+		response.Status = "error"
+		return &response, &apiErr
+	}
+
+	it.touch()
+	defer it.touch()
+
+	if it.ended {
+		// nothing more to read
+		response.Ended = true
+		response.LastRecordIdRead = it.LastRecordIdRead
+		response.Status = "success"
+		return &response, nil
+	}
 
 	if err = it.Seek(); err != nil {
 		response.Status = "error"
@@ -130,12 +302,32 @@ func (it *StreamIterator) GetRecords(c *fasthttp.RequestCtx, maxRecords uint) (*
 		lastRecordIdProcessed types.MessageId
 		foundRecord           bool
 		canContinue           bool
+		notified              <-chan struct{}
 	)
 
+	waitDeadline := startTime.Add(time.Duration(it.request.MaxWaitTimeSeconds) * time.Second)
+	hasEnd := it.request.HasEnd()
+
 	for {
+		// check before reading, therefore a record saved in the meantime can't be missed
+		rangeReadable := hasEnd && it.isRangeReadable()
+
 		recordId, record, foundRecord, canContinue, err = it.handler.GetNextRecord()
 
+		if !foundRecord && err != nil {
+			// the storage can't be read
+			break
+		}
+
 		if !foundRecord {
+			if rangeReadable || it.request.IsBackward() {
+				// all the records of the range have been read
+				// (reading backward, the first record of the stream has been read)
+				it.ended = true
+				err = nil
+				break
+			}
+
 			// No record found, this is the end of the stream.
 			// Long polling is activated when the 'createIterator' request has
 			// the 'MaxWaitTimeSeconds' attribute set to a value greater than 0.
@@ -143,12 +335,17 @@ func (it *StreamIterator) GetRecords(c *fasthttp.RequestCtx, maxRecords uint) (*
 			// until new information is available or a timeout occurs.
 			// Long polling reducing the occurrence of empty responses,
 			// which occur when there are no messages available for a GetRecords request.
-			if response.Count == 0 && it.request.MaxWaitTimeSeconds > 0 {
-				elaspedTime := int(time.Since(startTime).Seconds())
-				if elaspedTime < it.request.MaxWaitTimeSeconds {
-					// request has not reached its timeout yet
-					time.Sleep(time.Second)
-					// continue to search again
+			// The writer notifies the iterator as soon as new records are readable.
+			if response.Count == 0 && wait && it.notifier != nil && time.Now().Before(waitDeadline) {
+				if notified == nil {
+					// subscribe first, then search again,
+					// therefore records saved in the meantime can't be missed
+					notified = it.notifier.Wait()
+					continue
+				}
+				if it.waitForRecords(ctx, notified, waitDeadline) {
+					// new records are readable, continue to search again
+					notified = nil
 					continue
 				}
 			}
@@ -157,11 +354,23 @@ func (it *StreamIterator) GetRecords(c *fasthttp.RequestCtx, maxRecords uint) (*
 			break
 		}
 
+		if hasEnd && it.isAfterRangeEnd(recordId, record) {
+			// the record is not part of the range
+			it.ended = true
+			err = nil
+			break
+		}
+
 		lastRecordIdProcessed = recordId
 
 		if err != nil {
 			response.CountErrors += 1
 			if canContinue {
+				if hasEnd && it.isRangeEndReached(recordId) {
+					it.ended = true
+					err = nil
+					break
+				}
 				continue
 			} else {
 				// non recoverable error, cannot simply skip this record
@@ -185,7 +394,7 @@ func (it *StreamIterator) GetRecords(c *fasthttp.RequestCtx, maxRecords uint) (*
 			// TODO: iterator checkpoint?
 			// TODO: save iterator last seek file?
 
-			jqIter := it.jqFilter.RunWithContext(c, record)
+			jqIter := it.jqFilter.RunWithContext(ctx, record)
 			v, ok := jqIter.Next()
 			if ok {
 				// the message is matching the jq filter
@@ -210,6 +419,11 @@ func (it *StreamIterator) GetRecords(c *fasthttp.RequestCtx, maxRecords uint) (*
 			}
 		}
 
+		if hasEnd && it.isRangeEndReached(recordId) {
+			it.ended = true
+			break
+		}
+
 		if uint(len(response.Records)) >= maxRecords {
 			// reach the maximum allowed records count by response
 			response.Remain = true
@@ -223,12 +437,16 @@ func (it *StreamIterator) GetRecords(c *fasthttp.RequestCtx, maxRecords uint) (*
 		return &response, err
 	}
 
-	it.LastRecordIdRead = lastRecordIdProcessed
+	if lastRecordIdProcessed > 0 {
+		// keep the previous position if no record was read
+		it.LastRecordIdRead = lastRecordIdProcessed
+	}
 	it.Stats.RecordsErrors += response.CountErrors
 	it.Stats.RecordsSkipped += response.CountSkipped
 	it.Stats.RecordsSent += response.Count
 
-	response.LastRecordIdRead = lastRecordIdProcessed
+	response.LastRecordIdRead = it.LastRecordIdRead
+	response.Ended = it.ended
 
 	if err = it.SaveSeek(); err != nil {
 		response.Status = "error"
@@ -250,13 +468,16 @@ func NewStreamIterator(streamUUID types.StreamUUID, iteratorUUID types.StreamIte
 	}
 
 	it := StreamIterator{
-		streamUUID: streamUUID,
-		itUUID:     iteratorUUID,
-		request:    r,
-		jqFilter:   jqFilter,
-		handler:    handler,
-		logger:     logger,
+		streamUUID:   streamUUID,
+		itUUID:       iteratorUUID,
+		request:      r,
+		jqFilter:     jqFilter,
+		handler:      handler,
+		logger:       logger,
+		creationDate: time.Now(),
+		closing:      make(chan struct{}),
 	}
+	it.touch()
 
 	return &it, nil
 }
@@ -304,10 +525,32 @@ func init() {
 								"minLength": 1,
 								"maxLength": 256
 						},
+						"consumerGroup": {
+								"type": "string",
+								"pattern": "^[a-zA-Z0-9_.\\-]{1,256}$"
+						},
 						"maxWaitTimeSeconds": {
 							"type": "integer",
 							"minimum": 0,
 						  "maximum": 60
+						},
+						"partition": {
+							"type": "integer",
+							"minimum": 0
+						},
+						"untilMessageId": {
+							"type": "integer",
+							"minimum": 0
+						},
+						"untilTimestamp": {
+							"type": "string",
+							"pattern": "^([0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\\.[0-9]{1,9})?(Z|[+\\-][0-9]{2}:[0-9]{2})|-([0-9]+(\\.[0-9]+)?(ns|us|ms|s|m|h))+)$"
+						},
+						"snapshot": {
+							"type": "boolean"
+						},
+						"direction": {
+							"enum": ["forward", "backward"]
 						}
 					},
 					"required": ["iteratorType"],
@@ -333,10 +576,32 @@ func init() {
 								"minLength": 1,
 								"maxLength": 256
 						},
+						"consumerGroup": {
+								"type": "string",
+								"pattern": "^[a-zA-Z0-9_.\\-]{1,256}$"
+						},
 						"maxWaitTimeSeconds": {
 							"type": "integer",
 							"minimum": 0,
 						  "maximum": 60
+						},
+						"partition": {
+							"type": "integer",
+							"minimum": 0
+						},
+						"untilMessageId": {
+							"type": "integer",
+							"minimum": 0
+						},
+						"untilTimestamp": {
+							"type": "string",
+							"pattern": "^([0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\\.[0-9]{1,9})?(Z|[+\\-][0-9]{2}:[0-9]{2})|-([0-9]+(\\.[0-9]+)?(ns|us|ms|s|m|h))+)$"
+						},
+						"snapshot": {
+							"type": "boolean"
+						},
+						"direction": {
+							"enum": ["forward", "backward"]
 						}
 					},
 					"required": ["iteratorType", "messageId"],
@@ -350,7 +615,7 @@ func init() {
 						},
 						"timestamp": {
 								"type": "string",
-								"pattern": "^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}Z$"
+								"pattern": "^([0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\\.[0-9]{1,9})?(Z|[+\\-][0-9]{2}:[0-9]{2})|-([0-9]+(\\.[0-9]+)?(ns|us|ms|s|m|h))+)$"
 						},
 						"jqFilter": {
 								"type": "string",
@@ -362,10 +627,32 @@ func init() {
 								"minLength": 1,
 								"maxLength": 256
 						},
+						"consumerGroup": {
+								"type": "string",
+								"pattern": "^[a-zA-Z0-9_.\\-]{1,256}$"
+						},
 						"maxWaitTimeSeconds": {
 							"type": "integer",
 							"minimum": 0,
 						  "maximum": 60
+						},
+						"partition": {
+							"type": "integer",
+							"minimum": 0
+						},
+						"untilMessageId": {
+							"type": "integer",
+							"minimum": 0
+						},
+						"untilTimestamp": {
+							"type": "string",
+							"pattern": "^([0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\\.[0-9]{1,9})?(Z|[+\\-][0-9]{2}:[0-9]{2})|-([0-9]+(\\.[0-9]+)?(ns|us|ms|s|m|h))+)$"
+						},
+						"snapshot": {
+							"type": "boolean"
+						},
+						"direction": {
+							"enum": ["forward", "backward"]
 						}
 					},
 					"required": ["iteratorType", "timestamp"],
@@ -423,4 +710,41 @@ Valid iterator request examples:
 	"iteratorType": "FIRST_MESSAGE"
 	"maxWaitTimeSeconds": "20"
 }
+
+{
+	"iteratorType": "FIRST_MESSAGE"
+	"consumerGroup": "myApp"
+}
+
+{
+	"iteratorType": "FIRST_MESSAGE"
+	"partition": 2
+}
+
+{
+	"iteratorType": "AT_TIMESTAMP",
+	"timestamp": "2006-01-02T15:04:06.123456789Z",
+	"untilTimestamp": "2006-01-02T16:04:06.123456789+02:00"
+}
+
+{
+	"iteratorType": "AT_TIMESTAMP",
+	"timestamp": "-15m"
+}
+
+{
+	"iteratorType": "AT_MESSAGE_ID",
+	"messageId": 1234,
+	"untilMessageId": 5678
+}
+
+{
+	"iteratorType": "FIRST_MESSAGE"
+	"snapshot": true
+}
+
+{
+	"iteratorType": "LAST_MESSAGE"
+	"direction": "backward"
+}
 */
@@ -0,0 +1,87 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/nbigot/ministream/types"
+)
+
+func (s *Stream) isRangeReadable(req *types.StreamIteratorRequest) bool {
+	// returns true if all the records of the range of an iterator are readable,
+	// therefore the end of the range is reached as soon as no more record is found
+	if len(s.partitions) > 0 {
+		// iterator reading all the partitions
+		for _, partition := range s.partitions {
+			if !partition.isRangeReadable(req) {
+				return false
+			}
+		}
+		return true
+	}
+
+	readable := s.getReadableMessages()
+	switch {
+	case req.UntilMessageId != nil:
+		return readable.LastMsgId >= *req.UntilMessageId
+	case req.UntilTimestamp != nil:
+		if readable.LastMsgId > 0 && !readable.LastMsgTimestamp.Before(*req.UntilTimestamp) {
+			return true
+		}
+		// the records put from now are out of the range, the range is over once the ingest buffer is saved
+		return !time.Now().Before(*req.UntilTimestamp) && readable.LastMsgId >= s.GetLastIngestedMsgId()
+	}
+	return false
+}
+
+func (it *StreamIterator) SetRangeSource(s *Stream) {
+	// set the stream (or the partition) read by an iterator stopping at the end of a range of records
+	it.rangeSource = s
+}
+
+func (it *StreamIterator) isRangeReadable() bool {
+	return it.rangeSource != nil && it.rangeSource.isRangeReadable(it.request)
+}
+
+func (it *StreamIterator) isAfterRangeEnd(recordId types.MessageId, record interface{}) bool {
+	// returns true if the record is out of the range of the iterator (the record is not sent)
+	backward := it.request.IsBackward()
+	switch {
+	case it.request.UntilMessageId != nil && backward:
+		return recordId < *it.request.UntilMessageId
+	case it.request.UntilMessageId != nil:
+		return recordId > *it.request.UntilMessageId
+	case it.request.UntilTimestamp != nil:
+		if date, ok := getRecordDate(record); ok {
+			return date.Before(*it.request.UntilTimestamp) == backward
+		}
+	}
+	return false
+}
+
+func (it *StreamIterator) isRangeEndReached(recordId types.MessageId) bool {
+	// returns true if the record is the last one of the range of the iterator
+	if it.request.UntilMessageId == nil {
+		return false
+	}
+	if it.request.IsBackward() {
+		return recordId <= *it.request.UntilMessageId
+	}
+	return recordId >= *it.request.UntilMessageId
+}
+
+func getRecordDate(record interface{}) (time.Time, bool) {
+	// the records read from the storage providers hold their creation date ({"i": ..., "d": ..., "m": ...})
+	fields, ok := record.(map[string]interface{})
+	if !ok {
+		return time.Time{}, false
+	}
+	strDate, ok := fields["d"].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	date, err := time.Parse(time.RFC3339Nano, strDate)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
@@ -0,0 +1,101 @@
+package stream
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const minIteratorsReaperInterval = time.Second
+const maxIteratorsReaperInterval = 30 * time.Second
+
+func (s *Stream) getIteratorsReaperInterval() time.Duration {
+	// check the iterators a few times within the shortest timeout
+	interval := time.Duration(0)
+	for _, ttl := range []time.Duration{s.iteratorIdleTTL, s.iteratorMaxTTL} {
+		if ttl > 0 && (interval == 0 || ttl/4 < interval) {
+			interval = ttl / 4
+		}
+	}
+
+	if interval == 0 {
+		// iterators never expire
+		return 0
+	}
+	if interval < minIteratorsReaperInterval {
+		return minIteratorsReaperInterval
+	}
+	if interval > maxIteratorsReaperInterval {
+		return maxIteratorsReaperInterval
+	}
+	return interval
+}
+
+func (s *Stream) startIteratorsReaper() {
+	interval := s.getIteratorsReaperInterval()
+	if interval == 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.ReapExpiredIterators()
+			}
+		}
+	}()
+}
+
+func (s *Stream) ReapExpiredIterators() int {
+	// close and delete the expired iterators, returns the number of iterators deleted
+	if s.iteratorIdleTTL <= 0 && s.iteratorMaxTTL <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	cpt := 0
+	s.muIterators.Lock()
+	defer s.muIterators.Unlock()
+	for itUUID, it := range s.iterators {
+		if !it.IsExpired(now) {
+			continue
+		}
+
+		// never delete an iterator while it is reading records
+		if !it.TryAcquire() {
+			continue
+		}
+
+		if err := it.Close(); err != nil {
+			s.logger.Error(
+				"Can't close expired stream iterator",
+				zap.String("topic", "stream"),
+				zap.String("method", "ReapExpiredIterators"),
+				zap.String("stream.uuid", s.info.UUID.String()),
+				zap.String("it.uuid", itUUID.String()),
+				zap.Error(err),
+			)
+		}
+		it.Release()
+		delete(s.iterators, itUUID)
+		cpt++
+
+		s.logger.Info(
+			"Stream iterator expired",
+			zap.String("topic", "stream"),
+			zap.String("method", "ReapExpiredIterators"),
+			zap.String("stream.uuid", s.info.UUID.String()),
+			zap.String("it.uuid", itUUID.String()),
+			zap.Time("it.lastAccess", it.GetLastAccess()),
+		)
+	}
+
+	return cpt
+}
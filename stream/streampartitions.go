@@ -0,0 +1,81 @@
+package stream
+
+import (
+	"fmt"
+
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+func (s *Stream) SetPartitions(partitions []*Stream) {
+	// attach the partitions to the partitioned stream,
+	// the iterators of the partitioned stream are woken up when new records are saved into any partition
+	s.partitions = partitions
+	for _, partition := range partitions {
+		partition.parent = s
+		if partition.ingestBuffer != nil && s.ingestBuffer != nil {
+			partition.ingestBuffer.GetNotifier().SetParent(s.ingestBuffer.GetNotifier())
+		}
+	}
+
+	s.logger.Info(
+		"Attach stream partitions",
+		zap.String("topic", "stream"),
+		zap.String("method", "SetPartitions"),
+		zap.String("stream.uuid", s.info.UUID.String()),
+		zap.Int("partitions", len(partitions)),
+	)
+}
+
+func (s *Stream) IsPartitioned() bool {
+	return s.info.IsPartitioned()
+}
+
+func (s *Stream) IsPartition() bool {
+	return s.info.IsPartition()
+}
+
+func (s *Stream) GetParent() *Stream {
+	return s.parent
+}
+
+func (s *Stream) GetPartitions() []*Stream {
+	return s.partitions
+}
+
+func (s *Stream) GetPartitionsCount() int {
+	return len(s.partitions)
+}
+
+func (s *Stream) GetPartition(partition int) (*Stream, error) {
+	if partition < 0 || partition >= len(s.partitions) {
+		return nil, fmt.Errorf("invalid partition %d, stream has %d partitions", partition, len(s.partitions))
+	}
+	return s.partitions[partition], nil
+}
+
+func (s *Stream) GetPartitionForKey(key string) (*Stream, int) {
+	// returns the partition where to put the records having this key,
+	// the records without key are spread over the partitions (round robin)
+	var partition int
+	if key == "" {
+		partition = int((s.nextPartition.Add(1) - 1) % uint32(len(s.partitions)))
+	} else {
+		partition = types.GetPartitionForKey(key, len(s.partitions))
+	}
+	return s.partitions[partition], partition
+}
+
+func (s *Stream) GetPartitionsHandlers(newHandler func(partitionUUID types.StreamUUID) (types.IStreamIteratorHandler, error)) (types.IStreamIteratorHandler, error) {
+	// returns an iterator handler reading all the partitions of the stream
+	handlers := make([]types.IStreamIteratorHandler, len(s.partitions))
+	for i, partition := range s.partitions {
+		handler, err := newHandler(partition.GetUUID())
+		if err != nil {
+			return nil, err
+		}
+		handlers[i] = handler
+	}
+	return NewPartitionsIteratorHandler(handlers), nil
+}
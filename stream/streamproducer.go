@@ -0,0 +1,198 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+// Function used to persist the state of an idempotent producer (usually provided by the storage provider)
+type ProducerSaver func(producer *types.Producer) error
+
+// The batches of a producer are put one at a time, the lock is forgotten once no batch of the producer is put
+type producerLock struct {
+	sync.Mutex
+	cptHolders int
+}
+
+type ProducerSequenceError struct {
+	ProducerId       string
+	Sequence         uint64
+	ExpectedSequence uint64
+}
+
+func (e *ProducerSequenceError) Error() string {
+	if e.Sequence < e.ExpectedSequence {
+		return fmt.Sprintf("sequence %d of producer %s has already been put and is too old to be retried (expected sequence %d)", e.Sequence, e.ProducerId, e.ExpectedSequence)
+	}
+	return fmt.Sprintf("sequence %d of producer %s is out of order, expected sequence %d", e.Sequence, e.ProducerId, e.ExpectedSequence)
+}
+
+func (s *Stream) SetProducers(producers types.ProducerList) {
+	s.muProducers.Lock()
+	defer s.muProducers.Unlock()
+
+	s.producers = make(map[string]*types.Producer, len(producers))
+	s.unsavedProducers = make(map[string]bool)
+	for _, producer := range producers {
+		s.producers[producer.Id] = producer
+	}
+}
+
+func (s *Stream) GetProducer(id string) (*types.Producer, bool) {
+	s.muProducers.Lock()
+	defer s.muProducers.Unlock()
+
+	if producer, found := s.producers[id]; found {
+		p := *producer
+		return &p, true
+	}
+
+	return nil, false
+}
+
+func (s *Stream) PutIdempotent(ctx context.Context, producerId string, sequence uint64, save ProducerSaver, put func() (*types.ProducerBatch, error)) (*types.ProducerBatch, bool, error) {
+	// Put a batch of records of an idempotent producer: put is called only if the sequence is the next one of the producer.
+	// Returns the batch put and true if the batch is a duplicate (the original batch is returned, nothing is put).
+	if !types.IsValidProducerId(producerId) {
+		return nil, false, fmt.Errorf("invalid producer id: %s", producerId)
+	}
+	if sequence == 0 {
+		return nil, false, fmt.Errorf("invalid sequence of producer %s: the sequence starts from 1", producerId)
+	}
+
+	// the batches of a producer are put one at a time, therefore its sequences are checked and saved in order
+	// (the batches of different producers are put concurrently)
+	s.lockProducer(producerId)
+	defer s.unlockProducer(producerId)
+
+	s.muProducers.Lock()
+	var producer types.Producer
+	if current, found := s.producers[producerId]; found {
+		producer = *current
+		producer.Batches = append([]*types.ProducerBatch{}, current.Batches...)
+	} else {
+		producer = *types.NewProducer(s.info.UUID, producerId)
+	}
+	unsaved := s.unsavedProducers[producerId]
+	s.muProducers.Unlock()
+
+	if sequence <= producer.LastSequence {
+		if batch, found := producer.GetBatch(sequence); found {
+			if s.logVerbosity > 0 {
+				s.logger.Debug(
+					"Duplicate producer batch",
+					zap.String("topic", "stream"),
+					zap.String("method", "PutIdempotent"),
+					zap.String("stream.uuid", s.info.UUID.String()),
+					zap.String("producer", producerId),
+					zap.Uint64("sequence", sequence),
+				)
+			}
+			// the batch put is acknowledged once the producer is saved
+			if unsaved {
+				if err := s.saveProducer(ctx, &producer, batch, save); err != nil {
+					return nil, false, err
+				}
+			}
+			return batch, true, nil
+		}
+	}
+	if sequence != producer.LastSequence+1 {
+		return nil, false, &ProducerSequenceError{ProducerId: producerId, Sequence: sequence, ExpectedSequence: producer.LastSequence + 1}
+	}
+
+	batch, err := put()
+	if err != nil {
+		return nil, false, err
+	}
+	batch.Sequence = sequence
+	producer.AddBatch(batch)
+
+	// the records are put, the batch is kept in memory even if the producer can't be saved: the batch is not
+	// acknowledged (an error is returned) and the producer is saved again when the batch is retried,
+	// therefore the records are not put twice (unless the server restarts before the producer is saved)
+	s.muProducers.Lock()
+	s.producers[producerId] = &producer
+	s.unsavedProducers[producerId] = true
+	s.muProducers.Unlock()
+	if err = s.saveProducer(ctx, &producer, batch, save); err != nil {
+		return nil, false, err
+	}
+
+	return batch, false, nil
+}
+
+func (s *Stream) lockProducer(producerId string) {
+	s.muProducers.Lock()
+	lock, found := s.producerLocks[producerId]
+	if !found {
+		lock = &producerLock{}
+		s.producerLocks[producerId] = lock
+	}
+	lock.cptHolders++
+	s.muProducers.Unlock()
+
+	lock.Lock()
+}
+
+func (s *Stream) unlockProducer(producerId string) {
+	s.muProducers.Lock()
+	defer s.muProducers.Unlock()
+
+	lock := s.producerLocks[producerId]
+	lock.cptHolders--
+	if lock.cptHolders == 0 {
+		delete(s.producerLocks, producerId)
+	}
+	lock.Unlock()
+}
+
+func (s *Stream) saveProducer(ctx context.Context, producer *types.Producer, batch *types.ProducerBatch, save ProducerSaver) error {
+	// the producer is saved once the records of the batch are persisted, otherwise a crash in between
+	// would answer the retried batch as a duplicate of records that were never written
+	if err := s.waitForBatchPersisted(ctx, batch); err != nil {
+		return fmt.Errorf("records of producer %s not persisted, retry the batch with sequence %d: %w", producer.Id, batch.Sequence, err)
+	}
+
+	if err := save(producer); err != nil {
+		s.logger.Error(
+			"Can't save producer",
+			zap.String("topic", "stream"),
+			zap.String("method", "PutIdempotent"),
+			zap.String("stream.uuid", s.info.UUID.String()),
+			zap.String("producer", producer.Id),
+			zap.Uint64("sequence", batch.Sequence),
+			zap.Error(err),
+		)
+		return fmt.Errorf("can't save producer %s, retry the batch with sequence %d: %w", producer.Id, batch.Sequence, err)
+	}
+
+	s.muProducers.Lock()
+	defer s.muProducers.Unlock()
+	delete(s.unsavedProducers, producer.Id)
+	return nil
+}
+
+func (s *Stream) waitForBatchPersisted(ctx context.Context, batch *types.ProducerBatch) error {
+	// the records of a partitioned stream are persisted by the partition where they were put,
+	// they are synced to the disk first if the stream requires it
+	target := s
+	if batch.Partition != nil {
+		partitionPtr, err := s.GetPartition(*batch.Partition)
+		if err != nil {
+			return err
+		}
+		target = partitionPtr
+	}
+
+	level := types.DurabilityFlushed
+	if durability, err := s.GetDurability(); err == nil && durability == types.DurabilityFsynced {
+		level = types.DurabilityFsynced
+	}
+	return target.WaitForDurability(ctx, level, batch.MessageIds)
+}
@@ -0,0 +1,91 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+func WithRetention(policy types.RetentionPolicy, checkInterval time.Duration) StreamOption {
+	// default retention policy of the stream (can be overridden by the stream properties),
+	// the policy is applied every checkInterval (0 means never)
+	return func(s *Stream) {
+		s.retentionPolicy = policy
+		s.retentionPeriod = checkInterval
+	}
+}
+
+func (s *Stream) GetRetentionPolicy() (types.RetentionPolicy, error) {
+	if s.parent != nil {
+		// the partitions follow the retention policy of the partitioned stream
+		return s.retentionPolicy.WithProperties(s.parent.info.Properties)
+	}
+	return s.retentionPolicy.WithProperties(s.info.Properties)
+}
+
+func (s *Stream) startRetentionTimer() {
+	if s.retentionPeriod <= 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.retentionPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				_, _ = s.ApplyRetention()
+			}
+		}
+	}()
+}
+
+func (s *Stream) ApplyRetention() (types.Size64, error) {
+	// remove the records that are out of the retention policy, returns the number of records removed
+	policy, err := s.GetRetentionPolicy()
+	if err != nil {
+		s.logger.Error(
+			"Invalid retention policy",
+			zap.String("topic", "stream"),
+			zap.String("method", "ApplyRetention"),
+			zap.String("stream.uuid", s.info.UUID.String()),
+			zap.Error(err),
+		)
+		return 0, err
+	}
+
+	if !policy.IsEnabled() {
+		return 0, nil
+	}
+
+	cptRemoved, err := s.ingestBuffer.Trim(&policy, time.Now())
+	if err != nil {
+		s.logger.Error(
+			"Can't apply retention policy",
+			zap.String("topic", "stream"),
+			zap.String("method", "ApplyRetention"),
+			zap.String("stream.uuid", s.info.UUID.String()),
+			zap.Error(err),
+		)
+		return 0, err
+	}
+
+	if cptRemoved > 0 && s.logVerbosity > 0 {
+		s.logger.Debug(
+			"Retention policy applied",
+			zap.String("topic", "stream"),
+			zap.String("method", "ApplyRetention"),
+			zap.String("stream.uuid", s.info.UUID.String()),
+			zap.Uint64("records.removed", cptRemoved),
+			zap.Uint64("stream.readableMessages.firstMsgId", s.info.ReadableMessages.FirstMsgId),
+		)
+	}
+
+	return cptRemoved, nil
+}
@@ -0,0 +1,259 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/goccy/go-json"
+	"github.com/qri-io/jsonschema"
+	"go.uber.org/zap"
+)
+
+// Function used to persist a version of a schema (usually provided by the storage provider)
+type StreamSchemaSaver func(schema *types.StreamSchema) error
+
+// Maximum number of validation errors returned when records do not match the schema
+const MaxSchemaValidationErrors = 100
+
+var ErrStreamSchemaNotFound = errors.New("schema not found")
+var ErrInvalidStreamSchema = errors.New("invalid schema")
+
+type IncompatibleSchemaError struct {
+	Compatibility string
+	Reasons       []string
+}
+
+func (e *IncompatibleSchemaError) Error() string {
+	return fmt.Sprintf("schema is not %s compatible with the latest version: %s", e.Compatibility, strings.Join(e.Reasons, ", "))
+}
+
+func WithSchemaCompatibility(mode string) StreamOption {
+	// default compatibility mode enforced when a new schema version is registered
+	// (can be overridden by the stream properties)
+	return func(s *Stream) {
+		s.schemaCompatibility = mode
+	}
+}
+
+func (s *Stream) GetSchemaCompatibility() (string, error) {
+	if s.parent != nil {
+		return types.GetSchemaCompatibility(s.schemaCompatibility, s.parent.info.Properties)
+	}
+	return types.GetSchemaCompatibility(s.schemaCompatibility, s.info.Properties)
+}
+
+func (s *Stream) SetSchemas(schemas types.StreamSchemaList) error {
+	// set the registered versions of the schema (sorted by version)
+	compiledSchemas := make(map[int]*jsonschema.Schema, len(schemas))
+	for _, schema := range schemas {
+		compiledSchema, err := compileSchema(schema.Schema)
+		if err != nil {
+			return fmt.Errorf("invalid schema version %d: %w", schema.Version, err)
+		}
+		compiledSchemas[schema.Version] = compiledSchema
+	}
+
+	s.muSchemas.Lock()
+	defer s.muSchemas.Unlock()
+
+	s.schemas = schemas
+	s.compiledSchemas = compiledSchemas
+	return nil
+}
+
+func (s *Stream) GetSchemas() types.StreamSchemaList {
+	s.muSchemas.RLock()
+	defer s.muSchemas.RUnlock()
+
+	schemas := make(types.StreamSchemaList, len(s.schemas))
+	copy(schemas, s.schemas)
+	return schemas
+}
+
+func (s *Stream) GetSchema(version int) (*types.StreamSchema, bool) {
+	// returns a version of the schema (0 means the latest version)
+	s.muSchemas.RLock()
+	defer s.muSchemas.RUnlock()
+
+	return s.getSchema(version)
+}
+
+func (s *Stream) RegisterSchema(schema map[string]interface{}, save StreamSchemaSaver) (*types.StreamSchema, bool, error) {
+	// Register a new version of the schema, it must be compatible with the latest version.
+	// Registering the same schema as the latest version returns the latest version (nothing is created).
+	compiledSchema, err := compileSchema(schema)
+	if err != nil {
+		return nil, false, err
+	}
+
+	compatibility, err := s.GetSchemaCompatibility()
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.muSchemas.Lock()
+	defer s.muSchemas.Unlock()
+
+	version := 1
+	if latest, found := s.getSchema(0); found {
+		if reflect.DeepEqual(latest.Schema, schema) {
+			return latest, false, nil
+		}
+		if reasons := types.CheckSchemaCompatibility(compatibility, latest.Schema, schema); len(reasons) > 0 {
+			return nil, false, &IncompatibleSchemaError{Compatibility: compatibility, Reasons: reasons}
+		}
+		version = latest.Version + 1
+	}
+
+	newSchema := types.StreamSchema{
+		StreamUUID:   s.info.UUID,
+		Version:      version,
+		Schema:       schema,
+		CreationDate: time.Now(),
+	}
+	if err = save(&newSchema); err != nil {
+		s.logger.Error(
+			"Can't save schema",
+			zap.String("topic", "stream"),
+			zap.String("method", "RegisterSchema"),
+			zap.String("stream.uuid", s.info.UUID.String()),
+			zap.Int("schema.version", version),
+			zap.Error(err),
+		)
+		return nil, false, err
+	}
+
+	s.schemas = append(s.schemas, &newSchema)
+	s.compiledSchemas[version] = compiledSchema
+	s.logger.Info(
+		"Schema registered",
+		zap.String("topic", "stream"),
+		zap.String("method", "RegisterSchema"),
+		zap.String("stream.uuid", s.info.UUID.String()),
+		zap.Int("schema.version", version),
+		zap.String("schema.compatibility", compatibility),
+	)
+	return &newSchema, true, nil
+}
+
+func (s *Stream) DeleteSchemas(remove func() error) error {
+	// remove all the versions of the schema, the records are no longer validated
+	s.muSchemas.Lock()
+	defer s.muSchemas.Unlock()
+
+	if len(s.schemas) == 0 {
+		return ErrStreamSchemaNotFound
+	}
+
+	if err := remove(); err != nil {
+		return err
+	}
+
+	s.schemas = nil
+	s.compiledSchemas = make(map[int]*jsonschema.Schema)
+	s.logger.Info(
+		"Schemas deleted",
+		zap.String("topic", "stream"),
+		zap.String("method", "DeleteSchemas"),
+		zap.String("stream.uuid", s.info.UUID.String()),
+	)
+	return nil
+}
+
+func (s *Stream) ValidateRecords(ctx context.Context, version int, records []interface{}, headers []types.RecordHeaders) (int, []*apierror.ValidationError, error) {
+	// Validate the records against a version of the schema (0 means the latest version).
+	// Returns the version used (0 if the stream has no schema) and the validation errors of the records.
+	// The invalid records are sent to the dead-letter stream (headers is either nil or holds the headers of each record).
+	s.muSchemas.RLock()
+	defer s.muSchemas.RUnlock()
+
+	if len(s.schemas) == 0 {
+		if version != 0 {
+			return 0, nil, ErrStreamSchemaNotFound
+		}
+		return 0, nil, nil
+	}
+
+	schema, found := s.getSchema(version)
+	if !found {
+		return 0, nil, ErrStreamSchemaNotFound
+	}
+	compiledSchema := s.compiledSchemas[schema.Version]
+
+	var validationErrors []*apierror.ValidationError
+	var deadLetters []*types.DeadLetter
+	for i, record := range records {
+		state := compiledSchema.Validate(ctx, record)
+		if len(*state.Errs) == 0 {
+			continue
+		}
+		// the properties of an object are validated in no particular order
+		sort.SliceStable(*state.Errs, func(a, b int) bool { return (*state.Errs)[a].PropertyPath < (*state.Errs)[b].PropertyPath })
+		reasons := make([]string, 0, len(*state.Errs))
+		for _, keyError := range *state.Errs {
+			failedField := fmt.Sprintf("records[%d]%s", i, strings.TrimSuffix(keyError.PropertyPath, "/"))
+			reasons = append(reasons, fmt.Sprintf("%s: %s", failedField, keyError.Message))
+			if len(validationErrors) < MaxSchemaValidationErrors {
+				validationErrors = append(validationErrors, &apierror.ValidationError{
+					FailedField: failedField,
+					Tag:         "schema",
+					Value:       keyError.Message,
+				})
+			}
+		}
+
+		deadLetter := types.DeadLetter{
+			Source:  types.DeadLetterSourceSchema,
+			Reason:  fmt.Sprintf("schema version %d: %s", schema.Version, strings.Join(reasons, ", ")),
+			Message: record,
+		}
+		if headers != nil {
+			deadLetter.Headers = headers[i]
+		}
+		deadLetters = append(deadLetters, &deadLetter)
+	}
+
+	s.SendToDeadLetterStream(deadLetters)
+	return schema.Version, validationErrors, nil
+}
+
+func (s *Stream) getSchema(version int) (*types.StreamSchema, bool) {
+	// must be called with muSchemas locked
+	if len(s.schemas) == 0 {
+		return nil, false
+	}
+	if version == 0 {
+		return s.schemas[len(s.schemas)-1], true
+	}
+	for _, schema := range s.schemas {
+		if schema.Version == version {
+			return schema, true
+		}
+	}
+	return nil, false
+}
+
+func compileSchema(schema map[string]interface{}) (*jsonschema.Schema, error) {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	compiledSchema := &jsonschema.Schema{}
+	if err = compiledSchema.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidStreamSchema, err)
+	}
+
+	// the schema registers itself on its first validation,
+	// do it now because the records of a stream may be validated concurrently
+	compiledSchema.Validate(context.Background(), nil)
+	return compiledSchema, nil
+}
@@ -0,0 +1,43 @@
+package stream
+
+import (
+	"errors"
+
+	"github.com/nbigot/ministream/types"
+
+	"go.uber.org/zap"
+)
+
+func (s *Stream) Scrub() (*types.StreamScrubReport, error) {
+	// verify the records stored, the report of a partitioned stream merges the reports of its partitions
+	// (the records of a partitioned stream are stored by its partitions)
+	if s.IsPartitioned() {
+		report := types.NewStreamScrubReport()
+		for _, partition := range s.partitions {
+			partitionReport, err := partition.Scrub()
+			if err != nil {
+				return nil, err
+			}
+			report.AddPartition(partitionReport)
+		}
+		return report, nil
+	}
+
+	if s.ingestBuffer == nil {
+		return nil, errors.New("stream is not started")
+	}
+	report, err := s.ingestBuffer.Scrub()
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info(
+		"Stream scrubbed",
+		zap.String("topic", "stream"),
+		zap.String("method", "Scrub"),
+		zap.String("stream.uuid", s.info.UUID.String()),
+		zap.Int64("records.verified", report.CptRecords),
+		zap.Int64("records.corrupted", report.CptCorrupted),
+	)
+	return report, nil
+}
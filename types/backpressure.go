@@ -0,0 +1,52 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Stream properties used to override the default backpressure of a stream,
+// applied when the ingest buffer of the stream is full (the storage can't keep up with the producers)
+const BackpressurePropertyMode = "backpressure.mode"       // block, reject or shed
+const BackpressurePropertyMaxWait = "backpressure.maxWait" // seconds (number) or duration (string, ex: "500ms"), block mode only
+
+// Backpressure modes
+const BackpressureModeBlock = "block"   // wait for room in the ingest buffer (up to maxWait), then reject the records
+const BackpressureModeReject = "reject" // reject the records at once
+const BackpressureModeShed = "shed"     // put the records that fit into the ingest buffer, drop the others
+
+var ErrInvalidBackpressure = errors.New("invalid backpressure")
+
+type Backpressure struct {
+	Mode    string        `json:"mode"`
+	MaxWait time.Duration `json:"maxWait"` // maximum time a producer waits for room in the ingest buffer (block mode only, 0 means unlimited)
+}
+
+func ParseBackpressureMode(value interface{}) (string, error) {
+	if strValue, ok := value.(string); ok {
+		mode := strings.ToLower(strValue)
+		switch mode {
+		case BackpressureModeBlock, BackpressureModeReject, BackpressureModeShed:
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("%w: mode %v (must be block, reject or shed)", ErrInvalidBackpressure, value)
+}
+
+func (b Backpressure) WithProperties(properties StreamProperties) (Backpressure, error) {
+	// returns a copy of the backpressure overridden by the backpressure properties of the stream
+	var err error
+	if value, found := properties[BackpressurePropertyMode]; found {
+		if b.Mode, err = ParseBackpressureMode(value); err != nil {
+			return b, fmt.Errorf("invalid stream property %s: %w", BackpressurePropertyMode, err)
+		}
+	}
+	if value, found := properties[BackpressurePropertyMaxWait]; found {
+		if b.MaxWait, err = parseRetentionMaxAge(value); err != nil {
+			return b, fmt.Errorf("invalid stream property %s: %w: %w", BackpressurePropertyMaxWait, ErrInvalidBackpressure, err)
+		}
+	}
+	return b, nil
+}
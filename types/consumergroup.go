@@ -0,0 +1,36 @@
+package types
+
+import (
+	"regexp"
+	"time"
+)
+
+// A consumer group is a named position in a stream.
+// The committed message id is persisted by the storage provider so that
+// consumers can resume reading after a crash or a server restart.
+type ConsumerGroup struct {
+	Name           string     `json:"name" example:"myApp"`
+	StreamUUID     StreamUUID `json:"streamUUID"`
+	CommittedMsgId MessageId  `json:"committedMsgId"` // last message id processed by the group (0 means from the beginning)
+	CreationDate   time.Time  `json:"creationDate"`
+	LastCommitDate time.Time  `json:"lastCommitDate"`
+}
+
+type ConsumerGroupList []*ConsumerGroup
+
+var consumerGroupNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.\-]{1,256}$`)
+
+func IsValidConsumerGroupName(name string) bool {
+	return consumerGroupNameRegexp.MatchString(name)
+}
+
+func NewConsumerGroup(streamUUID StreamUUID, name string) *ConsumerGroup {
+	now := time.Now()
+	return &ConsumerGroup{
+		Name:           name,
+		StreamUUID:     streamUUID,
+		CommittedMsgId: 0,
+		CreationDate:   now,
+		LastCommitDate: now,
+	}
+}
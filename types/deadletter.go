@@ -0,0 +1,65 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Stream property holding the UUID of the dead-letter stream of a stream
+const DeadLetterPropertyStream = "deadletter.stream"
+
+// Reasons why a record is sent to a dead-letter stream
+const DeadLetterSourceSchema = "schema"                  // the record does not match the schema of the stream
+const DeadLetterSourceIngestPipeline = "ingest_pipeline" // a step of the ingest pipeline failed
+const DeadLetterSourceWrite = "write"                    // the storage provider could not write the record
+const DeadLetterSourceDerived = "derived"                // the jq program of a derived stream failed
+
+var ErrInvalidDeadLetterStream = errors.New("invalid dead-letter stream")
+
+// Error of a storage provider that will never write a batch of records (the other errors are retried)
+var ErrRecordNotWritable = errors.New("record cannot be written")
+
+// A record that could not be saved into a stream
+type DeadLetter struct {
+	Source    string        // DeadLetterSourceSchema, DeadLetterSourceIngestPipeline, DeadLetterSourceWrite or DeadLetterSourceDerived
+	Reason    string        // description of the failure
+	MessageId MessageId     // message id of the record (write failures only)
+	Message   interface{}   // the original record
+	Headers   RecordHeaders // headers of the original record
+}
+
+func GetDeadLetterStream(properties StreamProperties) (StreamUUID, bool, error) {
+	// returns the UUID of the dead-letter stream of a stream (false if the stream has none)
+	value, found := properties[DeadLetterPropertyStream]
+	if !found {
+		return StreamUUID{}, false, nil
+	}
+	strValue, ok := value.(string)
+	if !ok {
+		return StreamUUID{}, false, fmt.Errorf("%w: stream property %s must be a stream UUID", ErrInvalidDeadLetterStream, DeadLetterPropertyStream)
+	}
+	streamUUID, err := uuid.Parse(strValue)
+	if err != nil {
+		return StreamUUID{}, false, fmt.Errorf("%w: stream property %s: %w", ErrInvalidDeadLetterStream, DeadLetterPropertyStream, err)
+	}
+	return streamUUID, true, nil
+}
+
+func (d *DeadLetter) ToRecord(streamUUID StreamUUID, now time.Time) map[string]interface{} {
+	// message of the record saved into the dead-letter stream
+	// (the headers of the original record are the headers of the dead-letter record)
+	record := map[string]interface{}{
+		"stream":  streamUUID.String(),
+		"source":  d.Source,
+		"reason":  d.Reason,
+		"date":    now.Format(time.RFC3339Nano),
+		"message": d.Message,
+	}
+	if d.MessageId != 0 {
+		record["messageId"] = int(d.MessageId)
+	}
+	return record
+}
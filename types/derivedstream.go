@@ -0,0 +1,68 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/google/uuid"
+)
+
+// Stream properties defining a derived stream: the records of the source stream
+// transformed by a jq program (same semantics as a step of an ingest pipeline)
+const DerivedStreamPropertySource = "derived.source" // UUID of the source stream
+const DerivedStreamPropertyJq = "derived.jq"         // jq program (all the records are copied if not set)
+
+var ErrInvalidDerivedStream = errors.New("invalid derived stream")
+
+// A derived stream is kept up to date by the server, it follows its source stream
+// and tracks its position with a consumer group of the source stream.
+type DerivedStream struct {
+	Source StreamUUID
+	Filter IngestPipeline
+}
+
+func GetDerivedStream(properties StreamProperties) (*DerivedStream, error) {
+	// returns the definition of a derived stream (nil if the stream is not derived)
+	value, found := properties[DerivedStreamPropertySource]
+	if !found {
+		if _, found = properties[DerivedStreamPropertyJq]; found {
+			return nil, fmt.Errorf("%w: stream property %s is required", ErrInvalidDerivedStream, DerivedStreamPropertySource)
+		}
+		return nil, nil
+	}
+
+	strValue, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: stream property %s must be a stream UUID", ErrInvalidDerivedStream, DerivedStreamPropertySource)
+	}
+	source, err := uuid.Parse(strValue)
+	if err != nil {
+		return nil, fmt.Errorf("%w: stream property %s: %w", ErrInvalidDerivedStream, DerivedStreamPropertySource, err)
+	}
+
+	derived := DerivedStream{Source: source}
+	if program, found := properties[DerivedStreamPropertyJq]; found {
+		step, err := newIngestPipelineStep(DerivedStreamPropertyJq, program)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidDerivedStream, err)
+		}
+		derived.Filter = IngestPipeline{step}
+	}
+	return &derived, nil
+}
+
+func IsSameDerivedStream(properties StreamProperties, newProperties StreamProperties) bool {
+	// the definition of a derived stream cannot be changed once created
+	for _, name := range []string{DerivedStreamPropertySource, DerivedStreamPropertyJq} {
+		if !reflect.DeepEqual(properties[name], newProperties[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func GetDerivedStreamConsumerGroup(streamUUID StreamUUID) string {
+	// name of the consumer group of the source stream tracking the position of a derived stream
+	return "derived." + streamUUID.String()
+}
@@ -0,0 +1,41 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Stream property used to override the default durability level of a stream
+// (a producer can also ask for a given level with the x-ministream-durability header)
+const DurabilityPropertyLevel = "durability.level"
+
+// Durability levels: when a put of records is acknowledged
+const DurabilityNone = "none"       // as soon as the records are put into the ingest buffer
+const DurabilityFlushed = "flushed" // once the records are written by the storage provider
+const DurabilityFsynced = "fsynced" // once the records are written and synced to the disk by the storage provider
+
+var ErrInvalidDurability = errors.New("invalid durability")
+
+func ParseDurability(value interface{}) (string, error) {
+	if strValue, ok := value.(string); ok {
+		level := strings.ToLower(strValue)
+		switch level {
+		case DurabilityNone, DurabilityFlushed, DurabilityFsynced:
+			return level, nil
+		}
+	}
+	return "", fmt.Errorf("%w: level %v (must be none, flushed or fsynced)", ErrInvalidDurability, value)
+}
+
+func GetDurability(defaultLevel string, properties StreamProperties) (string, error) {
+	// returns the durability level of a stream (the stream property overrides the default level)
+	if value, found := properties[DurabilityPropertyLevel]; found {
+		level, err := ParseDurability(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid stream property %s: %w", DurabilityPropertyLevel, err)
+		}
+		return level, nil
+	}
+	return defaultLevel, nil
+}
@@ -0,0 +1,136 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// Stream properties holding the jq programs of the ingest pipeline of a stream,
+// the steps are run in the order of their number, ex: "ingest.pipeline.1", "ingest.pipeline.2"...
+const IngestPipelinePropertyPrefix = "ingest.pipeline."
+
+// Maximum number of steps of an ingest pipeline
+const MaxIngestPipelineSteps = 16
+
+// Variables available to the jq programs of an ingest pipeline
+var ingestPipelineVariables = []string{"$headers", "$stream"}
+
+var errIngestPipelineMultipleResults = errors.New("step returned more than one result")
+
+type IngestPipelineStep struct {
+	Name string // name of the stream property holding the jq program
+	code *gojq.Code
+}
+
+// An ingest pipeline transforms the records before they are saved into the stream.
+// Each step is a jq program run on the output of the previous step:
+// no output drops the record, an error rejects the record, more than one output is an error.
+type IngestPipeline []*IngestPipelineStep
+
+type IngestPipelineError struct {
+	Step string // name of the step that failed
+	Err  error
+}
+
+func (e *IngestPipelineError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Step, e.Err.Error())
+}
+
+func (e *IngestPipelineError) Unwrap() error {
+	return e.Err
+}
+
+func NewIngestPipeline(properties StreamProperties) (IngestPipeline, error) {
+	// returns the ingest pipeline defined by the stream properties (nil if there is no step)
+	type rankedStep struct {
+		rank int
+		step *IngestPipelineStep
+	}
+
+	var steps []rankedStep
+	for name, value := range properties {
+		if !strings.HasPrefix(name, IngestPipelinePropertyPrefix) {
+			continue
+		}
+
+		rank, err := strconv.Atoi(strings.TrimPrefix(name, IngestPipelinePropertyPrefix))
+		if err != nil || rank < 0 {
+			return nil, fmt.Errorf("invalid stream property %s: the step must be numbered (ex: %s1)", name, IngestPipelinePropertyPrefix)
+		}
+
+		step, err := newIngestPipelineStep(name, value)
+		if err != nil {
+			return nil, err
+		}
+
+		steps = append(steps, rankedStep{rank: rank, step: step})
+	}
+
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	if len(steps) > MaxIngestPipelineSteps {
+		return nil, fmt.Errorf("too many ingest pipeline steps (max %d)", MaxIngestPipelineSteps)
+	}
+
+	sort.Slice(steps, func(i, j int) bool { return steps[i].rank < steps[j].rank })
+	pipeline := make(IngestPipeline, len(steps))
+	for i, s := range steps {
+		pipeline[i] = s.step
+	}
+	return pipeline, nil
+}
+
+func newIngestPipelineStep(name string, value interface{}) (*IngestPipelineStep, error) {
+	// compile the jq program held by the stream property name
+	program, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid stream property %s: the jq program must be a string", name)
+	}
+
+	query, err := gojq.Parse(program)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream property %s: %w", name, err)
+	}
+	code, err := gojq.Compile(query, gojq.WithVariables(ingestPipelineVariables))
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream property %s: %w", name, err)
+	}
+
+	return &IngestPipelineStep{Name: name, code: code}, nil
+}
+
+func (p IngestPipeline) Run(record interface{}, headers RecordHeaders, streamUUID StreamUUID) (interface{}, bool, error) {
+	// Run the steps of the pipeline on a record.
+	// Returns the transformed record and false if the record has been dropped by a step.
+	jqHeaders := make(map[string]interface{}, len(headers))
+	for name, value := range headers {
+		jqHeaders[name] = value
+	}
+	jqStream := streamUUID.String()
+
+	for _, step := range p {
+		iter := step.code.Run(record, jqHeaders, jqStream)
+		value, found := iter.Next()
+		if !found {
+			return nil, false, nil
+		}
+		if err, ok := value.(error); ok {
+			return nil, false, &IngestPipelineError{Step: step.Name, Err: err}
+		}
+		if extra, found := iter.Next(); found {
+			if err, ok := extra.(error); ok {
+				return nil, false, &IngestPipelineError{Step: step.Name, Err: err}
+			}
+			return nil, false, &IngestPipelineError{Step: step.Name, Err: errIngestPipelineMultipleResults}
+		}
+		record = value
+	}
+
+	return record, true, nil
+}
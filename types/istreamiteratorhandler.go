@@ -1,14 +1,129 @@
 package types
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Directions of the iterators: the positions of the iterator types follow the direction
+// (backward, AFTER_MESSAGE_ID starts at the message before the given message id)
+const IteratorDirectionForward = "forward"   // oldest records first (default)
+const IteratorDirectionBackward = "backward" // newest records first
+
+var ErrInvalidIteratorTimestamp = errors.New("invalid iterator timestamp")
 
 type StreamIteratorRequest struct {
-	IteratorType       string    `json:"iteratorType" validate:"required,oneof=FIRST_MESSAGE LAST_MESSAGE AFTER_LAST_MESSAGE AT_MESSAGE_ID AFTER_MESSAGE_ID AT_TIMESTAMP"`
-	MessageId          MessageId `json:"messageId"`
-	Timestamp          time.Time `json:"timestamp"`
-	JqFilter           string    `json:"jqFilter"`
-	MaxWaitTimeSeconds int       `json:"maxWaitTimeSeconds"`
-	Name               string    `json:"name"`
+	IteratorType       string     `json:"iteratorType" validate:"required,oneof=FIRST_MESSAGE LAST_MESSAGE AFTER_LAST_MESSAGE AT_MESSAGE_ID AFTER_MESSAGE_ID AT_TIMESTAMP"`
+	MessageId          MessageId  `json:"messageId"`
+	Timestamp          time.Time  `json:"timestamp"`
+	JqFilter           string     `json:"jqFilter"`
+	MaxWaitTimeSeconds int        `json:"maxWaitTimeSeconds"`
+	Name               string     `json:"name"`
+	ConsumerGroup      string     `json:"consumerGroup"`
+	Partition          *int       `json:"partition,omitempty"`      // partitioned stream only: read a single partition (all partitions if not set)
+	UntilMessageId     *MessageId `json:"untilMessageId,omitempty"` // end of range: last message id read (included)
+	UntilTimestamp     *time.Time `json:"untilTimestamp,omitempty"` // end of range: the records put from this date (before this date backward) are not read
+	Snapshot           bool       `json:"snapshot,omitempty"`       // end of range: last message id of the stream when the iterator is created
+	Direction          string     `json:"direction,omitempty"`      // IteratorDirectionForward (default) or IteratorDirectionBackward
+}
+
+func (r *StreamIteratorRequest) UnmarshalJSON(data []byte) error {
+	// the timestamps are either RFC3339 dates (nanosecond precision) or durations relative to now (example: "-15m")
+	// (the embedded type has no method, therefore it is unmarshalled with the default behavior)
+	type Request StreamIteratorRequest
+	payload := struct {
+		*Request
+		Timestamp      *string `json:"timestamp"`
+		UntilTimestamp *string `json:"untilTimestamp"`
+	}{Request: (*Request)(r)}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if payload.Timestamp != nil {
+		timestamp, err := ParseIteratorTimestamp(*payload.Timestamp, now)
+		if err != nil {
+			return err
+		}
+		r.Timestamp = timestamp
+	}
+	if payload.UntilTimestamp != nil {
+		timestamp, err := ParseIteratorTimestamp(*payload.UntilTimestamp, now)
+		if err != nil {
+			return err
+		}
+		r.UntilTimestamp = &timestamp
+	}
+	return nil
+}
+
+func (r *StreamIteratorRequest) HasEnd() bool {
+	// returns true if the iterator stops at the end of a range of records
+	return r.UntilMessageId != nil || r.UntilTimestamp != nil || r.Snapshot
+}
+
+func (r *StreamIteratorRequest) CheckEnd() error {
+	// a range of records has a single end
+	cptEnds := 0
+	if r.UntilMessageId != nil {
+		cptEnds++
+	}
+	if r.UntilTimestamp != nil {
+		cptEnds++
+	}
+	if r.Snapshot {
+		cptEnds++
+	}
+	if cptEnds > 1 {
+		return errors.New("untilMessageId, untilTimestamp and snapshot are mutually exclusive")
+	}
+	return nil
+}
+
+func (r *StreamIteratorRequest) IsBackward() bool {
+	return r.Direction == IteratorDirectionBackward
+}
+
+func (r *StreamIteratorRequest) CheckDirection() error {
+	switch r.Direction {
+	case "", IteratorDirectionForward:
+		return nil
+	case IteratorDirectionBackward:
+	default:
+		return fmt.Errorf("invalid iterator direction: %s", r.Direction)
+	}
+
+	// a backward iterator never reads the records put after its creation
+	switch {
+	case r.IteratorType == "AFTER_LAST_MESSAGE":
+		return errors.New("iterator type AFTER_LAST_MESSAGE can't be read backward")
+	case r.Snapshot:
+		return errors.New("snapshot can't be used backward")
+	case r.ConsumerGroup != "":
+		return errors.New("consumer groups can't be read backward")
+	}
+	return nil
+}
+
+func ParseIteratorTimestamp(value string, now time.Time) (time.Time, error) {
+	// parse an absolute date (RFC3339 with an optional fraction of second) or a duration in the past relative to now
+	if strings.HasPrefix(value, "-") {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: %w", ErrInvalidIteratorTimestamp, err)
+		}
+		return now.Add(duration), nil
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %w", ErrInvalidIteratorTimestamp, err)
+	}
+	return timestamp, nil
 }
 
 type IStreamIteratorHandler interface {
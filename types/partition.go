@@ -0,0 +1,18 @@
+package types
+
+import "hash/fnv"
+
+// A partitioned stream spreads its records over partitions.
+// Each partition is a stream on its own (message ids, writer and index) attached to the partitioned stream.
+type StreamPartitioning struct {
+	Partitions StreamUUIDList `json:"partitions,omitempty"` // partitions of a partitioned stream (ordered by rank)
+	ParentUUID *StreamUUID    `json:"parentUuid,omitempty"` // partitioned stream of a partition
+	Partition  int            `json:"partition"`            // rank of a partition
+}
+
+func GetPartitionForKey(key string, cptPartitions int) int {
+	// the records having the same key always go to the same partition (therefore they stay ordered)
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(cptPartitions))
+}
@@ -0,0 +1,78 @@
+package types
+
+import (
+	"regexp"
+	"time"
+)
+
+// Number of batches remembered by producer, a retried batch older than these can't be deduplicated
+const MaxProducerBatches = 5
+
+// A batch of records put by an idempotent producer
+type ProducerBatch struct {
+	Sequence   uint64      `json:"sequence"`
+	MessageIds []MessageId `json:"messageIds"`          // message ids of the records (0 if the record has been dropped)
+	Partition  *int        `json:"partition,omitempty"` // partition where the records were put (partitioned stream only)
+}
+
+func (b *ProducerBatch) CountPut() int64 {
+	// number of records put into the stream (the records dropped or shed have no message id)
+	cpt := int64(0)
+	for _, msgId := range b.MessageIds {
+		if msgId != 0 {
+			cpt++
+		}
+	}
+	return cpt
+}
+
+// An idempotent producer numbers its batches of records with a sequence increasing by one, starting from 1.
+// The last sequence is persisted by the storage provider so that duplicates and gaps
+// are detected across server restarts, a retried batch returns the message ids of the original batch.
+type Producer struct {
+	Id           string           `json:"id" example:"myProducer"`
+	StreamUUID   StreamUUID       `json:"streamUUID"`
+	LastSequence uint64           `json:"lastSequence"`
+	Batches      []*ProducerBatch `json:"batches"` // last batches put (oldest first)
+	CreationDate time.Time        `json:"creationDate"`
+	LastUpdate   time.Time        `json:"lastUpdate"`
+}
+
+type ProducerList []*Producer
+
+var producerIdRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.\-]{1,64}$`)
+
+func IsValidProducerId(id string) bool {
+	return producerIdRegexp.MatchString(id)
+}
+
+func NewProducer(streamUUID StreamUUID, id string) *Producer {
+	now := time.Now()
+	return &Producer{
+		Id:           id,
+		StreamUUID:   streamUUID,
+		LastSequence: 0,
+		Batches:      []*ProducerBatch{},
+		CreationDate: now,
+		LastUpdate:   now,
+	}
+}
+
+func (p *Producer) GetBatch(sequence uint64) (*ProducerBatch, bool) {
+	for _, batch := range p.Batches {
+		if batch.Sequence == sequence {
+			return batch, true
+		}
+	}
+	return nil, false
+}
+
+func (p *Producer) AddBatch(batch *ProducerBatch) {
+	// the batch becomes the last one put by the producer
+	p.LastSequence = batch.Sequence
+	p.LastUpdate = time.Now()
+	p.Batches = append(p.Batches, batch)
+	if len(p.Batches) > MaxProducerBatches {
+		p.Batches = p.Batches[len(p.Batches)-MaxProducerBatches:]
+	}
+}
@@ -0,0 +1,360 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"sort"
+
+	"github.com/goccy/go-json"
+	"github.com/itchyny/gojq"
+)
+
+// Aggregation operations of a query
+const QueryOpCount = "count"       // number of records matching the query
+const QueryOpSum = "sum"           // sum of the numeric values
+const QueryOpMin = "min"           // lowest value (jq ordering: null < false < true < numbers < strings)
+const QueryOpMax = "max"           // greatest value (jq ordering)
+const QueryOpDistinct = "distinct" // distinct values
+const QueryOpGroupBy = "group_by"  // count of records (and sum of the numeric values) by key
+
+// Maximum number of aggregations of a query
+const MaxQueryAggregations = 32
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+var queryAggregationNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.\-]{1,64}$`)
+
+// A query scans a range of a stream and returns aggregated results, not the records.
+// The range is defined like the position of an iterator (ex: AT_TIMESTAMP with an untilTimestamp),
+// the jq program selects and transforms the records of the range before they are aggregated.
+type StreamQueryRequest struct {
+	Range              StreamIteratorRequest `json:"range"`
+	Jq                 string                `json:"jq,omitempty" example:"select(.m.type == \"order\")"`
+	Aggregations       []*QueryAggregation   `json:"aggregations"`
+	MaxRecordsScanned  uint64                `json:"maxRecordsScanned,omitempty" example:"100000"` // 0 means the server limit
+	MaxDurationSeconds int                   `json:"maxDurationSeconds,omitempty" example:"10"`    // 0 means the server limit
+}
+
+type QueryAggregation struct {
+	Name  string `json:"name" example:"amountByCountry"`
+	Op    string `json:"op" example:"group_by"`
+	Key   string `json:"key,omitempty" example:".m.country"`  // jq program returning the key of the group (group_by only)
+	Value string `json:"value,omitempty" example:".m.amount"` // jq program returning the value aggregated (optional for group_by)
+}
+
+type QueryGroup struct {
+	Key   interface{} `json:"key"`
+	Count int64       `json:"count"`
+	Sum   *float64    `json:"sum,omitempty"` // group_by with a value only
+}
+
+type QueryGroupByResult struct {
+	Groups    []*QueryGroup `json:"groups"` // ordered by count (highest first)
+	Truncated bool          `json:"truncated"`
+}
+
+type QueryDistinctResult struct {
+	Values    []interface{} `json:"values"` // in the order they were found
+	Truncated bool          `json:"truncated"`
+}
+
+// A compiled query, the records are aggregated one by one with Aggregate
+type StreamQuery struct {
+	filter       *gojq.Code
+	aggregations []*queryAggregator
+	maxGroups    int
+}
+
+type queryAggregator struct {
+	name      string
+	op        string
+	key       *gojq.Code
+	value     *gojq.Code
+	count     int64
+	sum       float64
+	hasResult bool
+	result    interface{} // min or max
+	keys      map[string]int
+	groups    []*QueryGroup
+	distinct  []interface{}
+	truncated bool
+}
+
+func NewStreamQuery(req *StreamQueryRequest, maxGroups int) (*StreamQuery, error) {
+	// compile the jq programs of the query,
+	// maxGroups is the maximum number of groups or distinct values of each aggregation
+	if len(req.Aggregations) == 0 {
+		return nil, fmt.Errorf("%w: at least one aggregation is required", ErrInvalidQuery)
+	}
+	if len(req.Aggregations) > MaxQueryAggregations {
+		return nil, fmt.Errorf("%w: too many aggregations (max %d)", ErrInvalidQuery, MaxQueryAggregations)
+	}
+
+	query := StreamQuery{maxGroups: maxGroups, aggregations: make([]*queryAggregator, 0, len(req.Aggregations))}
+	var err error
+	if req.Jq != "" {
+		if query.filter, err = compileQueryProgram("jq", req.Jq); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make(map[string]bool, len(req.Aggregations))
+	for _, aggregation := range req.Aggregations {
+		if aggregation == nil || !queryAggregationNameRegexp.MatchString(aggregation.Name) {
+			return nil, fmt.Errorf("%w: invalid aggregation name", ErrInvalidQuery)
+		}
+		if names[aggregation.Name] {
+			return nil, fmt.Errorf("%w: duplicate aggregation name %s", ErrInvalidQuery, aggregation.Name)
+		}
+		names[aggregation.Name] = true
+
+		aggregator := queryAggregator{name: aggregation.Name, op: aggregation.Op}
+		switch aggregation.Op {
+		case QueryOpCount:
+		case QueryOpSum, QueryOpMin, QueryOpMax, QueryOpDistinct:
+			if aggregation.Value == "" {
+				return nil, fmt.Errorf("%w: aggregation %s requires a value", ErrInvalidQuery, aggregation.Name)
+			}
+		case QueryOpGroupBy:
+			if aggregation.Key == "" {
+				return nil, fmt.Errorf("%w: aggregation %s requires a key", ErrInvalidQuery, aggregation.Name)
+			}
+			aggregator.keys = make(map[string]int)
+			aggregator.groups = make([]*QueryGroup, 0)
+		default:
+			return nil, fmt.Errorf("%w: aggregation %s: unknown operation %s", ErrInvalidQuery, aggregation.Name, aggregation.Op)
+		}
+		if aggregation.Op == QueryOpDistinct {
+			aggregator.keys = make(map[string]int)
+			aggregator.distinct = make([]interface{}, 0)
+		}
+
+		if aggregation.Key != "" {
+			if aggregation.Op != QueryOpGroupBy {
+				return nil, fmt.Errorf("%w: aggregation %s: key is only allowed with %s", ErrInvalidQuery, aggregation.Name, QueryOpGroupBy)
+			}
+			if aggregator.key, err = compileQueryProgram(aggregation.Name+".key", aggregation.Key); err != nil {
+				return nil, err
+			}
+		}
+		if aggregation.Value != "" {
+			if aggregation.Op == QueryOpCount {
+				return nil, fmt.Errorf("%w: aggregation %s: value is not allowed with %s", ErrInvalidQuery, aggregation.Name, QueryOpCount)
+			}
+			if aggregator.value, err = compileQueryProgram(aggregation.Name+".value", aggregation.Value); err != nil {
+				return nil, err
+			}
+		}
+		query.aggregations = append(query.aggregations, &aggregator)
+	}
+
+	return &query, nil
+}
+
+func compileQueryProgram(name string, program string) (*gojq.Code, error) {
+	query, err := gojq.Parse(program)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidQuery, name, err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidQuery, name, err)
+	}
+	return code, nil
+}
+
+func runQueryProgram(code *gojq.Code, input interface{}) (interface{}, bool, error) {
+	// only the first result is used, no result means the record is skipped
+	value, found := code.Run(input).Next()
+	if !found {
+		return nil, false, nil
+	}
+	if err, ok := value.(error); ok {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (q *StreamQuery) Aggregate(record interface{}) (bool, error) {
+	// Aggregate a record read from the stream.
+	// Returns false if the record does not match the jq program of the query.
+	if q.filter != nil {
+		value, found, err := runQueryProgram(q.filter, record)
+		if err != nil || !found {
+			return false, err
+		}
+		record = value
+	}
+
+	// an error only skips the record for the aggregation that failed
+	var firstErr error
+	for _, a := range q.aggregations {
+		if err := a.aggregate(record, q.maxGroups); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", a.name, err)
+		}
+	}
+	return true, firstErr
+}
+
+func (a *queryAggregator) aggregate(record interface{}, maxGroups int) error {
+	var value interface{}
+	if a.value != nil {
+		var found bool
+		var err error
+		if value, found, err = runQueryProgram(a.value, record); err != nil {
+			return err
+		}
+		if (!found || value == nil) && a.op != QueryOpGroupBy {
+			// missing value (ex: the field is not set), the record is ignored
+			return nil
+		}
+	}
+
+	switch a.op {
+	case QueryOpCount:
+		a.count++
+	case QueryOpSum:
+		if number, ok := toQueryNumber(value); ok {
+			a.sum += number
+		}
+	case QueryOpMin, QueryOpMax:
+		if _, ok := queryValueRank(value); !ok {
+			return nil
+		}
+		if !a.hasResult {
+			a.result, a.hasResult = value, true
+			return nil
+		}
+		cmp := compareQueryValues(value, a.result)
+		if (a.op == QueryOpMin && cmp < 0) || (a.op == QueryOpMax && cmp > 0) {
+			a.result = value
+		}
+	case QueryOpDistinct:
+		key, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if _, found := a.keys[string(key)]; found {
+			return nil
+		}
+		if len(a.distinct) >= maxGroups {
+			a.truncated = true
+			return nil
+		}
+		a.keys[string(key)] = len(a.distinct)
+		a.distinct = append(a.distinct, value)
+	case QueryOpGroupBy:
+		groupKey, found, err := runQueryProgram(a.key, record)
+		if err != nil || !found {
+			return err
+		}
+		key, err := json.Marshal(groupKey)
+		if err != nil {
+			return err
+		}
+		index, found := a.keys[string(key)]
+		if !found {
+			if len(a.groups) >= maxGroups {
+				a.truncated = true
+				return nil
+			}
+			index = len(a.groups)
+			a.keys[string(key)] = index
+			a.groups = append(a.groups, &QueryGroup{Key: groupKey})
+		}
+		group := a.groups[index]
+		group.Count++
+		if a.value != nil {
+			if group.Sum == nil {
+				group.Sum = new(float64)
+			}
+			if number, ok := toQueryNumber(value); ok {
+				*group.Sum += number
+			}
+		}
+	}
+	return nil
+}
+
+func (q *StreamQuery) GetResults() map[string]interface{} {
+	// results of the aggregations by name
+	results := make(map[string]interface{}, len(q.aggregations))
+	for _, a := range q.aggregations {
+		switch a.op {
+		case QueryOpCount:
+			results[a.name] = a.count
+		case QueryOpSum:
+			results[a.name] = a.sum
+		case QueryOpMin, QueryOpMax:
+			results[a.name] = a.result
+		case QueryOpDistinct:
+			results[a.name] = QueryDistinctResult{Values: a.distinct, Truncated: a.truncated}
+		case QueryOpGroupBy:
+			groups := append([]*QueryGroup{}, a.groups...)
+			sort.SliceStable(groups, func(i, j int) bool { return groups[i].Count > groups[j].Count })
+			results[a.name] = QueryGroupByResult{Groups: groups, Truncated: a.truncated}
+		}
+	}
+	return results
+}
+
+func toQueryNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, !math.IsNaN(v)
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, true
+	}
+	return 0, false
+}
+
+func queryValueRank(value interface{}) (int, bool) {
+	// rank of the type of a value (jq ordering), arrays and objects can't be compared
+	switch v := value.(type) {
+	case nil:
+		return 0, true
+	case bool:
+		if v {
+			return 2, true
+		}
+		return 1, true
+	case string:
+		return 4, true
+	}
+	if _, ok := toQueryNumber(value); ok {
+		return 3, true
+	}
+	return 0, false
+}
+
+func compareQueryValues(a interface{}, b interface{}) int {
+	rankA, _ := queryValueRank(a)
+	rankB, _ := queryValueRank(b)
+	switch {
+	case rankA != rankB:
+		return rankA - rankB
+	case rankA == 3:
+		numberA, _ := toQueryNumber(a)
+		numberB, _ := toQueryNumber(b)
+		switch {
+		case numberA < numberB:
+			return -1
+		case numberA > numberB:
+			return 1
+		}
+	case rankA == 4:
+		switch strA, strB := a.(string), b.(string); {
+		case strA < strB:
+			return -1
+		case strA > strB:
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Optional headers of a record (content type, trace id, producer id, schema version...)
+type RecordHeaders map[string]string
+
+const MaxRecordHeaders = 32
+const MaxRecordHeaderValueLength = 1024
+
+var recordHeaderNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.\-]{1,64}$`)
+
+func (h RecordHeaders) Validate() error {
+	if len(h) > MaxRecordHeaders {
+		return fmt.Errorf("too many headers (max %d)", MaxRecordHeaders)
+	}
+	for name, value := range h {
+		if !recordHeaderNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid header name: %s", name)
+		}
+		if len(value) > MaxRecordHeaderValueLength {
+			return fmt.Errorf("header %s value is too long (max %d)", name, MaxRecordHeaderValueLength)
+		}
+	}
+	return nil
+}
+
+func (h RecordHeaders) Merge(headers RecordHeaders) RecordHeaders {
+	// returns the union of both headers, the given headers override the existing ones
+	if len(headers) == 0 {
+		return h
+	}
+	if len(h) == 0 {
+		return headers
+	}
+	merged := make(RecordHeaders, len(h)+len(headers))
+	for name, value := range h {
+		merged[name] = value
+	}
+	for name, value := range headers {
+		merged[name] = value
+	}
+	return merged
+}
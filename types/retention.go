@@ -0,0 +1,131 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Stream properties used to override the default retention policy of a stream
+const RetentionPropertyMaxAge = "retention.maxAge"         // seconds (number) or duration (string, ex: "72h")
+const RetentionPropertyMaxRecords = "retention.maxRecords" // number of records
+const RetentionPropertyMaxSize = "retention.maxSize"       // bytes (number) or size (string, ex: "500mb")
+
+var ErrInvalidRetention = errors.New("invalid retention policy")
+
+type RetentionPolicy struct {
+	MaxAge     time.Duration `json:"maxAge"`     // remove records older than this duration (0 means unlimited)
+	MaxRecords uint64        `json:"maxRecords"` // keep only the most recent records (0 means unlimited)
+	MaxBytes   uint64        `json:"maxBytes"`   // keep only the most recent records within this size (0 means unlimited)
+}
+
+func (p *RetentionPolicy) IsEnabled() bool {
+	return p.MaxAge > 0 || p.MaxRecords > 0 || p.MaxBytes > 0
+}
+
+func (p RetentionPolicy) WithProperties(properties StreamProperties) (RetentionPolicy, error) {
+	// returns a copy of the policy overridden by the retention properties of the stream
+	var err error
+	if value, found := properties[RetentionPropertyMaxAge]; found {
+		if p.MaxAge, err = parseRetentionMaxAge(value); err != nil {
+			return p, fmt.Errorf("invalid stream property %s: %w: %w", RetentionPropertyMaxAge, ErrInvalidRetention, err)
+		}
+	}
+	if value, found := properties[RetentionPropertyMaxRecords]; found {
+		if p.MaxRecords, err = parseRetentionUint(value, false); err != nil {
+			return p, fmt.Errorf("invalid stream property %s: %w: %w", RetentionPropertyMaxRecords, ErrInvalidRetention, err)
+		}
+	}
+	if value, found := properties[RetentionPropertyMaxSize]; found {
+		if p.MaxBytes, err = parseRetentionUint(value, true); err != nil {
+			return p, fmt.Errorf("invalid stream property %s: %w: %w", RetentionPropertyMaxSize, ErrInvalidRetention, err)
+		}
+	}
+	return p, nil
+}
+
+func (p *RetentionPolicy) CountRecordsToRemove(cptRecords int64, now time.Time, getRecord func(rank int64) (int64, uint64, error)) (int64, error) {
+	// Returns the number of records to remove from the head of the stream.
+	// The records are sorted by rank (oldest first), getRecord returns for a given rank
+	// the timestamp (unix nano) of the record and the size in bytes from this record to the end of the stream.
+	var err error
+	search := func(match func(timestampUnixNano int64, tailSizeInBytes uint64) bool) int64 {
+		return int64(sort.Search(int(cptRecords), func(rank int) bool {
+			if err != nil {
+				return true
+			}
+			timestampUnixNano, tailSizeInBytes, errGet := getRecord(int64(rank))
+			if errGet != nil {
+				err = errGet
+				return true
+			}
+			return match(timestampUnixNano, tailSizeInBytes)
+		}))
+	}
+
+	var cptToRemove int64 = 0
+	if p.MaxRecords > 0 && uint64(cptRecords) > p.MaxRecords {
+		cptToRemove = cptRecords - int64(p.MaxRecords)
+	}
+
+	if p.MaxAge > 0 {
+		minTimestampUnixNano := now.Add(-p.MaxAge).UnixNano()
+		cpt := search(func(timestampUnixNano int64, _ uint64) bool { return timestampUnixNano >= minTimestampUnixNano })
+		cptToRemove = max(cptToRemove, cpt)
+	}
+
+	if p.MaxBytes > 0 {
+		cpt := search(func(_ int64, tailSizeInBytes uint64) bool { return tailSizeInBytes <= p.MaxBytes })
+		cptToRemove = max(cptToRemove, cpt)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return cptToRemove, nil
+}
+
+func parseRetentionMaxAge(value interface{}) (time.Duration, error) {
+	if strValue, ok := value.(string); ok {
+		d, err := time.ParseDuration(strValue)
+		if err != nil {
+			return 0, err
+		}
+		if d < 0 {
+			return 0, fmt.Errorf("value must be positive: %s", strValue)
+		}
+		return d, nil
+	}
+
+	seconds, err := parseRetentionUint(value, false)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func parseRetentionUint(value interface{}, allowHumanizedBytes bool) (uint64, error) {
+	switch v := value.(type) {
+	case float64:
+		// json numbers are decoded as float64
+		if v < 0 || v != float64(uint64(v)) {
+			return 0, fmt.Errorf("value must be a positive integer: %v", v)
+		}
+		return uint64(v), nil
+	case int:
+		if v < 0 {
+			return 0, fmt.Errorf("value must be a positive integer: %v", v)
+		}
+		return uint64(v), nil
+	case string:
+		if allowHumanizedBytes {
+			return humanize.ParseBytes(v)
+		}
+	}
+
+	return 0, fmt.Errorf("invalid value: %v", value)
+}
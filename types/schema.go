@@ -0,0 +1,50 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Stream property used to override the default schema compatibility mode of a stream
+const SchemaPropertyCompatibility = "schema.compatibility"
+
+// Compatibility modes enforced when a new version of a schema is registered
+const SchemaCompatibilityNone = "NONE"         // any schema is accepted
+const SchemaCompatibilityBackward = "BACKWARD" // the new schema accepts the records of the previous version
+const SchemaCompatibilityForward = "FORWARD"   // the previous schema accepts the records of the new version
+const SchemaCompatibilityFull = "FULL"         // both backward and forward
+
+// A version of the JSON Schema of the records of a stream.
+// Once registered, a version never changes: a new version must be registered instead.
+type StreamSchema struct {
+	StreamUUID   StreamUUID             `json:"streamUUID"`
+	Version      int                    `json:"version" example:"1"`
+	Schema       map[string]interface{} `json:"schema"`
+	CreationDate time.Time              `json:"creationDate"`
+}
+
+type StreamSchemaList []*StreamSchema
+
+func ParseSchemaCompatibility(value interface{}) (string, error) {
+	if strValue, ok := value.(string); ok {
+		mode := strings.ToUpper(strValue)
+		switch mode {
+		case SchemaCompatibilityNone, SchemaCompatibilityBackward, SchemaCompatibilityForward, SchemaCompatibilityFull:
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("invalid schema compatibility: %v (must be NONE, BACKWARD, FORWARD or FULL)", value)
+}
+
+func GetSchemaCompatibility(defaultMode string, properties StreamProperties) (string, error) {
+	// returns the compatibility mode of a stream (the stream property overrides the default mode)
+	if value, found := properties[SchemaPropertyCompatibility]; found {
+		mode, err := ParseSchemaCompatibility(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid stream property %s: %w", SchemaPropertyCompatibility, err)
+		}
+		return mode, nil
+	}
+	return ParseSchemaCompatibility(defaultMode)
+}
@@ -0,0 +1,188 @@
+package types
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+)
+
+// The compatibility of two JSON Schemas is checked structurally (not by comparing the sets of valid documents):
+// types, enums, required and allowed properties, items of arrays and the bounds of numbers, strings and arrays.
+// Adding a property that is not required is always compatible, the records of the previous
+// version are expected not to hold undeclared properties with the same name.
+// Any change of the other keywords (pattern, const, format, multipleOf, allOf, anyOf, oneOf, $ref...)
+// is not checked, therefore it is incompatible.
+
+// keywords checked structurally (items and additionalProperties only in their simple forms)
+var checkedSchemaKeywords = []string{
+	"type", "enum", "required", "properties", "additionalProperties", "items",
+	"minimum", "exclusiveMinimum", "minLength", "minItems", "minProperties",
+	"maximum", "exclusiveMaximum", "maxLength", "maxItems", "maxProperties",
+}
+
+// keywords that don't change the documents accepted
+var annotationSchemaKeywords = []string{
+	"$schema", "$id", "$comment", "title", "description", "default", "examples", "deprecated", "readOnly", "writeOnly",
+}
+
+func CheckSchemaCompatibility(mode string, previous map[string]interface{}, next map[string]interface{}) []string {
+	// returns the reasons why the next version of a schema is not compatible with the previous one
+	switch mode {
+	case SchemaCompatibilityBackward:
+		return checkSchemaCanRead(next, previous, "")
+	case SchemaCompatibilityForward:
+		return checkSchemaCanRead(previous, next, "")
+	case SchemaCompatibilityFull:
+		return append(checkSchemaCanRead(next, previous, ""), checkSchemaCanRead(previous, next, "")...)
+	default:
+		return nil
+	}
+}
+
+func checkSchemaCanRead(reader map[string]interface{}, writer map[string]interface{}, path string) []string {
+	// checks that the records valid for the writer schema are valid for the reader schema
+	var reasons []string
+	location := path
+	if location == "" {
+		location = "/"
+	}
+
+	if readerTypes := getSchemaTypes(reader); len(readerTypes) > 0 {
+		writerTypes := getSchemaTypes(writer)
+		if len(writerTypes) == 0 {
+			reasons = append(reasons, fmt.Sprintf("%s: type is restricted to %v", location, readerTypes))
+		}
+		for _, t := range writerTypes {
+			if !slices.Contains(readerTypes, t) && !(t == "integer" && slices.Contains(readerTypes, "number")) {
+				reasons = append(reasons, fmt.Sprintf("%s: type %s is no longer accepted", location, t))
+			}
+		}
+	}
+
+	if readerEnum, found := reader["enum"].([]interface{}); found {
+		writerEnum, found := writer["enum"].([]interface{})
+		if !found {
+			reasons = append(reasons, fmt.Sprintf("%s: values are restricted to an enum", location))
+		}
+		for _, value := range writerEnum {
+			if !slices.ContainsFunc(readerEnum, func(v interface{}) bool { return reflect.DeepEqual(v, value) }) {
+				reasons = append(reasons, fmt.Sprintf("%s: enum value %v is no longer accepted", location, value))
+			}
+		}
+	}
+
+	reasons = append(reasons, checkSchemaBounds(reader, writer, location)...)
+	reasons = append(reasons, checkSchemaUncheckedKeywords(reader, writer, location)...)
+
+	// objects
+	writerRequired := getSchemaStrings(writer, "required")
+	for _, name := range getSchemaStrings(reader, "required") {
+		if !slices.Contains(writerRequired, name) {
+			reasons = append(reasons, fmt.Sprintf("%s/%s: property is required but may be missing", path, name))
+		}
+	}
+
+	readerProperties, _ := reader["properties"].(map[string]interface{})
+	writerProperties, _ := writer["properties"].(map[string]interface{})
+	readerIsClosed := reader["additionalProperties"] == false
+	for _, name := range slices.Sorted(maps.Keys(writerProperties)) {
+		writerProperty := writerProperties[name]
+		readerProperty, found := readerProperties[name]
+		if !found {
+			if readerIsClosed {
+				reasons = append(reasons, fmt.Sprintf("%s/%s: property is no longer allowed", path, name))
+			}
+			continue
+		}
+		readerSchema, readerOk := readerProperty.(map[string]interface{})
+		writerSchema, writerOk := writerProperty.(map[string]interface{})
+		if readerOk && writerOk {
+			reasons = append(reasons, checkSchemaCanRead(readerSchema, writerSchema, path+"/"+name)...)
+		} else if !reflect.DeepEqual(readerProperty, writerProperty) {
+			reasons = append(reasons, fmt.Sprintf("%s/%s: property schema has changed and cannot be checked", path, name))
+		}
+	}
+	readerAdditional, readerFound := reader["additionalProperties"]
+	writerAdditional, writerFound := writer["additionalProperties"]
+	_, readerIsBool := readerAdditional.(bool)
+	_, writerIsBool := writerAdditional.(bool)
+	if (readerFound && !readerIsBool) || (writerFound && !writerIsBool) {
+		// additionalProperties holding a schema
+		if !reflect.DeepEqual(readerAdditional, writerAdditional) {
+			reasons = append(reasons, fmt.Sprintf("%s: additionalProperties has changed and cannot be checked", location))
+		}
+	} else if readerIsClosed && writer["additionalProperties"] != false {
+		reasons = append(reasons, fmt.Sprintf("%s: additional properties are no longer allowed", location))
+	}
+
+	// arrays
+	readerItems, readerOk := reader["items"].(map[string]interface{})
+	writerItems, writerOk := writer["items"].(map[string]interface{})
+	if readerOk && writerOk {
+		reasons = append(reasons, checkSchemaCanRead(readerItems, writerItems, path+"/[]")...)
+	} else if !reflect.DeepEqual(reader["items"], writer["items"]) {
+		reasons = append(reasons, fmt.Sprintf("%s: items has changed and cannot be checked", location))
+	}
+
+	return reasons
+}
+
+func checkSchemaBounds(reader map[string]interface{}, writer map[string]interface{}, location string) []string {
+	// the bounds of the reader must be looser than the bounds of the writer
+	var reasons []string
+	for _, keyword := range []string{"minimum", "exclusiveMinimum", "minLength", "minItems", "minProperties"} {
+		if readerBound, found := reader[keyword].(float64); found {
+			writerBound, found := writer[keyword].(float64)
+			if !found || writerBound < readerBound {
+				reasons = append(reasons, fmt.Sprintf("%s: %s is more restrictive", location, keyword))
+			}
+		}
+	}
+	for _, keyword := range []string{"maximum", "exclusiveMaximum", "maxLength", "maxItems", "maxProperties"} {
+		if readerBound, found := reader[keyword].(float64); found {
+			writerBound, found := writer[keyword].(float64)
+			if !found || writerBound > readerBound {
+				reasons = append(reasons, fmt.Sprintf("%s: %s is more restrictive", location, keyword))
+			}
+		}
+	}
+	return reasons
+}
+
+func checkSchemaUncheckedKeywords(reader map[string]interface{}, writer map[string]interface{}, location string) []string {
+	// the keywords not checked structurally must not change
+	var reasons []string
+	keywords := maps.Clone(reader)
+	maps.Copy(keywords, writer)
+	for _, keyword := range slices.Sorted(maps.Keys(keywords)) {
+		if slices.Contains(checkedSchemaKeywords, keyword) || slices.Contains(annotationSchemaKeywords, keyword) {
+			continue
+		}
+		if !reflect.DeepEqual(reader[keyword], writer[keyword]) {
+			reasons = append(reasons, fmt.Sprintf("%s: %s has changed and cannot be checked", location, keyword))
+		}
+	}
+	return reasons
+}
+
+func getSchemaTypes(schema map[string]interface{}) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		return getSchemaStrings(schema, "type")
+	}
+	return nil
+}
+
+func getSchemaStrings(schema map[string]interface{}, keyword string) []string {
+	values, _ := schema[keyword].([]interface{})
+	strValues := make([]string, 0, len(values))
+	for _, value := range values {
+		if strValue, ok := value.(string); ok {
+			strValues = append(strValues, strValue)
+		}
+	}
+	return strValues
+}
@@ -0,0 +1,130 @@
+package types
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCheckSchemaCompatibility(t *testing.T) {
+	property := func(keywords map[string]interface{}) map[string]interface{} {
+		// object schema with a single property "p"
+		return map[string]interface{}{"type": "object", "properties": map[string]interface{}{"p": keywords}}
+	}
+	testCases := []struct {
+		name     string
+		previous map[string]interface{}
+		next     map[string]interface{}
+		reasons  []string // backward compatibility
+	}{
+		{
+			name:     "same schema",
+			previous: property(map[string]interface{}{"type": "string", "pattern": "^[a-z]+$", "format": "email"}),
+			next:     property(map[string]interface{}{"type": "string", "pattern": "^[a-z]+$", "format": "email"}),
+		},
+		{
+			name:     "annotations changed",
+			previous: property(map[string]interface{}{"type": "string", "description": "a property"}),
+			next:     property(map[string]interface{}{"type": "string", "description": "the property", "title": "P", "examples": []interface{}{"a"}}),
+		},
+		{
+			name:     "looser bound",
+			previous: property(map[string]interface{}{"type": "string", "maxLength": 8.0}),
+			next:     property(map[string]interface{}{"type": "string", "maxLength": 16.0}),
+		},
+		{
+			name:     "pattern added",
+			previous: property(map[string]interface{}{"type": "string"}),
+			next:     property(map[string]interface{}{"type": "string", "pattern": "^[a-z]+$"}),
+			reasons:  []string{"/p: pattern has changed and cannot be checked"},
+		},
+		{
+			name:     "pattern changed",
+			previous: property(map[string]interface{}{"type": "string", "pattern": "^[a-z]+$"}),
+			next:     property(map[string]interface{}{"type": "string", "pattern": "^[a-f]+$"}),
+			reasons:  []string{"/p: pattern has changed and cannot be checked"},
+		},
+		{
+			name:     "const added",
+			previous: property(map[string]interface{}{"type": "string"}),
+			next:     property(map[string]interface{}{"type": "string", "const": "a"}),
+			reasons:  []string{"/p: const has changed and cannot be checked"},
+		},
+		{
+			name:     "format changed",
+			previous: property(map[string]interface{}{"type": "string", "format": "email"}),
+			next:     property(map[string]interface{}{"type": "string", "format": "uri"}),
+			reasons:  []string{"/p: format has changed and cannot be checked"},
+		},
+		{
+			name:     "multipleOf added",
+			previous: property(map[string]interface{}{"type": "integer"}),
+			next:     property(map[string]interface{}{"type": "integer", "multipleOf": 2.0}),
+			reasons:  []string{"/p: multipleOf has changed and cannot be checked"},
+		},
+		{
+			name:     "allOf added",
+			previous: property(map[string]interface{}{"type": "integer"}),
+			next:     property(map[string]interface{}{"type": "integer", "allOf": []interface{}{map[string]interface{}{"minimum": 1.0}}}),
+			reasons:  []string{"/p: allOf has changed and cannot be checked"},
+		},
+		{
+			name:     "anyOf changed",
+			previous: property(map[string]interface{}{"anyOf": []interface{}{map[string]interface{}{"type": "string"}, map[string]interface{}{"type": "integer"}}}),
+			next:     property(map[string]interface{}{"anyOf": []interface{}{map[string]interface{}{"type": "string"}}}),
+			reasons:  []string{"/p: anyOf has changed and cannot be checked"},
+		},
+		{
+			name:     "oneOf and $ref changed",
+			previous: map[string]interface{}{"$ref": "#/$defs/a", "oneOf": []interface{}{map[string]interface{}{"type": "string"}}},
+			next:     map[string]interface{}{"$ref": "#/$defs/b", "oneOf": []interface{}{map[string]interface{}{"type": "integer"}}},
+			reasons:  []string{"/: $ref has changed and cannot be checked", "/: oneOf has changed and cannot be checked"},
+		},
+		{
+			name:     "definitions changed",
+			previous: map[string]interface{}{"$ref": "#/$defs/a", "$defs": map[string]interface{}{"a": map[string]interface{}{"type": "string"}}},
+			next:     map[string]interface{}{"$ref": "#/$defs/a", "$defs": map[string]interface{}{"a": map[string]interface{}{"type": "integer"}}},
+			reasons:  []string{"/: $defs has changed and cannot be checked"},
+		},
+		{
+			name:     "boolean property schema changed",
+			previous: property(map[string]interface{}{"type": "string"}),
+			next:     map[string]interface{}{"type": "object", "properties": map[string]interface{}{"p": false}},
+			reasons:  []string{"/p: property schema has changed and cannot be checked"},
+		},
+		{
+			name:     "additionalProperties schema added",
+			previous: map[string]interface{}{"type": "object"},
+			next:     map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}},
+			reasons:  []string{"/: additionalProperties has changed and cannot be checked"},
+		},
+		{
+			name:     "tuple items changed",
+			previous: map[string]interface{}{"type": "array", "items": []interface{}{map[string]interface{}{"type": "string"}}},
+			next:     map[string]interface{}{"type": "array", "items": []interface{}{map[string]interface{}{"type": "integer"}}},
+			reasons:  []string{"/: items has changed and cannot be checked"},
+		},
+		{
+			name:     "nested items keyword changed",
+			previous: map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			next:     map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "pattern": "^a"}},
+			reasons:  []string{"/[]: pattern has changed and cannot be checked"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			reasons := CheckSchemaCompatibility(SchemaCompatibilityBackward, testCase.previous, testCase.next)
+			if !slices.Equal(reasons, testCase.reasons) {
+				t.Fatalf("expected reasons %q, got %q", testCase.reasons, reasons)
+			}
+			// a keyword not checked is incompatible whatever the direction of the change
+			if len(testCase.reasons) == 0 {
+				return
+			}
+			reasons = CheckSchemaCompatibility(SchemaCompatibilityForward, testCase.previous, testCase.next)
+			if len(reasons) != len(testCase.reasons) {
+				t.Fatalf("expected %d forward reasons, got %q", len(testCase.reasons), reasons)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package types
+
+import (
+	"errors"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+var ErrInvalidStreamClone = errors.New("invalid stream clone")
+
+// A clone is a new stream holding the records of a source stream up to a point in time (message id or timestamp),
+// the records keep their message id and creation date. The whole source stream is cloned when no end is set.
+type StreamCloneRequest struct {
+	UntilMessageId *MessageId        `json:"untilMessageId,omitempty"`                                                                        // last message id cloned (included), not allowed for a partitioned stream
+	UntilTimestamp *time.Time        `json:"untilTimestamp,omitempty"`                                                                        // the records put from this date are not cloned
+	Properties     map[string]string `json:"properties,omitempty" validate:"omitempty,lte=32,dive,keys,gt=0,lte=64,endkeys,max=256,required"` // added to (or replacing) the properties of the source stream
+}
+
+func (r *StreamCloneRequest) UnmarshalJSON(data []byte) error {
+	// the timestamp is either a RFC3339 date (nanosecond precision) or a duration relative to now (example: "-15m")
+	type Request StreamCloneRequest
+	payload := struct {
+		*Request
+		UntilTimestamp *string `json:"untilTimestamp"`
+	}{Request: (*Request)(r)}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return err
+	}
+
+	if payload.UntilTimestamp != nil {
+		timestamp, err := ParseIteratorTimestamp(*payload.UntilTimestamp, time.Now())
+		if err != nil {
+			return err
+		}
+		r.UntilTimestamp = &timestamp
+	}
+	return nil
+}
+
+func (r *StreamCloneRequest) CheckEnd() error {
+	if r.UntilMessageId != nil && r.UntilTimestamp != nil {
+		return errors.New("untilMessageId and untilTimestamp are mutually exclusive")
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+// Version of the format of the stream exports
+const StreamExportVersion = 1
+
+var ErrInvalidStreamExport = errors.New("invalid stream export")
+
+// A stream export is a JSONL file: the first line is the header (StreamExportHeader),
+// the other lines are the records ordered by partition then by message id (ExportedRecord).
+type StreamExportHeader struct {
+	Version      int              `json:"version" example:"1"`
+	UUID         StreamUUID       `json:"uuid"`
+	CreationDate time.Time        `json:"creationDate"`
+	ExportDate   time.Time        `json:"exportDate"`
+	Properties   StreamProperties `json:"properties"`
+	Partitions   int              `json:"partitions,omitempty"` // partitioned stream only: count of partitions
+}
+
+// A record of a stream export (same fields as the records read from the storage providers)
+type ExportedRecord struct {
+	Id           MessageId     `json:"i"`
+	CreationDate time.Time     `json:"d"`
+	Message      interface{}   `json:"m"`
+	Headers      RecordHeaders `json:"h,omitempty"`
+	Partition    *int          `json:"p,omitempty"` // partitioned stream only
+}
+
+type StreamImportOptions struct {
+	PreserveIds bool // keep the message ids and the creation dates of the records (new ones are given otherwise)
+	KeepUUID    bool // create the stream with the UUID of the exported stream (a new UUID is generated otherwise)
+}
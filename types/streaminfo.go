@@ -15,13 +15,35 @@ type StreamMessagesInfo struct {
 	LastMsgTimestamp  time.Time `json:"fastMsgTimestamp"`
 }
 
+func (m *StreamMessagesInfo) TrimHead(cptRemoved Size64, sizeInBytesRemoved Size64, firstMsgId MessageId, firstMsgTimestamp time.Time) {
+	// update the messages info after records were removed from the head of the stream
+	if cptRemoved >= m.CptMessages {
+		// the stream is empty, the first message id is the id of the next message to be written
+		m.CptMessages = 0
+		m.SizeInBytes = 0
+		m.FirstMsgId = m.LastMsgId + 1
+		m.FirstMsgTimestamp = m.LastMsgTimestamp
+		return
+	}
+
+	m.CptMessages -= cptRemoved
+	if sizeInBytesRemoved < m.SizeInBytes {
+		m.SizeInBytes -= sizeInBytesRemoved
+	} else {
+		m.SizeInBytes = 0
+	}
+	m.FirstMsgId = firstMsgId
+	m.FirstMsgTimestamp = firstMsgTimestamp
+}
+
 type StreamInfo struct {
-	UUID             StreamUUID         `json:"uuid" example:"4ce589e2-b483-467b-8b59-758b339801db"`
-	CreationDate     time.Time          `json:"creationDate"`
-	LastUpdate       time.Time          `json:"lastUpdate"`
-	Properties       StreamProperties   `json:"properties"`
-	IngestedMessages StreamMessagesInfo `json:"ingestedMessages"` // messages that have been ingested in the stream
-	ReadableMessages StreamMessagesInfo `json:"readableMessages"` // messages that are readable by a consumer
+	UUID             StreamUUID          `json:"uuid" example:"4ce589e2-b483-467b-8b59-758b339801db"`
+	CreationDate     time.Time           `json:"creationDate"`
+	LastUpdate       time.Time           `json:"lastUpdate"`
+	Properties       StreamProperties    `json:"properties"`
+	IngestedMessages StreamMessagesInfo  `json:"ingestedMessages"` // messages that have been ingested in the stream
+	ReadableMessages StreamMessagesInfo  `json:"readableMessages"` // messages that are readable by a consumer
+	Partitioning     *StreamPartitioning `json:"partitioning,omitempty"`
 }
 
 type StreamInfoList []*StreamInfo
@@ -50,6 +72,14 @@ func NewStreamInfo(uuid StreamUUID) *StreamInfo {
 	}
 }
 
+func (s *StreamInfo) IsPartitioned() bool {
+	return s.Partitioning != nil && len(s.Partitioning.Partitions) > 0
+}
+
+func (s *StreamInfo) IsPartition() bool {
+	return s.Partitioning != nil && s.Partitioning.ParentUUID != nil
+}
+
 func (s *StreamInfo) UpdateProperties(properties *StreamProperties) {
 	// add or update properties
 	if properties != nil {
@@ -0,0 +1,31 @@
+package types
+
+// Count of corrupted message ids listed by a scrub report
+const MaxScrubCorruptedIds = 100
+
+type StreamScrubReport struct {
+	CptRecords   int64                `json:"cptRecords"`           // records verified
+	CptChecksums int64                `json:"cptChecksums"`         // records verified with their checksum (the others were written before the checksums)
+	CptCorrupted int64                `json:"cptCorrupted"`         // corrupted records
+	CorruptedIds []MessageId          `json:"corruptedIds"`         // first corrupted records
+	Partitions   []*StreamScrubReport `json:"partitions,omitempty"` // report of each partition (partitioned stream only, the message ids are specific to each partition)
+}
+
+func NewStreamScrubReport() *StreamScrubReport {
+	return &StreamScrubReport{CorruptedIds: make([]MessageId, 0)}
+}
+
+func (r *StreamScrubReport) AddCorrupted(msgId MessageId) {
+	r.CptCorrupted++
+	if len(r.CorruptedIds) < MaxScrubCorruptedIds {
+		r.CorruptedIds = append(r.CorruptedIds, msgId)
+	}
+}
+
+func (r *StreamScrubReport) AddPartition(partition *StreamScrubReport) {
+	// the counters of a partitioned stream are the sum of the counters of its partitions
+	r.CptRecords += partition.CptRecords
+	r.CptChecksums += partition.CptChecksums
+	r.CptCorrupted += partition.CptCorrupted
+	r.Partitions = append(r.Partitions, partition)
+}
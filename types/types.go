@@ -15,7 +15,9 @@ type StreamUUIDList []StreamUUID
 
 // Defered stream record to be saved
 type DeferedStreamRecord struct {
-	Id           MessageId   `json:"i"`
-	CreationDate time.Time   `json:"d"`
-	Msg          interface{} `json:"m"`
+	Id           MessageId     `json:"i"`
+	CreationDate time.Time     `json:"d"`
+	Msg          interface{}   `json:"m"`
+	Headers      RecordHeaders `json:"h,omitempty"`
+	SizeInBytes  uint64        `json:"-"` // estimated size of the message (used to flush the ingest buffer)
 }
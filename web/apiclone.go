@@ -0,0 +1,60 @@
+package web
+
+import (
+	"strings"
+
+	"github.com/nbigot/ministream/log"
+	"github.com/nbigot/ministream/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// CloneStream godoc
+// @Summary Clone a stream
+// @Description Create a new stream holding the records of the stream up to a message id (included) or a timestamp (excluded),
+// @Description the records keep their message id and creation date. The whole stream is cloned when no end is set.
+// @Description The properties of the stream are copied, the properties of the request are added (or replace the copied ones).
+// @Description The clone of a partitioned stream has the same partitions (the partitions are cloned up to a timestamp only).
+// @Description The consumer groups, the schemas and the producers of the stream are not cloned.
+// @ID stream-clone
+// @Accept json
+// @Produce json
+// @Tags Stream
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param clone body types.StreamCloneRequest true "clone"
+// @Success 201 {object} stream.CloneStreamResponse "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Success 500 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/clone [post]
+func (w *WebAPIServer) CloneStream(c *fiber.Ctx) error {
+	_, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	req := types.StreamCloneRequest{}
+	if len(c.Body()) > 0 {
+		if apiErr = GetPayload(c, &req); apiErr != nil {
+			return apiErr.HTTPResponse(c)
+		}
+	}
+
+	response, apiErr := w.service.CloneStream(c.Context(), streamPtr, &req)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	log.Logger.Info(
+		"Stream cloned",
+		zap.String("topic", "stream"),
+		zap.String("method", "CloneStream"),
+		zap.String("ipAddress", c.IP()),
+		zap.String("ipAddresses", strings.Join(c.IPs(), ";")),
+		zap.String("streamUUID", response.StreamUUID.String()),
+		zap.String("sourceStreamUUID", response.SourceStreamUUID.String()),
+		zap.Int64("records", response.CountRecords),
+	)
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
@@ -0,0 +1,347 @@
+package web
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/nbigot/ministream/account"
+	"github.com/nbigot/ministream/constants"
+	"github.com/nbigot/ministream/log"
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type ConsumerGroupPositionPayload struct {
+	MessageId types.MessageId `json:"messageId" example:"1234"`
+}
+
+// ListConsumerGroups godoc
+// @Summary List consumer groups
+// @Description Get the consumer groups and their committed message id for the given stream UUID
+// @ID stream-list-consumer-groups
+// @Accept json
+// @Produce json
+// @Tags ConsumerGroup
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Success 200 {object} stream.ListConsumerGroupsResponse "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/consumergroups [get]
+func (w *WebAPIServer) ListConsumerGroups(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	response := stream.ListConsumerGroupsResponse{
+		Status:         "success",
+		StreamUUID:     streamUUID,
+		ConsumerGroups: streamPtr.GetConsumerGroups(),
+	}
+	return c.JSON(response)
+}
+
+// GetConsumerGroup godoc
+// @Summary Get a consumer group
+// @Description Get the committed message id of a consumer group
+// @ID stream-get-consumer-group
+// @Accept json
+// @Produce json
+// @Tags ConsumerGroup
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param consumergroup path string true "Consumer group name"
+// @Success 200 {object} stream.ConsumerGroupResponse "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Success 404 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/consumergroup/{consumergroup} [get]
+func (w *WebAPIServer) GetConsumerGroup(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	name, apiErr := GetConsumerGroupNameFromParameter(c, streamUUID)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	group, found := streamPtr.GetConsumerGroup(name)
+	if !found {
+		httpError := apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "consumer group not found",
+			Details:    name,
+			Code:       constants.ErrorConsumerGroupNotFound,
+			HttpCode:   fiber.StatusNotFound,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	response := stream.ConsumerGroupResponse{
+		Status:        "success",
+		Message:       "",
+		StreamUUID:    streamUUID,
+		ConsumerGroup: group,
+	}
+	return c.JSON(response)
+}
+
+// CommitConsumerGroup godoc
+// @Summary Commit a consumer group
+// @Description Commit the last message id processed by a consumer group (the group is created if it does not exist)
+// @ID stream-commit-consumer-group
+// @Accept json
+// @Produce json
+// @Tags ConsumerGroup
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param consumergroup path string true "Consumer group name"
+// @Param payload body ConsumerGroupPositionPayload true "ConsumerGroupPositionPayload" Format(ConsumerGroupPositionPayload)
+// @Success 200 {object} stream.ConsumerGroupResponse "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/consumergroup/{consumergroup}/commit [post]
+func (w *WebAPIServer) CommitConsumerGroup(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	name, apiErr := GetConsumerGroupNameFromParameter(c, streamUUID)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	var payload ConsumerGroupPositionPayload
+	if apiErr = GetPayload(c, &payload); apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	group, err := w.service.CommitConsumerGroup(streamPtr, name, payload.MessageId)
+	if err != nil {
+		httpError := apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "cannot commit consumer group",
+			Details:    err.Error(),
+			Code:       constants.ErrorCantCommitConsumerGroup,
+			HttpCode:   fiber.StatusBadRequest,
+			Err:        err,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	response := stream.ConsumerGroupResponse{
+		Status:        "success",
+		Message:       "consumer group committed",
+		StreamUUID:    streamUUID,
+		ConsumerGroup: group,
+	}
+	return c.JSON(response)
+}
+
+// CommitRecordsIterator godoc
+// @Summary Commit the position of a stream records iterator
+// @Description Commit the last record id read by the iterator into the consumer group the iterator was created with
+// @ID stream-commit-records-iterator
+// @Accept json
+// @Produce json
+// @Tags ConsumerGroup
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param streamiteratoruuid path string true "Stream iterator UUID" Format(uuid.UUID)
+// @Success 200 {object} stream.ConsumerGroupResponse "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/iterator/{streamiteratoruuid}/commit [post]
+func (w *WebAPIServer) CommitRecordsIterator(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	iteratorUuid, err := uuid.Parse(c.Params(constants.ParamNameStreamIteratorUuid))
+	if err != nil {
+		vErr := apierror.ValidationError{
+			FailedField: constants.ParamNameStreamIteratorUuid,
+			Tag:         "parameter",
+			Value:       c.Params(constants.ParamNameStreamIteratorUuid),
+		}
+		httpError := apierror.APIError{
+			StreamUUID:       streamUUID,
+			Message:          "invalid iterator uuid",
+			Details:          err.Error(),
+			Code:             constants.ErrorInvalidIteratorUuid,
+			HttpCode:         fiber.StatusBadRequest,
+			ValidationErrors: []*apierror.ValidationError{&vErr},
+			Err:              err,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	group, err := w.service.CommitIteratorConsumerGroup(streamPtr, iteratorUuid)
+	if err != nil {
+		httpError := apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "cannot commit consumer group",
+			Details:    err.Error(),
+			Code:       constants.ErrorCantCommitConsumerGroup,
+			HttpCode:   fiber.StatusBadRequest,
+			Err:        err,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	response := stream.ConsumerGroupResponse{
+		Status:        "success",
+		Message:       "consumer group committed",
+		StreamUUID:    streamUUID,
+		ConsumerGroup: group,
+	}
+	return c.JSON(response)
+}
+
+// ResetConsumerGroup godoc
+// @Summary Reset a consumer group
+// @Description Move the committed message id of a consumer group (0 means restart from the first message)
+// @ID stream-reset-consumer-group
+// @Accept json
+// @Produce json
+// @Tags ConsumerGroup
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param consumergroup path string true "Consumer group name"
+// @Param payload body ConsumerGroupPositionPayload true "ConsumerGroupPositionPayload" Format(ConsumerGroupPositionPayload)
+// @Success 200 {object} stream.ConsumerGroupResponse "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Success 404 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/consumergroup/{consumergroup}/reset [post]
+func (w *WebAPIServer) ResetConsumerGroup(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	name, apiErr := GetConsumerGroupNameFromParameter(c, streamUUID)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	var payload ConsumerGroupPositionPayload
+	if apiErr = GetPayload(c, &payload); apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	group, err := w.service.ResetConsumerGroup(streamPtr, name, payload.MessageId)
+	if err != nil {
+		httpError := consumerGroupError(streamUUID, "cannot reset consumer group", constants.ErrorCantResetConsumerGroup, err)
+		return httpError.HTTPResponse(c)
+	}
+
+	account := account.AccountMgr.GetAccount()
+	log.Logger.Info(
+		"Consumer group reset",
+		zap.String("topic", "stream"),
+		zap.String("method", "ResetConsumerGroup"),
+		zap.String("accountId", account.Id.String()),
+		zap.String("ipAddress", c.IP()),
+		zap.String("ipAddresses", strings.Join(c.IPs(), ";")),
+		zap.String("streamUUID", streamUUID.String()),
+		zap.String("consumerGroup", name),
+		zap.Uint64("committedMsgId", group.CommittedMsgId),
+	)
+
+	response := stream.ConsumerGroupResponse{
+		Status:        "success",
+		Message:       "consumer group reset",
+		StreamUUID:    streamUUID,
+		ConsumerGroup: group,
+	}
+	return c.JSON(response)
+}
+
+// DeleteConsumerGroup godoc
+// @Summary Delete a consumer group
+// @Description Delete a consumer group and its committed message id
+// @ID stream-delete-consumer-group
+// @Accept json
+// @Produce json
+// @Tags ConsumerGroup
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param consumergroup path string true "Consumer group name"
+// @success 200 {object} web.JSONResultSuccess{} "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Success 404 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/consumergroup/{consumergroup} [delete]
+func (w *WebAPIServer) DeleteConsumerGroup(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	name, apiErr := GetConsumerGroupNameFromParameter(c, streamUUID)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	if err := w.service.DeleteConsumerGroup(streamPtr, name); err != nil {
+		httpError := consumerGroupError(streamUUID, "cannot delete consumer group", constants.ErrorCantDeleteConsumerGroup, err)
+		return httpError.HTTPResponse(c)
+	}
+
+	account := account.AccountMgr.GetAccount()
+	log.Logger.Info(
+		"Consumer group deleted",
+		zap.String("topic", "stream"),
+		zap.String("method", "DeleteConsumerGroup"),
+		zap.String("accountId", account.Id.String()),
+		zap.String("ipAddress", c.IP()),
+		zap.String("ipAddresses", strings.Join(c.IPs(), ";")),
+		zap.String("streamUUID", streamUUID.String()),
+		zap.String("consumerGroup", name),
+	)
+
+	return c.JSON(
+		JSONResultSuccess{
+			Code:    fiber.StatusOK,
+			Message: "success",
+		},
+	)
+}
+
+func GetConsumerGroupNameFromParameter(c *fiber.Ctx, streamUUID types.StreamUUID) (string, *apierror.APIError) {
+	name := c.Params(constants.ParamNameConsumerGroup)
+	if !types.IsValidConsumerGroupName(name) {
+		vErr := apierror.ValidationError{FailedField: constants.ParamNameConsumerGroup, Tag: "parameter", Value: name}
+		return name, &apierror.APIError{
+			StreamUUID:       streamUUID,
+			Message:          "invalid consumer group name",
+			Details:          "allowed characters are letters, digits, '_', '.' and '-'",
+			Code:             constants.ErrorInvalidConsumerGroupName,
+			HttpCode:         fiber.StatusBadRequest,
+			ValidationErrors: []*apierror.ValidationError{&vErr},
+		}
+	}
+
+	return name, nil
+}
+
+func consumerGroupError(streamUUID types.StreamUUID, message string, errorCode int, err error) *apierror.APIError {
+	if errors.Is(err, stream.ErrConsumerGroupNotFound) {
+		return &apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    message,
+			Details:    err.Error(),
+			Code:       constants.ErrorConsumerGroupNotFound,
+			HttpCode:   fiber.StatusNotFound,
+			Err:        err,
+		}
+	}
+
+	return &apierror.APIError{
+		StreamUUID: streamUUID,
+		Message:    message,
+		Details:    err.Error(),
+		Code:       errorCode,
+		HttpCode:   fiber.StatusBadRequest,
+		Err:        err,
+	}
+}
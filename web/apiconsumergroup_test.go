@@ -0,0 +1,83 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestConsumerGroupAPI(t *testing.T) {
+	w, addr := newTestWebAPIServer(t)
+	s := createTestStream(t, w)
+	msgIds, _, err := s.PutMessages(nil, []interface{}{map[string]interface{}{"n": 1}, map[string]interface{}{"n": 2}, map[string]interface{}{"n": 3}}, nil)
+	if err != nil {
+		t.Fatalf("error while putting records: %v", err)
+	}
+	if err = s.WaitForDurability(context.Background(), types.DurabilityFlushed, msgIds); err != nil {
+		t.Fatalf("error while saving records: %v", err)
+	}
+
+	send := func(method string, path string, body string) (int, *types.ConsumerGroup) {
+		// returns the status and the consumer group of the response
+		t.Helper()
+		req, err := http.NewRequest(method, fmt.Sprintf("http://%s/api/v1/stream/%s/%s", addr, s.GetUUID(), path), strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("error while creating request: %v", err)
+		}
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error while sending request: %v", err)
+		}
+		defer resp.Body.Close()
+		response := stream.ConsumerGroupResponse{}
+		if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatalf("error while decoding response: %v", err)
+		}
+		return resp.StatusCode, response.ConsumerGroup
+	}
+	expectCommitted := func(method string, path string, body string, expectedMsgId types.MessageId) {
+		t.Helper()
+		if status, group := send(method, path, body); status != fiber.StatusOK || group == nil || group.CommittedMsgId != expectedMsgId {
+			t.Fatalf("expected the message %d committed by %s %s, got %d %+v", expectedMsgId, method, path, status, group)
+		}
+	}
+	expectStatus := func(method string, path string, body string, expectedStatus int) {
+		t.Helper()
+		if status, _ := send(method, path, body); status != expectedStatus {
+			t.Fatalf("expected status %d for %s %s, got %d", expectedStatus, method, path, status)
+		}
+	}
+
+	// the iterator commits the last record it has read into its consumer group
+	itUUID, apiErr := w.service.CreateRecordsIterator(s, &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE", ConsumerGroup: "g"})
+	if apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr.Details)
+	}
+	if _, err = s.GetRecords(nil, itUUID, 2); err != nil {
+		t.Fatalf("error while getting records: %v", err)
+	}
+	expectCommitted(fiber.MethodPost, fmt.Sprintf("iterator/%s/commit", itUUID), "", 2)
+	expectCommitted(fiber.MethodGet, "consumergroup/g", "", 2)
+
+	// a commit only moves the position forward, a reset moves it anywhere
+	expectStatus(fiber.MethodPost, "consumergroup/g/commit", `{"messageId": 1}`, fiber.StatusBadRequest)
+	expectStatus(fiber.MethodPost, "consumergroup/g/commit", `{"messageId": 4}`, fiber.StatusBadRequest)
+	expectCommitted(fiber.MethodPost, "consumergroup/g/commit", `{"messageId": 3}`, 3)
+	expectCommitted(fiber.MethodPost, "consumergroup/g/reset", `{"messageId": 1}`, 1)
+	expectStatus(fiber.MethodPost, "consumergroup/unknown/reset", `{"messageId": 1}`, fiber.StatusNotFound)
+	expectStatus(fiber.MethodPost, "consumergroup/g!/commit", `{"messageId": 3}`, fiber.StatusBadRequest)
+
+	// a deleted consumer group is not found anymore
+	expectStatus(fiber.MethodDelete, "consumergroup/g", "", fiber.StatusOK)
+	expectStatus(fiber.MethodDelete, "consumergroup/g", "", fiber.StatusNotFound)
+	expectStatus(fiber.MethodGet, "consumergroup/g", "", fiber.StatusNotFound)
+}
@@ -0,0 +1,192 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nbigot/ministream/constants"
+	"github.com/nbigot/ministream/log"
+	"github.com/nbigot/ministream/service"
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// ExportStream godoc
+// @Summary Export a stream
+// @Description Download the records of the stream as JSONL: the first line holds the properties of the stream,
+// @Description the other lines are the records with their message id, creation date, headers (and partition).
+// @Description The records put while the stream is exported are not exported.
+// @ID stream-export
+// @Produce application/x-ndjson
+// @Tags Stream
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param gzip query bool false "compress the export with gzip" example(true)
+// @Success 200 {string} string "JSONL export"
+// @Success 400 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/export [get]
+func (w *WebAPIServer) ExportStream(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+	if streamPtr.IsPartition() {
+		httpError := apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "cannot export stream",
+			Details:    "cannot export a partition, export its partitioned stream instead",
+			Code:       constants.ErrorInvalidParameterValue,
+			HttpCode:   fiber.StatusBadRequest,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	compress := c.QueryBool("gzip")
+	filename := streamUUID.String() + ".jsonl"
+	if compress {
+		filename += ".gz"
+		c.Set(fiber.HeaderContentType, "application/gzip")
+	} else {
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	}
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+	// the request context must not be used once the handler has returned
+	serverDone := c.Context().Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Context().SetBodyStreamWriter(func(writer *bufio.Writer) {
+		defer cancel()
+		go func() {
+			select {
+			case <-serverDone:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		var output io.Writer = writer
+		var gzipWriter *gzip.Writer
+		if compress {
+			gzipWriter = gzip.NewWriter(writer)
+			output = gzipWriter
+		}
+		cptRecords, err := w.service.ExportStream(ctx, streamPtr, output)
+		if gzipWriter != nil {
+			err = errors.Join(err, gzipWriter.Close())
+		}
+		err = errors.Join(err, writer.Flush())
+		if err != nil {
+			// the response has already started, the client gets a truncated export
+			log.Logger.Error(
+				"Cannot export stream",
+				zap.String("topic", "stream"),
+				zap.String("method", "ExportStream"),
+				zap.String("stream.uuid", streamUUID.String()),
+				zap.Int64("records", cptRecords),
+				zap.Error(err),
+			)
+			return
+		}
+		log.Logger.Info(
+			"Stream exported",
+			zap.String("topic", "stream"),
+			zap.String("method", "ExportStream"),
+			zap.String("stream.uuid", streamUUID.String()),
+			zap.Int64("records", cptRecords),
+		)
+	})
+
+	return nil
+}
+
+// ImportStream godoc
+// @Summary Import a stream
+// @Description Create a stream from a stream export (JSONL, optionally compressed with gzip: header Content-Encoding gzip, or detected).
+// @Description The request body is limited to 10 MiB (BodyLimit of the server), a larger export must be compressed.
+// @Description The records keep their message id and creation date when preserveIds is set, new ones are given otherwise.
+// @Description The records are not transformed by the ingest pipeline of the stream. The stream is deleted if the import fails.
+// @ID stream-import
+// @Accept application/x-ndjson
+// @Produce json
+// @Tags Stream
+// @Param preserveIds query bool false "keep the message ids and the creation dates of the records" example(true)
+// @Param keepUUID query bool false "create the stream with the UUID of the exported stream" example(false)
+// @Param Content-Encoding header string false "gzip if the export is compressed (detected otherwise)"
+// @Success 201 {object} stream.ImportStreamResponse
+// @Success 400 {object} apierror.APIError
+// @Success 409 {object} apierror.APIError
+// @Success 413 {object} apierror.APIError "request body larger than the BodyLimit of the server"
+// @Success 415 {object} apierror.APIError "content encoding other than gzip"
+// @Success 500 {object} apierror.APIError
+// @Router /api/v1/stream/import [post]
+func (w *WebAPIServer) ImportStream(c *fiber.Ctx) error {
+	options := types.StreamImportOptions{
+		PreserveIds: c.QueryBool("preserveIds"),
+		KeepUUID:    c.QueryBool("keepUUID"),
+	}
+
+	// The raw body is read (c.Body() would decompress it in memory), it is decompressed while it is imported
+	// when the Content-Encoding header is gzip, or when no encoding is set and the body starts like a gzip file.
+	// The whole request body is received first, therefore an export is limited to the BodyLimit of the server.
+	body := c.Request().Body()
+	var reader io.Reader = bytes.NewReader(body)
+	encoding := strings.ToLower(strings.TrimSpace(c.Get(fiber.HeaderContentEncoding)))
+	isGzip := encoding == "gzip" || (encoding == "" && len(body) > 2 && body[0] == 0x1f && body[1] == 0x8b)
+	if !isGzip && encoding != "" && encoding != "identity" {
+		err := fmt.Errorf("unsupported content encoding %q (gzip only)", encoding)
+		return errorImportStream(constants.ErrorInvalidParameterValue, fiber.StatusUnsupportedMediaType, err).HTTPResponse(c)
+	}
+	if isGzip {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return errorImportStream(constants.ErrorInvalidStreamExport, fiber.StatusBadRequest, err).HTTPResponse(c)
+		}
+		defer func() {
+			_ = gzipReader.Close()
+		}()
+		reader = gzipReader
+	}
+
+	response, err := w.service.ImportStream(c.Context(), reader, options)
+	if err != nil {
+		switch {
+		case errors.Is(err, types.ErrInvalidStreamExport), errors.Is(err, gzip.ErrHeader), errors.Is(err, gzip.ErrChecksum), errors.Is(err, io.ErrUnexpectedEOF):
+			return errorImportStream(constants.ErrorInvalidStreamExport, fiber.StatusBadRequest, err).HTTPResponse(c)
+		case errors.Is(err, service.ErrStreamAlreadyExists):
+			return errorImportStream(constants.ErrorCantImportStream, fiber.StatusConflict, err).HTTPResponse(c)
+		default:
+			return errorImportStream(constants.ErrorCantImportStream, fiber.StatusInternalServerError, err).HTTPResponse(c)
+		}
+	}
+
+	log.Logger.Info(
+		"Stream imported",
+		zap.String("topic", "stream"),
+		zap.String("method", "ImportStream"),
+		zap.String("ipAddress", c.IP()),
+		zap.String("ipAddresses", strings.Join(c.IPs(), ";")),
+		zap.String("streamUUID", response.StreamUUID.String()),
+		zap.String("sourceStreamUUID", response.SourceStreamUUID.String()),
+		zap.Int64("records", response.CountRecords),
+	)
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func errorImportStream(errorCode int, httpCode int, err error) *apierror.APIError {
+	return &apierror.APIError{
+		Message:  "cannot import stream",
+		Details:  err.Error(),
+		Code:     errorCode,
+		HttpCode: httpCode,
+		Err:      err,
+	}
+}
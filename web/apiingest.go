@@ -0,0 +1,165 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nbigot/ministream/constants"
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetStreamIngestStats godoc
+// @Summary Get statistics about the ingestion of a stream
+// @Description Get the depth of the ingest queue of the given stream UUID (records put and waiting to be buffered),
+// @Description its backpressure and the count of records rejected or shed because the ingest buffer was full.
+// @ID stream-get-ingest-stats
+// @Accept json
+// @Produce json
+// @Tags Stream
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Success 200 {object} stream.GetStreamIngestStatsResponse "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/ingest/stats [get]
+func (w *WebAPIServer) GetStreamIngestStats(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	response := stream.GetStreamIngestStatsResponse{
+		Status:     "success",
+		StreamUUID: streamUUID,
+		Ingest:     streamPtr.GetIngestStats(),
+	}
+	return c.JSON(response)
+}
+
+func (w *WebAPIServer) sendPutRecordsError(c *fiber.Ctx, streamUUID types.StreamUUID, err error, code int) error {
+	// the producers are told when to retry if the records are rejected because the stream is overloaded
+	if errors.Is(err, stream.ErrIngestBufferFull) || errors.Is(err, stream.ErrIngestBufferTimeout) {
+		retryAfter := w.appConfig.Streams.Backpressure.RetryAfter
+		if retryAfter <= 0 {
+			retryAfter = 1
+		}
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	}
+	return errorCantPutRecords(streamUUID, err, code).HTTPResponse(c)
+}
+
+func errorCantPutRecords(streamUUID types.StreamUUID, err error, code int) *apierror.APIError {
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var seqErr *stream.ProducerSequenceError
+	if errors.As(err, &seqErr) {
+		return &apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "producer sequence out of order",
+			Details:    seqErr.Error(),
+			Code:       constants.ErrorProducerSequenceOutOfOrder,
+			HttpCode:   fiber.StatusConflict,
+			Err:        err,
+		}
+	}
+
+	switch {
+	case errors.Is(err, stream.ErrIngestBufferFull):
+		return &apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "stream overloaded",
+			Details:    err.Error(),
+			Code:       constants.ErrorStreamOverloaded,
+			HttpCode:   fiber.StatusTooManyRequests,
+			Err:        err,
+		}
+	case errors.Is(err, stream.ErrIngestBufferTimeout):
+		return &apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "stream overloaded",
+			Details:    err.Error(),
+			Code:       constants.ErrorStreamOverloaded,
+			HttpCode:   fiber.StatusServiceUnavailable,
+			Err:        err,
+		}
+	case errors.Is(err, stream.ErrIngestBatchTooLarge):
+		return &apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "batch too large",
+			Details:    err.Error(),
+			Code:       constants.ErrorIngestBatchTooLarge,
+			HttpCode:   fiber.StatusRequestEntityTooLarge,
+			Err:        err,
+		}
+	}
+
+	return &apierror.APIError{
+		Message:  "invalid json body format",
+		Details:  fmt.Sprint(err),
+		Code:     code,
+		HttpCode: fiber.StatusInternalServerError,
+		Err:      err,
+	}
+}
+
+func getDurabilityFromRequest(c *fiber.Ctx, streamPtr *stream.Stream) (string, *apierror.APIError) {
+	// the producer can ask for a given durability level with the x-ministream-durability header
+	// (durability level of the stream by default)
+	var level string
+	var err error
+	if value := c.Get("x-ministream-durability"); value != "" {
+		level, err = types.ParseDurability(value)
+	} else {
+		level, err = streamPtr.GetDurability()
+	}
+	if err != nil {
+		return "", &apierror.APIError{
+			StreamUUID: streamPtr.GetUUID(),
+			Message:    "invalid durability",
+			Details:    err.Error(),
+			Code:       constants.ErrorInvalidDurability,
+			HttpCode:   fiber.StatusBadRequest,
+			Err:        err,
+		}
+	}
+	return level, nil
+}
+
+func waitForDurability(c *fiber.Ctx, streamPtr *stream.Stream, level string, batch *types.ProducerBatch) *apierror.APIError {
+	// the records of a partitioned stream are persisted by the partition where they were put
+	target := streamPtr
+	if batch.Partition != nil {
+		partitionPtr, err := streamPtr.GetPartition(*batch.Partition)
+		if err != nil {
+			return errorRecordsNotPersisted(streamPtr.GetUUID(), err)
+		}
+		target = partitionPtr
+	}
+
+	if err := target.WaitForDurability(c.Context(), level, batch.MessageIds); err != nil {
+		return errorRecordsNotPersisted(streamPtr.GetUUID(), err)
+	}
+	return nil
+}
+
+func errorRecordsNotPersisted(streamUUID types.StreamUUID, err error) *apierror.APIError {
+	// the records are put (they may be persisted later): the producer should retry as an idempotent producer
+	httpCode := fiber.StatusInternalServerError
+	if errors.Is(err, stream.ErrDurabilityTimeout) {
+		httpCode = fiber.StatusGatewayTimeout
+	}
+	return &apierror.APIError{
+		StreamUUID: streamUUID,
+		Message:    "records not persisted",
+		Details:    err.Error(),
+		Code:       constants.ErrorRecordsNotPersisted,
+		HttpCode:   httpCode,
+		Err:        err,
+	}
+}
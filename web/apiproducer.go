@@ -0,0 +1,78 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nbigot/ministream/constants"
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func getProducerFromRequest(c *fiber.Ctx, streamUUID types.StreamUUID) (string, uint64, *apierror.APIError) {
+	// returns the id and the sequence of the batch of an idempotent producer (empty id if the producer is not idempotent)
+	producerId := c.Get("x-ministream-producer-id", "")
+	strSequence := c.Get("x-ministream-producer-sequence", "")
+	if producerId == "" && strSequence == "" {
+		return "", 0, nil
+	}
+
+	if !types.IsValidProducerId(producerId) {
+		vErr := apierror.ValidationError{FailedField: "x-ministream-producer-id", Tag: "header", Value: producerId}
+		return "", 0, &apierror.APIError{
+			StreamUUID:       streamUUID,
+			Message:          "invalid producer id",
+			Details:          "producer id must be 1 to 64 characters among a-z, A-Z, 0-9, '_', '.' and '-'",
+			Code:             constants.ErrorInvalidProducer,
+			HttpCode:         fiber.StatusBadRequest,
+			ValidationErrors: []*apierror.ValidationError{&vErr},
+		}
+	}
+
+	sequence, err := strconv.ParseUint(strSequence, 10, 64)
+	if err != nil || sequence == 0 {
+		if err == nil {
+			err = errors.New("the sequence starts from 1")
+		}
+		vErr := apierror.ValidationError{FailedField: "x-ministream-producer-sequence", Tag: "header", Value: strSequence}
+		return "", 0, &apierror.APIError{
+			StreamUUID:       streamUUID,
+			Message:          "invalid producer sequence",
+			Details:          err.Error(),
+			Code:             constants.ErrorInvalidProducer,
+			HttpCode:         fiber.StatusBadRequest,
+			ValidationErrors: []*apierror.ValidationError{&vErr},
+			Err:              err,
+		}
+	}
+
+	return producerId, sequence, nil
+}
+
+func (w *WebAPIServer) checkBatchId(c *fiber.Ctx, streamUUID types.StreamUUID, producerId string) (string, *apierror.APIError) {
+	// Deprecated: the header x-ministream-batch-id deduplicates the batches in memory for a few minutes only
+	// (forgotten when the server restarts), use an idempotent producer instead (x-ministream-producer-id).
+	// The batch id is ignored when the batch is sent by an idempotent producer.
+	// Returns the deduplication id of the batch (empty if there is no batch id)
+	batchId := c.Get("x-ministream-batch-id", "")
+	if batchId == "" || producerId != "" {
+		return "", nil
+	}
+
+	dedupId := fmt.Sprintf("%s:%s", streamUUID.String(), batchId)
+	if w.reqDedupManager.Exists(dedupId) {
+		return "", &apierror.APIError{
+			Message:    "batch id already processed",
+			Details:    fmt.Sprintf("x-ministream-batch-id: %s", batchId),
+			Code:       constants.ErrorDuplicatedBatchId,
+			HttpCode:   fiber.StatusBadRequest,
+			StreamUUID: streamUUID,
+			Err:        nil,
+		}
+	}
+	w.reqDedupManager.Add(dedupId)
+	return dedupId, nil
+}
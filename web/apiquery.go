@@ -0,0 +1,39 @@
+package web
+
+import (
+	"github.com/nbigot/ministream/types"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// QueryStream godoc
+// @Summary Query a stream
+// @Description Scan a range of the stream (message ids or timestamps) and return aggregated results (count, sum, min, max, distinct values, group by key) instead of the records.
+// @Description The scan is bounded by a maximum number of records scanned and a maximum duration, the results are incomplete when a limit is reached.
+// @ID stream-query
+// @Accept json
+// @Produce json
+// @Tags Stream
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param query body types.StreamQueryRequest true "query"
+// @Success 200 {object} stream.QueryStreamResponse "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Success 500 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/query [post]
+func (w *WebAPIServer) QueryStream(c *fiber.Ctx) error {
+	_, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	req := types.StreamQueryRequest{}
+	if apiErr = GetPayload(c, &req); apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	response, apiErr := w.service.QueryStream(c.Context(), streamPtr, &req)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+	return c.JSON(response)
+}
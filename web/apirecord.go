@@ -0,0 +1,153 @@
+package web
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nbigot/ministream/constants"
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetRecord godoc
+// @Summary Get a record
+// @Description Get the record having the given message id, without creating an iterator
+// @ID stream-get-record
+// @Produce json
+// @Tags Stream
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param messageid path int true "Message id" example(1234)
+// @Param partition query int false "partitioned stream only: partition of the record (required)" example(0)
+// @Success 200 {object} interface{} "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Success 404 {object} apierror.APIError
+// @Success 500 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/record/{messageid} [get]
+func (w *WebAPIServer) GetRecord(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	strMessageId := c.Params(constants.ParamNameMessageId)
+	messageId, err := strconv.ParseUint(strMessageId, 10, 64)
+	if err != nil {
+		vErr := apierror.ValidationError{FailedField: constants.ParamNameMessageId, Tag: "parameter", Value: strMessageId}
+		httpError := apierror.APIError{
+			StreamUUID:       streamUUID,
+			Message:          "invalid message id",
+			Details:          err.Error(),
+			Code:             constants.ErrorInvalidParameterValue,
+			HttpCode:         fiber.StatusBadRequest,
+			ValidationErrors: []*apierror.ValidationError{&vErr},
+			Err:              err,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	partition, apiErr := w.GetPartitionFromQuery(c, streamUUID)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	records, apiErr := w.service.GetRecordsByIds(streamPtr, partition, []types.MessageId{messageId})
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+	if records[0] == nil {
+		httpError := apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "record not found",
+			Details:    fmt.Sprintf("message id %d not found", messageId),
+			Code:       constants.ErrorRecordNotFound,
+			HttpCode:   fiber.StatusNotFound,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	return c.JSON(records[0])
+}
+
+// GetRecordsByIds godoc
+// @Summary Get records
+// @Description Get the records having the given message ids, without creating an iterator
+// @ID stream-get-records-by-ids
+// @Produce json
+// @Tags Stream
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param messageIds query string true "comma separated message ids" example(1,2,3)
+// @Param partition query int false "partitioned stream only: partition of the records (required)" example(0)
+// @Success 200 {object} stream.GetRecordsByIdsResponse "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Success 500 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/records [get]
+func (w *WebAPIServer) GetRecordsByIds(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	messageIds, err := parseMessageIds(c.Query("messageIds"), w.appConfig.Streams.MaxMessagePerGetOperation)
+	if err != nil {
+		vErr := apierror.ValidationError{FailedField: "messageIds", Tag: "parameter", Value: c.Query("messageIds")}
+		httpError := apierror.APIError{
+			StreamUUID:       streamUUID,
+			Message:          "invalid message ids",
+			Details:          err.Error(),
+			Code:             constants.ErrorInvalidParameterValue,
+			HttpCode:         fiber.StatusBadRequest,
+			ValidationErrors: []*apierror.ValidationError{&vErr},
+			Err:              err,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	partition, apiErr := w.GetPartitionFromQuery(c, streamUUID)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	records, apiErr := w.service.GetRecordsByIds(streamPtr, partition, messageIds)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	response := stream.GetRecordsByIdsResponse{
+		Status:     "success",
+		StreamUUID: streamUUID,
+		Records:    make([]interface{}, 0, len(records)),
+		NotFound:   []types.MessageId{},
+	}
+	for i, record := range records {
+		if record == nil {
+			response.NotFound = append(response.NotFound, messageIds[i])
+		} else {
+			response.Records = append(response.Records, record)
+		}
+	}
+	response.Count = int64(len(response.Records))
+	return c.JSON(response)
+}
+
+func parseMessageIds(value string, maxMessageIds uint) ([]types.MessageId, error) {
+	if value == "" {
+		return nil, fmt.Errorf("at least one message id is required")
+	}
+	values := strings.Split(value, ",")
+	if uint(len(values)) > maxMessageIds {
+		return nil, fmt.Errorf("number of message ids cannot exceed limit %d", maxMessageIds)
+	}
+	messageIds := make([]types.MessageId, 0, len(values))
+	for _, strMessageId := range values {
+		messageId, err := strconv.ParseUint(strings.TrimSpace(strMessageId), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		messageIds = append(messageIds, messageId)
+	}
+	return messageIds, nil
+}
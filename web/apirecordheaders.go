@@ -0,0 +1,90 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nbigot/ministream/constants"
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HTTP headers prefix of the record headers, ex: "x-ministream-header-trace-id: 1234" sets the record header "trace-id"
+const recordHeaderHTTPPrefix = "x-ministream-header-"
+
+func getRecordHeadersFromRequest(c *fiber.Ctx, streamUUID types.StreamUUID) (types.RecordHeaders, *apierror.APIError) {
+	// returns the record headers given by the HTTP headers of the request (nil if none)
+	var headers types.RecordHeaders
+	c.Request().Header.VisitAll(func(key []byte, value []byte) {
+		name := strings.ToLower(string(key))
+		if strings.HasPrefix(name, recordHeaderHTTPPrefix) {
+			if headers == nil {
+				headers = types.RecordHeaders{}
+			}
+			headers[strings.TrimPrefix(name, recordHeaderHTTPPrefix)] = string(value)
+		}
+	})
+
+	if err := headers.Validate(); err != nil {
+		return nil, errorInvalidRecordHeaders(streamUUID, err)
+	}
+
+	return headers, nil
+}
+
+func isRecordEnvelope(c *fiber.Ctx) bool {
+	// the records are wrapped into an envelope holding their headers: {"h": {"trace-id": "1234"}, "m": {...}}
+	return c.QueryBool("envelope", false)
+}
+
+func getRecordFromEnvelope(envelope interface{}, requestHeaders types.RecordHeaders) (interface{}, types.RecordHeaders, error) {
+	// returns the message and the headers of the record (the headers of the envelope override the request headers)
+	fields, ok := envelope.(map[string]interface{})
+	if !ok {
+		return nil, nil, errors.New("record envelope must be an object")
+	}
+
+	message, found := fields["m"]
+	if !found {
+		return nil, nil, errors.New("record envelope must have a message (m)")
+	}
+
+	rawHeaders, found := fields["h"]
+	if !found || rawHeaders == nil {
+		return message, requestHeaders, nil
+	}
+
+	headersFields, ok := rawHeaders.(map[string]interface{})
+	if !ok {
+		return nil, nil, errors.New("record envelope headers (h) must be an object")
+	}
+	headers := make(types.RecordHeaders, len(headersFields))
+	for name, value := range headersFields {
+		strValue, ok := value.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("record header %s must be a string", name)
+		}
+		headers[name] = strValue
+	}
+	// the request headers are already valid, but the union may have too many headers
+	headers = requestHeaders.Merge(headers)
+	if err := headers.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return message, headers, nil
+}
+
+func errorInvalidRecordHeaders(streamUUID types.StreamUUID, err error) *apierror.APIError {
+	return &apierror.APIError{
+		Message:    "invalid record headers",
+		Details:    err.Error(),
+		Code:       constants.ErrorInvalidRecordHeaders,
+		HttpCode:   fiber.StatusBadRequest,
+		StreamUUID: streamUUID,
+		Err:        err,
+	}
+}
@@ -0,0 +1,105 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestPutRecordsHeaders(t *testing.T) {
+	w, addr := newTestWebAPIServer(t)
+	s := createTestStream(t, w)
+	putRecords := func(path string, body string, headers map[string]string) int {
+		req, err := http.NewRequest(fiber.MethodPut, fmt.Sprintf("http://%s/api/v1/stream/%s/%s", addr, s.GetUUID(), path), strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("error while creating request: %v", err)
+		}
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set("x-ministream-durability", types.DurabilityFlushed)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error while putting records: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// the headers of the envelopes override the headers of the request
+	body := `[{"h": {"trace-id": "1"}, "m": {"n": 1}}, {"m": {"n": 2}}, {"h": {"trace-id": "3", "content-type": "text/plain"}, "m": {"n": 3}}]`
+	if status := putRecords("records?envelope=true", body, map[string]string{"x-ministream-header-content-type": "application/json"}); status != fiber.StatusAccepted {
+		t.Fatalf("expected status %d, got %d", fiber.StatusAccepted, status)
+	}
+
+	// the invalid headers are rejected, the records are not put
+	tooManyHeaders := make([]string, 0, types.MaxRecordHeaders)
+	for i := range types.MaxRecordHeaders {
+		tooManyHeaders = append(tooManyHeaders, fmt.Sprintf(`"h%d": "v"`, i))
+	}
+	invalidRequests := []struct {
+		path    string
+		body    string
+		headers map[string]string
+	}{
+		{"records", `[{"n": 4}]`, map[string]string{"x-ministream-header-trace!id": "4"}},
+		{"record", `{"n": 4}`, map[string]string{"x-ministream-header-trace-id": strings.Repeat("4", types.MaxRecordHeaderValueLength+1)}},
+		{"records?envelope=true", `[{"h": {"trace id": "4"}, "m": {"n": 4}}]`, nil},
+		{"records?envelope=true", `[{"h": {"trace-id": 4}, "m": {"n": 4}}]`, nil},
+		{"records?envelope=true", `[{"h": {"trace-id": "4"}, "m": {"n": 4}}, {"h": "4", "m": {"n": 5}}]`, nil},
+		{"record?envelope=true", `{"h": {"` + strings.Repeat("a", 65) + `": "4"}, "m": {"n": 4}}`, nil},
+		// the union of the headers of the request and of the envelope has too many headers
+		{"records?envelope=true", `[{"h": {` + strings.Join(tooManyHeaders, ", ") + `}, "m": {"n": 4}}]`, map[string]string{"x-ministream-header-trace-id": "4"}},
+	}
+	for _, invalidRequest := range invalidRequests {
+		if status := putRecords(invalidRequest.path, invalidRequest.body, invalidRequest.headers); status != fiber.StatusBadRequest {
+			t.Fatalf("expected status %d for %+v, got %d", fiber.StatusBadRequest, invalidRequest, status)
+		}
+	}
+	if lastMsgId := s.GetLastIngestedMsgId(); lastMsgId != 3 {
+		t.Fatalf("expected the last message id 3, got %d", lastMsgId)
+	}
+
+	// the jq filters of the iterators can select the records by their headers
+	getRecords := func(jqFilter string) []interface{} {
+		itUUID, apiErr := w.service.CreateRecordsIterator(s, &types.StreamIteratorRequest{IteratorType: "FIRST_MESSAGE", JqFilter: jqFilter})
+		if apiErr != nil {
+			t.Fatalf("error while creating iterator: %v", apiErr)
+		}
+		resp, err := http.Get(fmt.Sprintf("http://%s/api/v1/stream/%s/iterator/%s/records", addr, s.GetUUID(), itUUID))
+		if err != nil {
+			t.Fatalf("error while getting records: %v", err)
+		}
+		defer resp.Body.Close()
+		response := stream.GetStreamRecordsResponse{}
+		if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatalf("error while decoding response: %v", err)
+		}
+		return response.Records
+	}
+	records := getRecords(`select(.h["content-type"] == "application/json")`)
+	if len(records) != 2 {
+		t.Fatalf("expected the records 1 and 2, got %v", records)
+	}
+	for i, expected := range []map[string]interface{}{
+		{"content-type": "application/json", "trace-id": "1"},
+		{"content-type": "application/json"},
+	} {
+		headers := records[i].(map[string]interface{})["h"].(map[string]interface{})
+		if fmt.Sprint(headers) != fmt.Sprint(expected) {
+			t.Fatalf("expected headers %v, got %v", expected, headers)
+		}
+	}
+	records = getRecords(`select(.h["trace-id"] == "3") | .m`)
+	if len(records) != 1 || records[0].(map[string]interface{})["n"] != float64(3) {
+		t.Fatalf("expected the message 3, got %v", records)
+	}
+}
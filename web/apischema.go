@@ -0,0 +1,307 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nbigot/ministream/account"
+	"github.com/nbigot/ministream/constants"
+	"github.com/nbigot/ministream/log"
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type RegisterStreamSchemaPayload struct {
+	Schema map[string]interface{} `json:"schema" validate:"required"`
+}
+
+// ListStreamSchemas godoc
+// @Summary List the schema versions of a stream
+// @Description Get the registered versions of the JSON Schema of the records and the compatibility mode of the stream
+// @ID stream-list-schemas
+// @Accept json
+// @Produce json
+// @Tags Schema
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Success 200 {object} stream.ListStreamSchemasResponse "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/schemas [get]
+func (w *WebAPIServer) ListStreamSchemas(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	compatibility, err := streamPtr.GetSchemaCompatibility()
+	if err != nil {
+		httpError := apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "invalid schema compatibility",
+			Details:    err.Error(),
+			Code:       constants.ErrorInvalidParameterValue,
+			HttpCode:   fiber.StatusBadRequest,
+			Err:        err,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	response := stream.ListStreamSchemasResponse{
+		Status:        "success",
+		StreamUUID:    streamUUID,
+		Compatibility: compatibility,
+		Schemas:       streamPtr.GetSchemas(),
+	}
+	return c.JSON(response)
+}
+
+// GetStreamSchema godoc
+// @Summary Get a schema version of a stream
+// @Description Get a version of the JSON Schema of the records ("latest" for the latest version)
+// @ID stream-get-schema
+// @Accept json
+// @Produce json
+// @Tags Schema
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param version path string true "Schema version or latest" example(latest)
+// @Success 200 {object} stream.StreamSchemaResponse "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Success 404 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/schema/{version} [get]
+func (w *WebAPIServer) GetStreamSchema(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	strVersion := c.Params(constants.ParamNameSchemaVersion)
+	version := 0
+	if strVersion != "latest" {
+		var apiErr *apierror.APIError
+		if version, apiErr = parseSchemaVersion(streamUUID, constants.ParamNameSchemaVersion, strVersion); apiErr != nil {
+			return apiErr.HTTPResponse(c)
+		}
+	}
+
+	schema, found := streamPtr.GetSchema(version)
+	if !found {
+		httpError := apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "schema not found",
+			Details:    strVersion,
+			Code:       constants.ErrorStreamSchemaNotFound,
+			HttpCode:   fiber.StatusNotFound,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	response := stream.StreamSchemaResponse{
+		Status:     "success",
+		Message:    "",
+		StreamUUID: streamUUID,
+		Schema:     schema,
+	}
+	return c.JSON(response)
+}
+
+// RegisterStreamSchema godoc
+// @Summary Register a schema version
+// @Description Register a new version of the JSON Schema of the records, it must be compatible with the latest version
+// @Description according to the compatibility mode of the stream (stream property "schema.compatibility": NONE, BACKWARD, FORWARD or FULL).
+// @Description The records put into the stream are then validated against the latest version.
+// @Description Registering the same schema as the latest version does not create a new version.
+// @ID stream-register-schema
+// @Accept json
+// @Produce json
+// @Tags Schema
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param payload body RegisterStreamSchemaPayload true "RegisterStreamSchemaPayload" Format(RegisterStreamSchemaPayload)
+// @Success 200 {object} stream.StreamSchemaResponse "schema already registered"
+// @Success 201 {object} stream.StreamSchemaResponse "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Success 409 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/schemas [post]
+func (w *WebAPIServer) RegisterStreamSchema(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	var payload RegisterStreamSchemaPayload
+	if apiErr = GetPayload(c, &payload); apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	schema, created, err := w.service.RegisterStreamSchema(streamPtr, payload.Schema)
+	if err != nil {
+		var incompatibleErr *stream.IncompatibleSchemaError
+		if errors.As(err, &incompatibleErr) {
+			validationErrors := make([]*apierror.ValidationError, len(incompatibleErr.Reasons))
+			for i, reason := range incompatibleErr.Reasons {
+				validationErrors[i] = &apierror.ValidationError{FailedField: "schema", Tag: incompatibleErr.Compatibility, Value: reason}
+			}
+			httpError := apierror.APIError{
+				StreamUUID:       streamUUID,
+				Message:          "incompatible schema",
+				Details:          fmt.Sprintf("schema is not %s compatible with the latest version", incompatibleErr.Compatibility),
+				Code:             constants.ErrorIncompatibleStreamSchema,
+				HttpCode:         fiber.StatusConflict,
+				ValidationErrors: validationErrors,
+				Err:              err,
+			}
+			return httpError.HTTPResponse(c)
+		}
+		httpError := apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "cannot register schema",
+			Details:    err.Error(),
+			Code:       constants.ErrorCantRegisterStreamSchema,
+			HttpCode:   fiber.StatusBadRequest,
+			Err:        err,
+		}
+		if errors.Is(err, stream.ErrInvalidStreamSchema) {
+			httpError.Code = constants.ErrorInvalidStreamSchema
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	response := stream.StreamSchemaResponse{
+		Status:     "success",
+		Message:    "schema already registered",
+		StreamUUID: streamUUID,
+		Schema:     schema,
+	}
+	if !created {
+		return c.JSON(response)
+	}
+
+	account := account.AccountMgr.GetAccount()
+	log.Logger.Info(
+		"Schema registered",
+		zap.String("topic", "stream"),
+		zap.String("method", "RegisterStreamSchema"),
+		zap.String("accountId", account.Id.String()),
+		zap.String("ipAddress", c.IP()),
+		zap.String("ipAddresses", strings.Join(c.IPs(), ";")),
+		zap.String("streamUUID", streamUUID.String()),
+		zap.Int("schemaVersion", schema.Version),
+	)
+
+	response.Message = "schema registered"
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// DeleteStreamSchemas godoc
+// @Summary Delete the schema versions of a stream
+// @Description Delete all the versions of the JSON Schema of the records, the records are no longer validated
+// @ID stream-delete-schemas
+// @Accept json
+// @Produce json
+// @Tags Schema
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @success 200 {object} web.JSONResultSuccess{} "successful operation"
+// @Success 400 {object} apierror.APIError
+// @Success 404 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/schemas [delete]
+func (w *WebAPIServer) DeleteStreamSchemas(c *fiber.Ctx) error {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	if err := w.service.DeleteStreamSchemas(streamPtr); err != nil {
+		httpError := apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "cannot delete schemas",
+			Details:    err.Error(),
+			Code:       constants.ErrorCantDeleteStreamSchemas,
+			HttpCode:   fiber.StatusBadRequest,
+			Err:        err,
+		}
+		if errors.Is(err, stream.ErrStreamSchemaNotFound) {
+			httpError.Code = constants.ErrorStreamSchemaNotFound
+			httpError.HttpCode = fiber.StatusNotFound
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	account := account.AccountMgr.GetAccount()
+	log.Logger.Info(
+		"Schemas deleted",
+		zap.String("topic", "stream"),
+		zap.String("method", "DeleteStreamSchemas"),
+		zap.String("accountId", account.Id.String()),
+		zap.String("ipAddress", c.IP()),
+		zap.String("ipAddresses", strings.Join(c.IPs(), ";")),
+		zap.String("streamUUID", streamUUID.String()),
+	)
+
+	return c.JSON(
+		JSONResultSuccess{
+			Code:    fiber.StatusOK,
+			Message: "success",
+		},
+	)
+}
+
+func validateRecordsSchema(c *fiber.Ctx, streamPtr *stream.Stream, records []interface{}, headers []types.RecordHeaders) (int, *apierror.APIError) {
+	// Validate the records against the schema of the stream (if any), returns the version of the schema used.
+	// The invalid records are sent to the dead-letter stream of the stream (if any).
+	// The producer can ask for a given version with the x-ministream-schema-version header (latest version by default).
+	streamUUID := streamPtr.GetUUID()
+	version := 0
+	if strVersion := c.Get("x-ministream-schema-version"); strVersion != "" {
+		var apiErr *apierror.APIError
+		if version, apiErr = parseSchemaVersion(streamUUID, "x-ministream-schema-version", strVersion); apiErr != nil {
+			return 0, apiErr
+		}
+	}
+
+	version, validationErrors, err := streamPtr.ValidateRecords(c.Context(), version, records, headers)
+	if err != nil {
+		return 0, &apierror.APIError{
+			StreamUUID: streamUUID,
+			Message:    "schema not found",
+			Details:    err.Error(),
+			Code:       constants.ErrorStreamSchemaNotFound,
+			HttpCode:   fiber.StatusBadRequest,
+			Err:        err,
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		return version, &apierror.APIError{
+			StreamUUID:       streamUUID,
+			Message:          "records do not match the schema",
+			Details:          fmt.Sprintf("schema version %d", version),
+			Code:             constants.ErrorRecordsDoNotMatchSchema,
+			HttpCode:         fiber.StatusBadRequest,
+			ValidationErrors: validationErrors,
+		}
+	}
+
+	return version, nil
+}
+
+func parseSchemaVersion(streamUUID types.StreamUUID, paramName string, strVersion string) (int, *apierror.APIError) {
+	version, err := strconv.Atoi(strVersion)
+	if err != nil || version <= 0 {
+		vErr := apierror.ValidationError{FailedField: paramName, Tag: "parameter", Value: strVersion}
+		return 0, &apierror.APIError{
+			StreamUUID:       streamUUID,
+			Message:          "invalid schema version",
+			Details:          "schema version must be a strictly positive integer",
+			Code:             constants.ErrorInvalidParameterValue,
+			HttpCode:         fiber.StatusBadRequest,
+			ValidationErrors: []*apierror.ValidationError{&vErr},
+			Err:              err,
+		}
+	}
+
+	return version, nil
+}
@@ -3,6 +3,7 @@ package web
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -17,6 +18,7 @@ import (
 	"github.com/nbigot/ministream/types"
 	"github.com/nbigot/ministream/web/apierror"
 
+	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/utils"
 	"github.com/google/uuid"
@@ -24,6 +26,10 @@ import (
 	"go.uber.org/zap"
 )
 
+// A whitespace is sent to the client waiting for records (long polling) every period,
+// therefore a client gone is detected and the wait ends
+var longPollingHeartbeatPeriod = time.Second
+
 // ListStreams godoc
 // @Summary List streams
 // @Description Get the list of all streams UUIDs
@@ -115,7 +121,13 @@ func (w *WebAPIServer) ListStreamsProperties(c *fiber.Ctx) error {
 
 // CreateStream godoc
 // @Summary Create a stream
-// @Description Create a new stream
+// @Description Create a new stream, a partitioned stream is created when the partitions count is greater than 0.
+// @Description The records put into a partitioned stream having the same partition key (header x-ministream-partition-key) go to the same partition.
+// @Description A derived stream is created when the property "derived.source" holds the UUID of a source stream:
+// @Description the server appends the records of the source stream transformed by the jq program of the property "derived.jq"
+// @Description (with the variables $headers and $stream, no output skips the record), ex: "select(.level == \"error\") | {msg}".
+// @Description The position of a derived stream is tracked by the consumer group "derived.{uuid}" of the source stream,
+// @Description the definition of a derived stream cannot be changed and the records cannot be put by the clients.
 // @ID stream-create
 // @Accept json
 // @Produce json
@@ -125,14 +137,15 @@ func (w *WebAPIServer) ListStreamsProperties(c *fiber.Ctx) error {
 // @Router /api/v1/stream/ [post]
 func (w *WebAPIServer) CreateStream(c *fiber.Ctx) error {
 	payload := struct {
-		Properties map[string]string `json:"properties" validate:"required,lte=32,dive,keys,gt=0,lte=64,endkeys,max=128,required"`
+		Properties map[string]string `json:"properties" validate:"required,lte=32,dive,keys,gt=0,lte=64,endkeys,max=256,required"`
+		Partitions int               `json:"partitions" validate:"gte=0,lte=256"`
 	}{}
 
 	if apiErr := GetPayload(c, &payload); apiErr != nil {
 		return apiErr.HTTPResponse(c)
 	}
 
-	s, err := w.service.CreateStream(convertToProperties(payload.Properties))
+	s, err := w.service.CreatePartitionedStream(convertToProperties(payload.Properties), payload.Partitions)
 	if err != nil {
 		httpError := apierror.APIError{
 			Message:  "cannot create stream",
@@ -160,7 +173,11 @@ func (w *WebAPIServer) CreateStream(c *fiber.Ctx) error {
 
 // SetStreamProperties godoc
 // @Summary Set stream properties
-// @Description Set and replace properties for the given stream
+// @Description Set and replace properties for the given stream.
+// @Description The properties "ingest.pipeline.1", "ingest.pipeline.2"... hold the jq programs run on each record before it is saved (see PutRecords).
+// @Description The property "deadletter.stream" holds the UUID of the stream receiving the records that could not be saved (see PutRecords).
+// @Description The properties "backpressure.mode" (block, reject or shed) and "backpressure.maxWait" apply when the ingest buffer of the stream is full (see PutRecords).
+// @Description The property "durability.level" (none, flushed or fsynced) tells when the records put are acknowledged (see PutRecords).
 // @ID stream-set-properties
 // @Accept json
 // @Produce json
@@ -183,13 +200,19 @@ func (w *WebAPIServer) SetStreamProperties(c *fiber.Ctx) error {
 		return apiErr2.HTTPResponse(c)
 	}
 
-	streamPtr.SetProperties(convertToProperties(payload.Properties))
+	if err := streamPtr.SetProperties(convertToProperties(payload.Properties)); err != nil {
+		return errorInvalidStreamProperties(streamPtr.GetUUID(), err).HTTPResponse(c)
+	}
 	return c.JSON(streamPtr.GetProperties())
 }
 
 // UpdateStreamProperties godoc
 // @Summary Update stream properties
-// @Description update properties for the given stream
+// @Description update properties for the given stream.
+// @Description The properties "ingest.pipeline.1", "ingest.pipeline.2"... hold the jq programs run on each record before it is saved (see PutRecords).
+// @Description The property "deadletter.stream" holds the UUID of the stream receiving the records that could not be saved (see PutRecords).
+// @Description The properties "backpressure.mode" (block, reject or shed) and "backpressure.maxWait" apply when the ingest buffer of the stream is full (see PutRecords).
+// @Description The property "durability.level" (none, flushed or fsynced) tells when the records put are acknowledged (see PutRecords).
 // @ID stream-update-properties
 // @Accept json
 // @Produce json
@@ -212,7 +235,9 @@ func (w *WebAPIServer) UpdateStreamProperties(c *fiber.Ctx) error {
 		return apiErr2.HTTPResponse(c)
 	}
 
-	streamPtr.UpdateProperties(convertToProperties(payload.Properties))
+	if err := streamPtr.UpdateProperties(convertToProperties(payload.Properties)); err != nil {
+		return errorInvalidStreamProperties(streamPtr.GetUUID(), err).HTTPResponse(c)
+	}
 	return c.JSON(streamPtr.GetProperties())
 }
 
@@ -413,6 +438,12 @@ func (w *WebAPIServer) GetRecordsIteratorStats(c *fiber.Ctx) error {
 		StreamIteratorUUID: streamIteratorUuid,
 		LastRecordIdRead:   it.LastRecordIdRead,
 		Name:               it.GetName(),
+		ConsumerGroup:      it.GetConsumerGroup(),
+		CreationDate:       it.GetCreationDate(),
+		LastAccess:         it.GetLastAccess(),
+	}
+	if expiresAt := it.GetExpiresAt(); !expiresAt.IsZero() {
+		response.ExpiresAt = &expiresAt
 	}
 	return c.JSON(response)
 }
@@ -483,7 +514,9 @@ func (w *WebAPIServer) CloseRecordsIterator(c *fiber.Ctx) error {
 
 // GetRecords godoc
 // @Summary Get stream records
-// @Description Get records for the given stream UUID
+// @Description Get records for the given stream UUID.
+// @Description With long polling (maxWaitTimeSeconds of the iterator), when no record is readable the request waits until records are readable,
+// @Description the wait time elapses or the client disconnects: the response is then streamed, whitespaces are sent before the json body while waiting.
 // @ID stream-get-records
 // @Accept json
 // @Produce json
@@ -520,66 +553,120 @@ func (w *WebAPIServer) GetRecords(c *fiber.Ctx) error {
 		return httpError.HTTPResponse(c)
 	}
 
-	var maxRecords = w.appConfig.Streams.MaxMessagePerGetOperation
-	strMaxRecords := c.Query("maxRecords")
-	if strMaxRecords != "" {
-		var maxRecordsRequested uint64
-		maxRecordsRequested, err = strconv.ParseUint(strMaxRecords, 10, 0)
-		if err == nil {
-			switch v := maxRecordsRequested; {
-			case v == 0:
-				err = errors.New("value must be positive")
-			case v > uint64(maxRecords):
-				err = fmt.Errorf("value must cannot exceed limit %d", maxRecords)
-			}
-		}
-		if err != nil {
-			vErr := apierror.ValidationError{FailedField: "maxRecords", Tag: "parameter", Value: strMaxRecords}
-			httpError := apierror.APIError{
-				StreamUUID:       streamUUID,
-				Message:          "invalid integer value",
-				Details:          err.Error(),
-				Code:             constants.ErrorInvalidParameterValue,
-				HttpCode:         fiber.StatusBadRequest,
-				ValidationErrors: []*apierror.ValidationError{&vErr},
-				Err:              err,
-			}
-			return httpError.HTTPResponse(c)
-		}
-		maxRecords = uint(maxRecordsRequested)
+	maxRecords, apiErr := w.GetMaxRecordsFromQuery(c, streamUUID)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
 	}
 
-	response, err2 := streamPtr.GetRecords(c.Context(), iteratorUuid, maxRecords)
+	// the records readable right now are returned at once,
+	// the request waits for new records only if there are none (long polling)
+	response, err2 := streamPtr.GetRecordsWithoutWait(c.Context(), iteratorUuid, maxRecords)
 	if err2 != nil {
-		// check if err2 is an apieror.APIError
-		if apiErr, ok := err2.(*apierror.APIError); ok {
-			return apiErr.HTTPResponse(c)
-		}
+		return errorGetRecords(streamUUID, err2).HTTPResponse(c)
+	}
 
-		httpError := apierror.APIError{
-			StreamUUID: streamUUID,
-			Message:    "cannot get records",
-			Details:    err2.Error(),
-			Code:       constants.ErrorCantGetMessagesFromStream,
-			HttpCode:   fiber.StatusInternalServerError,
-			Err:        err2,
+	if response.Count == 0 && !response.Ended {
+		if it, err := streamPtr.GetIterator(iteratorUuid); err == nil && it.GetMaxWaitTime() > 0 {
+			return w.waitForRecords(c, streamPtr, iteratorUuid, maxRecords)
 		}
-		return httpError.HTTPResponse(c)
 	}
 
 	return c.JSON(response)
 }
 
+func (w *WebAPIServer) waitForRecords(c *fiber.Ctx, streamPtr *stream.Stream, iteratorUUID types.StreamIteratorUUID, maxRecords uint) error {
+	// The long polling response is streamed: the fasthttp context is done only when the server shuts down,
+	// a client gone is detected when a heartbeat (a whitespace, ignored by json decoders) can't be sent,
+	// then the wait ends at once and the iterator is released.
+	// The status is sent before the records are read: an error is reported by the json body only.
+	serverDone := c.Context().Done()
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Context().SetBodyStreamWriter(func(writer *bufio.Writer) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		type result struct {
+			response *stream.GetStreamRecordsResponse
+			err      error
+		}
+		done := make(chan result, 1)
+		go func() {
+			response, err := streamPtr.GetRecords(ctx, iteratorUUID, maxRecords)
+			done <- result{response: response, err: err}
+		}()
+
+		heartbeat := time.NewTicker(longPollingHeartbeatPeriod)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case r := <-done:
+				var body interface{} = r.response
+				if r.err != nil {
+					body = errorGetRecords(streamPtr.GetUUID(), r.err)
+				}
+				if data, err := json.Marshal(body); err == nil {
+					_, _ = writer.Write(data)
+					_ = writer.Flush()
+				}
+				return
+			case <-heartbeat.C:
+				_ = writer.WriteByte(' ')
+				if err := writer.Flush(); err != nil {
+					// the client has disconnected
+					cancel()
+					heartbeat.Stop()
+				}
+			case <-serverDone:
+				cancel()
+				serverDone = nil
+			}
+		}
+	})
+
+	return nil
+}
+
+func errorGetRecords(streamUUID types.StreamUUID, err error) *apierror.APIError {
+	if apiErr, ok := err.(*apierror.APIError); ok {
+		return apiErr
+	}
+
+	return &apierror.APIError{
+		StreamUUID: streamUUID,
+		Message:    "cannot get records",
+		Details:    err.Error(),
+		Code:       constants.ErrorCantGetMessagesFromStream,
+		HttpCode:   fiber.StatusInternalServerError,
+		Err:        err,
+	}
+}
+
 // PutRecord godoc
 // @Summary Put one record into a stream
-// @Description Put a single record into a stream
+// @Description Put a single record into a stream, see "Putting records" in the README for the ingest of the records
+// @Description (ingest pipeline, dead-letter stream, idempotent producer, backpressure and durability).
 // @ID stream-put-record
 // @Accept json
 // @Produce json
 // @Tags Stream
 // @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
-// @Success 202 {object} stream.PutStreamRecordsResponse "successful operation"
+// @Param x-ministream-partition-key header string false "partition key (partitioned stream only)"
+// @Param x-ministream-header-{name} header string false "record header {name}, ex: x-ministream-header-trace-id"
+// @Param envelope query bool false "the body holds the message (m) and the headers (h) of each record: {\"h\": {\"trace-id\": \"1234\"}, \"m\": {...}}"
+// @Param x-ministream-schema-version header int false "validate the records against this version of the schema of the stream (latest version by default)"
+// @Param x-ministream-durability header string false "acknowledge the records once persisted: none, flushed or fsynced (durability level of the stream by default)"
+// @Param x-ministream-producer-id header string false "id of the idempotent producer (requires x-ministream-producer-sequence)"
+// @Param x-ministream-producer-sequence header int false "sequence of the batch of the idempotent producer, increased by one for each batch starting from 1"
+// @Param x-ministream-batch-id header string false "deprecated (use an idempotent producer): a batch id already put within the last 5 minutes is rejected, ignored with x-ministream-producer-id"
+// @Success 200 {object} stream.PutStreamRecordsResponse "batch of the idempotent producer already put (duplicate), returns the original message ids"
+// @Success 202 {object} stream.PutStreamRecordsResponse "successful operation, the records are persisted according to the durability of the response"
 // @Success 400 {object} apierror.APIError
+// @Success 409 {object} apierror.APIError "sequence of the idempotent producer out of order (gap) or too old to be deduplicated"
+// @Success 413 {object} apierror.APIError "batch larger than the ingest buffer of the stream (backpressure modes block and reject)"
+// @Success 429 {object} apierror.APIError "ingest buffer of the stream full (backpressure mode reject), retry after the Retry-After header"
+// @Success 503 {object} apierror.APIError "timeout while waiting for room in the ingest buffer of the stream (backpressure mode block), retry after the Retry-After header"
+// @Success 504 {object} apierror.APIError "timeout while waiting for the records to be persisted (durability levels flushed and fsynced)"
 // @Success 500 {object} apierror.APIError
 // @Router /api/v1/stream/{streamuuid}/record [put]
 func (w *WebAPIServer) PutRecord(c *fiber.Ctx) error {
@@ -591,22 +678,8 @@ func (w *WebAPIServer) PutRecord(c *fiber.Ctx) error {
 		return apiErr.HTTPResponse(c)
 	}
 
-	// check batch id for deduplication
-	batch_id := c.Get("x-ministream-batch-id", "")
-	if batch_id != "" {
-		dedup_id := fmt.Sprintf("%s:%s", streamPtr.GetUUID().String(), batch_id)
-		if w.reqDedupManager.Exists(dedup_id) {
-			httpError := apierror.APIError{
-				Message:    "batch id already processed",
-				Details:    fmt.Sprintf("x-ministream-batch-id: %s", batch_id),
-				Code:       constants.ErrorDuplicatedBatchId,
-				HttpCode:   fiber.StatusBadRequest,
-				StreamUUID: streamPtr.GetUUID(),
-				Err:        nil,
-			}
-			return httpError.HTTPResponse(c)
-		}
-		w.reqDedupManager.Add(dedup_id)
+	if streamPtr.IsDerived() {
+		return errorPutRecordsIntoDerivedStream(streamPtr.GetUUID()).HTTPResponse(c)
 	}
 
 	if err := c.BodyParser(&payload); err != nil {
@@ -620,38 +693,106 @@ func (w *WebAPIServer) PutRecord(c *fiber.Ctx) error {
 		return httpError.HTTPResponse(c)
 	}
 
-	singleMessageId, err2 := streamPtr.PutMessage(c.Context(), payload)
-	if err2 != nil {
-		httpError := apierror.APIError{
-			Message:  "invalid json body format",
-			Details:  err2.Error(),
-			Code:     constants.ErrorCantPutMessageIntoStream,
-			HttpCode: fiber.StatusInternalServerError,
-			Err:      err2,
+	headers, apiErr := getRecordHeadersFromRequest(c, streamPtr.GetUUID())
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	message := payload
+	if isRecordEnvelope(c) {
+		envelopeMessage, envelopeHeaders, err := getRecordFromEnvelope(payload, headers)
+		if err != nil {
+			return errorInvalidRecordHeaders(streamPtr.GetUUID(), err).HTTPResponse(c)
 		}
-		return httpError.HTTPResponse(c)
+		var ok bool
+		if message, ok = envelopeMessage.(map[string]interface{}); !ok {
+			return errorInvalidRecordHeaders(streamPtr.GetUUID(), errors.New("record envelope message (m) must be an object")).HTTPResponse(c)
+		}
+		headers = envelopeHeaders
+	}
+
+	producerId, sequence, apiErr := getProducerFromRequest(c, streamPtr.GetUUID())
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+	durability, apiErr := getDurabilityFromRequest(c, streamPtr)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+	dedupId, apiErr := w.checkBatchId(c, streamPtr.GetUUID(), producerId)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	// the batch of an idempotent producer is validated and put only if it has not already been put
+	var schemaVersion int
+	var report *stream.IngestPipelineReport
+	batch, duplicate, err2 := w.service.PutIdempotent(c.Context(), streamPtr, producerId, sequence, func() (*types.ProducerBatch, error) {
+		var apiErr *apierror.APIError
+		if schemaVersion, apiErr = validateRecordsSchema(c, streamPtr, []interface{}{message}, []types.RecordHeaders{headers}); apiErr != nil {
+			return nil, apiErr
+		}
+
+		targetStreamPtr, partition := getPartitionFromHeader(c, streamPtr)
+		singleMessageId, pipelineReport, err := targetStreamPtr.PutMessage(c.Context(), message, headers)
+		if err != nil {
+			return nil, err
+		}
+		report = pipelineReport
+		return &types.ProducerBatch{MessageIds: []types.MessageId{singleMessageId}, Partition: partition}, nil
+	})
+	if err2 != nil {
+		w.reqDedupManager.Remove(dedupId)
+		return w.sendPutRecordsError(c, streamPtr.GetUUID(), err2, constants.ErrorCantPutMessageIntoStream)
+	}
+
+	// the records put are acknowledged once persisted according to the durability level
+	if apiErr = waitForDurability(c, streamPtr, durability, batch); apiErr != nil {
+		return apiErr.HTTPResponse(c)
 	}
 
 	response := stream.PutStreamRecordsResponse{
-		Status:     "success",
-		StreamUUID: streamPtr.GetUUID(),
-		Duration:   time.Since(startTime).Milliseconds(),
-		Count:      1,
-		MessageIds: []types.MessageId{singleMessageId},
+		Status:        "success",
+		StreamUUID:    streamPtr.GetUUID(),
+		Duration:      time.Since(startTime).Milliseconds(),
+		Count:         batch.CountPut(),
+		MessageIds:    batch.MessageIds,
+		Partition:     batch.Partition,
+		SchemaVersion: schemaVersion,
+		Durability:    durability,
 	}
+	if duplicate {
+		response.SetDuplicateProducerBatch(batch)
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+	response.SetIngestPipelineReport(report)
 	return c.Status(fiber.StatusAccepted).JSON(response)
 }
 
 // PutRecords godoc
 // @Summary Put one or multiple records into a stream
-// @Description Put one or multiple records into a stream
+// @Description Put one or multiple records into a stream, the records are ingested as by PUT /api/v1/stream/{streamuuid}/record.
 // @ID stream-put-records
 // @Accept json
 // @Produce json
 // @Tags Stream
 // @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
-// @Success 202 {object} stream.PutStreamRecordsResponse "successful operation"
+// @Param x-ministream-partition-key header string false "partition key (partitioned stream only)"
+// @Param x-ministream-header-{name} header string false "record header {name}, ex: x-ministream-header-trace-id"
+// @Param envelope query bool false "the body holds the message (m) and the headers (h) of each record: {\"h\": {\"trace-id\": \"1234\"}, \"m\": {...}}"
+// @Param x-ministream-schema-version header int false "validate the records against this version of the schema of the stream (latest version by default)"
+// @Param x-ministream-durability header string false "acknowledge the records once persisted: none, flushed or fsynced (durability level of the stream by default)"
+// @Param x-ministream-producer-id header string false "id of the idempotent producer (requires x-ministream-producer-sequence)"
+// @Param x-ministream-producer-sequence header int false "sequence of the batch of the idempotent producer, increased by one for each batch starting from 1"
+// @Param x-ministream-batch-id header string false "deprecated (use an idempotent producer): a batch id already put within the last 5 minutes is rejected, ignored with x-ministream-producer-id"
+// @Success 200 {object} stream.PutStreamRecordsResponse "batch of the idempotent producer already put (duplicate), returns the original message ids"
+// @Success 202 {object} stream.PutStreamRecordsResponse "successful operation, the records are persisted according to the durability of the response"
 // @Success 400 {object} apierror.APIError
+// @Success 409 {object} apierror.APIError "sequence of the idempotent producer out of order (gap) or too old to be deduplicated"
+// @Success 413 {object} apierror.APIError "batch larger than the ingest buffer of the stream (backpressure modes block and reject)"
+// @Success 429 {object} apierror.APIError "ingest buffer of the stream full (backpressure mode reject), retry after the Retry-After header"
+// @Success 503 {object} apierror.APIError "timeout while waiting for room in the ingest buffer of the stream (backpressure mode block), retry after the Retry-After header"
+// @Success 504 {object} apierror.APIError "timeout while waiting for the records to be persisted (durability levels flushed and fsynced)"
 // @Success 500 {object} apierror.APIError
 // @Router /api/v1/stream/{streamuuid}/records [put]
 func (w *WebAPIServer) PutRecords(c *fiber.Ctx) error {
@@ -664,22 +805,8 @@ func (w *WebAPIServer) PutRecords(c *fiber.Ctx) error {
 		return apiErr.HTTPResponse(c)
 	}
 
-	// check batch id for batch deduplication
-	batch_id := c.Get("x-ministream-batch-id", "")
-	dedup_id := fmt.Sprintf("%s:%s", streamPtr.GetUUID().String(), batch_id)
-	if batch_id != "" {
-		if w.reqDedupManager.Exists(dedup_id) {
-			httpError := apierror.APIError{
-				Message:    "batch id already processed",
-				Details:    fmt.Sprintf("x-ministream-batch-id: %s", batch_id),
-				Code:       constants.ErrorDuplicatedBatchId,
-				HttpCode:   fiber.StatusBadRequest,
-				StreamUUID: streamPtr.GetUUID(),
-				Err:        nil,
-			}
-			return httpError.HTTPResponse(c)
-		}
-		w.reqDedupManager.Add(dedup_id)
+	if streamPtr.IsDerived() {
+		return errorPutRecordsIntoDerivedStream(streamPtr.GetUUID()).HTTPResponse(c)
 	}
 
 	// jsonlines (without [])
@@ -703,7 +830,6 @@ func (w *WebAPIServer) PutRecords(c *fiber.Ctx) error {
 
 		jsonBuffer = append(jsonBuffer, []byte("]")...)
 		if err = c.App().Config().JSONDecoder(jsonBuffer, &payload); err != nil {
-			w.reqDedupManager.Remove(dedup_id)
 			httpError := apierror.APIError{
 				Message:    "invalid jsonlines body format",
 				Details:    err.Error(),
@@ -717,7 +843,6 @@ func (w *WebAPIServer) PutRecords(c *fiber.Ctx) error {
 	} else {
 		// standard json array (with [])
 		if err = c.BodyParser(&payload); err != nil {
-			w.reqDedupManager.Remove(dedup_id)
 			httpError := apierror.APIError{
 				Message:    "invalid json body format",
 				Details:    err.Error(),
@@ -730,29 +855,93 @@ func (w *WebAPIServer) PutRecords(c *fiber.Ctx) error {
 		}
 	}
 
-	messageIds, err2 := streamPtr.PutMessages(c.Context(), payload)
-	if err2 != nil {
-		w.reqDedupManager.Remove(dedup_id)
-		httpError := apierror.APIError{
-			Message:  "invalid json body format",
-			Details:  err2.Error(),
-			Code:     constants.ErrorCantPutMessagesIntoStream,
-			HttpCode: fiber.StatusInternalServerError,
-			Err:      err2,
+	requestHeaders, apiErr := getRecordHeadersFromRequest(c, streamPtr.GetUUID())
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	var headers []types.RecordHeaders
+	if isRecordEnvelope(c) {
+		headers = make([]types.RecordHeaders, len(payload))
+		for i, envelope := range payload {
+			if payload[i], headers[i], err = getRecordFromEnvelope(envelope, requestHeaders); err != nil {
+				return errorInvalidRecordHeaders(streamPtr.GetUUID(), fmt.Errorf("record %d: %w", i, err)).HTTPResponse(c)
+			}
+		}
+	} else if requestHeaders != nil {
+		headers = make([]types.RecordHeaders, len(payload))
+		for i := range payload {
+			headers[i] = requestHeaders
 		}
-		return httpError.HTTPResponse(c)
+	}
+
+	producerId, sequence, apiErr := getProducerFromRequest(c, streamPtr.GetUUID())
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+	durability, apiErr := getDurabilityFromRequest(c, streamPtr)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+	dedupId, apiErr := w.checkBatchId(c, streamPtr.GetUUID(), producerId)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	// the batch of an idempotent producer is validated and put only if it has not already been put
+	var schemaVersion int
+	var report *stream.IngestPipelineReport
+	batch, duplicate, err2 := w.service.PutIdempotent(c.Context(), streamPtr, producerId, sequence, func() (*types.ProducerBatch, error) {
+		var apiErr *apierror.APIError
+		if schemaVersion, apiErr = validateRecordsSchema(c, streamPtr, payload, headers); apiErr != nil {
+			return nil, apiErr
+		}
+
+		targetStreamPtr, partition := getPartitionFromHeader(c, streamPtr)
+		messageIds, pipelineReport, err := targetStreamPtr.PutMessages(c.Context(), payload, headers)
+		if err != nil {
+			return nil, err
+		}
+		report = pipelineReport
+		return &types.ProducerBatch{MessageIds: messageIds, Partition: partition}, nil
+	})
+	if err2 != nil {
+		w.reqDedupManager.Remove(dedupId)
+		return w.sendPutRecordsError(c, streamPtr.GetUUID(), err2, constants.ErrorCantPutMessagesIntoStream)
+	}
+
+	// the records put are acknowledged once persisted according to the durability level
+	if apiErr = waitForDurability(c, streamPtr, durability, batch); apiErr != nil {
+		return apiErr.HTTPResponse(c)
 	}
 
 	response := stream.PutStreamRecordsResponse{
-		Status:     "success",
-		StreamUUID: streamPtr.GetUUID(),
-		Duration:   time.Since(startTime).Milliseconds(),
-		Count:      int64(len(payload)),
-		MessageIds: messageIds,
+		Status:        "success",
+		StreamUUID:    streamPtr.GetUUID(),
+		Duration:      time.Since(startTime).Milliseconds(),
+		Count:         batch.CountPut(),
+		MessageIds:    batch.MessageIds,
+		Partition:     batch.Partition,
+		SchemaVersion: schemaVersion,
+		Durability:    durability,
 	}
+	if duplicate {
+		response.SetDuplicateProducerBatch(batch)
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+	response.SetIngestPipelineReport(report)
 	return c.Status(fiber.StatusAccepted).JSON(response)
 }
 
+func getPartitionFromHeader(c *fiber.Ctx, streamPtr *stream.Stream) (*stream.Stream, *int) {
+	// returns the partition where to put the records (the stream itself if it is not partitioned)
+	if !streamPtr.IsPartitioned() {
+		return streamPtr, nil
+	}
+	partitionPtr, partition := streamPtr.GetPartitionForKey(c.Get("x-ministream-partition-key", ""))
+	return partitionPtr, &partition
+}
+
 func (w *WebAPIServer) GetStreamUUIDFromParameter(c *fiber.Ctx) (types.StreamUUID, *apierror.APIError) {
 	streamUuid, err := uuid.Parse(c.Params("streamuuid"))
 	if err != nil {
@@ -857,6 +1046,113 @@ func (w *WebAPIServer) RebuildIndex(c *fiber.Ctx) error {
 	return c.JSON(response)
 }
 
+// ScrubStream godoc
+// @Summary Scrub a stream
+// @Description Verify every record of the stream with its checksum and report the corrupted records (the report of a partitioned stream holds the report of each partition)
+// @ID stream-scrub
+// @Accept json
+// @Produce json
+// @Tags Stream
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Success 200 {object} stream.ScrubStreamResponse
+// @Success 500 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/scrub [post]
+func (w *WebAPIServer) ScrubStream(c *fiber.Ctx) error {
+	startTime := time.Now()
+
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	scrubReport, err := w.service.ScrubStream(streamPtr)
+	if err != nil {
+		httpError := apierror.APIError{
+			Message:    "cannot scrub stream",
+			Details:    err.Error(),
+			Code:       constants.ErrorCantScrubStream,
+			HttpCode:   fiber.StatusInternalServerError,
+			StreamUUID: streamPtr.GetUUID(),
+			Err:        err,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	account := account.AccountMgr.GetAccount()
+	log.Logger.Info(
+		"Stream scrubbed",
+		zap.String("topic", "stream"),
+		zap.String("method", "ScrubStream"),
+		zap.String("accountId", account.Id.String()),
+		zap.String("ipAddress", c.IP()),
+		zap.String("ipAddresses", strings.Join(c.IPs(), ";")),
+		zap.String("streamUUID", streamUUID.String()),
+	)
+
+	response := stream.ScrubStreamResponse{
+		Status:      "success",
+		Message:     "stream scrubbed",
+		StreamUUID:  streamUUID,
+		Duration:    time.Since(startTime).Milliseconds(),
+		ScrubReport: scrubReport,
+	}
+	return c.JSON(response)
+}
+
+// ReencryptStream godoc
+// @Summary Re-encrypt a stream
+// @Description Rewrite the records stored with a previous encryption key (or not encrypted) with the active key, or decrypt them when the encryption is disabled
+// @ID stream-reencrypt
+// @Accept json
+// @Produce json
+// @Tags Stream
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Success 200 {object} stream.ReencryptStreamResponse
+// @Success 500 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/reencrypt [post]
+func (w *WebAPIServer) ReencryptStream(c *fiber.Ctx) error {
+	startTime := time.Now()
+
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	cptRewritten, err := w.service.ReencryptStream(streamPtr)
+	if err != nil {
+		httpError := apierror.APIError{
+			Message:    "cannot re-encrypt stream",
+			Details:    err.Error(),
+			Code:       constants.ErrorCantReencryptStream,
+			HttpCode:   fiber.StatusInternalServerError,
+			StreamUUID: streamPtr.GetUUID(),
+			Err:        err,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	account := account.AccountMgr.GetAccount()
+	log.Logger.Info(
+		"Stream re-encrypted",
+		zap.String("topic", "stream"),
+		zap.String("method", "ReencryptStream"),
+		zap.String("accountId", account.Id.String()),
+		zap.String("ipAddress", c.IP()),
+		zap.String("ipAddresses", strings.Join(c.IPs(), ";")),
+		zap.String("streamUUID", streamUUID.String()),
+		zap.Uint64("records.rewritten", cptRewritten),
+	)
+
+	response := stream.ReencryptStreamResponse{
+		Status:              "success",
+		Message:             "stream re-encrypted",
+		StreamUUID:          streamUUID,
+		Duration:            time.Since(startTime).Milliseconds(),
+		CptRecordsRewritten: cptRewritten,
+	}
+	return c.JSON(response)
+}
+
 func convertToProperties(propertiesMap map[string]string) *types.StreamProperties {
 	properties := types.StreamProperties{}
 	for k, v := range propertiesMap {
@@ -905,3 +1201,93 @@ func convertStreamListToJsonResult(streams *[]*stream.Stream) *JSONResultListStr
 		},
 	}
 }
+
+func (w *WebAPIServer) GetMaxRecordsFromQuery(c *fiber.Ctx, streamUUID types.StreamUUID) (uint, *apierror.APIError) {
+	var maxRecords = w.appConfig.Streams.MaxMessagePerGetOperation
+	strMaxRecords := c.Query("maxRecords")
+	if strMaxRecords == "" {
+		return maxRecords, nil
+	}
+
+	maxRecordsRequested, err := strconv.ParseUint(strMaxRecords, 10, 0)
+	if err == nil {
+		switch v := maxRecordsRequested; {
+		case v == 0:
+			err = errors.New("value must be positive")
+		case v > uint64(maxRecords):
+			err = fmt.Errorf("value must cannot exceed limit %d", maxRecords)
+		}
+	}
+	if err != nil {
+		vErr := apierror.ValidationError{FailedField: "maxRecords", Tag: "parameter", Value: strMaxRecords}
+		return 0, &apierror.APIError{
+			StreamUUID:       streamUUID,
+			Message:          "invalid integer value",
+			Details:          err.Error(),
+			Code:             constants.ErrorInvalidParameterValue,
+			HttpCode:         fiber.StatusBadRequest,
+			ValidationErrors: []*apierror.ValidationError{&vErr},
+			Err:              err,
+		}
+	}
+
+	return uint(maxRecordsRequested), nil
+}
+
+func (w *WebAPIServer) GetPartitionFromQuery(c *fiber.Ctx, streamUUID types.StreamUUID) (*int, *apierror.APIError) {
+	// returns nil if the partition is not set
+	strPartition := c.Query("partition")
+	if strPartition == "" {
+		return nil, nil
+	}
+
+	partition, err := strconv.Atoi(strPartition)
+	if err != nil || partition < 0 {
+		vErr := apierror.ValidationError{FailedField: "partition", Tag: "parameter", Value: strPartition}
+		return nil, &apierror.APIError{
+			StreamUUID:       streamUUID,
+			Message:          "invalid partition",
+			Details:          "partition must be a positive integer",
+			Code:             constants.ErrorInvalidParameterValue,
+			HttpCode:         fiber.StatusBadRequest,
+			ValidationErrors: []*apierror.ValidationError{&vErr},
+		}
+	}
+
+	return &partition, nil
+}
+
+func errorInvalidStreamProperties(streamUUID types.StreamUUID, err error) *apierror.APIError {
+	// the properties configuring the stream (ingest pipeline, dead-letter stream, derived stream, backpressure, durability, retention) are checked before being set
+	code := constants.ErrorInvalidIngestPipeline
+	switch {
+	case errors.Is(err, types.ErrInvalidDeadLetterStream):
+		code = constants.ErrorInvalidDeadLetterStream
+	case errors.Is(err, types.ErrInvalidDerivedStream):
+		code = constants.ErrorInvalidDerivedStream
+	case errors.Is(err, types.ErrInvalidBackpressure):
+		code = constants.ErrorInvalidBackpressure
+	case errors.Is(err, types.ErrInvalidDurability):
+		code = constants.ErrorInvalidDurability
+	case errors.Is(err, types.ErrInvalidRetention):
+		code = constants.ErrorInvalidRetention
+	}
+	return &apierror.APIError{
+		Message:    "invalid stream properties",
+		Details:    err.Error(),
+		Code:       code,
+		HttpCode:   fiber.StatusBadRequest,
+		StreamUUID: streamUUID,
+		Err:        err,
+	}
+}
+
+func errorPutRecordsIntoDerivedStream(streamUUID types.StreamUUID) *apierror.APIError {
+	return &apierror.APIError{
+		Message:    "cannot put records into a derived stream",
+		Details:    "the records of a derived stream come from its source stream",
+		Code:       constants.ErrorCantPutRecordsIntoDerivedStream,
+		HttpCode:   fiber.StatusBadRequest,
+		StreamUUID: streamUUID,
+	}
+}
@@ -0,0 +1,231 @@
+package web
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/account"
+	"github.com/nbigot/ministream/config"
+	"github.com/nbigot/ministream/log"
+	"github.com/nbigot/ministream/service"
+	"github.com/nbigot/ministream/storageprovider/registry"
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMain(m *testing.M) {
+	if err := registry.SetupStorageProviders(); err != nil {
+		panic("error while setup storage providers:" + err.Error())
+	}
+	log.Logger = zap.NewNop()
+	// the handlers log the account of the requests
+	if err := account.AccountMgr.Initialize(log.Logger, &config.Account{}); err != nil {
+		panic("error while initializing account:" + err.Error())
+	}
+	os.Exit(m.Run())
+}
+
+func newTestWebAPIServer(t *testing.T) (*WebAPIServer, string) {
+	// the web server listens on a random local port, it is stopped at the end of the test
+	t.Helper()
+	conf := config.Config{}
+	if err := yaml.Unmarshal([]byte(`
+storage:
+    type: "InMemory"
+    inmemory:
+        maxRecordsByStream: 0
+        maxSize: "1gb"
+    logger:
+        level: "info"
+        encoding: "json"
+streams:
+    maxMessagePerGetOperation: 100
+`), &conf); err != nil {
+		t.Fatalf("error while parsing configuration: %v", err)
+	}
+
+	svc, err := service.NewStreamService(zap.NewNop(), &conf)
+	if err != nil {
+		t.Fatalf("error while creating service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("error while initializing service: %v", err)
+	}
+
+	w := NewWebAPIServer(&conf, fiber.Config{DisableStartupMessage: true}, svc, func() {}, func() {})
+	w.AddRoutes(w.GetFiberApp())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error while listening: %v", err)
+	}
+	go func() {
+		_ = w.GetFiberApp().Listener(ln)
+	}()
+	t.Cleanup(func() {
+		_ = w.GetFiberApp().Shutdown()
+		svc.Stop()
+	})
+	return w, ln.Addr().String()
+}
+
+func createTestStream(t *testing.T, w *WebAPIServer) *stream.Stream {
+	t.Helper()
+	s, err := w.service.CreateStream(&types.StreamProperties{})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	return s
+}
+
+func TestGetRecordsLongPollingClientDisconnect(t *testing.T) {
+	heartbeatPeriod := longPollingHeartbeatPeriod
+	longPollingHeartbeatPeriod = 50 * time.Millisecond
+	t.Cleanup(func() { longPollingHeartbeatPeriod = heartbeatPeriod })
+
+	w, addr := newTestWebAPIServer(t)
+	s := createTestStream(t, w)
+	req := types.StreamIteratorRequest{IteratorType: "AFTER_LAST_MESSAGE", MaxWaitTimeSeconds: 30}
+	itUUID, apiErr := w.service.CreateRecordsIterator(s, &req)
+	if apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr)
+	}
+	it, err := s.GetIterator(itUUID)
+	if err != nil {
+		t.Fatalf("iterator not found: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("error while connecting: %v", err)
+	}
+	fmt.Fprintf(conn, "GET /api/v1/stream/%s/iterator/%s/records HTTP/1.1\r\nHost: %s\r\n\r\n", s.GetUUID(), itUUID, addr)
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.Contains(status, "200") {
+		t.Fatalf("unexpected response %q (%v)", status, err)
+	}
+
+	// the client is waiting for records, the iterator is busy until the client disconnects
+	if it.TryAcquire() {
+		t.Fatalf("expected the iterator to be busy while long polling")
+	}
+	_ = conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !it.TryAcquire() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the wait has not ended once the client has disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	it.Release()
+}
+
+func TestGetRecordsLongPolling(t *testing.T) {
+	heartbeatPeriod := longPollingHeartbeatPeriod
+	longPollingHeartbeatPeriod = 50 * time.Millisecond
+	t.Cleanup(func() { longPollingHeartbeatPeriod = heartbeatPeriod })
+
+	w, addr := newTestWebAPIServer(t)
+	s := createTestStream(t, w)
+	req := types.StreamIteratorRequest{IteratorType: "AFTER_LAST_MESSAGE", MaxWaitTimeSeconds: 30}
+	itUUID, apiErr := w.service.CreateRecordsIterator(s, &req)
+	if apiErr != nil {
+		t.Fatalf("error while creating iterator: %v", apiErr)
+	}
+
+	go func() {
+		// the records are put while the client is waiting (heartbeats have been sent)
+		time.Sleep(200 * time.Millisecond)
+		_, _, _ = s.PutMessages(nil, []interface{}{map[string]interface{}{"n": 1}}, nil)
+	}()
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/api/v1/stream/%s/iterator/%s/records", addr, s.GetUUID(), itUUID))
+	if err != nil {
+		t.Fatalf("error while getting records: %v", err)
+	}
+	defer resp.Body.Close()
+	response := stream.GetStreamRecordsResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("error while decoding response: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK || response.Status != "success" || response.Count != 1 {
+		t.Fatalf("unexpected response %d %+v", resp.StatusCode, response)
+	}
+}
+
+func TestPutRecordsDurability(t *testing.T) {
+	w, addr := newTestWebAPIServer(t)
+	s := createTestStream(t, w)
+
+	// the records put are accepted whatever the durability level, the response tells how they were persisted
+	for _, level := range []string{types.DurabilityNone, types.DurabilityFlushed, types.DurabilityFsynced} {
+		req, err := http.NewRequest(fiber.MethodPut, fmt.Sprintf("http://%s/api/v1/stream/%s/records", addr, s.GetUUID()), strings.NewReader(`[{"n": 1}, {"n": 2}]`))
+		if err != nil {
+			t.Fatalf("error while creating request: %v", err)
+		}
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set("x-ministream-durability", level)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error while putting records: %v", err)
+		}
+		response := stream.PutStreamRecordsResponse{}
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatalf("error while decoding response: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusAccepted || response.Count != 2 || response.Durability != level {
+			t.Fatalf("unexpected response %d %+v for durability %s", resp.StatusCode, response, level)
+		}
+	}
+}
+
+func TestPutRecordsCount(t *testing.T) {
+	w, addr := newTestWebAPIServer(t)
+	s, err := w.service.CreateStream(&types.StreamProperties{"ingest.pipeline.1": "select(.n != 2) | .n += 1"})
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+
+	// the records dropped or rejected by the ingest pipeline are not counted
+	for _, put := range []struct {
+		path          string
+		body          string
+		expectedCount int64
+	}{
+		{"records", `[{"n": 1}, {"n": 2}, {"n": "3"}, {"n": 4}]`, 2},
+		{"record", `{"n": 2}`, 0},
+		{"record", `{"n": 1}`, 1},
+	} {
+		req, err := http.NewRequest(fiber.MethodPut, fmt.Sprintf("http://%s/api/v1/stream/%s/%s", addr, s.GetUUID(), put.path), strings.NewReader(put.body))
+		if err != nil {
+			t.Fatalf("error while creating request: %v", err)
+		}
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error while putting records: %v", err)
+		}
+		response := stream.PutStreamRecordsResponse{}
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatalf("error while decoding response: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusAccepted || response.Count != put.expectedCount {
+			t.Fatalf("expected %d records put by %s, got %d %+v", put.expectedCount, put.body, resp.StatusCode, response)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nbigot/ministream/constants"
+	"github.com/nbigot/ministream/log"
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+	"github.com/nbigot/ministream/web/apierror"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// Maximum duration of a long polling read while tailing a stream,
+// a heartbeat is sent to the client when no record has been read during this period.
+var tailStreamMaxWaitTimeSeconds = 15
+
+const localsTailStream = "tailStream"
+const localsTailIteratorUUID = "tailIteratorUUID"
+const localsTailMaxRecords = "tailMaxRecords"
+
+// TailStreamSSE godoc
+// @Summary Live tail a stream (Server-Sent Events)
+// @Description Keep the connection open and push the records as soon as they are saved.
+// @Description Each event contains a batch of records, the event id is the last record id read,
+// @Description therefore a client can resume with the Last-Event-ID header (or the messageId query parameter).
+// @Description The message ids are specific to each partition: tailing all the partitions of a stream, the events have no id and can't be resumed.
+// @ID stream-tail-sse
+// @Produce text/event-stream
+// @Tags Stream
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param iteratorType query string false "FIRST_MESSAGE, LAST_MESSAGE or AFTER_LAST_MESSAGE (default)"
+// @Param messageId query int false "resume after this message id (partitioned stream: the partition must be set)" example(1234)
+// @Param jq query string false "jq filter" example(.)
+// @Param maxRecords query int false "int max records per event" example(10)
+// @Param partition query int false "partitioned stream only: tail a single partition (all partitions if not set)" example(0)
+// @Param Last-Event-ID header int false "resume after this message id (partitioned stream: the partition must be set)"
+// @Success 200 {object} stream.GetStreamRecordsResponse "stream of events"
+// @Success 400 {object} apierror.APIError
+// @Success 500 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/tail/sse [get]
+func (w *WebAPIServer) TailStreamSSE(c *fiber.Ctx) error {
+	streamPtr, iteratorUUID, maxRecords, apiErr := w.createTailIterator(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	// the event ids are sent only if the client can resume with them
+	resumable := isTailResumable(streamPtr, c.Query("partition") != "")
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	// the request context must not be used once the handler has returned,
+	// only keep the channel closed when the web server is shutting down
+	serverDone := c.Context().Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Context().SetBodyStreamWriter(func(writer *bufio.Writer) {
+		defer cancel()
+		defer w.closeTailIterator(streamPtr, iteratorUUID)
+		go func() {
+			select {
+			case <-serverDone:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		// send a first heartbeat at once, the client knows the tail has started before the first event
+		fmt.Fprint(writer, ": heartbeat\n\n")
+		if err := writer.Flush(); err != nil {
+			w.logTailStreamEnd(streamPtr, iteratorUUID, "TailStreamSSE", err)
+			return
+		}
+		err := w.tailStream(ctx, streamPtr, iteratorUUID, maxRecords, func(response *stream.GetStreamRecordsResponse) error {
+			switch {
+			case response.Count > 0:
+				data, err := json.Marshal(response)
+				if err != nil {
+					return err
+				}
+				if resumable {
+					fmt.Fprintf(writer, "id: %d\n", response.LastRecordIdRead)
+				}
+				fmt.Fprintf(writer, "event: records\ndata: %s\n\n", data)
+			case resumable && response.LastRecordIdRead > 0:
+				// records may have been skipped by the jq filter, update the client position anyway
+				fmt.Fprintf(writer, "id: %d\n\n", response.LastRecordIdRead)
+			default:
+				fmt.Fprint(writer, ": heartbeat\n\n")
+			}
+			// an error here means the client has disconnected
+			return writer.Flush()
+		})
+		w.logTailStreamEnd(streamPtr, iteratorUUID, "TailStreamSSE", err)
+	})
+
+	return nil
+}
+
+// TailStreamWebSocketUpgrade godoc
+// @Summary Live tail a stream (WebSocket)
+// @Description Upgrade the connection to a WebSocket and push the records as soon as they are saved.
+// @Description Each message is a batch of records, resume with the messageId query parameter (last record id read).
+// @ID stream-tail-websocket
+// @Produce json
+// @Tags Stream
+// @Param streamuuid path string true "Stream UUID" Format(uuid.UUID)
+// @Param iteratorType query string false "FIRST_MESSAGE, LAST_MESSAGE or AFTER_LAST_MESSAGE (default)"
+// @Param messageId query int false "resume after this message id (partitioned stream: the partition must be set)" example(1234)
+// @Param jq query string false "jq filter" example(.)
+// @Param maxRecords query int false "int max records per message" example(10)
+// @Param partition query int false "partitioned stream only: tail a single partition (all partitions if not set)" example(0)
+// @Success 101 {object} stream.GetStreamRecordsResponse "stream of messages"
+// @Success 400 {object} apierror.APIError
+// @Success 426 {object} apierror.APIError
+// @Router /api/v1/stream/{streamuuid}/tail/ws [get]
+func (w *WebAPIServer) TailStreamWebSocketUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		httpError := apierror.APIError{
+			Message:  "websocket upgrade required",
+			Details:  "the request is not a websocket handshake",
+			Code:     constants.ErrorWebSocketUpgradeRequired,
+			HttpCode: fiber.StatusUpgradeRequired,
+		}
+		return httpError.HTTPResponse(c)
+	}
+
+	streamPtr, iteratorUUID, maxRecords, apiErr := w.createTailIterator(c)
+	if apiErr != nil {
+		return apiErr.HTTPResponse(c)
+	}
+
+	c.Locals(localsTailStream, streamPtr)
+	c.Locals(localsTailIteratorUUID, iteratorUUID)
+	c.Locals(localsTailMaxRecords, maxRecords)
+	return c.Next()
+}
+
+func (w *WebAPIServer) TailStreamWebSocket(conn *websocket.Conn) {
+	streamPtr := conn.Locals(localsTailStream).(*stream.Stream)
+	iteratorUUID := conn.Locals(localsTailIteratorUUID).(types.StreamIteratorUUID)
+	maxRecords := conn.Locals(localsTailMaxRecords).(uint)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer w.closeTailIterator(streamPtr, iteratorUUID)
+
+	go func() {
+		// messages sent by the client are ignored, stop as soon as the client disconnects
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	err := w.tailStream(ctx, streamPtr, iteratorUUID, maxRecords, func(response *stream.GetStreamRecordsResponse) error {
+		if response.Count == 0 {
+			return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second*time.Duration(tailStreamMaxWaitTimeSeconds)))
+		}
+		return conn.WriteJSON(response)
+	})
+	w.logTailStreamEnd(streamPtr, iteratorUUID, "TailStreamWebSocket", err)
+	_ = conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	)
+}
+
+func (w *WebAPIServer) createTailIterator(c *fiber.Ctx) (*stream.Stream, types.StreamIteratorUUID, uint, *apierror.APIError) {
+	streamUUID, streamPtr, apiErr := w.GetStreamFromParameter(c)
+	if apiErr != nil {
+		return nil, types.StreamIteratorUUID{}, 0, apiErr
+	}
+
+	maxRecords, apiErr := w.GetMaxRecordsFromQuery(c, streamUUID)
+	if apiErr != nil {
+		return nil, types.StreamIteratorUUID{}, 0, apiErr
+	}
+
+	req := types.StreamIteratorRequest{
+		IteratorType:       c.Query("iteratorType", "AFTER_LAST_MESSAGE"),
+		JqFilter:           c.Query("jq"),
+		MaxWaitTimeSeconds: tailStreamMaxWaitTimeSeconds,
+		Name:               "tail",
+	}
+	switch req.IteratorType {
+	case "FIRST_MESSAGE", "LAST_MESSAGE", "AFTER_LAST_MESSAGE":
+	default:
+		vErr := apierror.ValidationError{FailedField: "iteratorType", Tag: "parameter", Value: req.IteratorType}
+		return nil, types.StreamIteratorUUID{}, 0, &apierror.APIError{
+			StreamUUID:       streamUUID,
+			Message:          "invalid iterator type",
+			Details:          "iterator type must be FIRST_MESSAGE, LAST_MESSAGE or AFTER_LAST_MESSAGE",
+			Code:             constants.ErrorInvalidParameterValue,
+			HttpCode:         fiber.StatusBadRequest,
+			ValidationErrors: []*apierror.ValidationError{&vErr},
+		}
+	}
+
+	if req.Partition, apiErr = w.GetPartitionFromQuery(c, streamUUID); apiErr != nil {
+		return nil, types.StreamIteratorUUID{}, 0, apiErr
+	}
+
+	// resume right after the last message received by the client
+	paramName := "messageId"
+	strMessageId := c.Query(paramName)
+	if strMessageId == "" {
+		paramName = "Last-Event-ID"
+		strMessageId = c.Get(paramName)
+	}
+	if strMessageId != "" {
+		if !isTailResumable(streamPtr, req.Partition != nil) {
+			vErr := apierror.ValidationError{FailedField: paramName, Tag: "parameter", Value: strMessageId}
+			return nil, types.StreamIteratorUUID{}, 0, &apierror.APIError{
+				StreamUUID:       streamUUID,
+				Message:          "cannot resume tailing all the partitions",
+				Details:          "message ids are specific to each partition, set the partition parameter to resume after a message id",
+				Code:             constants.ErrorInvalidParameterValue,
+				HttpCode:         fiber.StatusBadRequest,
+				ValidationErrors: []*apierror.ValidationError{&vErr},
+			}
+		}
+		messageId, err := strconv.ParseUint(strMessageId, 10, 64)
+		if err != nil {
+			vErr := apierror.ValidationError{FailedField: paramName, Tag: "parameter", Value: strMessageId}
+			return nil, types.StreamIteratorUUID{}, 0, &apierror.APIError{
+				StreamUUID:       streamUUID,
+				Message:          "invalid integer value",
+				Details:          err.Error(),
+				Code:             constants.ErrorInvalidParameterValue,
+				HttpCode:         fiber.StatusBadRequest,
+				ValidationErrors: []*apierror.ValidationError{&vErr},
+				Err:              err,
+			}
+		}
+		if messageId > 0 {
+			req.IteratorType = "AFTER_MESSAGE_ID"
+			req.MessageId = messageId
+		}
+	}
+
+	iteratorUUID, apiErr := w.service.CreateRecordsIterator(streamPtr, &req)
+	if apiErr != nil {
+		return nil, types.StreamIteratorUUID{}, 0, apiErr
+	}
+
+	return streamPtr, iteratorUUID, maxRecords, nil
+}
+
+func isTailResumable(streamPtr *stream.Stream, tailPartition bool) bool {
+	// message ids are specific to each partition, therefore it can't resume when tailing all the partitions
+	return !streamPtr.IsPartitioned() || tailPartition
+}
+
+func (w *WebAPIServer) tailStream(ctx context.Context, streamPtr *stream.Stream, iteratorUUID types.StreamIteratorUUID, maxRecords uint, send func(response *stream.GetStreamRecordsResponse) error) error {
+	// read the records (long polling) and send them until the client disconnects or the stream stops
+	for {
+		response, err := streamPtr.GetRecords(ctx, iteratorUUID, maxRecords)
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err = send(response); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *WebAPIServer) closeTailIterator(streamPtr *stream.Stream, iteratorUUID types.StreamIteratorUUID) {
+	// the iterator may have already been closed (stream stopped or iterator expired)
+	_ = streamPtr.CloseIterator(iteratorUUID)
+}
+
+func (w *WebAPIServer) logTailStreamEnd(streamPtr *stream.Stream, iteratorUUID types.StreamIteratorUUID, method string, err error) {
+	log.Logger.Debug(
+		"Stop tailing stream",
+		zap.String("topic", "stream"),
+		zap.String("method", method),
+		zap.String("stream.uuid", streamPtr.GetUUID().String()),
+		zap.String("it.uuid", iteratorUUID.String()),
+		zap.NamedError("reason", err),
+	)
+}
@@ -0,0 +1,157 @@
+package web
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbigot/ministream/stream"
+	"github.com/nbigot/ministream/types"
+
+	"github.com/fasthttp/websocket"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func putTestRecords(t *testing.T, s *stream.Stream, count int) {
+	// the records are read by the tail once saved
+	t.Helper()
+	records := make([]interface{}, count)
+	for i := range records {
+		records[i] = map[string]interface{}{"n": i + 1}
+	}
+	if _, _, err := s.PutMessages(nil, records, nil); err != nil {
+		t.Fatalf("error while putting records: %v", err)
+	}
+}
+
+func waitForNoIterator(t *testing.T, s *stream.Stream) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.GetIteratorsCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the iterator has not been closed once the client has disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTailStreamSSEResume(t *testing.T) {
+	maxWaitTimeSeconds := tailStreamMaxWaitTimeSeconds
+	tailStreamMaxWaitTimeSeconds = 1
+	t.Cleanup(func() { tailStreamMaxWaitTimeSeconds = maxWaitTimeSeconds })
+
+	w, addr := newTestWebAPIServer(t)
+	s := createTestStream(t, w)
+	putTestRecords(t, s, 5)
+
+	// the client resumes right after the last event it has received
+	req, err := http.NewRequest(fiber.MethodGet, fmt.Sprintf("http://%s/api/v1/stream/%s/tail/sse", addr, s.GetUUID()), nil)
+	if err != nil {
+		t.Fatalf("error while creating request: %v", err)
+	}
+	req.Header.Set("Last-Event-ID", "3")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error while tailing stream: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	event := map[string]string{}
+	for event["data"] == "" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error while reading event: %v", err)
+		}
+		if name, value, found := strings.Cut(strings.TrimSuffix(line, "\n"), ": "); found {
+			event[name] = value
+		}
+	}
+	response := stream.GetStreamRecordsResponse{}
+	if err = json.Unmarshal([]byte(event["data"]), &response); err != nil {
+		t.Fatalf("error while decoding event %v: %v", event, err)
+	}
+	if event["id"] != "5" || event["event"] != "records" || response.Count != 2 {
+		t.Fatalf("expected the records 4 and 5, got %v", event)
+	}
+	for i, record := range response.Records {
+		if msgId := record.(map[string]interface{})["i"]; msgId != float64(4+i) {
+			t.Fatalf("expected record %d, got %v", 4+i, record)
+		}
+	}
+
+	// the iterator is closed once the client has disconnected
+	_ = resp.Body.Close()
+	waitForNoIterator(t, s)
+}
+
+func TestTailStreamWebSocketJq(t *testing.T) {
+	w, addr := newTestWebAPIServer(t)
+	s := createTestStream(t, w)
+	putTestRecords(t, s, 5)
+
+	query := url.Values{"iteratorType": {"FIRST_MESSAGE"}, "jq": {"select(.m.n > 3) | .m"}}
+	conn, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/api/v1/stream/%s/tail/ws?%s", addr, s.GetUUID(), query.Encode()), nil)
+	if err != nil {
+		t.Fatalf("error while tailing stream: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	// the records not matching the jq filter are skipped
+	messages := make([]interface{}, 0)
+	for len(messages) < 2 {
+		response := stream.GetStreamRecordsResponse{}
+		if err = conn.ReadJSON(&response); err != nil {
+			t.Fatalf("error while reading message: %v", err)
+		}
+		messages = append(messages, response.Records...)
+	}
+	if len(messages) != 2 || messages[0].(map[string]interface{})["n"] != float64(4) || messages[1].(map[string]interface{})["n"] != float64(5) {
+		t.Fatalf("expected the messages 4 and 5, got %v", messages)
+	}
+
+	// the iterator is closed once the client has disconnected
+	_ = conn.Close()
+	waitForNoIterator(t, s)
+}
+
+func TestTailPartitionedStreamResume(t *testing.T) {
+	w, addr := newTestWebAPIServer(t)
+	s, err := w.service.CreatePartitionedStream(&types.StreamProperties{}, 2)
+	if err != nil {
+		t.Fatalf("error while creating stream: %v", err)
+	}
+	tail := func(query string, lastEventId string) int {
+		req, err := http.NewRequest(fiber.MethodGet, fmt.Sprintf("http://%s/api/v1/stream/%s/tail/sse?%s", addr, s.GetUUID(), query), nil)
+		if err != nil {
+			t.Fatalf("error while creating request: %v", err)
+		}
+		if lastEventId != "" {
+			req.Header.Set("Last-Event-ID", lastEventId)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error while tailing stream: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// the message ids are specific to each partition, a message id can't be resumed on all the partitions
+	if status := tail("messageId=3", ""); status != fiber.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", fiber.StatusBadRequest, status)
+	}
+	if status := tail("", "3"); status != fiber.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", fiber.StatusBadRequest, status)
+	}
+	if status := tail("partition=1", "0"); status != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d", fiber.StatusOK, status)
+	}
+}
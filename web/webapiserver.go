@@ -3,6 +3,7 @@ package web
 import (
 	"time"
 
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/healthcheck"
 	"github.com/gofiber/fiber/v2/middleware/monitor"
@@ -51,18 +52,39 @@ func (w *WebAPIServer) AddRoutes(app *fiber.App) {
 
 	apiStream := api.Group("/stream", JWTProtected(), RateLimiterStreams(rateLimiterEnable, rateLimiterMaxRequests, rateDurationInSeconds))
 	apiStream.Get("/:streamuuid/iterator/:streamiteratoruuid/records", rbac.RBACProtected(enableRBAC, rbac.ActionGetRecords, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.GetRecords)
+	apiStream.Get("/:streamuuid/tail/sse", rbac.RBACProtected(enableRBAC, rbac.ActionGetRecords, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.TailStreamSSE)
+	apiStream.Get("/:streamuuid/tail/ws", rbac.RBACProtected(enableRBAC, rbac.ActionGetRecords, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.TailStreamWebSocketUpgrade, websocket.New(w.TailStreamWebSocket))
+	apiStream.Get("/:streamuuid/record/:messageid", rbac.RBACProtected(enableRBAC, rbac.ActionGetRecords, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.GetRecord)
+	apiStream.Get("/:streamuuid/records", rbac.RBACProtected(enableRBAC, rbac.ActionGetRecords, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.GetRecordsByIds)
+	apiStream.Post("/:streamuuid/query", rbac.RBACProtected(enableRBAC, rbac.ActionQueryRecords, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.QueryStream)
+	apiStream.Get("/:streamuuid/export", rbac.RBACProtected(enableRBAC, rbac.ActionExportStream, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.ExportStream)
+	apiStream.Post("/import", rbac.RBACProtected(enableRBAC, rbac.ActionImportStream, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.ImportStream)
+	apiStream.Post("/:streamuuid/clone", rbac.RBACProtected(enableRBAC, rbac.ActionCloneStream, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.CloneStream)
 	apiStream.Put("/:streamuuid/records", rbac.RBACProtected(enableRBAC, rbac.ActionPutRecords, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.PutRecords)
 	apiStream.Put("/:streamuuid/record", rbac.RBACProtected(enableRBAC, rbac.ActionPutRecord, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.PutRecord)
 	apiStream.Post("/:streamuuid/iterator", rbac.RBACProtected(enableRBAC, rbac.ActionCreateRecordsIterator, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.CreateRecordsIterator)
 	apiStream.Get("/:streamuuid/iterator/:streamiteratoruuid/stats", rbac.RBACProtected(enableRBAC, rbac.ActionGetRecordsIteratorStats, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.GetRecordsIteratorStats)
 	apiStream.Delete("/:streamuuid/iterator/:streamiteratoruuid", rbac.RBACProtected(enableRBAC, rbac.ActionCloseRecordsIterator, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.CloseRecordsIterator)
 	apiStream.Get("/:streamuuid", rbac.RBACProtected(enableRBAC, rbac.ActionGetStreamDescription, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.GetStreamInformation)
+	apiStream.Get("/:streamuuid/ingest/stats", rbac.RBACProtected(enableRBAC, rbac.ActionGetStreamDescription, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.GetStreamIngestStats)
 	apiStream.Get("/:streamuuid/properties", rbac.RBACProtected(enableRBAC, rbac.ActionGetStreamProperties, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.GetStreamProperties)
 	apiStream.Post("/:streamuuid/properties", rbac.RBACProtected(enableRBAC, rbac.ActionSetStreamProperties, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.SetStreamProperties)
 	apiStream.Patch("/:streamuuid/properties", rbac.RBACProtected(enableRBAC, rbac.ActionUpdateStreamProperties, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.UpdateStreamProperties)
 	apiStream.Post("/", rbac.RBACProtected(enableRBAC, rbac.ActionCreateStream, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.CreateStream)
 	apiStream.Delete("/:streamuuid", rbac.RBACProtected(enableRBAC, rbac.ActionDeleteStream, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.DeleteStream)
+	apiStream.Post("/:streamuuid/iterator/:streamiteratoruuid/commit", rbac.RBACProtected(enableRBAC, rbac.ActionCommitConsumerGroup, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.CommitRecordsIterator)
+	apiStream.Get("/:streamuuid/consumergroups", rbac.RBACProtected(enableRBAC, rbac.ActionListConsumerGroups, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.ListConsumerGroups)
+	apiStream.Get("/:streamuuid/consumergroup/:consumergroup", rbac.RBACProtected(enableRBAC, rbac.ActionGetConsumerGroup, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.GetConsumerGroup)
+	apiStream.Post("/:streamuuid/consumergroup/:consumergroup/commit", rbac.RBACProtected(enableRBAC, rbac.ActionCommitConsumerGroup, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.CommitConsumerGroup)
+	apiStream.Post("/:streamuuid/consumergroup/:consumergroup/reset", rbac.RBACProtected(enableRBAC, rbac.ActionResetConsumerGroup, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.ResetConsumerGroup)
+	apiStream.Delete("/:streamuuid/consumergroup/:consumergroup", rbac.RBACProtected(enableRBAC, rbac.ActionDeleteConsumerGroup, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.DeleteConsumerGroup)
+	apiStream.Get("/:streamuuid/schemas", rbac.RBACProtected(enableRBAC, rbac.ActionListStreamSchemas, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.ListStreamSchemas)
+	apiStream.Post("/:streamuuid/schemas", rbac.RBACProtected(enableRBAC, rbac.ActionRegisterStreamSchema, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.RegisterStreamSchema)
+	apiStream.Delete("/:streamuuid/schemas", rbac.RBACProtected(enableRBAC, rbac.ActionDeleteStreamSchemas, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.DeleteStreamSchemas)
+	apiStream.Get("/:streamuuid/schema/:version", rbac.RBACProtected(enableRBAC, rbac.ActionGetStreamSchema, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.GetStreamSchema)
 	apiStream.Post("/:streamuuid/index/rebuild", rbac.RBACProtected(enableRBAC, rbac.ActionRebuildIndex, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.RebuildIndex)
+	apiStream.Post("/:streamuuid/scrub", rbac.RBACProtected(enableRBAC, rbac.ActionScrubStream, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.ScrubStream)
+	apiStream.Post("/:streamuuid/reencrypt", rbac.RBACProtected(enableRBAC, rbac.ActionReencryptStream, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.ReencryptStream)
 
 	apiStreams := api.Group("/streams", JWTProtected(), RateLimiterStreams(rateLimiterEnable, rateLimiterMaxRequests, rateDurationInSeconds))
 	apiStreams.Get("/", rbac.RBACProtected(enableRBAC, rbac.ActionListStreams, nil, auditlogRBACHandlerLogAccessGranted, auditlogRBACHandlerLogAccessDeny), w.ListStreams)